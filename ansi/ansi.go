@@ -0,0 +1,213 @@
+// Package ansi handles the ANSI escape sequences that servers use to color their console
+// output, either removing them or converting them into a structured form that can be
+// rendered without a terminal emulator.
+package ansi
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the escape sequences that can appear in console output. Only SGR sequences,
+// which end in "m", affect the style of the text; the rest are removed.
+var sequence = regexp.MustCompile(`\x1b\[([0-9;?]*)[ -/]*([@-~])`)
+
+// The names of the standard colors, in the order of their codes.
+var colors = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// Removes every ANSI escape sequence from the string.
+func Strip(s string) string {
+	if !strings.Contains(s, "\x1b") {
+		return s
+	}
+
+	return sequence.ReplaceAllString(s, "")
+}
+
+// A run of text that is all rendered with the same style. Colors are either the name of
+// a standard color, such as "red" or "bright_red", or a hex color for extended colors.
+type Segment struct {
+	Text       string `json:"text"`
+	Foreground string `json:"fg,omitempty"`
+	Background string `json:"bg,omitempty"`
+	Bold       bool   `json:"bold,omitempty"`
+	Dim        bool   `json:"dim,omitempty"`
+	Italic     bool   `json:"italic,omitempty"`
+	Underline  bool   `json:"underline,omitempty"`
+}
+
+// Splits the string into segments of text with the style applied to them by the SGR
+// sequences within it. Any other escape sequences are removed.
+func Parse(s string) []Segment {
+	var out []Segment
+	var style Segment
+
+	appendText := func(text string) {
+		if text == "" {
+			return
+		}
+
+		// Merge the text into the previous segment when a sequence did not actually
+		// change the style.
+		if l := len(out); l > 0 {
+			prev := out[l-1]
+			prev.Text = ""
+			if prev == style {
+				out[l-1].Text += text
+				return
+			}
+		}
+
+		seg := style
+		seg.Text = text
+		out = append(out, seg)
+	}
+
+	last := 0
+	for _, m := range sequence.FindAllStringSubmatchIndex(s, -1) {
+		appendText(s[last:m[0]])
+		last = m[1]
+
+		if s[m[4]:m[5]] == "m" {
+			style = applySgr(style, s[m[2]:m[3]])
+		}
+	}
+
+	appendText(s[last:])
+
+	if out == nil {
+		out = []Segment{}
+	}
+
+	return out
+}
+
+// Applies the parameters of an SGR sequence to the style.
+func applySgr(style Segment, params string) Segment {
+	codes := strings.Split(params, ";")
+
+	for i := 0; i < len(codes); i++ {
+		// An empty parameter, such as in "\x1b[m", is the same as a reset.
+		code, _ := strconv.Atoi(codes[i])
+
+		switch {
+		case code == 0:
+			style = Segment{}
+		case code == 1:
+			style.Bold = true
+		case code == 2:
+			style.Dim = true
+		case code == 3:
+			style.Italic = true
+		case code == 4:
+			style.Underline = true
+		case code == 22:
+			style.Bold, style.Dim = false, false
+		case code == 23:
+			style.Italic = false
+		case code == 24:
+			style.Underline = false
+		case code >= 30 && code <= 37:
+			style.Foreground = colors[code-30]
+		case code >= 90 && code <= 97:
+			style.Foreground = "bright_" + colors[code-90]
+		case code == 39:
+			style.Foreground = ""
+		case code >= 40 && code <= 47:
+			style.Background = colors[code-40]
+		case code >= 100 && code <= 107:
+			style.Background = "bright_" + colors[code-100]
+		case code == 49:
+			style.Background = ""
+		case code == 38 || code == 48:
+			c, n := extendedColor(codes[i+1:])
+			i += n
+
+			if code == 38 {
+				style.Foreground = c
+			} else {
+				style.Background = c
+			}
+		}
+	}
+
+	return style
+}
+
+// Parses an extended color following a 38 or 48 code, which is either "5;n" for a color
+// from the 256 color palette, or "2;r;g;b" for a true color. Returns the color and the
+// number of parameters that were used by it.
+func extendedColor(params []string) (string, int) {
+	if len(params) == 0 {
+		return "", 0
+	}
+
+	switch params[0] {
+	case "5":
+		if len(params) < 2 {
+			return "", len(params)
+		}
+
+		n, _ := strconv.Atoi(params[1])
+
+		return paletteColor(n), 2
+	case "2":
+		if len(params) < 4 {
+			return "", len(params)
+		}
+
+		var rgb [3]int
+		for i := range rgb {
+			rgb[i], _ = strconv.Atoi(params[i+1])
+		}
+
+		return hex(rgb[0], rgb[1], rgb[2]), 4
+	}
+
+	return "", 1
+}
+
+// Returns the color for an entry in the 256 color palette. The first 16 entries are the
+// standard and bright colors, followed by a 6x6x6 color cube and then a grayscale ramp.
+func paletteColor(n int) string {
+	switch {
+	case n < 0 || n > 255:
+		return ""
+	case n < 8:
+		return colors[n]
+	case n < 16:
+		return "bright_" + colors[n-8]
+	case n < 232:
+		n -= 16
+
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+
+			return 55 + v*40
+		}
+
+		return hex(level(n/36), level(n/6%6), level(n%6))
+	}
+
+	v := 8 + (n-232)*10
+
+	return hex(v, v, v)
+}
+
+func hex(r, g, b int) string {
+	clamp := func(v int) int {
+		if v < 0 {
+			return 0
+		} else if v > 255 {
+			return 255
+		}
+
+		return v
+	}
+
+	return fmt.Sprintf("#%02x%02x%02x", clamp(r), clamp(g), clamp(b))
+}
@@ -26,6 +26,17 @@ type ServerConfigurationResponse struct {
 	ProcessConfiguration *ProcessConfiguration `json:"process_configuration"`
 }
 
+// Defines a single step in the sequence used to stop a server.
+type StopStep struct {
+	// Either "command" to send the value to the console, or "signal" to send the signal
+	// named by the value, such as "SIGINT", to the process.
+	Type  string `json:"type"`
+	Value string `json:"value"`
+	// The number of seconds to wait after the step before running the next one. If the
+	// server stops while waiting the remaining steps are skipped.
+	Wait int `json:"wait"`
+}
+
 // Defines the process configuration for a given server instance. This sets what the
 // daemon is looking for to mark a server as done starting, what to do when stopping,
 // and what changes to make to the configuration file for a server.
@@ -33,12 +44,200 @@ type ProcessConfiguration struct {
 	Startup struct {
 		Done            string   `json:"done"`
 		UserInteraction []string `json:"userInteraction"`
+		// Defines when a running server is ready to accept players. This is separate
+		// from the done line since many servers print that before they are listening.
+		Readiness struct {
+			// A regular expression matched against the console output of the server.
+			Pattern string `json:"pattern"`
+			// A port that must accept TCP connections. If not set the default allocation
+			// is used when no pattern is defined either.
+			Port int `json:"port"`
+		} `json:"readiness"`
 	} `json:"startup"`
 	Stop struct {
 		Type  string `json:"type"`
 		Value string `json:"value"`
+		// An ordered sequence of steps used to stop the server, replacing the single
+		// command or signal above when defined.
+		Sequence []StopStep `json:"sequence"`
 	} `json:"stop"`
 	ConfigurationFiles []parser.ConfigurationFile `json:"configs"`
+	HealthCheck        HealthCheck                `json:"health_check"`
+	OfflineResponder   OfflineResponder           `json:"offline_responder"`
+	Integrity          IntegrityMonitor           `json:"integrity"`
+	Rcon               Rcon                       `json:"rcon"`
+	Query              Query                      `json:"query"`
+	Redactions         []RedactionRule            `json:"redactions"`
+	FileRules          []FileRule                 `json:"file_rules"`
+	Backup             BackupCoordination         `json:"backup"`
+	PowerActions       []PowerAction              `json:"power_actions"`
+}
+
+// Defines the console commands sent to a running server around a backup, so that the
+// game writes its state to the disk and stops modifying it while the files are archived.
+// For example a Minecraft server would run "save-off" and "save-all flush" before the
+// backup, and "save-on" after it.
+type BackupCoordination struct {
+	// The commands run in order before the data is archived.
+	Pre []BackupCommand `json:"pre"`
+	// The commands run in order once the archive has been written, whether or not the
+	// backup was successful.
+	Post []BackupCommand `json:"post"`
+}
+
+// A single console command sent to the server around a backup.
+type BackupCommand struct {
+	Command string `json:"command"`
+	// A regular expression matched against the console output of the server, such as
+	// "Saved the game", that signals the command has finished. If not set the daemon
+	// simply waits for the timeout before continuing.
+	Pattern string `json:"pattern"`
+	// The number of seconds to wait for the pattern to be matched, or to wait after
+	// sending the command when there is no pattern. Defaults to 30 seconds when a
+	// pattern is defined.
+	Timeout int `json:"timeout"`
+	// If set for a pre-backup command the backup is cancelled when the pattern is not
+	// matched within the timeout, rather than continuing with a possibly inconsistent
+	// copy of the data.
+	Required bool `json:"required"`
+}
+
+// The protocols that can be used to query a running server for its player count.
+const (
+	QueryMinecraft = "minecraft"
+	QuerySource    = "source"
+	QueryFiveM     = "fivem"
+)
+
+// Defines how the daemon queries a running server for the number of players connected
+// to it and the map being played.
+type Query struct {
+	// The protocol used to query the server. If empty the server is not queried.
+	Protocol string `json:"protocol"`
+	// The port to query, defaults to the port of the default allocation.
+	Port int `json:"port"`
+	// The number of seconds between each query, defaults to 15 seconds.
+	Interval int `json:"interval"`
+}
+
+// A regular expression matched against the console output of a server, with any matches
+// replaced before the output leaves the daemon. The replacement can refer to groups in the
+// pattern, such as "$1". If no replacement is given the matches are replaced with
+// "[REDACTED]".
+type RedactionRule struct {
+	Pattern     string `json:"pattern"`
+	Replacement string `json:"replacement"`
+}
+
+// An additional power action defined by an egg, such as "reload" or "save", that runs a
+// sequence of console commands or signals against the running server instead of stopping
+// it. Each step is the same as a step of the stop sequence.
+type PowerAction struct {
+	// The name used for the action in the power API, which cannot be one of the actions
+	// built into the daemon.
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Steps       []StopStep `json:"steps"`
+	// The permission a websocket token must grant to run the action. Defaults to
+	// "power.<name>".
+	Permission string `json:"permission"`
+}
+
+// Restricts what can be done to the files of a server matching a pattern, such as "*.jar"
+// to prevent plugins from being uploaded or "server.properties" to protect the file from
+// being deleted. Patterns without a slash are matched against the name of the file and
+// of each directory containing it, other patterns are matched against the path of the
+// file from the root of the server. If no actions are given every action is denied.
+type FileRule struct {
+	Pattern string `json:"pattern"`
+	// Any of "write", "delete" or "execute".
+	Actions []string `json:"actions"`
+	// Allows the actions instead of denying them, for making exceptions to an earlier rule.
+	Allow bool `json:"allow"`
+}
+
+// Defines how the daemon connects to the RCON server of the server process, so that
+// commands sent through the API are able to return the response from the game.
+type Rcon struct {
+	// The environment variables of the server containing the RCON port and password,
+	// such as "RCON_PORT" and "RCON_PASSWORD". If the password variable is empty RCON
+	// is not used.
+	PortVariable     string `json:"port_variable"`
+	PasswordVariable string `json:"password_variable"`
+	// The port used when no port variable is defined, or it is not set.
+	Port int `json:"port"`
+}
+
+// Defines the critical files of a server, such as the server jar or an anti-cheat binary,
+// that are checked against a known good baseline to detect unexpected modifications.
+type IntegrityMonitor struct {
+	// The files to monitor, relative to the server data directory. Glob patterns such
+	// as "plugins/*.jar" are supported. If empty no monitoring is performed.
+	Files []string `json:"files"`
+	// The number of seconds between periodic checks while the daemon is running. Files
+	// are always checked before the server starts, if 0 that is the only check.
+	Interval int `json:"interval"`
+	// If set the server is not allowed to start while a monitored file does not match
+	// the baseline.
+	BlockStart bool `json:"block_start"`
+}
+
+// The protocols that the offline responder is able to answer status requests for. The
+// TCP responder does not answer any requests, it only holds the allocation so that the
+// server can be started when a client connects to it.
+const (
+	OfflineResponderMinecraft = "minecraft"
+	OfflineResponderTcp       = "tcp"
+)
+
+// Defines how the daemon answers status requests sent to the default allocation of the
+// server while the server process is not running, so that players see a useful message
+// rather than the server being unreachable.
+type OfflineResponder struct {
+	// The protocol used to answer status requests. If empty no responder is run.
+	Protocol string `json:"protocol"`
+	// The message shown in the server list while the server is offline, and while it is
+	// starting.
+	Motd         string `json:"motd"`
+	StartingMotd string `json:"starting_motd"`
+	// The version name shown in the server list.
+	Version string `json:"version"`
+	// The message shown to players that attempt to join the server.
+	KickMessage string `json:"kick_message"`
+	// If set the server is started when a player attempts to join it.
+	WakeOnJoin bool `json:"wake_on_join"`
+}
+
+// The types of health check an egg can define for its server process.
+const (
+	HealthCheckTcp       = "tcp"
+	HealthCheckCommand   = "command"
+	HealthCheckHeartbeat = "heartbeat"
+)
+
+// Defines how the daemon checks that a running server process is still responding. A
+// process can be running but hung, which is not caught by watching for it to exit.
+type HealthCheck struct {
+	// The type of check, either "tcp", "command" or "heartbeat". If empty no health
+	// checks are performed.
+	Type string `json:"type"`
+	// The port checked by a "tcp" check. If not set the default allocation is used.
+	Port int `json:"port"`
+	// The command executed in the container by a "command" check, which must exit with
+	// a status code of 0 for the check to pass.
+	Command []string `json:"command"`
+	// The console output that a "heartbeat" check expects to see at least once every
+	// interval.
+	Pattern string `json:"pattern"`
+	// The number of seconds between each check, and the number of seconds to wait for
+	// a single check to complete.
+	Interval int `json:"interval"`
+	Timeout  int `json:"timeout"`
+	// The number of consecutive failed checks before the server is marked as unhealthy.
+	Retries int `json:"retries"`
+	// The number of consecutive failed checks after which the server is restarted. If
+	// set to 0 the server is never restarted automatically.
+	RestartAfter int `json:"restart_after"`
 }
 
 // Defines installation script information for a server process. This is used when
@@ -47,6 +246,73 @@ type InstallationScript struct {
 	ContainerImage string `json:"container_image"`
 	Entrypoint     string `json:"entrypoint"`
 	Script         string `json:"script"`
+
+	// The interpreter used to execute the script, such as "bash", "python", or "pwsh".
+	// If this is empty the entrypoint is used to run the script directly.
+	Interpreter string `json:"interpreter"`
+
+	// The digest the installation image is pinned to, and the policy controlling when
+	// the image is pulled. If no pull policy is provided the node default is used.
+	ContainerImageDigest string `json:"container_image_digest"`
+	PullPolicy           string `json:"pull_policy"`
+
+	// The individual steps that make up the installation process. If any steps are
+	// defined they are run in order instead of the script above, allowing a failed
+	// installation to be resumed from the step that failed.
+	Steps []InstallationStep `json:"steps"`
+
+	// The base64 encoded Ed25519 signature of the signing payload for this script,
+	// created by the publisher of the egg.
+	Signature string `json:"signature"`
+}
+
+// Returns the payload that is signed by the publisher of an installation script. This is
+// the JSON encoding of every field that affects what is executed during the install, in
+// the order they are defined below.
+func (s *InstallationScript) SigningPayload() []byte {
+	b, _ := json.Marshal(struct {
+		ContainerImage string             `json:"container_image"`
+		Entrypoint     string             `json:"entrypoint"`
+		Interpreter    string             `json:"interpreter"`
+		Script         string             `json:"script"`
+		Steps          []InstallationStep `json:"steps"`
+	}{s.ContainerImage, s.Entrypoint, s.Interpreter, s.Script, s.Steps})
+
+	return b
+}
+
+// Defines a single step of a step based installation process.
+type InstallationStep struct {
+	// A unique name for the step, used when reporting progress and tracking the
+	// checkpoints for the installation.
+	Name string `json:"name"`
+
+	// The type of step, one of "download", "extract", "script", or "verify".
+	Type string `json:"type"`
+
+	// The number of additional times the step is attempted if it fails.
+	Retries int `json:"retries"`
+
+	// For download steps, the URL to download and the path within the server data
+	// directory to save it to. If a SHA-256 checksum is provided the download will be
+	// verified and cached on the node for future installations.
+	Url      string `json:"url,omitempty"`
+	Checksum string `json:"checksum,omitempty"`
+
+	// For extract steps, the archive to extract and the directory to extract it into. For
+	// download steps, the destination of the downloaded file.
+	Source      string `json:"source,omitempty"`
+	Destination string `json:"destination,omitempty"`
+
+	// For script steps, the script to run along with the container image and interpreter
+	// to use. If not set the values from the installation script are used.
+	Script         string `json:"script,omitempty"`
+	ContainerImage string `json:"container_image,omitempty"`
+	Interpreter    string `json:"interpreter,omitempty"`
+
+	// For verify steps, the files that must exist in the server data directory once
+	// the installation is complete, optionally mapped to their expected SHA-256 checksum.
+	Files map[string]string `json:"files,omitempty"`
 }
 
 // Fetches the server configuration and returns the struct for it.
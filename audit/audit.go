@@ -0,0 +1,307 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Defines all of the actions that are recorded in the audit log.
+const (
+	PowerAction     = "server:power"
+	ConsoleCommand  = "server:console.command"
+	FileWrite       = "server:file.write"
+	FileRename      = "server:file.rename"
+	FileCopy        = "server:file.copy"
+	FileDelete      = "server:file.delete"
+	FileRestore     = "server:file.restore"
+	TrashDelete     = "server:trash.delete"
+	FileCompress    = "server:file.compress"
+	FileDecompress  = "server:file.decompress"
+	FilePull        = "server:file.pull"
+	FileDownload    = "server:file.download"
+	DirectoryCreate = "server:file.create-directory"
+	ServerCreate    = "server:create"
+	ServerInstall   = "server:install"
+	ServerUpdate    = "server:update"
+	ServerDelete    = "server:delete"
+	TemplateCreate  = "server:template.create"
+	TemplateApply   = "server:template.apply"
+	TemplateDelete  = "template:delete"
+	SftpLogin       = "sftp:login"
+	SftpLoginFailed = "sftp:login.failed"
+	NodeDrain       = "node:drain"
+	NodeDrainCancel = "node:drain.cancel"
+	ProfileActivate = "server:profile.activate"
+	ProfileUpdate   = "server:profile.update"
+	ScheduleUpdate  = "server:schedule.update"
+	ScheduleDelete  = "server:schedule.delete"
+	ScheduleRun     = "server:schedule.run"
+	IntegrityReset  = "server:integrity.baseline"
+	StorageMigrate  = "server:storage.migrate"
+	NodeBulk        = "node:bulk"
+	NodeBulkCancel  = "node:bulk.cancel"
+	SnapshotCreate  = "server:snapshot.create"
+	SnapshotDelete  = "server:snapshot.delete"
+	ConsoleFormat   = "server:console.transforms"
+	NodeReconcile   = "node:containers.reconcile"
+	NodeEggSync     = "node:eggs.sync"
+	ServerDebug     = "server:debug"
+	BackupCreate    = "server:backup.create"
+	BackupDelete    = "server:backup.delete"
+)
+
+// The actor used for requests that are authenticated using the node's global token,
+// which is only ever used by the Panel.
+const PanelActor = "panel"
+
+// A single entry in the audit log.
+type Entry struct {
+	Time     time.Time         `json:"time"`
+	Action   string            `json:"action"`
+	Actor    string            `json:"actor"`
+	Server   string            `json:"server,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+var mu sync.Mutex
+
+// The number of entries matching a query within each compacted segment, keyed by the
+// path of the segment and the filters of the query. Segments never change once written,
+// so their counts only need to be found once.
+var segmentCounts = make(map[string]int)
+
+// Appends an entry to the audit log. Failures are logged but never bubbled up since an
+// audit failure should not block the action that was being performed.
+func Log(action string, actor string, server string, metadata map[string]string) {
+	cfg := config.Get().System.AuditLog
+	if !cfg.Enabled {
+		return
+	}
+
+	e := Entry{
+		Time:     time.Now().UTC(),
+		Action:   action,
+		Actor:    actor,
+		Server:   server,
+		Metadata: metadata,
+	}
+
+	if err := write(cfg.Path, e); err != nil {
+		zap.S().Warnw("failed to write entry to audit log", zap.String("action", action), zap.String("server", server), zap.Error(err))
+	}
+}
+
+// Writes the entry to the end of the audit log file, creating the file if it does not
+// exist yet. The file is only ever opened in append mode.
+func write(p string, e Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Defines the filtering and pagination options available when querying the log.
+type Query struct {
+	Server  string
+	Action  string
+	Page    int
+	PerPage int
+}
+
+// Returns the query with the page and number of entries per page limited to the values
+// that can be requested.
+func (q Query) Normalize() Query {
+	if q.Page < 1 {
+		q.Page = 1
+	}
+
+	if q.PerPage < 1 || q.PerPage > 500 {
+		q.PerPage = 50
+	}
+
+	return q
+}
+
+// Returns a single page of audit entries matching the query, ordered from newest to
+// oldest, along with the total number of matching entries. Only the files containing the
+// entries on the page are decoded in full, the others are only counted.
+func Read(q Query) ([]Entry, int, error) {
+	q = q.Normalize()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	// The compacted segments are ordered from oldest to newest, followed by the active
+	// log which contains the newest entries of all.
+	p := config.Get().System.AuditLog.Path
+	files := append(segments(p), p)
+
+	total := 0
+	counts := make([]int, len(files))
+	for i, f := range files {
+		n, err := countMatches(f, q, f != p)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		counts[i] = n
+		total += n
+	}
+
+	out := make([]Entry, 0, q.PerPage)
+	skip := (q.Page - 1) * q.PerPage
+
+	for i := len(files) - 1; i >= 0 && len(out) < q.PerPage; i-- {
+		n := counts[i]
+		if skip >= n {
+			skip -= n
+			continue
+		}
+
+		// The entries on the page are the newest matches in the file that have not been
+		// skipped, so work out where they start when reading the file from the beginning.
+		take := n - skip
+		if remaining := q.PerPage - len(out); take > remaining {
+			take = remaining
+		}
+
+		entries, err := readMatches(files[i], q, n-skip-take, n-skip)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		for j := len(entries) - 1; j >= 0; j-- {
+			out = append(out, entries[j])
+		}
+
+		skip = 0
+	}
+
+	return out, total, nil
+}
+
+// Returns the number of entries in the file matching the query. The count is cached if
+// the file is a compacted segment.
+func countMatches(p string, q Query, cache bool) (int, error) {
+	key := p + "\x00" + q.Server + "\x00" + q.Action
+	if n, ok := segmentCounts[key]; ok && cache {
+		return n, nil
+	}
+
+	n := 0
+	err := eachEntry(p, q, func(Entry) {
+		n++
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if cache {
+		// Filtering on arbitrary servers and actions could grow the cache forever, so it
+		// is started again once it becomes large.
+		if len(segmentCounts) >= 10000 {
+			segmentCounts = make(map[string]int)
+		}
+
+		segmentCounts[key] = n
+	}
+
+	return n, nil
+}
+
+// Returns the entries matching the query in the file from the match at index from up to,
+// but not including, the match at index to.
+func readMatches(p string, q Query, from int, to int) ([]Entry, error) {
+	out := make([]Entry, 0, to-from)
+
+	i := 0
+	err := eachEntry(p, q, func(e Entry) {
+		if i >= from && i < to {
+			out = append(out, e)
+		}
+		i++
+	})
+
+	return out, err
+}
+
+// Calls the function for every entry in the file matching the query, from oldest to
+// newest. Files that do not exist are skipped.
+func eachEntry(p string, q Query, fn func(e Entry)) error {
+	f, err := openSegment(p)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil
+		}
+
+		return err
+	}
+	defer f.Close()
+
+	return eachLine(f, func(line []byte) error {
+		var e Entry
+		// Skip over any lines that cannot be parsed, this would generally only happen
+		// if the daemon was killed part way through a write.
+		if err := json.Unmarshal(line, &e); err != nil {
+			return nil
+		}
+
+		if (q.Server != "" && e.Server != q.Server) || (q.Action != "" && e.Action != q.Action) {
+			return nil
+		}
+
+		fn(e)
+
+		return nil
+	})
+}
+
+// Calls the function for every line read from the reader, without the trailing newline.
+// Lines are read in full whatever their length, unlike with a bufio.Scanner which fails
+// on lines longer than its buffer.
+func eachLine(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReader(r)
+
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if ferr := fn(bytes.TrimSuffix(line, []byte("\n"))); ferr != nil {
+				return ferr
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
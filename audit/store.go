@@ -0,0 +1,336 @@
+package audit
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The interval at which the audit log is checked to see if it needs to be compacted.
+const compactionInterval = time.Hour
+
+// The format of the time a segment was created, which follows the path of the audit log in
+// the name of the segment. The time is down to the nanosecond so that compacting the log
+// twice within the same second does not try to create the same segment again.
+const segmentTimeFormat = "20060102T150405.000000000"
+
+// Defines a compression format that compacted segments of the audit log can be
+// written in. The extension is used to determine how to read a segment back.
+type Codec struct {
+	Extension string
+	Writer    func(w io.Writer) io.WriteCloser
+	Reader    func(r io.Reader) (io.ReadCloser, error)
+}
+
+// The codecs that can be used for compacted segments, keyed by the name used in the
+// configuration. Additional formats can be registered here.
+var Codecs = map[string]Codec{
+	"none": {
+		Extension: "",
+		Writer:    func(w io.Writer) io.WriteCloser { return nopWriteCloser{w} },
+		Reader:    func(r io.Reader) (io.ReadCloser, error) { return nopReadCloser{r}, nil },
+	},
+	"gzip": {
+		Extension: ".gz",
+		Writer:    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		Reader:    func(r io.Reader) (io.ReadCloser, error) { return gzip.NewReader(r) },
+	},
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+type nopReadCloser struct{ io.Reader }
+
+func (nopReadCloser) Close() error { return nil }
+
+// Details about the disk space used by the data the daemon stores for itself.
+type StorageUsage struct {
+	AuditLog struct {
+		Active   int64 `json:"active"`
+		Segments int   `json:"segments"`
+		// The total size of all compacted segments on the disk.
+		Compacted int64 `json:"compacted"`
+	} `json:"audit_log"`
+	InstallLogs  int64     `json:"install_logs"`
+	CrashReports int64     `json:"crash_reports"`
+	Total        int64     `json:"total"`
+	LastCompact  time.Time `json:"last_compaction"`
+}
+
+// The result of compacting the audit log.
+type CompactionResult struct {
+	// The number of duplicate entries that were removed from the compacted segment.
+	Duplicates int `json:"duplicates"`
+	// The number of segments removed because they were older than the retention period.
+	Expired      int   `json:"expired"`
+	BytesBefore  int64 `json:"bytes_before"`
+	BytesAfter   int64 `json:"bytes_after"`
+	SegmentsLeft int   `json:"segments"`
+}
+
+var lastCompaction time.Time
+
+// Starts a background routine that compacts the audit log once it grows beyond the
+// configured size, and removes segments that are older than the retention period.
+func StartCompactor() {
+	go supervisor.Supervise("audit log compactor", func() error {
+		ticker := time.NewTicker(compactionInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cfg := config.Get().System.AuditLog
+			if !cfg.Enabled {
+				continue
+			}
+
+			st, err := os.Stat(cfg.Path)
+			if err != nil && !os.IsNotExist(err) {
+				return errors.WithStack(err)
+			}
+
+			force := st != nil && cfg.MaxSize > 0 && st.Size() > int64(cfg.MaxSize)*1024*1024
+			if r, err := compact(cfg, force); err != nil {
+				zap.S().Warnw("failed to compact audit log", zap.Error(err))
+			} else if force || r.Expired > 0 {
+				zap.S().Infow("compacted audit log", zap.Int("duplicates", r.Duplicates), zap.Int("expired", r.Expired), zap.Int64("bytes_before", r.BytesBefore), zap.Int64("bytes_after", r.BytesAfter))
+			}
+		}
+
+		return nil
+	})
+}
+
+// Compacts the current audit log into a compressed segment immediately, regardless of
+// its size, and removes any expired segments.
+func Compact() (CompactionResult, error) {
+	return compact(config.Get().System.AuditLog, true)
+}
+
+// Moves the contents of the active audit log into a new segment, dropping any duplicate
+// entries, and removes segments older than the retention period. If rotate is false only
+// the retention period is enforced.
+func compact(cfg config.AuditLogConfiguration, rotate bool) (CompactionResult, error) {
+	var r CompactionResult
+
+	codec, ok := Codecs[cfg.Compression]
+	if !ok {
+		return r, errors.Errorf("unknown audit log compression \"%s\"", cfg.Compression)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	r.BytesBefore = pathSize(cfg.Path)
+	for _, s := range segments(cfg.Path) {
+		r.BytesBefore += pathSize(s)
+	}
+
+	if rotate {
+		d, err := writeSegment(cfg.Path, codec)
+		if err != nil {
+			return r, err
+		}
+
+		r.Duplicates = d
+	}
+
+	if cfg.Retention > 0 {
+		cutoff := time.Now().Add(-time.Duration(cfg.Retention) * time.Hour * 24)
+		for _, s := range segments(cfg.Path) {
+			if st, err := os.Stat(s); err == nil && st.ModTime().Before(cutoff) {
+				if err := os.Remove(s); err != nil {
+					return r, errors.WithStack(err)
+				}
+
+				r.Expired++
+			}
+		}
+
+		// The cached counts are only for segments that still exist.
+		if r.Expired > 0 {
+			segmentCounts = make(map[string]int)
+		}
+	}
+
+	remaining := segments(cfg.Path)
+	r.SegmentsLeft = len(remaining)
+	r.BytesAfter = pathSize(cfg.Path)
+	for _, s := range remaining {
+		r.BytesAfter += pathSize(s)
+	}
+
+	if rotate {
+		lastCompaction = time.Now().UTC()
+	}
+
+	return r, nil
+}
+
+// Writes the active audit log into a new segment using the codec and truncates it,
+// returning the number of lines that were dropped because they were identical to the
+// line before them.
+func writeSegment(p string, codec Codec) (int, error) {
+	src, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+
+		return 0, errors.WithStack(err)
+	}
+	defer src.Close()
+
+	name := p + "." + time.Now().UTC().Format(segmentTimeFormat) + codec.Extension
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	w := codec.Writer(f)
+
+	var previous []byte
+	duplicates := 0
+
+	err = eachLine(src, func(line []byte) error {
+		if previous != nil && bytes.Equal(line, previous) {
+			duplicates++
+			return nil
+		}
+
+		previous = line
+		_, err := w.Write(append(line, '\n'))
+
+		return errors.WithStack(err)
+	})
+
+	if err != nil {
+		os.Remove(name)
+		return 0, err
+	}
+
+	if err := w.Close(); err != nil {
+		os.Remove(name)
+		return 0, errors.WithStack(err)
+	}
+
+	return duplicates, errors.WithStack(os.Truncate(p, 0))
+}
+
+// Returns the compacted segments of the audit log, ordered from oldest to newest. Only
+// files named with the time they were created and the extension of a codec are segments,
+// anything else next to the audit log is left alone.
+func segments(p string) []string {
+	matches, _ := filepath.Glob(p + ".*")
+
+	var out []string
+	for _, m := range matches {
+		if isSegment(strings.TrimPrefix(m, p+".")) {
+			out = append(out, m)
+		}
+	}
+
+	// Segment names begin with the time they were created, so a lexical sort also sorts
+	// them by age.
+	sort.Strings(out)
+
+	return out
+}
+
+// Determines if the suffix following the path of the audit log is that of a segment.
+// Segments written before the time included nanoseconds are recognised as well, since
+// parsing accepts a fractional second that the layout does not include.
+func isSegment(suffix string) bool {
+	for _, c := range Codecs {
+		if c.Extension != "" && strings.HasSuffix(suffix, c.Extension) {
+			suffix = strings.TrimSuffix(suffix, c.Extension)
+			break
+		}
+	}
+
+	_, err := time.Parse("20060102T150405", suffix)
+
+	return err == nil
+}
+
+// Opens a compacted segment for reading using the codec matching its extension.
+func openSegment(p string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for _, c := range Codecs {
+		if c.Extension != "" && strings.HasSuffix(p, c.Extension) {
+			r, err := c.Reader(f)
+			if err != nil {
+				f.Close()
+				return nil, errors.WithStack(err)
+			}
+
+			return &segmentReader{ReadCloser: r, f: f}, nil
+		}
+	}
+
+	return f, nil
+}
+
+// Closes both the decompressing reader and the underlying file for a segment.
+type segmentReader struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (s *segmentReader) Close() error {
+	s.ReadCloser.Close()
+
+	return s.f.Close()
+}
+
+// Returns the disk space used by the data the daemon stores for itself.
+func Usage() StorageUsage {
+	var u StorageUsage
+
+	c := config.Get()
+
+	mu.Lock()
+	u.AuditLog.Active = pathSize(c.System.AuditLog.Path)
+	for _, s := range segments(c.System.AuditLog.Path) {
+		u.AuditLog.Segments++
+		u.AuditLog.Compacted += pathSize(s)
+	}
+	u.LastCompact = lastCompaction
+	mu.Unlock()
+
+	u.InstallLogs = pathSize("data/install_logs")
+	u.CrashReports = pathSize(c.System.CrashReports.Directory)
+	u.Total = u.AuditLog.Active + u.AuditLog.Compacted + u.InstallLogs + u.CrashReports
+
+	return u
+}
+
+// Returns the size of the file, or the total size of all files within the directory.
+func pathSize(p string) int64 {
+	var size int64
+
+	filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return size
+}
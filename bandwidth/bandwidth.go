@@ -0,0 +1,130 @@
+package bandwidth
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the index of the peer interface in the output of "ip link", for example
+// "eth0@if123".
+var peerIndexRegex = regexp.MustCompile(`@if(\d+)`)
+
+// Returns the name of the host side of the virtual ethernet pair for the container
+// process with the given PID. This is the interface that traffic for the container
+// passes through on the host.
+func HostInterface(pid int) (string, error) {
+	if pid <= 0 {
+		return "", errors.New("container is not running")
+	}
+
+	var stdout bytes.Buffer
+	cmd := exec.Command("nsenter", "-t", strconv.Itoa(pid), "-n", "ip", "-o", "link", "show", "eth0")
+	cmd.Stdout = &stdout
+
+	if err := cmd.Run(); err != nil {
+		return "", errors.Wrap(err, "failed to read container network interface")
+	}
+
+	m := peerIndexRegex.FindStringSubmatch(stdout.String())
+	if len(m) != 2 {
+		return "", errors.New("container network interface is not a veth pair")
+	}
+
+	index, _ := strconv.Atoi(m[1])
+
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return iface.Name, nil
+}
+
+// Limits the bandwidth of the container attached to the given host interface. Rates are
+// in megabits per second, a rate of 0 removes the limit in that direction.
+//
+// Traffic leaving the host interface is traffic received by the container, so ingress
+// for the container is shaped using HTB on the root of the interface. Traffic sent by
+// the container arrives on the interface, where it can only be policed.
+func Apply(iface string, ingress uint64, egress uint64) error {
+	if err := Clear(iface); err != nil {
+		return err
+	}
+
+	if ingress > 0 {
+		rate := fmt.Sprintf("%dmbit", ingress)
+
+		if err := tc("qdisc", "add", "dev", iface, "root", "handle", "1:", "htb", "default", "10"); err != nil {
+			return err
+		}
+
+		if err := tc("class", "add", "dev", iface, "parent", "1:", "classid", "1:10", "htb", "rate", rate, "ceil", rate); err != nil {
+			return err
+		}
+	}
+
+	if egress > 0 {
+		if err := tc("qdisc", "add", "dev", iface, "ingress"); err != nil {
+			return err
+		}
+
+		err := tc(
+			"filter", "add", "dev", iface, "parent", "ffff:", "protocol", "all",
+			"u32", "match", "u32", "0", "0",
+			"police", "rate", fmt.Sprintf("%dmbit", egress), "burst", burst(egress), "drop",
+			"flowid", ":1",
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Removes any bandwidth limits from the interface.
+func Clear(iface string) error {
+	for _, parent := range []string{"root", "ingress"} {
+		if err := tc("qdisc", "del", "dev", iface, parent); err != nil {
+			// The kernel returns an error if there is no qdisc to delete, which is the
+			// case for any interface that has not been limited before.
+			if !strings.Contains(err.Error(), "No such file or directory") && !strings.Contains(err.Error(), "Invalid handle") && !strings.Contains(err.Error(), "Cannot find specified qdisc") {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the burst size allowed by the policer, which is the amount of data that can be
+// sent at the rate in 100ms with a minimum of 10 kilobytes.
+func burst(rate uint64) string {
+	kb := rate * 1000 / 8 / 10
+	if kb < 10 {
+		kb = 10
+	}
+
+	return strconv.FormatUint(kb, 10) + "k"
+}
+
+// Runs a traffic control command, returning the output of the command in the error if
+// it fails.
+func tc(args ...string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command("tc", args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("tc %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String())))
+	}
+
+	return nil
+}
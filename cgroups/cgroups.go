@@ -0,0 +1,182 @@
+package cgroups
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// The location the cgroup filesystem is mounted on the host.
+const root = "/sys/fs/cgroup"
+
+var unified struct {
+	once  sync.Once
+	value bool
+}
+
+// Determines if the host is using the unified cgroup v2 hierarchy. The result is cached
+// since the hierarchy in use cannot change without a reboot.
+func Unified() bool {
+	unified.once.Do(func() {
+		_, err := os.Stat(filepath.Join(root, "cgroup.controllers"))
+		unified.value = err == nil
+	})
+
+	return unified.value
+}
+
+// Returns the cgroup v2 directory for a container. Docker places containers in a systemd
+// scope when using the systemd cgroup driver, or in its own directory when using the
+// cgroupfs driver.
+func ContainerPath(id string) (string, error) {
+	for _, p := range []string{
+		filepath.Join(root, "system.slice", "docker-"+id+".scope"),
+		filepath.Join(root, "machine.slice", "libpod-"+id+".scope"),
+		filepath.Join(root, "docker", id),
+	} {
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", errors.Errorf("could not find cgroup for container %s", id)
+}
+
+// Pressure stall information for a single resource. The averages are the percentage of
+// time over the last 10, 60 and 300 seconds that some, or all, tasks in the cgroup were
+// stalled waiting on the resource.
+type Pressure struct {
+	Some PressureAverages `json:"some"`
+	Full PressureAverages `json:"full"`
+}
+
+type PressureAverages struct {
+	Avg10  float64 `json:"avg10"`
+	Avg60  float64 `json:"avg60"`
+	Avg300 float64 `json:"avg300"`
+}
+
+// The pressure stall information for the CPU, memory and IO of a cgroup.
+type PressureStats struct {
+	Cpu    Pressure `json:"cpu"`
+	Memory Pressure `json:"memory"`
+	Io     Pressure `json:"io"`
+}
+
+// Reads the pressure stall information for the cgroup at the given path. This is only
+// available on cgroup v2 hosts running a kernel with PSI enabled.
+func ReadPressure(path string) (*PressureStats, error) {
+	ps := &PressureStats{}
+
+	for name, p := range map[string]*Pressure{"cpu": &ps.Cpu, "memory": &ps.Memory, "io": &ps.Io} {
+		if err := readPressureFile(filepath.Join(path, name+".pressure"), p); err != nil {
+			return nil, err
+		}
+	}
+
+	return ps, nil
+}
+
+// Parses a pressure file, which contains lines in the following format:
+//
+// some avg10=0.00 avg60=0.00 avg300=0.00 total=0
+// full avg10=0.00 avg60=0.00 avg300=0.00 total=0
+func readPressureFile(path string, p *Pressure) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var a *PressureAverages
+		switch fields[0] {
+		case "some":
+			a = &p.Some
+		case "full":
+			a = &p.Full
+		default:
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			kv := strings.SplitN(field, "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+
+			v, _ := strconv.ParseFloat(kv[1], 64)
+			switch kv[0] {
+			case "avg10":
+				a.Avg10 = v
+			case "avg60":
+				a.Avg60 = v
+			case "avg300":
+				a.Avg300 = v
+			}
+		}
+	}
+
+	return errors.WithStack(scanner.Err())
+}
+
+// Returns the number of CPU shares that the container runtime converts into the given
+// cgroup v2 CPU weight. Runtimes only accept shares and convert them to a weight using
+// 1 + ((shares - 2) * 9999) / 262142, so this is the inverse of that conversion.
+func SharesForWeight(weight uint64) uint64 {
+	if weight <= 1 {
+		return 2
+	}
+
+	return 2 + ((weight-1)*262142+9998)/9999
+}
+
+// Returns the path of the whole disk that the given path is stored on, for example
+// "/dev/sda" for a path on "/dev/sda1". Device IO limits can only be applied to whole
+// disks, cgroup v2 rejects limits for partitions entirely.
+func BlockDevice(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	// The major and minor numbers are encoded in the device ID using the same layout as
+	// the kernel's new_encode_dev.
+	major := (st.Dev >> 8) & 0xfff
+	minor := (st.Dev & 0xff) | ((st.Dev >> 12) & 0xfff00)
+
+	sys, err := filepath.EvalSymlinks(filepath.Join("/sys/dev/block", strconv.FormatUint(uint64(major), 10)+":"+strconv.FormatUint(uint64(minor), 10)))
+	if err != nil {
+		return "", errors.Wrap(err, "path is not stored on a block device")
+	}
+
+	// Partitions are a child of the disk they belong to in sysfs.
+	if _, err := os.Stat(filepath.Join(sys, "partition")); err == nil {
+		sys = filepath.Dir(sys)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(sys, "uevent"))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	for _, line := range strings.Split(string(b), "\n") {
+		if strings.HasPrefix(line, "DEVNAME=") {
+			return "/dev/" + strings.TrimPrefix(line, "DEVNAME="), nil
+		}
+	}
+
+	return "", errors.Errorf("could not determine the device name for %s", sys)
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"strings"
+)
+
+// Removes the brackets surrounding an IPv6 address, allowing addresses such as "[::]"
+// to be used for bind addresses in the configuration file.
+func TrimAddressBrackets(host string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+}
+
+// Returns the address that should be used to connect to a listener bound to the given
+// host on this machine. Listeners bound to every interface are reached using the
+// loopback address of the same family.
+func LocalDialAddress(host string) string {
+	host = TrimAddressBrackets(host)
+
+	switch host {
+	case "", "0.0.0.0":
+		return "127.0.0.1"
+	case "::":
+		return "::1"
+	}
+
+	return host
+}
@@ -50,6 +50,12 @@ type Configuration struct {
 		// The amount of time that should lapse between data output throttle
 		// checks. This should be defined in milliseconds.
 		CheckInterval int `defauly:"100" yaml:"check_interval"`
+
+		// The number of console commands each user is able to send to a server through
+		// the websocket per second, and the number of commands that can be sent in a
+		// single burst. Set the rate to 0 to disable the limit.
+		CommandsPerSecond int `default:"5" yaml:"commands_per_second"`
+		CommandBurst      int `default:"20" yaml:"command_burst"`
 	}
 
 	// The location where the panel is running that this daemon should connect to
@@ -66,6 +72,22 @@ type SystemConfiguration struct {
 	// Directory where the server data is stored at.
 	Data string `default:"/srv/daemon-data" yaml:"data"`
 
+	// Additional directories that server data can be stored in, keyed by the name of the
+	// pool. Servers are stored in the data directory above unless they are moved to one
+	// of these pools.
+	StoragePools map[string]string `yaml:"storage_pools"`
+
+	// The number of console commands kept in memory for each server so that they can be
+	// reviewed and replayed from the Panel.
+	CommandHistorySize int `default:"200" yaml:"command_history_size"`
+
+	// Limits on the combined resources of the running servers that belong to the same
+	// owner, keyed by the owner set on each server. This allows the individual servers of
+	// a customer to be oversold while capping what they are able to use at once.
+	GroupQuotas map[string]GroupQuota `yaml:"group_quotas"`
+
+	Admission AdmissionConfiguration `yaml:"admission"`
+
 	// The user that should own all of the server files, and be used for containers.
 	Username string `default:"pterodactyl" yaml:"username"`
 
@@ -81,6 +103,9 @@ type SystemConfiguration struct {
 	// detected as started when booting.
 	SyncServersOnBoot bool `default:"true" yaml:"sync_servers_on_boot"`
 
+	// Controls the periodic check of each server's egg configuration against the Panel.
+	EggSync EggSyncConfiguration `yaml:"egg_sync"`
+
 	// The path to the system's timezone file that will be mounted into running Docker containers.
 	TimezonePath string `yaml:"timezone_path"`
 
@@ -97,12 +122,561 @@ type SystemConfiguration struct {
 	// boot anyways.
 	SetPermissionsOnBoot bool `default:"true" yaml:"set_permissions_on_boot"`
 
+	// Allows the daemon to run as an unprivileged user with a rootless container runtime.
+	Rootless RootlessConfiguration `yaml:"rootless"`
+
 	// Determines if Wings should detect a server that stops with a normal exit code of
 	// "0" as being crashed if the process stopped without any Wings interaction. E.g.
 	// the user did not press the stop button, but the process stopped cleanly.
 	DetectCleanExitAsCrash bool `default:"true" yaml:"detect_clean_exit_as_crash"`
 
 	Sftp *SftpConfiguration `yaml:"sftp"`
+
+	AuditLog AuditLogConfiguration `yaml:"audit_log"`
+
+	Log LogConfiguration `yaml:"log"`
+
+	ConsoleLogs ConsoleLogConfiguration `yaml:"console_logs"`
+
+	StatsHistory StatsHistoryConfiguration `yaml:"stats_history"`
+
+	// Rules applied to the console output of every server on the node, in addition to
+	// any defined by the egg of the server, to hide sensitive values such as tokens or
+	// IP addresses before the output is sent to clients or stored.
+	ConsoleRedactions []RedactionRule `yaml:"console_redactions"`
+
+	// Rules restricting what can be done to the files of every server on the node, checked
+	// before any rules defined by the egg of the server. The rules apply to the files API,
+	// archive extraction and SFTP. Reading and listing files is never restricted.
+	FileRules []FileRule `yaml:"file_rules"`
+
+	// Environment variables added to the containers of the servers on the node, such as
+	// the address of a regional proxy. Variables defined by the server or its egg take
+	// precedence over these unless a rule overrides them, and the variables set by the
+	// daemon itself, such as SERVER_PORT, can never be replaced. When more than one rule
+	// sets the same variable the last rule applies.
+	Environment []EnvironmentRule `yaml:"environment"`
+
+	Temp TempConfiguration `yaml:"temp"`
+
+	Snapshots SnapshotConfiguration `yaml:"snapshots"`
+
+	Trash TrashConfiguration `yaml:"trash"`
+
+	Supervisor SupervisorConfiguration `yaml:"supervisor"`
+
+	CrashReports CrashReportConfiguration `yaml:"crash_reports"`
+
+	DebugCaptures DebugCaptureConfiguration `yaml:"debug_captures"`
+
+	Cache CacheConfiguration `yaml:"cache"`
+
+	DiskAccounting DiskAccountingConfiguration `yaml:"disk_accounting"`
+
+	Drain DrainConfiguration `yaml:"drain"`
+
+	Installer InstallerConfiguration `yaml:"installer"`
+
+	Firewall FirewallConfiguration `yaml:"firewall"`
+
+	Flows FlowsConfiguration `yaml:"flows"`
+
+	SharedCache SharedCacheConfiguration `yaml:"shared_cache"`
+
+	OfflineResponder OfflineResponderConfiguration `yaml:"offline_responder"`
+
+	// Scripts and webhooks that are run when lifecycle events occur for a server.
+	Hooks []HookConfiguration `yaml:"hooks"`
+}
+
+// Defines a script or webhook that is run when a lifecycle event occurs for a server.
+// Details about the server are passed to scripts using environment variables and as
+// JSON on stdin, and are sent to webhooks as the JSON body of a POST request.
+type HookConfiguration struct {
+	// The events the hook is run for: "pre-start", "post-start", "pre-stop",
+	// "post-crash", "backup-complete" or "integrity-violation". Hooks for "pre" events must finish before
+	// the action continues.
+	Events []string `yaml:"events"`
+
+	// The path to the script to execute, along with any arguments.
+	Command []string `yaml:"command"`
+
+	// The URL that event details are sent to.
+	Url string `yaml:"url"`
+
+	// The number of seconds a hook is allowed to run for before it is cancelled.
+	Timeout int `default:"30" yaml:"timeout"`
+}
+
+// Defines if the daemon answers game status requests for servers that are offline.
+type OfflineResponderConfiguration struct {
+	// If set to true the daemon binds the default allocation of offline servers whose
+	// egg defines an offline responder, and answers status requests sent to it.
+	Enabled bool `default:"false" yaml:"enabled"`
+}
+
+// Defines how installation artifacts and Docker images are shared between the nodes
+// in a cluster, so that they are fetched from a peer on the local network rather than
+// being downloaded from the internet by every node.
+type SharedCacheConfiguration struct {
+	// If set to true this node serves its cached installation artifacts to peers, and
+	// requests artifacts and images from peers before downloading them itself.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The token that peers must provide to access the cache of this node, and that is
+	// provided when requesting artifacts from peers. This must be the same on every
+	// node sharing a cache.
+	Token string `yaml:"token"`
+
+	// The base URLs of the daemons on the other nodes, for example
+	// "https://node2.example.com:8080".
+	Peers []string `yaml:"peers"`
+
+	// The addresses of pull-through registry mirrors, such as "10.0.0.2:5000", that
+	// Docker Hub images are pulled through. Mirrors that are not served over TLS must
+	// be added to the insecure registries of the Docker daemon.
+	RegistryMirrors []string `yaml:"registry_mirrors"`
+
+	// If set to true this node runs a pull-through registry mirror of Docker Hub that
+	// other nodes can add to their registry mirrors.
+	RunRegistryMirror   bool   `default:"false" yaml:"run_registry_mirror"`
+	RegistryMirrorPort  int    `default:"5000" yaml:"registry_mirror_port"`
+	RegistryMirrorImage string `default:"registry:2" yaml:"registry_mirror_image"`
+
+	// The address the registry mirror is published on. The mirror has no authentication,
+	// so by default it is only published on the gateway of the docker0 bridge, or on the
+	// loopback address if there is no bridge. Set this to an address on a private network
+	// to allow the other nodes to use it.
+	RegistryMirrorAddress string `yaml:"registry_mirror_address"`
+}
+
+// Defines the collection of network flow telemetry for servers on the node.
+type FlowsConfiguration struct {
+	// If set to true the daemon will collect the inbound flows for every server
+	// allocation and expose them through the metrics endpoint and the API.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The source flows are collected from. Only "conntrack" is supported, which reads
+	// the connection tracking table of the kernel. Flows are not collected with eBPF,
+	// since that would need a kernel and toolchain that many nodes do not have. Packet
+	// rates require net.netfilter.nf_conntrack_acct to be enabled on the host.
+	Source string `default:"conntrack" yaml:"source"`
+
+	// The number of seconds between each collection of flows. Collections are spaced
+	// further apart if reading the table takes longer than a tenth of this.
+	Interval int `default:"5" yaml:"interval"`
+
+	// The maximum number of entries read from the connection tracking table on each
+	// collection. Flows beyond this are not counted. Set to 0 to read every entry.
+	MaxEntries int `default:"100000" yaml:"max_entries"`
+
+	// The number of remote addresses and autonomous systems reported for each server.
+	Top int `default:"10" yaml:"top"`
+
+	// The path to an ASN database in the tab separated format provided by iptoasn.com,
+	// used to resolve the autonomous system of remote addresses. If empty, autonomous
+	// systems are not reported.
+	AsnDatabase string `yaml:"asn_database"`
+}
+
+// Defines the flood protection rules that the daemon programs into the host firewall
+// for server allocations.
+type FirewallConfiguration struct {
+	// If set to true the daemon will manage iptables rules limiting the connections to
+	// each server allocation.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The default maximum number of tracked connections to a single allocation. Set to 0
+	// to not limit connections unless a limit is defined for the allocation.
+	MaxConnections int `default:"0" yaml:"max_connections"`
+
+	// The default number of new UDP flows per second a single source address is able to
+	// create to an allocation, and the burst allowed above that rate.
+	UdpNewPerSecond int `default:"0" yaml:"udp_new_per_second"`
+	UdpBurst        int `default:"0" yaml:"udp_burst"`
+}
+
+// Defines the policy applied to the installation scripts that are run for servers.
+type InstallerConfiguration struct {
+	// The Docker images that installation scripts are allowed to run in. Entries may
+	// contain "*" wildcards, for example "ghcr.io/pterodactyl/installers:*". If no
+	// images are defined any image is allowed.
+	AllowedImages []string `yaml:"allowed_images"`
+
+	// The interpreters that installation scripts are allowed to declare. Scripts that do
+	// not declare one must use an entrypoint whose program name is in this list. If empty
+	// all of the built-in interpreters and any defined below are allowed.
+	AllowedInterpreters []string `yaml:"allowed_interpreters"`
+
+	// Additional interpreters that can be used by installation scripts, or replacements
+	// for the built-in ones, keyed by the name scripts use to reference them.
+	Interpreters map[string]InstallerInterpreter `yaml:"interpreters"`
+
+	// If set to true installation scripts are only run if they are signed by one of the
+	// trusted keys, or if their hash is in the list of allowed hashes. This protects the
+	// node from running arbitrary code in the privileged installation container if the
+	// Panel is compromised.
+	RequireVerification bool `default:"false" yaml:"require_verification"`
+
+	// The base64 encoded Ed25519 public keys of publishers that are trusted to sign
+	// installation scripts.
+	TrustedKeys []string `yaml:"trusted_keys"`
+
+	// The hex encoded SHA-256 hashes of installation scripts that are allowed to run
+	// without a signature.
+	AllowedHashes []string `yaml:"allowed_hashes"`
+
+	// The maximum size in megabytes of the downloads cached for installations. Once the
+	// cache is larger than this the downloads that were used least recently are removed.
+	// Set to 0 to not cache downloads at all.
+	CacheSize int64 `default:"10240" yaml:"cache_size"`
+}
+
+// Defines how an installation script is executed by an interpreter.
+type InstallerInterpreter struct {
+	// The command used to run the script. The path to the script is appended as the
+	// final argument.
+	Entrypoint []string `yaml:"entrypoint"`
+
+	// The extension given to the script file, some interpreters will refuse to run a
+	// file without the correct extension.
+	Extension string `yaml:"extension"`
+}
+
+// Defines how running servers are stopped when the node is placed into drain mode
+// ahead of maintenance.
+type DrainConfiguration struct {
+	// A console command sent to each running server before it is stopped so that users
+	// can be warned. Any instance of "{seconds}" is replaced with the number of seconds
+	// remaining before the server is stopped. If empty no warning is sent.
+	WarningCommand string `yaml:"warning_command"`
+
+	// The number of seconds to wait after sending the warning command before the
+	// server is stopped.
+	WarningDelay int `default:"60" yaml:"warning_delay"`
+
+	// The number of seconds to wait between beginning the stop process for each server
+	// so that the node is not hit by every server saving its data at once.
+	Stagger int `default:"5" yaml:"stagger"`
+
+	// The number of seconds to wait for a server to stop gracefully before the process
+	// is killed.
+	StopTimeout int `default:"300" yaml:"stop_timeout"`
+}
+
+// Defines how long the results of expensive filesystem read operations are cached for.
+// Cached directory listings are invalidated automatically when files are modified through
+// the daemon.
+type CacheConfiguration struct {
+	// The number of seconds that a directory listing is cached for. Set to 0 to disable
+	// caching of directory listings.
+	DirectoryListingTtl int `default:"10" yaml:"directory_listing_ttl"`
+
+	// The number of seconds that the calculated disk usage for a server is cached for. Set
+	// to 0 to disable caching and calculate the usage each time it is needed.
+	DiskUsageTtl int `default:"60" yaml:"disk_usage_ttl"`
+
+	// The number of seconds that the disk usage breakdown of a directory is cached for.
+	// Like directory listings the breakdown is invalidated when files are modified through
+	// the daemon, but not when they are modified by the server process.
+	DirectoryUsageTtl int `default:"300" yaml:"directory_usage_ttl"`
+}
+
+// Defines how the disk space used by each server is tracked.
+type DiskAccountingConfiguration struct {
+	// The method used to track the disk space of a server. "project" uses the project
+	// quotas of the filesystem, which must be XFS or ext4 mounted with project quotas
+	// enabled, and makes the filesystem itself refuse writes once a server is out of
+	// space. "watch" walks the files of a server once and then watches them for changes.
+	// "scan" walks every file of a server each time the cached usage expires. "auto" uses
+	// the first of these that is available.
+	Mode string `default:"auto" yaml:"mode"`
+
+	// If set to true a running server is stopped as soon as it is found to be using more
+	// disk space than it is allowed. This does not apply to scanned servers, since their
+	// usage is only known each time their files are walked.
+	StopOnExceed bool `default:"true" yaml:"stop_on_exceed"`
+}
+
+// Defines the configuration for the supervisors that restart daemon subsystems when
+// they panic or unexpectedly fail.
+type SupervisorConfiguration struct {
+	// A URL that will receive a POST request containing details about the failure any
+	// time a subsystem panics or is restarted.
+	WebhookUrl string `yaml:"webhook_url"`
+
+	// The maximum number of seconds to wait between restarts of a failing subsystem.
+	MaxBackoff int `default:"60" yaml:"max_backoff"`
+}
+
+// Defines how daemon panics and fatal errors are reported. Crash reporting is opt-in, and
+// reports only contain the error, stack trace, daemon version and anonymized details about
+// the host system.
+type CrashReportConfiguration struct {
+	// If set to true crash reports are written to the disk, and submitted to the DSN if
+	// one is configured.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The directory that crash reports are written to.
+	Directory string `default:"data/crashes" yaml:"directory"`
+
+	// The maximum number of reports kept on the disk, the oldest are removed first.
+	MaxReports int `default:"50" yaml:"max_reports"`
+
+	// A Sentry compatible DSN that reports are submitted to, in the format
+	// "https://<key>@<host>/<project>". If empty reports are only written locally.
+	Dsn string `yaml:"dsn"`
+}
+
+// Defines the limits for debug captures, which record verbose details about a single
+// server for a short time so that they can be downloaded as a bundle for support.
+type DebugCaptureConfiguration struct {
+	// The directory that captures and the bundles created from them are written to.
+	Directory string `default:"data/debug" yaml:"directory"`
+
+	// The number of minutes a capture runs for if no duration is requested, and the
+	// longest duration that can be requested.
+	DefaultDuration int `default:"15" yaml:"default_duration"`
+	MaxDuration     int `default:"60" yaml:"max_duration"`
+
+	// The most megabytes recorded by a single capture. Entries are dropped once a
+	// capture reaches the limit.
+	MaxSize int64 `default:"50" yaml:"max_size"`
+
+	// The most bundles kept for each server, the oldest are removed first.
+	MaxBundles int `default:"5" yaml:"max_bundles"`
+}
+
+// Defines the configuration for the append-only audit log that records administrative
+// actions performed aganist servers on this node.
+type AuditLogConfiguration struct {
+	// If set to false no audit entries will be written to the disk.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The file that audit entries are appended to. Each line in the file is a single
+	// JSON encoded entry.
+	Path string `default:"data/audit.log" yaml:"path"`
+
+	// The size in megabytes the audit log can grow to before it is compacted into a
+	// compressed segment. Set to 0 to only compact when requested through the API.
+	MaxSize int `default:"50" yaml:"max_size"`
+
+	// The compression used for compacted segments, either "gzip" or "none".
+	Compression string `default:"gzip" yaml:"compression"`
+
+	// The number of days compacted segments are kept for before they are removed. Set
+	// to 0 to keep them forever, which is the default so that history is never removed
+	// unless it has been asked for.
+	Retention int `default:"0" yaml:"retention"`
+}
+
+// Defines how the daemon writes its own log to the disk, in addition to stdout. The log
+// is rotated once it reaches the maximum size or age, and rotated files are compressed
+// and removed once they are older than the retention period.
+type LogConfiguration struct {
+	// The directory the daemon log is written to. Set to an empty string to only log
+	// to stdout.
+	Directory string `default:"logs" yaml:"directory"`
+
+	// The size in megabytes the active log can grow to before it is rotated.
+	MaxSize int `default:"100" yaml:"max_size"`
+
+	// The number of hours after which the active log is rotated regardless of its size.
+	// Set to 0 to only rotate the log based on its size.
+	MaxAge int `default:"24" yaml:"max_age"`
+
+	// If set to true rotated log files are compressed using gzip.
+	Compress bool `default:"true" yaml:"compress"`
+
+	// The number of days rotated log files are kept for, and the maximum number of
+	// rotated log files to keep. Set either to 0 to disable that limit.
+	Retention int `default:"30" yaml:"retention"`
+	MaxFiles  int `default:"50" yaml:"max_files"`
+}
+
+// Defines the directory that all of the temporary files created by the daemon are stored
+// in, such as archives being staged and files being downloaded.
+type TempConfiguration struct {
+	// The directory temporary files are created in, within a "wings" directory that only
+	// the daemon writes to. This should be on the same disk as the data directory, so that
+	// completed files can be moved into place without a copy.
+	Directory string `default:"data/tmp" yaml:"directory"`
+
+	// The maximum size in megabytes of all of the temporary files. Writes to temporary
+	// files fail once this is reached. Set to 0 to disable the limit.
+	MaxSize int64 `default:"20480" yaml:"max_size"`
+
+	// The number of hours after which a temporary file that has not been modified is
+	// considered abandoned and removed. Everything in the "wings" directory is removed
+	// when the daemon starts.
+	MaxAge int `default:"24" yaml:"max_age"`
+}
+
+// Defines the limits for read-only snapshots of server data that are made available to
+// external tools.
+type SnapshotConfiguration struct {
+	// The number of minutes a snapshot is available for when no lifetime is requested.
+	DefaultTtl int `default:"60" yaml:"default_ttl"`
+
+	// The maximum number of minutes a snapshot can be requested to be available for.
+	MaxTtl int `default:"1440" yaml:"max_ttl"`
+
+	// The maximum number of snapshots that can exist at once for a single server.
+	MaxPerServer int `default:"2" yaml:"max_per_server"`
+}
+
+// Defines the trash that files deleted through the API and SFTP are moved to, so that they
+// can be restored if they were deleted by accident. Items in the trash count towards the
+// disk space of the server they were deleted from.
+type TrashConfiguration struct {
+	// If set to true files deleted from servers are moved to the trash rather than being
+	// removed. Servers can override this. A file that cannot be moved to the trash is not
+	// deleted at all, and the deletion fails with the reason.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The number of hours items are kept in the trash before they are removed.
+	Retention int `default:"168" yaml:"retention"`
+
+	// The most megabytes kept in the trash of a single server. The oldest items are
+	// removed to make room for new ones, and files larger than this cannot be deleted
+	// while the trash is enabled for the server. Set to 0 to keep every item until it
+	// expires.
+	MaxSize int64 `default:"10240" yaml:"max_size"`
+}
+
+// The ways the daemon can respond to a server being created or started beyond the
+// overcommit limits of the node.
+const (
+	AdmissionOff     = "off"
+	AdmissionWarn    = "warn"
+	AdmissionEnforce = "enforce"
+)
+
+// Defines how far the resources allocated to the servers on the node may exceed the
+// capacity of the host, and what happens when creating or starting a server would go
+// beyond that.
+type AdmissionConfiguration struct {
+	// Either "off", "warn" to allow the server but log a warning, or "enforce" to refuse
+	// to create or start the server.
+	Mode string `default:"warn" yaml:"mode"`
+
+	// The capacity of the node in megabytes of memory and disk, and in percent of a CPU
+	// core. Any left at 0 are detected from the host, using the disk the data directory
+	// is stored on.
+	Memory int64 `yaml:"memory"`
+	Disk   int64 `yaml:"disk"`
+	Cpu    int64 `yaml:"cpu"`
+
+	// The multiple of the capacity of each resource that can be allocated, for example
+	// 1.5 allows 50% more memory to be allocated than the host has. Memory and CPU are
+	// checked against the servers that are running when a server starts, and every
+	// resource is checked against all of the servers when a server is created. Set to 0
+	// to not limit that resource.
+	MemoryRatio float64 `default:"1" yaml:"memory_ratio"`
+	DiskRatio   float64 `default:"1" yaml:"disk_ratio"`
+	CpuRatio    float64 `default:"4" yaml:"cpu_ratio"`
+}
+
+// The combined resource limits of the running servers in a group. A value of 0 means
+// that resource is not limited.
+type GroupQuota struct {
+	// The total memory in megabytes.
+	Memory int64 `yaml:"memory"`
+
+	// The total CPU as a percentage of a single core, so 400 allows four full cores.
+	Cpu int64 `yaml:"cpu"`
+
+	// The total disk space in megabytes.
+	Disk int64 `yaml:"disk"`
+}
+
+// Restricts what can be done to the files of servers matching a pattern. Patterns without
+// a slash, such as "*.jar", are matched against the name of the file and of each directory
+// containing it, other patterns are matched against the path from the root of the server.
+// The last rule matching a path decides whether an action is allowed.
+type FileRule struct {
+	Pattern string `yaml:"pattern"`
+	// Any of "write", "delete" or "execute". If no actions are given every action is denied.
+	Actions []string `yaml:"actions"`
+	// Allows the actions instead of denying them, for making exceptions to an earlier rule.
+	Allow bool `yaml:"allow"`
+}
+
+// A set of environment variables added to the containers of servers on the node. Every
+// condition that is set must match for the variables to be added to a server, and a rule
+// without conditions applies to every server.
+type EnvironmentRule struct {
+	// The variables to add, keyed by name.
+	Variables map[string]string `yaml:"variables"`
+
+	// The servers the rule applies to.
+	Servers []string `yaml:"servers"`
+
+	// The owners of the servers the rule applies to.
+	Owners []string `yaml:"owners"`
+
+	// Matches servers whose image begins with this value, such as "ghcr.io/pterodactyl/yolks:java".
+	Image string `yaml:"image"`
+
+	// If set to true the variables replace any with the same name defined by the server
+	// or its egg.
+	Override bool `yaml:"override"`
+}
+
+// A regular expression matched against console output, with any matches replaced. If no
+// replacement is given the matches are replaced with "[REDACTED]".
+type RedactionRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement"`
+}
+
+// Defines how the console output of each server is stored on the node so that it can be
+// searched after it has scrolled out of the console.
+type ConsoleLogConfiguration struct {
+	// If set to false console output is not written to the disk.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The directory the console logs are written to, with one log per server.
+	Directory string `default:"data/console_logs" yaml:"directory"`
+
+	// The size in megabytes the console log of a server can grow to before it is rotated.
+	MaxSize int `default:"10" yaml:"max_size"`
+
+	// If set to true rotated console logs are compressed using gzip.
+	Compress bool `default:"true" yaml:"compress"`
+
+	// The number of days rotated console logs are kept for, and the maximum number of
+	// rotated console logs to keep for each server. Set either to 0 to disable that limit.
+	Retention int `default:"7" yaml:"retention"`
+	MaxFiles  int `default:"5" yaml:"max_files"`
+}
+
+// Defines how the resource usage of each server is kept on the node so that it can be
+// charted without the Panel storing every sample itself.
+type StatsHistoryConfiguration struct {
+	// If set to false no history is kept.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The directory the history is written to, with one file per server.
+	Directory string `default:"data/stats" yaml:"directory"`
+
+	// The number of hours of history kept for each server.
+	Window int `default:"24" yaml:"window"`
+
+	// The number of seconds of samples combined into each point of the history. The file
+	// for each server has a fixed size of one point per interval within the window.
+	Resolution int `default:"10" yaml:"resolution"`
+}
+
+// Returns the rotation settings used for the console log of each server.
+func (c ConsoleLogConfiguration) Rotation() LogConfiguration {
+	return LogConfiguration{
+		Directory: c.Directory,
+		MaxSize:   c.MaxSize,
+		Compress:  c.Compress,
+		Retention: c.Retention,
+		MaxFiles:  c.MaxFiles,
+	}
 }
 
 // Defines the configuration of the internal SFTP server.
@@ -113,7 +687,8 @@ type SftpConfiguration struct {
 	// If set to true disk checking will not be performed. This will prevent the SFTP
 	// server from checking the total size of a directory when uploading files.
 	DisableDiskChecking bool `default:"false" yaml:"disable_disk_checking"`
-	// The bind address of the SFTP server.
+	// The bind address of the SFTP server. IPv6 addresses may optionally be wrapped
+	// in brackets, for example "[::]".
 	Address string `default:"0.0.0.0" yaml:"bind_address"`
 	// The bind port of the SFTP server.
 	Port int `default:"2022" yaml:"bind_port"`
@@ -138,6 +713,13 @@ type DockerNetworkConfiguration struct {
 	// with any other interfaces in use by Docker or on the system.
 	Interface string `default:"172.18.0.1"`
 
+	// The IPv6 address of the network interface. This is made available to server
+	// configuration files using "{{config.docker.interface6}}".
+	Interface6 string `default:"fdba:17c8:6c94::1011" yaml:"interface6"`
+
+	// If set to false the network will be created with only an IPv4 subnet.
+	EnableIPv6 bool `default:"true" yaml:"enable_ipv6"`
+
 	// The name of the network to use. If this network already exists it will not
 	// be created. If it is not found, a new network will be created using the interface
 	// defined.
@@ -147,6 +729,41 @@ type DockerNetworkConfiguration struct {
 	IsInternal bool                    `default:"false" yaml:"is_internal"`
 	EnableICC  bool                    `default:"true" yaml:"enable_icc"`
 	Interfaces dockerNetworkInterfaces `yaml:"interfaces"`
+
+	// The MTU of the network, and any additional options passed to the network driver.
+	// Options set here replace the defaults used by the daemon.
+	Mtu     int               `default:"1500" yaml:"mtu"`
+	Options map[string]string `yaml:"options"`
+
+	// If set to true the network is replaced when its driver, subnets, MTU or driver
+	// options no longer match this configuration. A new network is created alongside the
+	// existing one with an alternate name and bridge interface, and each server moves to
+	// it the next time it is started. The previous network is removed once no server is
+	// running on it. If the subnets did not change the networks cannot exist side by side,
+	// so the network is recreated in place once no server is running on it instead.
+	Reconcile bool `default:"false" yaml:"reconcile"`
+
+	// Defines the macvlan networks that servers can be attached to, keyed by the name
+	// of the parent interface on the host.
+	Macvlan map[string]MacvlanNetworkConfiguration `yaml:"macvlan"`
+
+	// The names of existing Docker networks that servers are allowed to attach to in
+	// addition to their primary network, for example a shared database network.
+	AdditionalNetworks []string `yaml:"additional_networks"`
+
+	// If set to true servers are allowed to use host networking.
+	AllowHostNetworking bool `default:"false" yaml:"allow_host_networking"`
+
+	// The images that are allowed to use host networking, such as those for voice
+	// servers that cannot work behind NAT. Entries may contain "*" wildcards. If empty
+	// any server is allowed to use host networking when it is enabled above.
+	HostNetworkImages []string `yaml:"host_network_images"`
+}
+
+// Defines a macvlan network that is created on top of a host interface.
+type MacvlanNetworkConfiguration struct {
+	Subnet  string `yaml:"subnet"`
+	Gateway string `yaml:"gateway"`
 }
 
 // Defines the docker configuration used by the daemon when interacting with
@@ -161,18 +778,223 @@ type DockerConfiguration struct {
 	// defer to the host system to manage image updates.
 	UpdateImages bool `default:"true" yaml:"update_images"`
 
-	// The location of the Docker socket.
+	// The default policy controlling when images are pulled, either "always",
+	// "if-not-present" or "never". If empty this is determined by update_images.
+	PullPolicy string `yaml:"pull_policy"`
+
+	// Credentials for private registries, keyed by the registry host. Images on
+	// Docker Hub use the "docker.io" key.
+	Registries map[string]RegistryConfiguration `yaml:"registries"`
+
+	// Controls how port allocation changes are applied to running servers. When set to
+	// "proxy" the new ports are forwarded to the ports the server is currently using
+	// until it is next restarted, "restart" restarts the server to apply the change
+	// immediately, and "none" waits until the server is next started.
+	AllocationRemap string `default:"proxy" yaml:"allocation_remap"`
+
+	// Controls the removal of images that are no longer used by any server.
+	ImageGc ImageGcConfiguration `yaml:"image_gc"`
+
+	// Controls how containers belonging to servers the daemon does not know about are
+	// handled.
+	Reconciliation ContainerReconciliationConfiguration `yaml:"reconciliation"`
+
+	// Controls how port ranges allocated to servers are published.
+	PortRanges PortRangeConfiguration `yaml:"port_ranges"`
+
+	// The location of the Docker socket. When using Podman this should be the location
+	// of the Docker compatible API socket exposed by Podman.
 	Socket string `default:"/var/run/docker.sock"`
 
+	// Defines how GPUs are passed through to servers that request them.
+	Gpu DockerGpuConfiguration `yaml:"gpu"`
+
+	// The DNS servers used by containers, unless a server defines its own.
+	Dns []string `default:"[\"1.1.1.1\",\"8.8.8.8\"]" yaml:"dns"`
+
+	// Additional entries added to the hosts file of every container, in the format
+	// "hostname:ip".
+	ExtraHosts []string `yaml:"extra_hosts"`
+
+	// Namespaced kernel parameters set for every container, such as
+	// "net.ipv4.tcp_tw_reuse".
+	Sysctls map[string]string `yaml:"sysctls"`
+
+	// The kernel parameters that servers are allowed to set for themselves. Entries may
+	// contain "*" wildcards, for example "net.ipv4.*".
+	AllowedSysctls []string `yaml:"allowed_sysctls"`
+
+	// The confinement applied to server containers.
+	Security DockerSecurityConfiguration `yaml:"security"`
+
+	// Controls the additional mounts that servers can declare for their containers.
+	Mounts DockerMountConfiguration `yaml:"mounts"`
+
+	// If set to true servers are allowed to provide a Dockerfile that is built into a
+	// node local image rather than pulling an existing image.
+	AllowImageBuilds bool `default:"false" yaml:"allow_image_builds"`
+
+	// The container runtime used to run server processes. Supported values are "docker"
+	// and "podman", which is accessed using its Docker compatible API.
+	Runtime string `default:"docker" yaml:"runtime"`
+
 	// Defines the location of the timezone file on the host system that should
 	// be mounted into the created containers so that they all use the same time.
 	TimezonePath string `default:"/etc/timezone" yaml:"timezone_path"`
 }
 
+// Defines which additional mounts servers are allowed to declare. By default servers
+// can only use tmpfs mounts, bind mounts and volumes must be explicitly allowed.
+type DockerMountConfiguration struct {
+	// If set to true servers can mount a tmpfs ramdisk, for example to store world data
+	// in memory.
+	AllowTmpfs bool `default:"true" yaml:"allow_tmpfs"`
+
+	// The largest tmpfs mount a server can declare in megabytes.
+	MaxTmpfsSize int64 `default:"1024" yaml:"max_tmpfs_size"`
+
+	// The directories on the host that servers can bind mount, or mount a directory
+	// within. Entries may contain "*" wildcards.
+	AllowedSources []string `yaml:"allowed_sources"`
+
+	// If set to false bind mounts must be read-only, which is generally what is wanted for
+	// assets shared between servers.
+	AllowWritableBinds bool `default:"false" yaml:"allow_writable_binds"`
+
+	// The named volumes servers can mount. Entries may contain "*" wildcards.
+	AllowedVolumes []string `yaml:"allowed_volumes"`
+}
+
+// Defines the seccomp and AppArmor profiles, capabilities and privilege settings used
+// for server containers, along with how far individual servers may change them.
+type DockerSecurityConfiguration struct {
+	// The path to a seccomp profile on the host applied to every server container. If
+	// empty the default profile of the container runtime is used, and "unconfined"
+	// disables seccomp filtering entirely.
+	Seccomp string `yaml:"seccomp"`
+
+	// Named seccomp profiles that servers may select instead of the node default, mapped
+	// to the path of the profile on the host.
+	SeccompProfiles map[string]string `yaml:"seccomp_profiles"`
+
+	// The AppArmor profile applied to every server container. If empty the default
+	// profile of the container runtime is used.
+	AppArmor string `yaml:"apparmor"`
+
+	// The AppArmor profiles that servers may select instead of the node default. The
+	// profiles must already be loaded on the host.
+	AppArmorProfiles []string `yaml:"apparmor_profiles"`
+
+	// If true processes in server containers cannot gain additional privileges, for
+	// example through setuid binaries.
+	NoNewPrivileges bool `default:"true" yaml:"no_new_privileges"`
+
+	// The capabilities dropped from, and added to, every server container.
+	CapDrop []string `default:"[\"setpcap\",\"mknod\",\"audit_write\",\"net_raw\",\"dac_override\",\"fowner\",\"fsetid\",\"net_bind_service\",\"sys_chroot\",\"setfcap\"]" yaml:"cap_drop"`
+	CapAdd  []string `yaml:"cap_add"`
+
+	// The capabilities that servers are allowed to add for themselves. Servers can always
+	// drop additional capabilities.
+	AllowedCapabilities []string `yaml:"allowed_capabilities"`
+
+	// If true servers may disable seccomp filtering, AppArmor or no-new-privileges for
+	// their container. This should only be enabled on nodes where every server is trusted.
+	AllowUnconfined bool `default:"false" yaml:"allow_unconfined"`
+}
+
+// Defines the limits and publishing of port ranges allocated to servers, which are used
+// by voice servers that need hundreds of UDP ports.
+type PortRangeConfiguration struct {
+	// The largest number of ports a single range can contain. Larger ranges are ignored.
+	MaxSize int `default:"1000" yaml:"max_size"`
+
+	// Controls how ranges are published for servers using bridge networking. When set to
+	// "docker" every port is published by Docker, which creates rules and a proxy process
+	// for each port. When set to "nat" the daemon forwards each range to the container
+	// using a single firewall rule per protocol instead. Ranges on IPv6 addresses are
+	// always published by Docker.
+	Publish string `default:"docker" yaml:"publish"`
+}
+
+// Defines when images that are no longer used by servers on the node are removed.
+type ImageGcConfiguration struct {
+	// If set to true images that are no longer used by any server are removed by the
+	// daemon. Images used to install servers are never removed.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The number of minutes between each collection.
+	Interval int `default:"360" yaml:"interval"`
+
+	// The percentage of the disk used by the container runtime at which collection
+	// happens immediately rather than waiting for the next scheduled run. Set to 0 to
+	// only collect on the schedule.
+	DiskThreshold int `default:"85" yaml:"disk_threshold"`
+
+	// The minimum time in hours since an image was last pulled or used by the daemon
+	// before it can be removed. This avoids removing images that were just pulled for a
+	// server that is being installed.
+	MinAge int `default:"24" yaml:"min_age"`
+
+	// Images that are never removed, even if unused. Entries may contain "*" wildcards,
+	// for example "ghcr.io/pterodactyl/installers:*".
+	Keep []string `yaml:"keep"`
+}
+
+// Defines how containers that look like they were created for a server, but that belong
+// to a server the daemon is not managing, are handled. These are usually left behind by
+// an older version of the daemon, a server configuration that was removed by hand, or a
+// container created manually.
+type ContainerReconciliationConfiguration struct {
+	// Either "report" to only list the containers, "adopt" to load the configuration of
+	// their servers from the Panel and manage them again, or "quarantine" to stop and
+	// rename them. When adopting, containers for servers the Panel does not know about
+	// are quarantined. Set to "disabled" to ignore unknown containers.
+	Mode string `default:"report" yaml:"mode"`
+
+	// The number of minutes between each check for unknown containers after the check
+	// run when the daemon boots. Set to 0 to only check when booting.
+	Interval int `default:"60" yaml:"interval"`
+}
+
+// Defines how often the egg configuration of each server is fetched from the Panel, and
+// what happens when it no longer matches the configuration the daemon is using. Servers
+// always fetch their configuration when started, so this is what allows fixes made to an
+// egg in the Panel to reach servers that run for a long time.
+type EggSyncConfiguration struct {
+	// Either "report" to only record the servers that have changed, "apply" to update the
+	// servers with their new configuration, or "disabled" to never check.
+	Mode string `default:"report" yaml:"mode"`
+
+	// The number of minutes between each check.
+	Interval int `default:"360" yaml:"interval"`
+}
+
+// Defines the credentials used to authenticate against a container registry.
+type RegistryConfiguration struct {
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Defines how GPU devices are made available to server containers.
+type DockerGpuConfiguration struct {
+	// The container runtime used for servers that request NVIDIA GPUs. This runtime is
+	// provided by the NVIDIA container toolkit.
+	Runtime string `default:"nvidia" yaml:"runtime"`
+
+	// The driver capabilities made available to servers that request a GPU, used when
+	// the server build does not define any.
+	Capabilities []string `default:"[\"compute\",\"utility\"]" yaml:"capabilities"`
+
+	// Additional device paths on the host that are mapped into the containers of any
+	// servers requesting a GPU, for example "/dev/dri" for Intel and AMD devices.
+	DevicePaths []string `yaml:"device_paths"`
+}
+
 // Defines the configuration for the internal API that is exposed by the
 // daemon webserver.
 type ApiConfiguration struct {
-	// The interface that the internal webserver should bind to.
+	// The interface that the internal webserver should bind to. IPv6 addresses may
+	// optionally be wrapped in brackets, for example "[::]".
 	Host string `default:"0.0.0.0" yaml:"host"`
 
 	// The port that the internal webserver should bind to.
@@ -187,6 +1009,153 @@ type ApiConfiguration struct {
 
 	// The maximum size for files uploaded through the Panel in bytes.
 	UploadLimit int `default:"100" yaml:"upload_limit"`
+
+	// The number of hours a resumable upload is kept for after it last received a chunk,
+	// before the data received so far is removed.
+	UploadExpiry int `default:"24" yaml:"upload_expiry"`
+
+	// The maximum size in kilobytes of a file that can be opened in the inline file editor
+	// of the Panel. Larger files must be downloaded and uploaded instead.
+	EditLimit int64 `default:"1024" yaml:"edit_limit"`
+
+	// The limits applied when extracting archives for a server.
+	Archives ArchiveConfiguration `yaml:"archives"`
+
+	// Controls the files that servers can have the node download from a remote URL.
+	RemoteDownloads RemoteDownloadConfiguration `yaml:"remote_downloads"`
+
+	// Controls the direct download links for server files issued by the Panel.
+	Downloads DownloadConfiguration `yaml:"downloads"`
+
+	// Configuration for the Prometheus compatible metrics endpoint.
+	Metrics MetricsConfiguration `yaml:"metrics"`
+
+	// Configuration for the websocket connections made to servers.
+	Websocket WebsocketConfiguration `yaml:"websocket"`
+
+	// Configuration for the health endpoint of the daemon.
+	Health HealthConfiguration `yaml:"health"`
+}
+
+// Defines the limits that protect the node from archives that expand to far more data
+// than their size suggests.
+type ArchiveConfiguration struct {
+	// The largest combined size in megabytes of the files extracted from a single archive.
+	// Set to 0 to only limit extraction by the disk space of the server.
+	MaxExtractSize int64 `default:"10240" yaml:"max_extract_size"`
+
+	// The most files and directories that can be extracted from a single archive.
+	MaxEntries int `default:"100000" yaml:"max_entries"`
+}
+
+// Defines where servers can download files from, and the limits applied to each download.
+type RemoteDownloadConfiguration struct {
+	// If set to false servers cannot download files from a remote URL.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The domains files can be downloaded from. Entries may contain "*" wildcards, for
+	// example "*.curseforge.com". If empty files can be downloaded from any domain.
+	AllowedDomains []string `yaml:"allowed_domains"`
+
+	// If set to true files can be downloaded from loopback, private and link-local
+	// addresses. This allows servers to reach services on the node and its network, so it
+	// should only be enabled when every server is trusted.
+	AllowPrivateNetworks bool `default:"false" yaml:"allow_private_networks"`
+
+	// The largest file in megabytes that can be downloaded. Set to 0 to only limit the
+	// download by the disk space of the server.
+	MaxSize int64 `default:"0" yaml:"max_size"`
+
+	// The maximum speed of each download in kilobytes per second. Set to 0 to disable the
+	// limit.
+	MaxBandwidth int64 `default:"0" yaml:"max_bandwidth"`
+
+	// The number of minutes a download can take before it is cancelled.
+	Timeout int `default:"60" yaml:"timeout"`
+}
+
+// Defines how files are served through the direct download links issued by the Panel,
+// which let users download large files from the node without the Panel proxying them.
+type DownloadConfiguration struct {
+	// If set to false download links are refused and files can only be read through the
+	// Panel.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The maximum speed of each download in kilobytes per second. Set to 0 to disable the
+	// limit.
+	MaxBandwidth int64 `default:"0" yaml:"max_bandwidth"`
+}
+
+// Defines the thresholds used by the health endpoint to decide when a subsystem of the
+// daemon is degraded or unhealthy.
+type HealthConfiguration struct {
+	// If set the health endpoint can be requested without a token, so that it can be used
+	// by load balancers. Unauthenticated requests only receive the status of each
+	// subsystem, not the messages describing why it is in that state.
+	Public bool `default:"true" yaml:"public"`
+
+	// The number of seconds the result of the checks is reused for, so that frequent
+	// requests do not repeatedly contact Docker and the Panel.
+	CacheTtl int `default:"5" yaml:"cache_ttl"`
+
+	// The percentage of disk space used on the data directory or a storage pool at which
+	// the disk is reported as degraded, and as unhealthy.
+	DiskDegradedPercent  float64 `default:"90" yaml:"disk_degraded_percent"`
+	DiskUnhealthyPercent float64 `default:"97" yaml:"disk_unhealthy_percent"`
+
+	// The number of server events waiting to be delivered to listeners at which the event
+	// queue is reported as degraded, and as unhealthy.
+	EventBacklogDegraded  int64 `default:"1000" yaml:"event_backlog_degraded"`
+	EventBacklogUnhealthy int64 `default:"10000" yaml:"event_backlog_unhealthy"`
+}
+
+// Defines how messages are encoded for websocket connections to servers.
+type WebsocketConfiguration struct {
+	// If set the permessage-deflate extension is negotiated with clients that support it,
+	// which compresses the messages written to the connection.
+	Compression bool `default:"true" yaml:"compression"`
+
+	// The flate compression level used for compressed connections, from 1 to 9. Lower
+	// levels use less CPU, which matters more than the ratio for small console messages.
+	CompressionLevel int `default:"1" yaml:"compression_level"`
+
+	// If set clients may request the "wings.msgpack" subprotocol when connecting, in which
+	// case stats events are sent to them as MessagePack encoded binary frames instead of
+	// JSON text.
+	BinaryStats bool `default:"true" yaml:"binary_stats"`
+
+	// The number of connections to server websockets and event streams that can be opened
+	// per minute from a single IP address, and to a single server, along with the number
+	// that can be opened at once in a burst. Set a rate to 0 to disable that limit.
+	IpConnectionsPerMinute     int `default:"60" yaml:"ip_connections_per_minute"`
+	IpConnectionBurst          int `default:"20" yaml:"ip_connection_burst"`
+	ServerConnectionsPerMinute int `default:"600" yaml:"server_connections_per_minute"`
+	ServerConnectionBurst      int `default:"100" yaml:"server_connection_burst"`
+
+	// The maximum number of seconds of random delay added to the time clients that are
+	// refused are told to wait before reconnecting, so that clients which were all
+	// disconnected at once do not all reconnect at the same moment.
+	RetryJitter int `default:"30" yaml:"retry_jitter"`
+
+	// The IP addresses or CIDR ranges of reverse proxies in front of the daemon. For
+	// connections from these addresses the client address is taken from the
+	// X-Forwarded-For header when limiting connections, rather than every client behind
+	// the proxy sharing its limit.
+	TrustedProxies []string `yaml:"trusted_proxies"`
+}
+
+// Defines the configuration for the metrics endpoint exposed by the daemon.
+type MetricsConfiguration struct {
+	// If set to false the /metrics endpoint will not be registered.
+	Enabled bool `default:"true" yaml:"enabled"`
+
+	// The bearer token that must be provided to access the metrics. If no token is set
+	// the authentication token for the daemon is used instead.
+	Token string `yaml:"token"`
+
+	// A list of IP addresses or CIDR ranges that are allowed to access the metrics
+	// endpoint without providing a token.
+	AllowedIps []string `yaml:"allowed_ips"`
 }
 
 // Reads the configuration from the provided file and returns the configuration
@@ -239,6 +1208,10 @@ func Get() *Configuration {
 // If files are not owned by this user there will be issues with permissions on Docker
 // mount points.
 func (c *Configuration) EnsurePterodactylUser() (*user.User, error) {
+	if c.System.Rootless.Enabled {
+		return c.ensureRootlessUser()
+	}
+
 	u, err := user.Lookup(c.System.Username)
 
 	// If an error is returned but it isn't the unknown user error just abort
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"go.uber.org/zap"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// Defines how the daemon behaves when it is run as an unprivileged user alongside a
+// rootless Docker or Podman installation.
+type RootlessConfiguration struct {
+	// If set to true the daemon does not attempt to create a system user or perform any
+	// operations that require root. Server files are owned by the user running the
+	// daemon, and server processes run as root inside of their container which the
+	// runtime maps back to that same user on the host.
+	Enabled bool `default:"false" yaml:"enabled"`
+
+	// The user, and optionally group, that server processes run as inside of their
+	// containers. This should only be changed if the runtime is configured with a custom
+	// user namespace mapping.
+	ContainerUser string `default:"0:0" yaml:"container_user"`
+}
+
+// Uses the user running the daemon as the system user when running rootless, since an
+// unprivileged daemon cannot create or switch to another user.
+func (c *Configuration) ensureRootlessUser() (*user.User, error) {
+	u, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+
+	return u, c.setSystemUser(u)
+}
+
+// Disables the features that require root on the host when running rootless, logging
+// a warning for any that were enabled in the configuration.
+func (c *Configuration) ConfigureRootless() {
+	if !c.System.Rootless.Enabled {
+		return
+	}
+
+	if c.System.Firewall.Enabled {
+		zap.S().Warnw("disabling firewall management, it is not supported when running rootless")
+		c.System.Firewall.Enabled = false
+	}
+
+	if c.System.Flows.Enabled {
+		zap.S().Warnw("disabling flow collection, it is not supported when running rootless")
+		c.System.Flows.Enabled = false
+	}
+}
+
+// Returns the default location of the runtime socket for a rootless installation, which
+// lives in the runtime directory of the user rather than a system wide location.
+func RootlessSocket(runtime string) string {
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join("/run/user", strconv.Itoa(os.Getuid()))
+	}
+
+	if runtime == "podman" {
+		return filepath.Join(dir, "podman", "podman.sock")
+	}
+
+	return filepath.Join(dir, "docker.sock")
+}
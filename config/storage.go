@@ -0,0 +1,11 @@
+package config
+
+// Returns the directory of the named storage pool. An empty name, or the name of a pool
+// that is not defined, refers to the data directory of the node.
+func (sc *SystemConfiguration) StoragePoolPath(name string) string {
+	if p, ok := sc.StoragePools[name]; ok && name != "" {
+		return p
+	}
+
+	return sc.Data
+}
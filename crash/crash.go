@@ -0,0 +1,247 @@
+package crash
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The version of the daemon included with every report. This is set when the daemon
+// boots since the version is defined in the main package.
+var version = "unknown"
+
+// A single crash report. Reports never include the hostname, addresses, tokens or any
+// server details, only what is needed to work out where the daemon failed.
+type Report struct {
+	Id        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Version   string    `json:"version"`
+	Subsystem string    `json:"subsystem"`
+	Error     string    `json:"error"`
+	Stack     string    `json:"stack"`
+	// Fatal is true if the daemon exited as a result of this failure, rather than
+	// recovering from it.
+	Fatal       bool        `json:"fatal"`
+	Environment Environment `json:"environment"`
+}
+
+// Anonymized details about the system the daemon was running on.
+type Environment struct {
+	Os            string `json:"os"`
+	Architecture  string `json:"architecture"`
+	GoVersion     string `json:"go_version"`
+	KernelVersion string `json:"kernel_version"`
+	Cpus          int    `json:"cpus"`
+	Goroutines    int    `json:"goroutines"`
+}
+
+// Sets the version of the daemon reported with crashes.
+func SetVersion(v string) {
+	version = v
+}
+
+// Returns a zap option that reports any fatal level log entries. These are submitted
+// before the logger exits the process. Panics are not reported here since they are
+// captured when they are recovered from.
+func LoggerHook() zap.Option {
+	return zap.Hooks(func(e zapcore.Entry) error {
+		if e.Level != zapcore.FatalLevel {
+			return nil
+		}
+
+		Capture("main", e.Message, e.Stack, true)
+
+		return nil
+	})
+}
+
+// Captures any panic in the calling goroutine and reports it before allowing the panic
+// to continue. This should be deferred at the start of the main function.
+func Recover() {
+	if r := recover(); r != nil {
+		Capture("main", r, string(debug.Stack()), true)
+		panic(r)
+	}
+}
+
+// Creates a crash report for the failure and writes it to the disk, submitting it to the
+// configured endpoint as well if there is one. Fatal reports are submitted synchronously
+// since the daemon is about to exit, all others are submitted in the background. If crash
+// reporting is not enabled this is a no-op.
+func Capture(subsystem string, value interface{}, stack string, fatal bool) {
+	c := config.Get()
+	if c == nil || !c.System.CrashReports.Enabled {
+		return
+	}
+
+	cfg := c.System.CrashReports
+
+	r := Report{
+		Id:        newId(),
+		Time:      time.Now().UTC(),
+		Version:   version,
+		Subsystem: subsystem,
+		Error:     fmt.Sprintf("%v", value),
+		Stack:     stack,
+		Fatal:     fatal,
+		Environment: Environment{
+			Os:           runtime.GOOS,
+			Architecture: runtime.GOARCH,
+			GoVersion:    runtime.Version(),
+			Cpus:         runtime.NumCPU(),
+			Goroutines:   runtime.NumGoroutine(),
+		},
+	}
+
+	if k, err := kernel.GetKernelVersion(); err == nil {
+		r.Environment.KernelVersion = k.String()
+	}
+
+	if err := write(cfg.Directory, cfg.MaxReports, r); err != nil {
+		zap.S().Warnw("failed to write crash report to disk", zap.String("report", r.Id), zap.Error(err))
+	}
+
+	if cfg.Dsn == "" {
+		return
+	}
+
+	submit := func() {
+		if err := send(cfg.Dsn, r); err != nil {
+			zap.S().Warnw("failed to submit crash report", zap.String("report", r.Id), zap.Error(err))
+		}
+	}
+
+	if fatal {
+		submit()
+	} else {
+		go submit()
+	}
+}
+
+// Writes the report to the directory, removing the oldest reports if there are more than
+// the maximum allowed.
+func write(dir string, max int, r Report) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	name := r.Time.Format("20060102T150405") + "-" + r.Id + ".json"
+	if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if max <= 0 {
+		return nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// The file names begin with the time of the report, so sorting them also sorts
+	// them from oldest to newest.
+	sort.Strings(files)
+	for len(files) > max {
+		os.Remove(files[0])
+		files = files[1:]
+	}
+
+	return nil
+}
+
+// Submits the report to a Sentry compatible endpoint. The DSN is in the format
+// "https://<key>@<host>/<project>".
+func send(dsn string, r Report) error {
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil {
+		return errors.New("crash report dsn is not valid")
+	}
+
+	key := u.User.Username()
+	project := strings.Trim(u.Path, "/")
+
+	level := "error"
+	if r.Fatal {
+		level = "fatal"
+	}
+
+	b, err := json.Marshal(map[string]interface{}{
+		"event_id":  r.Id,
+		"timestamp": r.Time.Format(time.RFC3339),
+		"level":     level,
+		"platform":  "go",
+		"logger":    r.Subsystem,
+		"release":   r.Version,
+		"message":   r.Error,
+		"tags": map[string]string{
+			"subsystem": r.Subsystem,
+			"os":        r.Environment.Os,
+			"arch":      r.Environment.Architecture,
+		},
+		"contexts": map[string]interface{}{
+			"os":      map[string]string{"name": r.Environment.Os, "kernel_version": r.Environment.KernelVersion},
+			"runtime": map[string]string{"name": "go", "version": r.Environment.GoVersion},
+		},
+		"extra": map[string]interface{}{
+			"stack":      r.Stack,
+			"cpus":       r.Environment.Cpus,
+			"goroutines": r.Environment.Goroutines,
+		},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	endpoint := fmt.Sprintf("%s://%s/api/%s/store/", u.Scheme, u.Host, project)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(b))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=wings/%s, sentry_key=%s", r.Version, key))
+
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return errors.Errorf("crash report endpoint returned an unexpected status code: %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Returns a random identifier for a report, in the format expected by Sentry.
+func newId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
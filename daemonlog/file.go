@@ -0,0 +1,240 @@
+package daemonlog
+
+import (
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The name of the active daemon log within the log directory. Rotated files have the
+// time they were rotated appended to this name.
+const activeName = "wings.log"
+
+// A log file that rotates itself once it grows beyond the configured size or age. It
+// implements zapcore.WriteSyncer so that it can be used directly as a logging output.
+type File struct {
+	mu       sync.Mutex
+	path     string
+	cfg      config.LogConfiguration
+	f        *os.File
+	size     int64
+	openedAt time.Time
+}
+
+// Opens the active daemon log within the configured directory, creating the directory if
+// it does not exist. Any rotated files that have expired are removed.
+func Open(cfg config.LogConfiguration) (*File, error) {
+	return OpenFile(ActivePath(cfg.Directory), cfg)
+}
+
+// Opens a log file at the given path that is rotated using the configuration provided.
+// Rotated files are stored alongside the active file, and the directory from the
+// configuration is ignored.
+func OpenFile(p string, cfg config.LogConfiguration) (*File, error) {
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	lf := &File{path: p, cfg: cfg}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+
+	go lf.prune()
+
+	return lf, nil
+}
+
+// Returns the path to the active daemon log in the directory.
+func ActivePath(dir string) string {
+	return filepath.Join(dir, activeName)
+}
+
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+
+	lf.f = f
+	lf.size = st.Size()
+	lf.openedAt = time.Now()
+
+	return nil
+}
+
+// Writes a single log entry, rotating the file first if the entry would take it beyond
+// the maximum size or the file has reached its maximum age.
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.shouldRotate(int64(len(p))) {
+		if err := lf.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+
+	return n, err
+}
+
+// Closes the active log file.
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	return lf.f.Close()
+}
+
+// Flushes the active log to the disk.
+func (lf *File) Sync() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	return lf.f.Sync()
+}
+
+func (lf *File) shouldRotate(n int64) bool {
+	if lf.size == 0 {
+		return false
+	}
+
+	if lf.cfg.MaxSize > 0 && lf.size+n > int64(lf.cfg.MaxSize)*1024*1024 {
+		return true
+	}
+
+	return lf.cfg.MaxAge > 0 && time.Since(lf.openedAt) > time.Duration(lf.cfg.MaxAge)*time.Hour
+}
+
+// Moves the active log aside and opens a new one in its place. Compressing the rotated
+// file and removing expired files happens in the background so that logging is not held
+// up by it.
+func (lf *File) rotate() error {
+	if err := lf.f.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Milliseconds are included so that a burst of logging rotating the file more than
+	// once a second does not overwrite a previously rotated file.
+	rotated := lf.path + "." + time.Now().UTC().Format("20060102T150405.000")
+	if err := os.Rename(lf.path, rotated); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := lf.open(); err != nil {
+		return err
+	}
+
+	go func() {
+		if lf.cfg.Compress {
+			compress(rotated)
+		}
+
+		lf.prune()
+	}()
+
+	return nil
+}
+
+// Compresses a rotated log file, removing the original once the compressed copy has
+// been written. If compression fails the original is left in place.
+func compress(p string) error {
+	src, err := os.Open(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer src.Close()
+
+	f, err := os.OpenFile(p+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	w := gzip.NewWriter(f)
+	if _, err := io.Copy(w, src); err != nil {
+		f.Close()
+		os.Remove(p + ".gz")
+		return errors.WithStack(err)
+	}
+
+	if err := w.Close(); err != nil {
+		f.Close()
+		os.Remove(p + ".gz")
+		return errors.WithStack(err)
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(p + ".gz")
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Remove(p))
+}
+
+// Removes rotated files that are older than the retention period, and the oldest files
+// once there are more than the maximum number of rotated files.
+func (lf *File) prune() {
+	files := Rotated(lf.path)
+
+	if lf.cfg.Retention > 0 {
+		cutoff := time.Now().Add(-time.Duration(lf.cfg.Retention) * time.Hour * 24)
+
+		var kept []string
+		for _, p := range files {
+			if st, err := os.Stat(p); err == nil && st.ModTime().Before(cutoff) {
+				os.Remove(p)
+				continue
+			}
+
+			kept = append(kept, p)
+		}
+
+		files = kept
+	}
+
+	if lf.cfg.MaxFiles > 0 && len(files) > lf.cfg.MaxFiles {
+		for _, p := range files[:len(files)-lf.cfg.MaxFiles] {
+			os.Remove(p)
+		}
+	}
+}
+
+// Returns the rotated copies of the log file at the path, ordered from oldest to newest.
+// The compressed copy of a file that is part way through being compressed is skipped,
+// since the original is only removed once the compressed copy is complete.
+func Rotated(p string) []string {
+	matches, _ := filepath.Glob(p + ".*")
+
+	var out []string
+	for _, m := range matches {
+		if strings.HasSuffix(m, ".gz") {
+			if _, err := os.Stat(strings.TrimSuffix(m, ".gz")); err == nil {
+				continue
+			}
+		}
+
+		out = append(out, m)
+	}
+
+	// Rotated files are named using the time they were rotated, so a lexical sort also
+	// sorts them by age.
+	sort.Strings(out)
+
+	return out
+}
@@ -0,0 +1,162 @@
+package daemonlog
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// The layout of the timestamps written to the daemon log.
+const timeLayout = "2006-01-02T15:04:05.000Z0700"
+
+// Returns the encoder configuration used for the daemon log on the disk. Entries are
+// written as JSON so that they can be filtered by "wings logs".
+func EncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		MessageKey:     "message",
+		StacktraceKey:  "stacktrace",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+}
+
+// A single entry read back from the daemon log.
+type Entry struct {
+	Time    time.Time
+	Level   string
+	Message string
+	// All of the other fields logged with the entry, such as the server it is for.
+	Fields map[string]interface{}
+}
+
+// Defines the entries to return when reading the daemon log. Empty values match every
+// entry.
+type Filter struct {
+	Server string
+	Since  time.Time
+	Until  time.Time
+	// The minimum level of the entries to return, such as "warn".
+	Level string
+}
+
+// Determines if the entry matches the filter.
+func (f Filter) matches(e Entry) bool {
+	if f.Server != "" {
+		if s, _ := e.Fields["server"].(string); s != f.Server {
+			return false
+		}
+	}
+
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+
+	if f.Level == "" {
+		return true
+	}
+
+	var min, l zapcore.Level
+	if min.UnmarshalText([]byte(f.Level)) != nil || l.UnmarshalText([]byte(e.Level)) != nil {
+		return true
+	}
+
+	return l >= min
+}
+
+// Reads every entry in the rotated and active daemon logs within the directory that
+// matches the filter, from oldest to newest, calling fn for each one. Rotated files that
+// were rotated before the start of the filter are skipped without being read.
+func Read(dir string, f Filter, fn func(e Entry)) error {
+	return ScanLines(ActivePath(dir), f.Since, func(line []byte) {
+		var fields map[string]interface{}
+		// Skip over any lines that are not entries written by the daemon.
+		if err := json.Unmarshal(line, &fields); err != nil {
+			return
+		}
+
+		e := Entry{Fields: fields}
+		if v, ok := fields["time"].(string); ok {
+			e.Time, _ = time.Parse(timeLayout, v)
+		}
+
+		e.Level, _ = fields["level"].(string)
+		e.Message, _ = fields["message"].(string)
+
+		for _, k := range []string{"time", "level", "message"} {
+			delete(fields, k)
+		}
+
+		if f.matches(e) {
+			fn(e)
+		}
+	})
+}
+
+// Reads every line of the rotated and active copies of the log file at the path, from
+// oldest to newest, calling fn for each one. Compressed files are decompressed as they
+// are read, and rotated files that were rotated before the time given are skipped. The
+// line passed to fn is only valid until fn returns.
+func ScanLines(p string, since time.Time, fn func(line []byte)) error {
+	for _, f := range append(Rotated(p), p) {
+		if !since.IsZero() && f != p {
+			if st, err := os.Stat(f); err == nil && st.ModTime().Before(since) {
+				continue
+			}
+		}
+
+		if err := scanFile(f, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func scanFile(p string, fn func(line []byte)) error {
+	file, err := os.Open(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if strings.HasSuffix(p, ".gz") {
+		gr, err := gzip.NewReader(file)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read compressed log file %s", p)
+		}
+		defer gr.Close()
+
+		r = gr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+
+	return errors.WithStack(scanner.Err())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Handles the "wings drain" command which places the running daemon into drain mode
+// using the local API, and then reports the progress of the drain until it completes.
+func runDrainCommand(c *config.Configuration, args []string) error {
+	fs := flag.NewFlagSet("drain", flag.ExitOnError)
+	stop := fs.Bool("stop-servers", false, "gracefully stop all running servers on the node")
+	cancel := fs.Bool("cancel", false, "take the node out of drain mode")
+	status := fs.Bool("status", false, "only report the current drain status")
+	fs.Parse(args)
+
+	switch {
+	case *cancel:
+		_, err := drainRequest(c, http.MethodDelete, nil)
+		if err == nil {
+			fmt.Println("node is no longer draining")
+		}
+
+		return err
+	case *status:
+		st, err := drainRequest(c, http.MethodGet, nil)
+		if err == nil {
+			printDrainStatus(st)
+		}
+
+		return err
+	}
+
+	b, _ := json.Marshal(map[string]bool{"stop_servers": *stop})
+	if _, err := drainRequest(c, http.MethodPost, b); err != nil {
+		return err
+	}
+
+	fmt.Println("node is now draining, no new installations will be accepted")
+
+	for {
+		st, err := drainRequest(c, http.MethodGet, nil)
+		if err != nil {
+			return err
+		}
+
+		printDrainStatus(st)
+		if st.Complete || !st.Draining {
+			return nil
+		}
+
+		time.Sleep(time.Second * 5)
+	}
+}
+
+func printDrainStatus(st *server.DrainStatus) {
+	if !st.Draining {
+		fmt.Println("node is not draining")
+		return
+	}
+
+	if !st.StopServers {
+		fmt.Printf("node has been draining since %s\n", st.StartedAt.Local().Format(time.RFC1123))
+		return
+	}
+
+	fmt.Printf("stopped %d of %d servers (%d killed)\n", st.Stopped, st.Total, st.Killed)
+}
+
+// Sends a request to the drain endpoint of the daemon running on this machine.
+func drainRequest(c *config.Configuration, method string, body []byte) (*server.DrainStatus, error) {
+	b, err := daemonRequest(c, method, "/api/system/drain", body)
+	if err != nil {
+		return nil, err
+	}
+
+	st := &server.DrainStatus{}
+	if len(b) == 0 {
+		return st, nil
+	}
+
+	return st, errors.WithStack(json.Unmarshal(b, st))
+}
+
+// Sends a request to the API of the daemon running on this machine, returning the body
+// of the response. An error is returned if the daemon responds with an error status.
+func daemonRequest(c *config.Configuration, method string, path string, body []byte) ([]byte, error) {
+	host := net.JoinHostPort(config.LocalDialAddress(c.Api.Host), strconv.Itoa(c.Api.Port))
+
+	scheme := "http"
+	client := &http.Client{Timeout: time.Second * 15}
+	if c.Api.Ssl.Enabled {
+		scheme = "https"
+		// The certificate will be issued for the public hostname of the node, not the
+		// loopback address being used here.
+		client.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s://%s%s", scheme, host, path), bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+c.AuthenticationToken)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "could not connect to the daemon, is it running?")
+	}
+	defer res.Body.Close()
+
+	b, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode >= 400 {
+		return nil, errors.Errorf("daemon responded with %s: %s", res.Status, bytes.TrimSpace(b))
+	}
+
+	if res.StatusCode == http.StatusNoContent {
+		return nil, nil
+	}
+
+	return b, nil
+}
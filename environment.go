@@ -3,16 +3,17 @@ package main
 import (
 	"context"
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/api/types/network"
 	"github.com/docker/docker/client"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
 	"go.uber.org/zap"
+	"net"
 )
 
 // Configures the required network for the docker environment.
 func ConfigureDockerEnvironment(c *config.DockerConfiguration) error {
 	// Ensure the required docker network exists on the system.
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := server.NewRuntimeClient()
 	if err != nil {
 		return err
 	}
@@ -20,75 +21,39 @@ func ConfigureDockerEnvironment(c *config.DockerConfiguration) error {
 	resource, err := cli.NetworkInspect(context.Background(), c.Network.Name, types.NetworkInspectOptions{})
 	if err != nil && client.IsErrNotFound(err) {
 		zap.S().Infow("creating missing pterodactyl0 interface, this could take a few seconds...")
-		return createDockerNetwork(cli, c)
+		return server.CreateDockerNetwork(cli, c)
 	} else if err != nil {
 		zap.S().Fatalw("failed to create required docker network for containers", zap.Error(err))
 	}
 
-	switch resource.Driver {
-	case "host":
-		c.Network.Interface = "127.0.0.1"
-		c.Network.ISPN = false
-		return nil
-	case "overlay":
-	case "weavemesh":
-		c.Network.Interface = ""
-		c.Network.ISPN = true
+	// If the network no longer matches the configuration it is recreated, which is done
+	// right away if no servers are running on it.
+	if recreated, err := server.ReconcileDockerNetwork(cli, c, resource); err != nil {
+		zap.S().Errorw("failed to reconcile docker network with configuration", zap.Error(err))
+	} else if recreated {
 		return nil
-	default:
-		c.Network.ISPN = false
 	}
 
-	return nil
-}
-
-// Creates a new network on the machine if one does not exist already.
-func createDockerNetwork(cli *client.Client, c *config.DockerConfiguration) error {
-	_, err := cli.NetworkCreate(context.Background(), c.Network.Name, types.NetworkCreate{
-		Driver:     c.Network.Driver,
-		EnableIPv6: true,
-		Internal:   c.Network.IsInternal,
-		IPAM: &network.IPAM{
-			Config: []network.IPAMConfig{
-				{
-					Subnet:  c.Network.Interfaces.V4.Subnet,
-					Gateway: c.Network.Interfaces.V4.Gateway,
-				},
-				{
-					Subnet:  c.Network.Interfaces.V6.Subnet,
-					Gateway: c.Network.Interfaces.V6.Gateway,
-				},
-			},
-		},
-		Options: map[string]string{
-			"encryption": "false",
-			"com.docker.network.bridge.default_bridge":       "false",
-			"com.docker.network.bridge.enable_icc":           "true",
-			"com.docker.network.bridge.enable_ip_masquerade": "true",
-			"com.docker.network.bridge.host_binding_ipv4":    "0.0.0.0",
-			"com.docker.network.bridge.name":                 "pterodactyl0",
-			"com.docker.network.driver.mtu":                  "1500",
-		},
-	})
-
-	if err != nil {
-		return err
+	// Track the IPv6 gateway of the existing network so that it can be referenced in
+	// server configuration files.
+	for _, cfg := range resource.IPAM.Config {
+		if ip := net.ParseIP(cfg.Gateway); ip != nil && ip.To4() == nil {
+			c.Network.Interface6 = cfg.Gateway
+		}
 	}
 
-	switch c.Network.Driver {
+	switch resource.Driver {
 	case "host":
 		c.Network.Interface = "127.0.0.1"
 		c.Network.ISPN = false
-		break
+		return nil
 	case "overlay":
 	case "weavemesh":
 		c.Network.Interface = ""
 		c.Network.ISPN = true
-		break
+		return nil
 	default:
-		c.Network.Interface = c.Network.Interfaces.V4.Gateway
 		c.Network.ISPN = false
-		break
 	}
 
 	return nil
@@ -0,0 +1,248 @@
+package firewall
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// The chain that Docker provides for user defined rules that are evaluated before any
+// of the rules Docker creates itself.
+const dockerUserChain = "DOCKER-USER"
+
+// The chain evaluated for traffic delivered to the host itself, which is where traffic
+// for servers using host networking ends up.
+const inputChain = "INPUT"
+
+// Defines the flood protection limits applied to a single allocation.
+type Rule struct {
+	Ip   string
+	Port int
+
+	// The last port of the allocation if it is a range of ports starting at Port, and the
+	// protocol of the range. The limits apply to the range as a whole. Both protocols are
+	// matched if no protocol is set.
+	EndPort  int
+	Protocol string
+
+	// The maximum number of tracked connections to the allocation across all sources.
+	// New connections above this limit are dropped.
+	MaxConnections int
+
+	// The number of new UDP flows per second that a single source address can create to
+	// the allocation, along with the burst that is allowed above that rate.
+	UdpNewPerSecond int
+	UdpBurst        int
+
+	// If set to true traffic to the allocation that is within the limits is accepted.
+	// This is required for servers using host networking, as Docker does not open the
+	// ports for them.
+	Accept bool
+}
+
+// Returns the name of the chain containing the rules for a server. The name is derived
+// from a hash of the full UUID of the server, truncated to fit within the 28 character
+// limit iptables places on chain names.
+func chainName(server string) string {
+	return "WINGS-" + hash(server)[:16]
+}
+
+// Returns the name of the hash table used to rate limit traffic to a port of a server.
+// Older kernels limit the name of the hash table to 15 characters, so it is derived from
+// a hash of the server and port rather than the chain name.
+func hashTableName(server string, port int) string {
+	return "w" + hash(server + ":" + strconv.Itoa(port))[:14]
+}
+
+// Returns the hex encoded SHA-256 hash of the value.
+func hash(v string) string {
+	sum := sha256.Sum256([]byte(v))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// Programs the flood protection rules for a server into the host firewall, replacing
+// any rules that previously existed for it. Rules are stored in a chain dedicated to the
+// server which is jumped to from the DOCKER-USER chain, or from the INPUT chain if the
+// server is using host networking.
+func Apply(server string, rules []Rule, host bool) error {
+	if len(rules) == 0 {
+		return Remove(server)
+	}
+
+	chain := chainName(server)
+
+	parent := dockerUserChain
+	if host {
+		parent = inputChain
+	}
+
+	for _, bin := range []string{"iptables", "ip6tables"} {
+		var args [][]string
+		for _, r := range rules {
+			if isIpv6(r.Ip) == (bin == "ip6tables") {
+				args = append(args, ruleArgs(chain, server, r)...)
+			}
+		}
+
+		// Always reset the chain, even if there are no rules for this address family,
+		// so that stale rules are not left behind.
+		if err := resetChain(bin, chain, parent); err != nil {
+			return err
+		}
+
+		for _, a := range args {
+			if err := run(bin, a...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Removes all of the flood protection rules for a server from the host firewall.
+func Remove(server string) error {
+	chain := chainName(server)
+
+	for _, bin := range []string{"iptables", "ip6tables"} {
+		if run(bin, "-n", "-L", chain) != nil {
+			continue
+		}
+
+		unlink(bin, chain, dockerUserChain)
+		unlink(bin, chain, inputChain)
+
+		if err := run(bin, "-F", chain); err != nil {
+			return err
+		}
+
+		if err := run(bin, "-X", chain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Creates the chain if it does not exist, flushes it, and ensures that it is jumped to
+// from only the parent chain. A server that switches between host and bridge networking
+// must not be left with a jump from the chain it was previously using.
+func resetChain(bin string, chain string, parent string) error {
+	if run(bin, "-n", "-L", chain) != nil {
+		if err := run(bin, "-N", chain); err != nil {
+			return err
+		}
+	} else if err := run(bin, "-F", chain); err != nil {
+		return err
+	}
+
+	for _, p := range []string{dockerUserChain, inputChain} {
+		if p != parent {
+			unlink(bin, chain, p)
+		}
+	}
+
+	if run(bin, "-C", parent, "-j", chain) != nil {
+		return run(bin, "-I", parent, "-j", chain)
+	}
+
+	return nil
+}
+
+// Removes every jump to the chain from the parent. There should only ever be one, but a
+// failed cleanup in the past could have left duplicates behind.
+func unlink(bin string, chain string, parent string) {
+	for run(bin, "-D", parent, "-j", chain) == nil {
+	}
+}
+
+// Returns the arguments for each of the rules needed to enforce the limits on an
+// allocation. Connections are matched using their original destination since Docker
+// has already translated the destination address by the time they reach DOCKER-USER.
+func ruleArgs(chain string, server string, r Rule) [][]string {
+	port := strconv.Itoa(r.Port)
+	if r.EndPort > r.Port {
+		port += ":" + strconv.Itoa(r.EndPort)
+	}
+
+	protocols := []string{"tcp", "udp"}
+	if r.Protocol != "" {
+		protocols = []string{r.Protocol}
+	}
+
+	match := []string{"-m", "conntrack", "--ctstate", "NEW", "--ctorigdstport", port}
+	if r.Ip != "" && r.Ip != "0.0.0.0" && r.Ip != "::" {
+		match = append(match, "--ctorigdst", r.Ip)
+	}
+
+	var out [][]string
+
+	if r.MaxConnections > 0 {
+		for _, proto := range protocols {
+			a := append([]string{"-A", chain, "-p", proto}, match...)
+			a = append(a, "-m", "connlimit", "--connlimit-above", strconv.Itoa(r.MaxConnections), "--connlimit-mask", "0", "-j", "DROP")
+
+			out = append(out, a)
+		}
+	}
+
+	if r.UdpNewPerSecond > 0 && (r.Protocol == "" || r.Protocol == "udp") {
+		burst := r.UdpBurst
+		if burst <= 0 {
+			burst = r.UdpNewPerSecond
+		}
+
+		a := append([]string{"-A", chain, "-p", "udp"}, match...)
+		a = append(
+			a,
+			"-m", "hashlimit",
+			"--hashlimit-above", fmt.Sprintf("%d/sec", r.UdpNewPerSecond),
+			"--hashlimit-burst", strconv.Itoa(burst),
+			"--hashlimit-mode", "srcip",
+			"--hashlimit-name", hashTableName(server, r.Port),
+			"-j", "DROP",
+		)
+
+		out = append(out, a)
+	}
+
+	if r.Accept {
+		for _, proto := range protocols {
+			a := []string{"-A", chain, "-p", proto, "--dport", port}
+			if r.Ip != "" && r.Ip != "0.0.0.0" && r.Ip != "::" {
+				a = append(a, "-d", r.Ip)
+			}
+
+			out = append(out, append(a, "-j", "ACCEPT"))
+		}
+	}
+
+	return out
+}
+
+func isIpv6(ip string) bool {
+	p := net.ParseIP(strings.Trim(ip, "[]"))
+
+	return p != nil && p.To4() == nil
+}
+
+// Runs a firewall command, returning the output of the command in the error if it fails.
+func run(bin string, args ...string) error {
+	var stderr bytes.Buffer
+
+	cmd := exec.Command(bin, append([]string{"-w"}, args...)...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		return errors.Wrap(err, fmt.Sprintf("%s %s: %s", bin, strings.Join(args, " "), strings.TrimSpace(stderr.String())))
+	}
+
+	return nil
+}
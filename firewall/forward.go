@@ -0,0 +1,147 @@
+package firewall
+
+import (
+	"strconv"
+)
+
+// The chains that the forwarding rules are jumped to from. PREROUTING handles traffic
+// arriving from other machines, OUTPUT handles traffic from the host itself, and the
+// DOCKER chain of the filter table is where Docker accepts traffic to published ports.
+const (
+	preroutingChain = "PREROUTING"
+	outputChain     = "OUTPUT"
+	dockerChain     = "DOCKER"
+)
+
+// Forwards a range of ports on the host to the same ports on a container.
+type Forward struct {
+	Ip       string
+	Protocol string
+	Start    int
+	End      int
+	// The address of the container the ports are forwarded to.
+	Target string
+}
+
+// Returns the name of the chains containing the forwarding rules for a server. Like the
+// chain for the other rules of the server, the name is derived from a hash of the full
+// UUID so that two servers sharing the start of their UUID never share a chain.
+func forwardChainName(server string) string {
+	return "WINGSNAT-" + hash(server)[:16]
+}
+
+// Programs the rules forwarding port ranges on the host to a server container, replacing
+// any rules that previously existed for it. Each range is forwarded using a single rule
+// per protocol, rather than the rule per port that Docker creates when publishing ports.
+// Only IPv4 is supported, as Docker does not use NAT for IPv6 by default.
+func ApplyForwards(server string, forwards []Forward) error {
+	if len(forwards) == 0 {
+		return RemoveForwards(server)
+	}
+
+	chain := forwardChainName(server)
+
+	if err := resetForwardChains(chain); err != nil {
+		return err
+	}
+
+	for _, f := range forwards {
+		ports := strconv.Itoa(f.Start) + ":" + strconv.Itoa(f.End)
+
+		protocols := []string{"tcp", "udp"}
+		if f.Protocol != "" {
+			protocols = []string{f.Protocol}
+		}
+
+		for _, proto := range protocols {
+			dnat := []string{"-t", "nat", "-A", chain, "-p", proto}
+			if f.Ip != "" && f.Ip != "0.0.0.0" {
+				dnat = append(dnat, "-d", f.Ip)
+			}
+			dnat = append(dnat, "--dport", ports, "-j", "DNAT", "--to-destination", f.Target)
+
+			if err := run("iptables", dnat...); err != nil {
+				return err
+			}
+
+			if err := run("iptables", "-A", chain, "-p", proto, "-d", f.Target, "--dport", ports, "-j", "ACCEPT"); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Removes the rules forwarding port ranges to a server container.
+func RemoveForwards(server string) error {
+	chain := forwardChainName(server)
+
+	if run("iptables", "-t", "nat", "-n", "-L", chain) == nil {
+		for _, p := range forwardJumps(chain) {
+			for run("iptables", append([]string{"-t", "nat", "-D", p[0]}, p[1:]...)...) == nil {
+			}
+		}
+
+		if err := run("iptables", "-t", "nat", "-F", chain); err != nil {
+			return err
+		}
+
+		if err := run("iptables", "-t", "nat", "-X", chain); err != nil {
+			return err
+		}
+	}
+
+	if run("iptables", "-n", "-L", chain) == nil {
+		unlink("iptables", chain, dockerChain)
+
+		if err := run("iptables", "-F", chain); err != nil {
+			return err
+		}
+
+		if err := run("iptables", "-X", chain); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Creates the chains in the nat and filter tables if they do not exist, flushes them,
+// and ensures they are jumped to. The filter chain is jumped to from the DOCKER chain so
+// that the rules in DOCKER-USER, including the flood protection rules, still apply.
+func resetForwardChains(chain string) error {
+	for _, table := range []string{"nat", "filter"} {
+		if run("iptables", "-t", table, "-n", "-L", chain) != nil {
+			if err := run("iptables", "-t", table, "-N", chain); err != nil {
+				return err
+			}
+		} else if err := run("iptables", "-t", table, "-F", chain); err != nil {
+			return err
+		}
+	}
+
+	for _, p := range forwardJumps(chain) {
+		if run("iptables", append([]string{"-t", "nat", "-C", p[0]}, p[1:]...)...) != nil {
+			if err := run("iptables", append([]string{"-t", "nat", "-I", p[0]}, p[1:]...)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	if run("iptables", "-C", dockerChain, "-j", chain) != nil {
+		return run("iptables", "-I", dockerChain, "-j", chain)
+	}
+
+	return nil
+}
+
+// Returns the chain and rule of each jump to the nat chain. Only traffic addressed to the
+// host is forwarded, and traffic from the host to the loopback address is left alone
+// just as Docker does for published ports.
+func forwardJumps(chain string) [][]string {
+	return [][]string{
+		{preroutingChain, "-m", "addrtype", "--dst-type", "LOCAL", "-j", chain},
+		{outputChain, "!", "-d", "127.0.0.0/8", "-m", "addrtype", "--dst-type", "LOCAL", "-j", chain},
+	}
+}
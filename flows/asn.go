@@ -0,0 +1,91 @@
+package flows
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A range of addresses announced by a single autonomous system.
+type asnRange struct {
+	start net.IP
+	end   net.IP
+	asn   int
+	name  string
+}
+
+// A database mapping addresses to the autonomous system announcing them.
+type AsnDatabase struct {
+	ranges []asnRange
+}
+
+// Loads an ASN database from a tab separated file in the format used by iptoasn.com,
+// where each line contains the start and end of a range, the AS number, the country
+// code and the description of the AS.
+func LoadAsnDatabase(path string) (*AsnDatabase, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	db := &AsnDatabase{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) < 3 {
+			continue
+		}
+
+		start, end := net.ParseIP(fields[0]), net.ParseIP(fields[1])
+		asn, err := strconv.Atoi(fields[2])
+		// Ranges that are not announced by any AS are recorded with an AS number of 0.
+		if start == nil || end == nil || err != nil || asn == 0 {
+			continue
+		}
+
+		r := asnRange{start: start.To16(), end: end.To16(), asn: asn}
+		if len(fields) >= 5 {
+			r.name = fields[4]
+		}
+
+		db.ranges = append(db.ranges, r)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sort.Slice(db.ranges, func(i, j int) bool {
+		return bytes.Compare(db.ranges[i].start, db.ranges[j].start) < 0
+	})
+
+	return db, nil
+}
+
+// Returns the AS number and description for the given address. If the address is not
+// found in the database an AS number of 0 is returned.
+func (db *AsnDatabase) Lookup(ip net.IP) (int, string) {
+	if db == nil {
+		return 0, ""
+	}
+
+	ip = ip.To16()
+
+	// Find the last range that starts at or before the address.
+	i := sort.Search(len(db.ranges), func(i int) bool {
+		return bytes.Compare(db.ranges[i].start, ip) > 0
+	}) - 1
+
+	if i < 0 || bytes.Compare(db.ranges[i].end, ip) < 0 {
+		return 0, ""
+	}
+
+	return db.ranges[i].asn, db.ranges[i].name
+}
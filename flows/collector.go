@@ -0,0 +1,265 @@
+package flows
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/metrics"
+	"go.uber.org/zap"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// An allocation that flows are attributed to. The IP may be unspecified (0.0.0.0) in
+// which case any flow to the port is attributed to the server.
+type Allocation struct {
+	Server string
+	Ip     net.IP
+	Port   int
+}
+
+// The number of connections from a single remote address.
+type RemoteAddress struct {
+	Ip          string `json:"ip"`
+	Asn         int    `json:"asn,omitempty"`
+	Connections int    `json:"connections"`
+}
+
+// The number of connections from a single autonomous system.
+type RemoteAsn struct {
+	Asn         int    `json:"asn"`
+	Name        string `json:"name"`
+	Connections int    `json:"connections"`
+}
+
+// The flow statistics for a single server at a point in time.
+type Snapshot struct {
+	Connections      int             `json:"connections"`
+	Tcp              int             `json:"tcp"`
+	Udp              int             `json:"udp"`
+	PacketsPerSecond float64         `json:"packets_per_second"`
+	TopAddresses     []RemoteAddress `json:"top_addresses"`
+	TopAsns          []RemoteAsn     `json:"top_asns"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+}
+
+// Collects the flows for every server allocation on the node at a regular interval.
+type Collector struct {
+	// Returns all of the allocations that flows should be attributed to.
+	Allocations func() []Allocation
+
+	// The number of remote addresses and autonomous systems to report for each server.
+	Top int
+
+	// An optional database used to resolve the AS of remote addresses.
+	Asn *AsnDatabase
+
+	// The maximum number of entries read from the connection tracking table on each
+	// collection, or 0 to read all of them.
+	MaxEntries int
+
+	truncated bool
+
+	mu        sync.RWMutex
+	snapshots map[string]*Snapshot
+	packets   map[string]uint64
+	last      time.Time
+}
+
+// Returns the most recent snapshot for the server, or nil if there is not one.
+func (c *Collector) Get(server string) *Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.snapshots[server]
+}
+
+// Returns the most recent snapshot for every server with active flows.
+func (c *Collector) All() map[string]*Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	m := make(map[string]*Snapshot, len(c.snapshots))
+	for k, v := range c.snapshots {
+		m[k] = v
+	}
+
+	return m
+}
+
+// Collects flows at the given interval. Errors reading the connection tracking table are
+// logged and collection continues, waiting longer after each consecutive failure. The
+// wait is never less than ten times as long as the last collection took, so that reading
+// a very large table only ever uses a small fraction of the time of a single CPU.
+func (c *Collector) Run(interval time.Duration) error {
+	wait := interval
+
+	for {
+		start := time.Now()
+
+		if err := c.Collect(); err != nil {
+			zap.S().Warnw("failed to collect network flows", zap.Duration("retry_in", wait), zap.Error(err))
+
+			if wait *= 2; wait > time.Minute*5 {
+				wait = time.Minute * 5
+			}
+		} else {
+			wait = interval
+		}
+
+		d := wait
+		if took := time.Since(start) * 10; took > d {
+			d = took
+		}
+
+		time.Sleep(d)
+	}
+}
+
+type aggregate struct {
+	snapshot  *Snapshot
+	packets   uint64
+	addresses map[string]int
+}
+
+// Reads the current flows from the kernel and updates the snapshot for every server.
+func (c *Collector) Collect() error {
+	flows, truncated, err := readConntrack(conntrackPath, c.MaxEntries)
+	if err != nil {
+		return errors.Wrap(err, "failed to read connection tracking table")
+	}
+
+	if truncated && !c.truncated {
+		zap.S().Warnw("connection tracking table has more entries than are read, some flows are not counted", zap.Int("max_entries", c.MaxEntries))
+	}
+	c.truncated = truncated
+
+	now := time.Now()
+	ports := make(map[int][]Allocation)
+	for _, a := range c.Allocations() {
+		ports[a.Port] = append(ports[a.Port], a)
+	}
+
+	aggregates := make(map[string]*aggregate)
+	for _, f := range flows {
+		server := match(ports[f.Dport], f.Dst)
+		if server == "" {
+			continue
+		}
+
+		a, ok := aggregates[server]
+		if !ok {
+			a = &aggregate{snapshot: &Snapshot{UpdatedAt: now}, addresses: make(map[string]int)}
+			aggregates[server] = a
+		}
+
+		a.snapshot.Connections++
+		if f.Protocol == "tcp" {
+			a.snapshot.Tcp++
+		} else {
+			a.snapshot.Udp++
+		}
+
+		a.packets += f.Packets
+		a.addresses[f.Src.String()]++
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elapsed := now.Sub(c.last).Seconds()
+	packets := make(map[string]uint64, len(aggregates))
+	snapshots := make(map[string]*Snapshot, len(aggregates))
+
+	for server, a := range aggregates {
+		// The packet counters only cover flows that are still being tracked, so the total
+		// can decrease between runs when flows expire. Only report increases.
+		if prev, ok := c.packets[server]; ok && !c.last.IsZero() && a.packets > prev {
+			a.snapshot.PacketsPerSecond = float64(a.packets-prev) / elapsed
+		}
+
+		c.summarize(a)
+
+		packets[server] = a.packets
+		snapshots[server] = a.snapshot
+	}
+
+	// Reset the metrics for any servers that no longer have any flows.
+	for server := range c.snapshots {
+		if _, ok := snapshots[server]; !ok {
+			publish(server, &Snapshot{})
+		}
+	}
+
+	for server, s := range snapshots {
+		publish(server, s)
+	}
+
+	c.snapshots = snapshots
+	c.packets = packets
+	c.last = now
+
+	return nil
+}
+
+// Builds the top remote addresses and autonomous systems for the aggregate.
+func (c *Collector) summarize(a *aggregate) {
+	asns := make(map[int]*RemoteAsn)
+
+	for ip, n := range a.addresses {
+		asn, name := c.Asn.Lookup(net.ParseIP(ip))
+		a.snapshot.TopAddresses = append(a.snapshot.TopAddresses, RemoteAddress{Ip: ip, Asn: asn, Connections: n})
+
+		if asn == 0 {
+			continue
+		}
+
+		if _, ok := asns[asn]; !ok {
+			asns[asn] = &RemoteAsn{Asn: asn, Name: name}
+		}
+		asns[asn].Connections += n
+	}
+
+	for _, v := range asns {
+		a.snapshot.TopAsns = append(a.snapshot.TopAsns, *v)
+	}
+
+	sort.Slice(a.snapshot.TopAddresses, func(i, j int) bool {
+		return a.snapshot.TopAddresses[i].Connections > a.snapshot.TopAddresses[j].Connections
+	})
+
+	sort.Slice(a.snapshot.TopAsns, func(i, j int) bool {
+		return a.snapshot.TopAsns[i].Connections > a.snapshot.TopAsns[j].Connections
+	})
+
+	if len(a.snapshot.TopAddresses) > c.Top {
+		a.snapshot.TopAddresses = a.snapshot.TopAddresses[:c.Top]
+	}
+
+	if len(a.snapshot.TopAsns) > c.Top {
+		a.snapshot.TopAsns = a.snapshot.TopAsns[:c.Top]
+	}
+}
+
+// Returns the server that a flow to the given destination belongs to. Allocations bound
+// to the exact destination address take priority over those bound to every address.
+func match(allocations []Allocation, dst net.IP) string {
+	var fallback string
+	for _, a := range allocations {
+		if a.Ip.Equal(dst) {
+			return a.Server
+		}
+
+		if a.Ip == nil || a.Ip.IsUnspecified() {
+			fallback = a.Server
+		}
+	}
+
+	return fallback
+}
+
+func publish(server string, s *Snapshot) {
+	metrics.ServerConnections.Set(float64(s.Tcp), server, "tcp")
+	metrics.ServerConnections.Set(float64(s.Udp), server, "udp")
+	metrics.ServerPacketsPerSecond.Set(s.PacketsPerSecond, server)
+}
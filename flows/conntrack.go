@@ -0,0 +1,97 @@
+package flows
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The location of the connection tracking table exposed by the kernel.
+const conntrackPath = "/proc/net/nf_conntrack"
+
+// A single flow tracked by the kernel. Only the original direction of the flow is used,
+// which for inbound connections to a server is the remote client connecting to the
+// allocation on the host.
+type Flow struct {
+	Protocol string
+	Src      net.IP
+	Dst      net.IP
+	Dport    int
+
+	// The number of packets seen in both directions of the flow. This is only available
+	// if connection tracking accounting is enabled (net.netfilter.nf_conntrack_acct).
+	Packets uint64
+}
+
+// Reads the TCP and UDP flows currently being tracked by the kernel. At most max entries
+// of the table are read if max is greater than 0, in which case true is returned if there
+// were more entries than that.
+func readConntrack(path string, max int) ([]Flow, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, false, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	var flows []Flow
+
+	scanner := bufio.NewScanner(f)
+	for n := 0; scanner.Scan(); n++ {
+		if max > 0 && n >= max {
+			return flows, true, nil
+		}
+
+		if flow, ok := parseConntrackLine(scanner.Text()); ok {
+			flows = append(flows, flow)
+		}
+	}
+
+	return flows, false, errors.WithStack(scanner.Err())
+}
+
+// Parses a single line of the conntrack table, which looks similar to the following:
+//
+// ipv4 2 tcp 6 431999 ESTABLISHED src=1.2.3.4 dst=5.6.7.8 sport=51234 dport=25565 packets=10 bytes=1000 src=...
+//
+// The first set of keys describe the original direction of the flow, and the second set
+// describe the reply direction.
+func parseConntrackLine(line string) (Flow, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || (fields[2] != "tcp" && fields[2] != "udp") {
+		return Flow{}, false
+	}
+
+	flow := Flow{Protocol: fields[2]}
+	seen := make(map[string]int)
+
+	for _, field := range fields[3:] {
+		i := strings.Index(field, "=")
+		if i == -1 {
+			continue
+		}
+
+		k, v := field[:i], field[i+1:]
+		seen[k]++
+
+		switch {
+		case k == "src" && seen[k] == 1:
+			flow.Src = net.ParseIP(v)
+		case k == "dst" && seen[k] == 1:
+			flow.Dst = net.ParseIP(v)
+		case k == "dport" && seen[k] == 1:
+			flow.Dport, _ = strconv.Atoi(v)
+		case k == "packets":
+			n, _ := strconv.ParseUint(v, 10, 64)
+			flow.Packets += n
+		}
+	}
+
+	if flow.Src == nil || flow.Dst == nil || flow.Dport == 0 {
+		return Flow{}, false
+	}
+
+	return flow, true
+}
@@ -0,0 +1,89 @@
+package gpu
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/pkg/errors"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Defines a single GPU device that was detected on the host system.
+type Device struct {
+	// The vendor of the device, either "nvidia" for devices managed by the NVIDIA
+	// driver, or "dri" for other devices exposed using a DRI render node.
+	Vendor string `json:"vendor"`
+	Index  int    `json:"index"`
+	Uuid   string `json:"uuid,omitempty"`
+	Name   string `json:"name"`
+
+	// The total memory of the device in megabytes, if known.
+	Memory int64 `json:"memory,omitempty"`
+
+	// The path to the device on the host, only set for DRI devices.
+	Path string `json:"path,omitempty"`
+}
+
+// Detects the GPUs available on the host system. NVIDIA devices are detected using the
+// nvidia-smi tool, if it is installed, and any other devices are detected using the DRI
+// render nodes in /dev/dri.
+func Detect() ([]Device, error) {
+	out, err := detectNvidia()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes, _ := filepath.Glob("/dev/dri/renderD*")
+	for i, n := range nodes {
+		out = append(out, Device{
+			Vendor: "dri",
+			Index:  i,
+			Name:   filepath.Base(n),
+			Path:   n,
+		})
+	}
+
+	return out, nil
+}
+
+// Returns the NVIDIA devices available on the system. If nvidia-smi is not installed
+// no devices are returned.
+func detectNvidia() ([]Device, error) {
+	if _, err := exec.LookPath("nvidia-smi"); err != nil {
+		return nil, nil
+	}
+
+	b, err := exec.Command("nvidia-smi", "--query-gpu=index,uuid,name,memory.total", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to query nvidia-smi for devices")
+	}
+
+	var out []Device
+
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		parts := strings.Split(s.Text(), ",")
+		if len(parts) != 4 {
+			continue
+		}
+
+		for i := range parts {
+			parts[i] = strings.TrimSpace(parts[i])
+		}
+
+		index, _ := strconv.Atoi(parts[0])
+		memory, _ := strconv.ParseInt(parts[3], 10, 64)
+
+		out = append(out, Device{
+			Vendor: "nvidia",
+			Index:  index,
+			Uuid:   parts[1],
+			Name:   parts[2],
+			Memory: memory,
+		})
+	}
+
+	return out, errors.WithStack(s.Err())
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/metrics"
+	"github.com/pterodactyl/wings/server"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The possible states of the daemon and each of its subsystems, from best to worst. A
+// degraded subsystem still works, but may be slow or close to failing.
+const (
+	HealthOk        = "ok"
+	HealthDegraded  = "degraded"
+	HealthUnhealthy = "unhealthy"
+)
+
+// The time allowed for each check that contacts another service.
+const healthCheckTimeout = time.Second * 5
+
+// Checks that take longer than this are reported as degraded even if they succeed.
+const healthSlowThreshold = time.Second * 2
+
+// The status of a single subsystem of the daemon.
+type SubsystemHealth struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// The time taken to run the check in milliseconds.
+	Latency int64 `json:"latency_ms"`
+}
+
+// The health of the daemon, which is the worst status of any of its subsystems.
+type HealthReport struct {
+	Status    string                     `json:"status"`
+	Version   string                     `json:"version"`
+	CheckedAt time.Time                  `json:"checked_at"`
+	Checks    map[string]SubsystemHealth `json:"checks"`
+}
+
+// The client used to check that Docker can be reached, which is created the first time it
+// is needed and reused for every check after.
+var dockerHealthClient = struct {
+	sync.Mutex
+	cli *client.Client
+}{}
+
+func healthClient() (*client.Client, error) {
+	dockerHealthClient.Lock()
+	defer dockerHealthClient.Unlock()
+
+	if dockerHealthClient.cli == nil {
+		cli, err := server.NewRuntimeClient()
+		if err != nil {
+			return nil, err
+		}
+
+		dockerHealthClient.cli = cli
+	}
+
+	return dockerHealthClient.cli, nil
+}
+
+var healthCache = struct {
+	sync.Mutex
+	report *HealthReport
+}{}
+
+// Returns the health of the daemon, reusing the last result if it was checked within
+// the configured cache time.
+func GetHealthReport() HealthReport {
+	healthCache.Lock()
+	defer healthCache.Unlock()
+
+	ttl := time.Second * time.Duration(config.Get().Api.Health.CacheTtl)
+	if healthCache.report != nil && time.Since(healthCache.report.CheckedAt) < ttl {
+		return *healthCache.report
+	}
+
+	r := checkHealth()
+	healthCache.report = &r
+
+	return r
+}
+
+// Runs every health check at once and combines the results.
+func checkHealth() HealthReport {
+	checks := map[string]func() SubsystemHealth{
+		"docker": checkDockerHealth,
+		"panel":  checkPanelHealth,
+		"disk":   checkDiskHealth,
+		"sftp":   checkSftpHealth,
+		"events": checkEventsHealth,
+	}
+
+	r := HealthReport{
+		Status:    HealthOk,
+		Version:   Version,
+		CheckedAt: time.Now().UTC(),
+		Checks:    make(map[string]SubsystemHealth, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+
+		go func(name string, check func() SubsystemHealth) {
+			defer wg.Done()
+
+			start := time.Now()
+			h := check()
+			h.Latency = int64(time.Since(start) / time.Millisecond)
+
+			mu.Lock()
+			r.Checks[name] = h
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	for name, h := range r.Checks {
+		r.Status = worseHealth(r.Status, h.Status)
+
+		metrics.HealthStatus.Set(float64(healthRank(h.Status)), name)
+	}
+
+	return r
+}
+
+func healthRank(status string) int {
+	switch status {
+	case HealthDegraded:
+		return 1
+	case HealthUnhealthy:
+		return 2
+	default:
+		return 0
+	}
+}
+
+// Returns the worse of the two statuses.
+func worseHealth(a string, b string) string {
+	if healthRank(b) > healthRank(a) {
+		return b
+	}
+
+	return a
+}
+
+// Returns the status of a check that took the given time to succeed.
+func latencyHealth(d time.Duration) SubsystemHealth {
+	if d > healthSlowThreshold {
+		return SubsystemHealth{Status: HealthDegraded, Message: fmt.Sprintf("responded slowly, took %s", d.Round(time.Millisecond))}
+	}
+
+	return SubsystemHealth{Status: HealthOk}
+}
+
+// Checks that the Docker daemon can be reached. Servers cannot be started, stopped or
+// monitored without it, so the daemon is unhealthy if it cannot be.
+func checkDockerHealth() SubsystemHealth {
+	cli, err := healthClient()
+	if err != nil {
+		return SubsystemHealth{Status: HealthUnhealthy, Message: err.Error()}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := cli.Ping(ctx); err != nil {
+		return SubsystemHealth{Status: HealthUnhealthy, Message: err.Error()}
+	}
+
+	return latencyHealth(time.Since(start))
+}
+
+// Checks that the Panel can be reached. Running servers are not affected while the Panel
+// is unavailable, but servers cannot be installed or configured, and users cannot be
+// authenticated, so the daemon is only degraded.
+func checkPanelHealth() SubsystemHealth {
+	req, err := http.NewRequest(http.MethodGet, config.Get().PanelLocation, nil)
+	if err != nil {
+		return SubsystemHealth{Status: HealthDegraded, Message: err.Error()}
+	}
+
+	start := time.Now()
+	res, err := (&http.Client{Timeout: healthCheckTimeout}).Do(req)
+	if err != nil {
+		return SubsystemHealth{Status: HealthDegraded, Message: err.Error()}
+	}
+	res.Body.Close()
+
+	if res.StatusCode >= http.StatusInternalServerError {
+		return SubsystemHealth{Status: HealthDegraded, Message: "panel responded with " + res.Status}
+	}
+
+	return latencyHealth(time.Since(start))
+}
+
+// Checks the space used on the data directory and each storage pool, reporting the
+// fullest of them.
+func checkDiskHealth() SubsystemHealth {
+	c := config.Get()
+	hc := c.Api.Health
+
+	paths := []string{c.System.Data}
+	for _, p := range c.System.StoragePools {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths[1:])
+
+	h := SubsystemHealth{Status: HealthOk}
+	var fullest float64
+	for _, p := range paths {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(p, &st); err != nil {
+			return SubsystemHealth{Status: HealthUnhealthy, Message: fmt.Sprintf("failed to read usage of %s: %s", p, err.Error())}
+		}
+
+		if st.Blocks == 0 {
+			continue
+		}
+
+		used := 100 - float64(st.Bavail)/float64(st.Blocks)*100
+		if used <= fullest {
+			continue
+		}
+
+		fullest = used
+		h.Message = fmt.Sprintf("%s is %.1f%% full", p, used)
+
+		switch {
+		case used >= hc.DiskUnhealthyPercent:
+			h.Status = HealthUnhealthy
+		case used >= hc.DiskDegradedPercent:
+			h.Status = HealthDegraded
+		default:
+			h.Status = HealthOk
+		}
+	}
+
+	return h
+}
+
+// Checks that the internal SFTP server is accepting connections. Servers keep running
+// without it, so the daemon is only degraded if it is not.
+func checkSftpHealth() SubsystemHealth {
+	c := config.Get().System.Sftp
+	if !c.UseInternalSystem {
+		return SubsystemHealth{Status: HealthOk, Message: "internal sftp server is disabled"}
+	}
+
+	addr := net.JoinHostPort(config.LocalDialAddress(c.Address), strconv.Itoa(c.Port))
+
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", addr, healthCheckTimeout)
+	if err != nil {
+		return SubsystemHealth{Status: HealthDegraded, Message: err.Error()}
+	}
+	conn.Close()
+
+	return latencyHealth(time.Since(start))
+}
+
+// Checks the number of server events waiting to be delivered to listeners, which grows
+// when websocket connections or other listeners stop keeping up.
+func checkEventsHealth() SubsystemHealth {
+	hc := config.Get().Api.Health
+	n := server.PendingEvents()
+
+	h := SubsystemHealth{Status: HealthOk, Message: fmt.Sprintf("%d events waiting to be delivered", n)}
+	switch {
+	case hc.EventBacklogUnhealthy > 0 && n >= hc.EventBacklogUnhealthy:
+		h.Status = HealthUnhealthy
+	case hc.EventBacklogDegraded > 0 && n >= hc.EventBacklogDegraded:
+		h.Status = HealthDegraded
+	}
+
+	return h
+}
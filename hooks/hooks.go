@@ -0,0 +1,150 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// The lifecycle events that hooks can be registered for.
+const (
+	PreStart       = "pre-start"
+	PostStart      = "post-start"
+	PreStop        = "pre-stop"
+	PostCrash      = "post-crash"
+	BackupComplete = "backup-complete"
+	Integrity      = "integrity-violation"
+	OomKill        = "oom-kill"
+)
+
+// The details about an event that are passed along to a hook.
+type Event struct {
+	Event  string            `json:"event"`
+	Time   time.Time         `json:"time"`
+	Server string            `json:"server"`
+	State  string            `json:"state"`
+	Data   map[string]string `json:"data,omitempty"`
+}
+
+// Runs all of the hooks registered for the event. Hooks for "pre" events are run before
+// this function returns so that they are able to prepare for the action, such as warming
+// a cache before the server starts. All other hooks are run in the background. Failing
+// hooks are logged, but never prevent the action from taking place.
+func Fire(e Event) {
+	c := config.Get()
+	if c == nil || len(c.System.Hooks) == 0 {
+		return
+	}
+
+	e.Time = time.Now().UTC()
+
+	for _, h := range c.System.Hooks {
+		if !handles(h, e.Event) {
+			continue
+		}
+
+		if strings.HasPrefix(e.Event, "pre-") {
+			run(h, e)
+			continue
+		}
+
+		go func(h config.HookConfiguration) {
+			defer supervisor.Recover("hooks")
+
+			run(h, e)
+		}(h)
+	}
+}
+
+// Determines if the hook is registered for the event.
+func handles(h config.HookConfiguration, event string) bool {
+	for _, v := range h.Events {
+		if v == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+func run(h config.HookConfiguration, e Event) {
+	timeout := time.Duration(h.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = time.Second * 30
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	if len(h.Command) > 0 {
+		if err := runCommand(ctx, h.Command, e, b); err != nil {
+			zap.S().Warnw("lifecycle hook script failed", zap.String("event", e.Event), zap.String("server", e.Server), zap.String("command", h.Command[0]), zap.Error(err))
+		}
+	}
+
+	if h.Url != "" {
+		if err := sendWebhook(ctx, h.Url, b); err != nil {
+			zap.S().Warnw("lifecycle hook webhook failed", zap.String("event", e.Event), zap.String("server", e.Server), zap.Error(err))
+		}
+	}
+}
+
+// Executes the hook script, passing the event details as environment variables and as
+// JSON on stdin.
+func runCommand(ctx context.Context, command []string, e Event, payload []byte) error {
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Env = append(
+		os.Environ(),
+		"WINGS_EVENT="+e.Event,
+		"WINGS_SERVER_UUID="+e.Server,
+		"WINGS_SERVER_STATE="+e.State,
+	)
+
+	for k, v := range e.Data {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("WINGS_%s=%s", strings.ToUpper(k), v))
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return errors.Wrapf(err, "output: %s", strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// Sends the event details to the webhook as a JSON POST request.
+func sendWebhook(ctx context.Context, url string, payload []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package hoststat
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Returns the total memory of the host in megabytes, read from /proc/meminfo.
+func Memory() (int64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemTotal:" {
+			continue
+		}
+
+		// The value is always reported in kilobytes.
+		kb, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		return kb / 1024, nil
+	}
+
+	return 0, errors.New("total memory not found in /proc/meminfo")
+}
+
+// Returns the total size of the filesystem containing the path in megabytes.
+func Disk(p string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(p, &st); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return int64(st.Blocks) * int64(st.Bsize) / 1024 / 1024, nil
+}
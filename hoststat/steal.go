@@ -0,0 +1,114 @@
+package hoststat
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/metrics"
+	"github.com/pterodactyl/wings/supervisor"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The interval at which the CPU time of the host is sampled.
+const sampleInterval = time.Second * 10
+
+var steal = struct {
+	sync.RWMutex
+	percent float64
+}{}
+
+// Returns the percentage of CPU time that was stolen by the hypervisor from the host
+// during the last sampling interval. On bare metal hosts this is always 0, a consistently
+// high value means that the virtual machine is competing with others for CPU time.
+func Steal() float64 {
+	steal.RLock()
+	defer steal.RUnlock()
+
+	return steal.percent
+}
+
+// Starts sampling the CPU steal time of the host in the background.
+func StartStealMonitor() {
+	go supervisor.Supervise("cpu steal monitor", func() error {
+		prev, err := readCpuTimes()
+		if err != nil {
+			return err
+		}
+
+		ticker := time.NewTicker(sampleInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			cur, err := readCpuTimes()
+			if err != nil {
+				return err
+			}
+
+			percent := 0.0
+			if total := cur.total - prev.total; total > 0 && cur.steal >= prev.steal {
+				percent = math.Round(float64(cur.steal-prev.steal)/float64(total)*100*1000) / 1000
+			}
+
+			steal.Lock()
+			steal.percent = percent
+			steal.Unlock()
+
+			metrics.HostCpuSteal.Set(percent)
+
+			prev = cur
+		}
+
+		return nil
+	})
+}
+
+type cpuTimes struct {
+	total uint64
+	steal uint64
+}
+
+// Reads the aggregate CPU times for the host from /proc/stat. The first line contains
+// the time spent in each state, in the order: user, nice, system, idle, iowait, irq,
+// softirq, steal, guest and guest_nice.
+func readCpuTimes() (cpuTimes, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || fields[0] != "cpu" {
+			continue
+		}
+
+		var t cpuTimes
+		// The guest times are already included in the user and nice times, so they are
+		// not added to the total.
+		for i, field := range fields[1:] {
+			if i >= 8 {
+				break
+			}
+
+			v, _ := strconv.ParseUint(field, 10, 64)
+			t.total += v
+			if i == 7 {
+				t.steal = v
+			}
+		}
+
+		return t, nil
+	}
+
+	if err := scanner.Err(); err != nil {
+		return cpuTimes{}, errors.WithStack(err)
+	}
+
+	return cpuTimes{}, errors.New("could not find aggregate cpu times in /proc/stat")
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
 	"fmt"
@@ -9,8 +8,10 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
 	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/installer"
+	"github.com/pterodactyl/wings/metrics"
 	"github.com/pterodactyl/wings/server"
 	"go.uber.org/zap"
 	"io"
@@ -149,8 +150,22 @@ func (rt *Router) routeServerPower(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
+	// Power actions defined by the egg of the server run against the running process
+	// rather than changing the state of the server.
 	if !action.IsValid() {
-		http.NotFound(w, r)
+		if _, ok := s.PowerAction(action.Action); !ok {
+			http.NotFound(w, r)
+			return
+		}
+
+		if err := s.RunPowerAction(action.Action); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		audit.Log(audit.PowerAction, audit.PanelActor, s.Uuid, map[string]string{"action": action.Action})
+
+		w.WriteHeader(http.StatusAccepted)
 		return
 	}
 
@@ -165,6 +180,17 @@ func (rt *Router) routeServerPower(w http.ResponseWriter, r *http.Request, ps ht
 		return
 	}
 
+	// The quota is checked again when the server boots, but checking it here as well lets
+	// the Panel show why the server did not start.
+	if action.Action == "start" && !server.IsRunningState(s.State) {
+		if err := s.CheckStart(); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	audit.Log(audit.PowerAction, audit.PanelActor, s.Uuid, map[string]string{"action": action.Action})
+
 	// Pass the actual heavy processing off to a seperate thread to handle so that
 	// we can immediately return a response from the server.
 	go func(a string, s *server.Server) {
@@ -180,6 +206,7 @@ func (rt *Router) routeServerPower(w http.ResponseWriter, r *http.Request, ps ht
 			}
 			break
 		case "stop":
+			s.SetStopReason(server.StopReasonStop, "stopped through the api")
 			if err := s.Environment.Stop(); err != nil {
 				zap.S().Errorw(
 					"encountered unexpected error stopping server process",
@@ -192,6 +219,7 @@ func (rt *Router) routeServerPower(w http.ResponseWriter, r *http.Request, ps ht
 		case "restart":
 			break
 		case "kill":
+			s.SetStopReason(server.StopReasonKill, "killed through the api")
 			if err := s.Environment.Terminate(os.Kill); err != nil {
 				zap.S().Errorw(
 					"encountered unexpected error killing server process",
@@ -260,7 +288,6 @@ func (rt *Router) routeServerFileRead(w http.ResponseWriter, r *http.Request, ps
 	defer f.Close()
 
 	w.Header().Set("X-Mime-Type", st.Mimetype)
-	w.Header().Set("Content-Length", strconv.Itoa(int(st.Info.Size())))
 
 	// If a download parameter is included in the URL go ahead and attach the necessary headers
 	// so that the file can be downloaded.
@@ -269,14 +296,25 @@ func (rt *Router) routeServerFileRead(w http.ResponseWriter, r *http.Request, ps
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
 
-	bufio.NewReader(f).WriteTo(w)
+	// Range requests are supported so that large files can be downloaded in parts.
+	http.ServeContent(w, r, st.Info.Name(), st.Info.ModTime(), f)
 }
 
-// Lists the contents of a directory.
+// Lists the contents of a directory. If any of the pagination, sorting, filtering, or
+// streaming parameters are passed through the paginated listing is used, otherwise every
+// entry in the directory is returned.
 func (rt *Router) routeServerListDirectory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	s := rt.GetServer(ps.ByName("server"))
+	q := r.URL.Query()
 
-	stats, err := s.Filesystem.ListDirectory(r.URL.Query().Get("directory"))
+	for _, k := range []string{"page", "per_page", "sort", "filter", "stream"} {
+		if _, ok := q[k]; ok {
+			rt.routeServerListDirectoryPage(w, r, s)
+			return
+		}
+	}
+
+	stats, err := s.Filesystem.ListDirectory(q.Get("directory"))
 	if os.IsNotExist(err) {
 		http.NotFound(w, r)
 		return
@@ -290,21 +328,100 @@ func (rt *Router) routeServerListDirectory(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(stats)
 }
 
+// Returns a filtered and sorted page of the contents of a directory. If "stream" is
+// passed each entry is instead written as a newline delimited JSON object as soon as it
+// is available, which allows clients to process huge directories without waiting for
+// the entire listing to be generated.
+func (rt *Router) routeServerListDirectoryPage(w http.ResponseWriter, r *http.Request, s *server.Server) {
+	q := r.URL.Query()
+	d := q.Get("directory")
+
+	opts := server.ListDirectoryOptions{
+		Filter:     q.Get("filter"),
+		Sort:       strings.TrimPrefix(q.Get("sort"), "-"),
+		Descending: strings.HasPrefix(q.Get("sort"), "-"),
+	}
+	opts.Page, _ = strconv.Atoi(q.Get("page"))
+	opts.PerPage, _ = strconv.Atoi(q.Get("per_page"))
+	if opts.PerPage <= 0 || opts.PerPage > server.MaxListingPerPage {
+		opts.PerPage = server.MaxListingPerPage
+	}
+
+	if q.Get("stream") != "" {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+
+		enc := json.NewEncoder(w)
+		f, _ := w.(http.Flusher)
+
+		i := 0
+		err := s.Filesystem.StreamDirectory(d, opts, func(st *server.Stat) error {
+			if err := enc.Encode(st); err != nil {
+				return err
+			}
+
+			if i++; f != nil && i%100 == 0 {
+				f.Flush()
+			}
+
+			return nil
+		})
+
+		if os.IsNotExist(err) && i == 0 {
+			http.NotFound(w, r)
+		} else if err != nil {
+			zap.S().Warnw("failed to stream contents of directory", zap.String("server", s.Uuid), zap.String("path", d), zap.Error(err))
+		}
+
+		return
+	}
+
+	stats, total, err := s.Filesystem.ListDirectoryPage(d, opts)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		zap.S().Errorw("failed to list contents of directory", zap.String("server", s.Uuid), zap.String("path", d), zap.Error(err))
+
+		http.Error(w, "failed to list directory", http.StatusInternalServerError)
+		return
+	}
+
+	if opts.Page < 1 {
+		opts.Page = 1
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": stats,
+		"meta": map[string]int{
+			"page":     opts.Page,
+			"per_page": opts.PerPage,
+			"total":    total,
+		},
+	})
+}
+
 // Writes a file to the system for the server.
 func (rt *Router) routeServerWriteFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	s := rt.GetServer(ps.ByName("server"))
 
 	p := r.URL.Query().Get("file")
 	defer r.Body.Close()
-	err := s.Filesystem.Writefile(p, r.Body)
+	err := s.WriteFile(p, r.Body)
 
 	if err != nil {
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		zap.S().Errorw("failed to write file to directory", zap.String("server", s.Uuid), zap.String("path", p), zap.Error(err))
 
 		http.Error(w, "failed to write file to directory", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(audit.FileWrite, audit.PanelActor, s.Uuid, map[string]string{"file": p})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -328,12 +445,19 @@ func (rt *Router) routeServerCreateDirectory(w http.ResponseWriter, r *http.Requ
 	}
 
 	if err := s.Filesystem.CreateDirectory(data.Name, data.Path); err != nil {
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		zap.S().Errorw("failed to create directory for server", zap.String("server", s.Uuid), zap.Error(err))
 
 		http.Error(w, "an error was encountered while creating the directory", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(audit.DirectoryCreate, audit.PanelActor, s.Uuid, map[string]string{"name": data.Name, "path": data.Path})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -351,12 +475,19 @@ func (rt *Router) routeServerRenameFile(w http.ResponseWriter, r *http.Request,
 	}
 
 	if err := s.Filesystem.Rename(oldPath, newPath); err != nil {
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		zap.S().Errorw("failed to rename file on server", zap.String("server", s.Uuid), zap.Error(err))
 
 		http.Error(w, "an error occurred while renaming the file", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(audit.FileRename, audit.PanelActor, s.Uuid, map[string]string{"from": oldPath, "to": newPath})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -368,12 +499,19 @@ func (rt *Router) routeServerCopyFile(w http.ResponseWriter, r *http.Request, ps
 	loc, _ := jsonparser.GetString(data, "location")
 
 	if err := s.Filesystem.Copy(loc); err != nil {
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
 		zap.S().Errorw("error copying file for server", zap.String("server", s.Uuid), zap.Error(err))
 
 		http.Error(w, "an error occurred while copying the file", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(audit.FileCopy, audit.PanelActor, s.Uuid, map[string]string{"location": loc})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -385,12 +523,24 @@ func (rt *Router) routeServerDeleteFile(w http.ResponseWriter, r *http.Request,
 	loc, _ := jsonparser.GetString(data, "location")
 
 	if err := s.Filesystem.Delete(loc); err != nil {
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if server.IsTrashError(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
 		zap.S().Errorw("failed to delete a file or directory for server", zap.String("server", s.Uuid), zap.Error(err))
 
 		http.Error(w, "an error occurred while trying to delete a file or directory", http.StatusInternalServerError)
 		return
 	}
 
+	audit.Log(audit.FileDelete, audit.PanelActor, s.Uuid, map[string]string{"location": loc})
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -410,11 +560,66 @@ func (rt *Router) routeServerSendCommand(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	for _, command := range commands {
-		if err := s.Environment.SendCommand(string(command)); err != nil {
+	var cmds []string
+	macro := false
+	jsonparser.ArrayEach(commands, func(v []byte, _ jsonparser.ValueType, _ int, _ error) {
+		cmds = append(cmds, string(v))
+		macro = macro || strings.HasPrefix(string(v), server.MacroPrefix)
+	})
+
+	rt.sendCommands(w, s, cmds, macro, nil)
+}
+
+// Sends the commands to the server on behalf of the Panel and writes the response. Any
+// additional audit data is recorded alongside each of the commands.
+func (rt *Router) sendCommands(w http.ResponseWriter, s *server.Server, cmds []string, macro bool, data map[string]string) {
+	auditData := func(command string, via string) map[string]string {
+		m := map[string]string{"command": command}
+		if via != "" {
+			m["via"] = via
+		}
+
+		for k, v := range data {
+			m[k] = v
+		}
+
+		return m
+	}
+
+	// If the egg defines an RCON connection the commands are executed through it so that
+	// the response from the game can be returned. Macros are always expanded by the daemon
+	// and never sent through RCON.
+	if s.HasRcon() && !macro {
+		responses, err := s.ExecuteRcon(cmds)
+		if err == nil {
+			for _, command := range cmds {
+				s.RecordCommand(audit.PanelActor, command)
+				audit.Log(audit.ConsoleCommand, audit.PanelActor, s.Uuid, auditData(command, "rcon"))
+			}
+
+			json.NewEncoder(w).Encode(struct {
+				Responses []string `json:"responses"`
+			}{Responses: responses})
+			return
+		}
+
+		// Commands that were already executed must not be sent a second time.
+		if len(responses) > 0 {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		zap.S().Warnw("failed to send commands using rcon, falling back to the console", zap.String("server", s.Uuid), zap.Error(err))
+	}
+
+	for _, command := range cmds {
+		if err := s.SendCommand(command); err != nil {
 			zap.S().Warnw("failed to send command to server", zap.Any("command", command), zap.Error(err))
 			return
 		}
+
+		s.RecordCommand(audit.PanelActor, command)
+		audit.Log(audit.ConsoleCommand, audit.PanelActor, s.Uuid, auditData(command, ""))
 	}
 
 	w.WriteHeader(http.StatusNoContent)
@@ -424,6 +629,20 @@ func (rt *Router) routeServerInstall(w http.ResponseWriter, r *http.Request, ps
 	s := rt.GetServer(ps.ByName("server"))
 	defer r.Body.Close()
 
+	if rt.rejectIfDraining(w) {
+		return
+	}
+
+	// Step based installations resume from the last failed step by default, passing
+	// "resume": false forces every step to be run again.
+	if resume, err := jsonparser.GetBoolean(rt.ReaderToBytes(r.Body), "resume"); err == nil && !resume {
+		if err := s.ResetInstallCheckpoints(); err != nil {
+			zap.S().Warnw("failed to reset installation checkpoints for server", zap.String("server", s.Uuid), zap.Error(err))
+		}
+	}
+
+	audit.Log(audit.ServerInstall, audit.PanelActor, s.Uuid, nil)
+
 	go func (serv *server.Server) {
 		if err := serv.Install(); err != nil {
 			zap.S().Errorw("failed to execute server installation process", zap.String("server", s.Uuid), zap.Error(err))
@@ -433,6 +652,26 @@ func (rt *Router) routeServerInstall(w http.ResponseWriter, r *http.Request, ps
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// Rebuilds the local image for a server that provides its own Dockerfile. This happens
+// in the background since builds can take a considerable amount of time.
+func (rt *Router) routeServerRebuildImage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	if s.Container.Dockerfile == "" {
+		http.Error(w, "server does not use a locally built image", http.StatusBadRequest)
+		return
+	}
+
+	go func(serv *server.Server) {
+		if err := serv.RebuildImage(); err != nil {
+			zap.S().Errorw("failed to rebuild server image", zap.String("server", serv.Uuid), zap.Error(err))
+		}
+	}(s)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
 func (rt *Router) routeServerUpdate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	s := rt.GetServer(ps.ByName("server"))
 	defer r.Body.Close()
@@ -445,12 +684,18 @@ func (rt *Router) routeServerUpdate(w http.ResponseWriter, r *http.Request, ps h
 		return
 	}
 
+	audit.Log(audit.ServerUpdate, audit.PanelActor, s.Uuid, nil)
+
 	w.WriteHeader(http.StatusNoContent)
 }
 
 func (rt *Router) routeCreateServer(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	defer r.Body.Close()
 
+	if rt.rejectIfDraining(w) {
+		return
+	}
+
 	inst, err := installer.New(rt.ReaderToBytes(r.Body))
 
 	if err != nil {
@@ -460,10 +705,17 @@ func (rt *Router) routeCreateServer(w http.ResponseWriter, r *http.Request, ps h
 		return
 	}
 
+	if err := inst.Server().CheckCreateAdmission(); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
 	// Plop that server instance onto the request so that it can be referenced in
 	// requests from here-on out.
 	server.GetServers().Add(inst.Server())
 
+	audit.Log(audit.ServerCreate, audit.PanelActor, inst.Uuid(), nil)
+
 	zap.S().Infow("beginning installation process for server", zap.String("server", inst.Uuid()))
 	// Begin the installation process in the background to not block the request
 	// cycle. If there are any errors they will be logged and communicated back
@@ -502,6 +754,8 @@ func (rt *Router) routeServerDelete(w http.ResponseWriter, r *http.Request, ps h
 	s.Suspended = true
 
 	zap.S().Infow("processing server deletion request", zap.String("server", s.Uuid))
+	audit.Log(audit.ServerDelete, audit.PanelActor, s.Uuid, nil)
+
 	// Destroy the environment; in Docker this will handle a running container and
 	// forcibly terminate it before removing the container, so we do not need to handle
 	// that here.
@@ -529,6 +783,52 @@ func (rt *Router) routeServerDelete(w http.ResponseWriter, r *http.Request, ps h
 		return s2.Uuid == uuid
 	})
 
+	metrics.DeleteServer(uuid)
+
+	s.Filesystem.StopDiskAccounting()
+
+	if err := s.Filesystem.EmptyTrash(); err != nil {
+		zap.S().Warnw("failed to remove server trash on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveProcessCache(); err != nil {
+		zap.S().Warnw("failed to remove cached process configuration on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveSchedules(); err != nil {
+		zap.S().Warnw("failed to remove server schedules on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveConfigurationJournal(); err != nil {
+		zap.S().Warnw("failed to remove server configuration journal on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveIntegrityBaseline(); err != nil {
+		zap.S().Warnw("failed to remove server integrity baseline on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveConsoleLog(); err != nil {
+		zap.S().Warnw("failed to remove server console log on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveSnapshots(); err != nil {
+		zap.S().Warnw("failed to remove server snapshots on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveStatsHistory(); err != nil {
+		zap.S().Warnw("failed to remove server stats history on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	if err := s.RemoveConsoleTransforms(); err != nil {
+		zap.S().Warnw("failed to remove server console transforms on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
+	s.RemoveFileOperations()
+
+	if err := s.RemoveUploads(); err != nil {
+		zap.S().Warnw("failed to remove server uploads on deletion", zap.String("server", uuid), zap.Error(err))
+	}
+
 	s = nil
 
 	// Remove the configuration file stored on the Daemon for this server.
@@ -541,6 +841,33 @@ func (rt *Router) routeServerDelete(w http.ResponseWriter, r *http.Request, ps h
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// Returns a paginated set of entries from the node's audit log. Results can be filtered
+// to a single server or action using the query parameters.
+func (rt *Router) routeAuditLog(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+
+	q := audit.Query{
+		Server:  r.URL.Query().Get("server"),
+		Action:  r.URL.Query().Get("action"),
+		Page:    page,
+		PerPage: perPage,
+	}.Normalize()
+
+	entries, total, err := audit.Read(q)
+	if err != nil {
+		zap.S().Errorw("failed to read audit log", zap.Error(err))
+
+		http.Error(w, "failed to read audit log", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": entries,
+		"meta": map[string]int{"page": q.Page, "total": total},
+	})
+}
+
 func (rt *Router) ReaderToBytes(r io.Reader) []byte {
 	buf := bytes.Buffer{}
 	buf.ReadFrom(r)
@@ -550,31 +877,122 @@ func (rt *Router) ReaderToBytes(r io.Reader) []byte {
 
 // Configures the router and all of the associated routes.
 func (rt *Router) ConfigureRouter() *httprouter.Router {
-	router := httprouter.New()
+	router := &routeRecorder{Router: httprouter.New()}
 
 	router.OPTIONS("/api/system", func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		rt.AttachAccessControlHeaders(w, r, ps)
 	})
 
 	router.GET("/", rt.routeIndex)
+	if config.Get().Api.Metrics.Enabled {
+		router.GET("/metrics", rt.AuthenticateMetrics(rt.routeMetrics))
+	}
 	router.GET("/api/system", rt.AuthenticateToken(rt.routeSystemInformation))
+	router.GET("/api/system/health", rt.routeSystemHealth)
+	router.GET("/api/system/drain", rt.AuthenticateToken(rt.routeDrainStatus))
+	router.POST("/api/system/drain", rt.AuthenticateToken(rt.routeDrainStart))
+	router.DELETE("/api/system/drain", rt.AuthenticateToken(rt.routeDrainStop))
+	router.GET("/api/system/flows", rt.AuthenticateToken(rt.routeSystemFlows))
+	router.GET("/api/system/ports", rt.AuthenticateToken(rt.routeSystemPorts))
+	router.GET("/api/system/storage", rt.AuthenticateToken(rt.routeStorageUsage))
+	router.GET("/api/system/quotas", rt.AuthenticateToken(rt.routeGroupQuotas))
+	router.POST("/api/system/storage/compact", rt.AuthenticateToken(rt.routeStorageCompact))
+	router.GET("/api/system/containers", rt.AuthenticateToken(rt.routeUnknownContainers))
+	router.GET("/api/system/network", rt.AuthenticateToken(rt.routeNetworkMigration))
+	router.POST("/api/system/network/migrate", rt.AuthenticateToken(rt.routeNetworkMigrate))
+	router.GET("/api/system/eggs/sync", rt.AuthenticateToken(rt.routeEggSyncReport))
+	router.POST("/api/system/containers/reconcile", rt.AuthenticateToken(rt.routeReconcileContainers))
+	router.POST("/api/system/eggs/sync", rt.AuthenticateToken(rt.routeSyncEggs))
+	router.GET("/api/system/bulk", rt.AuthenticateToken(rt.routeBulkOperations))
+	router.GET("/api/system/bulk/:operation", rt.AuthenticateToken(rt.routeBulkOperation))
+	router.POST("/api/system/bulk", rt.AuthenticateToken(rt.routeStartBulkOperation))
+	router.DELETE("/api/system/bulk/:operation", rt.AuthenticateToken(rt.routeCancelBulkOperation))
+	router.GET("/api/cache/artifacts/:checksum", rt.AuthenticateCachePeer(rt.routeCacheArtifact))
+	router.GET("/api/audit", rt.AuthenticateToken(rt.routeAuditLog))
+	router.GET("/api/snapshots/:snapshot/download", rt.routeSnapshotDownload)
+	router.GET("/api/snapshots/:snapshot/files/*path", rt.routeSnapshotFile)
+	router.GET("/download/file", rt.routeDownloadFile)
+	router.HEAD("/download/file", rt.routeDownloadFile)
+	router.GET("/api/templates", rt.AuthenticateToken(rt.routeTemplates))
+	router.GET("/api/templates/:template", rt.AuthenticateToken(rt.routeTemplate))
+	router.GET("/api/templates/:template/download", rt.AuthenticateToken(rt.routeTemplateDownload))
+	router.DELETE("/api/templates/:template", rt.AuthenticateToken(rt.routeTemplateDelete))
 	router.GET("/api/servers", rt.AuthenticateToken(rt.routeAllServers))
 	router.GET("/api/servers/:server", rt.AuthenticateRequest(rt.routeServer))
-	router.GET("/api/servers/:server/ws", rt.AuthenticateServer(rt.routeWebsocket))
+	router.GET("/api/servers/:server/ws", rt.ThrottleConnections(rt.AuthenticateServer(rt.routeWebsocket)))
+	router.GET("/api/servers/:server/events", rt.ThrottleConnections(rt.AuthenticateServer(rt.routeServerEventStream)))
 	router.GET("/api/servers/:server/logs", rt.AuthenticateRequest(rt.routeServerLogs))
+	router.GET("/api/servers/:server/flows", rt.AuthenticateRequest(rt.routeServerFlows))
+	router.GET("/api/servers/:server/profiles", rt.AuthenticateRequest(rt.routeServerProfiles))
+	router.GET("/api/servers/:server/schedules", rt.AuthenticateRequest(rt.routeServerSchedules))
+	router.GET("/api/servers/:server/schedules/:schedule", rt.AuthenticateRequest(rt.routeServerSchedule))
+	router.GET("/api/servers/:server/configuration/lint", rt.AuthenticateRequest(rt.routeServerLintConfiguration))
+	router.GET("/api/servers/:server/configuration/allocations", rt.AuthenticateRequest(rt.routeServerAllocationReferences))
+	router.GET("/api/servers/:server/configuration/journal", rt.AuthenticateRequest(rt.routeServerConfigurationJournal))
+	router.GET("/api/servers/:server/commands/history", rt.AuthenticateRequest(rt.routeServerCommandHistory))
+	router.GET("/api/servers/:server/logs/history", rt.AuthenticateRequest(rt.routeServerConsoleLogs))
+	router.GET("/api/servers/:server/logs/search", rt.AuthenticateRequest(rt.routeServerSearchConsoleLogs))
+	router.GET("/api/servers/:server/console/transforms", rt.AuthenticateRequest(rt.routeServerConsoleTransforms))
+	router.GET("/api/servers/:server/integrity", rt.AuthenticateRequest(rt.routeServerIntegrity))
+	router.GET("/api/servers/:server/storage/migration", rt.AuthenticateRequest(rt.routeServerStorageMigration))
+	router.GET("/api/servers/:server/snapshots", rt.AuthenticateRequest(rt.routeServerSnapshots))
+	router.GET("/api/servers/:server/stats/history", rt.AuthenticateRequest(rt.routeServerStatsHistory))
 	router.GET("/api/servers/:server/files/contents", rt.AuthenticateRequest(rt.routeServerFileRead))
+	router.GET("/api/servers/:server/files/edit", rt.AuthenticateRequest(rt.routeServerReadEditableFile))
 	router.GET("/api/servers/:server/files/list-directory", rt.AuthenticateRequest(rt.routeServerListDirectory))
+	router.GET("/api/servers/:server/files/operations", rt.AuthenticateRequest(rt.routeServerFileOperations))
+	router.GET("/api/servers/:server/files/search", rt.AuthenticateRequest(rt.routeServerSearchFiles))
+	router.GET("/api/servers/:server/files/usage", rt.AuthenticateRequest(rt.routeServerDiskUsage))
+	router.GET("/api/servers/:server/debug", rt.AuthenticateRequest(rt.routeServerDebug))
+	router.GET("/api/servers/:server/debug/bundles/:bundle", rt.AuthenticateRequest(rt.routeServerDebugBundle))
+	router.GET("/api/servers/:server/files/uploads", rt.AuthenticateRequest(rt.routeServerUploads))
+	router.GET("/api/servers/:server/files/uploads/:upload", rt.AuthenticateRequest(rt.routeServerUpload))
+	router.GET("/api/servers/:server/files/operations/:operation", rt.AuthenticateRequest(rt.routeServerFileOperation))
 	router.PUT("/api/servers/:server/files/rename", rt.AuthenticateRequest(rt.routeServerRenameFile))
+	router.PUT("/api/servers/:server/files/edit", rt.AuthenticateRequest(rt.routeServerWriteEditableFile))
 	router.POST("/api/servers", rt.AuthenticateToken(rt.routeCreateServer))
 	router.POST("/api/servers/:server/install", rt.AuthenticateRequest(rt.routeServerInstall))
+	router.POST("/api/servers/:server/image/rebuild", rt.AuthenticateRequest(rt.routeServerRebuildImage))
+	router.POST("/api/servers/:server/profiles/activate", rt.AuthenticateRequest(rt.routeServerActivateProfile))
+	router.POST("/api/servers/:server/schedules", rt.AuthenticateRequest(rt.routeServerSaveSchedule))
+	router.POST("/api/servers/:server/schedules/:schedule/run", rt.AuthenticateRequest(rt.routeServerRunSchedule))
+	router.POST("/api/servers/:server/templates", rt.AuthenticateRequest(rt.routeServerCreateTemplate))
+	router.POST("/api/servers/:server/templates/apply", rt.AuthenticateRequest(rt.routeServerApplyTemplate))
 	router.POST("/api/servers/:server/files/copy", rt.AuthenticateRequest(rt.routeServerCopyFile))
 	router.POST("/api/servers/:server/files/write", rt.AuthenticateRequest(rt.routeServerWriteFile))
 	router.POST("/api/servers/:server/files/create-directory", rt.AuthenticateRequest(rt.routeServerCreateDirectory))
 	router.POST("/api/servers/:server/files/delete", rt.AuthenticateRequest(rt.routeServerDeleteFile))
+	router.GET("/api/servers/:server/files/trash", rt.AuthenticateRequest(rt.routeServerTrash))
+	router.DELETE("/api/servers/:server/files/trash", rt.AuthenticateRequest(rt.routeServerEmptyTrash))
+	router.POST("/api/servers/:server/files/trash/:item/restore", rt.AuthenticateRequest(rt.routeServerRestoreTrashItem))
+	router.DELETE("/api/servers/:server/files/trash/:item", rt.AuthenticateRequest(rt.routeServerDeleteTrashItem))
+	router.POST("/api/servers/:server/files/operations", rt.AuthenticateRequest(rt.routeServerStartFileOperation))
+	router.POST("/api/servers/:server/files/compress", rt.AuthenticateRequest(rt.routeServerCompressFiles))
+	router.POST("/api/servers/:server/files/decompress", rt.AuthenticateRequest(rt.routeServerDecompressFiles))
+	router.POST("/api/servers/:server/files/pull", rt.AuthenticateRequest(rt.routeServerPullFiles))
+	router.POST("/api/servers/:server/files/uploads", rt.AuthenticateRequest(rt.routeServerCreateUpload))
 	router.POST("/api/servers/:server/power", rt.AuthenticateRequest(rt.routeServerPower))
+	router.GET("/api/servers/:server/power", rt.AuthenticateRequest(rt.routeServerPowerActions))
 	router.POST("/api/servers/:server/commands", rt.AuthenticateRequest(rt.routeServerSendCommand))
+	router.POST("/api/servers/:server/commands/history/:command/replay", rt.AuthenticateRequest(rt.routeServerReplayCommand))
+	router.POST("/api/servers/:server/macros/:macro", rt.AuthenticateRequest(rt.routeServerRunMacro))
+	router.POST("/api/servers/:server/integrity/verify", rt.AuthenticateRequest(rt.routeServerVerifyIntegrity))
+	router.POST("/api/servers/:server/integrity/baseline", rt.AuthenticateRequest(rt.routeServerResetIntegrity))
+	router.POST("/api/servers/:server/storage/migrate", rt.AuthenticateRequest(rt.routeServerMigrateStorage))
+	router.POST("/api/servers/:server/snapshots", rt.AuthenticateRequest(rt.routeServerCreateSnapshot))
+	router.POST("/api/servers/:server/debug", rt.AuthenticateRequest(rt.routeServerStartDebug))
+	router.PUT("/api/servers/:server/profiles", rt.AuthenticateRequest(rt.routeServerUpdateProfiles))
+	router.PUT("/api/servers/:server/console/transforms", rt.AuthenticateRequest(rt.routeServerUpdateConsoleTransforms))
+	router.PUT("/api/servers/:server/schedules", rt.AuthenticateRequest(rt.routeServerSyncSchedules))
 	router.PATCH("/api/servers/:server", rt.AuthenticateRequest(rt.routeServerUpdate))
+	router.PATCH("/api/servers/:server/resources", rt.AuthenticateRequest(rt.routeServerUpdateResources))
+	router.PATCH("/api/servers/:server/files/uploads/:upload", rt.AuthenticateRequest(rt.routeServerWriteUpload))
+	router.DELETE("/api/servers/:server/schedules/:schedule", rt.AuthenticateRequest(rt.routeServerDeleteSchedule))
+	router.DELETE("/api/servers/:server/snapshots/:snapshot", rt.AuthenticateRequest(rt.routeServerDeleteSnapshot))
+	router.DELETE("/api/servers/:server/debug", rt.AuthenticateRequest(rt.routeServerStopDebug))
+	router.DELETE("/api/servers/:server/files/operations/:operation", rt.AuthenticateRequest(rt.routeServerCancelFileOperation))
+	router.DELETE("/api/servers/:server/files/uploads/:upload", rt.AuthenticateRequest(rt.routeServerCancelUpload))
 	router.DELETE("/api/servers/:server", rt.AuthenticateRequest(rt.routeServerDelete))
 
-	return router
+	return router.Router
 }
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Returns where each allocation of a server ends up in its configuration files, along
+// with any values that look like a port or address but do not match an allocation. This
+// is intended to help find out why a server is listening on the wrong port without
+// having to search through its configuration files.
+func (rt *Router) routeServerAllocationReferences(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	report, err := s.AllocationReferences()
+	if err != nil {
+		zap.S().Errorw("failed to read allocations from server configuration files", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to read server configuration files", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
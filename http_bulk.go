@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strconv"
+)
+
+// Returns the bulk operations that are running or have recently finished on the node.
+func (rt *Router) routeBulkOperations(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	json.NewEncoder(w).Encode(server.BulkOperations())
+}
+
+// Returns the progress of a single bulk operation, including the status of every server
+// it applies to.
+func (rt *Router) routeBulkOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	o, ok := server.GetBulkOperation(ps.ByName("operation"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(o)
+}
+
+// Reinstalls, or re-runs the configuration file parsing for, every server matching the
+// filter in the request. The servers are processed in the background and the operation
+// is returned so that its progress can be followed.
+func (rt *Router) routeStartBulkOperation(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer r.Body.Close()
+
+	var req server.BulkRequest
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &req); err != nil {
+		http.Error(w, "could not parse bulk operation from request", http.StatusBadRequest)
+		return
+	}
+
+	o, err := server.StartBulkOperation(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.NodeBulk, audit.PanelActor, "", map[string]string{
+		"operation": o.Id,
+		"action":    o.Request.Action,
+		"servers":   strconv.Itoa(len(o.Servers)),
+	})
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(o)
+}
+
+// Cancels a bulk operation. Servers that are already being processed are not affected,
+// and any that have not been reached yet are skipped.
+func (rt *Router) routeCancelBulkOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if !server.CancelBulkOperation(ps.ByName("operation")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	audit.Log(audit.NodeBulkCancel, audit.PanelActor, "", map[string]string{"operation": ps.ByName("operation")})
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"crypto/subtle"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strings"
+)
+
+// Middleware protecting the shared cache endpoints. Peers authenticate using the shared
+// cache token rather than the node token, since the node token is unique to each node.
+func (rt *Router) AuthenticateCachePeer(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		c := config.Get().System.SharedCache
+		if !c.Enabled || c.Token == "" {
+			http.NotFound(w, r)
+			return
+		}
+
+		auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if len(auth) == 2 && auth[0] == "Bearer" && subtle.ConstantTimeCompare([]byte(auth[1]), []byte(c.Token)) == 1 {
+			h(w, r, ps)
+			return
+		}
+
+		http.Error(w, "authorization failed", http.StatusUnauthorized)
+	}
+}
+
+// Serves an installation artifact from the local cache to a peer.
+func (rt *Router) routeCacheArtifact(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	p, err := server.CachedArtifactPath(ps.ByName("checksum"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeFile(w, r, p)
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/buger/jsonparser"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The number of console log lines returned when no limit is given, and the most that can
+// be requested at once.
+const (
+	consoleLogDefaultLimit = 500
+	consoleLogMaxLimit     = 5000
+)
+
+// Returns the commands recently sent to the console of a server and who sent them. The
+// results can be limited to the commands sent by a single actor, such as "user:1".
+func (rt *Router) routeServerCommandHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	json.NewEncoder(w).Encode(s.ConsoleHistory(r.URL.Query().Get("actor"), limit))
+}
+
+// Returns the console output of a server stored on the node between the "since" and
+// "until" times, which are either RFC3339 times or durations before now such as "2h".
+func (rt *Router) routeServerConsoleLogs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	q, ok := consoleLogQuery(w, r)
+	if !ok {
+		return
+	}
+
+	rt.writeConsoleLog(w, s, q)
+}
+
+// Searches the console output of a server stored on the node for the lines that contain
+// the "q" parameter, ignoring case, or that match it as a regular expression if "regex"
+// is set. The search can be limited to a range of time in the same way as fetching the
+// console logs.
+func (rt *Router) routeServerSearchConsoleLogs(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	term := r.URL.Query().Get("q")
+	if term == "" {
+		http.Error(w, "a search term must be provided", http.StatusUnprocessableEntity)
+		return
+	}
+
+	q, ok := consoleLogQuery(w, r)
+	if !ok {
+		return
+	}
+
+	if useRegex, _ := strconv.ParseBool(r.URL.Query().Get("regex")); useRegex {
+		re, err := regexp.Compile(term)
+		if err != nil {
+			http.Error(w, "invalid search pattern: "+err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		q.Match = re.MatchString
+	} else {
+		term = strings.ToLower(term)
+
+		q.Match = func(line string) bool {
+			return strings.Contains(strings.ToLower(line), term)
+		}
+	}
+
+	rt.writeConsoleLog(w, s, q)
+}
+
+// Parses the time range and limit shared by the console log endpoints, writing an error
+// response and returning false if they are not valid.
+func consoleLogQuery(w http.ResponseWriter, r *http.Request) (server.ConsoleLogQuery, bool) {
+	var q server.ConsoleLogQuery
+	var err error
+
+	if q.Since, err = parseLogTime(r.URL.Query().Get("since")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return q, false
+	}
+
+	if q.Until, err = parseLogTime(r.URL.Query().Get("until")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return q, false
+	}
+
+	q.Limit, _ = strconv.Atoi(r.URL.Query().Get("limit"))
+	if q.Limit <= 0 {
+		q.Limit = consoleLogDefaultLimit
+	} else if q.Limit > consoleLogMaxLimit {
+		q.Limit = consoleLogMaxLimit
+	}
+
+	return q, true
+}
+
+func (rt *Router) writeConsoleLog(w http.ResponseWriter, s *server.Server, q server.ConsoleLogQuery) {
+	lines, err := s.ReadConsoleLog(q)
+	if err != nil {
+		zap.S().Errorw("failed to read server console log", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to read server console log", http.StatusInternalServerError)
+		return
+	}
+
+	if lines == nil {
+		lines = []server.ConsoleLogLine{}
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"data": lines})
+}
+
+// Sends a command from the console history of a server to it again. Since commands can
+// be destructive the request must set "confirm" to true, otherwise the command that would
+// be sent is returned so that it can be shown to the user before they confirm it.
+func (rt *Router) routeServerReplayCommand(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	id, err := strconv.ParseUint(ps.ByName("command"), 10, 64)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	c, ok := s.ConsoleCommand(id)
+	if !ok {
+		http.Error(w, "command is no longer in the console history", http.StatusNotFound)
+		return
+	}
+
+	if confirm, _ := jsonparser.GetBoolean(rt.ReaderToBytes(r.Body), "confirm"); !confirm {
+		w.WriteHeader(http.StatusPreconditionRequired)
+		json.NewEncoder(w).Encode(c)
+		return
+	}
+
+	if running, err := s.Environment.IsRunning(); !running || err != nil {
+		http.Error(w, "cannot send commands to a stopped instance", http.StatusBadGateway)
+		return
+	}
+
+	rt.sendCommands(w, s, []string{c.Command}, strings.HasPrefix(c.Command, server.MacroPrefix), map[string]string{
+		"replay_of": strconv.FormatUint(c.Id, 10),
+	})
+}
+
+// Returns the transforms applied to the console output of a server.
+func (rt *Router) routeServerConsoleTransforms(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	c, err := s.GetConsoleTransforms()
+	if err != nil {
+		zap.S().Errorw("failed to load server console transforms", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to load server console transforms", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(c)
+}
+
+// Replaces the transforms applied to the console output of a server. The new transforms
+// apply to any output received after the request completes.
+func (rt *Router) routeServerUpdateConsoleTransforms(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var c server.ConsoleTransforms
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &c); err != nil {
+		http.Error(w, "could not parse console transforms from request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.UpdateConsoleTransforms(c); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.ConsoleFormat, audit.PanelActor, s.Uuid, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
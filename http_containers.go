@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strconv"
+)
+
+// Returns the result of the last check for containers belonging to servers the daemon is
+// not managing, including any containers that were quarantined.
+func (rt *Router) routeUnknownContainers(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	r := server.GetReconciliationReport()
+	if r == nil {
+		r = &server.ReconciliationReport{Mode: config.Get().Docker.Reconciliation.Mode, Containers: []server.UnknownContainer{}}
+	}
+
+	json.NewEncoder(w).Encode(r)
+}
+
+// Checks for unknown containers immediately. The mode defaults to the one configured for
+// the node, but can be overridden in the request, for example to adopt the containers
+// found by a node that only reports them.
+func (rt *Router) routeReconcileContainers(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer r.Body.Close()
+
+	var data struct {
+		Mode string `json:"mode"`
+	}
+
+	if b := rt.ReaderToBytes(r.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, "could not parse reconciliation request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if data.Mode == "" {
+		data.Mode = config.Get().Docker.Reconciliation.Mode
+	}
+
+	switch data.Mode {
+	case server.ReconcileReport, server.ReconcileAdopt, server.ReconcileQuarantine:
+	default:
+		http.Error(w, "mode must be one of \"report\", \"adopt\" or \"quarantine\"", http.StatusUnprocessableEntity)
+		return
+	}
+
+	report := server.ReconcileContainers(data.Mode)
+
+	audit.Log(audit.NodeReconcile, audit.PanelActor, "", map[string]string{
+		"mode":       data.Mode,
+		"containers": strconv.Itoa(len(report.Containers)),
+	})
+
+	json.NewEncoder(w).Encode(report)
+}
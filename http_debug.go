@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Returns the running or last debug capture for a server, along with the bundles that are
+// available to download.
+func (rt *Router) routeServerDebug(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	bundles, err := s.DebugBundles()
+	if err != nil {
+		zap.S().Errorw("failed to list debug bundles for server", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to list debug bundles", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Capture *server.DebugCapture `json:"capture"`
+		Bundles []server.DebugBundle `json:"bundles"`
+	}{s.CurrentDebugCapture(), bundles})
+}
+
+// Starts a debug capture for a server, which runs for the requested number of minutes
+// and is then written to a bundle.
+func (rt *Router) routeServerStartDebug(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var data struct {
+		// The number of minutes the capture runs for.
+		Duration int `json:"duration"`
+	}
+
+	if b := rt.ReaderToBytes(r.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, "request body must be valid JSON", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	c, err := s.StartDebugCapture(time.Duration(data.Duration) * time.Minute)
+	if err != nil {
+		if err == server.ErrDebugCaptureRunning {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		zap.S().Warnw("failed to start debug capture for server", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.ServerDebug, audit.PanelActor, s.Uuid, map[string]string{
+		"capture":  c.Id,
+		"action":   "start",
+		"duration": strconv.Itoa(int(c.ExpiresAt.Sub(c.StartedAt) / time.Minute)),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(c)
+}
+
+// Stops the running debug capture for a server before it expires, writing everything
+// recorded so far to a bundle.
+func (rt *Router) routeServerStopDebug(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	c, err := s.StopDebugCapture()
+	if err == os.ErrNotExist {
+		http.NotFound(w, r)
+		return
+	}
+
+	audit.Log(audit.ServerDebug, audit.PanelActor, s.Uuid, map[string]string{"capture": c.Id, "action": "stop"})
+
+	if err != nil {
+		zap.S().Errorw("failed to write debug bundle for server", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to write debug bundle", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(c)
+}
+
+// Downloads a bundle created from a debug capture of a server.
+func (rt *Router) routeServerDebugBundle(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	p, err := s.DebugBundlePath(ps.ByName("bundle"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+ps.ByName("bundle"))
+
+	http.ServeFile(w, r, p)
+}
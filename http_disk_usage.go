@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Returns the disk usage of a directory of a server, broken down by each of the files and
+// directories within it. If checksums are requested the checksum of each file in the
+// directory is returned, along with any duplicate files found below it.
+func (rt *Router) routeServerDiskUsage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	q := r.URL.Query()
+
+	opts := server.DiskUsageOptions{
+		Directory: q.Get("directory"),
+		Checksums: q.Get("checksums") == "true" || q.Get("checksums") == "1",
+	}
+	opts.MinDuplicateSize, _ = strconv.ParseInt(q.Get("min_size"), 10, 64)
+
+	u, err := s.Filesystem.DiskUsage(r.Context(), opts)
+	if err != nil {
+		if r.Context().Err() != nil {
+			return
+		}
+
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	json.NewEncoder(w).Encode(u)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gbrlsnchs/jwt/v3"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The claims of a token issued by the Panel that allows a single file of a server to be
+// downloaded directly from the node. Tokens are signed with the token for the daemon, and
+// must have an id, expiry and the download audience.
+type DownloadTokenPayload struct {
+	jwt.Payload
+	UserID     json.Number `json:"user_id"`
+	ServerUuid string      `json:"server_uuid"`
+	FilePath   string      `json:"file_path"`
+}
+
+// The audience that download tokens must be issued for. Websocket tokens are signed with
+// the same secret, so without it they could be used to download files.
+const downloadTokenAudience = "wings:download"
+
+// The file the state of each download token is kept in, so that a spent link cannot be
+// used again after the daemon restarts.
+const downloadSessionsFile = "data/downloads.json"
+
+// The number of times the size of the file that can be sent using a single token, which
+// allows a download that was interrupted to be resumed or restarted.
+const downloadAllowance = 2
+
+// The state of a download token. A token is bound to the file as it was when the token
+// was first used, and can be used one request at a time until the whole file has been
+// sent in one response, or the bytes sent across every request reach the allowance.
+type downloadSession struct {
+	ETag      string    `json:"etag"`
+	Sent      int64     `json:"sent"`
+	Spent     bool      `json:"spent"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	active bool
+}
+
+var downloadSessions = struct {
+	sync.Mutex
+	loaded   bool
+	sessions map[string]*downloadSession
+}{sessions: make(map[string]*downloadSession)}
+
+// Validates a download token against the secret for the daemon and returns the parsed
+// claims.
+func ParseDownloadToken(token []byte) (*DownloadTokenPayload, error) {
+	var payload DownloadTokenPayload
+	if alg == nil {
+		alg = jwt.NewHS256([]byte(config.Get().AuthenticationToken))
+	}
+
+	verifyOptions := jwt.ValidatePayload(
+		&payload.Payload,
+		jwt.ExpirationTimeValidator(time.Now()),
+		jwt.AudienceValidator(jwt.Audience{downloadTokenAudience}),
+	)
+
+	if _, err := jwt.Verify(token, alg, &payload, verifyOptions); err != nil {
+		return nil, err
+	}
+
+	if payload.JWTID == "" || payload.ExpirationTime == nil {
+		return nil, errors.New("download tokens must have an id and expiry")
+	}
+
+	return &payload, nil
+}
+
+// Starts a download using the token, returning an error with the status to respond with
+// if the token cannot be used for the file right now. The download must be finished by
+// calling endDownload.
+func beginDownload(payload *DownloadTokenPayload, etag string) (int, error) {
+	downloadSessions.Lock()
+	defer downloadSessions.Unlock()
+
+	loadDownloadSessions()
+
+	// Expired tokens are forgotten, since they can no longer be used anyway.
+	for k, v := range downloadSessions.sessions {
+		if time.Now().After(v.ExpiresAt) {
+			delete(downloadSessions.sessions, k)
+		}
+	}
+
+	sess, ok := downloadSessions.sessions[payload.JWTID]
+	if !ok {
+		sess = &downloadSession{ETag: etag, ExpiresAt: payload.ExpirationTime.Time}
+		downloadSessions.sessions[payload.JWTID] = sess
+		saveDownloadSessions()
+	}
+
+	switch {
+	case sess.Spent:
+		return http.StatusGone, errors.New("this download link has already been used")
+	case sess.ETag != etag:
+		return http.StatusPreconditionFailed, errors.New("the file has changed since this download link was first used")
+	case sess.active:
+		return http.StatusConflict, errors.New("this download link is already in use")
+	}
+
+	sess.active = true
+
+	return 0, nil
+}
+
+// Records the bytes sent by a download using the token, spending the token once the whole
+// file has been sent in one response or the allowance for the file has been used.
+func endDownload(id string, sent int64, size int64, finished bool) {
+	downloadSessions.Lock()
+	defer downloadSessions.Unlock()
+
+	sess, ok := downloadSessions.sessions[id]
+	if !ok {
+		return
+	}
+
+	sess.active = false
+	sess.Sent += sent
+	if finished || sess.Sent >= size*downloadAllowance {
+		sess.Spent = true
+	}
+
+	saveDownloadSessions()
+}
+
+// Loads the state of download tokens from the disk the first time it is needed. The
+// download sessions lock must be held.
+func loadDownloadSessions() {
+	if downloadSessions.loaded {
+		return
+	}
+	downloadSessions.loaded = true
+
+	b, err := ioutil.ReadFile(downloadSessionsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.S().Warnw("failed to read state of download links", zap.Error(err))
+		}
+
+		return
+	}
+
+	if err := json.Unmarshal(b, &downloadSessions.sessions); err != nil {
+		zap.S().Warnw("failed to parse state of download links", zap.Error(err))
+	}
+
+	if downloadSessions.sessions == nil {
+		downloadSessions.sessions = make(map[string]*downloadSession)
+	}
+}
+
+// Writes the state of download tokens to the disk. The download sessions lock must be
+// held.
+func saveDownloadSessions() {
+	b, err := json.Marshal(downloadSessions.sessions)
+	if err == nil {
+		tmp := downloadSessionsFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, downloadSessionsFile)
+		}
+	}
+
+	if err != nil {
+		zap.S().Warnw("failed to save state of download links", zap.Error(err))
+	}
+}
+
+// Reads a file being downloaded, limiting the average speed it is read at.
+type downloadReader struct {
+	f     *os.File
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (d *downloadReader) Read(b []byte) (int, error) {
+	if d.rate > 0 {
+		if d.start.IsZero() {
+			d.start = time.Now()
+		}
+
+		if int64(len(b)) > d.rate {
+			b = b[:d.rate]
+		}
+	}
+
+	n, err := d.f.Read(b)
+	d.read += int64(n)
+
+	if d.rate > 0 {
+		expected := time.Duration(float64(d.read) / float64(d.rate) * float64(time.Second))
+		if w := expected - time.Since(d.start); w > 0 {
+			time.Sleep(w)
+		}
+	}
+
+	return n, err
+}
+
+func (d *downloadReader) Seek(offset int64, whence int) (int64, error) {
+	return d.f.Seek(offset, whence)
+}
+
+// Records the number of bytes written to the client, and whether any part of the response
+// could not be written.
+type downloadWriter struct {
+	http.ResponseWriter
+	written int64
+	err     error
+}
+
+func (d *downloadWriter) Write(b []byte) (int, error) {
+	n, err := d.ResponseWriter.Write(b)
+	d.written += int64(n)
+	if err != nil && d.err == nil {
+		d.err = err
+	}
+
+	return n, err
+}
+
+// Serves a file of a server using a download token issued by the Panel, provided either in
+// the "token" query parameter or as a bearer token. Range requests are supported so that
+// large files can be downloaded in parts and interrupted downloads resumed, one request
+// at a time for each token.
+func (rt *Router) routeDownloadFile(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if !config.Get().Api.Downloads.Enabled {
+		http.Error(w, "download links are disabled on this node", http.StatusForbidden)
+		return
+	}
+
+	token := r.URL.Query().Get("token")
+	if auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(auth) == 2 && auth[0] == "Bearer" {
+		token = auth[1]
+	}
+
+	payload, err := ParseDownloadToken([]byte(token))
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authorization failed", http.StatusUnauthorized)
+		return
+	}
+
+	s := server.GetServers().Find(func(s *server.Server) bool {
+		return s.Uuid == payload.ServerUuid
+	})
+
+	if s == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	cleaned, err := s.Filesystem.SafePath(payload.FilePath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	// The file is opened without following symlinks, since the server can replace it with
+	// one after the path was resolved.
+	f, st, err := s.Filesystem.OpenFile(cleaned)
+	if err != nil {
+		if !os.IsNotExist(errors.Cause(err)) {
+			zap.S().Errorw("failed to open file for download", zap.String("server", s.Uuid), zap.String("path", payload.FilePath), zap.Error(err))
+		}
+
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	// Resuming a download is only safe if the file has not changed since it started, which
+	// clients check using the ETag.
+	etag := fmt.Sprintf("\"%x-%x\"", st.ModTime().UnixNano(), st.Size())
+	if r.Method == http.MethodGet {
+		if status, err := beginDownload(payload, etag); err != nil {
+			http.Error(w, err.Error(), status)
+			return
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", strings.Replace(st.Name(), "\"", "", -1)))
+
+	// Only log the start of a download, not each part that is requested.
+	if r.Method == http.MethodGet && (r.Header.Get("Range") == "" || strings.HasPrefix(r.Header.Get("Range"), "bytes=0-")) {
+		audit.Log(audit.FileDownload, "user:"+payload.UserID.String(), s.Uuid, map[string]string{"file": payload.FilePath, "token": payload.JWTID})
+	}
+
+	reader := &downloadReader{f: f, rate: config.Get().Api.Downloads.MaxBandwidth * 1000}
+	writer := &downloadWriter{ResponseWriter: w}
+
+	if r.Method == http.MethodGet {
+		defer func() {
+			endDownload(payload.JWTID, writer.written, st.Size(), writer.err == nil && writer.written >= st.Size())
+		}()
+	}
+
+	http.ServeContent(writer, r, st.Name(), st.ModTime(), reader)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/buger/jsonparser"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strconv"
+)
+
+// Returns the current drain status of the node.
+func (rt *Router) routeDrainStatus(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	json.NewEncoder(w).Encode(server.GetDrainStatus())
+}
+
+// Places the node into drain mode. If "stop_servers" is passed through in the request
+// body all of the running servers will be stopped in the background.
+func (rt *Router) routeDrainStart(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer r.Body.Close()
+
+	stop, _ := jsonparser.GetBoolean(rt.ReaderToBytes(r.Body), "stop_servers")
+
+	if err := server.StartDrain(stop, &config.Get().System.Drain); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	audit.Log(audit.NodeDrain, audit.PanelActor, "", map[string]string{"stop_servers": strconv.FormatBool(stop)})
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(server.GetDrainStatus())
+}
+
+// Takes the node out of drain mode.
+func (rt *Router) routeDrainStop(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	server.StopDrain()
+
+	audit.Log(audit.NodeDrainCancel, audit.PanelActor, "", nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Responds with an error if the node is draining. Returns true if the request should
+// not continue.
+func (rt *Router) rejectIfDraining(w http.ResponseWriter) bool {
+	if !server.IsDraining() {
+		return false
+	}
+
+	http.Error(w, "node is draining and is not accepting new installations", http.StatusServiceUnavailable)
+
+	return true
+}
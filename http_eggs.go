@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strconv"
+)
+
+// Returns the result of the last check of the egg configuration of every server against
+// the Panel, listing the servers that have changed.
+func (rt *Router) routeEggSyncReport(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	r := server.GetEggSyncReport()
+	if r == nil {
+		r = &server.EggSyncReport{Mode: config.Get().System.EggSync.Mode, Servers: []server.EggDrift{}}
+	}
+
+	json.NewEncoder(w).Encode(r)
+}
+
+// Checks the egg configuration of every server against the Panel immediately. The mode
+// defaults to the one configured for the node, but can be overridden in the request, for
+// example to apply an egg fix right away on a node that only reports changes.
+func (rt *Router) routeSyncEggs(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	defer r.Body.Close()
+
+	var data struct {
+		Mode string `json:"mode"`
+	}
+
+	if b := rt.ReaderToBytes(r.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, "could not parse egg sync request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Nodes that never check on their own still report the changes when asked to.
+	if data.Mode == "" {
+		data.Mode = config.Get().System.EggSync.Mode
+		if data.Mode == server.EggDriftDisabled {
+			data.Mode = server.EggDriftReport
+		}
+	}
+
+	switch data.Mode {
+	case server.EggDriftReport, server.EggDriftApply:
+	default:
+		http.Error(w, "mode must be either \"report\" or \"apply\"", http.StatusUnprocessableEntity)
+		return
+	}
+
+	report := server.SyncEggs(data.Mode)
+
+	audit.Log(audit.NodeEggSync, audit.PanelActor, "", map[string]string{
+		"mode":    data.Mode,
+		"servers": strconv.Itoa(len(report.Servers)),
+	})
+
+	json.NewEncoder(w).Encode(report)
+}
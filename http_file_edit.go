@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type editFileRequest struct {
+	Contents *string `json:"contents"`
+	// The version of the file returned when it was opened, which can also be given in the
+	// If-Match header. If empty the file must not exist yet.
+	Version *string `json:"version"`
+}
+
+type editFileConflictResponse struct {
+	Error   string `json:"error"`
+	Version string `json:"version"`
+}
+
+// Opens a text file of a server for editing, returning its contents along with the version
+// that must be sent back when the file is saved. The version is also set as the ETag.
+func (rt *Router) routeServerReadEditableFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	p := r.URL.Query().Get("file")
+
+	f, err := s.ReadEditableFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		if server.IsFileNotEditableError(err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		zap.S().Errorw("failed to read file for editing", zap.String("server", s.Uuid), zap.String("path", p), zap.Error(err))
+
+		http.Error(w, "failed to read file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", "\""+f.Version+"\"")
+
+	json.NewEncoder(w).Encode(f)
+}
+
+// Saves a file edited inline. The save is rejected if the file has been changed since the
+// version being edited was opened, returning the current version so that the Panel can
+// show the user what changed rather than silently overwriting the other edit.
+func (rt *Router) routeServerWriteEditableFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	p := r.URL.Query().Get("file")
+	defer r.Body.Close()
+
+	var data editFileRequest
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			zap.S().Errorw("failed to decode file edit data", zap.String("server", s.Uuid), zap.Error(err))
+		}
+
+		http.Error(w, "could not parse data in request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if data.Contents == nil {
+		http.Error(w, "the contents of the file must be provided", http.StatusUnprocessableEntity)
+		return
+	}
+
+	var version string
+	if data.Version != nil {
+		version = *data.Version
+	} else if m := r.Header.Get("If-Match"); m != "" {
+		version = strings.Trim(strings.TrimPrefix(m, "W/"), "\"")
+	} else {
+		http.Error(w, "the version of the file being edited must be provided", http.StatusPreconditionRequired)
+		return
+	}
+
+	v, err := s.WriteEditableFile(p, []byte(*data.Contents), version)
+	if err != nil {
+		if server.IsFileVersionError(err) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusPreconditionFailed)
+
+			json.NewEncoder(w).Encode(editFileConflictResponse{Error: err.Error(), Version: server.FileVersionOf(err)})
+			return
+		}
+
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		if server.IsFileNotEditableError(err) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+
+		zap.S().Errorw("failed to write edited file", zap.String("server", s.Uuid), zap.String("path", p), zap.Error(err))
+
+		http.Error(w, "failed to write file", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(audit.FileWrite, audit.PanelActor, s.Uuid, map[string]string{"file": p, "version": v})
+
+	w.Header().Set("ETag", "\""+v+"\"")
+
+	json.NewEncoder(w).Encode(map[string]string{"version": v})
+}
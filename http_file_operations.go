@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strconv"
+)
+
+// Returns the file operations for a server that are running or have recently finished.
+func (rt *Router) routeServerFileOperations(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	json.NewEncoder(w).Encode(s.FileOperations())
+}
+
+// Returns the progress of a single file operation, including any paths that failed.
+func (rt *Router) routeServerFileOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	o, ok := s.GetFileOperation(ps.ByName("operation"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(o)
+}
+
+// Copies, moves, deletes, compresses, decompresses or downloads a batch of files on the
+// node. The files are processed in the background and the operation is returned so that its
+// progress can be followed, either through this API or the file operation events sent to
+// the websocket.
+func (rt *Router) routeServerStartFileOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.startFileOperation(w, r, ps, "")
+}
+
+// Creates a tar.gz or zip archive containing the files in the background.
+func (rt *Router) routeServerCompressFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.startFileOperation(w, r, ps, server.FileOperationCompress)
+}
+
+// Extracts archives within the data directory of the server in the background.
+func (rt *Router) routeServerDecompressFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.startFileOperation(w, r, ps, server.FileOperationDecompress)
+}
+
+// Downloads files from remote URLs into the data directory of the server in the
+// background.
+func (rt *Router) routeServerPullFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	rt.startFileOperation(w, r, ps, server.FileOperationPull)
+}
+
+// Starts the file operation in the request body, using the given action if one is set.
+func (rt *Router) startFileOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params, action string) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var req server.FileOperationRequest
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &req); err != nil {
+		http.Error(w, "could not parse file operation from request", http.StatusBadRequest)
+		return
+	}
+
+	if action != "" {
+		req.Action = action
+	}
+
+	o, err := s.StartFileOperation(req)
+	if err != nil {
+		if server.IsDiskSpaceError(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	a := audit.FileCopy
+	switch o.Request.Action {
+	case server.FileOperationMove:
+		a = audit.FileRename
+	case server.FileOperationDelete:
+		a = audit.FileDelete
+	case server.FileOperationCompress:
+		a = audit.FileCompress
+	case server.FileOperationDecompress:
+		a = audit.FileDecompress
+	case server.FileOperationPull:
+		a = audit.FilePull
+	}
+
+	meta := map[string]string{
+		"operation": o.Id,
+		"root":      o.Request.Root,
+		"files":     strconv.Itoa(len(o.Request.Files)),
+	}
+
+	if o.Request.Destination != "" {
+		meta["destination"] = o.Request.Destination
+	}
+
+	audit.Log(a, audit.PanelActor, s.Uuid, meta)
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(o)
+}
+
+// Cancels a running file operation. The file currently being processed is finished, and
+// any paths that have not been reached yet are left untouched.
+func (rt *Router) routeServerCancelFileOperation(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	if !s.CancelFileOperation(ps.ByName("operation")) {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
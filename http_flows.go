@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/flows"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"time"
+)
+
+// The collector for server network flows, this is nil unless flow collection is enabled
+// in the configuration.
+var flowCollector *flows.Collector
+
+// Starts collecting the network flows for every server on the node in the background.
+func startFlowCollector(c *config.FlowsConfiguration) error {
+	if c.Source != "conntrack" {
+		return errors.Errorf("flow source \"%s\" is not supported", c.Source)
+	}
+
+	fc := &flows.Collector{Allocations: serverAllocations, Top: c.Top, MaxEntries: c.MaxEntries}
+
+	if c.AsnDatabase != "" {
+		db, err := flows.LoadAsnDatabase(c.AsnDatabase)
+		if err != nil {
+			return errors.Wrap(err, "failed to load ASN database")
+		}
+
+		fc.Asn = db
+	}
+
+	interval := time.Duration(c.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Second * 5
+	}
+
+	flowCollector = fc
+
+	go supervisor.Supervise("flows", func() error {
+		return fc.Run(interval)
+	})
+
+	return nil
+}
+
+// Returns the allocations for every server on the node.
+func serverAllocations() []flows.Allocation {
+	var allocations []flows.Allocation
+
+	for _, s := range server.GetServers().All() {
+		for ip, ports := range s.Allocations.Bindings() {
+			for _, port := range ports {
+				allocations = append(allocations, flows.Allocation{
+					Server: s.Uuid,
+					Ip:     net.ParseIP(config.TrimAddressBrackets(ip)),
+					Port:   port,
+				})
+			}
+		}
+	}
+
+	return allocations
+}
+
+// Returns the most recent network flow statistics for every server on the node.
+func (rt *Router) routeSystemFlows(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	if flowCollector == nil {
+		http.Error(w, "flow collection is not enabled on this node", http.StatusNotFound)
+		return
+	}
+
+	json.NewEncoder(w).Encode(flowCollector.All())
+}
+
+// Returns the most recent network flow statistics for a single server.
+func (rt *Router) routeServerFlows(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	if flowCollector == nil {
+		http.Error(w, "flow collection is not enabled on this node", http.StatusNotFound)
+		return
+	}
+
+	s := rt.GetServer(ps.ByName("server"))
+
+	snapshot := flowCollector.Get(s.Uuid)
+	if snapshot == nil {
+		snapshot = &flows.Snapshot{}
+	}
+
+	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
+		zap.S().Warnw("failed to write server flows to response", zap.String("server", s.Uuid), zap.Error(err))
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/config"
+	"net/http"
+	"strings"
+)
+
+// Returns the health of the daemon and each of its subsystems. The response status is 200
+// while the daemon is healthy or degraded, and 503 once any subsystem is unhealthy, so the
+// endpoint can be used directly as a load balancer health check.
+//
+// When the endpoint is public it can be requested without a token, in which case the
+// messages describing each subsystem are left out since they can include addresses and
+// paths on the node.
+func (rt *Router) routeSystemHealth(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+	authorized := len(auth) == 2 && auth[0] == "Bearer" && auth[1] == rt.token
+
+	if !authorized && !config.Get().Api.Health.Public {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authorization failed", http.StatusUnauthorized)
+		return
+	}
+
+	h := GetHealthReport()
+	if !authorized {
+		checks := make(map[string]SubsystemHealth, len(h.Checks))
+		for name, c := range h.Checks {
+			c.Message = ""
+			checks[name] = c
+		}
+
+		h.Checks = checks
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if h.Status == HealthUnhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(h)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Returns the integrity baseline for a server along with the result of the last check.
+func (rt *Router) routeServerIntegrity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	baseline, err := s.GetIntegrityBaseline()
+	if err != nil {
+		zap.S().Errorw("failed to load server integrity baseline", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to load server integrity baseline", http.StatusInternalServerError)
+		return
+	}
+
+	if baseline == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(baseline)
+}
+
+// Checks the monitored files of a server against the baseline immediately.
+func (rt *Router) routeServerVerifyIntegrity(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	report, err := s.VerifyIntegrity()
+	if err != nil {
+		zap.S().Errorw("failed to check integrity of server files", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to check integrity of server files", http.StatusInternalServerError)
+		return
+	}
+
+	if report == nil {
+		http.Error(w, "the egg for this server does not declare any files to monitor", http.StatusBadRequest)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// Accepts the current state of the monitored files of a server as the new baseline, such
+// as after the server software has been intentionally updated.
+func (rt *Router) routeServerResetIntegrity(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	baseline, err := s.ResetIntegrityBaseline()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	audit.Log(audit.IntegrityReset, audit.PanelActor, s.Uuid, nil)
+
+	json.NewEncoder(w).Encode(baseline)
+}
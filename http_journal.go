@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// Returns the most recent changes made to the configuration files of a server, along with
+// any conflicts that occurred while making them.
+func (rt *Router) routeServerConfigurationJournal(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+
+	changes, err := s.ConfigurationJournal(limit)
+	if err != nil {
+		zap.S().Errorw("failed to read server configuration journal", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to read server configuration journal", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(changes)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Runs the configuration file parsers for a server without modifying any files, and
+// returns a report of what each replacement would do. This is intended to help egg
+// authors find replacements that do not match anything in the file.
+func (rt *Router) routeServerLintConfiguration(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	reports, err := s.LintConfigurationFiles()
+	if err != nil {
+		zap.S().Errorw("failed to lint server configuration files", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to lint server configuration files", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(reports)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+	"strings"
+)
+
+// Runs a console macro defined for a server, passing along any arguments provided.
+func (rt *Router) routeServerRunMacro(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	name := ps.ByName("macro")
+	if _, ok := s.Macros[name]; !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	var data struct {
+		Args []string `json:"args"`
+	}
+
+	if b := rt.ReaderToBytes(r.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, "could not parse macro arguments from request", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	if err := s.RunMacro(name, data.Args); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	command := strings.TrimSpace(server.MacroPrefix + name + " " + strings.Join(data.Args, " "))
+
+	s.RecordCommand(audit.PanelActor, command)
+	audit.Log(audit.ConsoleCommand, audit.PanelActor, s.Uuid, map[string]string{"command": command})
+
+	w.WriteHeader(http.StatusAccepted)
+}
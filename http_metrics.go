@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/metrics"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Wraps a response writer to capture the status code that was sent back.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	route  string
+}
+
+func (sr *statusRecorder) WriteHeader(code int) {
+	sr.status = code
+	sr.ResponseWriter.WriteHeader(code)
+}
+
+// Allows websocket connections to be upgraded through the recorder.
+func (sr *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sr.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+
+	sr.status = http.StatusSwitchingProtocols
+
+	return h.Hijack()
+}
+
+// Allows streaming responses to flush through the recorder.
+func (sr *statusRecorder) Flush() {
+	if f, ok := sr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Wraps the router to record the duration of every API request that is handled. Requests
+// are recorded against the route they matched rather than their path, so that the number
+// of series does not grow with every server, file or unknown path that is requested.
+func (rt *Router) InstrumentHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		h.ServeHTTP(rec, r)
+
+		route := rec.route
+		if route == "" {
+			route = "unmatched"
+		}
+
+		metrics.ApiRequestDuration.Observe(
+			time.Since(start).Seconds(),
+			r.Method,
+			route,
+			strconv.Itoa(rec.status),
+		)
+	})
+}
+
+// Registers routes on the router, recording the pattern of the route that handles each
+// request on the status recorder so that it can be used when recording metrics. The
+// router itself does not make the pattern that was matched available.
+type routeRecorder struct {
+	*httprouter.Router
+}
+
+func (rr *routeRecorder) Handle(method, path string, h httprouter.Handle) {
+	rr.Router.Handle(method, path, func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		if rec, ok := w.(*statusRecorder); ok {
+			rec.route = path
+		}
+
+		h(w, r, ps)
+	})
+}
+
+func (rr *routeRecorder) GET(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodGet, path, h)
+}
+
+func (rr *routeRecorder) HEAD(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodHead, path, h)
+}
+
+func (rr *routeRecorder) OPTIONS(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodOptions, path, h)
+}
+
+func (rr *routeRecorder) POST(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodPost, path, h)
+}
+
+func (rr *routeRecorder) PUT(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodPut, path, h)
+}
+
+func (rr *routeRecorder) PATCH(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodPatch, path, h)
+}
+
+func (rr *routeRecorder) DELETE(path string, h httprouter.Handle) {
+	rr.Handle(http.MethodDelete, path, h)
+}
+
+// Middleware protecting the metrics endpoint. Requests are allowed through if they come
+// from an allowed IP address, or if they provide the configured metrics token.
+func (rt *Router) AuthenticateMetrics(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		c := config.Get().Api.Metrics
+
+		if len(c.AllowedIps) > 0 {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err == nil && ipAllowed(net.ParseIP(host), c.AllowedIps) {
+				h(w, r, ps)
+				return
+			}
+		}
+
+		token := c.Token
+		if token == "" {
+			token = rt.token
+		}
+
+		auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2)
+		if len(auth) == 2 && auth[0] == "Bearer" && subtle.ConstantTimeCompare([]byte(auth[1]), []byte(token)) == 1 {
+			h(w, r, ps)
+			return
+		}
+
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "authorization failed", http.StatusUnauthorized)
+	}
+}
+
+// Determines if the IP is contained in the list of addresses and CIDR ranges.
+func ipAllowed(ip net.IP, allowed []string) bool {
+	if ip == nil {
+		return false
+	}
+
+	for _, a := range allowed {
+		if _, n, err := net.ParseCIDR(a); err == nil {
+			if n.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		if other := net.ParseIP(a); other != nil && other.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writes all of the daemon metrics in the Prometheus text format.
+func (rt *Router) routeMetrics(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	if err := metrics.Write(w); err != nil {
+		zap.S().Warnw("failed to write metrics to response", zap.Error(err))
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Returns the plan for recreating the Docker network used by servers, which is null if
+// the network matches the configuration.
+func (rt *Router) routeNetworkMigration(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	json.NewEncoder(w).Encode(struct {
+		Migration *server.NetworkMigration `json:"migration"`
+	}{Migration: server.GetNetworkMigration()})
+}
+
+// Attempts to recreate the Docker network used by servers right away, which only happens
+// if no containers are running on it. The updated plan is returned either way.
+func (rt *Router) routeNetworkMigrate(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	if server.GetNetworkMigration() == nil {
+		http.Error(w, "the docker network matches the configuration", http.StatusConflict)
+		return
+	}
+
+	if err := server.MigrateDockerNetwork(); err != nil {
+		zap.S().Errorw("failed to recreate docker network", zap.Error(err))
+
+		http.Error(w, "failed to recreate docker network", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Migration *server.NetworkMigration `json:"migration"`
+	}{Migration: server.GetNetworkMigration()})
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+)
+
+// Returns every port allocated to a server on the node, along with any ports that are
+// allocated to more than one server.
+func (rt *Router) routeSystemPorts(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	claims := server.GetPortClaims()
+	if claims == nil {
+		claims = []server.PortClaim{}
+	}
+
+	conflicts := server.GetPortConflicts()
+	if conflicts == nil {
+		conflicts = []server.PortConflict{}
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Ports     []server.PortClaim    `json:"ports"`
+		Conflicts []server.PortConflict `json:"conflicts"`
+	}{claims, conflicts})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+)
+
+// A power action that can be sent to a server, along with the websocket permission
+// needed to send it.
+type powerActionResponse struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Permission  string `json:"permission"`
+	Builtin     bool   `json:"builtin"`
+}
+
+// Returns the power actions that can be sent to a server, which are the actions built into
+// the daemon followed by any defined by the egg of the server.
+func (rt *Router) routeServerPowerActions(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	out := []powerActionResponse{}
+	for _, name := range server.BuiltinPowerActions {
+		out = append(out, powerActionResponse{Name: name, Permission: PermissionSendPower, Builtin: true})
+	}
+
+	for _, a := range s.PowerActions() {
+		out = append(out, powerActionResponse{
+			Name:        a.Name,
+			Description: a.Description,
+			Permission:  server.PowerActionPermission(a),
+		})
+	}
+
+	json.NewEncoder(w).Encode(out)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/buger/jsonparser"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Returns the configuration profiles defined for a server.
+func (rt *Router) routeServerProfiles(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	set, err := s.GetProfiles()
+	if err != nil {
+		zap.S().Errorw("failed to load server profiles", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to load server profiles", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(set)
+}
+
+// Replaces the configuration profiles and schedules defined for a server.
+func (rt *Router) routeServerUpdateProfiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var data struct {
+		Profiles  map[string]server.Profile `json:"profiles"`
+		Schedules []server.ProfileSchedule  `json:"schedules"`
+	}
+
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &data); err != nil {
+		http.Error(w, "could not parse profiles from request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if data.Profiles == nil {
+		data.Profiles = make(map[string]server.Profile)
+	}
+
+	if err := s.UpdateProfiles(data.Profiles, data.Schedules); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.ProfileUpdate, audit.PanelActor, s.Uuid, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Switches the server to a configuration profile. An empty profile name switches the
+// server back to its normal configuration.
+func (rt *Router) routeServerActivateProfile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	name, _ := jsonparser.GetString(rt.ReaderToBytes(r.Body), "profile")
+
+	if err := s.ActivateProfile(name); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.ProfileActivate, audit.PanelActor, s.Uuid, map[string]string{"profile": name})
+
+	w.WriteHeader(http.StatusAccepted)
+}
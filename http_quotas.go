@@ -0,0 +1,19 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"net/http"
+)
+
+// Returns the resources in use by the running servers of every group that has a quota
+// defined on the node.
+func (rt *Router) routeGroupQuotas(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	q := server.GroupQuotas()
+	if q == nil {
+		q = []server.GroupQuotaUsage{}
+	}
+
+	json.NewEncoder(w).Encode(q)
+}
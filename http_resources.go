@@ -0,0 +1,49 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// Changes the CPU, memory and process limits of a server, applying them to the running
+// server process without a restart where possible. The response lists the limits that
+// could only be changed by restarting the server.
+func (rt *Router) routeServerUpdateResources(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var l server.ResourceLimits
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &l); err != nil {
+		http.Error(w, "could not parse resource limits from request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := l.Validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	res, err := s.UpdateResourceLimits(l)
+	if err != nil {
+		if server.IsGroupQuotaError(err) || server.IsAdmissionError(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		zap.S().Errorw("failed to update server resource limits", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to update server resource limits", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(audit.ServerUpdate, audit.PanelActor, s.Uuid, map[string]string{
+		"applied":         strings.Join(res.Applied, ","),
+		"pending_restart": strings.Join(res.PendingRestart, ","),
+	})
+
+	json.NewEncoder(w).Encode(res)
+}
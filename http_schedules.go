@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Returns the scheduled tasks defined for a server along with the result of the last
+// time each of them was run.
+func (rt *Router) routeServerSchedules(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		zap.S().Errorw("failed to load server schedules", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to load server schedules", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(schedules)
+}
+
+// Returns a single scheduled task for a server.
+func (rt *Router) routeServerSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	sc, err := s.GetSchedule(ps.ByName("schedule"))
+	if err != nil {
+		zap.S().Errorw("failed to load server schedules", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to load server schedules", http.StatusInternalServerError)
+		return
+	}
+
+	if sc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(sc)
+}
+
+// Replaces all of the scheduled tasks for a server, this is used by the Panel to keep the
+// schedules on the node in sync with its own.
+func (rt *Router) routeServerSyncSchedules(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var schedules []server.Schedule
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &schedules); err != nil {
+		http.Error(w, "could not parse schedules from request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if schedules == nil {
+		schedules = []server.Schedule{}
+	}
+
+	if err := s.SyncSchedules(schedules); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.ScheduleUpdate, audit.PanelActor, s.Uuid, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Creates a scheduled task for a server, replacing any existing task with the same id.
+func (rt *Router) routeServerSaveSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var sc server.Schedule
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &sc); err != nil {
+		http.Error(w, "could not parse schedule from request", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.SaveSchedule(sc); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.ScheduleUpdate, audit.PanelActor, s.Uuid, map[string]string{"schedule": sc.Id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Removes a scheduled task from a server.
+func (rt *Router) routeServerDeleteSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	id := ps.ByName("schedule")
+
+	ok, err := s.DeleteSchedule(id)
+	if err != nil {
+		zap.S().Errorw("failed to delete server schedule", zap.String("server", s.Uuid), zap.String("schedule", id), zap.Error(err))
+		http.Error(w, "failed to delete server schedule", http.StatusInternalServerError)
+		return
+	}
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	audit.Log(audit.ScheduleDelete, audit.PanelActor, s.Uuid, map[string]string{"schedule": id})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Runs a scheduled task for a server immediately, regardless of its cron expression, and
+// returns the result once it has finished.
+func (rt *Router) routeServerRunSchedule(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	sc, err := s.GetSchedule(ps.ByName("schedule"))
+	if err != nil {
+		zap.S().Errorw("failed to load server schedules", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to load server schedules", http.StatusInternalServerError)
+		return
+	}
+
+	if sc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	run, err := s.RunSchedule(*sc)
+	if err == server.ErrScheduleRunning {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	audit.Log(audit.ScheduleRun, audit.PanelActor, s.Uuid, map[string]string{"schedule": sc.Id, "action": sc.Action})
+
+	json.NewEncoder(w).Encode(run)
+}
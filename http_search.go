@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// Searches the files of a server by name and optionally by content. Each matching file
+// is written as a newline delimited JSON object as soon as it is found, so that results
+// can be shown while the rest of the directory is still being searched.
+func (rt *Router) routeServerSearchFiles(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	q := r.URL.Query()
+
+	opts := server.SearchOptions{
+		Directory:  q.Get("directory"),
+		Pattern:    q.Get("pattern"),
+		Content:    q.Get("content"),
+		IgnoreCase: q.Get("ignore_case") == "true" || q.Get("ignore_case") == "1",
+	}
+	opts.Limit, _ = strconv.Atoi(q.Get("limit"))
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+
+	enc := json.NewEncoder(w)
+	f, _ := w.(http.Flusher)
+
+	found := 0
+	err := s.Filesystem.Search(r.Context(), opts, func(res *server.SearchResult) error {
+		if err := enc.Encode(res); err != nil {
+			return err
+		}
+
+		if found++; f != nil {
+			f.Flush()
+		}
+
+		return nil
+	})
+
+	// Nothing more can be sent once the client has gone away, or once results have been
+	// written to the response.
+	if err == nil || r.Context().Err() != nil {
+		return
+	} else if found > 0 {
+		zap.S().Warnw("failed to search server files", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Returns the snapshot being read by an external tool. These requests do not use the
+// token for the daemon, instead the token issued when the snapshot was created must be
+// provided either as a bearer token or in the "token" query parameter, so that tools which
+// can only be given a URL are able to read it. If the snapshot does not exist, has expired
+// or the token is wrong an error is written and nil is returned.
+func authenticateSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) *server.Snapshot {
+	token := r.URL.Query().Get("token")
+	if auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(auth) == 2 && auth[0] == "Bearer" {
+		token = auth[1]
+	}
+
+	sn, err := server.GetSnapshot(ps.ByName("snapshot"), token)
+	if err != nil {
+		if os.IsPermission(err) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "authorization failed", http.StatusUnauthorized)
+		} else {
+			http.NotFound(w, r)
+		}
+
+		return nil
+	}
+
+	return sn
+}
+
+// Returns the snapshots of a server that have not yet expired.
+func (rt *Router) routeServerSnapshots(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	json.NewEncoder(w).Encode(s.Snapshots())
+}
+
+// Creates a read-only snapshot of the server data. The response includes the token that
+// external tools use to read the snapshot, which is not returned again.
+func (rt *Router) routeServerCreateSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var data struct {
+		// The number of minutes the snapshot is available for.
+		Ttl    int  `json:"ttl"`
+		Freeze bool `json:"freeze"`
+	}
+
+	if b := rt.ReaderToBytes(r.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, "request body must be valid JSON", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	sn, token, err := s.CreateSnapshot(time.Duration(data.Ttl)*time.Minute, data.Freeze)
+	if err != nil {
+		zap.S().Warnw("failed to create snapshot of server data", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.SnapshotCreate, audit.PanelActor, s.Uuid, map[string]string{
+		"snapshot": sn.Id,
+		"method":   sn.Method,
+		"frozen":   strconv.FormatBool(sn.Frozen),
+	})
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		*server.Snapshot
+		Token string `json:"token"`
+	}{sn, token})
+}
+
+// Removes a snapshot of a server before it expires.
+func (rt *Router) routeServerDeleteSnapshot(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	if err := s.DeleteSnapshot(ps.ByName("snapshot")); err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		zap.S().Errorw("failed to delete snapshot of server data", zap.String("server", s.Uuid), zap.String("snapshot", ps.ByName("snapshot")), zap.Error(err))
+
+		http.Error(w, "failed to delete snapshot", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(audit.SnapshotDelete, audit.PanelActor, s.Uuid, map[string]string{"snapshot": ps.ByName("snapshot")})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Streams the entire snapshot as a gzip compressed tarball.
+func (rt *Router) routeSnapshotDownload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sn := authenticateSnapshot(w, r, ps)
+	if sn == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+sn.Id+".tar.gz")
+
+	if err := sn.Archive(w); err != nil {
+		zap.S().Warnw("failed to stream snapshot archive", zap.String("server", sn.Server), zap.String("snapshot", sn.Id), zap.Error(err))
+	}
+}
+
+// Returns a single file from a snapshot, or the contents of a directory as JSON. Range
+// requests are supported for files, so that large region files can be read in parts.
+func (rt *Router) routeSnapshotFile(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	sn := authenticateSnapshot(w, r, ps)
+	if sn == nil {
+		return
+	}
+
+	p := sn.Path(ps.ByName("path"))
+
+	st, err := os.Stat(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if st.IsDir() {
+		entries, err := sn.List(ps.ByName("path"))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+
+		json.NewEncoder(w).Encode(entries)
+		return
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, st.Name(), st.ModTime(), f)
+}
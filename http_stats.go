@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// The number of points returned from the stats history when the request does not define
+// a step, and the most that can be requested.
+const (
+	defaultStatsHistoryPoints = 360
+	maxStatsHistoryPoints     = 5000
+)
+
+// Returns the resource usage history of a server kept on the node. The range can be
+// limited using the "since" and "until" parameters in the same way as the console logs.
+// The history is combined into points of the "step" duration, such as "5m", or into
+// roughly the number of points given by the "points" parameter.
+func (rt *Router) routeServerStatsHistory(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	var q server.StatsHistoryQuery
+	var err error
+
+	if q.Since, err = parseLogTime(r.URL.Query().Get("since")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if q.Until, err = parseLogTime(r.URL.Query().Get("until")); err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if v := r.URL.Query().Get("step"); v != "" {
+		if q.Step, err = time.ParseDuration(v); err != nil || q.Step <= 0 {
+			http.Error(w, "step must be a duration such as \"5m\"", http.StatusUnprocessableEntity)
+			return
+		}
+	} else {
+		points := defaultStatsHistoryPoints
+		if v := r.URL.Query().Get("points"); v != "" {
+			if points, err = strconv.Atoi(v); err != nil || points <= 0 || points > maxStatsHistoryPoints {
+				http.Error(w, "points must be a number between 1 and "+strconv.Itoa(maxStatsHistoryPoints), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+
+		since, until := q.Since, q.Until
+		if since.IsZero() {
+			since = time.Now().Add(-time.Duration(config.Get().System.StatsHistory.Window) * time.Hour)
+		}
+
+		if until.IsZero() {
+			until = time.Now()
+		}
+
+		q.Step = until.Sub(since) / time.Duration(points)
+	}
+
+	history, err := s.StatsHistory(q)
+	if err != nil {
+		zap.S().Errorw("failed to read server stats history", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to read stats history", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(history)
+}
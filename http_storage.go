@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Returns the disk space used by the data the daemon stores for itself, such as the
+// audit log and installation logs.
+func (rt *Router) routeStorageUsage(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	json.NewEncoder(w).Encode(audit.Usage())
+}
+
+// Compacts the audit log immediately, rather than waiting for it to reach the configured
+// size, and removes any segments that are older than the retention period.
+func (rt *Router) routeStorageCompact(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	r, err := audit.Compact()
+	if err != nil {
+		zap.S().Errorw("failed to compact audit log", zap.Error(err))
+
+		http.Error(w, "failed to compact audit log", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(r)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/buger/jsonparser"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"net/http"
+)
+
+// Returns the progress of the current or last storage migration for a server.
+func (rt *Router) routeServerStorageMigration(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	m := s.StorageMigration()
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(m)
+}
+
+// Begins moving the data of a server to another storage pool on the node. An empty pool
+// moves the data back to the data directory of the node.
+func (rt *Router) routeServerMigrateStorage(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	pool, err := jsonparser.GetString(rt.ReaderToBytes(r.Body), "pool")
+	if err != nil && err != jsonparser.KeyPathNotFoundError {
+		http.Error(w, "pool must be a string", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if err := s.MigrateStorage(pool); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	audit.Log(audit.StorageMigrate, audit.PanelActor, s.Uuid, map[string]string{"pool": pool})
+
+	w.WriteHeader(http.StatusAccepted)
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/buger/jsonparser"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+)
+
+// Returns all of the server templates that exist on this node.
+func (rt *Router) routeTemplates(w http.ResponseWriter, _ *http.Request, _ httprouter.Params) {
+	t, err := server.GetTemplates()
+	if err != nil {
+		zap.S().Errorw("failed to list server templates", zap.Error(err))
+
+		http.Error(w, "failed to list templates", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(t)
+}
+
+// Returns the manifest of a single template on this node.
+func (rt *Router) routeTemplate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := server.GetTemplate(ps.ByName("template"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	json.NewEncoder(w).Encode(t)
+}
+
+// Streams the data archive for a template back to the caller. This is used by peer
+// nodes that are instantiating a server from a template stored on this node.
+func (rt *Router) routeTemplateDownload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := server.GetTemplate(ps.ByName("template"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	f, err := os.Open(t.ArchivePath())
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", "attachment; filename="+t.Name+".tar.gz")
+
+	http.ServeContent(w, r, t.Name+".tar.gz", t.CreatedAt, f)
+}
+
+// Deletes a template from the node.
+func (rt *Router) routeTemplateDelete(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	t, err := server.GetTemplate(ps.ByName("template"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err := t.Delete(); err != nil {
+		zap.S().Errorw("failed to delete server template", zap.String("template", t.Name), zap.Error(err))
+
+		http.Error(w, "failed to delete template", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(audit.TemplateDelete, audit.PanelActor, "", map[string]string{"template": t.Name})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Creates a new template using the current data and configuration of a server. This
+// runs in the background since archiving a large server can take some time. If object
+// storage URLs are provided the template is also uploaded to them once it is created.
+func (rt *Router) routeServerCreateTemplate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	data := rt.ReaderToBytes(r.Body)
+	name, _ := jsonparser.GetString(data, "name")
+	if name == "" {
+		http.Error(w, "a template name must be provided", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if !server.ValidTemplateName(name) {
+		http.Error(w, server.ErrInvalidTemplateName.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	storage, err := templateObjectStorage(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	audit.Log(audit.TemplateCreate, audit.PanelActor, s.Uuid, map[string]string{"template": name})
+
+	go func(s *server.Server, name string) {
+		t, err := s.CreateTemplate(name)
+		if err != nil {
+			zap.S().Errorw("failed to create template from server", zap.String("server", s.Uuid), zap.String("template", name), zap.Error(err))
+			return
+		}
+
+		if storage != nil {
+			if err := t.Upload(*storage); err != nil {
+				zap.S().Errorw("failed to upload template to object storage", zap.String("server", s.Uuid), zap.String("template", name), zap.Error(err))
+			}
+		}
+	}(s, name)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Instantiates a server using the data from a template. If object storage URLs are provided
+// the template is fetched from object storage, if a remote node is provided it is fetched
+// from that node, and otherwise it must exist on this node.
+func (rt *Router) routeServerApplyTemplate(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	if rt.rejectIfDraining(w) {
+		return
+	}
+
+	data := rt.ReaderToBytes(r.Body)
+	name, _ := jsonparser.GetString(data, "template")
+	remote, _ := jsonparser.GetString(data, "remote", "url")
+	token, _ := jsonparser.GetString(data, "remote", "token")
+
+	storage, err := templateObjectStorage(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if name == "" && storage == nil {
+		http.Error(w, "a template name must be provided", http.StatusUnprocessableEntity)
+		return
+	}
+
+	if storage == nil && !server.ValidTemplateName(name) {
+		http.Error(w, server.ErrInvalidTemplateName.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if storage == nil && remote == "" {
+		if _, err := server.GetTemplate(name); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	audit.Log(audit.TemplateApply, audit.PanelActor, s.Uuid, map[string]string{"template": name, "remote": remote})
+
+	go func(s *server.Server) {
+		var err error
+		if storage != nil {
+			err = s.ApplyObjectStorageTemplate(*storage)
+		} else if remote != "" {
+			err = s.ApplyRemoteTemplate(remote, token, name)
+		} else {
+			err = s.ApplyLocalTemplate(name)
+		}
+
+		if err != nil {
+			zap.S().Errorw("failed to apply template to server", zap.String("server", s.Uuid), zap.String("template", name), zap.Error(err))
+		}
+	}(s)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// Returns the object storage URLs provided in a template request, or nil if the template
+// is not stored in object storage.
+func templateObjectStorage(data []byte) (*server.TemplateObjectStorage, error) {
+	v, _, _, err := jsonparser.Get(data, "object_storage")
+	if err == jsonparser.KeyPathNotFoundError {
+		return nil, nil
+	}
+
+	o := new(server.TemplateObjectStorage)
+	if err != nil || json.Unmarshal(v, o) != nil {
+		return nil, errors.New("invalid object storage details provided")
+	}
+
+	if o.ArchiveUrl == "" || o.ManifestUrl == "" {
+		return nil, errors.New("both an archive and manifest URL must be provided for object storage")
+	}
+
+	return o, nil
+}
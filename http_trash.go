@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"net/http"
+	"os"
+)
+
+// Returns the items in the trash of a server, the most recently deleted first.
+func (rt *Router) routeServerTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	items, err := s.Filesystem.TrashItems()
+	if err != nil {
+		zap.S().Errorw("failed to read server trash", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to read server trash", http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(items)
+}
+
+// Restores an item from the trash of a server, either to the path it was deleted from or
+// to the location provided.
+func (rt *Router) routeServerRestoreTrashItem(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var data struct {
+		Location string `json:"location"`
+	}
+
+	if b := rt.ReaderToBytes(r.Body); len(b) > 0 {
+		if err := json.Unmarshal(b, &data); err != nil {
+			http.Error(w, "could not parse request body", http.StatusUnprocessableEntity)
+			return
+		}
+	}
+
+	item, err := s.Filesystem.RestoreTrashItem(ps.ByName("item"), data.Location)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			http.NotFound(w, r)
+		case err == server.ErrTrashDestinationExists:
+			http.Error(w, err.Error(), http.StatusConflict)
+		case server.IsFileRuleError(err):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			zap.S().Errorw("failed to restore item from server trash", zap.String("server", s.Uuid), zap.String("item", ps.ByName("item")), zap.Error(err))
+
+			http.Error(w, "failed to restore item from trash", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	audit.Log(audit.FileRestore, audit.PanelActor, s.Uuid, map[string]string{"item": item.Id, "location": item.Path})
+
+	json.NewEncoder(w).Encode(item)
+}
+
+// Permanently removes an item from the trash of a server.
+func (rt *Router) routeServerDeleteTrashItem(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	if err := s.Filesystem.DeleteTrashItem(ps.ByName("item")); err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(audit.TrashDelete, audit.PanelActor, s.Uuid, map[string]string{"item": ps.ByName("item")})
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Permanently removes every item in the trash of a server.
+func (rt *Router) routeServerEmptyTrash(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	if err := s.Filesystem.EmptyTrash(); err != nil {
+		zap.S().Errorw("failed to empty server trash", zap.String("server", s.Uuid), zap.Error(err))
+
+		http.Error(w, "failed to empty server trash", http.StatusInternalServerError)
+		return
+	}
+
+	audit.Log(audit.TrashDelete, audit.PanelActor, s.Uuid, nil)
+
+	w.WriteHeader(http.StatusNoContent)
+}
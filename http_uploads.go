@@ -0,0 +1,220 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+)
+
+// Matches the Content-Range header sent with each chunk of an upload.
+var contentRangeRegex = regexp.MustCompile(`^bytes (\d+)-(\d+)/(\d+)$`)
+
+// Writes the upload to the response along with its offset in the Upload-Offset header,
+// leaving out the internal checksum state.
+func writeUpload(w http.ResponseWriter, status int, u *server.Upload) {
+	c := *u
+	c.HashState = nil
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(c.Offset, 10))
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(c)
+}
+
+// Returns the uploads in progress for a server.
+func (rt *Router) routeServerUploads(w http.ResponseWriter, _ *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	list, err := s.Uploads()
+	if err != nil {
+		zap.S().Errorw("failed to list server uploads", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to list uploads", http.StatusInternalServerError)
+		return
+	}
+
+	for _, u := range list {
+		u.HashState = nil
+	}
+
+	json.NewEncoder(w).Encode(list)
+}
+
+// Returns a single upload, including the offset the next chunk must start at. Clients
+// use this to find where to resume from after a chunk failed.
+func (rt *Router) routeServerUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	u, err := s.GetUpload(ps.ByName("upload"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		zap.S().Errorw("failed to read server upload", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to read upload", http.StatusInternalServerError)
+		return
+	}
+
+	writeUpload(w, http.StatusOK, u)
+}
+
+// Starts a resumable upload of a file to a server. The size of the file must be provided
+// up front so that the disk space available to the server can be checked before any of
+// the file is sent, along with the SHA-256 checksum the file is verified against once
+// every chunk has been received.
+func (rt *Router) routeServerCreateUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	var data struct {
+		Path     string `json:"path"`
+		Size     int64  `json:"size"`
+		Checksum string `json:"checksum"`
+	}
+
+	if err := json.Unmarshal(rt.ReaderToBytes(r.Body), &data); err != nil {
+		http.Error(w, "could not parse upload from request", http.StatusBadRequest)
+		return
+	}
+
+	u, err := s.CreateUpload(data.Path, data.Size, data.Checksum)
+	if err != nil {
+		if server.IsDiskSpaceError(err) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		if server.IsFileRuleError(err) {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
+
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if u.Complete {
+		audit.Log(audit.FileWrite, audit.PanelActor, s.Uuid, map[string]string{"file": u.Path, "upload": u.Id})
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/api/servers/%s/files/uploads/%s", s.Uuid, u.Id))
+	writeUpload(w, http.StatusCreated, u)
+}
+
+// Writes a chunk of an upload. The chunk must start at the current offset of the upload,
+// given either by a Content-Range header or an Upload-Offset header in which case the
+// rest of the file may be sent at once. If the connection fails part way through the
+// chunk, the bytes that were received are kept and the client can resume from the offset
+// returned for the upload.
+func (rt *Router) routeServerWriteUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+	defer r.Body.Close()
+
+	u, err := s.GetUpload(ps.ByName("upload"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		zap.S().Errorw("failed to read server upload", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to read upload", http.StatusInternalServerError)
+		return
+	}
+
+	var body io.Reader = r.Body
+	var offset, end int64
+
+	if h := r.Header.Get("Content-Range"); h != "" {
+		m := contentRangeRegex.FindStringSubmatch(h)
+		if m == nil {
+			http.Error(w, "invalid Content-Range header", http.StatusBadRequest)
+			return
+		}
+
+		offset, _ = strconv.ParseInt(m[1], 10, 64)
+		end, _ = strconv.ParseInt(m[2], 10, 64)
+		total, _ := strconv.ParseInt(m[3], 10, 64)
+
+		if total != u.Size || end < offset || end >= u.Size {
+			http.Error(w, "Content-Range header does not match the size of the upload", http.StatusBadRequest)
+			return
+		}
+
+		body = io.LimitReader(r.Body, end-offset+1)
+	} else if h := r.Header.Get("Upload-Offset"); h != "" {
+		if offset, err = strconv.ParseInt(h, 10, 64); err != nil {
+			http.Error(w, "invalid Upload-Offset header", http.StatusBadRequest)
+			return
+		}
+
+		end = u.Size - 1
+	} else {
+		http.Error(w, "a Content-Range or Upload-Offset header must be provided", http.StatusBadRequest)
+		return
+	}
+
+	u, err = s.WriteUploadChunk(u.Id, offset, body)
+	if err != nil {
+		if o, ok := server.UploadOffsetFromError(err); ok {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(o, 10))
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+
+		switch {
+		case server.IsUploadBusyError(err):
+			http.Error(w, err.Error(), http.StatusConflict)
+		case server.IsUploadChecksumError(err):
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		case os.IsNotExist(errors.Cause(err)):
+			http.NotFound(w, r)
+		default:
+			zap.S().Warnw("failed to write chunk of server upload", zap.String("server", s.Uuid), zap.String("upload", ps.ByName("upload")), zap.Error(err))
+
+			if u != nil {
+				w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+			}
+			http.Error(w, "failed to write chunk of upload", http.StatusInternalServerError)
+		}
+
+		return
+	}
+
+	if u.Complete {
+		audit.Log(audit.FileWrite, audit.PanelActor, s.Uuid, map[string]string{"file": u.Path, "upload": u.Id})
+	} else if u.Offset <= end {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		http.Error(w, "chunk ended before the end of its range", http.StatusBadRequest)
+		return
+	}
+
+	writeUpload(w, http.StatusOK, u)
+}
+
+// Cancels an upload, removing the data that was received for it.
+func (rt *Router) routeServerCancelUpload(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	if err := s.RemoveUpload(ps.ByName("upload")); err != nil {
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+
+		zap.S().Errorw("failed to remove server upload", zap.String("server", s.Uuid), zap.Error(err))
+		http.Error(w, "failed to remove upload", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
+	"net/http"
+)
+
+// Handles the "wings lint <server>" command which runs the configuration file parsers
+// for a server in the running daemon without modifying any files, and prints a report
+// of what each replacement would do.
+func runLintCommand(c *config.Configuration, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: wings lint <server uuid>")
+	}
+
+	b, err := daemonRequest(c, http.MethodGet, "/api/servers/"+args[0]+"/configuration/lint", nil)
+	if err != nil {
+		return err
+	}
+
+	var reports []parser.LintReport
+	if err := json.Unmarshal(b, &reports); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if len(reports) == 0 {
+		fmt.Println("server does not define any configuration files")
+		return nil
+	}
+
+	for _, r := range reports {
+		fmt.Printf("%s (%s)\n", r.File, r.Parser)
+
+		if r.Error != "" {
+			fmt.Printf("  error: %s\n", r.Error)
+		}
+
+		if r.Created {
+			fmt.Println("  file does not exist and will be created")
+		}
+
+		for _, rr := range r.Replacements {
+			fmt.Printf("  %s = %s (%d matches)\n", rr.Match, rr.Value, rr.Matches)
+
+			for _, w := range rr.Warnings {
+				fmt.Printf("    warning: %s\n", w)
+			}
+		}
+	}
+
+	return nil
+}
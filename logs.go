@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/daemonlog"
+	"strings"
+	"time"
+)
+
+// Handles the "wings logs" command which prints the entries in the daemon log, including
+// rotated and compressed files, optionally filtered by server and time range. This reads
+// the log files directly, so the daemon does not need to be running.
+func runLogsCommand(c *config.Configuration, args []string) error {
+	fs := flag.NewFlagSet("logs", flag.ExitOnError)
+	srv := fs.String("server", "", "only show entries for the server with this UUID")
+	since := fs.String("since", "", "only show entries after this time, either RFC3339 or a duration such as \"2h\"")
+	until := fs.String("until", "", "only show entries before this time, either RFC3339 or a duration such as \"30m\"")
+	level := fs.String("level", "", "only show entries of at least this level, such as \"warn\"")
+	raw := fs.Bool("json", false, "print entries as JSON")
+	fs.Parse(args)
+
+	if c.System.Log.Directory == "" {
+		return errors.New("the daemon log is not written to the disk, no log directory is configured")
+	}
+
+	f := daemonlog.Filter{Server: *srv, Level: *level}
+
+	var err error
+	if f.Since, err = parseLogTime(*since); err != nil {
+		return err
+	}
+
+	if f.Until, err = parseLogTime(*until); err != nil {
+		return err
+	}
+
+	return daemonlog.Read(c.System.Log.Directory, f, func(e daemonlog.Entry) {
+		if *raw {
+			e.Fields["time"] = e.Time
+			e.Fields["level"] = e.Level
+			e.Fields["message"] = e.Message
+
+			b, _ := json.Marshal(e.Fields)
+			fmt.Println(string(b))
+			return
+		}
+
+		line := fmt.Sprintf("%s  %-5s  %s", e.Time.Local().Format("2006-01-02 15:04:05"), strings.ToUpper(e.Level), e.Message)
+		if len(e.Fields) > 0 {
+			b, _ := json.Marshal(e.Fields)
+			line += "  " + string(b)
+		}
+
+		fmt.Println(line)
+	})
+}
+
+// Parses a time given on the command line, which is either an absolute RFC3339 time or
+// a duration before the current time. An empty value returns the zero time.
+func parseLogTime(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	if d, err := time.ParseDuration(v); err == nil {
+		return time.Now().Add(-d), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return t, errors.Errorf("invalid time \"%s\", expected RFC3339 or a duration such as \"2h\"", v)
+	}
+
+	return t, nil
+}
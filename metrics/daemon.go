@@ -0,0 +1,76 @@
+package metrics
+
+// All of the metrics published by the daemon. Subsystems should publish into these
+// directly rather than registering their own metrics wherever possible so that all of
+// the metric names are defined in a single location.
+var (
+	ServerCpuAbsolute = NewGaugeVec("wings_server_cpu_absolute", "The absolute CPU usage of the server process as a percentage.", "server")
+	ServerMemory      = NewGaugeVec("wings_server_memory_bytes", "The memory in use by the server process in bytes.", "server")
+	ServerMemoryLimit = NewGaugeVec("wings_server_memory_limit_bytes", "The memory limit of the server process in bytes.", "server")
+	ServerNetworkRx   = NewGaugeVec("wings_server_network_rx_bytes", "The total bytes received by the server process.", "server")
+	ServerNetworkTx   = NewGaugeVec("wings_server_network_tx_bytes", "The total bytes transmitted by the server process.", "server")
+	ServerDisk        = NewGaugeVec("wings_server_disk_bytes", "The disk space used by the server in bytes.", "server")
+	ServerState       = NewCounterVec("wings_server_state_changes_total", "The number of times a server has entered a given state.", "server", "state")
+
+	ServerCpuThrottledPercent = NewGaugeVec("wings_server_cpu_throttled_percent", "The percentage of scheduler periods in which the server process was throttled by its CPU limit.", "server")
+	ServerCpuThrottledSeconds = NewGaugeVec("wings_server_cpu_throttled_seconds", "The total time the server process has been throttled by its CPU limit.", "server")
+
+	HostCpuSteal = NewGaugeVec("wings_host_cpu_steal_percent", "The percentage of CPU time stolen from the host by the hypervisor.")
+
+	ServerConnections      = NewGaugeVec("wings_server_connections", "The number of tracked inbound connections to the server allocations.", "server", "protocol")
+	ServerPacketsPerSecond = NewGaugeVec("wings_server_packets_per_second", "The packets per second sent and received over the server connections.", "server")
+
+	ServerPlayers    = NewGaugeVec("wings_server_players", "The number of players connected to the server, as reported by its query protocol.", "server")
+	ServerMaxPlayers = NewGaugeVec("wings_server_max_players", "The maximum number of players allowed on the server, as reported by its query protocol.", "server")
+
+	ServerInstallDuration = NewHistogramVec(
+		"wings_server_install_duration_seconds", "The time taken to run a server installation process.",
+		[]float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600}, "successful",
+	)
+
+	ServerBootPhaseDuration = NewHistogramVec(
+		"wings_server_boot_phase_duration_seconds", "The time taken by each phase of booting a server.",
+		[]float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}, "phase",
+	)
+	ServerBootDuration = NewHistogramVec(
+		"wings_server_boot_duration_seconds", "The time taken for a server to finish booting, until it is ready if the egg defines a readiness check.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200},
+	)
+
+	ApiRequestDuration = NewHistogramVec("wings_api_request_duration_seconds", "The time taken to respond to API requests.", nil, "method", "route", "status")
+
+	TempBytes           = NewGaugeVec("wings_temp_bytes", "The disk space used by temporary files in bytes.")
+	TempEntries         = NewGaugeVec("wings_temp_entries", "The number of temporary files and directories.")
+	TempQuotaRejections = NewCounterVec("wings_temp_quota_rejections_total", "The number of temporary files refused because the temporary directory was full.")
+
+	WebsocketThrottled = NewCounterVec("wings_websocket_throttled_total", "The number of websocket and event stream connections refused for reconnecting too quickly.", "scope")
+
+	SftpLogins   = NewCounterVec("wings_sftp_logins_total", "The number of SFTP authentication attempts.", "result")
+	SftpSessions = NewGaugeVec("wings_sftp_sessions", "The number of open SFTP sessions.")
+
+	BackupDuration = NewHistogramVec(
+		"wings_backup_duration_seconds", "The time taken to create a local backup of a server.",
+		[]float64{1, 5, 10, 30, 60, 120, 300, 600, 1200, 1800, 3600}, "successful",
+	)
+
+	HealthStatus = NewGaugeVec("wings_health_status", "The status of each subsystem of the daemon, 0 when healthy, 1 when degraded and 2 when unhealthy.", "subsystem")
+)
+
+// Removes all of the per-server metrics for the given server. This should be called
+// when a server is deleted from the node. Anything published for the server afterwards,
+// such as by a routine that was still stopping, is ignored until RestoreServer is called.
+func DeleteServer(uuid string) {
+	removedServers.Lock()
+	removedServers.uuids[uuid] = true
+	removedServers.Unlock()
+
+	DeleteLabelValues("server", uuid)
+}
+
+// Allows metrics to be published again for a server that was previously deleted, for
+// when a server with the same UUID is created on the node again.
+func RestoreServer(uuid string) {
+	removedServers.Lock()
+	delete(removedServers.uuids, uuid)
+	removedServers.Unlock()
+}
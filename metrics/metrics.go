@@ -0,0 +1,309 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Defines the types of metrics that can be registered.
+const (
+	gaugeType     = "gauge"
+	counterType   = "counter"
+	histogramType = "histogram"
+)
+
+// The default buckets used by histograms, in seconds. These match the defaults used by
+// the Prometheus client libraries.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A single metric family that can have multiple series identified by their label values.
+type vec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+	value  float64
+
+	// Only used by histograms.
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+var registry = struct {
+	mu   sync.Mutex
+	vecs []*vec
+}{}
+
+// The servers that have been deleted from the node, which no longer have metrics
+// published for them.
+var removedServers = struct {
+	sync.Mutex
+	uuids map[string]bool
+}{uuids: make(map[string]bool)}
+
+func newVec(name string, help string, kind string, labels []string) *vec {
+	v := &vec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+
+	registry.mu.Lock()
+	registry.vecs = append(registry.vecs, v)
+	registry.mu.Unlock()
+
+	return v
+}
+
+// Returns the series for the given label values, creating it if it does not exist.
+func (v *vec) with(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, received %d", v.name, len(v.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	s, ok := v.series[key]
+	if !ok {
+		s = &series{labels: values}
+		if v.kind == histogramType {
+			s.counts = make([]uint64, len(v.buckets))
+		}
+
+		// Series for deleted servers are returned without being stored, so that the value
+		// is discarded rather than being reported again.
+		if !v.removed(values) {
+			v.series[key] = s
+		}
+	}
+
+	return s
+}
+
+// Determines if the label values belong to a server that has been deleted.
+func (v *vec) removed(values []string) bool {
+	for i, l := range v.labels {
+		if l != "server" {
+			continue
+		}
+
+		removedServers.Lock()
+		defer removedServers.Unlock()
+
+		return removedServers.uuids[values[i]]
+	}
+
+	return false
+}
+
+// Removes the series matching the label values from the metric.
+func (v *vec) delete(values []string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	delete(v.series, strings.Join(values, "\xff"))
+}
+
+// Removes every series where the label has the given value from all of the registered
+// metrics.
+func DeleteLabelValues(label string, value string) {
+	registry.mu.Lock()
+	vecs := make([]*vec, len(registry.vecs))
+	copy(vecs, registry.vecs)
+	registry.mu.Unlock()
+
+	for _, v := range vecs {
+		for i, l := range v.labels {
+			if l != label {
+				continue
+			}
+
+			v.mu.Lock()
+			for k, s := range v.series {
+				if s.labels[i] == value {
+					delete(v.series, k)
+				}
+			}
+			v.mu.Unlock()
+		}
+	}
+}
+
+// A gauge is a metric that can arbitrarily go up and down.
+type GaugeVec struct {
+	v *vec
+}
+
+// Registers a new gauge with the given label names.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{v: newVec(name, help, gaugeType, labels)}
+}
+
+// Sets the gauge to the provided value.
+func (g *GaugeVec) Set(value float64, labels ...string) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+
+	g.v.with(labels).value = value
+}
+
+// Adds the provided value to the gauge, this value may be negative.
+func (g *GaugeVec) Add(value float64, labels ...string) {
+	g.v.mu.Lock()
+	defer g.v.mu.Unlock()
+
+	g.v.with(labels).value += value
+}
+
+// Removes the series for the given labels.
+func (g *GaugeVec) Delete(labels ...string) {
+	g.v.delete(labels)
+}
+
+// A counter is a metric that only ever increases.
+type CounterVec struct {
+	v *vec
+}
+
+// Registers a new counter with the given label names.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{v: newVec(name, help, counterType, labels)}
+}
+
+// Increments the counter by one.
+func (c *CounterVec) Inc(labels ...string) {
+	c.v.mu.Lock()
+	defer c.v.mu.Unlock()
+
+	c.v.with(labels).value++
+}
+
+// Removes the series for the given labels.
+func (c *CounterVec) Delete(labels ...string) {
+	c.v.delete(labels)
+}
+
+// A histogram tracks the distribution of observed values in buckets.
+type HistogramVec struct {
+	v *vec
+}
+
+// Registers a new histogram using the buckets provided. If no buckets are given the
+// default buckets are used.
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+
+	v := newVec(name, help, histogramType, labels)
+	v.buckets = buckets
+
+	return &HistogramVec{v: v}
+}
+
+// Records a single observation in the histogram.
+func (h *HistogramVec) Observe(value float64, labels ...string) {
+	h.v.mu.Lock()
+	defer h.v.mu.Unlock()
+
+	s := h.v.with(labels)
+	for i, b := range h.v.buckets {
+		if value <= b {
+			s.counts[i]++
+		}
+	}
+
+	s.count++
+	s.sum += value
+}
+
+// Writes all of the registered metrics to the writer using the Prometheus text
+// exposition format.
+func Write(w io.Writer) error {
+	registry.mu.Lock()
+	vecs := make([]*vec, len(registry.vecs))
+	copy(vecs, registry.vecs)
+	registry.mu.Unlock()
+
+	for _, v := range vecs {
+		if _, err := io.WriteString(w, v.format()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Formats a metric family and all of its series.
+func (v *vec) format() string {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# HELP %s %s\n", v.name, v.help)
+	fmt.Fprintf(&b, "# TYPE %s %s\n", v.name, v.kind)
+
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := v.series[k]
+		if v.kind != histogramType {
+			fmt.Fprintf(&b, "%s%s %s\n", v.name, formatLabels(v.labels, s.labels, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		for i, bucket := range v.buckets {
+			fmt.Fprintf(&b, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labels, "le", formatFloat(bucket)), s.counts[i])
+		}
+		fmt.Fprintf(&b, "%s_bucket%s %d\n", v.name, formatLabels(v.labels, s.labels, "le", "+Inf"), s.count)
+		fmt.Fprintf(&b, "%s_sum%s %s\n", v.name, formatLabels(v.labels, s.labels, "", ""), formatFloat(s.sum))
+		fmt.Fprintf(&b, "%s_count%s %d\n", v.name, formatLabels(v.labels, s.labels, "", ""), s.count)
+	}
+
+	return b.String()
+}
+
+// Formats a set of labels, optionally appending an additional label to the end.
+func formatLabels(names []string, values []string, extraName string, extraValue string) string {
+	var parts []string
+	for i, n := range names {
+		parts = append(parts, fmt.Sprintf("%s=%q", n, values[i]))
+	}
+
+	if extraName != "" {
+		parts = append(parts, fmt.Sprintf("%s=%q", extraName, extraValue))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/migrate"
+)
+
+// Handles the "wings migrate" command which converts the configuration and server data of
+// the legacy Node.js daemon into the layout used by this daemon. Layouts used by older
+// releases of this daemon are not converted. Every change made is recorded in a journal
+// which can be passed back to the command to undo the migration.
+func runMigrateCommand(configPath string, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: wings migrate [flags]")
+		fmt.Fprintln(fs.Output())
+		fmt.Fprintln(fs.Output(), "Converts the configuration and server data of the legacy Node.js daemon into the")
+		fmt.Fprintln(fs.Output(), "layout used by this daemon. Layouts used by older releases of this daemon are not")
+		fmt.Fprintln(fs.Output(), "converted. Both daemons and the containers of the servers being migrated must be")
+		fmt.Fprintln(fs.Output(), "stopped first.")
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+
+	o := migrate.Options{
+		ConfigPath:       configPath,
+		ServersDirectory: "data/servers",
+		JournalDirectory: "data/migrations",
+	}
+
+	fs.StringVar(&o.Legacy, "legacy", "/srv/daemon", "the installation directory of the legacy daemon")
+	dryRun := fs.Bool("dry-run", false, "print the changes that would be made without making them")
+	rollback := fs.String("rollback", "", "undo the changes recorded in a migration journal")
+
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	// Use the container runtime configured for this daemon when checking that the servers
+	// are stopped, if it has been configured already.
+	if c, err := config.ReadConfiguration(configPath); err == nil {
+		config.Set(c)
+	}
+
+	if *rollback != "" {
+		j, err := migrate.Rollback(*rollback)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("undid %d changes\n", len(j.Changes))
+		return nil
+	}
+
+	p, err := migrate.Scan(o)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range p.Warnings {
+		fmt.Printf("warning: %s\n", w)
+	}
+
+	if len(p.Changes) == 0 {
+		fmt.Println("nothing to migrate")
+		return nil
+	}
+
+	for _, c := range p.Changes {
+		if c.Action == migrate.ChangeMove {
+			fmt.Printf("%s: %s -> %s\n", c.Description, c.From, c.Path)
+		} else {
+			fmt.Printf("%s: write %s\n", c.Description, c.Path)
+		}
+	}
+
+	if *dryRun {
+		fmt.Printf("%d changes would be made\n", len(p.Changes))
+		return nil
+	}
+
+	journal, err := migrate.Apply(o, p)
+	if err != nil {
+		if journal != "" {
+			return errors.Wrapf(err, "migration failed, the changes made so far can be undone with \"wings migrate -rollback %s\"", journal)
+		}
+
+		return err
+	}
+
+	fmt.Printf("made %d changes, these can be undone with \"wings migrate -rollback %s\"\n", len(p.Changes), journal)
+
+	return nil
+}
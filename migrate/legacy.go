@@ -0,0 +1,186 @@
+package migrate
+
+import (
+	"encoding/json"
+	"github.com/creasty/defaults"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// The configuration file of the legacy Node.js daemon, found in the "config" directory of
+// its installation. Only the settings that have an equivalent in this daemon are read.
+type legacyConfiguration struct {
+	Web struct {
+		Host   string `json:"host"`
+		Listen int    `json:"listen"`
+		Ssl    struct {
+			Enabled     bool   `json:"enabled"`
+			Certificate string `json:"certificate"`
+			Key         string `json:"key"`
+		} `json:"ssl"`
+	} `json:"web"`
+	Docker struct {
+		Network struct {
+			Name string `json:"name"`
+		} `json:"network"`
+	} `json:"docker"`
+	Sftp struct {
+		Path string `json:"path"`
+		Ip   string `json:"ip"`
+		Port int    `json:"port"`
+	} `json:"sftp"`
+	Remote struct {
+		Base string `json:"base"`
+	} `json:"remote"`
+	Uploads struct {
+		SizeLimit int `json:"size_limit"`
+	} `json:"uploads"`
+	Keys []string `json:"keys"`
+}
+
+// The configuration of a single server in the legacy Node.js daemon, stored as
+// "config/servers/<uuid>/server.json" within its installation.
+type legacyServer struct {
+	Uuid      string `json:"uuid"`
+	Suspended bool   `json:"suspended"`
+	Build     struct {
+		Default struct {
+			Ip   string `json:"ip"`
+			Port int    `json:"port"`
+		} `json:"default"`
+		Ports  map[string][]int  `json:"ports"`
+		Env    map[string]string `json:"env"`
+		Memory int64             `json:"memory"`
+		Swap   int64             `json:"swap"`
+		Io     uint16            `json:"io"`
+		Cpu    int64             `json:"cpu"`
+		Disk   int64             `json:"disk"`
+		Image  string            `json:"image"`
+	} `json:"build"`
+	Container struct {
+		Image string `json:"image"`
+	} `json:"container"`
+}
+
+func readLegacyConfiguration(dir string) (*legacyConfiguration, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "config", "core.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &legacyConfiguration{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errors.Wrap(err, "could not parse legacy daemon configuration")
+	}
+
+	return c, nil
+}
+
+// Converts the configuration of the legacy daemon into the configuration for this daemon,
+// using the defaults for every setting the legacy daemon did not have.
+func (l *legacyConfiguration) convert() (*config.Configuration, error) {
+	c := new(config.Configuration)
+	if err := defaults.Set(c); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if c.System.Sftp == nil {
+		c.System.Sftp = new(config.SftpConfiguration)
+		if err := defaults.Set(c.System.Sftp); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if l.Web.Host != "" {
+		c.Api.Host = l.Web.Host
+	}
+
+	if l.Web.Listen != 0 {
+		c.Api.Port = l.Web.Listen
+	}
+
+	c.Api.Ssl.Enabled = l.Web.Ssl.Enabled
+	c.Api.Ssl.CertificateFile = l.Web.Ssl.Certificate
+	c.Api.Ssl.KeyFile = l.Web.Ssl.Key
+
+	if l.Uploads.SizeLimit > 0 {
+		c.Api.UploadLimit = l.Uploads.SizeLimit
+	}
+
+	if l.Sftp.Path != "" {
+		c.System.Data = l.Sftp.Path
+	}
+
+	if l.Sftp.Ip != "" {
+		c.System.Sftp.Address = l.Sftp.Ip
+	}
+
+	if l.Sftp.Port != 0 {
+		c.System.Sftp.Port = l.Sftp.Port
+	}
+
+	if l.Docker.Network.Name != "" {
+		c.Docker.Network.Name = l.Docker.Network.Name
+	}
+
+	c.PanelLocation = l.Remote.Base
+	if len(l.Keys) > 0 {
+		c.AuthenticationToken = l.Keys[0]
+	}
+
+	return c, nil
+}
+
+func readLegacyServer(p string) (*legacyServer, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &legacyServer{}
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, errors.Wrapf(err, "could not parse legacy server configuration %s", p)
+	}
+
+	return s, nil
+}
+
+// Returns the configuration file for the server used by this daemon. Only the settings
+// needed to boot the server are written, the rest are provided by the Panel when the
+// server is next synced.
+func (l *legacyServer) convert() ([]byte, error) {
+	image := l.Build.Image
+	if image == "" {
+		image = l.Container.Image
+	}
+
+	s := yaml.MapSlice{
+		{Key: "uuid", Value: l.Uuid},
+		{Key: "suspended", Value: l.Suspended},
+		{Key: "environment", Value: l.Build.Env},
+		{Key: "build", Value: yaml.MapSlice{
+			{Key: "memory", Value: l.Build.Memory},
+			{Key: "swap", Value: l.Build.Swap},
+			{Key: "io", Value: l.Build.Io},
+			{Key: "cpu", Value: l.Build.Cpu},
+			{Key: "disk", Value: l.Build.Disk},
+		}},
+		{Key: "allocations", Value: yaml.MapSlice{
+			{Key: "default", Value: yaml.MapSlice{
+				{Key: "ip", Value: l.Build.Default.Ip},
+				{Key: "port", Value: l.Build.Default.Port},
+			}},
+			{Key: "mappings", Value: l.Build.Ports},
+		}},
+		{Key: "container", Value: yaml.MapSlice{
+			{Key: "image", Value: image},
+		}},
+	}
+
+	b, err := yaml.Marshal(s)
+
+	return b, errors.WithStack(err)
+}
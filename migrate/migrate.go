@@ -0,0 +1,383 @@
+package migrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"gopkg.in/yaml.v2"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// The kinds of changes made by a migration.
+const (
+	ChangeMove  = "move"
+	ChangeWrite = "write"
+)
+
+// Defines where the migration reads the legacy data from and writes the converted data to.
+type Options struct {
+	// The installation directory of the legacy Node.js daemon.
+	Legacy string
+
+	// The configuration file for this daemon. The legacy configuration is only converted
+	// if this file does not exist yet.
+	ConfigPath string
+
+	// The directory the configuration files of servers are kept in.
+	ServersDirectory string
+
+	// The directory the journals of applied migrations are written to.
+	JournalDirectory string
+}
+
+// A single change made by a migration. Each change is recorded in the journal before the
+// next one is made, so that a migration that fails part way through can be undone.
+type Change struct {
+	Action      string `json:"action"`
+	Description string `json:"description"`
+	// The file or directory being moved, when moving.
+	From string `json:"from,omitempty"`
+	// The file or directory being written or moved to.
+	Path string `json:"path"`
+	// A copy of the file that was replaced by a write, or empty if the file did not exist.
+	Previous string `json:"previous,omitempty"`
+	// The server the change is made for, if any.
+	Server string `json:"server,omitempty"`
+
+	contents []byte
+}
+
+// The changes needed to migrate the node, along with anything found that could not be
+// migrated automatically.
+type Plan struct {
+	Changes  []Change `json:"changes"`
+	Warnings []string `json:"warnings"`
+}
+
+// The record of a migration that was applied, written to the journal directory.
+type Journal struct {
+	// The installation directory of the legacy daemon that was migrated from.
+	Legacy     string     `json:"legacy"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	RolledBack *time.Time `json:"rolled_back"`
+	Changes    []Change   `json:"changes"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Finds the data on the node using a legacy layout and returns the changes needed to
+// convert it. Nothing is changed on the disk.
+func Scan(o Options) (*Plan, error) {
+	p := &Plan{Changes: []Change{}, Warnings: []string{}}
+
+	legacy, err := readLegacyConfiguration(o.Legacy)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// The data directory used by this daemon, which is read from its configuration if it
+	// already exists and is otherwise the directory used by the legacy daemon.
+	var data string
+	if _, err := os.Stat(o.ConfigPath); err == nil {
+		c, err := config.ReadConfiguration(o.ConfigPath)
+		if err != nil {
+			return nil, errors.Wrap(err, "could not read configuration")
+		}
+
+		data = c.System.Data
+
+		if legacy != nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("%s already exists, the legacy daemon configuration was not converted", o.ConfigPath))
+		}
+	} else if legacy != nil {
+		c, err := legacy.convert()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := yaml.Marshal(c)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		data = c.System.Data
+		p.Changes = append(p.Changes, Change{
+			Action:      ChangeWrite,
+			Description: "convert the legacy daemon configuration",
+			Path:        o.ConfigPath,
+			contents:    b,
+		})
+
+		if c.AuthenticationToken == "" {
+			p.Warnings = append(p.Warnings, "the legacy daemon configuration does not contain a token, one must be added before the daemon can connect to the Panel")
+		}
+	}
+
+	if legacy != nil {
+		if err := scanLegacyServers(o, legacy, data, p); err != nil {
+			return nil, err
+		}
+	}
+
+	return p, nil
+}
+
+// Plans the conversion of the configuration of each server of the legacy daemon, and the
+// move of its data if the data directory has changed.
+func scanLegacyServers(o Options, legacy *legacyConfiguration, data string, p *Plan) error {
+	dirs, err := ioutil.ReadDir(filepath.Join(o.Legacy, "config", "servers"))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+
+		s, err := readLegacyServer(filepath.Join(o.Legacy, "config", "servers", d.Name(), "server.json"))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				p.Warnings = append(p.Warnings, err.Error())
+			}
+
+			continue
+		}
+
+		if s.Uuid == "" {
+			s.Uuid = d.Name()
+		}
+
+		target := filepath.Join(o.ServersDirectory, s.Uuid+".yml")
+		if _, err := os.Stat(target); err == nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("%s already exists, the legacy configuration for server %s was not converted", target, s.Uuid))
+		} else {
+			b, err := s.convert()
+			if err != nil {
+				return err
+			}
+
+			p.Changes = append(p.Changes, Change{
+				Action:      ChangeWrite,
+				Description: "convert the legacy configuration for server " + s.Uuid,
+				Path:        target,
+				Server:      s.Uuid,
+				contents:    b,
+			})
+		}
+
+		if legacy.Sftp.Path == "" || data == "" || filepath.Clean(legacy.Sftp.Path) == filepath.Clean(data) {
+			continue
+		}
+
+		from := filepath.Join(legacy.Sftp.Path, s.Uuid)
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+
+		to := filepath.Join(data, s.Uuid)
+		if _, err := os.Stat(to); err == nil {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("%s already exists, the data for server %s was not moved from %s", to, s.Uuid, from))
+			continue
+		}
+
+		if requiresCopy(from, to) {
+			p.Warnings = append(p.Warnings, fmt.Sprintf("%s is on a different disk than %s, the data for server %s will be copied and then removed which may take some time", from, filepath.Dir(to), s.Uuid))
+		}
+
+		p.Changes = append(p.Changes, Change{
+			Action:      ChangeMove,
+			Description: "move the data for server " + s.Uuid + " to the data directory",
+			From:        from,
+			Path:        to,
+			Server:      s.Uuid,
+		})
+	}
+
+	return nil
+}
+
+// Applies the changes of the plan in order, recording each one in a new journal within the
+// journal directory. The path to the journal is returned, even when a change fails, so that
+// the changes made so far can be undone. Nothing is changed while either daemon or the
+// container of a server being migrated is running.
+func Apply(o Options, p *Plan) (string, error) {
+	if err := checkStopped(o.Legacy, changedServers(p.Changes)); err != nil {
+		return "", err
+	}
+
+	stamp := time.Now().UTC().Format("20060102-150405")
+	journal := filepath.Join(o.JournalDirectory, stamp+".json")
+	previous := filepath.Join(o.JournalDirectory, stamp)
+
+	if err := os.MkdirAll(o.JournalDirectory, 0700); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	j := &Journal{Legacy: o.Legacy, StartedAt: time.Now().UTC(), Changes: []Change{}}
+	if err := j.write(journal); err != nil {
+		return "", err
+	}
+
+	for i, c := range p.Changes {
+		if err := apply(&c, filepath.Join(previous, fmt.Sprintf("%d", i))); err != nil {
+			j.Error = err.Error()
+			j.write(journal)
+
+			return journal, errors.Wrap(err, "failed to "+c.Description)
+		}
+
+		j.Changes = append(j.Changes, c)
+		if err := j.write(journal); err != nil {
+			return journal, err
+		}
+	}
+
+	now := time.Now().UTC()
+	j.FinishedAt = &now
+
+	return journal, j.write(journal)
+}
+
+func apply(c *Change, previous string) error {
+	if err := os.MkdirAll(filepath.Dir(c.Path), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch c.Action {
+	case ChangeMove:
+		if _, err := os.Lstat(c.Path); err == nil {
+			return errors.Errorf("%s already exists", c.Path)
+		}
+
+		return move(c.From, c.Path)
+	case ChangeWrite:
+		if _, err := os.Stat(c.Path); err == nil {
+			if err := copyFile(c.Path, previous); err != nil {
+				return err
+			}
+
+			c.Previous = previous
+		}
+
+		return writeFile(c.Path, c.contents)
+	}
+
+	return errors.Errorf("unknown change \"%s\"", c.Action)
+}
+
+// Undoes the changes recorded in a journal, from the last change to the first. Files that
+// were written are restored to their previous contents, or removed if they did not exist.
+// As with applying a migration, nothing is changed while either daemon or the container of
+// a migrated server is running.
+func Rollback(journal string) (*Journal, error) {
+	b, err := ioutil.ReadFile(journal)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	j := &Journal{}
+	if err := json.Unmarshal(b, j); err != nil {
+		return nil, errors.Wrap(err, "could not parse migration journal")
+	}
+
+	if j.RolledBack != nil {
+		return nil, errors.New("the migration has already been rolled back")
+	}
+
+	if err := checkStopped(j.Legacy, changedServers(j.Changes)); err != nil {
+		return nil, err
+	}
+
+	// The changes that have not been undone yet are written back to the journal if undoing
+	// one fails, so that the rollback can be run again once the problem is fixed.
+	changes := append([]Change{}, j.Changes...)
+
+	for i := len(j.Changes) - 1; i >= 0; i-- {
+		c := j.Changes[i]
+
+		var err error
+		switch c.Action {
+		case ChangeMove:
+			if err = os.MkdirAll(filepath.Dir(c.From), 0755); err == nil {
+				err = move(c.Path, c.From)
+			}
+		case ChangeWrite:
+			if c.Previous != "" {
+				err = copyFile(c.Previous, c.Path)
+			} else if err = os.Remove(c.Path); os.IsNotExist(err) {
+				err = nil
+			}
+		}
+
+		if err != nil {
+			j.Changes = j.Changes[:i+1]
+			j.write(journal)
+
+			return j, errors.Wrapf(err, "failed to undo: %s", c.Description)
+		}
+
+		j.Changes = j.Changes[:i]
+	}
+
+	now := time.Now().UTC()
+	j.RolledBack = &now
+	j.Changes = changes
+
+	return j, j.write(journal)
+}
+
+func (j *Journal) write(p string) error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(p, b, 0600))
+}
+
+// Copies the contents of a file. Neither path is followed if it is a symlink, so that a
+// link swapped in for either cannot redirect the copy to somewhere else on the disk.
+func copyFile(src string, dst string) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+
+	return errors.WithStack(err)
+}
+
+// Writes the contents to a file without following the path if it is a symlink.
+func writeFile(p string, b []byte) error {
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(f.Close())
+}
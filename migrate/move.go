@@ -0,0 +1,135 @@
+package migrate
+
+import (
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Moves a file or directory to a new path. If the path is on another disk, which is common
+// when the legacy daemon kept server data on a dedicated mount, the contents are copied to
+// the new path and the original is removed once the copy has completed.
+func move(from string, to string) error {
+	err := os.Rename(from, to)
+	if err == nil {
+		return nil
+	}
+
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return errors.WithStack(err)
+	}
+
+	// Copy to a temporary name next to the destination so that a partial copy is never left
+	// at the destination itself if the copy fails.
+	tmp := to + ".migrating"
+	if err := copyTree(from, tmp); err != nil {
+		os.RemoveAll(tmp)
+
+		return err
+	}
+
+	if err := os.Rename(tmp, to); err != nil {
+		os.RemoveAll(tmp)
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.RemoveAll(from))
+}
+
+// Copies a file or directory and everything within it, keeping the permissions and owners
+// of each file. Symlinks are copied as links rather than followed.
+func copyTree(src string, dst string) error {
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case info.IsDir():
+			if err := os.Mkdir(target, info.Mode().Perm()); err != nil {
+				return errors.WithStack(err)
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(p)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if err := os.Symlink(link, target); err != nil {
+				return errors.WithStack(err)
+			}
+		case info.Mode().IsRegular():
+			if err := copyRegularFile(p, target, info.Mode().Perm()); err != nil {
+				return err
+			}
+		default:
+			// Sockets, pipes and devices have no place in the data of a server.
+			return nil
+		}
+
+		if st, ok := info.Sys().(*syscall.Stat_t); ok {
+			if err := os.Lchown(target, int(st.Uid), int(st.Gid)); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Copies the contents of a regular file to a new file with the given permissions. Neither
+// path is followed if it has been replaced by a symlink since the tree was walked.
+func copyRegularFile(src string, dst string, mode os.FileMode) error {
+	in, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, mode)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(out.Close())
+}
+
+// Determines if the path will be moved to the destination by copying it, because it is on
+// a different disk than the closest existing parent of the destination.
+func requiresCopy(from string, to string) bool {
+	a, err := os.Stat(from)
+	if err != nil {
+		return false
+	}
+
+	dir := filepath.Dir(to)
+	b, err := os.Stat(dir)
+	for err != nil && os.IsNotExist(err) && dir != filepath.Dir(dir) {
+		dir = filepath.Dir(dir)
+		b, err = os.Stat(dir)
+	}
+
+	if err != nil {
+		return false
+	}
+
+	sa, ok := a.Sys().(*syscall.Stat_t)
+	sb, ok2 := b.Sys().(*syscall.Stat_t)
+
+	return ok && ok2 && sa.Dev != sb.Dev
+}
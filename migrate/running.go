@@ -0,0 +1,117 @@
+package migrate
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/server"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Returns an error if this daemon, the legacy daemon or the container of any of the servers
+// is running. Moving the data of a server while something is still writing to it would
+// leave a partial copy behind, so nothing is changed until they have all been stopped.
+func checkStopped(legacy string, servers []string) error {
+	if p := runningDaemon(legacy); p != "" {
+		return errors.Errorf("%s is running, it must be stopped before migrating", p)
+	}
+
+	if len(servers) == 0 {
+		return nil
+	}
+
+	cli, err := server.NewRuntimeClient()
+	if err != nil {
+		return err
+	}
+
+	for _, uuid := range servers {
+		ci, err := cli.ContainerInspect(context.Background(), uuid)
+		if err != nil {
+			// Containers cannot be running if there are none, or if the runtime itself
+			// is not running.
+			if client.IsErrNotFound(err) || client.IsErrConnectionFailed(err) {
+				continue
+			}
+
+			return errors.Wrapf(err, "could not check the container for server %s", uuid)
+		}
+
+		if ci.State != nil && ci.State.Running {
+			return errors.Errorf("the container for server %s is running, it must be stopped before migrating", uuid)
+		}
+	}
+
+	return nil
+}
+
+// Returns a description of the first process found that is either this daemon or the
+// legacy daemon, which runs under node from its installation directory. An empty string
+// is returned if neither is running.
+func runningDaemon(legacy string) string {
+	procs, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return ""
+	}
+
+	if legacy != "" {
+		if p, err := filepath.Abs(legacy); err == nil {
+			legacy = filepath.Clean(p)
+		}
+	}
+
+	for _, p := range procs {
+		pid, err := strconv.Atoi(p.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join("/proc", p.Name(), "cmdline"))
+		if err != nil || len(b) == 0 {
+			continue
+		}
+
+		args := strings.Split(strings.TrimRight(string(b), "\x00"), "\x00")
+		name := filepath.Base(args[0])
+
+		// Other invocations of the migrate command do not count as a running daemon.
+		if name == "wings" && !(len(args) > 1 && contains(args[1:], "migrate")) {
+			return fmt.Sprintf("wings (pid %d)", pid)
+		}
+
+		if legacy != "" && (name == "node" || name == "nodejs") {
+			if cwd, err := os.Readlink(filepath.Join("/proc", p.Name(), "cwd")); err == nil && filepath.Clean(cwd) == legacy {
+				return fmt.Sprintf("the legacy daemon (pid %d)", pid)
+			}
+		}
+	}
+
+	return ""
+}
+
+// Returns the servers that the changes were made for.
+func changedServers(changes []Change) []string {
+	var servers []string
+	for _, c := range changes {
+		if c.Server != "" && !contains(servers, c.Server) {
+			servers = append(servers, c.Server)
+		}
+	}
+
+	return servers
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
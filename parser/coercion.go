@@ -0,0 +1,142 @@
+package parser
+
+import (
+	"encoding/json"
+	"github.com/buger/jsonparser"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+)
+
+// The forms boolean values can be written to a configuration file in.
+const (
+	// Written as a boolean, or as "true" and "false" in files without types. This is the
+	// default.
+	BooleanLiteral = "literal"
+	// Written as the numbers 1 and 0.
+	BooleanInteger = "integer"
+	// Written as the strings "true" and "false", which are quoted in JSON and YAML.
+	BooleanString = "string"
+	// Written as the strings "yes" and "no", or "on" and "off".
+	BooleanYesNo = "yes_no"
+	BooleanOnOff = "on_off"
+)
+
+// The forms numeric values can be written to a configuration file in.
+const (
+	// Written as an integer, with anything that is not an integer written as 0. This is
+	// the default, and is kept for the eggs that rely on it.
+	NumberInteger = "integer"
+	// Written exactly as given, so that decimals and large numbers are kept intact.
+	NumberExact = "exact"
+	// Written as a string, which is quoted in JSON and YAML.
+	NumberString = "string"
+)
+
+// The ways string values can be written to a configuration file.
+const (
+	// Written as a string. This is the default.
+	StringKeep = "keep"
+	// Strings that contain a boolean or a number are written as that type instead, for
+	// values the Panel sends as strings that the game expects to be typed.
+	StringInfer = "infer"
+)
+
+// Defines how the values of the replacements for a configuration file are written to it,
+// since games differ in the exact literal forms they accept. Values are converted before
+// they are written, so a boolean written as an integer is also formatted by the rules
+// for numbers.
+type CoercionRules struct {
+	Booleans string `json:"booleans"`
+	Numbers  string `json:"numbers"`
+	Strings  string `json:"strings"`
+}
+
+// Checks that each of the rules is one that is supported.
+func (c CoercionRules) Validate() error {
+	switch c.Booleans {
+	case "", BooleanLiteral, BooleanInteger, BooleanString, BooleanYesNo, BooleanOnOff:
+	default:
+		return errors.Errorf("unknown boolean coercion \"%s\"", c.Booleans)
+	}
+
+	switch c.Numbers {
+	case "", NumberInteger, NumberExact, NumberString:
+	default:
+		return errors.Errorf("unknown number coercion \"%s\"", c.Numbers)
+	}
+
+	switch c.Strings {
+	case "", StringKeep, StringInfer:
+	default:
+		return errors.Errorf("unknown string coercion \"%s\"", c.Strings)
+	}
+
+	return nil
+}
+
+// Converts a value into the form defined by the rules, returning the value along with
+// the type it should be written as.
+func (c CoercionRules) apply(value []byte, dt jsonparser.ValueType) ([]byte, jsonparser.ValueType) {
+	if dt == jsonparser.String && c.Strings == StringInfer {
+		s := strings.TrimSpace(string(value))
+
+		if s == "true" || s == "false" {
+			value, dt = []byte(s), jsonparser.Boolean
+		} else if _, err := strconv.ParseFloat(s, 64); err == nil {
+			value, dt = []byte(s), jsonparser.Number
+		}
+	}
+
+	if dt == jsonparser.Boolean {
+		b, _ := strconv.ParseBool(string(value))
+
+		switch c.Booleans {
+		case BooleanInteger:
+			value, dt = []byte("0"), jsonparser.Number
+			if b {
+				value = []byte("1")
+			}
+		case BooleanString:
+			dt = jsonparser.String
+		case BooleanYesNo:
+			value, dt = []byte("no"), jsonparser.String
+			if b {
+				value = []byte("yes")
+			}
+		case BooleanOnOff:
+			value, dt = []byte("off"), jsonparser.String
+			if b {
+				value = []byte("on")
+			}
+		}
+	}
+
+	if dt == jsonparser.Number && c.Numbers == NumberString {
+		dt = jsonparser.String
+	}
+
+	return value, dt
+}
+
+// Returns the value as the type it is written to a structured file as.
+func (c CoercionRules) typed(value []byte, dt jsonparser.ValueType) interface{} {
+	if dt == jsonparser.Number && c.Numbers == NumberExact {
+		return json.Number(strings.TrimSpace(string(value)))
+	}
+
+	return getKeyValue(value, dt)
+}
+
+// Returns the value for a replacement after any configuration references have been
+// resolved and the coercion rules for the file have been applied.
+func (f *ConfigurationFile) resolveValue(cfr ConfigurationFileReplacement) ([]byte, jsonparser.ValueType, error) {
+	value, dt, err := f.LookupConfigurationValue(cfr)
+	if err != nil {
+		return value, dt, err
+	}
+
+	value, dt = f.Coercion.apply(value, dt)
+
+	return value, dt, nil
+}
@@ -0,0 +1,221 @@
+package parser
+
+import (
+	"github.com/pkg/errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Regex to match references to a value in another configuration file, in the format
+// {{ file.<id>.<key> }}. The key is the path to the value within the other file, using
+// the same format as the match of a replacement, which allows one file to use a value
+// that was generated into another, such as a password created by the install script.
+//
+// A file that references another is always parsed after it.
+var fileReferenceRegex = regexp.MustCompile(`{{\s?file\.([\w-]+)\.([^\s{}]+)\s?}}`)
+
+// Regex to match the markers left in a replacement value in place of the values read from
+// other configuration files.
+var literalMarkerRegex = regexp.MustCompile("\x00(\\d+)\x00")
+
+// Determines if the file is identified by the name, which can either be the id of the
+// file or its file name.
+func (f *ConfigurationFile) Is(name string) bool {
+	return name != "" && (name == f.Id || name == f.FileName)
+}
+
+// Returns the names of the files that must be parsed before this file, which are the
+// files it is defined to come after and any files its replacements reference.
+func (f *ConfigurationFile) Dependencies() []string {
+	var out []string
+	seen := make(map[string]bool)
+
+	add := func(name string) {
+		if name != "" && !seen[name] && !f.Is(name) {
+			seen[name] = true
+			out = append(out, name)
+		}
+	}
+
+	for _, name := range f.After {
+		add(name)
+	}
+
+	for _, r := range f.Replace {
+		for _, m := range fileReferenceRegex.FindAllStringSubmatch(r.Value, -1) {
+			add(m[1])
+		}
+	}
+
+	return out
+}
+
+// Replaces the references to values in other configuration files within the replacement
+// values, using the lookup function to read the value. References that cannot be
+// resolved are left as they are.
+//
+// The files can be edited by the server, so the values read from them are only put in
+// place after any references to the configuration of the daemon have been resolved, and
+// are never treated as references themselves. Until then a marker holds their place.
+func (f ConfigurationFile) ExpandFileReferences(lookup func(id string, key string) (string, bool)) ConfigurationFile {
+	replace := make([]ConfigurationFileReplacement, len(f.Replace))
+	for i, r := range f.Replace {
+		r.literals = nil
+		r.Value = fileReferenceRegex.ReplaceAllStringFunc(r.Value, func(match string) string {
+			m := fileReferenceRegex.FindStringSubmatch(match)
+			if v, ok := lookup(m[1], m[2]); ok {
+				r.literals = append(r.literals, v)
+
+				return "\x00" + strconv.Itoa(len(r.literals)-1) + "\x00"
+			}
+
+			return match
+		})
+
+		replace[i] = r
+	}
+
+	f.Replace = replace
+
+	return f
+}
+
+// Puts the values read from other configuration files in place of their markers within
+// the resolved value.
+func (cfr ConfigurationFileReplacement) expandLiterals(value []byte) []byte {
+	if len(cfr.literals) == 0 {
+		return value
+	}
+
+	return literalMarkerRegex.ReplaceAllFunc(value, func(m []byte) []byte {
+		i, err := strconv.Atoi(string(m[1 : len(m)-1]))
+		if err != nil || i >= len(cfr.literals) {
+			return m
+		}
+
+		return []byte(cfr.literals[i])
+	})
+}
+
+// Orders the configuration files so that every file is parsed after the files it depends
+// on. The files are returned in stages, where the files within a stage do not depend on
+// each other and can be parsed at the same time. Dependencies on files that are not in
+// the list are ignored.
+//
+// If files depend on each other in a cycle an error is returned, along with the stages
+// for every file, the files in the cycle making up the last stage.
+func OrderFiles(files []ConfigurationFile) ([][]ConfigurationFile, error) {
+	deps := make([][]int, len(files))
+	for i := range files {
+		for _, name := range files[i].Dependencies() {
+			for j := range files {
+				if j != i && files[j].Is(name) {
+					deps[i] = append(deps[i], j)
+				}
+			}
+		}
+	}
+
+	done := make([]bool, len(files))
+	remaining := len(files)
+
+	var stages [][]ConfigurationFile
+	for remaining > 0 {
+		var ready []int
+
+	floop:
+		for i := range files {
+			if done[i] {
+				continue
+			}
+
+			for _, j := range deps[i] {
+				if !done[j] {
+					continue floop
+				}
+			}
+
+			ready = append(ready, i)
+		}
+
+		if len(ready) == 0 {
+			var stage []ConfigurationFile
+			var names []string
+			for i := range files {
+				if !done[i] {
+					stage = append(stage, files[i])
+					names = append(names, files[i].FileName)
+				}
+			}
+
+			return append(stages, stage), errors.Errorf("configuration files %s depend on each other", strings.Join(names, ", "))
+		}
+
+		stage := make([]ConfigurationFile, len(ready))
+		for k, i := range ready {
+			stage[k] = files[i]
+			done[i] = true
+		}
+
+		stages = append(stages, stage)
+		remaining -= len(ready)
+	}
+
+	return stages, nil
+}
+
+// Returns the changed files along with every file that depends on one of them, either
+// directly or through other files, so that the values those files use are updated too.
+// The files are returned in the order they are defined.
+func WithDependents(files []ConfigurationFile, changed []ConfigurationFile) []ConfigurationFile {
+	included := make([]bool, len(files))
+	for i := range files {
+		for _, c := range changed {
+			if c.FileName == files[i].FileName {
+				included[i] = true
+			}
+		}
+	}
+
+	for found := true; found; {
+		found = false
+
+		for i := range files {
+			if included[i] {
+				continue
+			}
+
+		dloop:
+			for _, name := range files[i].Dependencies() {
+				for j := range files {
+					if included[j] && files[j].Is(name) {
+						included[i], found = true, true
+						break dloop
+					}
+				}
+			}
+		}
+	}
+
+	var out []ConfigurationFile
+	for i := range files {
+		if included[i] {
+			out = append(out, files[i])
+		}
+	}
+
+	// Files that were changed but are not in the list are kept as well.
+cloop:
+	for _, c := range changed {
+		for i := range files {
+			if files[i].FileName == c.FileName {
+				continue cloop
+			}
+		}
+
+		out = append(out, c)
+	}
+
+	return out
+}
@@ -0,0 +1,221 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/beevik/etree"
+	"github.com/ghodss/yaml"
+	"github.com/magiconair/properties"
+	"github.com/pterodactyl/wings/config"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A single value read from a configuration file.
+type Entry struct {
+	// The dot-notated path to the value, using the same format as the match of a
+	// replacement. Attributes of XML elements are written as "path[attribute]", and the
+	// lines of plain text files as "line <number>".
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+// Reads every value currently in the configuration file at the given path without
+// modifying it. A file that does not exist has no entries.
+func (f *ConfigurationFile) Entries(path string) ([]Entry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Entry{}, nil
+		}
+
+		return nil, err
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		return []Entry{}, nil
+	}
+
+	out := []Entry{}
+
+	switch f.Parser {
+	case Json, Yaml, "yml":
+		if f.Parser != Json {
+			if b, err = yaml.YAMLToJSON(b); err != nil {
+				return nil, err
+			}
+		}
+
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return nil, err
+		}
+
+		out = flattenJson(out, "", v)
+	case Properties:
+		p, err := properties.Load(b, properties.UTF8)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range p.Keys() {
+			v, _ := p.Get(k)
+			out = append(out, Entry{Key: k, Value: v})
+		}
+	case Ini:
+		cfg, err := ini.Load(b)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range cfg.Sections() {
+			for _, k := range s.Keys() {
+				key := k.Name()
+				if s.Name() != ini.DefaultSection {
+					key = s.Name() + "." + key
+				}
+
+				out = append(out, Entry{Key: key, Value: k.Value()})
+			}
+		}
+	case Xml:
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(b); err != nil {
+			return nil, err
+		}
+
+		if doc.Root() != nil {
+			out = flattenXml(out, "", doc.Root())
+		}
+	case File:
+		n := 0
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			n++
+			if strings.TrimSpace(scanner.Text()) != "" {
+				out = append(out, Entry{Key: "line " + strconv.Itoa(n), Value: scanner.Text()})
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unknown parser \"%s\"", f.Parser)
+	}
+
+	return out, nil
+}
+
+// Returns the replacement that writes the given entry of the file, if there is one.
+func (f *ConfigurationFile) ReplacementFor(e Entry) (ConfigurationFileReplacement, bool) {
+	for _, r := range f.Replace {
+		if f.Parser == File {
+			if strings.HasPrefix(e.Value, r.Match) {
+				return r, true
+			}
+
+			continue
+		}
+
+		key := e.Key
+		if i := strings.Index(key, "["); i >= 0 && f.Parser == Xml {
+			key = key[:i]
+		}
+
+		if key == r.Match {
+			return r, true
+		}
+
+		if strings.Contains(r.Match, "*") {
+			parts := strings.Split(r.Match, "*")
+			for i, p := range parts {
+				parts[i] = regexp.QuoteMeta(p)
+			}
+
+			if regexp.MustCompile("^" + strings.Join(parts, "[^.]+") + "$").MatchString(key) {
+				return r, true
+			}
+		}
+	}
+
+	return ConfigurationFileReplacement{}, false
+}
+
+// Returns the value the replacement writes to the file, after any configuration
+// references are resolved and the coercion rules for the file are applied.
+func (f *ConfigurationFile) ResolvedValue(r ConfigurationFileReplacement) (string, error) {
+	if f.configuration == nil {
+		f.configuration, _ = json.Marshal(config.Get())
+	}
+
+	v, _, err := f.resolveValue(r)
+
+	return string(v), err
+}
+
+// Appends every scalar value within the decoded JSON to the entries, keyed by its path.
+func flattenJson(out []Entry, prefix string, v interface{}) []Entry {
+	join := func(k string) string {
+		if prefix == "" {
+			return k
+		}
+
+		return prefix + "." + k
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			out = flattenJson(out, join(k), t[k])
+		}
+	case []interface{}:
+		for i, c := range t {
+			out = flattenJson(out, join(strconv.Itoa(i)), c)
+		}
+	case nil:
+		out = append(out, Entry{Key: prefix, Value: "null"})
+	default:
+		out = append(out, Entry{Key: prefix, Value: fmt.Sprint(t)})
+	}
+
+	return out
+}
+
+// Appends the text and attributes of the element and all of its children to the entries.
+func flattenXml(out []Entry, prefix string, e *etree.Element) []Entry {
+	key := e.Tag
+	if prefix != "" {
+		key = prefix + "." + e.Tag
+	}
+
+	for _, a := range e.Attr {
+		out = append(out, Entry{Key: key + "[" + a.Key + "]", Value: a.Value})
+	}
+
+	children := e.ChildElements()
+	if len(children) == 0 {
+		out = append(out, Entry{Key: key, Value: strings.TrimSpace(e.Text())})
+	}
+
+	for _, c := range children {
+		out = flattenXml(out, key, c)
+	}
+
+	return out
+}
@@ -32,6 +32,13 @@ var configMatchRegex = regexp.MustCompile(`{{\s?config\.([\w.-]+)\s?}}`)
 // noinspection RegExpRedundantEscape
 var xmlValueMatchRegex = regexp.MustCompile(`^\[([\w]+)='(.*)'\]$`)
 
+// Configuration paths supported by the old Daemon that now live at a different location
+// in the configuration for Wings.
+var configPathAliases = map[string]string{
+	"docker.interface":  "docker.network.interface",
+	"docker.interface6": "docker.network.interface6",
+}
+
 // Gets the []byte representation of a configuration file to be passed through to other
 // handler functions. If the file does not currently exist, it will be created.
 func readFileBytes(path string) ([]byte, error) {
@@ -44,11 +51,8 @@ func readFileBytes(path string) ([]byte, error) {
 	return ioutil.ReadAll(file)
 }
 
-// Helper function to set the value of the JSON key item based on the jsonparser value
-// type returned.
-func setPathway(c *gabs.Container, path string, value []byte, vt jsonparser.ValueType) error {
-	v := getKeyValue(value, vt)
-
+// Helper function to set the value of the JSON key item.
+func setPathway(c *gabs.Container, path string, v interface{}) error {
 	_, err := c.SetP(v, path)
 
 	return err
@@ -88,11 +92,13 @@ func (f *ConfigurationFile) IterateOverJson(data []byte) (*gabs.Container, error
 	}
 
 	for _, v := range f.Replace {
-		value, dt, err := f.LookupConfigurationValue(v)
+		value, dt, err := f.resolveValue(v)
 		if err != nil {
 			return nil, err
 		}
 
+		typed := f.Coercion.typed(value, dt)
+
 		// Check for a wildcard character, and if found split the key on that value to
 		// begin doing a search and replace in the data.
 		if strings.Contains(v.Match, ".*") {
@@ -104,12 +110,12 @@ func (f *ConfigurationFile) IterateOverJson(data []byte) (*gabs.Container, error
 			// If the child is a null value, nothing will happen. Seems reasonable as of the
 			// time this code is being written.
 			for _, child := range parsed.Path(strings.Trim(parts[0], ".")).Children() {
-				if err := setPathway(child, strings.Trim(parts[1], "."), value, dt); err != nil {
+				if err := setPathway(child, strings.Trim(parts[1], "."), typed); err != nil {
 					return nil, err
 				}
 			}
 		} else {
-			if err = setPathway(parsed, v.Match, value, dt); err != nil {
+			if err = setPathway(parsed, v.Match, typed); err != nil {
 				return nil, err
 			}
 		}
@@ -118,8 +124,16 @@ func (f *ConfigurationFile) IterateOverJson(data []byte) (*gabs.Container, error
 	return parsed, nil
 }
 
-// Looks up a configuration value on the Daemon given a dot-notated syntax.
+// Looks up a configuration value on the Daemon given a dot-notated syntax. Values read
+// from other configuration files are put in place once the lookup is done, so they are
+// never looked up themselves.
 func (f *ConfigurationFile) LookupConfigurationValue(cfr ConfigurationFileReplacement) ([]byte, jsonparser.ValueType, error) {
+	value, dt, err := f.lookupConfigurationValue(cfr)
+
+	return cfr.expandLiterals(value), dt, err
+}
+
+func (f *ConfigurationFile) lookupConfigurationValue(cfr ConfigurationFileReplacement) ([]byte, jsonparser.ValueType, error) {
 	if !configMatchRegex.Match([]byte(cfr.Value)) {
 		return []byte(cfr.Value), cfr.ValueType, nil
 	}
@@ -131,6 +145,10 @@ func (f *ConfigurationFile) LookupConfigurationValue(cfr ConfigurationFileReplac
 		configMatchRegex.FindString(cfr.Value), "$1",
 	)
 
+	if alias, ok := configPathAliases[huntPath]; ok {
+		huntPath = alias
+	}
+
 	var path []string
 	// The camel casing is important here, the configuration for the Daemon does not use
 	// JSON, and as such all of the keys will be generated in CamelCase format, rather than
@@ -0,0 +1,217 @@
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/Jeffail/gabs/v2"
+	"github.com/beevik/etree"
+	"github.com/buger/jsonparser"
+	"github.com/ghodss/yaml"
+	"github.com/magiconair/properties"
+	"github.com/pterodactyl/wings/config"
+	"gopkg.in/ini.v1"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// The result of checking a single configuration file without modifying it.
+type LintReport struct {
+	File   string              `json:"file"`
+	Parser ConfigurationParser `json:"parser"`
+	// Created is true if the file does not exist and would be created from scratch when
+	// the server is started.
+	Created      bool                `json:"created"`
+	Replacements []ReplacementReport `json:"replacements"`
+	// Any error that would prevent the file from being parsed at all.
+	Error string `json:"error,omitempty"`
+}
+
+// The result of checking a single replacement within a configuration file.
+type ReplacementReport struct {
+	Match string `json:"match"`
+	// The value that would be written, after any configuration references are resolved.
+	Value string `json:"value"`
+	// The number of places in the file matched by the replacement. For wildcard matches
+	// this can be more than one, and a value of 0 means the match was not found.
+	Matches int `json:"matches"`
+	// Warnings about the replacement, such as the match not being found or the value
+	// being converted into a different type.
+	Warnings []string `json:"warnings"`
+}
+
+// Checks the configuration file at the given path, reporting what each replacement would
+// do without making any changes to the file.
+func (f *ConfigurationFile) Lint(path string) LintReport {
+	r := LintReport{File: f.FileName, Parser: f.Parser, Replacements: []ReplacementReport{}}
+
+	switch f.Parser {
+	case File, Yaml, "yml", Properties, Ini, Json, Xml:
+	default:
+		r.Error = "unknown parser \"" + string(f.Parser) + "\", the file will not be modified"
+		return r
+	}
+
+	if err := f.Coercion.Validate(); err != nil {
+		r.Error = err.Error()
+		return r
+	}
+
+	mb, _ := json.Marshal(config.Get())
+	f.configuration = mb
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			r.Error = err.Error()
+			return r
+		}
+
+		r.Created = true
+	}
+
+	for _, replacement := range f.Replace {
+		rr := ReplacementReport{Match: replacement.Match, Warnings: []string{}}
+
+		value, dt, err := f.resolveValue(replacement)
+		if err != nil {
+			rr.Warnings = append(rr.Warnings, "failed to resolve configuration value: "+err.Error())
+		} else if configMatchRegex.Match(value) {
+			rr.Warnings = append(rr.Warnings, "configuration reference could not be resolved and is used as-is")
+		} else if fileReferenceRegex.Match(value) {
+			rr.Warnings = append(rr.Warnings, "file reference could not be resolved and is used as-is")
+		}
+
+		rr.Value = string(value)
+		rr.Warnings = append(rr.Warnings, coercionWarnings(f.Parser, f.Coercion, value, dt)...)
+
+		if m, err := f.countMatches(b, replacement.Match); err != nil {
+			r.Error = err.Error()
+		} else {
+			rr.Matches = m
+		}
+
+		if rr.Matches == 0 && r.Error == "" {
+			switch {
+			case f.Parser == File:
+				rr.Warnings = append(rr.Warnings, "no lines in the file begin with the match, the replacement is unused")
+			case strings.Contains(replacement.Match, "*"):
+				rr.Warnings = append(rr.Warnings, "wildcard did not match anything, the replacement is unused")
+			default:
+				rr.Warnings = append(rr.Warnings, "path was not found in the file and will be created")
+			}
+		}
+
+		r.Replacements = append(r.Replacements, rr)
+	}
+
+	return r
+}
+
+// Returns warnings for any conversion that will be applied to the value when it is
+// written to the file.
+func coercionWarnings(p ConfigurationParser, c CoercionRules, value []byte, dt jsonparser.ValueType) []string {
+	var out []string
+
+	structured := p == Json || p == Yaml || p == "yml"
+
+	switch dt {
+	case jsonparser.Number:
+		if !structured {
+			out = append(out, "numeric value is written as a string")
+		} else if _, err := strconv.Atoi(string(value)); err != nil && c.Numbers != NumberExact {
+			out = append(out, "value \""+string(value)+"\" is not an integer and will be written as 0")
+		}
+	case jsonparser.Boolean:
+		if !structured {
+			out = append(out, "boolean value is written as a string")
+		}
+	}
+
+	return out
+}
+
+// Returns the number of places in the file contents matched by the replacement.
+func (f *ConfigurationFile) countMatches(b []byte, match string) (int, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return 0, nil
+	}
+
+	switch f.Parser {
+	case Json, Yaml, "yml":
+		if f.Parser != Json {
+			var err error
+			if b, err = yaml.YAMLToJSON(b); err != nil {
+				return 0, err
+			}
+		}
+
+		parsed, err := gabs.ParseJSON(b)
+		if err != nil {
+			return 0, err
+		}
+
+		if !strings.Contains(match, ".*") {
+			if parsed.ExistsP(match) {
+				return 1, nil
+			}
+
+			return 0, nil
+		}
+
+		parts := strings.SplitN(match, ".*", 2)
+		count := 0
+		for _, child := range parsed.Path(strings.Trim(parts[0], ".")).Children() {
+			if rest := strings.Trim(parts[1], "."); rest == "" || child.ExistsP(rest) {
+				count++
+			}
+		}
+
+		return count, nil
+	case Properties:
+		p, err := properties.Load(b, properties.UTF8)
+		if err != nil {
+			return 0, err
+		}
+
+		if _, ok := p.Get(match); ok {
+			return 1, nil
+		}
+	case Ini:
+		cfg, err := ini.Load(b)
+		if err != nil {
+			return 0, err
+		}
+
+		path := strings.SplitN(match, ".", 2)
+		k, s := path[0], ""
+		if len(path) == 2 {
+			k, s = path[1], path[0]
+		}
+
+		if sec, err := cfg.GetSection(s); err == nil && sec.HasKey(k) {
+			return 1, nil
+		}
+	case Xml:
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(b); err != nil {
+			return 0, err
+		}
+
+		return len(doc.FindElements("./" + strings.Replace(match, ".", "/", -1))), nil
+	case File:
+		count := 0
+		scanner := bufio.NewScanner(bytes.NewReader(b))
+		for scanner.Scan() {
+			if strings.HasPrefix(scanner.Text(), match) {
+				count++
+			}
+		}
+
+		return count, scanner.Err()
+	}
+
+	return 0, nil
+}
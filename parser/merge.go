@@ -0,0 +1,235 @@
+package parser
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// The number of times the replacements for a configuration file are applied before giving
+// up, when the file keeps being modified by another writer while it is being updated.
+const maxMergeAttempts = 3
+
+// A record of a single update made to a configuration file.
+type Change struct {
+	Time   time.Time `json:"time"`
+	File   string    `json:"file"`
+	Parser string    `json:"parser,omitempty"`
+	// The subsystem that made the change, such as "egg", "profile" or "api".
+	Source string `json:"source"`
+	// The SHA-256 hashes of the file before and after the change. An empty hash means the
+	// file did not exist.
+	Before string `json:"before"`
+	After  string `json:"after"`
+	// The number of times the file was modified by another writer while the change was
+	// being made, causing the replacements to be merged into the new contents.
+	Conflicts int    `json:"conflicts"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Determines if the change modified the file, or needs attention.
+func (c *Change) Notable() bool {
+	return c.Before != c.After || c.Conflicts > 0 || c.Error != ""
+}
+
+type fileLock struct {
+	sync.Mutex
+	refs int
+}
+
+var fileLocks = struct {
+	sync.Mutex
+	locks map[string]*fileLock
+}{locks: make(map[string]*fileLock)}
+
+// Acquires an exclusive lock on the file at the given path, returning the function that
+// releases it. Anything within the daemon that writes a file which could be modified by
+// the configuration parsers at the same time should hold this lock while doing so.
+func LockFile(path string) func() {
+	path = filepath.Clean(path)
+
+	fileLocks.Lock()
+	l, ok := fileLocks.locks[path]
+	if !ok {
+		l = &fileLock{}
+		fileLocks.locks[path] = l
+	}
+	l.refs++
+	fileLocks.Unlock()
+
+	l.Lock()
+
+	return func() {
+		l.Unlock()
+
+		fileLocks.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(fileLocks.locks, path)
+		}
+		fileLocks.Unlock()
+	}
+}
+
+// Applies the replacements for the configuration file while holding the lock for it. The
+// replacements are made to a copy of the file which then atomically replaces the original,
+// so that a reader never sees a partially written file. If another writer that does not
+// use the lock, such as a hook script, modifies the file in the meantime the replacements
+// are merged into the new contents rather than overwriting them.
+func (f *ConfigurationFile) Apply(path string, source string) (*Change, error) {
+	unlock := LockFile(path)
+	defer unlock()
+
+	c := &Change{Time: time.Now().UTC(), File: f.FileName, Parser: string(f.Parser), Source: source}
+
+	for {
+		before, err := HashFile(path)
+		if err != nil {
+			c.Error = err.Error()
+			return c, err
+		}
+
+		tmp, err := f.applyToCopy(path)
+		if err != nil {
+			c.Error = err.Error()
+			return c, err
+		}
+
+		current, err := HashFile(path)
+		if err != nil {
+			os.Remove(tmp)
+			c.Error = err.Error()
+			return c, err
+		}
+
+		if current != before {
+			os.Remove(tmp)
+
+			if c.Conflicts++; c.Conflicts >= maxMergeAttempts {
+				err := errors.Errorf("configuration file \"%s\" was repeatedly modified by another writer while it was being updated", f.FileName)
+				c.Error = err.Error()
+
+				return c, err
+			}
+
+			continue
+		}
+
+		after, err := HashFile(tmp)
+		if err != nil {
+			os.Remove(tmp)
+			c.Error = err.Error()
+			return c, err
+		}
+
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			c.Error = err.Error()
+			return c, errors.WithStack(err)
+		}
+
+		c.Before, c.After = before, after
+
+		return c, nil
+	}
+}
+
+// Copies the file to a temporary file in the same directory and applies the replacements
+// to the copy, returning its path.
+func (f *ConfigurationFile) applyToCopy(path string) (string, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if err := copyInto(tmp, path); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+
+		return "", err
+	}
+	tmp.Close()
+
+	if err := f.Parse(tmp.Name(), false); err != nil {
+		os.Remove(tmp.Name())
+
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// Copies the contents, permissions and owner of the file at the path into the
+// destination, since the destination replaces the file. If the file does not exist the
+// destination is left empty and owned by the user the servers run as.
+func copyInto(dst *os.File, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			u := config.Get().System.User
+			if err := chown(dst, u.Uid, u.Gid); err != nil {
+				return err
+			}
+
+			return errors.WithStack(dst.Chmod(0644))
+		}
+
+		return errors.WithStack(err)
+	}
+	defer src.Close()
+
+	st, err := src.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if sys, ok := st.Sys().(*syscall.Stat_t); ok {
+		if err := chown(dst, int(sys.Uid), int(sys.Gid)); err != nil {
+			return err
+		}
+	}
+
+	return errors.WithStack(dst.Chmod(st.Mode().Perm()))
+}
+
+// Changes the owner of the file. Only root can give a file to another user, so when the
+// daemon is not running as root the file is left owned by the daemon.
+func chown(f *os.File, uid int, gid int) error {
+	if err := f.Chown(uid, gid); err != nil && os.Geteuid() == 0 {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Returns the SHA-256 hash of the file contents, or an empty string if the file does not
+// exist.
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -36,6 +36,17 @@ type ConfigurationFile struct {
 	Parser   ConfigurationParser            `json:"parser"`
 	Replace  []ConfigurationFileReplacement `json:"replace"`
 
+	// An optional identifier used by other files to reference the values in this file,
+	// with a replacement value in the format {{ file.<id>.<key> }}.
+	Id string `json:"id,omitempty"`
+
+	// The files, by id or file name, that must be parsed before this file. Files that are
+	// referenced in the replacement values are always parsed first as well.
+	After []string `json:"after,omitempty"`
+
+	// Defines the literal forms the replacement values are written in.
+	Coercion CoercionRules `json:"coercion"`
+
 	// Tracks Wings' configuration so that we can quickly get values
 	// out of it when variables request it.
 	configuration []byte
@@ -46,6 +57,10 @@ type ConfigurationFileReplacement struct {
 	Match     string               `json:"match"`
 	Value     string               `json:"value"`
 	ValueType jsonparser.ValueType `json:"-"`
+
+	// The values read from other configuration files for the references in the value,
+	// which are put in place of their markers once the value has been resolved.
+	literals []string
 }
 
 func (cfr *ConfigurationFileReplacement) UnmarshalJSON(data []byte) error {
@@ -71,11 +86,35 @@ func (cfr *ConfigurationFileReplacement) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// Encodes the replacement in the same format it is received in, keeping the type of the
+// value so that it is parsed the same way when it is read back.
+func (cfr ConfigurationFileReplacement) MarshalJSON() ([]byte, error) {
+	// String values are stored as they appear between the quotes in the original JSON,
+	// so they are already escaped.
+	value := "\"" + cfr.Value + "\""
+	if cfr.ValueType == jsonparser.Number || cfr.ValueType == jsonparser.Boolean {
+		value = cfr.Value
+	}
+
+	m, err := json.Marshal(cfr.Match)
+	if err != nil {
+		return nil, err
+	}
+
+	return []byte(`{"match":` + string(m) + `,"value":` + value + `}`), nil
+}
+
 // Parses a given configuration file and updates all of the values within as defined
 // in the API response from the Panel.
 func (f *ConfigurationFile) Parse(path string, internal bool) error {
 	zap.S().Debugw("parsing configuration file", zap.String("path", path), zap.String("parser", string(f.Parser)))
 
+	// Unknown coercions are ignored rather than failing the whole file, in which case the
+	// default form is used for those values.
+	if err := f.Coercion.Validate(); err != nil {
+		zap.S().Warnw("configuration file defines an invalid coercion rule", zap.String("path", path), zap.Error(err))
+	}
+
 	mb, _ := json.Marshal(config.Get())
 	f.configuration = mb
 
@@ -139,7 +178,7 @@ func (f *ConfigurationFile) parseXmlFile(path string) error {
 	}
 
 	for i, replacement := range f.Replace {
-		value, _, err := f.LookupConfigurationValue(replacement)
+		value, _, err := f.resolveValue(replacement)
 		if err != nil {
 			return err
 		}
@@ -223,7 +262,7 @@ func (f *ConfigurationFile) parseIniFile(path string) error {
 	for _, replacement := range f.Replace {
 		path := strings.SplitN(replacement.Match, ".", 2)
 
-		value, _, err := f.LookupConfigurationValue(replacement)
+		value, _, err := f.resolveValue(replacement)
 		if err != nil {
 			return err
 		}
@@ -364,7 +403,7 @@ func (f *ConfigurationFile) parsePropertiesFile(path string) error {
 	}
 
 	for _, replace := range f.Replace {
-		data, _, err := f.LookupConfigurationValue(replace)
+		data, _, err := f.resolveValue(replace)
 		if err != nil {
 			return err
 		}
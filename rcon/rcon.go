@@ -0,0 +1,176 @@
+package rcon
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"net"
+	"time"
+)
+
+// The packet types used by the Source RCON protocol, which is also used by Minecraft.
+const (
+	typeResponse = 0
+	typeCommand  = 2
+	typeAuth     = 3
+)
+
+// The largest packet accepted from the server. The protocol limits responses to 4096
+// bytes per packet, so anything much larger means the connection is not speaking RCON.
+const maxPacketSize = 1 << 16
+
+// The largest response accepted for a single command across all of the packets it is
+// split over, so that a server cannot make the daemon buffer an endless response.
+const maxResponseSize = 1 << 20
+
+// The ids used for requests. The id of the packet sent after a command is used to find
+// the end of a response that is split over multiple packets.
+const (
+	authId       = 1
+	commandId    = 2
+	terminatorId = 3
+)
+
+// A connection to a game server using the Source RCON protocol.
+type Client struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+}
+
+// Connects to the RCON server at the address and authenticates using the password.
+func Dial(addr string, password string, timeout time.Duration) (*Client, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	c := &Client{conn: conn, r: bufio.NewReader(conn), timeout: timeout}
+	if err := c.authenticate(password); err != nil {
+		conn.Close()
+
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Closes the connection to the server.
+func (c *Client) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Client) authenticate(password string) error {
+	if err := c.write(authId, typeAuth, password); err != nil {
+		return err
+	}
+
+	// Source servers send an empty response value before the authentication response,
+	// so keep reading until the authentication response arrives. A failed authentication
+	// is signalled using an id of -1.
+	for {
+		id, t, _, err := c.read()
+		if err != nil {
+			return err
+		}
+
+		if t != typeCommand {
+			continue
+		}
+
+		if id == -1 {
+			return errors.New("rcon authentication failed, the password is incorrect")
+		}
+
+		return nil
+	}
+}
+
+// Executes a command on the server, returning its response.
+func (c *Client) Execute(command string) (string, error) {
+	if err := c.write(commandId, typeCommand, command); err != nil {
+		return "", err
+	}
+
+	// Responses can be split over multiple packets with nothing marking the last one. To
+	// find the end a second request is sent immediately after the command, and since the
+	// server answers requests in order everything before its response belongs to the
+	// command.
+	if err := c.write(terminatorId, typeResponse, ""); err != nil {
+		return "", err
+	}
+
+	var out bytes.Buffer
+	for {
+		id, _, body, err := c.read()
+		if err != nil {
+			return out.String(), err
+		}
+
+		if id == terminatorId {
+			// Source servers send a second packet in response to the terminator, which
+			// needs to be consumed so that it is not mistaken for the response to the
+			// next command.
+			if body == "" {
+				c.readWithin(time.Millisecond * 100)
+			}
+
+			return out.String(), nil
+		}
+
+		if id == commandId {
+			if out.Len()+len(body) > maxResponseSize {
+				return out.String(), errors.Errorf("rcon response is larger than %d bytes", maxResponseSize)
+			}
+
+			out.WriteString(body)
+		}
+	}
+}
+
+func (c *Client) write(id int32, t int32, body string) error {
+	var buf bytes.Buffer
+
+	// The size does not include the size field itself, but does include the id, the
+	// type, and the two null bytes terminating the body and the packet.
+	binary.Write(&buf, binary.LittleEndian, int32(len(body)+10))
+	binary.Write(&buf, binary.LittleEndian, id)
+	binary.Write(&buf, binary.LittleEndian, t)
+	buf.WriteString(body)
+	buf.Write([]byte{0, 0})
+
+	c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(buf.Bytes())
+
+	return errors.WithStack(err)
+}
+
+func (c *Client) read() (int32, int32, string, error) {
+	return c.readWithin(c.timeout)
+}
+
+// Reads a single packet, returning its id, type and body.
+func (c *Client) readWithin(timeout time.Duration) (int32, int32, string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(timeout))
+
+	var size int32
+	if err := binary.Read(c.r, binary.LittleEndian, &size); err != nil {
+		return 0, 0, "", errors.WithStack(err)
+	}
+
+	if size < 10 || size > maxPacketSize {
+		return 0, 0, "", errors.Errorf("invalid rcon packet size %d", size)
+	}
+
+	b := make([]byte, size)
+	if _, err := io.ReadFull(c.r, b); err != nil {
+		return 0, 0, "", errors.WithStack(err)
+	}
+
+	id := int32(binary.LittleEndian.Uint32(b[0:4]))
+	t := int32(binary.LittleEndian.Uint32(b[4:8]))
+
+	return id, t, string(bytes.TrimRight(b[8:], "\x00")), nil
+}
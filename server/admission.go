@@ -0,0 +1,182 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/hoststat"
+	"go.uber.org/zap"
+	"math"
+	"runtime"
+)
+
+// The capacity of a single resource on the node compared with what has been allocated
+// to the servers on it and what they are actually using. Memory and disk are in megabytes
+// and CPU is in percent of a single core.
+type ResourceHeadroom struct {
+	Capacity int64 `json:"capacity"`
+	// The most that can be allocated once the overcommit ratio is applied, or 0 if the
+	// resource is not limited.
+	Limit int64 `json:"limit"`
+	// The resources allocated to every server on the node, and to only those that are
+	// running.
+	Allocated int64 `json:"allocated"`
+	Running   int64 `json:"running"`
+	Used      int64 `json:"used"`
+	// The resources that can still be allocated to a new server, and to a server that is
+	// started. Negative values mean the node is already beyond its limit.
+	Headroom      int64 `json:"headroom"`
+	StartHeadroom int64 `json:"start_headroom"`
+}
+
+// The resources of the node available for placing new servers on it.
+type NodeHeadroom struct {
+	Mode    string           `json:"mode"`
+	Servers int              `json:"servers"`
+	Running int              `json:"running"`
+	Memory  ResourceHeadroom `json:"memory"`
+	Disk    ResourceHeadroom `json:"disk"`
+	Cpu     ResourceHeadroom `json:"cpu"`
+}
+
+// Returns the current headroom of the node.
+func GetNodeHeadroom() NodeHeadroom {
+	return nodeHeadroom("")
+}
+
+// Totals the allocations and usage of every server on the node, skipping the server with
+// the excluded UUID.
+func nodeHeadroom(exclude string) NodeHeadroom {
+	cfg := config.Get()
+	a := cfg.System.Admission
+
+	h := NodeHeadroom{Mode: a.Mode}
+
+	h.Memory.Capacity = a.Memory
+	if h.Memory.Capacity == 0 {
+		if m, err := hoststat.Memory(); err == nil {
+			h.Memory.Capacity = m
+		}
+	}
+
+	h.Disk.Capacity = a.Disk
+	if h.Disk.Capacity == 0 {
+		if d, err := hoststat.Disk(cfg.System.Data); err == nil {
+			h.Disk.Capacity = d
+		}
+	}
+
+	h.Cpu.Capacity = a.Cpu
+	if h.Cpu.Capacity == 0 {
+		h.Cpu.Capacity = int64(runtime.NumCPU()) * 100
+	}
+
+	// Servers without a limit on a resource do not count towards its allocation, since
+	// there is no way to know how much of it they will use.
+	for _, s := range GetServers().Filter(func(s *Server) bool {
+		return s.Uuid != exclude
+	}) {
+		h.Servers++
+
+		h.Memory.Allocated += s.effectiveMemoryLimit()
+		h.Disk.Allocated += s.Build.DiskSpace
+		h.Cpu.Allocated += s.Build.CpuLimit
+
+		h.Disk.Used += s.Resources.Disk / 1024 / 1024
+
+		if s.claimsGroupQuota() {
+			h.Running++
+
+			h.Memory.Running += s.effectiveMemoryLimit()
+			h.Cpu.Running += s.Build.CpuLimit
+
+			h.Memory.Used += int64(s.Resources.Memory / 1024 / 1024)
+			h.Cpu.Used += int64(math.Round(s.Resources.CpuAbsolute))
+		}
+	}
+
+	// Disk is claimed by a server whether or not it is running.
+	h.Disk.Running = h.Disk.Allocated
+
+	h.Memory.finish(a.MemoryRatio)
+	h.Disk.finish(a.DiskRatio)
+	h.Cpu.finish(a.CpuRatio)
+
+	return h
+}
+
+// Applies the overcommit ratio to the capacity and calculates the headroom.
+func (r *ResourceHeadroom) finish(ratio float64) {
+	if ratio <= 0 || r.Capacity <= 0 {
+		return
+	}
+
+	r.Limit = int64(float64(r.Capacity) * ratio)
+	r.Headroom = r.Limit - r.Allocated
+	r.StartHeadroom = r.Limit - r.Running
+}
+
+// Checks that creating the server would not take the resources allocated to the servers
+// on the node beyond the overcommit limits. The server must not have been added to the
+// collection of servers yet.
+func (s *Server) CheckCreateAdmission() error {
+	return s.checkAdmission(true, true)
+}
+
+// A single resource checked before a server is admitted.
+type admissionCheck struct {
+	name      string
+	unit      string
+	headroom  int64
+	resources ResourceHeadroom
+	want      int64
+}
+
+func (s *Server) checkAdmission(creating bool, warn bool) error {
+	a := config.Get().System.Admission
+	if a.Mode == "" || a.Mode == config.AdmissionOff {
+		return nil
+	}
+
+	h := nodeHeadroom(s.Uuid)
+
+	action := "starting"
+	checks := []admissionCheck{
+		{"memory", "MB", h.Memory.StartHeadroom, h.Memory, s.effectiveMemoryLimit()},
+		{"cpu", "%", h.Cpu.StartHeadroom, h.Cpu, s.Build.CpuLimit},
+	}
+
+	if creating {
+		action = "creating"
+		checks = []admissionCheck{
+			{"memory", "MB", h.Memory.Headroom, h.Memory, s.Build.MemoryLimit},
+			{"cpu", "%", h.Cpu.Headroom, h.Cpu, s.Build.CpuLimit},
+			{"disk", "MB", h.Disk.Headroom, h.Disk, s.Build.DiskSpace},
+		}
+	}
+
+	for _, c := range checks {
+		if c.resources.Limit == 0 || c.want <= c.headroom {
+			continue
+		}
+
+		err := &admissionError{
+			message: fmt.Sprintf(
+				"%s the server would exceed the %s overcommit limit of the node (%d%s available of %d%s, server requires %d%s)",
+				action, c.name, c.headroom, c.unit, c.resources.Limit, c.unit, c.want, c.unit,
+			),
+		}
+
+		if a.Mode != config.AdmissionEnforce {
+			if !warn {
+				continue
+			}
+
+			zap.S().Warnw("server is exceeding the overcommit limit of the node", zap.String("server", s.Uuid), zap.String("resource", c.name), zap.String("reason", err.Error()))
+			continue
+		}
+
+		return err
+	}
+
+	return nil
+}
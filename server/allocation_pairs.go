@@ -0,0 +1,210 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Matches the allocation placeholders that can be used in the replacement values of the
+// egg configuration files, such as {{server.allocations.default.ipv6}}.
+var allocationPlaceholderRegex = regexp.MustCompile(`{{\s?server\.allocations\.([\w.]+)\s?}}`)
+
+// A single address and port that a server is bound to.
+type AllocationBinding struct {
+	Ip   string `json:"ip"`
+	Port int    `json:"port"`
+}
+
+// Pairs an IPv4 and an IPv6 binding into a single logical allocation so that dual-stack
+// servers can be configured without the egg needing to know how the node is addressed.
+// Either side of the pair may be empty if the node only has one address family.
+type AllocationPair struct {
+	Ipv4 AllocationBinding `json:"ipv4"`
+	Ipv6 AllocationBinding `json:"ipv6"`
+}
+
+// Determines if the binding matches the given address and port.
+func (b AllocationBinding) matches(ip string, port int) bool {
+	if b.Ip == "" || b.Port != port {
+		return false
+	}
+
+	return net.ParseIP(config.TrimAddressBrackets(b.Ip)).Equal(net.ParseIP(config.TrimAddressBrackets(ip)))
+}
+
+// Returns every address and port the server should be bound to, which is the mappings
+// along with both sides of each allocation pair.
+func (a *Allocations) Bindings() map[string][]int {
+	out := make(map[string][]int, len(a.Mappings))
+	add := func(ip string, port int) {
+		for _, p := range out[ip] {
+			if p == port {
+				return
+			}
+		}
+
+		out[ip] = append(out[ip], port)
+	}
+
+	for ip, ports := range a.Mappings {
+		for _, port := range ports {
+			add(ip, port)
+		}
+	}
+
+	for _, p := range a.Pairs {
+		for _, b := range []AllocationBinding{p.Ipv4, p.Ipv6} {
+			if b.Ip != "" {
+				add(b.Ip, b.Port)
+			}
+		}
+	}
+
+	return out
+}
+
+// Returns the allocation pair that contains the default allocation of the server. If the
+// default allocation is not part of a pair a pair is returned containing only the default
+// allocation on the side of its address family.
+func (a *Allocations) DefaultPair() AllocationPair {
+	ip, port := a.DefaultMapping.Ip, a.DefaultMapping.Port
+
+	for _, p := range a.Pairs {
+		if p.Ipv4.matches(ip, port) || p.Ipv6.matches(ip, port) {
+			return p
+		}
+	}
+
+	b := AllocationBinding{Ip: ip, Port: port}
+	if isIpv6Address(ip) {
+		return AllocationPair{Ipv6: b}
+	}
+
+	return AllocationPair{Ipv4: b}
+}
+
+// Determines if the address is an IPv6 address, which may be wrapped in brackets.
+func isIpv6Address(ip string) bool {
+	parsed := net.ParseIP(config.TrimAddressBrackets(ip))
+
+	return parsed != nil && parsed.To4() == nil
+}
+
+// Returns the environment variables describing both sides of the default allocation pair.
+// These are only set if the default allocation is part of a pair, so that the startup
+// command of an egg can check for them to determine if the server is dual-stack.
+func (a *Allocations) pairEnvironment() []string {
+	if len(a.Pairs) == 0 {
+		return nil
+	}
+
+	p := a.DefaultPair()
+
+	return []string{
+		fmt.Sprintf("SERVER_IP4=%s", p.Ipv4.Ip),
+		fmt.Sprintf("SERVER_PORT4=%s", bindingPort(p.Ipv4)),
+		fmt.Sprintf("SERVER_IP6=%s", config.TrimAddressBrackets(p.Ipv6.Ip)),
+		fmt.Sprintf("SERVER_PORT6=%s", bindingPort(p.Ipv6)),
+	}
+}
+
+// Returns the port of the binding as a string, or an empty string if the binding is not
+// in use.
+func bindingPort(b AllocationBinding) string {
+	if b.Ip == "" {
+		return ""
+	}
+
+	return strconv.Itoa(b.Port)
+}
+
+// Looks up a single allocation value using the dot-notated path following the
+// "server.allocations" prefix of a placeholder. The default pair is available using
+// "default", every pair is available by its index using "pairs.<index>", and every port
+// range by its index using "ranges.<index>".
+func (a *Allocations) lookupPlaceholder(path string) (string, bool) {
+	parts := strings.Split(path, ".")
+
+	var p AllocationPair
+	switch {
+	case len(parts) == 3 && parts[0] == "ranges":
+		return a.lookupRangePlaceholder(parts[1], parts[2])
+	case len(parts) == 2 && parts[0] == "default":
+		p = a.DefaultPair()
+		parts = parts[1:]
+	case len(parts) == 3 && parts[0] == "pairs":
+		i, err := strconv.Atoi(parts[1])
+		if err != nil || i < 0 || i >= len(a.Pairs) {
+			return "", false
+		}
+
+		p = a.Pairs[i]
+		parts = parts[2:]
+	default:
+		return "", false
+	}
+
+	switch parts[0] {
+	case "ipv4":
+		return p.Ipv4.Ip, true
+	case "ipv4_port":
+		return bindingPort(p.Ipv4), true
+	case "ipv6":
+		return config.TrimAddressBrackets(p.Ipv6.Ip), true
+	case "ipv6_port":
+		return bindingPort(p.Ipv6), true
+	case "ipv4_address":
+		if p.Ipv4.Ip == "" {
+			return "", true
+		}
+
+		return net.JoinHostPort(p.Ipv4.Ip, bindingPort(p.Ipv4)), true
+	case "ipv6_address":
+		if p.Ipv6.Ip == "" {
+			return "", true
+		}
+
+		return net.JoinHostPort(config.TrimAddressBrackets(p.Ipv6.Ip), bindingPort(p.Ipv6)), true
+	}
+
+	return "", false
+}
+
+// Returns a copy of the configuration file with all of the allocation placeholders in
+// the replacement values expanded. Unknown placeholders are left intact so that it is
+// obvious there is an issue with the egg.
+func (s *Server) expandAllocationPlaceholders(f parser.ConfigurationFile) parser.ConfigurationFile {
+	replace := make([]parser.ConfigurationFileReplacement, len(f.Replace))
+	for i, r := range f.Replace {
+		r.Value = allocationPlaceholderRegex.ReplaceAllStringFunc(r.Value, func(match string) string {
+			if v, ok := s.Allocations.lookupPlaceholder(allocationPlaceholderRegex.FindStringSubmatch(match)[1]); ok {
+				return v
+			}
+
+			return match
+		})
+
+		replace[i] = r
+	}
+
+	f.Replace = replace
+
+	return f
+}
+
+// Determines if any of the replacements for the configuration file use an allocation
+// placeholder.
+func usesAllocationPlaceholders(f parser.ConfigurationFile) bool {
+	for _, r := range f.Replace {
+		if allocationPlaceholderRegex.MatchString(r.Value) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,256 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long a UDP client can go without a packet being sent or received before the
+// forwarding for it is removed.
+const udpForwardIdleTimeout = time.Minute * 2
+
+// The maximum number of UDP clients forwarded at once for each listener, since each one
+// holds a socket open. Packets from new clients are dropped once it is reached.
+const udpForwardMaxClients = 1024
+
+// Forwards connections made to a host address to an address inside a server container.
+type allocationForward struct {
+	Listen string
+	Target string
+}
+
+// The listeners forwarding traffic for a single server, along with every connection that
+// is being forwarded through them so that they can all be closed together.
+type allocationProxy struct {
+	mu      sync.Mutex
+	closed  bool
+	closers []io.Closer
+	conns   map[io.Closer]struct{}
+}
+
+var allocationProxies = struct {
+	sync.Mutex
+	proxies map[string]*allocationProxy
+}{proxies: make(map[string]*allocationProxy)}
+
+// Starts forwarding both TCP and UDP traffic for each of the forwards provided, replacing
+// any forwarding that is already running for the server.
+func startAllocationProxy(uuid string, forwards []allocationForward) error {
+	stopAllocationProxy(uuid)
+
+	p := &allocationProxy{conns: make(map[io.Closer]struct{})}
+	for _, f := range forwards {
+		l, err := net.Listen("tcp", f.Listen)
+		if err != nil {
+			p.close()
+			return errors.Wrapf(err, "failed to listen on %s", f.Listen)
+		}
+		p.closers = append(p.closers, l)
+
+		pc, err := net.ListenPacket("udp", f.Listen)
+		if err != nil {
+			p.close()
+			return errors.Wrapf(err, "failed to listen on %s", f.Listen)
+		}
+		p.closers = append(p.closers, pc)
+
+		go p.forwardTcp(l, f.Target)
+		go p.forwardUdp(pc, f.Target)
+
+		zap.S().Infow("forwarding new allocation to existing port", zap.String("server", uuid), zap.String("listen", f.Listen), zap.String("target", f.Target))
+	}
+
+	allocationProxies.Lock()
+	allocationProxies.proxies[uuid] = p
+	allocationProxies.Unlock()
+
+	return nil
+}
+
+// Stops all of the forwarding running for the server, closing every connection that is
+// being forwarded.
+func stopAllocationProxy(uuid string) {
+	allocationProxies.Lock()
+	p, ok := allocationProxies.proxies[uuid]
+	delete(allocationProxies.proxies, uuid)
+	allocationProxies.Unlock()
+
+	if ok {
+		p.close()
+	}
+}
+
+func (p *allocationProxy) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	for _, c := range p.closers {
+		c.Close()
+	}
+
+	for c := range p.conns {
+		c.Close()
+	}
+	p.conns = nil
+}
+
+// Tracks a connection being forwarded so that it is closed along with the proxy. False is
+// returned, and the connection closed, if the proxy has already been closed.
+func (p *allocationProxy) track(c io.Closer) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		c.Close()
+		return false
+	}
+
+	p.conns[c] = struct{}{}
+
+	return true
+}
+
+// Closes a connection and stops tracking it.
+func (p *allocationProxy) untrack(c io.Closer) {
+	p.mu.Lock()
+	delete(p.conns, c)
+	p.mu.Unlock()
+
+	c.Close()
+}
+
+// Accepts connections until the listener is closed, copying data in both directions
+// between the client and the target.
+func (p *allocationProxy) forwardTcp(l net.Listener, target string) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		if !p.track(conn) {
+			return
+		}
+
+		go func(conn net.Conn) {
+			defer p.untrack(conn)
+
+			upstream, err := net.DialTimeout("tcp", target, time.Second*10)
+			if err != nil {
+				return
+			}
+
+			if !p.track(upstream) {
+				return
+			}
+			defer p.untrack(upstream)
+
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(upstream, conn)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(conn, upstream)
+				done <- struct{}{}
+			}()
+
+			<-done
+		}(conn)
+	}
+}
+
+// A UDP client being forwarded, along with the time a packet was last sent or received
+// for it in nanoseconds.
+type udpForwardClient struct {
+	net.Conn
+	active int64
+}
+
+func (c *udpForwardClient) touch() {
+	atomic.StoreInt64(&c.active, time.Now().UnixNano())
+}
+
+func (c *udpForwardClient) idle() bool {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&c.active))) >= udpForwardIdleTimeout
+}
+
+// Reads packets until the connection is closed, forwarding them to the target using a
+// separate socket for each client so that replies can be returned to the right client.
+// The socket for a client is closed once nothing has been sent or received for it within
+// the idle timeout.
+func (p *allocationProxy) forwardUdp(pc net.PacketConn, target string) {
+	var mu sync.Mutex
+	clients := make(map[string]*udpForwardClient)
+
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		mu.Lock()
+		c, ok := clients[addr.String()]
+		if !ok {
+			if len(clients) >= udpForwardMaxClients {
+				mu.Unlock()
+				continue
+			}
+
+			upstream, err := net.Dial("udp", target)
+			if err != nil {
+				mu.Unlock()
+				continue
+			}
+
+			if !p.track(upstream) {
+				mu.Unlock()
+				return
+			}
+
+			c = &udpForwardClient{Conn: upstream}
+			c.touch()
+			clients[addr.String()] = c
+
+			go func(addr net.Addr, c *udpForwardClient) {
+				defer func() {
+					mu.Lock()
+					delete(clients, addr.String())
+					mu.Unlock()
+					p.untrack(c.Conn)
+				}()
+
+				b := make([]byte, 65535)
+				for {
+					c.SetReadDeadline(time.Now().Add(udpForwardIdleTimeout))
+					n, err := c.Read(b)
+					if err != nil {
+						// The client may still be sending packets even though none are
+						// being returned, in which case the forwarding is kept.
+						if ne, ok := err.(net.Error); ok && ne.Timeout() && !c.idle() {
+							continue
+						}
+
+						return
+					}
+
+					c.touch()
+					if _, err := pc.WriteTo(b[:n], addr); err != nil {
+						return
+					}
+				}
+			}(addr, c)
+		}
+		mu.Unlock()
+
+		c.touch()
+		c.Write(buf[:n])
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Matches every number within a configuration value that could be a port.
+var portNumberRegex = regexp.MustCompile(`\d+`)
+
+// A value in a configuration file of the server that refers to an allocation, or that
+// looks like it defines a port or address of the server.
+type AllocationReference struct {
+	File   string                     `json:"file"`
+	Parser parser.ConfigurationParser `json:"parser"`
+	Key    string                     `json:"key"`
+	Value  string                     `json:"value"`
+	// The parts of the allocation found in the value, "ip" and/or "port".
+	Matched []string `json:"matched"`
+	// The match of the egg replacement that writes the value, and the value it writes.
+	// If the expected value differs from the current value the file has been changed
+	// since the server was last started, or the replacement could not be applied.
+	Replacement string `json:"replacement,omitempty"`
+	Expected    string `json:"expected,omitempty"`
+}
+
+// A single allocation of the server along with every place it is used in the
+// configuration files.
+type AllocationUsage struct {
+	Ip         string                `json:"ip"`
+	Port       int                   `json:"port"`
+	Default    bool                  `json:"default"`
+	References []AllocationReference `json:"references"`
+}
+
+// Describes where each allocation of the server ends up in its configuration files.
+type AllocationReport struct {
+	Allocations []AllocationUsage `json:"allocations"`
+	// Values that look like a port or address, because the key refers to a port or the
+	// value is an IP address, but do not match any allocation of the server. These are
+	// usually the cause of a server binding to the wrong port.
+	Unmatched []AllocationReference `json:"unmatched"`
+	// Files that could not be read, keyed by the name of the file.
+	Errors map[string]string `json:"errors"`
+}
+
+// Reads the configuration files of the server, including those for the active profile,
+// and reports which keys contain each of the allocations assigned to the server. The
+// process configuration is fetched from the Panel if the server has not been started
+// since the daemon booted. No files are modified, and the fetched configuration is only
+// used for the report rather than stored on the server, since the server may be booting
+// at the same time.
+func (s *Server) AllocationReferences() (*AllocationReport, error) {
+	pc := s.processConfiguration
+	if pc == nil {
+		cfg, rerr, err := s.GetProcessConfiguration()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		} else if rerr != nil {
+			return nil, errors.New(rerr.String())
+		}
+
+		pc = cfg.ProcessConfiguration
+	}
+
+	files := pc.ConfigurationFiles
+	if p := s.activeProfile(); p != nil {
+		files = append(append([]parser.ConfigurationFile{}, files...), p.Files...)
+	}
+
+	report := &AllocationReport{
+		Allocations: s.allocationUsages(),
+		Unmatched:   []AllocationReference{},
+		Errors:      map[string]string{},
+	}
+
+	for _, f := range files {
+		p, err := s.Filesystem.SafePath(f.FileName)
+		if err != nil {
+			report.Errors[f.FileName] = err.Error()
+			continue
+		}
+
+		f = s.expandAllocationPlaceholders(f)
+
+		entries, err := f.Entries(p)
+		if err != nil {
+			report.Errors[f.FileName] = err.Error()
+			continue
+		}
+
+		for _, e := range entries {
+			ref := AllocationReference{File: f.FileName, Parser: f.Parser, Key: e.Key, Value: e.Value}
+			if r, ok := f.ReplacementFor(e); ok {
+				ref.Replacement = r.Match
+				ref.Expected, _ = f.ResolvedValue(r)
+			}
+
+			found := false
+			for i := range report.Allocations {
+				a := &report.Allocations[i]
+				if matched := allocationMatches(a.Ip, a.Port, e.Value); len(matched) > 0 {
+					found = true
+
+					r := ref
+					r.Matched = matched
+					a.References = append(a.References, r)
+				}
+			}
+
+			if !found && looksLikeAllocation(e) {
+				ref.Matched = []string{}
+				report.Unmatched = append(report.Unmatched, ref)
+			}
+		}
+	}
+
+	return report, nil
+}
+
+// Returns every allocation of the server with the default allocation first, followed by
+// the rest ordered by address and port.
+func (s *Server) allocationUsages() []AllocationUsage {
+	def := s.Allocations.DefaultMapping
+
+	out := []AllocationUsage{}
+	for ip, ports := range s.Allocations.Bindings() {
+		for _, port := range ports {
+			out = append(out, AllocationUsage{
+				Ip:         config.TrimAddressBrackets(ip),
+				Port:       port,
+				Default:    ip == def.Ip && port == def.Port,
+				References: []AllocationReference{},
+			})
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Default != out[j].Default {
+			return out[i].Default
+		}
+
+		if out[i].Ip != out[j].Ip {
+			return out[i].Ip < out[j].Ip
+		}
+
+		return out[i].Port < out[j].Port
+	})
+
+	return out
+}
+
+// Returns the parts of the allocation that appear in the value. Addresses and ports must
+// not be part of a longer address or number, so that port 2556 is not found in 25565.
+func allocationMatches(ip string, port int, value string) []string {
+	var out []string
+
+	if ip != "" {
+		boundary := `[^0-9.]`
+		if strings.Contains(ip, ":") {
+			boundary = `[^0-9a-fA-F:]`
+		}
+
+		re := regexp.MustCompile(`(^|` + boundary + `)` + regexp.QuoteMeta(ip) + `($|` + boundary + `)`)
+		if re.MatchString(value) {
+			out = append(out, "ip")
+		}
+	}
+
+	for _, n := range portNumberRegex.FindAllString(value, -1) {
+		if n == strconv.Itoa(port) {
+			out = append(out, "port")
+			break
+		}
+	}
+
+	return out
+}
+
+// Determines if a value that does not match an allocation looks like it defines a port
+// or an address that the server binds to.
+func looksLikeAllocation(e parser.Entry) bool {
+	v := strings.TrimSpace(e.Value)
+	if v == "" {
+		return false
+	}
+
+	if p, err := strconv.Atoi(v); err == nil && p > 0 && p <= 65535 {
+		return strings.Contains(strings.ToLower(e.Key), "port")
+	}
+
+	if host, _, err := net.SplitHostPort(v); err == nil {
+		v = host
+	}
+
+	ip := net.ParseIP(config.TrimAddressBrackets(v))
+
+	// Loopback addresses are only reachable from within the container, and binding to all
+	// interfaces is correct for any allocation, so neither is reported.
+	return ip != nil && !ip.IsLoopback() && !ip.IsUnspecified()
+}
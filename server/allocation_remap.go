@@ -0,0 +1,263 @@
+package server
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// The amount of time a server is given to stop when it is restarted to apply new port
+// allocations before the process is killed.
+const remapStopTimeout = time.Minute
+
+// Applies changes to the port allocations of a server. The process configuration is
+// synced with the Panel so that any configuration files referencing the allocations are
+// rewritten, and if the server is running the new ports are made available according
+// to the remap strategy configured for the node.
+func (s *Server) remapAllocations(previous map[string][]int) {
+	zap.S().Infow("server allocations changed, remapping ports", zap.String("server", s.Uuid))
+
+	if err := s.SyncFirewall(); err != nil {
+		zap.S().Warnw("failed to apply firewall rules for server", zap.String("server", s.Uuid), zap.Error(err))
+	}
+
+	var files []parser.ConfigurationFile
+	if s.processConfiguration != nil {
+		files = s.processConfiguration.ConfigurationFiles
+	}
+
+	if err := s.Sync(); err != nil {
+		zap.S().Warnw("failed to sync server configuration after allocation change", zap.String("server", s.Uuid), zap.Error(err))
+	} else {
+		changed := changedConfigurationFiles(files, s.processConfiguration.ConfigurationFiles)
+
+		// Files using the allocation placeholders need to be rewritten even if the Panel
+		// did not change them, since the values are only resolved by the daemon.
+	eloop:
+		for _, f := range s.processConfiguration.ConfigurationFiles {
+			for _, c := range changed {
+				if c.FileName == f.FileName {
+					continue eloop
+				}
+			}
+
+			if usesAllocationPlaceholders(f) {
+				changed = append(changed, f)
+			}
+		}
+
+		// Files using values from a changed file need to be rewritten as well.
+		changed = parser.WithDependents(s.processConfiguration.ConfigurationFiles, changed)
+
+		if len(changed) > 0 {
+			s.updateConfigurationFiles(changed, "allocation")
+		}
+	}
+
+	if s.State == ProcessOfflineState {
+		return
+	}
+
+	var err error
+	switch config.Get().Docker.AllocationRemap {
+	case "proxy":
+		err = s.proxyAllocations(previous)
+	case "restart":
+		err = s.restartForRemap()
+	default:
+		s.PublishConsoleOutputFromDaemon("Server allocations have changed and will be applied the next time the server is started.")
+	}
+
+	if err != nil {
+		zap.S().Warnw("failed to remap server allocations", zap.String("server", s.Uuid), zap.Error(err))
+	}
+}
+
+// Forwards any newly added ports to the ports they replaced within the running container.
+// Ports are paired in sorted order, so a server that has its port changed from 25565 to
+// 25570 will have connections to 25570 forwarded to 25565 until it is restarted, at which
+// point the container is created with the new ports and the proxy is removed.
+func (s *Server) proxyAllocations(previous map[string][]int) error {
+	d, ok := s.Environment.(*DockerEnvironment)
+	if !ok {
+		return errors.New("allocation proxies are only supported by the docker environment")
+	}
+
+	switch s.Network.Mode {
+	case "host":
+		// Containers on the host network are bound directly to the host ports, so there
+		// is no way to forward the traffic without the server process changing ports.
+		return s.restartForRemap()
+	case "macvlan":
+		// Servers on a macvlan network are reachable using their own address and never
+		// have ports published on the host.
+		return nil
+	}
+
+	ip, err := d.containerIp()
+	if err != nil {
+		return err
+	}
+
+	current := s.Allocations.Bindings()
+	removed := mappingDifference(previous, current)
+	added := mappingDifference(current, previous)
+
+	var forwards []allocationForward
+	for i, a := range added {
+		if i >= len(removed) {
+			break
+		}
+
+		_, port, _ := net.SplitHostPort(removed[i])
+		forwards = append(forwards, allocationForward{
+			Listen: a,
+			Target: net.JoinHostPort(ip, port),
+		})
+	}
+
+	if len(forwards) == 0 {
+		s.PublishConsoleOutputFromDaemon("Server allocations have changed and will be applied the next time the server is started.")
+		return nil
+	}
+
+	if err := startAllocationProxy(s.Uuid, forwards); err != nil {
+		return err
+	}
+
+	s.PublishConsoleOutputFromDaemon("Server allocations have changed, the new ports are available now and will be fully applied the next time the server is restarted.")
+
+	return nil
+}
+
+// Restarts the server so that the container is recreated with the new port bindings.
+func (s *Server) restartForRemap() error {
+	s.PublishConsoleOutputFromDaemon("Server allocations have changed, restarting the server to apply them...")
+
+	return s.gracefulRestart(remapStopTimeout, "the allocations of the server changed")
+}
+
+// Stops the server, killing the process if it does not stop within the timeout, and then
+// starts it again. The message is recorded as the reason the server was restarted.
+func (s *Server) gracefulRestart(timeout time.Duration, message string) error {
+	if err := s.stopAndWait(timeout, StopReasonRestart, message); err != nil {
+		return err
+	}
+
+	return s.Environment.Start()
+}
+
+// Stops the server and waits for it to be offline, killing the process if it does not
+// stop within the timeout.
+func (s *Server) stopAndWait(timeout time.Duration, reason string, message string) error {
+	s.SetStopReason(reason, message)
+	if err := s.Environment.Stop(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	deadline := time.After(timeout)
+	for s.State != ProcessOfflineState {
+		select {
+		case <-deadline:
+			if err := s.Environment.Terminate(os.Kill); err != nil {
+				return errors.WithStack(err)
+			}
+		case <-time.After(time.Second):
+		}
+	}
+
+	return nil
+}
+
+// Returns the addresses of the container on the networks it is connected to, preferring
+// the node network.
+func (d *DockerEnvironment) containerIp() (string, error) {
+	c, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if n, ok := c.NetworkSettings.Networks[config.Get().Docker.Network.Name]; ok && n.IPAddress != "" {
+		return n.IPAddress, nil
+	}
+
+	for _, n := range c.NetworkSettings.Networks {
+		if n.IPAddress != "" {
+			return n.IPAddress, nil
+		}
+	}
+
+	return "", errors.New("container does not have an address on any network")
+}
+
+// Returns the "ip:port" pairs that exist in a but not in b, sorted by port.
+func mappingDifference(a map[string][]int, b map[string][]int) []string {
+	existing := make(map[string]bool)
+	for ip, ports := range b {
+		for _, port := range ports {
+			existing[net.JoinHostPort(config.TrimAddressBrackets(ip), strconv.Itoa(port))] = true
+		}
+	}
+
+	type entry struct {
+		addr string
+		port int
+	}
+
+	var out []entry
+	for ip, ports := range a {
+		for _, port := range ports {
+			addr := net.JoinHostPort(config.TrimAddressBrackets(ip), strconv.Itoa(port))
+			if !existing[addr] {
+				out = append(out, entry{addr: addr, port: port})
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].port == out[j].port {
+			return out[i].addr < out[j].addr
+		}
+
+		return out[i].port < out[j].port
+	})
+
+	addrs := make([]string, len(out))
+	for i, e := range out {
+		addrs[i] = e.addr
+	}
+
+	return addrs
+}
+
+// Determines if two sets of allocation mappings contain the same ports.
+func mappingsEqual(a map[string][]int, b map[string][]int) bool {
+	return len(mappingDifference(a, b)) == 0 && len(mappingDifference(b, a)) == 0
+}
+
+// Returns the configuration files that are new, or have different replacements than
+// they did previously.
+func changedConfigurationFiles(previous []parser.ConfigurationFile, current []parser.ConfigurationFile) []parser.ConfigurationFile {
+	existing := make(map[string]parser.ConfigurationFile, len(previous))
+	for _, f := range previous {
+		existing[f.FileName] = f
+	}
+
+	var changed []parser.ConfigurationFile
+	for _, f := range current {
+		p, ok := existing[f.FileName]
+		if !ok || p.Parser != f.Parser || !reflect.DeepEqual(p.Replace, f.Replace) {
+			changed = append(changed, f)
+		}
+	}
+
+	return changed
+}
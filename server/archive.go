@@ -0,0 +1,77 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Writes a gzip compressed tarball of the given directory within the server's data
+// directory to the writer. Paths in the archive are relative to the directory.
+func (fs *Filesystem) CompressDirectory(dir string, w io.Writer) error {
+	cleaned, err := fs.SafePath(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return compressDirectory(cleaned, w)
+}
+
+// Writes a gzip compressed tarball of the directory on the disk to the writer, skipping
+// any symlinks within it.
+func compressDirectory(root string, w io.Writer) error {
+	gw := gzip.NewWriter(w)
+	defer gw.Close()
+
+	tw := tar.NewWriter(gw)
+	defer tw.Close()
+
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Never follow symlinks out of the server directory, just skip them entirely.
+		if info.Mode()&os.ModeSymlink != 0 || p == root {
+			return nil
+		}
+
+		return addToArchive(tw, root, p, info)
+	})
+}
+
+// Adds a single file or directory to the tar writer using a name relative to the
+// root path provided.
+func addToArchive(tw *tar.Writer, root string, p string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	header.Name = filepath.ToSlash(rel)
+	if err := tw.WriteHeader(header); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !info.Mode().IsRegular() {
+		return nil
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+
+	return errors.WithStack(err)
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
+	"github.com/pterodactyl/wings/hooks"
+	"github.com/pterodactyl/wings/metrics"
+	"github.com/pterodactyl/wings/tempdir"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The directory that local backups of server data are stored in, with a directory for
+// each server.
+const backupsDirectory = "data/backups"
+
+// Returns the directory containing the local backups for the server.
+func (s *Server) backupsPath() string {
+	return filepath.Join(backupsDirectory, s.Uuid)
+}
+
+// Creates a gzip compressed tarball of the server's data directory on the node, returning
+// the path to the archive. If retain is greater than zero only that many of the most
+// recent backups are kept for the server. The backup, and any backups removed because of
+// the retention, are recorded in the audit log against the actor.
+func (s *Server) CreateLocalBackup(actor string, retain int) (string, error) {
+	start := time.Now()
+	p, err := s.createLocalBackup(actor, retain)
+
+	metrics.BackupDuration.Observe(time.Since(start).Seconds(), strconv.FormatBool(err == nil))
+
+	return p, err
+}
+
+func (s *Server) createLocalBackup(actor string, retain int) (string, error) {
+	if err := os.MkdirAll(s.backupsPath(), 0700); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	// The time is down to the nanosecond so that two backups made within the same second,
+	// such as by a schedule and a request from the Panel, never share a name.
+	p := filepath.Join(s.backupsPath(), time.Now().UTC().Format("20060102-150405.000000000")+".tar.gz")
+
+	// Write the archive to a temporary file first so that an interrupted backup is never
+	// mistaken for a complete one.
+	f, err := tempdir.File("backup-")
+	if err != nil {
+		return "", err
+	}
+
+	// Have the game save its state and stop writing to the disk while the archive is
+	// written, if the egg defines how to do so.
+	finish, err := s.prepareBackup()
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return "", err
+	}
+
+	err = s.Filesystem.CompressDirectory("/", f)
+	finish()
+
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return "", err
+	}
+	f.Close()
+
+	if err := tempdir.Move(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+
+		return "", err
+	}
+
+	audit.Log(audit.BackupCreate, actor, s.Uuid, map[string]string{"backup": filepath.Base(p)})
+
+	s.fireHook(hooks.BackupComplete, map[string]string{"backup_path": p})
+
+	if retain > 0 {
+		if err := s.pruneLocalBackups(actor, retain); err != nil {
+			return p, err
+		}
+	}
+
+	return p, nil
+}
+
+// Removes all but the most recent local backups for the server.
+func (s *Server) pruneLocalBackups(actor string, retain int) error {
+	files, err := ioutil.ReadDir(s.backupsPath())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var names []string
+	for _, f := range files {
+		if f.Mode().IsRegular() && strings.HasSuffix(f.Name(), ".tar.gz") {
+			names = append(names, f.Name())
+		}
+	}
+
+	// The names are timestamps, so sorting them orders the backups from oldest to newest.
+	sort.Strings(names)
+	for i := 0; i < len(names)-retain; i++ {
+		if err := os.Remove(filepath.Join(s.backupsPath(), names[i])); err != nil {
+			return errors.WithStack(err)
+		}
+
+		audit.Log(audit.BackupDelete, actor, s.Uuid, map[string]string{"backup": names[i]})
+	}
+
+	return nil
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"go.uber.org/zap"
+	"regexp"
+	"time"
+)
+
+// The time waited for the output of a backup command when the egg does not define one.
+const backupCommandTimeout = time.Second * 30
+
+// Runs the pre-backup commands defined by the egg of a running server, returning a
+// function that runs the post-backup commands which must be called once the archive has
+// been written. If a required command does not finish in time the post-backup commands
+// are run straight away and an error is returned, so that the backup is not taken.
+func (s *Server) prepareBackup() (func(), error) {
+	if s.processConfiguration == nil || !IsRunningState(s.State) {
+		return func() {}, nil
+	}
+
+	c := s.processConfiguration.Backup
+	if len(c.Pre) == 0 && len(c.Post) == 0 {
+		return func() {}, nil
+	}
+
+	finish := func() {
+		if len(c.Post) == 0 || !IsRunningState(s.State) {
+			return
+		}
+
+		for _, cmd := range c.Post {
+			if err := s.runBackupCommand(cmd); err != nil {
+				zap.S().Warnw("post-backup command did not complete", zap.String("server", s.Uuid), zap.String("command", cmd.Command), zap.Error(err))
+			}
+		}
+	}
+
+	if len(c.Pre) > 0 {
+		s.PublishConsoleOutputFromDaemon("Preparing server for backup...")
+	}
+
+	for _, cmd := range c.Pre {
+		err := s.runBackupCommand(cmd)
+		if err == nil {
+			continue
+		}
+
+		if cmd.Required {
+			finish()
+
+			return nil, errors.Wrapf(err, "pre-backup command \"%s\" did not complete", cmd.Command)
+		}
+
+		zap.S().Warnw("pre-backup command did not complete, continuing with backup", zap.String("server", s.Uuid), zap.String("command", cmd.Command), zap.Error(err))
+	}
+
+	return finish, nil
+}
+
+// Sends a backup command to the server and waits for it to finish, either by matching the
+// console output against the pattern for the command or by waiting for the timeout.
+func (s *Server) runBackupCommand(cmd api.BackupCommand) error {
+	timeout := time.Duration(cmd.Timeout) * time.Second
+
+	if cmd.Pattern == "" {
+		if err := s.Environment.SendCommand(cmd.Command); err != nil {
+			return err
+		}
+
+		time.Sleep(timeout)
+
+		return nil
+	}
+
+	re, err := regexp.Compile(cmd.Pattern)
+	if err != nil {
+		return errors.Wrap(err, "pattern is not a valid regular expression")
+	}
+
+	if timeout <= 0 {
+		timeout = backupCommandTimeout
+	}
+
+	// Subscribe before sending the command so that output written immediately after it
+	// is not missed. The channel is buffered and never closed, so that output that was
+	// already being delivered when it is unsubscribed does not block or panic.
+	ch := make(chan Event, 64)
+	s.Events().Subscribe(ProcessOutputEvent, ch)
+	defer s.Events().Unsubscribe(ProcessOutputEvent, ch)
+
+	if err := s.Environment.SendCommand(cmd.Command); err != nil {
+		return err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		select {
+		case e := <-ch:
+			if re.MatchString(e.Data) {
+				return nil
+			}
+		case <-timer.C:
+			return errors.Errorf("output did not match \"%s\" within %s", cmd.Pattern, timeout)
+		}
+	}
+}
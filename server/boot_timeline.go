@@ -0,0 +1,123 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/pterodactyl/wings/metrics"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// The time taken by a single phase of booting a server, in seconds.
+type BootPhase struct {
+	Name     string  `json:"name"`
+	Duration float64 `json:"duration"`
+}
+
+// The time taken to process a single configuration file while booting a server, in
+// seconds. Configuration files are processed in parallel, so these overlap.
+type BootFileTiming struct {
+	File     string  `json:"file"`
+	Duration float64 `json:"duration"`
+}
+
+// A breakdown of where the time was spent while booting a server, from the start request
+// until the server was ready. This makes it possible to tell if a slow boot is caused by
+// the node, the configuration files, or the game itself.
+type BootTimeline struct {
+	StartedAt          time.Time        `json:"started_at"`
+	Phases             []BootPhase      `json:"phases"`
+	ConfigurationFiles []BootFileTiming `json:"configuration_files"`
+
+	// The total time taken for the server to reach the running and ready states, in
+	// seconds. The time to ready is only set if the egg defines a readiness check.
+	TimeToRunning float64 `json:"time_to_running,omitempty"`
+	TimeToReady   float64 `json:"time_to_ready,omitempty"`
+
+	// Set if the server finished booting, rather than stopping or crashing part way.
+	Successful bool `json:"successful"`
+}
+
+// Tracks the boot timeline for the server while it is starting.
+type bootTracker struct {
+	mu      sync.Mutex
+	current *BootTimeline
+
+	// The time the previous phase finished, the next phase is measured from here.
+	mark time.Time
+}
+
+// Begins recording a new boot timeline for the server.
+func (s *Server) beginBoot() {
+	s.boot.mu.Lock()
+	defer s.boot.mu.Unlock()
+
+	now := time.Now()
+	s.boot.current = &BootTimeline{
+		StartedAt:          now.UTC(),
+		Phases:             []BootPhase{},
+		ConfigurationFiles: []BootFileTiming{},
+	}
+	s.boot.mark = now
+}
+
+// Records the end of a boot phase, which is measured from the end of the previous phase.
+// This is a no-op if the server is not currently booting.
+func (s *Server) bootPhase(name string) {
+	s.boot.mu.Lock()
+	defer s.boot.mu.Unlock()
+
+	if s.boot.current == nil {
+		return
+	}
+
+	now := time.Now()
+	d := now.Sub(s.boot.mark).Seconds()
+	s.boot.mark = now
+
+	s.boot.current.Phases = append(s.boot.current.Phases, BootPhase{Name: name, Duration: d})
+	metrics.ServerBootPhaseDuration.Observe(d, name)
+
+	switch name {
+	case "startup":
+		s.boot.current.TimeToRunning = now.Sub(s.boot.current.StartedAt).Seconds()
+	case "ready":
+		s.boot.current.TimeToReady = now.Sub(s.boot.current.StartedAt).Seconds()
+	}
+}
+
+// Records the time taken to process a configuration file while booting.
+func (s *Server) bootConfigurationFile(file string, d time.Duration) {
+	s.boot.mu.Lock()
+	defer s.boot.mu.Unlock()
+
+	if s.boot.current != nil {
+		s.boot.current.ConfigurationFiles = append(s.boot.current.ConfigurationFiles, BootFileTiming{File: file, Duration: d.Seconds()})
+	}
+}
+
+// Finishes the boot timeline for the server and publishes it to any listeners. This is a
+// no-op if the server is not currently booting.
+func (s *Server) finishBoot(successful bool) {
+	s.boot.mu.Lock()
+	t := s.boot.current
+	s.boot.current = nil
+	s.boot.mu.Unlock()
+
+	if t == nil {
+		return
+	}
+
+	t.Successful = successful
+	if successful {
+		metrics.ServerBootDuration.Observe(time.Since(t.StartedAt).Seconds())
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		zap.S().Warnw("failed to encode server boot timeline", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	s.Events().Publish(BootTimelineEvent, string(b))
+}
@@ -0,0 +1,397 @@
+package server
+
+import (
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The actions that can be applied to a set of servers at once.
+const (
+	BulkReinstall = "reinstall"
+	BulkConfigure = "configure"
+)
+
+// How a bulk operation handles servers that are running when it reaches them.
+const (
+	// Running servers are skipped. This is the default.
+	BulkRunningSkip = "skip"
+	// The operation waits for the server to be stopped by its users, up to the deferral
+	// timeout, before applying the action to it.
+	BulkRunningDefer = "defer"
+	// The server is stopped, the action applied, and the server started again.
+	BulkRunningRestart = "restart"
+)
+
+// The states of each server within a bulk operation.
+const (
+	BulkPending  = "pending"
+	BulkDeferred = "deferred"
+	BulkRunning  = "running"
+	BulkComplete = "complete"
+	BulkFailed   = "failed"
+	BulkSkipped  = "skipped"
+)
+
+// The number of finished bulk operations kept so that their results can be retrieved.
+const bulkHistorySize = 20
+
+// The maximum number of servers processed at once by a bulk operation.
+const bulkMaxConcurrency = 32
+
+// Selects the servers a bulk operation applies to. Every condition that is set must match
+// for a server to be included, and an empty filter matches every server on the node.
+type BulkFilter struct {
+	Servers []string `json:"servers"`
+	Owner   string   `json:"owner"`
+	// Matches servers whose image begins with this value, such as "ghcr.io/pterodactyl/yolks:java".
+	Image string `json:"image"`
+}
+
+// Determines if the server matches the filter.
+func (f BulkFilter) matches(s *Server) bool {
+	if len(f.Servers) > 0 {
+		found := false
+		for _, u := range f.Servers {
+			found = found || u == s.Uuid
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	if f.Owner != "" && s.Owner != f.Owner {
+		return false
+	}
+
+	return f.Image == "" || strings.HasPrefix(s.Container.Image, f.Image)
+}
+
+// The request to apply an action to a set of servers.
+type BulkRequest struct {
+	Action      string     `json:"action"`
+	Filter      BulkFilter `json:"filter"`
+	Concurrency int        `json:"concurrency"`
+	Running     string     `json:"running"`
+	// The number of minutes to wait for running servers to stop when deferring them.
+	DeferTimeout int `json:"defer_timeout"`
+}
+
+// The progress of a single server within a bulk operation.
+type BulkServer struct {
+	Uuid       string     `json:"uuid"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// An action being applied to a set of servers with bounded concurrency. A failure for one
+// server is recorded against it and does not affect any of the others.
+type BulkOperation struct {
+	Id         string       `json:"id"`
+	Request    BulkRequest  `json:"request"`
+	StartedAt  time.Time    `json:"started_at"`
+	FinishedAt *time.Time   `json:"finished_at,omitempty"`
+	Cancelled  bool         `json:"cancelled"`
+	Servers    []BulkServer `json:"servers"`
+
+	cancel chan struct{}
+}
+
+var bulkOperations = struct {
+	sync.Mutex
+	operations []*BulkOperation
+}{}
+
+// Returns a copy of the operation that is safe to use without holding the lock.
+func (o *BulkOperation) copy() BulkOperation {
+	c := *o
+	c.Servers = append([]BulkServer{}, o.Servers...)
+
+	return c
+}
+
+// Returns all of the bulk operations that are running or recently finished, from oldest
+// to newest.
+func BulkOperations() []BulkOperation {
+	bulkOperations.Lock()
+	defer bulkOperations.Unlock()
+
+	out := make([]BulkOperation, 0, len(bulkOperations.operations))
+	for _, o := range bulkOperations.operations {
+		out = append(out, o.copy())
+	}
+
+	return out
+}
+
+// Returns the bulk operation with the given id.
+func GetBulkOperation(id string) (BulkOperation, bool) {
+	bulkOperations.Lock()
+	defer bulkOperations.Unlock()
+
+	for _, o := range bulkOperations.operations {
+		if o.Id == id {
+			return o.copy(), true
+		}
+	}
+
+	return BulkOperation{}, false
+}
+
+// Stops a bulk operation from starting the action on any more servers. Servers that are
+// already being processed are left to finish.
+func CancelBulkOperation(id string) bool {
+	bulkOperations.Lock()
+	defer bulkOperations.Unlock()
+
+	for _, o := range bulkOperations.operations {
+		if o.Id == id {
+			if !o.Cancelled {
+				o.Cancelled = true
+				close(o.cancel)
+			}
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Updates a server within an operation while holding the lock.
+func (o *BulkOperation) update(i int, fn func(bs *BulkServer)) {
+	bulkOperations.Lock()
+	defer bulkOperations.Unlock()
+
+	fn(&o.Servers[i])
+}
+
+// Begins applying an action to every server matching the filter of the request in the
+// background, returning the operation so that its progress can be followed.
+func StartBulkOperation(r BulkRequest) (BulkOperation, error) {
+	switch r.Action {
+	case BulkReinstall, BulkConfigure:
+	default:
+		return BulkOperation{}, errors.Errorf("unknown bulk action \"%s\"", r.Action)
+	}
+
+	switch r.Running {
+	case "":
+		r.Running = BulkRunningSkip
+	case BulkRunningSkip, BulkRunningDefer, BulkRunningRestart:
+	default:
+		return BulkOperation{}, errors.Errorf("unknown running server handling \"%s\"", r.Running)
+	}
+
+	if r.Action == BulkReinstall && IsDraining() {
+		return BulkOperation{}, errors.New("servers cannot be reinstalled while the node is draining")
+	}
+
+	if r.Concurrency <= 0 {
+		r.Concurrency = 4
+	} else if r.Concurrency > bulkMaxConcurrency {
+		r.Concurrency = bulkMaxConcurrency
+	}
+
+	if r.DeferTimeout <= 0 {
+		r.DeferTimeout = 60
+	}
+
+	servers := GetServers().Filter(r.Filter.matches)
+	if len(servers) == 0 {
+		return BulkOperation{}, errors.New("no servers match the filter")
+	}
+
+	o := &BulkOperation{
+		Id:        uuid.New().String(),
+		Request:   r,
+		StartedAt: time.Now().UTC(),
+		Servers:   make([]BulkServer, len(servers)),
+		cancel:    make(chan struct{}),
+	}
+
+	for i, s := range servers {
+		o.Servers[i] = BulkServer{Uuid: s.Uuid, Status: BulkPending}
+	}
+
+	bulkOperations.Lock()
+	bulkOperations.operations = append(bulkOperations.operations, o)
+	pruneBulkOperations()
+	c := o.copy()
+	bulkOperations.Unlock()
+
+	zap.S().Infow("starting bulk server operation", zap.String("operation", o.Id), zap.String("action", r.Action), zap.Int("servers", len(servers)))
+
+	go o.run(servers)
+
+	return c, nil
+}
+
+// Removes the oldest finished operations beyond the history size. The lock must be held
+// when this is called.
+func pruneBulkOperations() {
+	var kept []*BulkOperation
+	finished := 0
+
+	for i := len(bulkOperations.operations) - 1; i >= 0; i-- {
+		o := bulkOperations.operations[i]
+		if o.FinishedAt != nil {
+			finished++
+			if finished > bulkHistorySize {
+				continue
+			}
+		}
+
+		kept = append([]*BulkOperation{o}, kept...)
+	}
+
+	bulkOperations.operations = kept
+}
+
+// Processes each of the servers, running the action on up to the configured number of
+// servers at once. Servers waiting to be stopped by their users do not count towards the
+// limit, so that they cannot hold up the rest of the operation.
+func (o *BulkOperation) run(servers []*Server) {
+	defer supervisor.Recover("bulk operation")
+
+	sem := make(chan struct{}, o.Request.Concurrency)
+	wg := sync.WaitGroup{}
+
+	for i, s := range servers {
+		wg.Add(1)
+
+		go func(i int, s *Server) {
+			defer wg.Done()
+
+			o.process(i, s, sem)
+		}(i, s)
+	}
+
+	wg.Wait()
+
+	bulkOperations.Lock()
+	t := time.Now().UTC()
+	o.FinishedAt = &t
+	bulkOperations.Unlock()
+
+	zap.S().Infow("finished bulk server operation", zap.String("operation", o.Id))
+}
+
+// Applies the action of the operation to a single server, recording the outcome. A panic
+// while processing the server is recorded as a failure for that server alone.
+func (o *BulkOperation) process(i int, s *Server, sem chan struct{}) {
+	var err error
+	status := BulkComplete
+
+	defer func() {
+		if r := recover(); r != nil {
+			status, err = BulkFailed, errors.Errorf("panic while processing server: %v", r)
+		}
+
+		o.update(i, func(bs *BulkServer) {
+			t := time.Now().UTC()
+			bs.FinishedAt = &t
+			bs.Status = status
+
+			if err != nil {
+				bs.Error = err.Error()
+			}
+		})
+
+		if err != nil && status == BulkFailed {
+			zap.S().Warnw("bulk operation failed for server", zap.String("operation", o.Id), zap.String("server", s.Uuid), zap.Error(err))
+		}
+	}()
+
+	if s.State != ProcessOfflineState {
+		switch o.Request.Running {
+		case BulkRunningSkip:
+			status, err = BulkSkipped, errors.New("server is running")
+			return
+		case BulkRunningDefer:
+			o.update(i, func(bs *BulkServer) {
+				bs.Status = BulkDeferred
+			})
+
+			if !o.waitForOffline(s) {
+				status, err = BulkSkipped, errors.New("server did not stop before the deferral timeout")
+				return
+			}
+		}
+	}
+
+	select {
+	case <-o.cancel:
+		status, err = BulkSkipped, errors.New("operation was cancelled")
+		return
+	case sem <- struct{}{}:
+		defer func() { <-sem }()
+	}
+
+	restart := false
+	if s.State != ProcessOfflineState {
+		switch o.Request.Running {
+		case BulkRunningSkip, BulkRunningDefer:
+			status, err = BulkSkipped, errors.New("server was started before the operation reached it")
+			return
+		case BulkRunningRestart:
+			restart = true
+
+			s.PublishConsoleOutputFromDaemon("Server is being stopped to apply an update from the node administrator...")
+			if err = s.stopAndWait(remapStopTimeout, StopReasonRestart, "the node administrator is applying an update to the server"); err != nil {
+				status = BulkFailed
+				return
+			}
+		}
+	}
+
+	o.update(i, func(bs *BulkServer) {
+		t := time.Now().UTC()
+		bs.StartedAt = &t
+		bs.Status = BulkRunning
+	})
+
+	switch o.Request.Action {
+	case BulkReinstall:
+		err = s.Install()
+	case BulkConfigure:
+		if err = s.Sync(); err == nil {
+			s.UpdateConfigurationFiles()
+		}
+	}
+
+	if err != nil {
+		status = BulkFailed
+	}
+
+	if restart {
+		if serr := s.Environment.Start(); serr != nil && err == nil {
+			status, err = BulkFailed, errors.Wrap(serr, "failed to start server after the operation")
+		}
+	}
+}
+
+// Waits for a running server to be stopped, returning false if it is still running once
+// the deferral timeout is reached or the operation is cancelled.
+func (o *BulkOperation) waitForOffline(s *Server) bool {
+	deadline := time.After(time.Duration(o.Request.DeferTimeout) * time.Minute)
+
+	for s.State != ProcessOfflineState {
+		select {
+		case <-deadline:
+			return false
+		case <-o.cancel:
+			return false
+		case <-time.After(time.Second * 5):
+		}
+	}
+
+	return true
+}
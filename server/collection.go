@@ -1,6 +1,9 @@
 package server
 
-import "sync"
+import (
+	"github.com/pterodactyl/wings/metrics"
+	"sync"
+)
 
 type Collection struct {
 	items []*Server
@@ -25,6 +28,10 @@ func (c *Collection) Add(s *Server) {
 	c.mutex.Lock()
 	defer c.mutex.Unlock()
 
+	// The server may have been deleted from the node before, such as when it is transferred
+	// back to the node, in which case its metrics were removed and need to be published again.
+	metrics.RestoreServer(s.Uuid)
+
 	c.items = append(c.items, s)
 }
 
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/parser"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The directory containing the journal of changes made to the configuration files of
+// each server.
+const configJournalDirectory = "data/config_journal"
+
+// The size at which the journal for a server is trimmed, keeping the newest half.
+const configJournalMaxSize = 1024 * 1024
+
+var configJournalLock sync.Mutex
+
+// Returns the path to the configuration journal for the server.
+func (s *Server) configJournalPath() string {
+	return path.Join(configJournalDirectory, s.Uuid+".log")
+}
+
+// Appends a change to the configuration journal for the server. Changes that did not
+// modify the file are not recorded.
+func (s *Server) journalConfigurationChange(c *parser.Change) {
+	if c == nil || !c.Notable() {
+		return
+	}
+
+	if c.Conflicts > 0 {
+		zap.S().Warnw("configuration file was modified by another writer while being updated", zap.String("server", s.Uuid), zap.String("file", c.File), zap.Int("conflicts", c.Conflicts))
+	}
+
+	if err := s.appendConfigJournal(c); err != nil {
+		zap.S().Warnw("failed to write to server configuration journal", zap.String("server", s.Uuid), zap.Error(err))
+	}
+}
+
+// Writes a file for the server on behalf of the API. If the file is one of the
+// configuration files for the server the write is recorded in the configuration journal.
+func (s *Server) WriteFile(file string, r io.Reader) error {
+	p, err := s.Filesystem.SafePath(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := s.Filesystem.checkFileRules(p, FileActionWrite); err != nil {
+		return err
+	}
+
+	if !s.isConfigurationFile(file) {
+		return s.Filesystem.Writefile(file, r)
+	}
+
+	before, _ := parser.HashFile(p)
+	if err := s.Filesystem.Writefile(file, r); err != nil {
+		return err
+	}
+
+	after, _ := parser.HashFile(p)
+	s.journalConfigurationChange(&parser.Change{
+		Time:   time.Now().UTC(),
+		File:   file,
+		Source: "api",
+		Before: before,
+		After:  after,
+	})
+
+	return nil
+}
+
+// Determines if the file is one of the configuration files updated by the egg for the
+// server.
+func (s *Server) isConfigurationFile(file string) bool {
+	if s.processConfiguration == nil {
+		return false
+	}
+
+	for _, f := range s.processConfiguration.ConfigurationFiles {
+		if filepath.Clean("/"+f.FileName) == filepath.Clean("/"+file) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Server) appendConfigJournal(c *parser.Change) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	configJournalLock.Lock()
+	defer configJournalLock.Unlock()
+
+	if err := os.MkdirAll(configJournalDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	f, err := os.OpenFile(s.configJournalPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err = f.Write(append(b, '\n'))
+	f.Close()
+
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return s.trimConfigJournal()
+}
+
+// Removes the oldest half of the journal once it grows beyond the maximum size.
+func (s *Server) trimConfigJournal() error {
+	st, err := os.Stat(s.configJournalPath())
+	if err != nil || st.Size() < configJournalMaxSize {
+		return errors.WithStack(err)
+	}
+
+	b, err := ioutil.ReadFile(s.configJournalPath())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	b = b[len(b)/2:]
+	for i, c := range b {
+		if c == '\n' {
+			b = b[i+1:]
+			break
+		}
+	}
+
+	return errors.WithStack(ioutil.WriteFile(s.configJournalPath(), b, 0600))
+}
+
+// Returns the most recent changes recorded in the configuration journal for the server,
+// newest first.
+func (s *Server) ConfigurationJournal(limit int) ([]parser.Change, error) {
+	configJournalLock.Lock()
+	defer configJournalLock.Unlock()
+
+	out := make([]parser.Change, 0)
+
+	f, err := os.Open(s.configJournalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return out, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var c parser.Change
+		if err := json.Unmarshal(scanner.Bytes(), &c); err != nil {
+			continue
+		}
+
+		out = append(out, c)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+
+	return out, nil
+}
+
+// Removes the configuration journal for the server.
+func (s *Server) RemoveConfigurationJournal() error {
+	configJournalLock.Lock()
+	defer configJournalLock.Unlock()
+
+	if err := os.Remove(s.configJournalPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/parser"
+)
+
+// Checks all of the configuration files for the server, including those for the active
+// profile, and reports what each replacement would do without modifying any files. The
+// process configuration is fetched from the Panel if the server has not been started
+// since the daemon booted.
+func (s *Server) LintConfigurationFiles() ([]parser.LintReport, error) {
+	if s.processConfiguration == nil {
+		cfg, rerr, err := s.GetProcessConfiguration()
+		if err != nil {
+			return nil, errors.WithStack(err)
+		} else if rerr != nil {
+			return nil, errors.New(rerr.String())
+		}
+
+		s.processConfiguration = cfg.ProcessConfiguration
+	}
+
+	files := s.configurationFiles()
+
+	// Files that depend on each other in a cycle are reported, since they are updated in
+	// no particular order.
+	var cycle []parser.ConfigurationFile
+	if stages, err := parser.OrderFiles(files); err != nil {
+		cycle = stages[len(stages)-1]
+	}
+
+	out := make([]parser.LintReport, 0, len(files))
+	for _, f := range files {
+		p, err := s.Filesystem.SafePath(f.FileName)
+		if err != nil {
+			out = append(out, parser.LintReport{File: f.FileName, Parser: f.Parser, Error: err.Error()})
+			continue
+		}
+
+		f = s.expandAllocationPlaceholders(f).ExpandFileReferences(s.lookupFileReference)
+
+		r := f.Lint(p)
+		for _, c := range cycle {
+			if c.FileName == f.FileName && r.Error == "" {
+				r.Error = "file is part of a dependency cycle, the files in the cycle are updated in no particular order"
+			}
+		}
+
+		out = append(out, r)
+	}
+
+	return out, nil
+}
@@ -4,19 +4,108 @@ import (
 	"github.com/pterodactyl/wings/parser"
 	"go.uber.org/zap"
 	"sync"
+	"time"
 )
 
 // Parent function that will update all of the defined configuration files for a server
 // automatically to ensure that they always use the specified values.
 func (s *Server) UpdateConfigurationFiles() {
+	s.updateConfigurationFiles(s.processConfiguration.ConfigurationFiles, "egg")
+
+	// The files for the active profile are processed once the egg files are complete,
+	// since both could modify the same file.
+	if p := s.activeProfile(); p != nil && len(p.Files) > 0 {
+		s.updateConfigurationFiles(p.Files, "profile")
+	}
+}
+
+// Returns every configuration file for the server, including those for the active
+// profile.
+func (s *Server) configurationFiles() []parser.ConfigurationFile {
+	var files []parser.ConfigurationFile
+	if s.processConfiguration != nil {
+		files = s.processConfiguration.ConfigurationFiles
+	}
+
+	if p := s.activeProfile(); p != nil {
+		files = append(append([]parser.ConfigurationFile{}, files...), p.Files...)
+	}
+
+	return files
+}
+
+// Looks up the current value of a key in one of the configuration files of the server,
+// which is used to resolve the references to it in the replacements of other files.
+func (s *Server) lookupFileReference(id string, key string) (string, bool) {
+	for _, f := range s.configurationFiles() {
+		if !f.Is(id) {
+			continue
+		}
+
+		p, err := s.Filesystem.SafePath(f.FileName)
+		if err != nil {
+			return "", false
+		}
+
+		entries, err := f.Entries(p)
+		if err != nil {
+			zap.S().Warnw("failed to read referenced configuration file", zap.String("server", s.Uuid), zap.String("file", f.FileName), zap.Error(err))
+			return "", false
+		}
+
+		for _, e := range entries {
+			if e.Key == key {
+				return e.Value, true
+			}
+		}
+
+		return "", false
+	}
+
+	return "", false
+}
+
+// Updates the given configuration files for the server, blocking until all of them have
+// been processed. Each change is recorded in the configuration journal of the server
+// against the source provided.
+//
+// Files are updated after the files they depend on, files that do not depend on each
+// other are updated at the same time.
+func (s *Server) updateConfigurationFiles(files []parser.ConfigurationFile, source string) {
+	stages, err := parser.OrderFiles(files)
+	if err != nil {
+		zap.S().Warnw("configuration files cannot be ordered, updating them in any order", zap.String("server", s.Uuid), zap.Error(err))
+	}
+
+	order := make([][]string, len(stages))
+	for i, stage := range stages {
+		for _, f := range stage {
+			order[i] = append(order[i], f.FileName)
+		}
+	}
+	s.DebugTrace(DebugCategoryParser, "ordered configuration files", map[string]interface{}{"source": source, "stages": order})
+
+	for _, stage := range stages {
+		s.updateConfigurationStage(stage, source)
+	}
+}
+
+// Updates configuration files that do not depend on each other at the same time,
+// blocking until all of them have been processed.
+func (s *Server) updateConfigurationStage(files []parser.ConfigurationFile, source string) {
 	wg := new(sync.WaitGroup)
 
-	for _, v := range s.processConfiguration.ConfigurationFiles {
+	for _, v := range files {
 		wg.Add(1)
 
 		go func(f parser.ConfigurationFile, server *Server) {
 			defer wg.Done()
 
+			start := time.Now()
+			defer func() {
+				server.bootConfigurationFile(f.FileName, time.Since(start))
+			}()
+
 			p, err := s.Filesystem.SafePath(f.FileName)
 			if err != nil {
 				zap.S().Errorw("failed to generate safe path for configuration file", zap.String("server", server.Uuid), zap.Error(err))
@@ -24,7 +113,22 @@ func (s *Server) UpdateConfigurationFiles() {
 				return
 			}
 
-			if err := f.Parse(p, false); err != nil {
+			f = server.expandAllocationPlaceholders(f).ExpandFileReferences(server.lookupFileReference)
+			c, err := f.Apply(p, source)
+			server.journalConfigurationChange(c)
+
+			server.DebugTrace(DebugCategoryParser, "updated configuration file", map[string]interface{}{
+				"file":         c.File,
+				"parser":       c.Parser,
+				"source":       source,
+				"replacements": len(f.Replace),
+				"changed":      c.Before != c.After,
+				"conflicts":    c.Conflicts,
+				"error":        c.Error,
+				"duration_ms":  int64(time.Since(start) / time.Millisecond),
+			})
+
+			if err != nil {
 				zap.S().Errorw("failed to parse and update server configuration file", zap.String("server", server.Uuid), zap.Error(err))
 			}
 		}(v, s)
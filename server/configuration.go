@@ -23,6 +23,21 @@ func (s *Server) WriteConfigurationToDisk() ([]byte, error) {
 		return nil, errors.WithStack(err)
 	}
 
+	// The file only ever contains the configuration from the Panel, so any local overrides
+	// are swapped back for the values they replaced in a copy of the configuration.
+	if s.panelValues != nil {
+		c := new(Server)
+		if err := yaml.Unmarshal(b, c); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		s.panelValues.restore(c)
+
+		if b, err = yaml.Marshal(c); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
 	if _, err := f.Write(b); err != nil {
 		return nil, errors.WithStack(err)
 	}
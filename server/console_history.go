@@ -0,0 +1,153 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/config"
+	"math"
+	"sync"
+	"time"
+)
+
+// The number of commands kept in the console history of each server when the node does
+// not configure a size.
+const consoleHistorySize = 200
+
+// A command that was sent to the console of a server, and who sent it. Commands are given
+// an id that increases with each command sent to the server, so that a single command can
+// be referred to when replaying it.
+type ConsoleCommand struct {
+	Id      uint64    `json:"id"`
+	Time    time.Time `json:"time"`
+	Actor   string    `json:"actor"`
+	Command string    `json:"command"`
+}
+
+// Tracks the commands sent to the console of a server, and how quickly each actor is
+// sending them.
+type consoleHistory struct {
+	mu       sync.Mutex
+	commands []ConsoleCommand
+	buckets  map[string]*commandBucket
+	// The id of the last command recorded.
+	last uint64
+}
+
+// A token bucket limiting the rate at which a single actor is able to send commands.
+type commandBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token from the bucket, refilling it at the given rate up to the burst size.
+// Returns false if the bucket is empty.
+func (b *commandBucket) take(now time.Time, rate float64, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+
+	b.tokens--
+
+	return true
+}
+
+// Records a command sent to the console of the server by the actor. Only the most recent
+// commands are kept, older commands remain available in the audit log.
+func (s *Server) RecordCommand(actor string, command string) {
+	size := config.Get().System.CommandHistorySize
+	if size <= 0 {
+		size = consoleHistorySize
+	}
+
+	s.console.mu.Lock()
+	defer s.console.mu.Unlock()
+
+	s.console.last++
+	s.console.commands = append(s.console.commands, ConsoleCommand{
+		Id:      s.console.last,
+		Time:    time.Now().UTC(),
+		Actor:   actor,
+		Command: command,
+	})
+
+	if len(s.console.commands) > size {
+		s.console.commands = s.console.commands[len(s.console.commands)-size:]
+	}
+}
+
+// Returns the command in the console history of the server with the given id, or false
+// if it is no longer in the history.
+func (s *Server) ConsoleCommand(id uint64) (ConsoleCommand, bool) {
+	s.console.mu.Lock()
+	defer s.console.mu.Unlock()
+
+	for _, c := range s.console.commands {
+		if c.Id == id {
+			return c, true
+		}
+	}
+
+	return ConsoleCommand{}, false
+}
+
+// Returns the most recent commands sent to the console of the server, ordered from oldest
+// to newest. If an actor is provided only the commands sent by that actor are returned.
+func (s *Server) ConsoleHistory(actor string, limit int) []ConsoleCommand {
+	s.console.mu.Lock()
+	defer s.console.mu.Unlock()
+
+	out := make([]ConsoleCommand, 0)
+	for _, c := range s.console.commands {
+		if actor == "" || c.Actor == actor {
+			out = append(out, c)
+		}
+	}
+
+	if limit > 0 && len(out) > limit {
+		out = out[len(out)-limit:]
+	}
+
+	return out
+}
+
+// Determines if the actor is allowed to send another command to the console of the server
+// without exceeding the rate limit configured for the node.
+func (s *Server) AllowCommand(actor string) bool {
+	t := config.Get().Throttles
+	if t.CommandsPerSecond <= 0 {
+		return true
+	}
+
+	burst := math.Max(float64(t.CommandBurst), 1)
+
+	s.console.mu.Lock()
+	defer s.console.mu.Unlock()
+
+	if s.console.buckets == nil {
+		s.console.buckets = make(map[string]*commandBucket)
+	}
+
+	now := time.Now()
+
+	// Buckets that have had time to refill completely are the same as a new bucket, so
+	// they are removed to stop the map growing with every actor that has ever connected.
+	full := time.Duration(burst / float64(t.CommandsPerSecond) * float64(time.Second))
+	for k, b := range s.console.buckets {
+		if now.Sub(b.last) > full {
+			delete(s.console.buckets, k)
+		}
+	}
+
+	b, ok := s.console.buckets[actor]
+	if !ok {
+		b = &commandBucket{}
+		s.console.buckets[actor] = b
+	}
+
+	return b.take(now, float64(t.CommandsPerSecond), burst)
+}
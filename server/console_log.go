@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/ansi"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/daemonlog"
+	"go.uber.org/zap"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The layout of the timestamp written before each line of the console log.
+const consoleLogTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// The rotated log file the console output of a server is written to.
+type consoleLog struct {
+	mu sync.Mutex
+	f  *daemonlog.File
+	// Set if the log could not be opened, so that the failure is only logged once.
+	failed bool
+	// Set once the log has been removed along with the server.
+	removed bool
+}
+
+// A single line of console output read back from the console log of a server.
+type ConsoleLogLine struct {
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+}
+
+// Defines the lines to return when reading the console log of a server. Empty values
+// match every line.
+type ConsoleLogQuery struct {
+	Since time.Time
+	Until time.Time
+	// Matches the lines to return. Lines are matched with any color codes removed.
+	Match func(line string) bool
+	// The maximum number of lines to return. Only the most recent lines are returned
+	// when more than this many lines match.
+	Limit int
+}
+
+// Returns the path to the active console log for the server.
+func (s *Server) consoleLogPath() string {
+	return filepath.Join(config.Get().System.ConsoleLogs.Directory, s.Uuid+".log")
+}
+
+// Writes a line of console output to the console log of the server, opening the log the
+// first time output is written to it.
+func (s *Server) writeConsoleLog(data string) {
+	cfg := config.Get().System.ConsoleLogs
+	if !cfg.Enabled {
+		return
+	}
+
+	s.consoleLog.mu.Lock()
+	defer s.consoleLog.mu.Unlock()
+
+	if s.consoleLog.removed {
+		return
+	}
+
+	if s.consoleLog.f == nil {
+		f, err := daemonlog.OpenFile(s.consoleLogPath(), cfg.Rotation())
+		if err != nil {
+			if !s.consoleLog.failed {
+				zap.S().Warnw("failed to open server console log", zap.String("server", s.Uuid), zap.Error(err))
+			}
+
+			s.consoleLog.failed = true
+			return
+		}
+
+		s.consoleLog.f = f
+		s.consoleLog.failed = false
+	}
+
+	// Each line of the log must be a single line of output, so any line breaks within the
+	// output are escaped.
+	line := strings.NewReplacer("\r", "", "\n", "\\n").Replace(data)
+
+	if _, err := s.consoleLog.f.Write([]byte(time.Now().UTC().Format(consoleLogTimeLayout) + " " + line + "\n")); err != nil {
+		zap.S().Debugw("failed to write to server console log", zap.String("server", s.Uuid), zap.Error(err))
+	}
+}
+
+// Reads the lines from the console log of the server that match the query, from oldest
+// to newest.
+func (s *Server) ReadConsoleLog(q ConsoleLogQuery) ([]ConsoleLogLine, error) {
+	// Make sure anything buffered is on the disk before it is read back.
+	s.consoleLog.mu.Lock()
+	if s.consoleLog.f != nil {
+		s.consoleLog.f.Sync()
+	}
+	s.consoleLog.mu.Unlock()
+
+	var lines []ConsoleLogLine
+	err := daemonlog.ScanLines(s.consoleLogPath(), q.Since, func(b []byte) {
+		i := bytes.IndexByte(b, ' ')
+		if i < 0 {
+			return
+		}
+
+		t, err := time.Parse(consoleLogTimeLayout, string(b[:i]))
+		if err != nil {
+			return
+		}
+
+		if (!q.Since.IsZero() && t.Before(q.Since)) || (!q.Until.IsZero() && t.After(q.Until)) {
+			return
+		}
+
+		line := string(b[i+1:])
+		if q.Match != nil && !q.Match(ansi.Strip(line)) {
+			return
+		}
+
+		lines = append(lines, ConsoleLogLine{Time: t, Line: line})
+
+		if q.Limit > 0 && len(lines) > q.Limit*2 {
+			lines = append(lines[:0], lines[len(lines)-q.Limit:]...)
+		}
+	})
+
+	if q.Limit > 0 && len(lines) > q.Limit {
+		lines = lines[len(lines)-q.Limit:]
+	}
+
+	return lines, err
+}
+
+// Closes and removes the console log for the server, along with all of its rotated
+// files. Nothing more is written to the log once it has been removed.
+func (s *Server) RemoveConsoleLog() error {
+	s.consoleLog.mu.Lock()
+	defer s.consoleLog.mu.Unlock()
+
+	s.consoleLog.removed = true
+	if s.consoleLog.f != nil {
+		s.consoleLog.f.Close()
+		s.consoleLog.f = nil
+	}
+
+	p := s.consoleLogPath()
+	for _, f := range append(daemonlog.Rotated(p), p) {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,303 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// The directory containing the console transforms configured for servers.
+const consoleTransformsDirectory = "data/console_transforms"
+
+// The layout used for timestamps when the transforms do not define one.
+const defaultConsoleTimestampFormat = "15:04:05"
+
+// The amount of time a repeated line is held back for before the number of times it was
+// repeated is written, if no other output is received.
+const consoleRepeatFlushDelay = time.Second * 2
+
+// Transforms applied to the output of the server process before it is sent to the
+// console, and written to the console log. They only change how the output is displayed,
+// the readiness and health checks for the server always see the original output.
+type ConsoleTransforms struct {
+	// Prefixes every line with the time it was received, using the Go time layout set as
+	// the format.
+	Timestamps      bool   `json:"timestamps"`
+	TimestampFormat string `json:"timestamp_format"`
+
+	// Collapses runs of identical lines into a single line, followed by the number of
+	// times it was repeated.
+	Deduplicate bool `json:"deduplicate"`
+
+	// Prefixes lines matching a pattern, such as the output of a sub-process. The first
+	// matching rule is used.
+	Prefixes []ConsolePrefix `json:"prefixes"`
+}
+
+// Adds a prefix to the lines of output matching the pattern. The prefix can reference
+// the groups captured by the pattern, such as "[$1] ".
+type ConsolePrefix struct {
+	Pattern string `json:"pattern"`
+	Prefix  string `json:"prefix"`
+
+	// Applies the prefix to the lines following a match that do not match any rule, so
+	// that output spanning multiple lines such as a stack trace is prefixed as well.
+	Sticky bool `json:"sticky"`
+}
+
+type compiledPrefix struct {
+	pattern *regexp.Regexp
+	ConsolePrefix
+}
+
+// Tracks the console transforms for a server and the state of the output passing through
+// them.
+type consoleTransformer struct {
+	mu       sync.Mutex
+	loaded   bool
+	settings ConsoleTransforms
+	prefixes []compiledPrefix
+
+	// The prefix applied to lines that do not match any rule.
+	sticky string
+
+	// The last line of output, and the number of times it has been repeated since it was
+	// last sent.
+	last    string
+	repeats int
+	flush   *time.Timer
+}
+
+// Returns the path to the console transforms file for the server.
+func (s *Server) consoleTransformsPath() string {
+	return path.Join(consoleTransformsDirectory, s.Uuid+".json")
+}
+
+// Returns the console transforms configured for the server.
+func (s *Server) GetConsoleTransforms() (ConsoleTransforms, error) {
+	t := &s.consoleTransforms
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := s.loadConsoleTransforms(); err != nil {
+		return ConsoleTransforms{}, err
+	}
+
+	return t.settings, nil
+}
+
+// Validates and stores the console transforms for the server. The transforms are applied
+// to the output of the server immediately, without it needing to be restarted.
+func (s *Server) UpdateConsoleTransforms(c ConsoleTransforms) error {
+	prefixes, err := compileConsolePrefixes(c.Prefixes)
+	if err != nil {
+		return err
+	}
+
+	if c.Prefixes == nil {
+		c.Prefixes = []ConsolePrefix{}
+	}
+
+	if err := os.MkdirAll(consoleTransformsDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.MarshalIndent(c, "", "    ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	t := &s.consoleTransforms
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := ioutil.WriteFile(s.consoleTransformsPath(), b, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Any repeated output held back by the previous transforms is sent before they are
+	// replaced, so that it is not lost.
+	if summary := s.takeConsoleRepeats(); summary != "" {
+		s.Events().Publish(ConsoleOutputEvent, summary)
+	}
+
+	t.loaded = true
+	t.settings = c
+	t.prefixes = prefixes
+	t.sticky = ""
+	t.last = ""
+
+	return nil
+}
+
+// Removes the console transforms configured for the server.
+func (s *Server) RemoveConsoleTransforms() error {
+	if err := os.Remove(s.consoleTransformsPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Loads the console transforms for the server from the disk if they have not been loaded
+// yet. The lock for the transforms must be held by the caller.
+func (s *Server) loadConsoleTransforms() error {
+	t := &s.consoleTransforms
+	if t.loaded {
+		return nil
+	}
+
+	c := ConsoleTransforms{Prefixes: []ConsolePrefix{}}
+
+	b, err := ioutil.ReadFile(s.consoleTransformsPath())
+	if err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	} else if err == nil {
+		if err := json.Unmarshal(b, &c); err != nil {
+			return errors.Wrap(err, "failed to parse console transforms")
+		}
+	}
+
+	prefixes, err := compileConsolePrefixes(c.Prefixes)
+	if err != nil {
+		return err
+	}
+
+	t.loaded = true
+	t.settings = c
+	t.prefixes = prefixes
+
+	return nil
+}
+
+func compileConsolePrefixes(prefixes []ConsolePrefix) ([]compiledPrefix, error) {
+	out := make([]compiledPrefix, 0, len(prefixes))
+	for _, p := range prefixes {
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, errors.Wrapf(err, "console prefix pattern \"%s\" is not a valid regular expression", p.Pattern)
+		}
+
+		out = append(out, compiledPrefix{pattern: re, ConsolePrefix: p})
+	}
+
+	return out, nil
+}
+
+// Sends a line of output from the server process to the console. The checks that depend
+// on the output of the server, and any listeners within the daemon, see the line before
+// any transforms are applied, the transforms only change what is sent to clients.
+func (s *Server) publishConsoleOutput(line string) {
+	line = s.Redact(line)
+
+	s.inspectConsoleOutput(line)
+	s.Events().Publish(ProcessOutputEvent, line)
+
+	for _, l := range s.transformConsoleOutput(line) {
+		s.Events().Publish(ConsoleOutputEvent, l)
+	}
+}
+
+// Applies the console transforms for the server to a line of output, returning the lines
+// that should be sent to the console. No lines are returned if the line is a repeat of
+// the previous line and is being held back.
+func (s *Server) transformConsoleOutput(line string) []string {
+	t := &s.consoleTransforms
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := s.loadConsoleTransforms(); err != nil {
+		return []string{line}
+	}
+
+	var out []string
+	if t.settings.Deduplicate {
+		if line == t.last {
+			t.repeats++
+
+			if t.flush == nil {
+				t.flush = time.AfterFunc(consoleRepeatFlushDelay, func() {
+					t.mu.Lock()
+					summary := s.takeConsoleRepeats()
+					t.mu.Unlock()
+
+					if summary != "" {
+						s.Events().Publish(ConsoleOutputEvent, summary)
+					}
+				})
+			}
+
+			return nil
+		}
+
+		if summary := s.takeConsoleRepeats(); summary != "" {
+			out = append(out, summary)
+		}
+
+		t.last = line
+	}
+
+	prefix := t.sticky
+	for _, p := range t.prefixes {
+		if m := p.pattern.FindStringSubmatchIndex(line); m != nil {
+			prefix = string(p.pattern.ExpandString(nil, p.Prefix, line, m))
+
+			t.sticky = ""
+			if p.Sticky {
+				t.sticky = prefix
+			}
+
+			break
+		}
+	}
+
+	return append(out, t.timestamp()+prefix+line)
+}
+
+// Returns the line reporting the number of times the last line of output was repeated,
+// resetting the count. An empty string is returned if it was not repeated. The lock for
+// the transforms must be held by the caller.
+func (s *Server) takeConsoleRepeats() string {
+	t := &s.consoleTransforms
+
+	if t.flush != nil {
+		t.flush.Stop()
+		t.flush = nil
+	}
+
+	if t.repeats == 0 {
+		return ""
+	}
+
+	n := t.repeats
+	t.repeats = 0
+
+	if n == 1 {
+		return t.timestamp() + "(previous line repeated 1 more time)"
+	}
+
+	return t.timestamp() + fmt.Sprintf("(previous line repeated %d more times)", n)
+}
+
+// Returns the timestamp prefixed to lines of output, or an empty string if timestamps
+// are not enabled.
+func (t *consoleTransformer) timestamp() string {
+	if !t.settings.Timestamps {
+		return ""
+	}
+
+	format := t.settings.TimestampFormat
+	if format == "" {
+		format = defaultConsoleTimestampFormat
+	}
+
+	return "[" + time.Now().Format(format) + "] "
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The ways that unknown containers can be handled.
+const (
+	ReconcileDisabled   = "disabled"
+	ReconcileReport     = "report"
+	ReconcileAdopt      = "adopt"
+	ReconcileQuarantine = "quarantine"
+)
+
+// The actions taken for an unknown container.
+const (
+	ContainerIgnored     = "none"
+	ContainerAdopted     = "adopted"
+	ContainerQuarantined = "quarantined"
+	ContainerFailed      = "failed"
+)
+
+// The suffix added to the name of a quarantined container, followed by the time it was
+// quarantined.
+const quarantineSuffix = "_quarantined_"
+
+// Matches the names of the containers created for servers, along with any suffix added
+// when the container was quarantined.
+var serverContainerRegex = regexp.MustCompile(`^([a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12})(_installer)?(` + quarantineSuffix + `\d+)?$`)
+
+// A container that was created for a server the daemon is not managing.
+type UnknownContainer struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Image   string    `json:"image"`
+	State   string    `json:"state"`
+	Created time.Time `json:"created"`
+	// The server the container was created for, and whether it runs the server process
+	// or its installation.
+	Server    string `json:"server"`
+	Installer bool   `json:"installer"`
+	Action    string `json:"action"`
+	Error     string `json:"error,omitempty"`
+}
+
+// The result of the last check for unknown containers.
+type ReconciliationReport struct {
+	Mode       string             `json:"mode"`
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at"`
+	Containers []UnknownContainer `json:"containers"`
+	Error      string             `json:"error,omitempty"`
+}
+
+var reconciliation = struct {
+	sync.Mutex
+	// Held while a check is running so that checks never overlap.
+	running sync.Mutex
+	report  *ReconciliationReport
+}{}
+
+// Returns the result of the last check for unknown containers, or nil if no check has
+// run yet.
+func GetReconciliationReport() *ReconciliationReport {
+	reconciliation.Lock()
+	defer reconciliation.Unlock()
+
+	return reconciliation.report
+}
+
+// Starts the background routine that checks for unknown containers when the daemon boots
+// and then on the configured interval.
+func StartContainerReconciliation(cfg *config.ContainerReconciliationConfiguration) {
+	if cfg.Mode == ReconcileDisabled {
+		return
+	}
+
+	go supervisor.Supervise("container reconciliation", func() error {
+		for {
+			if r := ReconcileContainers(cfg.Mode); r.Error != "" {
+				zap.S().Warnw("failed to check for unknown containers", zap.String("error", r.Error))
+			}
+
+			if cfg.Interval <= 0 {
+				return nil
+			}
+
+			time.Sleep(time.Duration(cfg.Interval) * time.Minute)
+		}
+	})
+}
+
+// Finds the containers that were created for a server but that belong to a server the
+// daemon is not managing, and handles them using the given mode. Containers are never
+// removed, quarantining only stops and renames them so that they can be inspected.
+func ReconcileContainers(mode string) *ReconciliationReport {
+	reconciliation.running.Lock()
+	defer reconciliation.running.Unlock()
+
+	r := &ReconciliationReport{Mode: mode, StartedAt: time.Now().UTC(), Containers: []UnknownContainer{}}
+
+	containers, err := reconcileContainers(mode)
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	r.Containers = append(r.Containers, containers...)
+	r.FinishedAt = time.Now().UTC()
+
+	reconciliation.Lock()
+	reconciliation.report = r
+	reconciliation.Unlock()
+
+	return r
+}
+
+func reconcileContainers(mode string) ([]UnknownContainer, error) {
+	cli, err := NewRuntimeClient()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer cli.Close()
+
+	list, err := cli.ContainerList(context.Background(), types.ContainerListOptions{All: true})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var out []UnknownContainer
+	for _, c := range list {
+		if len(c.Names) == 0 {
+			continue
+		}
+
+		name := strings.TrimPrefix(c.Names[0], "/")
+		m := serverContainerRegex.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+
+		u := UnknownContainer{
+			Id:        c.ID,
+			Name:      name,
+			Image:     c.Image,
+			State:     c.State,
+			Created:   time.Unix(c.Created, 0).UTC(),
+			Server:    m[1],
+			Installer: m[2] != "",
+			Action:    ContainerIgnored,
+		}
+
+		if m[3] != "" {
+			u.Action = ContainerQuarantined
+			out = append(out, u)
+			continue
+		}
+
+		// Installation containers for a known server are cleaned up by the installation
+		// process itself.
+		if GetServers().Find(func(s *Server) bool { return s.Uuid == u.Server }) != nil {
+			continue
+		}
+
+		switch {
+		case mode == ReconcileAdopt && !u.Installer:
+			if err := adoptContainer(c, u.Server); err == nil {
+				u.Action = ContainerAdopted
+			} else if IsServerDoesNotExistError(errors.Cause(err)) {
+				err = quarantineContainer(cli, &u)
+				u.Action, u.Error = reconcileResult(ContainerQuarantined, err)
+			} else {
+				u.Action, u.Error = reconcileResult(ContainerAdopted, err)
+			}
+		case mode == ReconcileAdopt || mode == ReconcileQuarantine:
+			err := quarantineContainer(cli, &u)
+			u.Action, u.Error = reconcileResult(ContainerQuarantined, err)
+		}
+
+		if u.Action != ContainerIgnored {
+			zap.S().Infow("handled container for unknown server", zap.String("server", u.Server), zap.String("container", name), zap.String("action", u.Action), zap.String("error", u.Error))
+		}
+
+		out = append(out, u)
+	}
+
+	return out, nil
+}
+
+func reconcileResult(action string, err error) (string, string) {
+	if err != nil {
+		return ContainerFailed, err.Error()
+	}
+
+	return action, ""
+}
+
+// Loads the configuration of the server the container was created for from the Panel
+// and starts managing it. If the container is running the daemon attaches to it, just
+// as it does for running servers when booting.
+func adoptContainer(c types.Container, uuid string) error {
+	cfg := &config.Get().System
+
+	s, err := FromConfiguration([]byte("uuid: "+uuid+"\n"), cfg)
+	if err != nil {
+		return err
+	}
+
+	if !cfg.SyncServersOnBoot {
+		if err := s.Sync(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := os.Stat(s.Filesystem.Path()); err != nil {
+		return errors.Wrap(err, "data directory for the server does not exist")
+	}
+
+	if _, err := s.WriteConfigurationToDisk(); err != nil {
+		return err
+	}
+
+	GetServers().Add(s)
+
+	// The server is managed from this point on, so failing to attach to the process is
+	// only logged, the server can be restarted to recover.
+	if c.State == "running" {
+		if err := s.Environment.Start(); err != nil {
+			zap.S().Warnw("failed to attach to adopted server process", zap.String("server", s.Uuid), zap.Error(err))
+		}
+
+		return nil
+	}
+
+	return s.SetState(ProcessOfflineState)
+}
+
+// Stops the container, prevents Docker from restarting it, and renames it so that it is
+// no longer mistaken for the container of a server.
+func quarantineContainer(cli *client.Client, u *UnknownContainer) error {
+	ctx := context.Background()
+
+	if u.State == "running" || u.State == "restarting" {
+		t := time.Second * 30
+		if err := cli.ContainerStop(ctx, u.Id, &t); err != nil {
+			return errors.WithStack(err)
+		}
+
+		u.State = "exited"
+	}
+
+	update := container.UpdateConfig{RestartPolicy: container.RestartPolicy{Name: "no"}}
+	if _, err := cli.ContainerUpdate(ctx, u.Id, update); err != nil {
+		return errors.WithStack(err)
+	}
+
+	name := u.Name + quarantineSuffix + strconv.FormatInt(time.Now().Unix(), 10)
+	if err := cli.ContainerRename(ctx, u.Id, name); err != nil {
+		return errors.WithStack(err)
+	}
+
+	u.Name = name
+
+	return nil
+}
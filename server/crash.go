@@ -1,10 +1,13 @@
 package server
 
 import (
+	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/hooks"
 	"go.uber.org/zap"
+	"strconv"
 	"time"
 )
 
@@ -13,8 +16,48 @@ type CrashDetection struct {
 	// can indicate that the server stopped unexpectedly.
 	Enabled bool `default:"true" json:"enabled" yaml:"enabled"`
 
+	// Exit codes that are never treated as a crash, for example a code the server
+	// process uses when it is asked to shut down by a player.
+	IgnoredExitCodes []int `json:"ignored_exit_codes" yaml:"ignored_exit_codes"`
+
+	// The number of seconds a server must run for before a crash is no longer counted
+	// against the restart limit, and the backoff between restarts is reset.
+	StableAfter int `default:"60" json:"stable_after" yaml:"stable_after"`
+
+	// The maximum number of automatic restarts allowed within the window, in seconds,
+	// before the server is considered to be in a crash loop and is no longer restarted.
+	MaxRestarts int `default:"1" json:"max_restarts" yaml:"max_restarts"`
+	Window      int `default:"60" json:"window" yaml:"window"`
+
+	// The number of seconds to wait before the first automatic restart. This doubles for
+	// every restart in a row, up to the maximum backoff. If set to 0 the server is
+	// restarted immediately.
+	Backoff    int `default:"0" json:"backoff" yaml:"backoff"`
+	MaxBackoff int `default:"300" json:"max_backoff" yaml:"max_backoff"`
+
 	// Tracks the time of the last server crash event.
 	lastCrash time.Time
+
+	// The time the server was last started, and the times of the automatic restarts
+	// performed since the server was last stable.
+	startedAt time.Time
+	restarts  []time.Time
+
+	// Tracks if the server became ready after it was last started, and the number of
+	// times in a row it has crashed without becoming ready.
+	wasReady       bool
+	unreadyCrashes int
+}
+
+// The number of times in a row a server with a readiness check can crash before becoming
+// ready until it is no longer restarted automatically.
+const maxUnreadyCrashes = 3
+
+// Details about a server entering a crash loop, sent with the crash loop event.
+type CrashLoop struct {
+	Restarts int    `json:"restarts"`
+	Window   int    `json:"window"`
+	Reason   string `json:"reason"`
 }
 
 // Looks at the environment exit state to determine if the process exited cleanly or
@@ -27,8 +70,6 @@ type CrashDetection struct {
 //
 // If the server is determined to have crashed, the process will be restarted and the
 // counter for the server will be incremented.
-//
-// @todo output event to server console
 func (s *Server) handleServerCrash() error {
 	// No point in doing anything here if the server isn't currently offline, there
 	// is no reason to do a crash detection event. If the server crash detection is
@@ -48,6 +89,12 @@ func (s *Server) handleServerCrash() error {
 		return errors.WithStack(err)
 	}
 
+	// The runtime only reports the container as killed if the main process was killed,
+	// a child process being killed can also cause the server to exit.
+	if s.oomKilledRecently() {
+		oomKilled = true
+	}
+
 	// If the system is not configured to detect a clean exit code as a crash, and the
 	// crash is not the result of the program running out of memory, do nothing.
 	if exitCode == 0 && !oomKilled && !config.Get().System.DetectCleanExitAsCrash {
@@ -56,20 +103,123 @@ func (s *Server) handleServerCrash() error {
 		return nil
 	}
 
+	for _, c := range s.CrashDetection.IgnoredExitCodes {
+		if !oomKilled && int(exitCode) == c {
+			zap.S().Debugw("server exited with an ignored exit code; not detecting as crash", zap.String("server", s.Uuid), zap.Int("code", c))
+
+			return nil
+		}
+	}
+
 	s.PublishConsoleOutputFromDaemon("---------- Detected server process in a crashed state! ----------")
 	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Exit code: %d", exitCode))
 	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Out of memory: %t", oomKilled))
 
-	c := s.CrashDetection.lastCrash
-	// If the last crash time was within the last 60 seconds we do not want to perform
-	// an automatic reboot of the process. Return an error that can be handled.
-	if !c.IsZero() && c.Add(time.Second * 60).After(time.Now()) {
-		s.PublishConsoleOutputFromDaemon("Aborting automatic reboot: last crash occurred less than 60 seconds ago.")
+	s.fireHook(hooks.PostCrash, map[string]string{
+		"exit_code":  strconv.Itoa(int(exitCode)),
+		"oom_killed": strconv.FormatBool(oomKilled),
+	})
+
+	if oomKilled && s.applyOomPolicy() {
+		return nil
+	}
+
+	cd := &s.CrashDetection
+	now := time.Now()
+
+	// A server that ran for long enough before crashing is not in a loop, so start
+	// counting restarts and backing off from scratch.
+	stableAfter := time.Duration(cd.StableAfter) * time.Second
+	if !cd.startedAt.IsZero() && now.Sub(cd.startedAt) >= stableAfter {
+		cd.restarts = nil
+	}
+
+	// A server that keeps crashing before it is ready to accept players is stuck in a
+	// restart loop, even if each crash is more than a minute apart.
+	if s.hasReadinessCheck() && !cd.wasReady {
+		cd.unreadyCrashes++
+	} else {
+		cd.unreadyCrashes = 0
+	}
+
+	if cd.unreadyCrashes >= maxUnreadyCrashes {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Aborting automatic reboot: server crashed %d times in a row without becoming ready.", cd.unreadyCrashes))
+
+		s.enterCrashLoop(CrashLoop{Restarts: cd.unreadyCrashes, Reason: "server did not become ready"})
+		cd.unreadyCrashes = 0
 
 		return &crashTooFrequent{}
 	}
 
-	s.CrashDetection.lastCrash = time.Now()
+	window := time.Duration(cd.Window) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+
+	var recent []time.Time
+	for _, t := range cd.restarts {
+		if now.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+
+	max := cd.MaxRestarts
+	if max <= 0 {
+		max = 1
+	}
+
+	if len(recent) >= max {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Aborting automatic reboot: server restarted %d times in the last %s.", len(recent), window))
+
+		s.enterCrashLoop(CrashLoop{Restarts: len(recent), Window: int(window.Seconds()), Reason: "too many restarts"})
+
+		return &crashTooFrequent{}
+	}
+
+	if delay := cd.backoff(len(cd.restarts)); delay > 0 {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Restarting server in %s...", delay))
+		time.Sleep(delay)
+
+		// The server may have been started, or deleted, while waiting.
+		if s.State != ProcessOfflineState {
+			return nil
+		}
+	}
+
+	cd.lastCrash = now
+	cd.restarts = append(recent, time.Now())
 
 	return s.Environment.Start()
-}
\ No newline at end of file
+}
+
+// Returns the time to wait before automatically restarting the server, given the number
+// of times it has already been restarted since it was last stable.
+func (cd *CrashDetection) backoff(restarts int) time.Duration {
+	if cd.Backoff <= 0 {
+		return 0
+	}
+
+	d := time.Duration(cd.Backoff) * time.Second
+	for i := 0; i < restarts; i++ {
+		d *= 2
+		if cd.MaxBackoff > 0 && d > time.Duration(cd.MaxBackoff)*time.Second {
+			return time.Duration(cd.MaxBackoff) * time.Second
+		}
+	}
+
+	return d
+}
+
+// Marks the server as being in a crash loop and notifies any listeners. The server stays
+// in the crash loop state until it is next started.
+func (s *Server) enterCrashLoop(c CrashLoop) {
+	s.CrashDetection.restarts = nil
+	s.CrashLooping = true
+
+	zap.S().Warnw("server entered a crash loop and will not be restarted automatically", zap.String("server", s.Uuid), zap.String("reason", c.Reason), zap.Int("restarts", c.Restarts))
+
+	b, _ := json.Marshal(c)
+	s.Events().Publish(CrashLoopEvent, string(b))
+
+	s.RecordStopReason(StopReasonCrashLoop, c.Reason)
+}
@@ -0,0 +1,616 @@
+package server
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The categories of the entries recorded by a debug capture.
+const (
+	DebugCategoryLog       = "log"
+	DebugCategoryDocker    = "docker"
+	DebugCategoryParser    = "parser"
+	DebugCategoryWebsocket = "websocket"
+)
+
+// Returned when a debug capture is started for a server that already has one running.
+var ErrDebugCaptureRunning = errors.New("a debug capture is already running for this server")
+
+// Details about a debug capture for a server, which records verbose details about the
+// server for a short time and is then written to a bundle that can be downloaded.
+type DebugCapture struct {
+	Id         string     `json:"id"`
+	Server     string     `json:"server"`
+	StartedAt  time.Time  `json:"started_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	// The number of entries recorded, and the number dropped once the capture reached
+	// its size limit.
+	Entries int64 `json:"entries"`
+	Dropped int64 `json:"dropped"`
+	Size    int64 `json:"size"`
+	// The name of the bundle created once the capture finished.
+	Bundle string `json:"bundle,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// A bundle created from a finished debug capture.
+type DebugBundle struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// A single entry recorded by a debug capture.
+type debugEntry struct {
+	Time     time.Time              `json:"time"`
+	Category string                 `json:"category"`
+	Message  string                 `json:"message"`
+	Fields   map[string]interface{} `json:"fields,omitempty"`
+}
+
+// A running debug capture.
+type debugCapture struct {
+	mu       sync.Mutex
+	details  DebugCapture
+	f        *os.File
+	counters map[string]*debugCounter
+	// Cancels the routines recording events for the capture.
+	cancel context.CancelFunc
+}
+
+// The number of times something happened during a capture, used for high volume events
+// that are not worth recording individually, such as console output.
+type debugCounter struct {
+	Count int64 `json:"count"`
+	Bytes int64 `json:"bytes"`
+}
+
+var debugCaptures = struct {
+	sync.Mutex
+	active   map[string]*debugCapture
+	finished map[string]DebugCapture
+	// The number of active captures, checked before anything else so that recording is
+	// free when no captures are running.
+	count int32
+}{active: make(map[string]*debugCapture), finished: make(map[string]DebugCapture)}
+
+// Returns the running debug capture for the server, or nil if there is not one.
+func activeDebugCapture(uuid string) *debugCapture {
+	if atomic.LoadInt32(&debugCaptures.count) == 0 {
+		return nil
+	}
+
+	debugCaptures.Lock()
+	defer debugCaptures.Unlock()
+
+	return debugCaptures.active[uuid]
+}
+
+// Starts a debug capture for the server that runs for the given duration, or the default
+// duration if it is zero. While the capture is running every daemon log entry for the
+// server is recorded regardless of the log level, along with the Docker events for the
+// container, the configuration files that are parsed and the websocket traffic, without
+// the contents of the messages.
+func (s *Server) StartDebugCapture(d time.Duration) (*DebugCapture, error) {
+	cfg := config.Get().System.DebugCaptures
+
+	if d == 0 {
+		d = time.Duration(cfg.DefaultDuration) * time.Minute
+	}
+
+	if d < 0 || (cfg.MaxDuration > 0 && d > time.Duration(cfg.MaxDuration)*time.Minute) {
+		return nil, errors.Errorf("debug captures must run for between 1 and %d minutes", cfg.MaxDuration)
+	}
+
+	if err := os.MkdirAll(cfg.Directory, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	debugCaptures.Lock()
+	if _, ok := debugCaptures.active[s.Uuid]; ok {
+		debugCaptures.Unlock()
+		return nil, ErrDebugCaptureRunning
+	}
+
+	// Remove any capture left behind by the daemon stopping while it was running.
+	if stale, err := filepath.Glob(filepath.Join(cfg.Directory, s.Uuid+".*.ndjson")); err == nil {
+		for _, p := range stale {
+			os.Remove(p)
+		}
+	}
+
+	id := uuid.New().String()
+	f, err := os.OpenFile(filepath.Join(cfg.Directory, s.Uuid+"."+id+".ndjson"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		debugCaptures.Unlock()
+		return nil, errors.WithStack(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+
+	c := &debugCapture{
+		details: DebugCapture{
+			Id:        id,
+			Server:    s.Uuid,
+			StartedAt: time.Now().UTC(),
+			ExpiresAt: time.Now().Add(d).UTC(),
+		},
+		f:        f,
+		counters: make(map[string]*debugCounter),
+		cancel:   cancel,
+	}
+
+	debugCaptures.active[s.Uuid] = c
+	atomic.AddInt32(&debugCaptures.count, 1)
+	debugCaptures.Unlock()
+
+	zap.S().Infow("started debug capture for server", zap.String("server", s.Uuid), zap.String("capture", id), zap.Duration("duration", d))
+
+	go s.watchDockerEvents(ctx, c)
+
+	go func() {
+		defer supervisor.Recover("debug capture")
+
+		<-ctx.Done()
+
+		// The capture is only finished here if it expired, stopping it early cancels the
+		// context after it has already been finished.
+		if ctx.Err() == context.DeadlineExceeded {
+			if _, err := s.StopDebugCapture(); err != nil && err != os.ErrNotExist {
+				zap.S().Warnw("failed to finish debug capture for server", zap.String("server", s.Uuid), zap.Error(err))
+			}
+		}
+	}()
+
+	return c.snapshot(), nil
+}
+
+// Stops the running debug capture for the server and writes everything it recorded to a
+// bundle, returning the details of the finished capture. If there is no capture running
+// os.ErrNotExist is returned.
+func (s *Server) StopDebugCapture() (*DebugCapture, error) {
+	debugCaptures.Lock()
+	c, ok := debugCaptures.active[s.Uuid]
+	if ok {
+		delete(debugCaptures.active, s.Uuid)
+		atomic.AddInt32(&debugCaptures.count, -1)
+	}
+	debugCaptures.Unlock()
+
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+
+	c.cancel()
+
+	// Nothing is recorded once the capture is finished, so the details and counters can
+	// be read without holding the lock from here on.
+	c.mu.Lock()
+	c.f.Close()
+	now := time.Now().UTC()
+	c.details.FinishedAt = &now
+	c.mu.Unlock()
+
+	bundle, err := s.writeDebugBundle(c)
+	if err != nil {
+		c.details.Error = err.Error()
+	} else {
+		c.details.Bundle = bundle
+	}
+
+	os.Remove(c.f.Name())
+	s.pruneDebugBundles()
+
+	debugCaptures.Lock()
+	debugCaptures.finished[s.Uuid] = c.details
+	debugCaptures.Unlock()
+
+	zap.S().Infow("finished debug capture for server", zap.String("server", s.Uuid), zap.String("capture", c.details.Id), zap.String("bundle", bundle))
+
+	return c.snapshot(), errors.WithStack(err)
+}
+
+// Returns the running debug capture for the server, or the last capture that finished
+// since the daemon booted if there is not one running.
+func (s *Server) CurrentDebugCapture() *DebugCapture {
+	debugCaptures.Lock()
+	c, ok := debugCaptures.active[s.Uuid]
+	finished, done := debugCaptures.finished[s.Uuid]
+	debugCaptures.Unlock()
+
+	if ok {
+		return c.snapshot()
+	} else if done {
+		return &finished
+	}
+
+	return nil
+}
+
+// Returns a copy of the details of the capture.
+func (c *debugCapture) snapshot() *DebugCapture {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	d := c.details
+
+	return &d
+}
+
+// Records an entry in the running debug capture for the server, if there is one.
+func (s *Server) DebugTrace(category string, message string, fields map[string]interface{}) {
+	if c := activeDebugCapture(s.Uuid); c != nil {
+		c.record(category, message, fields)
+	}
+}
+
+// Counts an occurrence of a high volume event in the running debug capture for the
+// server, if there is one.
+func (s *Server) DebugCount(name string, bytes int) {
+	c := activeDebugCapture(s.Uuid)
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.details.FinishedAt != nil {
+		return
+	}
+
+	if _, ok := c.counters[name]; !ok {
+		c.counters[name] = &debugCounter{}
+	}
+
+	c.counters[name].Count++
+	c.counters[name].Bytes += int64(bytes)
+}
+
+// Writes an entry to the capture, dropping it if the capture has reached its size limit.
+func (c *debugCapture) record(category string, message string, fields map[string]interface{}) {
+	b, err := json.Marshal(debugEntry{Time: time.Now().UTC(), Category: category, Message: message, Fields: fields})
+	if err != nil {
+		return
+	}
+	b = append(b, '\n')
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.details.FinishedAt != nil {
+		return
+	}
+
+	if max := config.Get().System.DebugCaptures.MaxSize * 1000 * 1000; max > 0 && c.details.Size+int64(len(b)) > max {
+		c.details.Dropped++
+		return
+	}
+
+	if _, err := c.f.Write(b); err != nil {
+		c.details.Dropped++
+		return
+	}
+
+	c.details.Entries++
+	c.details.Size += int64(len(b))
+}
+
+// Records the Docker events for the container of the server until the context is done.
+func (s *Server) watchDockerEvents(ctx context.Context, c *debugCapture) {
+	defer supervisor.Recover("debug capture")
+
+	cli, err := NewRuntimeClient()
+	if err != nil {
+		c.record(DebugCategoryDocker, "failed to connect to docker", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	defer cli.Close()
+
+	messages, errs := cli.Events(ctx, types.EventsOptions{
+		Filters: filters.NewArgs(filters.Arg("container", s.Uuid)),
+	})
+
+	for {
+		select {
+		case m := <-messages:
+			c.record(DebugCategoryDocker, m.Action, map[string]interface{}{
+				"type":       m.Type,
+				"attributes": m.Actor.Attributes,
+			})
+		case err := <-errs:
+			if ctx.Err() == nil && err != nil {
+				c.record(DebugCategoryDocker, "event stream closed", map[string]interface{}{"error": err.Error()})
+			}
+
+			return
+		}
+	}
+}
+
+// Writes the entries recorded by the capture to a bundle, along with the configuration of
+// the server and its container. The values of environment variables, configuration file
+// replacements and the startup command are redacted since they can contain secrets.
+func (s *Server) writeDebugBundle(c *debugCapture) (string, error) {
+	name := s.Uuid + "-" + c.details.StartedAt.Format("20060102T150405") + "-" + c.details.Id[:8] + ".tar.gz"
+	p := filepath.Join(config.Get().System.DebugCaptures.Directory, name)
+
+	f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+
+	files := map[string]interface{}{
+		"capture.json":  c.details,
+		"counters.json": c.counters,
+		"server.json":   s.redactedDetails(),
+	}
+
+	if s.processConfiguration != nil {
+		files["process_configuration.json"] = redactProcessConfiguration(*s.processConfiguration)
+	}
+
+	if d, ok := s.Environment.(*DockerEnvironment); ok {
+		if ci, err := d.Client.ContainerInspect(context.Background(), s.Uuid); err == nil {
+			if ci.Config != nil {
+				for i, e := range ci.Config.Env {
+					ci.Config.Env[i] = strings.SplitN(e, "=", 2)[0] + "=<redacted>"
+				}
+
+				for i := range ci.Config.Cmd {
+					ci.Config.Cmd[i] = "<redacted>"
+				}
+			}
+
+			// The arguments the container was started with are the same command.
+			for i := range ci.Args {
+				ci.Args[i] = "<redacted>"
+			}
+
+			files["container.json"] = ci
+		}
+	}
+
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+
+	for _, n := range names {
+		b, err := json.MarshalIndent(files[n], "", "  ")
+		if err != nil {
+			b = []byte(err.Error())
+		}
+
+		if err := addBundleFile(tw, n, b); err != nil {
+			os.Remove(p)
+			return "", err
+		}
+	}
+
+	entries, err := ioutil.ReadFile(c.f.Name())
+	if err == nil {
+		err = addBundleFile(tw, "entries.ndjson", entries)
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+
+	if err == nil {
+		err = gw.Close()
+	}
+
+	if err != nil {
+		os.Remove(p)
+		return "", errors.WithStack(err)
+	}
+
+	return name, nil
+}
+
+// Returns the details of the server with the values of its environment variables and its
+// startup command removed, since the command has the variables substituted into it.
+func (s *Server) redactedDetails() map[string]interface{} {
+	out := make(map[string]interface{})
+
+	b, err := json.Marshal(s)
+	if err != nil {
+		return out
+	}
+	json.Unmarshal(b, &out)
+
+	if env, ok := out["environment"].(map[string]interface{}); ok {
+		for k := range env {
+			env[k] = "<redacted>"
+		}
+	}
+
+	if _, ok := out["invocation"]; ok {
+		out["invocation"] = "<redacted>"
+	}
+
+	return out
+}
+
+// Returns a copy of the process configuration with the values written to configuration
+// files removed.
+func redactProcessConfiguration(pc api.ProcessConfiguration) api.ProcessConfiguration {
+	files := make([]parser.ConfigurationFile, len(pc.ConfigurationFiles))
+	for i, f := range pc.ConfigurationFiles {
+		replace := make([]parser.ConfigurationFileReplacement, len(f.Replace))
+		for j, r := range f.Replace {
+			r.Value = "<redacted>"
+			replace[j] = r
+		}
+
+		f.Replace = replace
+		files[i] = f
+	}
+
+	pc.ConfigurationFiles = files
+
+	return pc
+}
+
+// Adds a file with the given contents to a bundle.
+func addBundleFile(tw *tar.Writer, name string, b []byte) error {
+	h := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(b)),
+		ModTime: time.Now(),
+	}
+
+	if err := tw.WriteHeader(h); err != nil {
+		return errors.WithStack(err)
+	}
+
+	_, err := tw.Write(b)
+
+	return errors.WithStack(err)
+}
+
+// Returns the bundles created for the server, newest first.
+func (s *Server) DebugBundles() ([]DebugBundle, error) {
+	matches, err := filepath.Glob(filepath.Join(config.Get().System.DebugCaptures.Directory, s.Uuid+"-*.tar.gz"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// The names of the bundles begin with the time the capture started, so sorting them
+	// also sorts them from oldest to newest.
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+
+	out := []DebugBundle{}
+	for _, p := range matches {
+		st, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+
+		out = append(out, DebugBundle{Name: filepath.Base(p), Size: st.Size(), CreatedAt: st.ModTime().UTC()})
+	}
+
+	return out, nil
+}
+
+// Returns the path to a bundle created for the server. If the bundle does not exist, or
+// belongs to a different server, os.ErrNotExist is returned.
+func (s *Server) DebugBundlePath(name string) (string, error) {
+	if !strings.HasPrefix(name, s.Uuid+"-") || !strings.HasSuffix(name, ".tar.gz") || strings.ContainsAny(name, "/\\") {
+		return "", os.ErrNotExist
+	}
+
+	p := filepath.Join(config.Get().System.DebugCaptures.Directory, name)
+	if _, err := os.Stat(p); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// Removes the oldest bundles for the server once there are more than the maximum.
+func (s *Server) pruneDebugBundles() {
+	max := config.Get().System.DebugCaptures.MaxBundles
+	if max <= 0 {
+		return
+	}
+
+	bundles, err := s.DebugBundles()
+	if err != nil {
+		return
+	}
+
+	dir := config.Get().System.DebugCaptures.Directory
+	for i := max; i < len(bundles); i++ {
+		os.Remove(filepath.Join(dir, bundles[i].Name))
+	}
+}
+
+// A logging core that records every entry for a server with a running debug capture,
+// regardless of the level the daemon is logging at. Entries are matched to a server
+// using the "server" field that is included with them.
+type debugLogCore struct {
+	fields []zapcore.Field
+}
+
+// Returns the logging core that records entries for servers with a running debug
+// capture. This should be added alongside the other cores of the daemon logger.
+func DebugLogCore() zapcore.Core {
+	return &debugLogCore{}
+}
+
+func (c *debugLogCore) Enabled(zapcore.Level) bool {
+	return atomic.LoadInt32(&debugCaptures.count) > 0
+}
+
+func (c *debugLogCore) With(fields []zapcore.Field) zapcore.Core {
+	return &debugLogCore{fields: append(append([]zapcore.Field{}, c.fields...), fields...)}
+}
+
+func (c *debugLogCore) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(e.Level) {
+		return ce.AddCore(e, c)
+	}
+
+	return ce
+}
+
+func (c *debugLogCore) Write(e zapcore.Entry, fields []zapcore.Field) error {
+	enc := zapcore.NewMapObjectEncoder()
+	for _, f := range c.fields {
+		f.AddTo(enc)
+	}
+
+	for _, f := range fields {
+		f.AddTo(enc)
+	}
+
+	id, _ := enc.Fields["server"].(string)
+	if id == "" {
+		return nil
+	}
+
+	if dc := activeDebugCapture(id); dc != nil {
+		delete(enc.Fields, "server")
+		enc.Fields["level"] = e.Level.String()
+		if e.Caller.Defined {
+			enc.Fields["caller"] = e.Caller.TrimmedPath()
+		}
+
+		dc.record(DebugCategoryLog, e.Message, enc.Fields)
+	}
+
+	return nil
+}
+
+func (c *debugLogCore) Sync() error {
+	return nil
+}
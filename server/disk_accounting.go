@@ -0,0 +1,205 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"sync"
+)
+
+// The methods that can be used to track the disk space used by a server.
+const (
+	DiskAccountingAuto    = "auto"
+	DiskAccountingProject = "project"
+	DiskAccountingWatch   = "watch"
+	DiskAccountingScan    = "scan"
+)
+
+// Tracks the disk space used by the files of a server as they change, so that the usage
+// is always current without walking every file of the server.
+type diskAccountant interface {
+	// Returns the method used by the accountant.
+	Mode() string
+
+	// Returns the number of bytes used by the files of the server.
+	Usage() (int64, error)
+
+	// Sets the number of bytes the server is allowed to use, 0 allowing unlimited space.
+	SetLimit(limit int64) error
+
+	// Stops tracking the files of the server.
+	Close() error
+}
+
+// The accountant used to track the disk space of a server, started the first time the
+// usage of the server is needed.
+type diskAccounting struct {
+	sync.Mutex
+
+	accountant diskAccountant
+	// The directory the accountant is tracking, a different accountant is started if the
+	// data of the server is moved.
+	root string
+	// The limit last applied to the accountant.
+	limit int64
+	// Set if an accountant could not be used for the directory, in which case the files
+	// of the server are scanned instead.
+	failed bool
+	// Set once the server has been stopped for using too much space, so that it is only
+	// stopped once while it is running over the limit.
+	exceeded bool
+}
+
+// Returns the method used to track the disk space of the server.
+func (fs *Filesystem) DiskAccountingMode() string {
+	if a := fs.diskAccountant(); a != nil {
+		return a.Mode()
+	}
+
+	return DiskAccountingScan
+}
+
+// Returns the accountant tracking the disk space of the server, starting one if needed.
+// Nil is returned if the files of the server should be scanned instead.
+func (fs *Filesystem) diskAccountant() diskAccountant {
+	d := &fs.Server.diskAccounting
+
+	d.Lock()
+	defer d.Unlock()
+
+	root := fs.Path()
+	if d.root != root {
+		d.close(fs.Server.Uuid)
+		d.root = root
+	}
+
+	if d.accountant == nil && !d.failed {
+		mode := fs.Configuration.DiskAccounting.Mode
+		if mode == "" {
+			mode = DiskAccountingAuto
+		}
+
+		if mode == DiskAccountingScan {
+			d.failed = true
+			return nil
+		}
+
+		a, err := newDiskAccountant(mode, root, fs.Server.Uuid, fs.diskUsageChanged)
+		if err != nil {
+			zap.S().Warnw("failed to start disk accounting, falling back to scanning the server files", zap.String("server", fs.Server.Uuid), zap.String("mode", mode), zap.Error(err))
+
+			d.failed = true
+			return nil
+		}
+
+		zap.S().Debugw("started disk accounting for server", zap.String("server", fs.Server.Uuid), zap.String("mode", a.Mode()))
+
+		d.accountant = a
+		d.limit = -1
+	}
+
+	if d.accountant == nil {
+		return nil
+	}
+
+	var limit int64
+	if fs.Server.Build.DiskSpace > 0 {
+		limit = fs.Server.Build.DiskSpace * 1000 * 1000
+	}
+
+	if limit != d.limit {
+		if err := d.accountant.SetLimit(limit); err != nil {
+			zap.S().Warnw("failed to apply disk limit to server", zap.String("server", fs.Server.Uuid), zap.Error(err))
+		} else {
+			d.limit = limit
+		}
+	}
+
+	return d.accountant
+}
+
+// Returns the disk space used by the server from the accountant. False is returned if
+// the server has no accountant, or if it can no longer be trusted, in which case the
+// files of the server are scanned instead.
+func (fs *Filesystem) accountedDiskUsage() (int64, bool) {
+	a := fs.diskAccountant()
+	if a == nil {
+		return 0, false
+	}
+
+	size, err := a.Usage()
+	if err != nil {
+		zap.S().Warnw("disk accounting failed, falling back to scanning the server files", zap.String("server", fs.Server.Uuid), zap.String("mode", a.Mode()), zap.Error(err))
+
+		d := &fs.Server.diskAccounting
+		d.Lock()
+		if d.accountant == a {
+			d.close(fs.Server.Uuid)
+			d.failed = true
+		}
+		d.Unlock()
+
+		return 0, false
+	}
+
+	return size, true
+}
+
+// Stops tracking the disk space of the server, such as when it is deleted.
+func (fs *Filesystem) StopDiskAccounting() {
+	d := &fs.Server.diskAccounting
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.close(fs.Server.Uuid)
+	d.root = ""
+}
+
+func (d *diskAccounting) close(uuid string) {
+	if d.accountant != nil {
+		if err := d.accountant.Close(); err != nil {
+			zap.S().Warnw("failed to stop disk accounting for server", zap.String("server", uuid), zap.Error(err))
+		}
+	}
+
+	d.accountant = nil
+	d.failed = false
+	d.exceeded = false
+}
+
+// Called by the accountant whenever the disk space used by the server changes, which
+// stops the server as soon as it goes over its limit rather than the next time the
+// usage is checked.
+func (fs *Filesystem) diskUsageChanged(size int64) {
+	size += fs.trashSize() + fs.snapshotsSize()
+	fs.Server.Resources.Disk = size
+
+	limit := fs.Server.Build.DiskSpace * 1000 * 1000
+	over := limit > 0 && size > limit
+	running := fs.Server.State != ProcessOfflineState
+
+	d := &fs.Server.diskAccounting
+	d.Lock()
+	if !over || !running {
+		d.exceeded = false
+	}
+	stop := over && running && !d.exceeded
+	if stop {
+		d.exceeded = true
+	}
+	d.Unlock()
+
+	if !stop || !fs.Configuration.DiskAccounting.StopOnExceed {
+		return
+	}
+
+	zap.S().Infow("stopping server for exceeding its disk limit", zap.String("server", fs.Server.Uuid), zap.Int64("used", size), zap.Int64("limit", limit))
+
+	fs.Server.PublishConsoleOutputFromDaemon("Server is exceeding the assigned disk space limit, stopping process now...")
+
+	fs.Server.SetStopReason(StopReasonDiskQuota, fmt.Sprintf("the server used %d MB of its %d MB disk limit", size/1000/1000, fs.Server.Build.DiskSpace))
+	if err := fs.Server.Environment.Stop(); err != nil {
+		zap.S().Errorw("failed to stop server exceeding its disk limit", zap.String("server", fs.Server.Uuid), zap.Error(errors.WithStack(err)))
+	}
+}
@@ -0,0 +1,651 @@
+package server
+
+import (
+	"bufio"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// Starts tracking the disk space used by the files in the root directory, using the
+// requested method. In auto mode project quotas are used if the filesystem supports them,
+// otherwise the files are watched for changes.
+func newDiskAccountant(mode string, root string, uuid string, onChange func(int64)) (diskAccountant, error) {
+	switch mode {
+	case DiskAccountingProject:
+		return newProjectAccountant(root, uuid)
+	case DiskAccountingWatch:
+		return newWatchAccountant(root, onChange)
+	case DiskAccountingAuto:
+		a, err := newProjectAccountant(root, uuid)
+		if err == nil {
+			return a, nil
+		}
+
+		zap.S().Debugw("project quotas are not available for server, watching files instead", zap.String("server", uuid), zap.Error(err))
+
+		return newWatchAccountant(root, onChange)
+	}
+
+	return nil, errors.Errorf("unknown disk accounting mode \"%s\"", mode)
+}
+
+// The magic numbers of the filesystems that support project quotas.
+const (
+	xfsSuperMagic  = 0x58465342
+	ext4SuperMagic = 0xef53
+)
+
+// The ioctls used to read and set the project of a file.
+const (
+	fsIocFsGetXattr    = 0x801c581f
+	fsIocFsSetXattr    = 0x401c5820
+	fsXflagProjInherit = 0x00000200
+)
+
+// The quotactl commands and flags used to read and limit the space used by a project.
+const (
+	qXGetQuota = 0x5803
+	qXSetQLim  = 0x5804
+	qXGetQStat = 0x5805
+	prjQuota   = 2
+
+	fsDquotVersion = 1
+	fsProjQuota    = 1 << 1
+	fsDqBSoft      = 1 << 2
+	fsDqBHard      = 1 << 3
+
+	fsQuotaPdqAcct = 1 << 4
+	fsQuotaPdqEnfd = 1 << 5
+)
+
+// Matches struct fsxattr from linux/fs.h.
+type fsxattr struct {
+	Xflags     uint32
+	Extsize    uint32
+	Nextents   uint32
+	Projid     uint32
+	Cowextsize uint32
+	_          [8]byte
+}
+
+// Matches struct fs_disk_quota from linux/dqblk_xfs.h.
+type fsDiskQuota struct {
+	Version      int8
+	Flags        int8
+	Fieldmask    uint16
+	Id           uint32
+	BlkHardlimit uint64
+	BlkSoftlimit uint64
+	InoHardlimit uint64
+	InoSoftlimit uint64
+	Bcount       uint64
+	Icount       uint64
+	Itimer       int32
+	Btimer       int32
+	Iwarns       uint16
+	Bwarns       uint16
+	_            [4]int8
+	RtbHardlimit uint64
+	RtbSoftlimit uint64
+	Rtbcount     uint64
+	Rtbtimer     int32
+	Rtbwarns     uint16
+	_            int16
+	_            [8]byte
+}
+
+// The start of struct fs_quota_stat from linux/dqblk_xfs.h, padded past the end of the
+// structure since only the flags are needed.
+type fsQuotaStat struct {
+	Version int8
+	_       int8
+	Flags   uint16
+	_       [124]byte
+}
+
+// Tracks the disk space used by a server with the project quotas of the filesystem. Every
+// file of the server belongs to a project, and the filesystem itself keeps count of the
+// space used by the project and refuses writes once the project is out of space.
+type projectAccountant struct {
+	device string
+	id     uint32
+}
+
+func newProjectAccountant(root string, uuid string) (*projectAccountant, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(root, &st); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if st.Type != xfsSuperMagic && st.Type != ext4SuperMagic {
+		return nil, errors.New("the filesystem does not support project quotas")
+	}
+
+	device, err := mountSource(root)
+	if err != nil {
+		return nil, err
+	}
+
+	var qs fsQuotaStat
+	if err := quotactl(qXGetQStat, device, 0, unsafe.Pointer(&qs)); err != nil {
+		return nil, errors.Wrap(err, "failed to read quota state of filesystem")
+	}
+
+	if qs.Flags&fsQuotaPdqAcct == 0 || qs.Flags&fsQuotaPdqEnfd == 0 {
+		return nil, errors.New("project quotas are not enabled on the filesystem")
+	}
+
+	a := &projectAccountant{device: device, id: projectId(uuid)}
+	if err := a.assign(root); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Returns the project used for the files of a server. Projects are derived from the uuid
+// of the server so that they are the same every time the daemon is started.
+func projectId(uuid string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(uuid))
+
+	if id := h.Sum32() & 0x7fffffff; id != 0 {
+		return id
+	}
+
+	return 1
+}
+
+// Assigns every file in the root directory to the project. Directories are marked so
+// that any files created in them are assigned to the project as well, which means this
+// only needs to walk the files the first time the server is assigned to the project.
+func (a *projectAccountant) assign(root string) error {
+	if id, err := fileProject(root); err == nil && id == a.id {
+		return nil
+	}
+
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// The root directory is assigned last, so that the files are walked again if the
+		// daemon stops before they have all been assigned.
+		if p == root || (!info.IsDir() && !info.Mode().IsRegular()) {
+			return nil
+		}
+
+		return setFileProject(p, a.id)
+	})
+	if err != nil {
+		return err
+	}
+
+	return setFileProject(root, a.id)
+}
+
+func (a *projectAccountant) Mode() string {
+	return DiskAccountingProject
+}
+
+func (a *projectAccountant) Usage() (int64, error) {
+	var q fsDiskQuota
+	if err := quotactl(qXGetQuota, a.device, a.id, unsafe.Pointer(&q)); err != nil {
+		// A project that has never used any space and has no limit does not exist yet.
+		if err == syscall.ENOENT {
+			return 0, nil
+		}
+
+		return 0, errors.Wrap(err, "failed to read project quota")
+	}
+
+	return int64(q.Bcount) * 512, nil
+}
+
+func (a *projectAccountant) SetLimit(limit int64) error {
+	blocks := uint64(limit+511) / 512
+
+	q := fsDiskQuota{
+		Version:      fsDquotVersion,
+		Flags:        fsProjQuota,
+		Fieldmask:    fsDqBSoft | fsDqBHard,
+		Id:           a.id,
+		BlkHardlimit: blocks,
+		BlkSoftlimit: blocks,
+	}
+
+	if err := quotactl(qXSetQLim, a.device, a.id, unsafe.Pointer(&q)); err != nil {
+		return errors.Wrap(err, "failed to set project quota")
+	}
+
+	return nil
+}
+
+func (a *projectAccountant) Close() error {
+	return nil
+}
+
+func quotactl(cmd int, device string, id uint32, addr unsafe.Pointer) error {
+	p, err := syscall.BytePtrFromString(device)
+	if err != nil {
+		return err
+	}
+
+	if _, _, errno := syscall.Syscall6(syscall.SYS_QUOTACTL, uintptr(cmd<<8|prjQuota), uintptr(unsafe.Pointer(p)), uintptr(id), uintptr(addr), 0, 0); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// Returns the project a file belongs to.
+func fileProject(p string) (uint32, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var x fsxattr
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&x))); errno != 0 {
+		return 0, errno
+	}
+
+	return x.Projid, nil
+}
+
+// Assigns a file to the project. Directories are also marked so that files created in
+// them are assigned to the same project.
+func setFileProject(p string, id uint32) error {
+	f, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		// Files can be removed while they are being assigned.
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	var x fsxattr
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFsGetXattr, uintptr(unsafe.Pointer(&x))); errno != 0 {
+		return errors.Wrapf(errno, "failed to read project of %s", p)
+	}
+
+	flags := x.Xflags
+	if st, err := f.Stat(); err == nil && st.IsDir() {
+		flags |= fsXflagProjInherit
+	}
+
+	if x.Projid == id && x.Xflags == flags {
+		return nil
+	}
+
+	x.Projid = id
+	x.Xflags = flags
+
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocFsSetXattr, uintptr(unsafe.Pointer(&x))); errno != 0 {
+		return errors.Wrapf(errno, "failed to set project of %s", p)
+	}
+
+	return nil
+}
+
+// Returns the device of the filesystem the path is stored on, which is the source of the
+// closest mount containing the path.
+func mountSource(p string) (string, error) {
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	var point, source string
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// The fields of each mount are followed by a separator, the type of the filesystem
+		// and the source of the mount.
+		fields := strings.Fields(scanner.Text())
+
+		sep := -1
+		for i, v := range fields {
+			if v == "-" {
+				sep = i
+				break
+			}
+		}
+
+		if sep < 5 || len(fields) < sep+3 {
+			continue
+		}
+
+		mp := unescapeMountField(fields[4])
+		if mp != "/" && p != mp && !strings.HasPrefix(p, mp+"/") {
+			continue
+		}
+
+		if len(mp) >= len(point) {
+			point, source = mp, unescapeMountField(fields[sep+2])
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if !strings.HasPrefix(source, "/") {
+		return "", errors.Errorf("could not determine the device that %s is stored on", p)
+	}
+
+	return source, nil
+}
+
+// Replaces the octal escapes used for spaces and other characters in the mount table.
+func unescapeMountField(v string) string {
+	if !strings.Contains(v, "\\") {
+		return v
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(v); i++ {
+		if v[i] == '\\' && i+3 < len(v) {
+			if c, err := strconv.ParseUint(v[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+
+		b.WriteByte(v[i])
+	}
+
+	return b.String()
+}
+
+// The events watched for on each directory of a server.
+const watchMask = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_ONLYDIR | syscall.IN_DONT_FOLLOW
+
+// A directory being watched, along with the size of each file directly within it.
+type watchedDirectory struct {
+	path  string
+	files map[string]int64
+}
+
+// Tracks the disk space used by a server by walking its files once and then watching every
+// directory for changes, adjusting the usage as each file changes.
+type watchAccountant struct {
+	mu sync.Mutex
+
+	file     *os.File
+	fd       int
+	root     string
+	dirs     map[int32]*watchedDirectory
+	paths    map[string]int32
+	total    int64
+	onChange func(int64)
+	// Set once the usage can no longer be trusted, such as when the limit on the number
+	// of watches is reached.
+	err    error
+	closed bool
+}
+
+func newWatchAccountant(root string, onChange func(int64)) (*watchAccountant, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create inotify instance")
+	}
+
+	w := &watchAccountant{
+		file:     os.NewFile(uintptr(fd), "inotify"),
+		fd:       fd,
+		root:     root,
+		dirs:     make(map[int32]*watchedDirectory),
+		paths:    make(map[string]int32),
+		onChange: onChange,
+	}
+
+	if err := w.addTree(root); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+
+	go w.run()
+
+	return w, nil
+}
+
+func (w *watchAccountant) Mode() string {
+	return DiskAccountingWatch
+}
+
+func (w *watchAccountant) Usage() (int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	return w.total, nil
+}
+
+// The limit is enforced by the server when it is notified of a change in usage, since
+// watching the files cannot prevent them from being written.
+func (w *watchAccountant) SetLimit(limit int64) error {
+	return nil
+}
+
+func (w *watchAccountant) Close() error {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+
+	return w.file.Close()
+}
+
+// Reads the events for the watched directories until the accountant is closed, notifying
+// the server each time the usage changes.
+func (w *watchAccountant) run() {
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			w.mu.Lock()
+			if !w.closed {
+				w.err = errors.Wrap(err, "failed to read filesystem events")
+			}
+			w.mu.Unlock()
+
+			return
+		}
+
+		w.mu.Lock()
+		before := w.total
+
+		for off := 0; off+syscall.SizeofInotifyEvent <= n; {
+			ev := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[off]))
+
+			end := off + syscall.SizeofInotifyEvent + int(ev.Len)
+			if end > n {
+				break
+			}
+
+			w.handle(ev.Wd, ev.Mask, strings.TrimRight(string(buf[off+syscall.SizeofInotifyEvent:end]), "\x00"))
+			off = end
+		}
+
+		total, failed := w.total, w.err != nil
+		w.mu.Unlock()
+
+		if total != before && !failed && w.onChange != nil {
+			w.onChange(total)
+		}
+	}
+}
+
+// Adjusts the usage for a single event. This must be called while holding the lock.
+func (w *watchAccountant) handle(wd int32, mask uint32, name string) {
+	// Events were dropped because they were not read quickly enough, so the only way to
+	// know the usage is to walk the files again.
+	if mask&syscall.IN_Q_OVERFLOW != 0 {
+		w.removeTree(w.root)
+		w.fail(w.addTree(w.root))
+
+		return
+	}
+
+	d, ok := w.dirs[wd]
+	if !ok {
+		return
+	}
+
+	// The watch was removed because the directory was deleted.
+	if mask&syscall.IN_IGNORED != 0 {
+		w.removeTree(d.path)
+		if d.path == w.root {
+			w.fail(errors.New("the server data directory was removed"))
+		}
+
+		return
+	}
+
+	if name == "" {
+		return
+	}
+
+	p := filepath.Join(d.path, name)
+
+	if mask&(syscall.IN_DELETE|syscall.IN_MOVED_FROM) != 0 {
+		if mask&syscall.IN_ISDIR != 0 {
+			w.removeTree(p)
+		} else {
+			w.setFile(d, name, -1)
+		}
+
+		return
+	}
+
+	st, err := os.Lstat(p)
+	if err != nil {
+		w.setFile(d, name, -1)
+	} else if st.IsDir() {
+		if mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+			w.fail(w.addTree(p))
+		}
+	} else {
+		w.setFile(d, name, st.Size())
+	}
+}
+
+func (w *watchAccountant) fail(err error) {
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Watches a directory and every directory below it, counting the files within them.
+func (w *watchAccountant) addTree(root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can be removed while they are being walked, and unreadable directories
+			// should not prevent the rest from being counted.
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		// Directories are watched before the files in them are read, so that no file can
+		// be created without either being read or causing an event.
+		if info.IsDir() {
+			return w.watch(p)
+		}
+
+		if wd, ok := w.paths[filepath.Dir(p)]; ok {
+			w.setFile(w.dirs[wd], info.Name(), info.Size())
+		}
+
+		return nil
+	})
+}
+
+func (w *watchAccountant) watch(p string) error {
+	wd, err := syscall.InotifyAddWatch(w.fd, p, watchMask)
+	if err != nil {
+		if err == syscall.ENOSPC {
+			return errors.New("the limit on the number of directories that can be watched has been reached, raise fs.inotify.max_user_watches to watch this server")
+		}
+
+		if err == syscall.ENOENT || err == syscall.ENOTDIR {
+			return filepath.SkipDir
+		}
+
+		return errors.Wrapf(err, "failed to watch %s", p)
+	}
+
+	// Watching a directory that is already being watched returns the same watch, which
+	// happens when the same directory is walked again.
+	if d, ok := w.dirs[int32(wd)]; ok {
+		if d.path == p {
+			return nil
+		}
+
+		for _, size := range d.files {
+			w.total -= size
+		}
+		delete(w.paths, d.path)
+	}
+
+	w.dirs[int32(wd)] = &watchedDirectory{path: p, files: make(map[string]int64)}
+	w.paths[p] = int32(wd)
+
+	return nil
+}
+
+// Stops watching a directory and every directory below it, removing the files within
+// them from the usage.
+func (w *watchAccountant) removeTree(root string) {
+	for wd, d := range w.dirs {
+		if d.path != root && !strings.HasPrefix(d.path, root+"/") {
+			continue
+		}
+
+		for _, size := range d.files {
+			w.total -= size
+		}
+
+		delete(w.dirs, wd)
+		delete(w.paths, d.path)
+		syscall.InotifyRmWatch(w.fd, uint32(wd))
+	}
+}
+
+// Sets the size of a file in the directory, removing it if the size is negative.
+func (w *watchAccountant) setFile(d *watchedDirectory, name string, size int64) {
+	w.total -= d.files[name]
+
+	if size < 0 {
+		delete(d.files, name)
+		return
+	}
+
+	d.files[name] = size
+	w.total += size
+}
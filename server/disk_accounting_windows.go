@@ -0,0 +1,11 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Disk accounting relies on features of Linux, so the files of servers are always scanned
+// on this platform.
+func newDiskAccountant(mode string, root string, uuid string, onChange func(int64)) (diskAccountant, error) {
+	return nil, errors.New("disk accounting is not supported on this platform")
+}
@@ -0,0 +1,193 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Reports the progress of a drain operation on the node.
+type DrainStatus struct {
+	Draining    bool      `json:"draining"`
+	StartedAt   time.Time `json:"started_at"`
+	StopServers bool      `json:"stop_servers"`
+	Total       int       `json:"total"`
+	Stopped     int       `json:"stopped"`
+	Killed      int       `json:"killed"`
+	Complete    bool      `json:"complete"`
+}
+
+var drain = struct {
+	sync.RWMutex
+	status DrainStatus
+	cancel chan struct{}
+	// Incremented each time a drain is started, so that the servers being stopped for a
+	// drain that was cancelled are not counted towards a later one.
+	generation int
+}{}
+
+// Determines if the node is currently draining. While draining no new server installs
+// should be accepted by the daemon.
+func IsDraining() bool {
+	drain.RLock()
+	defer drain.RUnlock()
+
+	return drain.status.Draining
+}
+
+// Returns the current status of the drain operation.
+func GetDrainStatus() DrainStatus {
+	drain.RLock()
+	defer drain.RUnlock()
+
+	return drain.status
+}
+
+// Places the node into drain mode. If stopServers is true every running server is sent
+// the configured warning command and then stopped on a staggered schedule in the
+// background. Calling this while the node is already draining returns an error.
+func StartDrain(stopServers bool, cfg *config.DrainConfiguration) error {
+	drain.Lock()
+	defer drain.Unlock()
+
+	if drain.status.Draining {
+		return errors.New("node is already draining")
+	}
+
+	drain.generation++
+	drain.cancel = make(chan struct{})
+	drain.status = DrainStatus{
+		Draining:    true,
+		StartedAt:   time.Now().UTC(),
+		StopServers: stopServers,
+		Complete:    !stopServers,
+	}
+
+	zap.S().Infow("node is now draining", zap.Bool("stop_servers", stopServers))
+
+	if stopServers {
+		servers := GetServers().Filter(func(s *Server) bool {
+			return s.State != ProcessOfflineState
+		})
+
+		drain.status.Total = len(servers)
+		go stopServersForDrain(servers, cfg, drain.cancel, drain.generation)
+	}
+
+	return nil
+}
+
+// Takes the node out of drain mode. Servers that have not yet been stopped will be left
+// running, but servers that have already been stopped are not started again.
+func StopDrain() {
+	drain.Lock()
+	defer drain.Unlock()
+
+	if !drain.status.Draining {
+		return
+	}
+
+	close(drain.cancel)
+	drain.status.Draining = false
+
+	zap.S().Infow("node is no longer draining")
+}
+
+// Stops each of the servers provided, beginning the process for each one after the
+// configured stagger period has passed. The status is only updated while the drain with
+// the given generation is still the current one.
+func stopServersForDrain(servers []*Server, cfg *config.DrainConfiguration, cancel chan struct{}, generation int) {
+	var wg sync.WaitGroup
+
+	for i, s := range servers {
+		if i > 0 && !sleepOrCancel(time.Duration(cfg.Stagger)*time.Second, cancel) {
+			break
+		}
+
+		wg.Add(1)
+		go func(s *Server) {
+			defer wg.Done()
+
+			killed, err := stopServerForDrain(s, cfg, cancel)
+			if err != nil {
+				zap.S().Warnw("failed to stop server while draining node", zap.String("server", s.Uuid), zap.Error(err))
+				return
+			}
+
+			drain.Lock()
+			if drain.generation == generation {
+				drain.status.Stopped++
+				if killed {
+					drain.status.Killed++
+				}
+			}
+			drain.Unlock()
+		}(s)
+	}
+
+	wg.Wait()
+
+	drain.Lock()
+	defer drain.Unlock()
+
+	if drain.generation != generation {
+		return
+	}
+
+	drain.status.Complete = true
+
+	zap.S().Infow("finished stopping servers for node drain")
+}
+
+// Warns the users of a server that it is about to be stopped, and then stops it. If the
+// server does not stop within the configured timeout the process is killed. The boolean
+// returned indicates if the process had to be killed.
+func stopServerForDrain(s *Server, cfg *config.DrainConfiguration, cancel chan struct{}) (bool, error) {
+	if cfg.WarningCommand != "" {
+		cmd := strings.Replace(cfg.WarningCommand, "{seconds}", strconv.Itoa(cfg.WarningDelay), -1)
+
+		if err := s.Environment.SendCommand(cmd); err != nil {
+			zap.S().Warnw("failed to send drain warning to server", zap.String("server", s.Uuid), zap.Error(err))
+		} else if !sleepOrCancel(time.Duration(cfg.WarningDelay)*time.Second, cancel) {
+			return false, errors.New("drain was cancelled before the server was stopped")
+		}
+	}
+
+	s.PublishConsoleOutputFromDaemon("Server is being stopped for node maintenance...")
+	s.SetStopReason(StopReasonDrain, "the node is being drained for maintenance")
+
+	if err := s.Environment.Stop(); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	// The process is not killed if the drain is cancelled while waiting for it to stop,
+	// it is left to finish stopping by itself.
+	timeout := time.After(time.Duration(cfg.StopTimeout) * time.Second)
+	for s.State != ProcessOfflineState {
+		select {
+		case <-timeout:
+			return true, s.Environment.Terminate(os.Kill)
+		case <-cancel:
+			return false, errors.New("drain was cancelled before the server stopped")
+		case <-time.After(time.Second):
+		}
+	}
+
+	return false, nil
+}
+
+// Sleeps for the given duration, returning false if the drain is cancelled before the
+// duration has passed.
+func sleepOrCancel(d time.Duration, cancel chan struct{}) bool {
+	select {
+	case <-cancel:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
@@ -0,0 +1,276 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The ways that changes to the egg configuration of a server can be handled.
+const (
+	EggDriftDisabled = "disabled"
+	EggDriftReport   = "report"
+	EggDriftApply    = "apply"
+)
+
+// The changes found between the egg configuration a server is using and the configuration
+// the Panel has for it. The values of environment variables are never included since
+// they can contain secrets, only their names.
+type EggDrift struct {
+	Server  string   `json:"server"`
+	Changes []string `json:"changes"`
+	Applied bool     `json:"applied"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// The result of the last check of every server against the Panel. Only servers that have
+// changed, or that could not be checked, are included.
+type EggSyncReport struct {
+	Mode       string     `json:"mode"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt time.Time  `json:"finished_at"`
+	Checked    int        `json:"checked"`
+	Servers    []EggDrift `json:"servers"`
+}
+
+// The parts of the server settings sent by the Panel that are defined by the egg.
+type eggSettings struct {
+	Invocation string            `json:"invocation"`
+	EnvVars    map[string]string `json:"environment"`
+	Container  struct {
+		Image string `json:"image"`
+	} `json:"container"`
+}
+
+var eggSync = struct {
+	sync.Mutex
+	// Held while a check is running so that checks never overlap.
+	running sync.Mutex
+	report  *EggSyncReport
+}{}
+
+// Returns the result of the last check of the servers against the Panel, or nil if no
+// check has run yet.
+func GetEggSyncReport() *EggSyncReport {
+	eggSync.Lock()
+	defer eggSync.Unlock()
+
+	return eggSync.report
+}
+
+// Starts the background routine that checks the egg configuration of every server
+// against the Panel on the configured interval. The first check runs after one interval
+// has passed, since servers are synced when the daemon boots.
+func StartEggSync(cfg *config.EggSyncConfiguration) {
+	if cfg.Mode == EggDriftDisabled || cfg.Interval <= 0 {
+		return
+	}
+
+	go supervisor.Supervise("egg sync", func() error {
+		for {
+			time.Sleep(time.Duration(cfg.Interval) * time.Minute)
+
+			SyncEggs(cfg.Mode)
+		}
+	})
+}
+
+// Fetches the configuration of every server from the Panel and compares the parts defined
+// by the egg with what the server is using. Depending on the mode the changes are either
+// only reported, or applied to the server. Changes applied to a running server take
+// effect the next time it is started, just as they would if it was updated in the Panel.
+func SyncEggs(mode string) *EggSyncReport {
+	eggSync.running.Lock()
+	defer eggSync.running.Unlock()
+
+	r := &EggSyncReport{Mode: mode, StartedAt: time.Now().UTC(), Servers: []EggDrift{}}
+
+	for _, s := range GetServers().All() {
+		r.Checked++
+
+		d := s.syncEgg(mode)
+		if d.Error != "" {
+			zap.S().Warnw("failed to check egg configuration of server", zap.String("server", s.Uuid), zap.String("error", d.Error))
+		} else if len(d.Changes) > 0 {
+			zap.S().Infow("egg configuration of server has changed in the panel", zap.String("server", s.Uuid), zap.Strings("changes", d.Changes), zap.Bool("applied", d.Applied))
+		}
+
+		if d.Error != "" || len(d.Changes) > 0 {
+			r.Servers = append(r.Servers, d)
+		}
+	}
+
+	r.FinishedAt = time.Now().UTC()
+
+	eggSync.Lock()
+	eggSync.report = r
+	eggSync.Unlock()
+
+	return r
+}
+
+// Compares the egg configuration of the server with the Panel, applying the changes if
+// the mode allows it.
+func (s *Server) syncEgg(mode string) EggDrift {
+	d := EggDrift{Server: s.Uuid, Changes: []string{}}
+
+	cfg, rerr, err := s.GetProcessConfiguration()
+	if err != nil || rerr != nil {
+		if err == nil {
+			err = errors.New(rerr.String())
+		}
+
+		d.Error = err.Error()
+		return d
+	}
+
+	changes, err := s.eggChanges(cfg)
+	if err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	d.Changes = changes
+	if len(changes) == 0 || mode != EggDriftApply {
+		return d
+	}
+
+	if err := s.UpdateDataStructure(cfg.Settings, false); err != nil {
+		d.Error = err.Error()
+		return d
+	}
+
+	s.processConfiguration = cfg.ProcessConfiguration
+	d.Applied = true
+
+	return d
+}
+
+// Returns the parts of the egg configuration that differ between the server and the
+// configuration from the Panel. Local overrides are applied to the configuration from
+// the Panel first, so that they are not reported as changes.
+func (s *Server) eggChanges(cfg *api.ServerConfigurationResponse) ([]string, error) {
+	var remote eggSettings
+	if err := json.Unmarshal(cfg.Settings, &remote); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Settings that are missing from the Panel response are left unchanged when they are
+	// applied, so they are not changes either.
+	if remote.Invocation == "" {
+		remote.Invocation = s.Invocation
+	}
+
+	if remote.Container.Image == "" {
+		remote.Container.Image = s.Container.Image
+	}
+
+	if len(remote.EnvVars) == 0 {
+		remote.EnvVars = s.EnvVars
+	}
+
+	if o, err := s.GetOverrides(); err == nil && o != nil {
+		if o.Image != "" {
+			remote.Container.Image = o.Image
+		}
+
+		if o.Invocation != "" {
+			remote.Invocation = o.Invocation
+		}
+
+		if o.InvocationAppend != "" && !strings.HasSuffix(remote.Invocation, " "+o.InvocationAppend) {
+			remote.Invocation = remote.Invocation + " " + o.InvocationAppend
+		}
+
+		if len(o.Environment) > 0 {
+			env := make(map[string]string, len(remote.EnvVars)+len(o.Environment))
+			for k, v := range remote.EnvVars {
+				env[k] = v
+			}
+
+			for k, v := range o.Environment {
+				env[k] = v
+			}
+
+			remote.EnvVars = env
+		}
+	}
+
+	changes := []string{}
+	if remote.Invocation != s.Invocation {
+		changes = append(changes, "invocation")
+	}
+
+	if remote.Container.Image != s.Container.Image {
+		changes = append(changes, "image")
+	}
+
+	for k, v := range remote.EnvVars {
+		if cur, ok := s.EnvVars[k]; !ok || cur != v {
+			changes = append(changes, "environment."+k)
+		}
+	}
+
+	for k := range s.EnvVars {
+		if _, ok := remote.EnvVars[k]; !ok {
+			changes = append(changes, "environment."+k)
+		}
+	}
+
+	// The process configuration is only loaded once a server has been synced, and is
+	// fetched again when the server starts, so there is nothing to compare until then.
+	if s.processConfiguration != nil && cfg.ProcessConfiguration != nil {
+		pc, err := processConfigurationChanges(s.processConfiguration, cfg.ProcessConfiguration)
+		if err != nil {
+			return nil, err
+		}
+
+		changes = append(changes, pc...)
+	}
+
+	sort.Strings(changes)
+
+	return changes, nil
+}
+
+// Returns the name of each section of the process configuration that differs, such as
+// "process.configs" when the configuration files have changed.
+func processConfigurationChanges(a *api.ProcessConfiguration, b *api.ProcessConfiguration) ([]string, error) {
+	sections := func(pc *api.ProcessConfiguration) (map[string]json.RawMessage, error) {
+		out := make(map[string]json.RawMessage)
+
+		j, err := json.Marshal(pc)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		return out, errors.WithStack(json.Unmarshal(j, &out))
+	}
+
+	sa, err := sections(a)
+	if err != nil {
+		return nil, err
+	}
+
+	sb, err := sections(b)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []string
+	for k, v := range sb {
+		if !bytes.Equal(sa[k], v) {
+			changes = append(changes, "process."+k)
+		}
+	}
+
+	return changes, nil
+}
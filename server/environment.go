@@ -19,6 +19,11 @@ type Environment interface {
 	// a no-op.
 	InSituUpdate() error
 
+	// Applies the named resource limits of the server to a running server instance one at
+	// a time, returning the names of the limits that can only be applied by restarting
+	// it. If the server is not running nothing is changed.
+	UpdateResources(limits []string) ([]string, error)
+
 	// Runs before the environment is started. If an error is returned starting will
 	// not occur, otherwise proceeds as normal.
 	OnBeforeStart() error
@@ -40,6 +45,18 @@ type Environment interface {
 	// is not running no error should be returned.
 	Terminate(signal os.Signal) error
 
+	// Sends the named signal, such as "SIGHUP", to a running server instance without
+	// changing the state of the server.
+	Signal(name string) error
+
+	// Suspends every process of a running server instance without stopping it, so that
+	// its files can be read while nothing is writing to them. If the server is not
+	// running no error should be returned.
+	Freeze() error
+
+	// Resumes a server instance that was suspended by Freeze.
+	Thaw() error
+
 	// Destroys the environment removing any containers that were created (in Docker
 	// environments at least).
 	Destroy() error
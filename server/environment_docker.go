@@ -14,9 +14,15 @@ import (
 	"github.com/docker/go-connections/nat"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/cgroups"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/firewall"
+	"github.com/pterodactyl/wings/hooks"
+	"github.com/pterodactyl/wings/hoststat"
+	"github.com/pterodactyl/wings/supervisor"
 	"go.uber.org/zap"
 	"io"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
@@ -42,11 +48,19 @@ type DockerEnvironment struct {
 	// Holds the stats stream used by the polling commands so that we can easily close
 	// it out.
 	stats io.ReadCloser
+
+	// Tracks if bandwidth limits have been applied to the running container, so that
+	// they can be removed if the limits are removed from the server.
+	bandwidthLimited bool
+
+	// Set to 1 while a stop sequence is running for the server, so that additional stop
+	// requests do not start the sequence over.
+	stopping int32
 }
 
 // Creates a new base Docker environment. A server must still be attached to it.
 func NewDockerEnvironment(server *Server) error {
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := NewRuntimeClient()
 	if err != nil {
 		return err
 	}
@@ -65,7 +79,7 @@ var _ Environment = (*DockerEnvironment)(nil)
 
 // Returns the name of the environment.
 func (d *DockerEnvironment) Type() string {
-	return "docker"
+	return RuntimeName()
 }
 
 // Determines if the container exists in this environment.
@@ -130,9 +144,74 @@ func (d *DockerEnvironment) InSituUpdate() error {
 		return errors.WithStack(err)
 	}
 
+	if d.Server.Build.Bandwidth.Enabled() || d.bandwidthLimited {
+		if err := d.applyBandwidthLimits(); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// Applies the named resource limits to the running container. The runtime ignores limits
+// with a zero value when updating a container, so removing a limit, and any limit the
+// runtime refuses to change, is left until the container is next created.
+func (d *DockerEnvironment) UpdateResources(limits []string) ([]string, error) {
+	c, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
+	if err != nil {
+		if client.IsErrNotFound(err) {
+			return nil, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	if !c.State.Running {
+		return nil, nil
+	}
+
+	full := d.getResourcesForServer()
+
+	var pending []string
+	for _, l := range limits {
+		var r container.Resources
+		var unset bool
+
+		switch l {
+		case MemoryResourceLimit:
+			r.Memory, r.MemoryReservation, r.MemorySwap = full.Memory, full.MemoryReservation, full.MemorySwap
+			unset = r.Memory == 0
+		case CpuResourceLimit:
+			r.CPUQuota, r.CPUPeriod = full.CPUQuota, full.CPUPeriod
+			unset = r.CPUQuota == 0
+		case CpuWeightResourceLimit:
+			r.CPUShares = full.CPUShares
+			unset = r.CPUShares == 0
+		case IoWeightResourceLimit:
+			r.BlkioWeight = full.BlkioWeight
+			unset = r.BlkioWeight == 0
+		case PidsResourceLimit:
+			r.PidsLimit = full.PidsLimit
+			unset = r.PidsLimit == 0
+		default:
+			unset = true
+		}
+
+		if unset {
+			pending = append(pending, l)
+			continue
+		}
+
+		if _, err := d.Client.ContainerUpdate(context.Background(), d.Server.Uuid, container.UpdateConfig{Resources: r}); err != nil {
+			zap.S().Warnw("failed to change resource limit of running server, it will be applied on restart", zap.String("server", d.Server.Uuid), zap.String("limit", l), zap.Error(err))
+
+			pending = append(pending, l)
+		}
+	}
+
+	return pending, nil
+}
+
 // Run before the container starts and get the process configuration from the Panel.
 // This is important since we use this to check configuration files as well as ensure
 // we always have the latest version of an egg available for server processes.
@@ -145,6 +224,15 @@ func (d *DockerEnvironment) OnBeforeStart() error {
 	if err := d.Server.Sync(); err != nil {
 		return err
 	}
+	d.Server.bootPhase("sync")
+
+	if err := d.Server.checkPortConflicts(); err != nil {
+		return err
+	}
+
+	// Any ports being forwarded after an allocation change are bound directly by the new
+	// container, so the forwarding needs to stop before it is created.
+	stopAllocationProxy(d.Server.Uuid)
 
 	// Always destroy and re-create the server container to ensure that synced data from
 	// the Panel is used.
@@ -165,6 +253,11 @@ func (d *DockerEnvironment) OnBeforeStart() error {
 		return err
 	}
 
+	if err := d.Server.SyncFirewall(); err != nil {
+		zap.S().Warnw("failed to apply firewall rules for server", zap.String("server", d.Server.Uuid), zap.Error(err))
+	}
+	d.Server.bootPhase("firewall")
+
 	return nil
 }
 
@@ -192,6 +285,10 @@ func (d *DockerEnvironment) Start() error {
 		return &suspendedError{}
 	}
 
+	if d.Server.storageMigrationLocked() {
+		return errors.New("server cannot be started while its data is being migrated")
+	}
+
 	c, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
 	if err != nil && !client.IsErrNotFound(err) {
 		return errors.WithStack(err)
@@ -204,11 +301,32 @@ func (d *DockerEnvironment) Start() error {
 		return d.Attach()
 	}
 
-	d.Server.SetState(ProcessStartingState)
+	// The server is marked as starting while the quota is held so that it counts towards
+	// the quota of its group before any other server in the group is checked.
+	err = d.Server.checkGroupQuota(func() {
+		d.Server.SetState(ProcessStartingState)
+	}, true)
+
+	if err != nil {
+		d.Server.PublishConsoleOutputFromDaemon("Server cannot be started: " + err.Error())
+
+		return err
+	}
+
+	d.Server.beginBoot()
 	// Set this to true for now, we will set it to false once we reach the
 	// end of this chain.
 	sawError = true
 
+	d.Server.fireHook(hooks.PreStart, nil)
+	d.Server.bootPhase("hooks")
+
+	// The previous network may be waiting for this server to stop before it can be removed,
+	// and must not be removed once the container for the server has been created on it.
+	retryNetworkMigration()
+	release := holdNetwork()
+	defer release()
+
 	// Run the before start function and wait for it to finish. This will validate that the container
 	// exists on the system, and rebuild the container if that is required for server booting to
 	// occur.
@@ -231,28 +349,69 @@ func (d *DockerEnvironment) Start() error {
 	// we don't need to actively do anything about it at this point, worst comes to worst the
 	// server starts in a weird state and the user can manually adjust.
 	d.Server.UpdateConfigurationFiles()
+	d.Server.bootPhase("configuration")
 
 	// Reset the permissions on files for the server before actually trying
 	// to start it.
 	if err := d.Server.Filesystem.Chown("/"); err != nil {
 		return errors.WithStack(err)
 	}
+	d.Server.bootPhase("permissions")
+
+	if err := d.Server.checkIntegrityBeforeStart(); err != nil {
+		return err
+	}
+	d.Server.bootPhase("integrity")
+
+	// The offline responder keeps answering while the server is starting, but must release
+	// the allocation before the container is able to bind it.
+	stopOfflineResponder(d.Server.Uuid)
 
 	opts := types.ContainerStartOptions{}
 	if err := d.Client.ContainerStart(context.Background(), d.Server.Uuid, opts); err != nil {
 		return errors.WithStack(err)
 	}
 
+	// The interface that bandwidth limits are applied to is only created once the
+	// container is running, and is replaced every time the container starts.
+	d.bandwidthLimited = false
+	if d.Server.Build.Bandwidth.Enabled() {
+		if err := d.applyBandwidthLimits(); err != nil {
+			zap.S().Warnw("failed to apply bandwidth limits to server", zap.String("server", d.Server.Uuid), zap.Error(err))
+		}
+	}
+
+	// Port ranges forwarded by the daemon point at the address of the container, which
+	// changes every time it starts.
+	if err := d.applyPortRangeForwards(); err != nil {
+		zap.S().Warnw("failed to forward port ranges to server", zap.String("server", d.Server.Uuid), zap.Error(err))
+	}
+
+	d.Server.bootPhase("container_start")
+
 	// No errors, good to continue through.
 	sawError = false
 
-	return d.Attach()
+	if err := d.Attach(); err != nil {
+		return err
+	}
+	d.Server.bootPhase("attach")
+
+	return nil
 }
 
 // Stops the container that the server is running in. This will allow up to 10
 // seconds to pass before a failure occurs.
 func (d *DockerEnvironment) Stop() error {
+	if d.Server.State != ProcessOfflineState {
+		d.Server.fireHook(hooks.PreStop, nil)
+	}
+
 	stop := d.Server.processConfiguration.Stop
+	if len(stop.Sequence) > 0 {
+		return d.runStopSequence(stop.Sequence)
+	}
+
 	if stop.Type == api.ProcessStopSignal {
 		return d.Terminate(os.Kill)
 	}
@@ -287,6 +446,39 @@ func (d *DockerEnvironment) Terminate(signal os.Signal) error {
 	)
 }
 
+// Pauses the Docker container, which freezes every process within it using the cgroup
+// freezer.
+func (d *DockerEnvironment) Freeze() error {
+	ctx := context.Background()
+
+	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !c.State.Running || c.State.Paused {
+		return nil
+	}
+
+	return errors.WithStack(d.Client.ContainerPause(ctx, d.Server.Uuid))
+}
+
+// Unpauses the Docker container if it was paused.
+func (d *DockerEnvironment) Thaw() error {
+	ctx := context.Background()
+
+	c, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !c.State.Paused {
+		return nil
+	}
+
+	return errors.WithStack(d.Client.ContainerUnpause(ctx, d.Server.Uuid))
+}
+
 // Remove the Docker container from the machine. If the container is currently running
 // it will be forcibly stopped by Docker.
 func (d *DockerEnvironment) Destroy() error {
@@ -295,6 +487,17 @@ func (d *DockerEnvironment) Destroy() error {
 	// Avoid crash detection firing off.
 	d.Server.SetState(ProcessStoppingState)
 
+	stopAllocationProxy(d.Server.Uuid)
+	stopOfflineResponder(d.Server.Uuid)
+
+	if err := d.Server.RemoveFirewall(); err != nil {
+		zap.S().Warnw("failed to remove firewall rules for server", zap.String("server", d.Server.Uuid), zap.Error(err))
+	}
+
+	if err := firewall.RemoveForwards(d.Server.Uuid); err != nil {
+		zap.S().Warnw("failed to remove port range forwards for server", zap.String("server", d.Server.Uuid), zap.Error(err))
+	}
+
 	return d.Client.ContainerRemove(ctx, d.Server.Uuid, types.ContainerRemoveOptions{
 		RemoveVolumes: true,
 		RemoveLinks:   false,
@@ -391,7 +594,7 @@ func (d *DockerEnvironment) FollowConsoleOutput() error {
 
 		s := bufio.NewScanner(r)
 		for s.Scan() {
-			d.Server.Events().Publish(ConsoleOutputEvent, s.Text())
+			d.Server.publishConsoleOutput(s.Text())
 		}
 
 		if err := s.Err(); err != nil {
@@ -418,46 +621,91 @@ func (d *DockerEnvironment) EnableResourcePolling() error {
 	}
 	d.stats = stats.Body
 
-	dec := json.NewDecoder(d.stats)
-	go func(s *Server) {
-		for {
-			var v *types.StatsJSON
+	// The stats collector is supervised so that a panic while processing the stats does not
+	// take down the entire daemon. If the collector is restarted the stats stream will be
+	// opened again as long as the server is still running.
+	go supervisor.Supervise("stats", func() error {
+		defer d.DisableResourcePolling()
 
-			if err := dec.Decode(&v); err != nil {
-				if err != io.EOF {
-					zap.S().Warnw("encountered error processing server stats; stopping collection", zap.Error(err))
-				}
+		if d.stats == nil {
+			if d.Server.State == ProcessOfflineState {
+				return nil
+			}
 
-				d.DisableResourcePolling()
-				return
+			stats, err := d.Client.ContainerStats(context.Background(), d.Server.Uuid, true)
+			if err != nil {
+				return errors.WithStack(err)
 			}
+			d.stats = stats.Body
+		}
+
+		d.pollResources(d.Server, json.NewDecoder(d.stats))
+
+		return nil
+	})
+
+	return nil
+}
+
+// Processes the stats stream from Docker until the stream is closed or the server is no
+// longer running.
+func (d *DockerEnvironment) pollResources(s *Server, dec *json.Decoder) {
+	for {
+		var v *types.StatsJSON
 
-			// Disable collection if the server is in an offline state and this process is
-			// still running.
-			if s.State == ProcessOfflineState {
-				d.DisableResourcePolling()
-				return
+		if err := dec.Decode(&v); err != nil {
+			if err != io.EOF {
+				zap.S().Warnw("encountered error processing server stats; stopping collection", zap.Error(err))
 			}
 
-			s.Resources.CpuAbsolute = s.Resources.CalculateAbsoluteCpu(&v.PreCPUStats, &v.CPUStats)
-			s.Resources.Memory = v.MemoryStats.Usage
-			s.Resources.MemoryLimit = v.MemoryStats.Limit
+			return
+		}
+
+		// Disable collection if the server is in an offline state and this process is
+		// still running.
+		if s.State == ProcessOfflineState {
+			return
+		}
 
-			// Why you ask? This already has the logic for caching disk space in use and then
-			// also handles pushing that value to the resources object automatically.
-			s.Filesystem.HasSpaceAvailable()
+		s.Resources.CpuAbsolute = s.Resources.CalculateAbsoluteCpu(&v.PreCPUStats, &v.CPUStats)
+		s.Resources.updateThrottling(&v.PreCPUStats, &v.CPUStats)
+		s.Resources.CpuSteal = hoststat.Steal()
+		s.Resources.Health = s.Health()
+		s.Resources.Memory = memoryUsage(&v.MemoryStats)
+		s.Resources.MemoryLimit = v.MemoryStats.Limit
 
-			for _, nw := range v.Networks {
-				s.Resources.Network.RxBytes += nw.RxBytes
-				s.Resources.Network.TxBytes += nw.TxBytes
+		if cgroups.Unified() {
+			if p, err := cgroups.ContainerPath(v.ID); err == nil {
+				s.Resources.Pressure, _ = cgroups.ReadPressure(p)
 			}
+		}
 
-			b, _ := json.Marshal(s.Resources)
-			s.Events().Publish(StatsEvent, string(b))
+		// Why you ask? This already has the logic for caching disk space in use and then
+		// also handles pushing that value to the resources object automatically.
+		s.Filesystem.HasSpaceAvailable()
+
+		// The network statistics are totals since the container started, so they are
+		// summed across networks rather than added to the previous values.
+		var rx, tx uint64
+		for _, nw := range v.Networks {
+			rx += nw.RxBytes
+			tx += nw.TxBytes
 		}
-	}(d.Server)
 
-	return nil
+		if elapsed := v.Read.Sub(v.PreRead).Seconds(); elapsed > 0 && !v.PreRead.IsZero() {
+			s.Resources.Network.RxRate = rate(rx, s.Resources.Network.RxBytes, elapsed)
+			s.Resources.Network.TxRate = rate(tx, s.Resources.Network.TxBytes, elapsed)
+		}
+
+		s.Resources.Network.RxBytes = rx
+		s.Resources.Network.TxBytes = tx
+
+		s.Resources.publishMetrics(s.Uuid)
+		s.recordStats()
+
+		b, _ := json.Marshal(s.Resources)
+		s.Events().Publish(StatsEvent, string(b))
+	}
 }
 
 // Closes the stats stream for a server process.
@@ -467,38 +715,36 @@ func (d *DockerEnvironment) DisableResourcePolling() error {
 	}
 
 	err := d.stats.Close()
+	d.stats = nil
 
 	d.Server.Resources.CpuAbsolute = 0
+	d.Server.Resources.CpuThrottling.Percent = 0
 	d.Server.Resources.Memory = 0
 	d.Server.Resources.Network.TxBytes = 0
 	d.Server.Resources.Network.RxBytes = 0
+	d.Server.Resources.Network.TxRate = 0
+	d.Server.Resources.Network.RxRate = 0
+	d.Server.Resources.Pressure = nil
+	d.Server.Resources.Health = ""
+	d.Server.Resources.Players = nil
+	d.Server.Resources.publishMetrics(d.Server.Uuid)
 
 	return errors.WithStack(err)
 }
 
-// Pulls the image from Docker.
-//
-// @todo handle authorization & local images
+// Pulls the image from Docker according to the pull policy for the server, or builds
+// the image locally if the server provides a Dockerfile.
 func (d *DockerEnvironment) ensureImageExists(c *client.Client) error {
-	out, err := c.ImagePull(context.Background(), d.Server.Container.Image, types.ImagePullOptions{All: false})
-	if err != nil {
-		return err
+	if d.Server.Container.Dockerfile != "" {
+		return d.ensureLocalImageExists(c)
 	}
-	defer out.Close()
-
-	zap.S().Debugw("pulling docker image... this could take a bit of time", zap.String("image", d.Server.Container.Image))
 
-	// I'm not sure what the best approach here is, but this will block execution until the image
-	// is done being pulled, which is what we need.
-	scanner := bufio.NewScanner(out)
-	for scanner.Scan() {
-		continue
-	}
-
-	if err := scanner.Err(); err != nil {
+	if err := pullImage(c, d.image(), d.Server.Container.PullPolicy); err != nil {
 		return err
 	}
 
+	recordImagePull(c, d.image(), false)
+
 	return nil
 }
 
@@ -508,7 +754,7 @@ func (d *DockerEnvironment) ensureImageExists(c *client.Client) error {
 // @todo pull the image being requested if it doesn't exist currently.
 func (d *DockerEnvironment) Create() error {
 	ctx := context.Background()
-	cli, err := client.NewClientWithOpts(client.FromEnv)
+	cli, err := NewRuntimeClient()
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -531,10 +777,11 @@ func (d *DockerEnvironment) Create() error {
 	if err := d.ensureImageExists(cli); err != nil {
 		return errors.WithStack(err)
 	}
+	d.Server.bootPhase("image")
 
 	conf := &container.Config{
 		Hostname:     "container",
-		User:         strconv.Itoa(config.Get().System.User.Uid),
+		User:         containerUser(),
 		AttachStdin:  true,
 		AttachStdout: true,
 		AttachStderr: true,
@@ -543,7 +790,7 @@ func (d *DockerEnvironment) Create() error {
 
 		ExposedPorts: d.exposedPorts(),
 
-		Image: d.Server.Container.Image,
+		Image: d.image(),
 		Env:   d.environmentVariables(),
 
 		Labels: map[string]string{
@@ -576,8 +823,8 @@ func (d *DockerEnvironment) Create() error {
 		// from the Panel.
 		Resources: d.getResourcesForServer(),
 
-		// @todo make this configurable again
-		DNS: []string{"1.1.1.1", "8.8.8.8"},
+		DNS:        d.dns(),
+		ExtraHosts: d.extraHosts(),
 
 		// Configure logging for the container to make it easier on the Daemon to grab
 		// the server output. Ensure that we don't use too much space on the host machine
@@ -591,13 +838,24 @@ func (d *DockerEnvironment) Create() error {
 			},
 		},
 
-		SecurityOpt:    []string{"no-new-privileges"},
 		ReadonlyRootfs: true,
-		CapDrop: []string{
-			"setpcap", "mknod", "audit_write", "net_raw", "dac_override",
-			"fowner", "fsetid", "net_bind_service", "sys_chroot", "setfcap",
-		},
-		NetworkMode: "pterodactyl_nw",
+		NetworkMode:    container.NetworkMode(config.Get().Docker.Network.Name),
+	}
+
+	if err := d.configureGpus(conf, hostConf); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if hostConf.Sysctls, err = d.sysctls(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := d.configureSecurity(hostConf); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := d.configureMounts(hostConf); err != nil {
+		return errors.WithStack(err)
 	}
 
 	// Pretty sure TZ=X in the environment variables negates the need for this
@@ -611,10 +869,21 @@ func (d *DockerEnvironment) Create() error {
 	// 	}
 	// }
 
-	if _, err := cli.ContainerCreate(ctx, conf, hostConf, nil, d.Server.Uuid); err != nil {
+	netConf, err := d.configureNetwork(cli, conf, hostConf)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	r, err := cli.ContainerCreate(ctx, conf, hostConf, netConf, d.Server.Uuid)
+	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	if err := d.connectAdditionalNetworks(cli, r.ID); err != nil {
+		return err
+	}
+	d.Server.bootPhase("container_create")
+
 	return nil
 }
 
@@ -657,6 +926,10 @@ func (d *DockerEnvironment) SendCommand(c string) error {
 // Reads the log file for the server. This does not care if the server is running or not, it will
 // simply try to read the last X bytes of the file and return them.
 func (d *DockerEnvironment) Readlog(len int64) ([]string, error) {
+	if Runtime().ReadsLogsFromApi() {
+		return d.readlogFromApi(len)
+	}
+
 	ctx := context.Background()
 
 	j, err := d.Client.ContainerInspect(ctx, d.Server.Uuid)
@@ -698,6 +971,30 @@ func (d *DockerEnvironment) Readlog(len int64) ([]string, error) {
 	return d.parseLogToStrings(b)
 }
 
+// Reads the end of the server output using the logs API of the runtime.
+func (d *DockerEnvironment) readlogFromApi(size int64) ([]string, error) {
+	r, err := d.Client.ContainerLogs(context.Background(), d.Server.Uuid, types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Tail:       "500",
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if int64(len(b)) > size {
+		b = b[int64(len(b))-size:]
+	}
+
+	return strings.Split(strings.TrimRight(string(b), "\n"), "\n"), nil
+}
+
 type dockerLogLine struct {
 	Log string `json:"log"`
 }
@@ -742,9 +1039,11 @@ func (d *DockerEnvironment) environmentVariables() []string {
 		fmt.Sprintf("SERVER_IP=%s", d.Server.Allocations.DefaultMapping.Ip),
 		fmt.Sprintf("SERVER_PORT=%d", d.Server.Allocations.DefaultMapping.Port),
 	}
+	out = append(out, d.Server.Allocations.pairEnvironment()...)
+	out = append(out, d.Server.Allocations.rangeEnvironment()...)
 
 eloop:
-	for k, v := range d.Server.EnvVars {
+	for k, v := range d.Server.withNodeEnvironment(d.Server.EnvVars) {
 		for _, e := range out {
 			if strings.HasPrefix(e, strings.ToUpper(k)) {
 				continue eloop
@@ -766,22 +1065,46 @@ func (d *DockerEnvironment) volumes() map[string]struct{} {
 func (d *DockerEnvironment) portBindings() nat.PortMap {
 	var out = nat.PortMap{}
 
-	for ip, ports := range d.Server.Allocations.Mappings {
+	for ip, ports := range d.Server.Allocations.Bindings() {
 		for _, port := range ports {
 			// Skip over invalid ports.
 			if port < 0 || port > 65535 {
 				continue
 			}
 
-			binding := []nat.PortBinding{
-				{
-					HostIP:   ip,
-					HostPort: strconv.Itoa(port),
-				},
+			// Allocations using IPv6 addresses may be wrapped in brackets, which Docker
+			// will not accept when binding the port.
+			binding := nat.PortBinding{
+				HostIP:   config.TrimAddressBrackets(ip),
+				HostPort: strconv.Itoa(port),
 			}
 
-			out[nat.Port(fmt.Sprintf("%d/tcp", port))] = binding
-			out[nat.Port(fmt.Sprintf("%d/udp", port))] = binding
+			// The same port can be bound on more than one address, which is always the
+			// case for dual-stack allocation pairs.
+			for _, proto := range []string{"tcp", "udp"} {
+				p := nat.Port(fmt.Sprintf("%d/%s", port, proto))
+				out[p] = append(out[p], binding)
+			}
+		}
+	}
+
+	// Ranges forwarded by the daemon are left out so that Docker does not create rules
+	// and a proxy process for every port in them.
+	for _, r := range d.Server.portRanges() {
+		if d.Server.forwardsPortRange(r) {
+			continue
+		}
+
+		for port := r.Start; port <= r.End; port++ {
+			binding := nat.PortBinding{
+				HostIP:   config.TrimAddressBrackets(r.Ip),
+				HostPort: strconv.Itoa(port),
+			}
+
+			for _, proto := range r.protocols() {
+				p := nat.Port(fmt.Sprintf("%d/%s", port, proto))
+				out[p] = append(out[p], binding)
+			}
 		}
 	}
 
@@ -807,15 +1130,41 @@ func (d *DockerEnvironment) exposedPorts() nat.PortSet {
 // Formats the resources available to a server instance in such as way that Docker will
 // generate a matching environment in the container.
 func (d *DockerEnvironment) getResourcesForServer() container.Resources {
-	return container.Resources{
+	// Any memory added by the OOM policy of the server is only applied to the container,
+	// the server is still told it has the memory limit set by the Panel.
+	bump := d.Server.oomMemoryBump() * 1000000
+
+	r := container.Resources{
 		// @todo memory limit should be slightly higher than the reservation
-		Memory:            d.Server.Build.MemoryLimit * 1000000,
-		MemoryReservation: d.Server.Build.MemoryLimit * 1000000,
+		Memory:            d.Server.Build.MemoryLimit*1000000 + bump,
+		MemoryReservation: d.Server.Build.MemoryLimit*1000000 + bump,
 		MemorySwap:        d.Server.Build.ConvertedSwap(),
 		CPUQuota:          d.Server.Build.ConvertedCpuLimit(),
 		CPUPeriod:         100000,
-		CPUShares:         1024,
+		CPUShares:         d.Server.Build.ConvertedCpuShares(),
 		BlkioWeight:       d.Server.Build.IoWeight,
 		OomKillDisable:    &d.Server.Container.OomDisabled,
+		PidsLimit:         d.Server.Build.PidsLimit,
+	}
+
+	if r.MemorySwap > 0 {
+		r.MemorySwap += bump
+	}
+
+	if err := d.configureIoLimits(&r); err != nil {
+		zap.S().Warnw("failed to apply io limits to server", zap.String("server", d.Server.Uuid), zap.Error(err))
 	}
+
+	if cgroups.Unified() {
+		// The reservation is converted to memory.low on cgroup v2, which protects all of
+		// that memory from being reclaimed. Using the full limit would prevent the kernel
+		// from ever reclaiming memory from the server when the host is under pressure.
+		r.MemoryReservation = 0
+
+		// Disabling the OOM killer is not supported by cgroup v2, and the runtime will
+		// refuse to update a container that requests it.
+		r.OomKillDisable = nil
+	}
+
+	return r
 }
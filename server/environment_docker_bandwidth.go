@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/bandwidth"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+)
+
+// Applies the bandwidth limits for the server to the host side of the network interface
+// of the running container. If the server no longer has any limits they are removed.
+func (d *DockerEnvironment) applyBandwidthLimits() error {
+	// Shaping traffic requires entering the network namespace of the container, which
+	// cannot be done by an unprivileged daemon.
+	if config.Get().System.Rootless.Enabled {
+		zap.S().Warnw("bandwidth limits are not supported when running rootless", zap.String("server", d.Server.Uuid))
+		return nil
+	}
+
+	// Containers on the host network, or attached directly to a host interface, do not
+	// have an interface of their own that traffic can be shaped on.
+	if d.Server.Network.Mode == "host" || d.Server.Network.Mode == "macvlan" {
+		zap.S().Warnw("bandwidth limits are not supported for the server network mode", zap.String("server", d.Server.Uuid), zap.String("mode", d.Server.Network.Mode))
+		return nil
+	}
+
+	c, err := d.Client.ContainerInspect(context.Background(), d.Server.Uuid)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if !c.State.Running {
+		return nil
+	}
+
+	iface, err := bandwidth.HostInterface(c.State.Pid)
+	if err != nil {
+		return err
+	}
+
+	b := d.Server.Build.Bandwidth
+	if !b.Enabled() {
+		d.bandwidthLimited = false
+
+		return bandwidth.Clear(iface)
+	}
+
+	if err := bandwidth.Apply(iface, b.Ingress, b.Egress); err != nil {
+		return err
+	}
+
+	d.bandwidthLimited = true
+
+	zap.S().Debugw("applied bandwidth limits to server", zap.String("server", d.Server.Uuid), zap.String("interface", iface), zap.Uint64("ingress", b.Ingress), zap.Uint64("egress", b.Egress))
+
+	return nil
+}
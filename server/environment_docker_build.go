@@ -0,0 +1,145 @@
+package server
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/buger/jsonparser"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Returns the image that the server container should be created from. Servers that
+// provide a Dockerfile use a node local image tagged using a hash of the Dockerfile and
+// build arguments, so any change to either results in a new image being built.
+func (d *DockerEnvironment) image() string {
+	if d.Server.Container.Dockerfile == "" {
+		return pinnedImage(d.Server.Container.Image, d.Server.Container.Digest)
+	}
+
+	h := sha256.New()
+	h.Write([]byte(d.Server.Container.Dockerfile))
+
+	keys := make([]string, 0, len(d.Server.Container.BuildArgs))
+	for k := range d.Server.Container.BuildArgs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		h.Write([]byte("\x00" + k + "=" + d.Server.Container.BuildArgs[k]))
+	}
+
+	return "wings-local/" + hex.EncodeToString(h.Sum(nil))[:16] + ":latest"
+}
+
+// Builds the local image for the server if it does not already exist. Images are shared
+// between all servers using the same Dockerfile and build arguments.
+func (d *DockerEnvironment) ensureLocalImageExists(c *client.Client) error {
+	if _, _, err := c.ImageInspectWithRaw(context.Background(), d.image()); err == nil {
+		return nil
+	} else if !client.IsErrNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	return d.buildImage(c, false)
+}
+
+// Forces the local image for the server to be rebuilt without using any cached layers.
+// The new image is used the next time the server is started.
+func (d *DockerEnvironment) RebuildImage() error {
+	if d.Server.Container.Dockerfile == "" {
+		return errors.New("server does not use a locally built image")
+	}
+
+	return d.buildImage(d.Client, true)
+}
+
+// Builds the Dockerfile for the server into a local image.
+func (d *DockerEnvironment) buildImage(c *client.Client, noCache bool) error {
+	if !config.Get().Docker.AllowImageBuilds {
+		return errors.New("building server images is not allowed on this node")
+	}
+
+	buildContext, err := d.buildContext()
+	if err != nil {
+		return err
+	}
+
+	args := make(map[string]*string)
+	for k, v := range d.Server.Container.BuildArgs {
+		v := v
+		args[k] = &v
+	}
+
+	image := d.image()
+	zap.S().Infow("building local image for server", zap.String("server", d.Server.Uuid), zap.String("image", image))
+	d.Server.Events().Publish(DaemonMessageEvent, "Building server image, this could take a few minutes...")
+
+	res, err := c.ImageBuild(context.Background(), buildContext, types.ImageBuildOptions{
+		Tags:        []string{image},
+		NoCache:     noCache,
+		Remove:      true,
+		ForceRemove: true,
+		PullParent:  true,
+		BuildArgs:   args,
+		Labels: map[string]string{
+			"Service":       "Pterodactyl",
+			"ContainerType": "server_image",
+		},
+	})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	// The build output is a stream of JSON messages, any errors with the build itself
+	// are returned as a message in this stream rather than through the API response.
+	scanner := bufio.NewScanner(res.Body)
+	for scanner.Scan() {
+		if msg, err := jsonparser.GetString(scanner.Bytes(), "error"); err == nil {
+			d.Server.Events().Publish(DaemonMessageEvent, "Failed to build server image: "+msg)
+
+			return errors.New(msg)
+		}
+
+		if msg, err := jsonparser.GetString(scanner.Bytes(), "stream"); err == nil && strings.TrimSpace(msg) != "" {
+			zap.S().Debugw(strings.TrimSpace(msg), zap.String("server", d.Server.Uuid))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	d.Server.Events().Publish(DaemonMessageEvent, "Finished building server image.")
+
+	return nil
+}
+
+// Returns a tar archive containing the Dockerfile for the server which is sent to the
+// runtime as the build context.
+func (d *DockerEnvironment) buildContext() (*bytes.Buffer, error) {
+	buf := new(bytes.Buffer)
+	tw := tar.NewWriter(buf)
+
+	b := []byte(d.Server.Container.Dockerfile)
+	if err := tw.WriteHeader(&tar.Header{Name: "Dockerfile", Mode: 0644, Size: int64(len(b)), ModTime: time.Now()}); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if _, err := tw.Write(b); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return buf, errors.WithStack(tw.Close())
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/gpu"
+	"strconv"
+	"strings"
+)
+
+// Configures the container to have access to the GPUs requested by the server. NVIDIA
+// devices are made available using the NVIDIA container runtime, and any additional
+// device paths configured for the node are mapped into the container directly.
+func (d *DockerEnvironment) configureGpus(conf *container.Config, hostConf *container.HostConfig) error {
+	g := d.Server.Build.Gpu
+	if !g.Enabled() {
+		return nil
+	}
+
+	cfg := config.Get().Docker.Gpu
+
+	devices, err := d.resolveGpuDevices(g)
+	if err != nil {
+		return err
+	}
+
+	capabilities := g.Capabilities
+	if len(capabilities) == 0 {
+		capabilities = cfg.Capabilities
+	}
+
+	hostConf.Runtime = cfg.Runtime
+	conf.Env = append(
+		conf.Env,
+		"NVIDIA_VISIBLE_DEVICES="+devices,
+		"NVIDIA_DRIVER_CAPABILITIES="+strings.Join(capabilities, ","),
+	)
+
+	for _, p := range cfg.DevicePaths {
+		hostConf.Devices = append(hostConf.Devices, container.DeviceMapping{
+			PathOnHost:        p,
+			PathInContainer:   p,
+			CgroupPermissions: "rwm",
+		})
+	}
+
+	return nil
+}
+
+// Returns the value used to select the visible devices for the NVIDIA runtime.
+func (d *DockerEnvironment) resolveGpuDevices(g GpuSettings) (string, error) {
+	if len(g.Devices) > 0 {
+		return strings.Join(g.Devices, ","), nil
+	}
+
+	detected, err := gpu.Detect()
+	if err != nil {
+		return "", err
+	}
+
+	var indexes []string
+	for _, dev := range detected {
+		if dev.Vendor == "nvidia" && len(indexes) < g.Count {
+			indexes = append(indexes, strconv.Itoa(dev.Index))
+		}
+	}
+
+	if len(indexes) < g.Count {
+		return "", errors.Errorf("server requested %d GPUs but only %d are available on this node", g.Count, len(indexes))
+	}
+
+	return strings.Join(indexes, ","), nil
+}
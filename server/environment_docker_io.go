@@ -0,0 +1,45 @@
+package server
+
+import (
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+	"github.com/pterodactyl/wings/cgroups"
+	"os"
+)
+
+// Applies the IO limits for the server to the disk that the server data is stored on.
+// The runtime applies these using blkio.throttle on cgroup v1 hosts, and io.max on
+// cgroup v2 hosts.
+func (d *DockerEnvironment) configureIoLimits(r *container.Resources) error {
+	l := d.Server.Build.IoLimits
+	if !l.Enabled() {
+		return nil
+	}
+
+	// The server directory may not exist yet if the server has not been installed, in
+	// which case the disk is determined using the root data directory.
+	p := d.Server.Filesystem.Path()
+	if _, err := os.Stat(p); err != nil {
+		p = d.Server.Filesystem.Root()
+	}
+
+	dev, err := cgroups.BlockDevice(p)
+	if err != nil {
+		return err
+	}
+
+	throttle := func(rate uint64) []*blkiodev.ThrottleDevice {
+		if rate == 0 {
+			return nil
+		}
+
+		return []*blkiodev.ThrottleDevice{{Path: dev, Rate: rate}}
+	}
+
+	r.BlkioDeviceReadBps = throttle(l.ReadBps)
+	r.BlkioDeviceWriteBps = throttle(l.WriteBps)
+	r.BlkioDeviceReadIOps = throttle(l.ReadIops)
+	r.BlkioDeviceWriteIOps = throttle(l.WriteIops)
+
+	return nil
+}
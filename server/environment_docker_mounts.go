@@ -0,0 +1,115 @@
+package server
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Defines an additional mount for the server container, alongside the server data
+// directory that is always mounted.
+type Mount struct {
+	// The type of mount, either "bind", "tmpfs" or "volume".
+	Type string `json:"type"`
+	// The path on the host for bind mounts, or the name of the volume. This is ignored
+	// for tmpfs mounts.
+	Source string `json:"source"`
+	// The path the mount is available at inside of the container.
+	Target string `json:"target"`
+	// If set to true the mount cannot be written to by the server process.
+	ReadOnly bool `json:"read_only"`
+	// The size of a tmpfs mount in megabytes. Memory used by a tmpfs mount counts towards
+	// the memory limit of the server.
+	Size int64 `json:"size"`
+}
+
+// Adds the additional mounts defined for the server to the container. Every mount is
+// checked against the node configuration, and an error is returned if any are not
+// allowed so that the server is never started with only some of its mounts.
+func (d *DockerEnvironment) configureMounts(hostConf *container.HostConfig) error {
+	cfg := config.Get().Docker.Mounts
+
+	for _, m := range d.Server.Mounts {
+		target := path.Clean(m.Target)
+		if !path.IsAbs(target) || target == "/" || target == "/home/container" {
+			return errors.Errorf("mount target \"%s\" is not valid", m.Target)
+		}
+
+		switch m.Type {
+		case "tmpfs":
+			if !cfg.AllowTmpfs {
+				return errors.New("server is not allowed to use tmpfs mounts")
+			}
+
+			size := m.Size
+			if size <= 0 || (cfg.MaxTmpfsSize > 0 && size > cfg.MaxTmpfsSize) {
+				return errors.Errorf("tmpfs mount \"%s\" must have a size between 1 and %d megabytes", target, cfg.MaxTmpfsSize)
+			}
+
+			hostConf.Mounts = append(hostConf.Mounts, mount.Mount{
+				Type:         mount.TypeTmpfs,
+				Target:       target,
+				ReadOnly:     m.ReadOnly,
+				TmpfsOptions: &mount.TmpfsOptions{SizeBytes: size * 1024 * 1024, Mode: 0777},
+			})
+		case "bind":
+			// Resolve any symlinks in the source first so that a link within an allowed
+			// directory cannot be used to mount something outside of it.
+			source, err := filepath.EvalSymlinks(m.Source)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve bind mount source \"%s\"", m.Source)
+			}
+
+			if !matchesAny(source, cfg.AllowedSources) && !withinAny(source, cfg.AllowedSources) {
+				return errors.Errorf("server is not allowed to bind mount \"%s\"", m.Source)
+			}
+
+			if !m.ReadOnly && !cfg.AllowWritableBinds {
+				return errors.Errorf("bind mount \"%s\" must be read-only", m.Source)
+			}
+
+			hostConf.Mounts = append(hostConf.Mounts, mount.Mount{
+				Type:     mount.TypeBind,
+				Source:   source,
+				Target:   target,
+				ReadOnly: m.ReadOnly,
+			})
+		case "volume":
+			if !matchesAny(m.Source, cfg.AllowedVolumes) {
+				return errors.Errorf("server is not allowed to mount the volume \"%s\"", m.Source)
+			}
+
+			hostConf.Mounts = append(hostConf.Mounts, mount.Mount{
+				Type:     mount.TypeVolume,
+				Source:   m.Source,
+				Target:   target,
+				ReadOnly: m.ReadOnly,
+			})
+		default:
+			return errors.Errorf("unknown mount type \"%s\"", m.Type)
+		}
+	}
+
+	return nil
+}
+
+// Determines if the path is one of the directories, or is contained within one of them.
+// Directories containing wildcards are ignored here since they are handled by matchesAny.
+func withinAny(p string, dirs []string) bool {
+	for _, d := range dirs {
+		if strings.Contains(d, "*") {
+			continue
+		}
+
+		d = filepath.Clean(d)
+		if p == d || strings.HasPrefix(p, d+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
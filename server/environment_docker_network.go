@@ -0,0 +1,152 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+)
+
+// Configures the networking for the server container based on the network mode for the
+// server. The networking configuration returned is passed along when creating the
+// container.
+func (d *DockerEnvironment) configureNetwork(cli *client.Client, conf *container.Config, hostConf *container.HostConfig) (*network.NetworkingConfig, error) {
+	n := d.Server.Network
+	cfg := config.Get().Docker.Network
+
+	switch n.Mode {
+	case "", "bridge":
+		return nil, nil
+	case "host":
+		if !cfg.AllowHostNetworking {
+			return nil, errors.New("host networking is not allowed on this node")
+		}
+
+		if len(cfg.HostNetworkImages) > 0 && !matchesAny(d.Server.Container.Image, cfg.HostNetworkImages) {
+			return nil, errors.Errorf("host networking is not allowed for image \"%s\"", d.Server.Container.Image)
+		}
+
+		// Ports cannot be mapped when using the host network, the server process binds
+		// to them directly.
+		hostConf.NetworkMode = "host"
+		hostConf.PortBindings = nil
+		conf.ExposedPorts = nil
+
+		return nil, nil
+	case "macvlan":
+		name, err := d.ensureMacvlanNetwork(cli, n.Parent)
+		if err != nil {
+			return nil, err
+		}
+
+		// The server is reachable directly using its own address on the network, so
+		// there is no need to publish any ports on the host.
+		hostConf.NetworkMode = container.NetworkMode(name)
+		hostConf.PortBindings = nil
+
+		return &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				name: {
+					IPAMConfig: &network.EndpointIPAMConfig{
+						IPv4Address: n.Ip,
+						IPv6Address: n.Ip6,
+					},
+				},
+			},
+		}, nil
+	}
+
+	return nil, errors.Errorf("unknown network mode \"%s\"", n.Mode)
+}
+
+// Creates the macvlan network for the given parent interface if it does not already
+// exist, returning the name of the network.
+func (d *DockerEnvironment) ensureMacvlanNetwork(cli *client.Client, parent string) (string, error) {
+	cfg, ok := config.Get().Docker.Network.Macvlan[parent]
+	if !ok {
+		return "", errors.Errorf("no macvlan network is configured for the parent interface \"%s\"", parent)
+	}
+
+	name := "pterodactyl_macvlan_" + parent
+	if _, err := cli.NetworkInspect(context.Background(), name, types.NetworkInspectOptions{}); err == nil {
+		return name, nil
+	} else if !client.IsErrNotFound(err) {
+		return "", errors.WithStack(err)
+	}
+
+	zap.S().Infow("creating macvlan network for server", zap.String("server", d.Server.Uuid), zap.String("parent", parent))
+
+	_, err := cli.NetworkCreate(context.Background(), name, types.NetworkCreate{
+		Driver: "macvlan",
+		IPAM: &network.IPAM{
+			Config: []network.IPAMConfig{{Subnet: cfg.Subnet, Gateway: cfg.Gateway}},
+		},
+		Options: map[string]string{
+			"parent": parent,
+		},
+	})
+
+	return name, errors.WithStack(err)
+}
+
+// Attaches the container to any additional networks defined for the server. Only the
+// networks that are allowed in the node configuration can be used.
+func (d *DockerEnvironment) connectAdditionalNetworks(cli *client.Client, id string) error {
+	if len(d.Server.Network.Networks) == 0 || d.Server.Network.Mode == "host" {
+		return nil
+	}
+
+	allowed := config.Get().Docker.Network.AdditionalNetworks
+
+	for _, n := range d.Server.Network.Networks {
+		if !matchesAny(n, allowed) {
+			return errors.Errorf("server is not allowed to attach to the network \"%s\"", n)
+		}
+
+		if err := cli.NetworkConnect(context.Background(), n, id, nil); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
+
+// Returns the DNS servers for the container.
+func (d *DockerEnvironment) dns() []string {
+	if len(d.Server.Container.Dns) > 0 {
+		return d.Server.Container.Dns
+	}
+
+	return config.Get().Docker.Dns
+}
+
+// Returns the additional hosts file entries for the container.
+func (d *DockerEnvironment) extraHosts() []string {
+	return append(append([]string{}, config.Get().Docker.ExtraHosts...), d.Server.Container.ExtraHosts...)
+}
+
+// Returns the kernel parameters for the container, merging the node defaults with any
+// parameters set for the server. An error is returned if the server attempts to set a
+// parameter that is not allowed by the node.
+func (d *DockerEnvironment) sysctls() (map[string]string, error) {
+	cfg := config.Get().Docker
+	out := make(map[string]string)
+
+	for k, v := range cfg.Sysctls {
+		out[k] = v
+	}
+
+	for k, v := range d.Server.Container.Sysctls {
+		if !matchesAny(k, cfg.AllowedSysctls) {
+			return nil, errors.Errorf("server is not allowed to set the kernel parameter \"%s\"", k)
+		}
+
+		out[k] = v
+	}
+
+	return out, nil
+}
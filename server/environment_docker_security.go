@@ -0,0 +1,151 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/docker/docker/api/types/container"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"io/ioutil"
+	"strings"
+)
+
+// The value used to disable seccomp filtering or AppArmor for a container.
+const unconfined = "unconfined"
+
+// Defines the overrides a server can make to the confinement of its container. Empty
+// values fall back to the node configuration.
+type ContainerSecurity struct {
+	// The name of a seccomp profile defined by the node, or "unconfined".
+	Seccomp string `json:"seccomp,omitempty" yaml:"seccomp,omitempty"`
+	// The name of an AppArmor profile allowed by the node, or "unconfined".
+	AppArmor string `json:"apparmor,omitempty" yaml:"apparmor,omitempty"`
+	// Overrides the no-new-privileges setting of the node when set.
+	NoNewPrivileges *bool `json:"no_new_privileges,omitempty" yaml:"no_new_privileges,omitempty"`
+	// Additional capabilities to drop from, or add to, the container.
+	CapDrop []string `json:"cap_drop,omitempty" yaml:"cap_drop,omitempty"`
+	CapAdd  []string `json:"cap_add,omitempty" yaml:"cap_add,omitempty"`
+}
+
+// Applies the seccomp profile, AppArmor profile, capabilities and privilege settings
+// to the container, merging the node configuration with any overrides for the server.
+// An error is returned if the server attempts to loosen confinement in a way the node
+// does not allow.
+func (d *DockerEnvironment) configureSecurity(hostConf *container.HostConfig) error {
+	cfg := config.Get().Docker.Security
+	s := d.Server.Container.Security
+
+	noNewPrivileges := cfg.NoNewPrivileges
+	if s.NoNewPrivileges != nil {
+		if !*s.NoNewPrivileges && cfg.NoNewPrivileges && !cfg.AllowUnconfined {
+			return errors.New("server is not allowed to disable no-new-privileges")
+		}
+
+		noNewPrivileges = *s.NoNewPrivileges
+	}
+
+	if noNewPrivileges {
+		hostConf.SecurityOpt = append(hostConf.SecurityOpt, "no-new-privileges")
+	}
+
+	seccomp, err := seccompOption(cfg, s.Seccomp)
+	if err != nil {
+		return err
+	}
+
+	if seccomp != "" {
+		hostConf.SecurityOpt = append(hostConf.SecurityOpt, "seccomp="+seccomp)
+	}
+
+	apparmor := cfg.AppArmor
+	if s.AppArmor != "" {
+		if s.AppArmor == unconfined && !cfg.AllowUnconfined {
+			return errors.New("server is not allowed to disable AppArmor")
+		} else if s.AppArmor != unconfined && !matchesAny(s.AppArmor, cfg.AppArmorProfiles) {
+			return errors.Errorf("server is not allowed to use the AppArmor profile \"%s\"", s.AppArmor)
+		}
+
+		apparmor = s.AppArmor
+	}
+
+	if apparmor != "" {
+		hostConf.SecurityOpt = append(hostConf.SecurityOpt, "apparmor="+apparmor)
+	}
+
+	for _, c := range s.CapAdd {
+		if !matchesAny(strings.ToLower(c), cfg.AllowedCapabilities) {
+			return errors.Errorf("server is not allowed to add the capability \"%s\"", c)
+		}
+	}
+
+	// A capability added by the server takes priority over the same capability being
+	// dropped by the node, otherwise there would be no way to selectively loosen the
+	// defaults.
+	added := append(append([]string{}, cfg.CapAdd...), s.CapAdd...)
+	for _, c := range append(append([]string{}, cfg.CapDrop...), s.CapDrop...) {
+		if !containsCapability(s.CapAdd, c) {
+			hostConf.CapDrop = append(hostConf.CapDrop, c)
+		}
+	}
+
+	for _, c := range added {
+		if !containsCapability(s.CapDrop, c) {
+			hostConf.CapAdd = append(hostConf.CapAdd, c)
+		}
+	}
+
+	return nil
+}
+
+// Returns the seccomp profile to pass along to the container runtime. The runtime expects
+// the contents of the profile rather than its path, so the profile is read from the disk.
+func seccompOption(cfg config.DockerSecurityConfiguration, name string) (string, error) {
+	p := cfg.Seccomp
+	if name != "" {
+		if name == unconfined {
+			if !cfg.AllowUnconfined {
+				return "", errors.New("server is not allowed to disable seccomp filtering")
+			}
+
+			return unconfined, nil
+		}
+
+		var ok bool
+		if p, ok = cfg.SeccompProfiles[name]; !ok {
+			return "", errors.Errorf("server is not allowed to use the seccomp profile \"%s\"", name)
+		}
+	}
+
+	if p == "" || p == unconfined {
+		return p, nil
+	}
+
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to read seccomp profile")
+	}
+
+	// Compact the profile so that it can be passed along as a single option, this also
+	// makes sure the profile is valid before attempting to create the container.
+	buf := &bytes.Buffer{}
+	if err := json.Compact(buf, b); err != nil {
+		return "", errors.Wrapf(err, "seccomp profile \"%s\" is not valid JSON", p)
+	}
+
+	return buf.String(), nil
+}
+
+// Determines if the capability is in the list, ignoring case and any "CAP_" prefix.
+func containsCapability(caps []string, c string) bool {
+	normalize := func(v string) string {
+		return strings.TrimPrefix(strings.ToLower(v), "cap_")
+	}
+
+	for _, v := range caps {
+		if normalize(v) == normalize(c) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,109 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The time allowed for the process to exit after the stop sequence has finished before it
+// is sent a SIGTERM, and then after that before it is killed.
+const stopSequenceGracePeriod = time.Second * 30
+
+// Runs the stop sequence defined for the server in the background, streaming the progress
+// to the console. If the server is still running once every step has finished it is sent
+// a SIGTERM, and is killed if that does not stop it either.
+func (d *DockerEnvironment) runStopSequence(steps []api.StopStep) error {
+	for _, s := range steps {
+		if s.Type != api.ProcessStopCommand && s.Type != api.ProcessStopSignal {
+			return errors.Errorf("unknown stop sequence step type \"%s\"", s.Type)
+		}
+	}
+
+	if !atomic.CompareAndSwapInt32(&d.stopping, 0, 1) {
+		return nil
+	}
+
+	d.Server.SetState(ProcessStoppingState)
+
+	go func() {
+		defer supervisor.Recover("stop sequence")
+		defer atomic.StoreInt32(&d.stopping, 0)
+
+		for i, s := range steps {
+			if d.Server.State == ProcessOfflineState {
+				return
+			}
+
+			d.Server.PublishConsoleOutputFromDaemon(fmt.Sprintf("Running stop step %d of %d: %s %s", i+1, len(steps), s.Type, s.Value))
+
+			var err error
+			if s.Type == api.ProcessStopCommand {
+				err = d.SendCommand(s.Value)
+			} else {
+				err = d.Signal(s.Value)
+			}
+
+			if err != nil {
+				zap.S().Warnw("failed to run server stop sequence step", zap.String("server", d.Server.Uuid), zap.Int("step", i+1), zap.Error(err))
+			}
+
+			if d.waitForOffline(time.Duration(s.Wait) * time.Second) {
+				return
+			}
+		}
+
+		if d.waitForOffline(stopSequenceGracePeriod) {
+			return
+		}
+
+		d.Server.PublishConsoleOutputFromDaemon("Server did not stop after the stop sequence, sending SIGTERM...")
+		if err := d.Signal("SIGTERM"); err != nil {
+			zap.S().Warnw("failed to send SIGTERM to server", zap.String("server", d.Server.Uuid), zap.Error(err))
+		}
+
+		if d.waitForOffline(stopSequenceGracePeriod) {
+			return
+		}
+
+		d.Server.PublishConsoleOutputFromDaemon("Server did not stop after SIGTERM, killing the process...")
+		if err := d.Signal("SIGKILL"); err != nil {
+			zap.S().Errorw("failed to kill server after stop sequence", zap.String("server", d.Server.Uuid), zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Sends the named signal to the server process, without changing the state of the server.
+func (d *DockerEnvironment) Signal(name string) error {
+	name = strings.ToUpper(name)
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+
+	return errors.WithStack(d.Client.ContainerKill(context.Background(), d.Server.Uuid, name))
+}
+
+// Waits up to the given duration for the server to stop, returning true if it did.
+func (d *DockerEnvironment) waitForOffline(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		if d.Server.State == ProcessOfflineState {
+			return true
+		}
+
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(time.Millisecond * 500)
+	}
+}
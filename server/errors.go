@@ -1,5 +1,7 @@
 package server
 
+import "fmt"
+
 type suspendedError struct {
 }
 
@@ -13,6 +15,34 @@ func IsSuspendedError(err error) bool {
 	return ok
 }
 
+type groupQuotaError struct {
+	message string
+}
+
+func (e *groupQuotaError) Error() string {
+	return e.message
+}
+
+func IsGroupQuotaError(err error) bool {
+	_, ok := err.(*groupQuotaError)
+
+	return ok
+}
+
+type admissionError struct {
+	message string
+}
+
+func (e *admissionError) Error() string {
+	return e.message
+}
+
+func IsAdmissionError(err error) bool {
+	_, ok := err.(*admissionError)
+
+	return ok
+}
+
 type crashTooFrequent struct {
 }
 
@@ -37,4 +67,100 @@ func IsServerDoesNotExistError(err error) bool {
 	_, ok := err.(*serverDoesNotExist)
 
 	return ok
-}
\ No newline at end of file
+}
+
+type fileRuleError struct {
+	path   string
+	action string
+}
+
+func (e *fileRuleError) Error() string {
+	switch e.action {
+	case FileActionDelete:
+		return fmt.Sprintf("the file rules of this server do not allow \"%s\" to be deleted", e.path)
+	case FileActionExecute:
+		return fmt.Sprintf("the file rules of this server do not allow \"%s\" to be executed", e.path)
+	}
+
+	return fmt.Sprintf("the file rules of this server do not allow \"%s\" to be written", e.path)
+}
+
+// Determines if an error was returned because the file rules of the server do not allow
+// the action.
+func IsFileRuleError(err error) bool {
+	_, ok := err.(*fileRuleError)
+
+	return ok
+}
+
+type fileVersionError struct {
+	path    string
+	version string
+}
+
+func (e *fileVersionError) Error() string {
+	return fmt.Sprintf("\"%s\" has been changed since it was opened", e.path)
+}
+
+// Determines if an error was returned because a file was changed by someone else after it
+// was read for editing.
+func IsFileVersionError(err error) bool {
+	_, ok := err.(*fileVersionError)
+
+	return ok
+}
+
+// Returns the current version of the file that was changed since it was read for editing.
+func FileVersionOf(err error) string {
+	if e, ok := err.(*fileVersionError); ok {
+		return e.version
+	}
+
+	return ""
+}
+
+type fileNotEditableError struct {
+	message string
+}
+
+func (e *fileNotEditableError) Error() string {
+	return e.message
+}
+
+// Determines if an error was returned because a file is too large, or is not text, and so
+// cannot be edited inline.
+func IsFileNotEditableError(err error) bool {
+	_, ok := err.(*fileNotEditableError)
+
+	return ok
+}
+
+type trashError struct {
+	message string
+}
+
+func (e *trashError) Error() string {
+	return e.message
+}
+
+// Determines if an error was returned because a file could not be moved to the trash, in
+// which case it was not deleted.
+func IsTrashError(err error) bool {
+	_, ok := err.(*trashError)
+
+	return ok
+}
+
+type diskSpaceError struct {
+	message string
+}
+
+func (e *diskSpaceError) Error() string {
+	return e.message
+}
+
+func IsDiskSpaceError(err error) bool {
+	_, ok := err.(*diskSpaceError)
+
+	return ok
+}
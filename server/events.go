@@ -2,6 +2,7 @@ package server
 
 import (
 	"sync"
+	"sync/atomic"
 )
 
 // Defines all of the possible output events for a server.
@@ -12,6 +13,19 @@ const (
 	ConsoleOutputEvent = "console output"
 	StatusEvent        = "status"
 	StatsEvent         = "stats"
+	HealthEvent        = "health"
+	CrashLoopEvent     = "crash loop"
+	OomEvent           = "oom"
+	BootTimelineEvent  = "boot timeline"
+	IntegrityEvent     = "integrity violation"
+	PlayersEmptyEvent  = "players empty"
+	PlayersFullEvent   = "players full"
+	FileOperationEvent = "file operation"
+	StopReasonEvent    = "stop reason"
+
+	// Lines of output from the server process before any console transforms are applied.
+	// This is only used within the daemon and is never sent to clients.
+	ProcessOutputEvent = "process output"
 )
 
 type Event struct {
@@ -19,16 +33,63 @@ type Event struct {
 	Topic string
 }
 
+// The number of events that have been published but not yet delivered to every listener,
+// across all of the servers on the node.
+var pendingEvents int64
+
+// Returns the number of events waiting to be delivered to listeners. A growing number
+// means that listeners are not reading events as fast as they are published.
+func PendingEvents() int64 {
+	return atomic.LoadInt64(&pendingEvents)
+}
+
 type EventBus struct {
-	subscribers map[string][]chan Event
+	subscribers map[string][]*subscription
 	mu          sync.Mutex
 }
 
+// A channel subscribed to a topic. Events are delivered to the channel in the background,
+// so the subscription tracks when it has been removed to stop any delivery that is still
+// waiting to send once the subscriber is no longer reading from the channel.
+type subscription struct {
+	ch   chan Event
+	done chan struct{}
+	// Held for reading while an event is being sent to the channel.
+	mu      sync.RWMutex
+	removed bool
+}
+
+// Sends the event to the channel, unless the subscription is removed first.
+func (s *subscription) deliver(e Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.removed {
+		return
+	}
+
+	select {
+	case s.ch <- e:
+	case <-s.done:
+	}
+}
+
+// Marks the subscription as removed, waiting for any event that is being sent to the
+// channel to be abandoned. Once this returns nothing else is sent to the channel, so the
+// subscriber is free to close it.
+func (s *subscription) remove() {
+	close(s.done)
+
+	s.mu.Lock()
+	s.removed = true
+	s.mu.Unlock()
+}
+
 // Returns the server's emitter instance.
 func (s *Server) Events() *EventBus {
 	if s.emitter == nil {
 		s.emitter = &EventBus{
-			subscribers: map[string][]chan Event{},
+			subscribers: map[string][]*subscription{},
 		}
 	}
 
@@ -40,12 +101,18 @@ func (e *EventBus) Publish(topic string, data string) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if ch, ok := e.subscribers[topic]; ok {
-		go func(data Event, cs []chan Event) {
-			for _, channel := range cs {
-				channel <- data
+	if subs, ok := e.subscribers[topic]; ok && len(subs) > 0 {
+		atomic.AddInt64(&pendingEvents, 1)
+
+		// The slice of subscribers is never modified in place, so it can be used after the
+		// lock is released.
+		go func(data Event, subs []*subscription) {
+			defer atomic.AddInt64(&pendingEvents, -1)
+
+			for _, sub := range subs {
+				sub.deliver(data)
 			}
-		}(Event{Data: data, Topic: topic}, ch)
+		}(Event{Data: data, Topic: topic}, subs)
 	}
 }
 
@@ -54,23 +121,33 @@ func (e *EventBus) Subscribe(topic string, ch chan Event) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	if p, ok := e.subscribers[topic]; ok {
-		e.subscribers[topic] = append(p, ch)
-	} else {
-		e.subscribers[topic] = append([]chan Event{}, ch)
-	}
+	subs := make([]*subscription, 0, len(e.subscribers[topic])+1)
+	subs = append(subs, e.subscribers[topic]...)
+
+	e.subscribers[topic] = append(subs, &subscription{ch: ch, done: make(chan struct{})})
 }
 
-// Unsubscribe a channel from a topic.
+// Unsubscribe a channel from a topic. Once this returns no more events are sent to the
+// channel for the topic, so it can be closed once it is unsubscribed from every topic.
 func (e *EventBus) Unsubscribe(topic string, ch chan Event) {
 	e.mu.Lock()
-	defer e.mu.Unlock()
 
-	if _, ok := e.subscribers[topic]; ok {
-		for i := range e.subscribers[topic] {
-			if ch == e.subscribers[topic][i] {
-				e.subscribers[topic] = append(e.subscribers[topic][:i], e.subscribers[topic][i+1:]...)
-			}
+	var removed []*subscription
+	subs := make([]*subscription, 0, len(e.subscribers[topic]))
+	for _, sub := range e.subscribers[topic] {
+		if sub.ch == ch {
+			removed = append(removed, sub)
+		} else {
+			subs = append(subs, sub)
 		}
 	}
-}
\ No newline at end of file
+	e.subscribers[topic] = subs
+
+	e.mu.Unlock()
+
+	// Wait for any delivery to the channel outside of the lock, so that publishing is not
+	// held up while it is abandoned.
+	for _, sub := range removed {
+		sub.remove()
+	}
+}
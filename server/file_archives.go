@@ -0,0 +1,572 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// The formats that archives can be created in and extracted from.
+const (
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// Returns the format of an archive using the extension of its name, or an empty string if
+// the format is not supported.
+func archiveFormat(name string) string {
+	name = strings.ToLower(name)
+
+	switch {
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return ArchiveTarGz
+	case strings.HasSuffix(name, ".zip"):
+		return ArchiveZip
+	}
+
+	return ""
+}
+
+// Returns the format the archive for a compress operation is created in, which is either
+// the requested format or the format matching the name of the archive.
+func (r FileOperationRequest) archiveFormat() string {
+	if r.Format != "" {
+		return r.Format
+	}
+
+	return archiveFormat(r.Destination)
+}
+
+// Resolves the location of the archive created by a compress operation, which must be
+// within the data directory of the server and must not exist yet.
+func (s *Server) resolveArchiveDestination(req FileOperationRequest) (string, error) {
+	if req.Destination == "" {
+		return "", errors.New("no destination provided for the archive")
+	}
+
+	switch req.archiveFormat() {
+	case ArchiveTarGz, ArchiveZip:
+	case "":
+		return "", errors.New("the archive format could not be determined from the destination")
+	default:
+		return "", errors.Errorf("unknown archive format \"%s\"", req.Format)
+	}
+
+	p, err := s.Filesystem.SafePath(path.Join(req.Root, req.Destination))
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid path \"%s\"", req.Destination)
+	}
+
+	if _, err := os.Lstat(p); err == nil {
+		return "", errors.Errorf("\"%s\" already exists", req.Destination)
+	}
+
+	if err := s.Filesystem.checkFileRules(p, FileActionWrite); err != nil {
+		return "", err
+	}
+
+	return p, nil
+}
+
+// Checks that an archive is within the extraction limits, returning the number of files
+// and the number of bytes it expands to. The sizes recorded in an archive are not trusted, the limits
+// are checked again as the files are written.
+func (s *Server) scanArchive(src string) (int64, int64, error) {
+	cfg := config.Get().Api.Archives
+
+	f, err := s.openArchive(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var entries int
+	var files, size int64
+	err = walkArchive(f, func(_ string, info os.FileInfo, _ func() (io.ReadCloser, error)) error {
+		entries++
+		if cfg.MaxEntries > 0 && entries > cfg.MaxEntries {
+			return errors.Errorf("archive contains more than %d files", cfg.MaxEntries)
+		}
+
+		if info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if cfg.MaxExtractSize > 0 && size > cfg.MaxExtractSize*1000*1000 {
+		return 0, 0, errors.Errorf("archive expands to more than %d MB", cfg.MaxExtractSize)
+	}
+
+	return files, size, nil
+}
+
+// Opens an archive for reading. Archives within the data directory of the server are
+// opened without following symlinks, since the server can replace them at any time.
+func (s *Server) openArchive(src string) (*os.File, error) {
+	root := s.Filesystem.Path()
+	if strings.HasPrefix(src, root+string(filepath.Separator)) {
+		f, _, err := openFileBeneath(root, src)
+
+		return f, err
+	}
+
+	f, err := os.Open(src)
+
+	return f, errors.WithStack(err)
+}
+
+// Calls the function for every entry of the open archive in the order they are stored.
+// The contents of an entry can only be opened while the function is running.
+func walkArchive(f *os.File, fn func(name string, info os.FileInfo, open func() (io.ReadCloser, error)) error) error {
+	switch archiveFormat(f.Name()) {
+	case ArchiveTarGz:
+		gr, err := gzip.NewReader(f)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer gr.Close()
+
+		tr := tar.NewReader(gr)
+		for {
+			header, err := tr.Next()
+			if err == io.EOF {
+				return nil
+			} else if err != nil {
+				return errors.WithStack(err)
+			}
+
+			open := func() (io.ReadCloser, error) {
+				return ioutil.NopCloser(tr), nil
+			}
+
+			if err := fn(header.Name, header.FileInfo(), open); err != nil {
+				return err
+			}
+		}
+	case ArchiveZip:
+		st, err := f.Stat()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		zr, err := zip.NewReader(f, st.Size())
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		for _, f := range zr.File {
+			if err := fn(f.Name, f.FileInfo(), f.Open); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	return errors.New("archive format is not supported")
+}
+
+// Extracts an archive that exists within the server data directory into the given
+// directory, with the same limits as archives extracted through a file operation. Both
+// gzip compressed tarballs and zip archives are supported, the format is determined using
+// the file extension.
+func (fs *Filesystem) ExtractArchive(src string, dir string) error {
+	cleaned, err := fs.SafePath(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	dest, err := fs.SafePath(dir)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return fs.Server.extractArchiveTo(cleaned, dest)
+}
+
+// Extracts an archive anywhere on the disk into a directory of the server, limiting it to
+// the disk space the server has left.
+func (s *Server) extractArchiveTo(src string, dest string) error {
+	d, err := mkdirAllBeneath(s.Filesystem.Path(), dest)
+	if err != nil {
+		return err
+	}
+	d.Close()
+
+	var available int64 = -1
+	if limit := s.Build.DiskSpace; limit > 0 {
+		available = limit*1000*1000 - s.Filesystem.cachedDiskUsage()
+	}
+
+	err = s.extractArchive(nil, src, dest, &available)
+
+	s.Filesystem.InvalidateCache()
+
+	return err
+}
+
+// Extracts an archive into the destination directory. Entries are written relative to the
+// destination and any entry that would end up outside of it is refused, along with links
+// and other special files. Files that were extracted before a failure are left in place.
+// Existing files are replaced rather than written through, and no part of the destination
+// is followed through a symlink, since the server can change its files while the archive
+// is being extracted.
+// Entries that the file rules of the server do not allow to be written are skipped and
+// recorded as errors of the operation, or logged when there is no operation.
+func (s *Server) extractArchive(op *FileOperation, src string, dest string, available *int64) error {
+	cfg := config.Get().Api.Archives
+
+	var remaining int64 = -1
+	if cfg.MaxExtractSize > 0 {
+		remaining = cfg.MaxExtractSize * 1000 * 1000
+	}
+
+	root := s.Filesystem.Path()
+	rel := strings.TrimPrefix(dest, root)
+
+	f, err := s.openArchive(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var entries int
+	err = walkArchive(f, func(name string, info os.FileInfo, open func() (io.ReadCloser, error)) error {
+		if op != nil && op.cancelled() {
+			return errors.New("operation was cancelled")
+		}
+
+		entries++
+		if cfg.MaxEntries > 0 && entries > cfg.MaxEntries {
+			return errors.Errorf("archive contains more than %d files", cfg.MaxEntries)
+		}
+
+		name = strings.Replace(name, "\\", "/", -1)
+		for _, part := range strings.Split(name, "/") {
+			if part == ".." {
+				return errors.Errorf("archive entry \"%s\" is outside of the destination directory", name)
+			}
+		}
+
+		target, err := s.Filesystem.SafePath(filepath.Join(rel, strings.TrimPrefix(name, "/")))
+		if err != nil {
+			return errors.Wrapf(err, "invalid archive entry \"%s\"", name)
+		}
+
+		if target != dest && !strings.HasPrefix(target, dest+string(filepath.Separator)) {
+			return errors.Errorf("archive entry \"%s\" is outside of the destination directory", name)
+		}
+
+		if err := s.Filesystem.checkFileRules(target, FileActionWrite); err != nil {
+			if op == nil {
+				zap.S().Warnw("skipping archive entry denied by file rules", zap.String("server", s.Uuid), zap.String("entry", name), zap.Error(err))
+
+				return nil
+			}
+
+			fileOperations.Lock()
+			op.Errors = append(op.Errors, FileOperationError{Path: name, Error: err.Error()})
+			fileOperations.Unlock()
+
+			return nil
+		}
+
+		if info.IsDir() {
+			d, err := mkdirAllBeneath(root, target)
+			if err != nil {
+				return err
+			}
+
+			return d.Close()
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		r, err := open()
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer r.Close()
+
+		d, err := mkdirAllBeneath(root, filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		d.Close()
+
+		if st, err := lstatBeneath(root, target); err == nil && st.Mode&unix.S_IFMT == unix.S_IFDIR {
+			return errors.Errorf("archive entry \"%s\" would replace a directory", name)
+		}
+
+		if err := removeBeneath(root, target); err != nil {
+			return err
+		}
+
+		f, err := openBeneath(root, target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, s.Filesystem.FileRuleMode(target, info.Mode().Perm()|0600))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w := &quotaWriter{
+			w:         f,
+			available: available,
+			err:       &diskSpaceError{message: "extracting the archive would exceed the disk space available to the server"},
+		}
+
+		n, err := io.Copy(&quotaWriter{w: w, available: &remaining, err: errors.Errorf("archive expands to more than %d MB", cfg.MaxExtractSize)}, r)
+		if err != nil {
+			return err
+		}
+
+		if op == nil {
+			return nil
+		}
+
+		fileOperations.Lock()
+		op.ProcessedFiles++
+		op.ProcessedBytes += n
+		fileOperations.Unlock()
+
+		s.publishFileOperation(op, false)
+
+		return nil
+	})
+
+	if cerr := lchownTreeBeneath(root, dest, s.Filesystem.Configuration.User.Uid, s.Filesystem.Configuration.User.Gid); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Creates an archive containing every path of the operation. Paths within the archive are
+// relative to the root of the request, and symbolic links are skipped. The archive is
+// removed if it could not be completed.
+func (s *Server) compressFiles(op *FileOperation, paths []resolvedFileOperationPath, available *int64) error {
+	archive := paths[0].to
+
+	base, err := s.Filesystem.SafePath(op.Request.Root)
+	if err != nil {
+		base = s.Filesystem.Path()
+	}
+
+	root := s.Filesystem.Path()
+
+	f, err := openBeneath(root, archive, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := &quotaWriter{
+		w:         f,
+		available: available,
+		err:       &diskSpaceError{message: "the archive would exceed the disk space available to the server"},
+	}
+
+	var aw archiveWriter
+	if op.Request.archiveFormat() == ArchiveZip {
+		aw = &zipArchiveWriter{root: root, zw: zip.NewWriter(w)}
+	} else {
+		gw := gzip.NewWriter(w)
+		aw = &tarGzArchiveWriter{root: root, gw: gw, tw: tar.NewWriter(gw)}
+	}
+
+	for _, p := range paths {
+		err = filepath.Walk(p.from, func(fp string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if op.cancelled() {
+				return errors.New("operation was cancelled")
+			}
+
+			if fp == archive || (!info.IsDir() && !info.Mode().IsRegular()) {
+				return nil
+			}
+
+			name, err := filepath.Rel(base, fp)
+			if err != nil || strings.HasPrefix(name, "..") {
+				name, _ = filepath.Rel(s.Filesystem.Path(), fp)
+			}
+
+			if name == "." {
+				return nil
+			}
+
+			if err := aw.add(filepath.ToSlash(name), fp, info); err != nil {
+				return err
+			}
+
+			if info.Mode().IsRegular() {
+				fileOperations.Lock()
+				op.ProcessedFiles++
+				op.ProcessedBytes += info.Size()
+				fileOperations.Unlock()
+
+				s.publishFileOperation(op, false)
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			break
+		}
+	}
+
+	if cerr := aw.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = errors.WithStack(f.Chown(s.Filesystem.Configuration.User.Uid, s.Filesystem.Configuration.User.Gid))
+	}
+
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = errors.WithStack(cerr)
+	}
+
+	if err != nil {
+		removeBeneath(root, archive)
+
+		return err
+	}
+
+	return nil
+}
+
+// Writes files into an archive.
+type archiveWriter interface {
+	add(name string, p string, info os.FileInfo) error
+	Close() error
+}
+
+type tarGzArchiveWriter struct {
+	root string
+	gw   *gzip.Writer
+	tw   *tar.Writer
+}
+
+func (a *tarGzArchiveWriter) add(name string, p string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if err := a.tw.WriteHeader(header); err != nil {
+		return errors.WithStack(err)
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	return copyIntoArchive(a.tw, a.root, p, info.Size())
+}
+
+func (a *tarGzArchiveWriter) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(a.gw.Close())
+}
+
+type zipArchiveWriter struct {
+	root string
+	zw   *zip.Writer
+}
+
+func (a *zipArchiveWriter) add(name string, p string, info os.FileInfo) error {
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+
+	w, err := a.zw.CreateHeader(header)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if info.IsDir() {
+		return nil
+	}
+
+	return copyIntoArchive(w, a.root, p, info.Size())
+}
+
+func (a *zipArchiveWriter) Close() error {
+	return errors.WithStack(a.zw.Close())
+}
+
+// Copies the contents of a file within the root into the archive. The file is opened
+// without following symlinks, and exactly the size recorded in the header of the entry
+// is copied, so a file that was replaced after it was listed cannot be read in its place
+// or corrupt the archive.
+func copyIntoArchive(w io.Writer, root string, p string, size int64) error {
+	f, info, err := openFileBeneath(root, p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if info.Size() < size {
+		return errors.Errorf("%s changed while it was being archived", p)
+	}
+
+	_, err = io.CopyN(w, f, size)
+
+	return errors.WithStack(err)
+}
+
+// Passes writes through to another writer, returning the error once more bytes have been
+// written than are available. Writes are not limited if the available bytes are negative.
+type quotaWriter struct {
+	w         io.Writer
+	available *int64
+	err       error
+}
+
+func (q *quotaWriter) Write(b []byte) (int, error) {
+	if *q.available >= 0 {
+		if int64(len(b)) > *q.available {
+			return 0, q.err
+		}
+
+		*q.available -= int64(len(b))
+	}
+
+	return q.w.Write(b)
+}
@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+	"unicode/utf8"
+)
+
+// A text file of a server opened for editing, along with the version the edited contents
+// must be saved against.
+type EditableFile struct {
+	Contents   string    `json:"contents"`
+	Version    string    `json:"version"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// Returns the version of a file with the given contents. The version is a hash of the
+// contents rather than the modification time, so an edit is only rejected if the contents
+// of the file actually changed.
+func fileVersion(b []byte) string {
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:])
+}
+
+// Returns an error if the contents cannot be edited inline, either because they are larger
+// than the configured limit or are not text.
+func checkEditable(file string, b []byte) error {
+	limit := config.Get().Api.EditLimit * 1024
+	if limit > 0 && int64(len(b)) > limit {
+		return &fileNotEditableError{message: fmt.Sprintf("\"%s\" is larger than the %d KB limit for editing files", file, limit/1024)}
+	}
+
+	if bytes.IndexByte(b, 0) != -1 || !utf8.Valid(b) {
+		return &fileNotEditableError{message: fmt.Sprintf("\"%s\" is not a text file", file)}
+	}
+
+	return nil
+}
+
+// Reads the current contents of a file for editing. The size of the file is checked once
+// it is open, and no more than the limit is ever read, so that a large file is never
+// loaded into memory just to be refused.
+func (s *Server) readEditableFile(file string, p string) ([]byte, os.FileInfo, error) {
+	f, st, err := s.Filesystem.OpenFile(p)
+	if err != nil {
+		if os.IsNotExist(errors.Cause(err)) {
+			return nil, nil, err
+		}
+
+		if _, serr := os.Lstat(p); serr == nil {
+			return nil, nil, &fileNotEditableError{message: fmt.Sprintf("\"%s\" is not a file", file)}
+		}
+
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	limit := config.Get().Api.EditLimit * 1024
+	if limit > 0 && st.Size() > limit {
+		return nil, nil, &fileNotEditableError{message: fmt.Sprintf("\"%s\" is larger than the %d KB limit for editing files", file, limit/1024)}
+	}
+
+	var r io.Reader = f
+	if limit > 0 {
+		// The file can grow after it was checked, reading one byte past the limit is
+		// enough for checkEditable to refuse it.
+		r = io.LimitReader(f, limit+1)
+	}
+
+	b, err := ioutil.ReadAll(r)
+
+	return b, st, errors.WithStack(err)
+}
+
+// Reads a text file of the server for editing inline. The version returned must be passed
+// back when the file is saved.
+func (s *Server) ReadEditableFile(file string) (*EditableFile, error) {
+	p, err := s.Filesystem.SafePath(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	b, st, err := s.readEditableFile(file, p)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkEditable(file, b); err != nil {
+		return nil, err
+	}
+
+	return &EditableFile{
+		Contents:   string(b),
+		Version:    fileVersion(b),
+		Size:       int64(len(b)),
+		ModifiedAt: st.ModTime(),
+	}, nil
+}
+
+// Saves the edited contents of a file, as long as the file has not been changed since the
+// given version was read. An empty version creates the file, and fails if it already
+// exists. The version of the saved contents is returned.
+func (s *Server) WriteEditableFile(file string, contents []byte, version string) (string, error) {
+	if err := checkEditable(file, contents); err != nil {
+		return "", err
+	}
+
+	p, err := s.Filesystem.SafePath(file)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	s.editLock.Lock()
+	defer s.editLock.Unlock()
+
+	current := ""
+	if b, _, err := s.readEditableFile(file, p); err == nil {
+		current = fileVersion(b)
+	} else if !os.IsNotExist(errors.Cause(err)) {
+		return "", err
+	}
+
+	if current != version {
+		return "", &fileVersionError{path: file, version: current}
+	}
+
+	if err := s.WriteFile(file, bytes.NewReader(contents)); err != nil {
+		return "", err
+	}
+
+	return fileVersion(contents), nil
+}
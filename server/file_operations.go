@@ -0,0 +1,607 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The actions that can be applied to a batch of files.
+const (
+	FileOperationCopy   = "copy"
+	FileOperationMove   = "move"
+	FileOperationDelete = "delete"
+
+	// Creates a single archive containing every path, or extracts each archive into its
+	// destination directory.
+	FileOperationCompress   = "compress"
+	FileOperationDecompress = "decompress"
+
+	// Downloads each file from a remote URL into the data directory of the server.
+	FileOperationPull = "pull"
+)
+
+// The states of a file operation.
+const (
+	FileOperationRunning   = "running"
+	FileOperationComplete  = "complete"
+	FileOperationFailed    = "failed"
+	FileOperationCancelled = "cancelled"
+)
+
+// The most paths that can be included in a single file operation.
+const fileOperationMaxPaths = 1000
+
+// The number of finished file operations kept for each server so that their results can
+// be retrieved.
+const fileOperationHistorySize = 10
+
+// The minimum time between progress events for a running file operation.
+const fileOperationProgressInterval = time.Second
+
+// A single file or directory within a file operation. Paths are relative to the root of
+// the request. The destination is not used when deleting or compressing, and defaults to
+// the directory containing the archive when decompressing.
+//
+// When pulling files the source is the URL of the file, and the destination defaults to
+// the name of the file in the URL. The file is checked against the SHA-256 checksum if
+// one is provided.
+type FileOperationPath struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Checksum string `json:"checksum,omitempty"`
+}
+
+// The request to copy, move, delete, compress, decompress or download a batch of files. Renaming a
+// file is a move within the same directory.
+type FileOperationRequest struct {
+	Action string              `json:"action"`
+	Root   string              `json:"root"`
+	Files  []FileOperationPath `json:"files"`
+
+	// The archive created when compressing files, and its format. The format is determined
+	// using the extension of the archive if it is not set.
+	Destination string `json:"destination,omitempty"`
+	Format      string `json:"format,omitempty"`
+}
+
+// A failure for a single path within a file operation.
+type FileOperationError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+// A batch of file changes running in the background on the node. A failure for one path
+// is recorded against it and the remaining paths are still processed.
+type FileOperation struct {
+	Id         string               `json:"id"`
+	Server     string               `json:"server"`
+	Request    FileOperationRequest `json:"request"`
+	Status     string               `json:"status"`
+	StartedAt  time.Time            `json:"started_at"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+
+	// The number of files and bytes to process, and the number processed so far. Copies
+	// and archives count every file within them, moves and deletes count each path.
+	TotalFiles     int64 `json:"total_files"`
+	TotalBytes     int64 `json:"total_bytes"`
+	ProcessedFiles int64 `json:"processed_files"`
+	ProcessedBytes int64 `json:"processed_bytes"`
+
+	Errors []FileOperationError `json:"errors"`
+
+	cancel       chan struct{}
+	lastProgress time.Time
+}
+
+// A path of a file operation once it has been resolved to a location on the disk.
+type resolvedFileOperationPath struct {
+	FileOperationPath
+	from string
+	to   string
+}
+
+var fileOperations = struct {
+	sync.Mutex
+	operations map[string][]*FileOperation
+}{operations: make(map[string][]*FileOperation)}
+
+// Returns a copy of the operation that is safe to use without holding the lock.
+func (o *FileOperation) copy() FileOperation {
+	c := *o
+	c.Errors = append([]FileOperationError{}, o.Errors...)
+
+	return c
+}
+
+// Returns the file operations for the server that are running or recently finished, from
+// oldest to newest.
+func (s *Server) FileOperations() []FileOperation {
+	fileOperations.Lock()
+	defer fileOperations.Unlock()
+
+	out := []FileOperation{}
+	for _, o := range fileOperations.operations[s.Uuid] {
+		out = append(out, o.copy())
+	}
+
+	return out
+}
+
+// Returns a single file operation for the server.
+func (s *Server) GetFileOperation(id string) (FileOperation, bool) {
+	fileOperations.Lock()
+	defer fileOperations.Unlock()
+
+	for _, o := range fileOperations.operations[s.Uuid] {
+		if o.Id == id {
+			return o.copy(), true
+		}
+	}
+
+	return FileOperation{}, false
+}
+
+// Stops a running file operation once the file currently being processed is finished.
+// Returns false if there is no running operation with the id.
+func (s *Server) CancelFileOperation(id string) bool {
+	fileOperations.Lock()
+	defer fileOperations.Unlock()
+
+	for _, o := range fileOperations.operations[s.Uuid] {
+		if o.Id == id && o.Status == FileOperationRunning {
+			o.Status = FileOperationCancelled
+			close(o.cancel)
+
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validates the request and starts processing it in the background. Every path is checked
+// before anything is changed, so an invalid path fails the entire request. Copies are
+// refused if the files being copied would not fit in the disk space of the server.
+func (s *Server) StartFileOperation(req FileOperationRequest) (*FileOperation, error) {
+	switch req.Action {
+	case FileOperationCopy, FileOperationMove, FileOperationDelete, FileOperationCompress, FileOperationDecompress:
+	case FileOperationPull:
+		if !config.Get().Api.RemoteDownloads.Enabled {
+			return nil, errors.New("downloading files from a remote url is disabled on this node")
+		}
+	default:
+		return nil, errors.Errorf("unknown file operation \"%s\"", req.Action)
+	}
+
+	if len(req.Files) == 0 {
+		return nil, errors.New("no files were provided")
+	} else if len(req.Files) > fileOperationMaxPaths {
+		return nil, errors.Errorf("no more than %d files can be changed at once", fileOperationMaxPaths)
+	}
+
+	op := &FileOperation{
+		Id:        uuid.New().String(),
+		Server:    s.Uuid,
+		Request:   req,
+		Status:    FileOperationRunning,
+		StartedAt: time.Now().UTC(),
+		Errors:    []FileOperationError{},
+		cancel:    make(chan struct{}),
+	}
+
+	paths, err := s.resolveFileOperation(req)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, p := range paths {
+		var files, size int64
+		switch req.Action {
+		case FileOperationCopy, FileOperationCompress:
+			files, size, err = treeSize(p.from)
+		case FileOperationDecompress:
+			files, size, err = s.scanArchive(p.from)
+		default:
+			op.TotalFiles++
+			continue
+		}
+
+		if err != nil {
+			return nil, errors.Wrapf(err, "could not read \"%s\"", p.From)
+		}
+
+		op.TotalFiles += files
+		op.TotalBytes += size
+	}
+
+	if req.Action == FileOperationCopy && !s.Filesystem.hasSpaceFor(op.TotalBytes) {
+		return nil, &diskSpaceError{message: "copying the files would exceed the disk space available to the server"}
+	}
+
+	if req.Action == FileOperationDecompress && !s.Filesystem.hasSpaceFor(op.TotalBytes) {
+		return nil, &diskSpaceError{message: "extracting the archives would exceed the disk space available to the server"}
+	}
+
+	fileOperations.Lock()
+	ops := append(fileOperations.operations[s.Uuid], op)
+	// Only finished operations are removed from the history, so that a running operation
+	// can always be found again.
+	for len(ops) > fileOperationHistorySize && ops[0].Status != FileOperationRunning {
+		ops = ops[1:]
+	}
+	fileOperations.operations[s.Uuid] = ops
+	c := op.copy()
+	fileOperations.Unlock()
+
+	go func() {
+		defer supervisor.Recover("file operation")
+
+		s.runFileOperation(op, paths)
+	}()
+
+	return &c, nil
+}
+
+// Resolves every path of the request, checking that each one is within the data
+// directory of the server and that the files being acted on exist.
+func (s *Server) resolveFileOperation(req FileOperationRequest) ([]resolvedFileOperationPath, error) {
+	var out []resolvedFileOperationPath
+
+	var archive string
+	if req.Action == FileOperationCompress {
+		var err error
+		if archive, err = s.resolveArchiveDestination(req); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, f := range req.Files {
+		r := resolvedFileOperationPath{FileOperationPath: f}
+
+		if req.Action == FileOperationPull {
+			u, err := url.Parse(f.From)
+			if err != nil {
+				return nil, errors.Errorf("invalid url \"%s\"", f.From)
+			}
+
+			if err := validatePullUrl(u); err != nil {
+				return nil, err
+			}
+
+			if _, err := hex.DecodeString(f.Checksum); err != nil || (f.Checksum != "" && len(f.Checksum) != sha256.Size*2) {
+				return nil, errors.Errorf("invalid sha256 checksum for \"%s\"", f.From)
+			}
+
+			if r.to, err = s.resolvePullDestination(req.Root, u, f.To); err != nil {
+				return nil, err
+			}
+
+			if err := s.Filesystem.checkFileRules(r.to, FileActionWrite); err != nil {
+				return nil, err
+			}
+
+			for _, o := range out {
+				if o.to == r.to {
+					return nil, errors.Errorf("more than one file would be downloaded to \"%s\"", f.To)
+				}
+			}
+
+			out = append(out, r)
+			continue
+		}
+
+		from, err := s.Filesystem.SafePath(path.Join(req.Root, f.From))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid path \"%s\"", f.From)
+		}
+
+		if from == s.Filesystem.Path() && req.Action != FileOperationCompress {
+			return nil, errors.New("the root directory of the server cannot be changed")
+		}
+
+		st, err := os.Lstat(from)
+		if err != nil {
+			return nil, errors.Errorf("\"%s\" does not exist", f.From)
+		}
+
+		r.from = from
+
+		switch req.Action {
+		case FileOperationDelete:
+			if err := s.Filesystem.checkFileRulesTree(from, FileActionDelete); err != nil {
+				return nil, err
+			}
+		case FileOperationCompress:
+			r.to = archive
+		case FileOperationDecompress:
+			if !st.Mode().IsRegular() || archiveFormat(from) == "" {
+				return nil, errors.Errorf("\"%s\" is not a supported archive", f.From)
+			}
+
+			r.to = filepath.Dir(from)
+			if f.To != "" {
+				if r.to, err = s.Filesystem.SafePath(path.Join(req.Root, f.To)); err != nil {
+					return nil, errors.Wrapf(err, "invalid path \"%s\"", f.To)
+				}
+			}
+
+			if st, err := os.Stat(r.to); err == nil && !st.IsDir() {
+				return nil, errors.Errorf("\"%s\" is not a directory", f.To)
+			}
+		default:
+			if f.To == "" {
+				return nil, errors.Errorf("no destination provided for \"%s\"", f.From)
+			}
+
+			to, err := s.Filesystem.SafePath(path.Join(req.Root, f.To))
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid path \"%s\"", f.To)
+			}
+
+			if to == from || strings.HasPrefix(to, from+string(filepath.Separator)) {
+				return nil, errors.Errorf("\"%s\" cannot be placed inside of itself", f.From)
+			}
+
+			check := s.Filesystem.checkFileRulesCopy
+			if req.Action == FileOperationMove {
+				check = s.Filesystem.checkFileRulesMove
+			}
+
+			if err := check(from, to); err != nil {
+				return nil, err
+			}
+
+			r.to = to
+		}
+
+		out = append(out, r)
+	}
+
+	return out, nil
+}
+
+// Processes every path of the operation, publishing the progress to any listeners.
+func (s *Server) runFileOperation(op *FileOperation, paths []resolvedFileOperationPath) {
+	// The space that can still be used by the files being written, checked as they are
+	// written in case the server writes files while the operation is running.
+	var available int64 = -1
+	if limit := s.Build.DiskSpace; limit > 0 && op.writesFiles() {
+		available = limit*1000*1000 - s.Filesystem.cachedDiskUsage()
+	}
+
+	// Every path is written into the same archive when compressing.
+	if op.Request.Action == FileOperationCompress {
+		if err := s.compressFiles(op, paths, &available); err != nil {
+			fileOperations.Lock()
+			if op.Status != FileOperationCancelled {
+				op.Errors = append(op.Errors, FileOperationError{Path: op.Request.Destination, Error: err.Error()})
+			}
+			fileOperations.Unlock()
+		}
+
+		paths = nil
+	}
+
+	for _, p := range paths {
+		if op.cancelled() {
+			break
+		}
+
+		var err error
+		switch op.Request.Action {
+		case FileOperationCopy:
+			err = s.copyTree(op, p.from, p.to, &available)
+		case FileOperationMove:
+			if _, serr := lstatBeneath(s.Filesystem.Path(), p.to); serr == nil {
+				err = errors.New("destination already exists")
+			} else {
+				err = renameBeneath(s.Filesystem.Path(), p.from, p.to)
+			}
+		case FileOperationDelete:
+			err = s.Filesystem.remove(p.from)
+		case FileOperationDecompress:
+			err = s.extractArchive(op, p.from, p.to, &available)
+		case FileOperationPull:
+			err = s.pullFile(op, p, &available)
+		}
+
+		fileOperations.Lock()
+		if err != nil && op.Status != FileOperationCancelled {
+			op.Errors = append(op.Errors, FileOperationError{Path: p.From, Error: err.Error()})
+		}
+
+		if !op.writesFiles() {
+			op.ProcessedFiles++
+		}
+		fileOperations.Unlock()
+
+		s.publishFileOperation(op, false)
+	}
+
+	s.Filesystem.InvalidateCache()
+
+	fileOperations.Lock()
+	now := time.Now().UTC()
+	op.FinishedAt = &now
+	if op.Status == FileOperationRunning {
+		op.Status = FileOperationComplete
+		if len(op.Errors) > 0 {
+			op.Status = FileOperationFailed
+		}
+	}
+	fileOperations.Unlock()
+
+	if len(op.Errors) > 0 {
+		zap.S().Warnw("file operation finished with errors", zap.String("server", s.Uuid), zap.String("operation", op.Id), zap.Int("errors", len(op.Errors)))
+	}
+
+	s.publishFileOperation(op, true)
+}
+
+// Copies a file or directory to the destination, which must not exist yet. Symbolic links
+// and other special files are skipped. The server keeps running during the copy, so every
+// file is opened and created without following symlinks, and is never written to or has
+// its owner changed through a path that could have been swapped.
+func (s *Server) copyTree(op *FileOperation, from string, to string, available *int64) error {
+	root := s.Filesystem.Path()
+	if _, err := lstatBeneath(root, to); err == nil {
+		return errors.New("destination already exists")
+	}
+
+	return filepath.Walk(from, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if op.cancelled() {
+			return errors.New("operation was cancelled")
+		}
+
+		target := filepath.Join(to, strings.TrimPrefix(p, from))
+		uid, gid := s.Filesystem.Configuration.User.Uid, s.Filesystem.Configuration.User.Gid
+
+		if info.IsDir() {
+			d, err := mkdirAllBeneath(root, target)
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			if err := d.Chmod(info.Mode().Perm() | 0700); err != nil {
+				return err
+			}
+
+			return d.Chown(uid, gid)
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		if *available >= 0 {
+			if info.Size() > *available {
+				return &diskSpaceError{message: "copying the files would exceed the disk space available to the server"}
+			}
+
+			*available -= info.Size()
+		}
+
+		d, err := mkdirAllBeneath(root, filepath.Dir(target))
+		if err != nil {
+			return err
+		}
+		d.Close()
+
+		mode := s.Filesystem.FileRuleMode(target, info.Mode().Perm())
+		if _, err := copyFile(root, p, root, target, mode, info.ModTime(), uid, gid); err != nil {
+			return err
+		}
+
+		fileOperations.Lock()
+		op.ProcessedFiles++
+		op.ProcessedBytes += info.Size()
+		fileOperations.Unlock()
+
+		s.publishFileOperation(op, false)
+
+		return nil
+	})
+}
+
+// Returns the number of regular files within the path and their combined size in bytes.
+func treeSize(p string) (int64, int64, error) {
+	var files, size int64
+
+	err := filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() {
+			files++
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	return files, size, err
+}
+
+// Determines if there is enough disk space available to the server to write the given
+// number of bytes.
+func (fs *Filesystem) hasSpaceFor(size int64) bool {
+	limit := fs.Server.Build.DiskSpace
+	if limit <= 0 {
+		return true
+	}
+
+	return fs.cachedDiskUsage()+size <= limit*1000*1000
+}
+
+// Determines if the operation writes new data to the disk, rather than only moving or
+// removing files.
+func (o *FileOperation) writesFiles() bool {
+	switch o.Request.Action {
+	case FileOperationCopy, FileOperationCompress, FileOperationDecompress, FileOperationPull:
+		return true
+	}
+
+	return false
+}
+
+// Determines if the operation has been cancelled.
+func (o *FileOperation) cancelled() bool {
+	select {
+	case <-o.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// Sends the progress of the operation to any listeners. Progress is sent at most once
+// per interval while the operation is running, unless it is the final update.
+func (s *Server) publishFileOperation(op *FileOperation, final bool) {
+	fileOperations.Lock()
+	if !final && time.Since(op.lastProgress) < fileOperationProgressInterval {
+		fileOperations.Unlock()
+		return
+	}
+
+	op.lastProgress = time.Now()
+	c := op.copy()
+	fileOperations.Unlock()
+
+	if b, err := json.Marshal(c); err == nil {
+		s.Events().Publish(FileOperationEvent, string(b))
+	}
+}
+
+// Removes the file operations for the server, cancelling any that are still running.
+func (s *Server) RemoveFileOperations() {
+	fileOperations.Lock()
+	defer fileOperations.Unlock()
+
+	for _, o := range fileOperations.operations[s.Uuid] {
+		if o.Status == FileOperationRunning {
+			o.Status = FileOperationCancelled
+			close(o.cancel)
+		}
+	}
+
+	delete(fileOperations.operations, s.Uuid)
+}
@@ -0,0 +1,311 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// The most redirects followed when downloading a file.
+const pullMaxRedirects = 10
+
+// The address ranges that files cannot be downloaded from unless private networks are
+// allowed, in addition to loopback, link-local and unspecified addresses.
+var privateNetworks = func() []*net.IPNet {
+	var out []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "198.18.0.0/15", "fc00::/7", "64:ff9b::/96"} {
+		_, n, _ := net.ParseCIDR(cidr)
+		out = append(out, n)
+	}
+
+	return out
+}()
+
+// Checks that a file can be downloaded from the URL, which must use HTTP or HTTPS and be
+// on one of the allowed domains. The address the domain resolves to is checked when the
+// connection is made.
+func validatePullUrl(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return errors.New("only http and https urls can be downloaded")
+	}
+
+	host := strings.ToLower(u.Hostname())
+	if host == "" {
+		return errors.New("the url does not contain a host")
+	}
+
+	allowed := config.Get().Api.RemoteDownloads.AllowedDomains
+	if len(allowed) > 0 && !matchesAny(host, allowed) {
+		return errors.Errorf("downloading files from \"%s\" is not allowed", host)
+	}
+
+	return nil
+}
+
+// Determines if the address is on the node or a private network.
+func isPrivateAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the client used to download files. Every connection, including those made when
+// following redirects, is checked against the allowed domains and addresses so that a
+// download cannot be used to reach services on the node or its network.
+func pullClient(cfg config.RemoteDownloadConfiguration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: time.Second * 30,
+		Control: func(_ string, address string, _ syscall.RawConn) error {
+			if cfg.AllowPrivateNetworks {
+				return nil
+			}
+
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+
+			if ip := net.ParseIP(host); ip == nil || isPrivateAddress(ip) {
+				return errors.Errorf("downloading files from %s is not allowed", host)
+			}
+
+			return nil
+		},
+	}
+
+	return &http.Client{
+		Timeout: time.Minute * time.Duration(cfg.Timeout),
+		Transport: &http.Transport{
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   time.Second * 10,
+			ResponseHeaderTimeout: time.Second * 30,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= pullMaxRedirects {
+				return errors.New("stopped after too many redirects")
+			}
+
+			return validatePullUrl(req.URL)
+		},
+	}
+}
+
+// Resolves where a downloaded file is written. If the destination is a directory, or ends
+// with a slash, the name of the file is taken from the URL.
+func (s *Server) resolvePullDestination(root string, u *url.URL, to string) (string, error) {
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		name = ""
+	}
+
+	if to == "" || strings.HasSuffix(to, "/") {
+		if name == "" {
+			return "", errors.Errorf("no destination provided for \"%s\"", u)
+		}
+
+		to = path.Join(to, name)
+	}
+
+	p, err := s.Filesystem.SafePath(path.Join(root, to))
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid path \"%s\"", to)
+	}
+
+	if st, err := os.Stat(p); err == nil && st.IsDir() && name != "" {
+		if p, err = s.Filesystem.SafePath(path.Join(root, to, name)); err != nil {
+			return "", errors.Wrapf(err, "invalid path \"%s\"", to)
+		}
+	}
+
+	if _, err := os.Lstat(p); err == nil {
+		return "", errors.Errorf("\"%s\" already exists", strings.TrimPrefix(p, s.Filesystem.Path()))
+	}
+
+	return p, nil
+}
+
+// Downloads a single file into the data directory of the server. The file is written
+// next to its destination and only moved into place once it has been fully downloaded
+// and matches its checksum, if one was provided. A download can take minutes, during
+// which the server can change its directories, so the destination is opened and moved
+// without following symlinks rather than relying on the path resolved beforehand.
+func (s *Server) pullFile(op *FileOperation, p resolvedFileOperationPath, available *int64) error {
+	cfg := config.Get().Api.RemoteDownloads
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-op.cancel:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequest(http.MethodGet, p.From, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	res, err := pullClient(cfg).Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("received unexpected response status %s", res.Status)
+	}
+
+	var remaining int64 = -1
+	if cfg.MaxSize > 0 {
+		remaining = cfg.MaxSize * 1000 * 1000
+	}
+
+	tooLarge := errors.Errorf("file is larger than the %d MB limit", cfg.MaxSize)
+	noSpace := &diskSpaceError{message: "downloading the file would exceed the disk space available to the server"}
+
+	if res.ContentLength > 0 {
+		switch {
+		case remaining >= 0 && res.ContentLength > remaining:
+			return tooLarge
+		case *available >= 0 && res.ContentLength > *available:
+			return noSpace
+		}
+
+		fileOperations.Lock()
+		op.TotalBytes += res.ContentLength
+		fileOperations.Unlock()
+	}
+
+	root := s.Filesystem.Path()
+
+	d, err := mkdirAllBeneath(root, filepath.Dir(p.to))
+	if err != nil {
+		return err
+	}
+	d.Close()
+
+	tmp := filepath.Join(filepath.Dir(p.to), "."+filepath.Base(p.to)+"."+op.Id[:8]+".part")
+	f, err := openBeneath(root, tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	h := sha256.New()
+	w := io.MultiWriter(
+		&quotaWriter{w: &quotaWriter{w: f, available: available, err: noSpace}, available: &remaining, err: tooLarge},
+		h,
+		&pullProgressWriter{s: s, op: op},
+	)
+
+	var r io.Reader = res.Body
+	if cfg.MaxBandwidth > 0 {
+		r = &throttledReader{r: r, rate: cfg.MaxBandwidth * 1000, start: time.Now()}
+	}
+
+	_, err = io.Copy(w, r)
+	if err == nil {
+		err = f.Chown(s.Filesystem.Configuration.User.Uid, s.Filesystem.Configuration.User.Gid)
+	}
+
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if err == nil && p.Checksum != "" {
+		if actual := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(actual, p.Checksum) {
+			err = errors.Errorf("checksum of downloaded file %s does not match the expected value", actual)
+		}
+	}
+
+	if err == nil {
+		if _, serr := lstatBeneath(root, p.to); serr == nil {
+			err = errors.New("destination already exists")
+		}
+	}
+
+	if err != nil {
+		removeBeneath(root, tmp)
+
+		if op.cancelled() {
+			return errors.New("operation was cancelled")
+		}
+
+		return errors.WithStack(err)
+	}
+
+	if err := renameBeneath(root, tmp, p.to); err != nil {
+		removeBeneath(root, tmp)
+
+		return err
+	}
+
+	fileOperations.Lock()
+	op.ProcessedFiles++
+	fileOperations.Unlock()
+
+	return nil
+}
+
+// Records the bytes downloaded for a file against the operation.
+type pullProgressWriter struct {
+	s  *Server
+	op *FileOperation
+}
+
+func (w *pullProgressWriter) Write(b []byte) (int, error) {
+	fileOperations.Lock()
+	w.op.ProcessedBytes += int64(len(b))
+	fileOperations.Unlock()
+
+	w.s.publishFileOperation(w.op, false)
+
+	return len(b), nil
+}
+
+// Limits the average speed that data is read from the underlying reader to the rate, in
+// bytes per second.
+type throttledReader struct {
+	r     io.Reader
+	rate  int64
+	start time.Time
+	read  int64
+}
+
+func (t *throttledReader) Read(b []byte) (int, error) {
+	if int64(len(b)) > t.rate {
+		b = b[:t.rate]
+	}
+
+	n, err := t.r.Read(b)
+	t.read += int64(n)
+
+	expected := time.Duration(float64(t.read) / float64(t.rate) * float64(time.Second))
+	if d := expected - time.Since(t.start); d > 0 {
+		time.Sleep(d)
+	}
+
+	return n, err
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// The actions that can be restricted by the file rules of a server.
+const (
+	FileActionWrite   = "write"
+	FileActionDelete  = "delete"
+	FileActionExecute = "execute"
+)
+
+type fileRule struct {
+	pattern string
+	// Set when the pattern is matched against the whole path rather than each name in it.
+	anchored bool
+	actions  []string
+	allow    bool
+}
+
+// The file rules for a server, compiled from the node configuration and the egg of the
+// server. The rules are compiled again whenever either of them changes.
+type fileRestrictions struct {
+	mu    sync.Mutex
+	rules []fileRule
+	// The configuration the rules were compiled from.
+	cfg *config.Configuration
+	pc  *api.ProcessConfiguration
+}
+
+// Returns the compiled file rules for the server, compiling them first if the node
+// configuration or egg have changed since they were last compiled.
+func (s *Server) fileRules() []fileRule {
+	cfg := config.Get()
+	pc := s.processConfiguration
+
+	s.fileRestrictions.mu.Lock()
+	defer s.fileRestrictions.mu.Unlock()
+
+	if s.fileRestrictions.cfg == cfg && s.fileRestrictions.pc == pc {
+		return s.fileRestrictions.rules
+	}
+
+	var rules []fileRule
+	add := func(pattern string, actions []string, allow bool) {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		anchored := strings.Contains(strings.TrimSuffix(pattern, "/"), "/")
+		pattern = strings.Trim(pattern, "/")
+
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			zap.S().Warnw("file rule pattern is not valid", zap.String("server", s.Uuid), zap.String("pattern", pattern))
+			return
+		}
+
+		rules = append(rules, fileRule{pattern: pattern, anchored: anchored, actions: actions, allow: allow})
+	}
+
+	for _, r := range cfg.System.FileRules {
+		add(r.Pattern, r.Actions, r.Allow)
+	}
+
+	if pc != nil {
+		for _, r := range pc.FileRules {
+			add(r.Pattern, r.Actions, r.Allow)
+		}
+	}
+
+	s.fileRestrictions.rules = rules
+	s.fileRestrictions.cfg = cfg
+	s.fileRestrictions.pc = pc
+
+	return rules
+}
+
+// Determines if the rule restricts the action.
+func (r *fileRule) covers(action string) bool {
+	if len(r.actions) == 0 {
+		return true
+	}
+
+	for _, a := range r.actions {
+		if strings.EqualFold(a, action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Determines if the rule matches a path relative to the root of the server, or any of
+// the directories containing it.
+func (r *fileRule) matches(rel string) bool {
+	parts := strings.Split(rel, "/")
+
+	for i := range parts {
+		target := parts[i]
+		if r.anchored {
+			target = strings.Join(parts[:i+1], "/")
+		}
+
+		if ok, _ := path.Match(r.pattern, target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the path of a file relative to the root of the server, in the form the file
+// rules are matched against.
+func (fs *Filesystem) rulePath(cleaned string) string {
+	rel := filepath.ToSlash(strings.TrimPrefix(cleaned, fs.Path()))
+
+	return strings.ToLower(strings.Trim(rel, "/"))
+}
+
+// Determines if the file rules of the server allow the action on the path, which must
+// already have been passed through SafePath. The last rule matching the path decides.
+func (fs *Filesystem) FileActionAllowed(cleaned string, action string) bool {
+	rel := fs.rulePath(cleaned)
+	if rel == "" {
+		return true
+	}
+
+	allowed := true
+	for _, r := range fs.Server.fileRules() {
+		if r.covers(action) && r.matches(rel) {
+			allowed = r.allow
+		}
+	}
+
+	return allowed
+}
+
+// Returns an error if the file rules of the server do not allow the action on the path.
+func (fs *Filesystem) checkFileRules(cleaned string, action string) error {
+	if fs.FileActionAllowed(cleaned, action) {
+		return nil
+	}
+
+	return &fileRuleError{path: "/" + strings.Trim(filepath.ToSlash(strings.TrimPrefix(cleaned, fs.Path())), "/"), action: action}
+}
+
+// Returns an error if the file rules of the server do not allow the action on the path,
+// or on any file within it if the path is a directory.
+func (fs *Filesystem) checkFileRulesTree(cleaned string, action string) error {
+	if err := fs.checkFileRules(cleaned, action); err != nil {
+		return err
+	}
+
+	if !fs.Server.restrictsFiles(action) {
+		return nil
+	}
+
+	return filepath.Walk(cleaned, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == cleaned {
+			return nil
+		}
+
+		return fs.checkFileRules(p, action)
+	})
+}
+
+// Returns an error if the file rules of the server do not allow the files within a path
+// to be written to the destination, as they would be when the path is copied or moved.
+func (fs *Filesystem) checkFileRulesCopy(from string, to string) error {
+	if err := fs.checkFileRules(to, FileActionWrite); err != nil {
+		return err
+	}
+
+	if !fs.Server.restrictsFiles(FileActionWrite) {
+		return nil
+	}
+
+	return filepath.Walk(from, func(p string, info os.FileInfo, err error) error {
+		if err != nil || p == from {
+			return nil
+		}
+
+		return fs.checkFileRules(filepath.Join(to, strings.TrimPrefix(p, from)), FileActionWrite)
+	})
+}
+
+// Returns an error if the file rules of the server do not allow a path to be moved to
+// the destination.
+func (fs *Filesystem) checkFileRulesMove(from string, to string) error {
+	if err := fs.checkFileRulesTree(from, FileActionDelete); err != nil {
+		return err
+	}
+
+	return fs.checkFileRulesCopy(from, to)
+}
+
+// Determines if any of the file rules of the server deny the action, in which case every
+// file within a directory needs to be checked before acting on the directory.
+func (s *Server) restrictsFiles(action string) bool {
+	for _, r := range s.fileRules() {
+		if !r.allow && r.covers(action) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the permissions a file should be written with, removing the executable bits if
+// the file rules of the server do not allow the file to be executed.
+func (fs *Filesystem) FileRuleMode(cleaned string, mode os.FileMode) os.FileMode {
+	if mode&0111 == 0 || fs.FileActionAllowed(cleaned, FileActionExecute) {
+		return mode
+	}
+
+	return mode &^ 0111
+}
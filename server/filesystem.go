@@ -8,6 +8,7 @@ import (
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
 	"go.uber.org/zap"
 	"io"
 	"io/ioutil"
@@ -33,7 +34,12 @@ type Filesystem struct {
 
 // Returns the root path that contains all of a server's data.
 func (fs *Filesystem) Path() string {
-	return filepath.Join(fs.Configuration.Data, fs.Server.Uuid)
+	return filepath.Join(fs.Root(), fs.Server.Uuid)
+}
+
+// Returns the directory of the storage pool that the server data is stored within.
+func (fs *Filesystem) Root() string {
+	return fs.Configuration.StoragePoolPath(fs.Server.StoragePool)
 }
 
 // Normalizes a directory being passed in to ensure the user is not able to escape
@@ -107,7 +113,8 @@ func (fs *Filesystem) SafePath(p string) (string, error) {
 // Determines if the directory a file is trying to be added to has enough space available
 // for the file to be written to.
 //
-// Because determining the amount of space being used by a server is a taxing operation we
+// The disk space is tracked as the files of the server change where possible. Otherwise,
+// because determining the amount of space being used by a server is a taxing operation we
 // will load it all up into a cache and pull from that as long as the key is not expired.
 func (fs *Filesystem) HasSpaceAvailable() bool {
 	var space = fs.Server.Build.DiskSpace
@@ -117,6 +124,29 @@ func (fs *Filesystem) HasSpaceAvailable() bool {
 		return true
 	}
 
+	// Determine if their folder size, in bytes, is smaller than the amount of space they've
+	// been allocated.
+	return (fs.cachedDiskUsage() / 1000.0 / 1000.0) <= space
+}
+
+// Returns the disk space used by the server in bytes. If the disk space is tracked as the
+// files change the current value is returned, otherwise the cached value is used if it
+// has been calculated recently. Items in the trash of the server and copied snapshots are
+// included.
+func (fs *Filesystem) cachedDiskUsage() int64 {
+	if size, ok := fs.accountedDiskUsage(); ok {
+		// Items moved to the trash keep counting towards the project they were deleted
+		// from, so they are already included in the usage of the project.
+		if fs.DiskAccountingMode() != DiskAccountingProject {
+			size += fs.trashSize()
+		}
+		size += fs.snapshotsSize()
+
+		fs.Server.Resources.Disk = size
+
+		return size
+	}
+
 	var size int64
 	if x, exists := fs.Server.Cache.Get("disk_used"); exists {
 		size = x.(int64)
@@ -127,18 +157,20 @@ func (fs *Filesystem) HasSpaceAvailable() bool {
 	// grab the size of their data directory. This is a taxing operation, so we want to store it in
 	// the cache once we've gotten it.
 	if size == 0 {
-		if size, err := fs.DirectorySize("/"); err != nil {
+		if s, err := fs.DirectorySize("/"); err != nil {
 			zap.S().Warnw("failed to determine directory size", zap.String("server", fs.Server.Uuid), zap.Error(err))
 		} else {
-			fs.Server.Cache.Set("disk_used", size, time.Second * 60)
+			size = s
+			if ttl := fs.Configuration.Cache.DiskUsageTtl; ttl > 0 {
+				fs.Server.Cache.Set("disk_used", size, time.Second*time.Duration(ttl))
+			}
 		}
 	}
 
-	// Determine if their folder size, in bytes, is smaller than the amount of space they've
-	// been allocated.
+	size += fs.trashSize() + fs.snapshotsSize()
 	fs.Server.Resources.Disk = size
 
-	return (size / 1000.0 / 1000.0) <= space
+	return size
 }
 
 // Determines the directory size of a given location by running parallel tasks to iterate
@@ -224,6 +256,10 @@ func (fs *Filesystem) Writefile(p string, r io.Reader) error {
 		return errors.New("cannot use a directory as a file for writing")
 	}
 
+	// Hold the same lock used by the configuration parsers so that a file being written
+	// is never interleaved with the parser updating it.
+	defer parser.LockFile(cleaned)()
+
 	// This will either create the file if it does not already exist, or open and
 	// truncate the existing file.
 	file, err := os.OpenFile(cleaned, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
@@ -256,6 +292,8 @@ func (fs *Filesystem) Writefile(p string, r io.Reader) error {
 		return errors.WithStack(err)
 	}
 
+	fs.InvalidateCache()
+
 	// Finally, chown the file to ensure the permissions don't end up out-of-whack
 	// if we had just created it.
 	return fs.Chown(p)
@@ -327,6 +365,12 @@ func (fs *Filesystem) CreateDirectory(name string, p string) error {
 		return errors.WithStack(err)
 	}
 
+	if err := fs.checkFileRules(cleaned, FileActionWrite); err != nil {
+		return err
+	}
+
+	defer fs.InvalidateCache()
+
 	return os.MkdirAll(cleaned, 0755)
 }
 
@@ -342,6 +386,12 @@ func (fs *Filesystem) Rename(from string, to string) error {
 		return errors.WithStack(err)
 	}
 
+	if err := fs.checkFileRulesMove(cleanedFrom, cleanedTo); err != nil {
+		return err
+	}
+
+	defer fs.InvalidateCache()
+
 	return os.Rename(cleanedFrom, cleanedTo)
 }
 
@@ -462,6 +512,10 @@ func (fs *Filesystem) Copy(p string) error {
 		return errors.WithStack(err)
 	}
 
+	if err := fs.checkFileRules(finalPath, FileActionWrite); err != nil {
+		return err
+	}
+
 	source, err := os.Open(cleaned)
 	if err != nil {
 		return errors.WithStack(err)
@@ -473,6 +527,7 @@ func (fs *Filesystem) Copy(p string) error {
 		return errors.WithStack(err)
 	}
 	defer dest.Close()
+	defer fs.InvalidateCache()
 
 	if _, err := io.Copy(dest, source); err != nil {
 		return errors.WithStack(err)
@@ -494,7 +549,13 @@ func (fs *Filesystem) Delete(p string) error {
 		return errors.New("cannot delete root server directory")
 	}
 
-	return os.RemoveAll(cleaned)
+	if err := fs.checkFileRulesTree(cleaned, FileActionDelete); err != nil {
+		return err
+	}
+
+	defer fs.InvalidateCache()
+
+	return fs.remove(cleaned)
 }
 
 // Lists the contents of a given directory and returns stat information about each
@@ -505,39 +566,12 @@ func (fs *Filesystem) ListDirectory(p string) ([]*Stat, error) {
 		return nil, err
 	}
 
-	files, err := ioutil.ReadDir(cleaned)
+	files, err := fs.readDirectory(cleaned)
 	if err != nil {
 		return nil, err
 	}
 
-	var wg sync.WaitGroup
-
-	// You must initialize the output of this directory as a non-nil value otherwise
-	// when it is marshaled into a JSON object you'll just get 'null' back, which will
-	// break the panel badly.
-	out := make([]*Stat, 0)
-
-	// Iterate over all of the files and directories returned and perform an async process
-	// to get the mime-type for them all.
-	for _, file := range files {
-		wg.Add(1)
-
-		go func(f os.FileInfo) {
-			defer wg.Done()
-
-			var m = "inode/directory"
-			if !f.IsDir() {
-				m, _, _ = mimetype.DetectFile(filepath.Join(cleaned, f.Name()))
-			}
-
-			out = append(out, &Stat{
-				Info:     f,
-				Mimetype: m,
-			})
-		}(file)
-	}
-
-	wg.Wait()
+	out := fs.statFiles(cleaned, files)
 
 	// Sort the output alphabetically to begin with since we've run the output
 	// through an asynchronous process and the order is gonna be very random.
@@ -558,6 +592,19 @@ func (fs *Filesystem) ListDirectory(p string) ([]*Stat, error) {
 	return out, nil
 }
 
+// Removes the cached disk usage, directory listings and disk usage breakdowns for the
+// server. This is called any time a file is modified through the daemon, including over
+// SFTP, so that stale listings and sizes are never returned.
+func (fs *Filesystem) InvalidateCache() {
+	fs.Server.Cache.Delete("disk_used")
+
+	for k := range fs.Server.Cache.Items() {
+		if strings.HasPrefix(k, "listing:") || strings.HasPrefix(k, "usage:") {
+			fs.Server.Cache.Delete(k)
+		}
+	}
+}
+
 // Ensures that the data directory for the server instance exists.
 func (fs *Filesystem) EnsureDataDirectory() error {
 	if _, err := os.Stat(fs.Path()); err != nil && !os.IsNotExist(err) {
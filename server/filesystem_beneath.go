@@ -0,0 +1,339 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The functions in this file act on paths within a directory that the server process is
+// able to modify while the daemon is working on it. Checking a path and then acting on it
+// leaves a window in which the server can replace a directory along the path with a
+// symlink, causing the daemon to read or write files anywhere on the host. To prevent this
+// each component of the path is opened relative to the one before it, and symlinks are
+// never followed.
+
+// Returns the components of the path relative to the root directory, or an error if the
+// path is not within the root.
+func beneathParts(root string, p string) ([]string, error) {
+	rel, err := filepath.Rel(root, p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if rel == "." {
+		return nil, nil
+	}
+
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, errors.Errorf("%s is not within %s", p, root)
+	}
+
+	return strings.Split(rel, string(filepath.Separator)), nil
+}
+
+// Opens a directory within the root, one component at a time without following symlinks.
+// If create is set any missing directories are created along the way. The caller must
+// close the returned descriptor.
+func openDirBeneath(root string, parts []string, create bool) (int, error) {
+	fd, err := unix.Open(root, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return -1, &os.PathError{Op: "open", Path: root, Err: err}
+	}
+
+	p := root
+	for _, part := range parts {
+		p = filepath.Join(p, part)
+
+		next, err := unix.Openat(fd, part, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+		if err == unix.ENOENT && create {
+			if err = unix.Mkdirat(fd, part, 0755); err == nil || err == unix.EEXIST {
+				next, err = unix.Openat(fd, part, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+			}
+		}
+		unix.Close(fd)
+
+		if err != nil {
+			// Opening a symlink without following it returns ELOOP, or ENOTDIR on some
+			// systems, neither of which explain what happened.
+			if err == unix.ELOOP || err == unix.ENOTDIR {
+				return -1, errors.Errorf("%s is not a directory", p)
+			}
+
+			return -1, &os.PathError{Op: "open", Path: p, Err: err}
+		}
+
+		fd = next
+	}
+
+	return fd, nil
+}
+
+// Opens the directory containing the path within the root, returning its descriptor and
+// the final component of the path. The caller must close the returned descriptor.
+func openParentBeneath(root string, p string, create bool) (int, string, error) {
+	parts, err := beneathParts(root, p)
+	if err != nil {
+		return -1, "", err
+	}
+
+	if len(parts) == 0 {
+		return -1, "", errors.Errorf("%s is the root directory", p)
+	}
+
+	dir, err := openDirBeneath(root, parts[:len(parts)-1], create)
+	if err != nil {
+		return -1, "", err
+	}
+
+	return dir, parts[len(parts)-1], nil
+}
+
+// Opens a file within the root without following symlinks in any part of the path. The
+// directories leading to the file must already exist.
+func openBeneath(root string, p string, flag int, perm os.FileMode) (*os.File, error) {
+	dir, name, err := openParentBeneath(root, p, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dir)
+
+	fd, err := unix.Openat(dir, name, flag|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(perm.Perm()))
+	if err != nil {
+		if err == unix.ELOOP {
+			return nil, errors.Errorf("%s is a symlink", p)
+		}
+
+		return nil, &os.PathError{Op: "open", Path: p, Err: err}
+	}
+
+	return os.NewFile(uintptr(fd), p), nil
+}
+
+// Opens a regular file within the root for reading, without following symlinks in any
+// part of the path. The details of the file are read from the open descriptor, so they
+// always describe the file that was opened.
+func openFileBeneath(root string, p string) (*os.File, os.FileInfo, error) {
+	// Opening a named pipe for reading would block until something writes to it, which
+	// O_NONBLOCK prevents. It has no effect on regular files.
+	f, err := openBeneath(root, p, os.O_RDONLY|unix.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+
+		return nil, nil, errors.WithStack(err)
+	}
+
+	if !st.Mode().IsRegular() {
+		f.Close()
+
+		return nil, nil, errors.Errorf("%s is not a regular file", p)
+	}
+
+	return f, st, nil
+}
+
+// Creates a directory within the root along with any missing parents, without following
+// symlinks in any part of the path. The directory is returned open so that its owner can
+// be changed using the descriptor, and the caller must close it.
+func mkdirAllBeneath(root string, p string) (*os.File, error) {
+	parts, err := beneathParts(root, p)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := openDirBeneath(root, parts, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.NewFile(uintptr(fd), p), nil
+}
+
+// Moves a file or directory within the root, without following symlinks in the parents
+// of either path. The parents of the destination are created if they do not exist, and
+// nothing already at the destination is ever replaced.
+func renameBeneath(root string, from string, to string) error {
+	fromDir, fromName, err := openParentBeneath(root, from, false)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fromDir)
+
+	toDir, toName, err := openParentBeneath(root, to, true)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(toDir)
+
+	if err := unix.Renameat2(fromDir, fromName, toDir, toName, unix.RENAME_NOREPLACE); err != nil {
+		return &os.LinkError{Op: "rename", Old: from, New: to, Err: err}
+	}
+
+	return nil
+}
+
+// Removes a file or directory within the root along with everything inside of it, without
+// following symlinks. Symlinks are removed rather than the files they point to.
+func removeBeneath(root string, p string) error {
+	dir, name, err := openParentBeneath(root, p, false)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return err
+	}
+	defer unix.Close(dir)
+
+	return removeAt(dir, name, p)
+}
+
+// Removes the named entry of the directory, removing the contents of directories first.
+func removeAt(dir int, name string, p string) error {
+	err := unix.Unlinkat(dir, name, 0)
+	if err == nil || err == unix.ENOENT {
+		return nil
+	}
+
+	if err != unix.EISDIR && err != unix.EPERM {
+		return &os.PathError{Op: "unlinkat", Path: p, Err: err}
+	}
+
+	fd, err := unix.Openat(dir, name, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: p, Err: err}
+	}
+
+	f := os.NewFile(uintptr(fd), p)
+	names, err := f.Readdirnames(-1)
+	if err != nil {
+		f.Close()
+
+		return errors.WithStack(err)
+	}
+
+	for _, n := range names {
+		if err := removeAt(fd, n, filepath.Join(p, n)); err != nil {
+			f.Close()
+
+			return err
+		}
+	}
+	f.Close()
+
+	if err := unix.Unlinkat(dir, name, unix.AT_REMOVEDIR); err != nil && err != unix.ENOENT {
+		return &os.PathError{Op: "unlinkat", Path: p, Err: err}
+	}
+
+	return nil
+}
+
+// Returns the details of a file within the root without following symlinks in any part of
+// the path.
+func lstatBeneath(root string, p string) (*unix.Stat_t, error) {
+	dir, name, err := openParentBeneath(root, p, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unix.Close(dir)
+
+	st := new(unix.Stat_t)
+	if err := unix.Fstatat(dir, name, st, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return nil, &os.PathError{Op: "lstat", Path: p, Err: err}
+	}
+
+	return st, nil
+}
+
+// Reads the target of a symlink within the root without following symlinks in the parents
+// of the path.
+func readlinkBeneath(root string, p string) (string, error) {
+	dir, name, err := openParentBeneath(root, p, false)
+	if err != nil {
+		return "", err
+	}
+	defer unix.Close(dir)
+
+	b := make([]byte, 4096)
+	n, err := unix.Readlinkat(dir, name, b)
+	if err != nil {
+		return "", &os.PathError{Op: "readlink", Path: p, Err: err}
+	}
+
+	return string(b[:n]), nil
+}
+
+// Creates a symlink within the root pointing to the target, without following symlinks in
+// the parents of the path.
+func symlinkBeneath(root string, target string, p string) error {
+	dir, name, err := openParentBeneath(root, p, false)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dir)
+
+	if err := unix.Symlinkat(target, dir, name); err != nil {
+		return &os.LinkError{Op: "symlink", Old: target, New: p, Err: err}
+	}
+
+	return nil
+}
+
+// Changes the owner of a file within the root without following symlinks in any part of
+// the path. A symlink itself has its owner changed rather than the file it points to.
+func lchownBeneath(root string, p string, uid int, gid int) error {
+	parts, err := beneathParts(root, p)
+	if err != nil {
+		return err
+	}
+
+	if len(parts) == 0 {
+		return errors.WithStack(os.Lchown(root, uid, gid))
+	}
+
+	dir, name, err := openParentBeneath(root, p, false)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(dir)
+
+	if err := unix.Fchownat(dir, name, uid, gid, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return &os.PathError{Op: "lchown", Path: p, Err: err}
+	}
+
+	return nil
+}
+
+// Changes the owner of a file within the root and everything inside of it, without
+// following symlinks in any part of the path.
+func lchownTreeBeneath(root string, p string, uid int, gid int) error {
+	return filepath.Walk(p, func(fp string, _ os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return errors.WithStack(err)
+		}
+
+		if err := lchownBeneath(root, fp, uid, gid); err != nil && !os.IsNotExist(errors.Cause(err)) {
+			return err
+		}
+
+		return nil
+	})
+}
+
+// Opens a regular file within the data directory of the server for reading, without
+// following symlinks in any part of the path. The path must already be resolved using
+// SafePath, and the details returned are those of the file that was opened.
+func (fs *Filesystem) OpenFile(p string) (*os.File, os.FileInfo, error) {
+	return openFileBeneath(fs.Path(), p)
+}
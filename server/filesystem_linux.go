@@ -1,6 +1,7 @@
 package server
 
 import (
+	"os"
 	"syscall"
 	"time"
 )
@@ -10,4 +11,15 @@ func (s *Stat) CTime() time.Time {
 	st := s.Info.Sys().(*syscall.Stat_t)
 
 	return time.Unix(int64(st.Ctim.Sec), int64(st.Ctim.Nsec))
-}
\ No newline at end of file
+}
+
+// Returns the device and inode of a file that has more than one hard link, so that the
+// space it uses is only counted once.
+func hardLinkIdentity(info os.FileInfo) ([2]uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok || st.Nlink <= 1 {
+		return [2]uint64{}, false
+	}
+
+	return [2]uint64{uint64(st.Dev), uint64(st.Ino)}, true
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/remeh/sizedwaitgroup"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The maximum number of entries that can be returned in a single page of a directory
+// listing.
+const MaxListingPerPage = 1000
+
+// Options used to filter, sort, and paginate the contents of a directory.
+type ListDirectoryOptions struct {
+	// Only entries with a name containing this value will be returned. The comparison
+	// is case-insensitive.
+	Filter string
+
+	// The field to sort entries by, one of "name", "size", or "modified". Directories
+	// are always listed before files regardless of the field used.
+	Sort string
+
+	// If true entries are returned in descending order.
+	Descending bool
+
+	// The page of results to return, starting at 1. If PerPage is zero every entry
+	// is returned.
+	Page    int
+	PerPage int
+}
+
+// Returns a single page of the contents of a directory, along with the total number of
+// entries in the directory that matched the filter. Unlike ListDirectory the mimetype
+// is only detected for the entries that are actually returned, which makes this safe
+// to use on directories containing hundreds of thousands of files.
+func (fs *Filesystem) ListDirectoryPage(p string, opts ListDirectoryOptions) ([]*Stat, int, error) {
+	cleaned, files, err := fs.sortedDirectory(p, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total := len(files)
+	if opts.PerPage > 0 {
+		if opts.PerPage > MaxListingPerPage {
+			opts.PerPage = MaxListingPerPage
+		}
+
+		if opts.Page < 1 {
+			opts.Page = 1
+		}
+
+		start := (opts.Page - 1) * opts.PerPage
+		if start > total {
+			start = total
+		}
+
+		end := start + opts.PerPage
+		if end > total {
+			end = total
+		}
+
+		files = files[start:end]
+	}
+
+	return fs.statFiles(cleaned, files), total, nil
+}
+
+// Walks over the contents of a directory in batches, calling the provided function for
+// each entry. This allows very large directories to be streamed back to a client
+// without holding the stat information for every entry in memory at once. Pagination
+// options are ignored.
+func (fs *Filesystem) StreamDirectory(p string, opts ListDirectoryOptions, fn func(*Stat) error) error {
+	cleaned, files, err := fs.sortedDirectory(p, opts)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i < len(files); i += 100 {
+		end := i + 100
+		if end > len(files) {
+			end = len(files)
+		}
+
+		for _, st := range fs.statFiles(cleaned, files[i:end]) {
+			if err := fn(st); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Returns the filtered and sorted contents of a directory.
+func (fs *Filesystem) sortedDirectory(p string, opts ListDirectoryOptions) (string, []os.FileInfo, error) {
+	cleaned, err := fs.SafePath(p)
+	if err != nil {
+		return "", nil, err
+	}
+
+	all, err := fs.readDirectory(cleaned)
+	if err != nil {
+		return "", nil, err
+	}
+
+	files := make([]os.FileInfo, 0, len(all))
+	filter := strings.ToLower(opts.Filter)
+	for _, f := range all {
+		if filter == "" || strings.Contains(strings.ToLower(f.Name()), filter) {
+			files = append(files, f)
+		}
+	}
+
+	less := func(a, b os.FileInfo) bool {
+		switch opts.Sort {
+		case "size":
+			if a.Size() != b.Size() {
+				return a.Size() < b.Size()
+			}
+		case "modified":
+			if !a.ModTime().Equal(b.ModTime()) {
+				return a.ModTime().Before(b.ModTime())
+			}
+		}
+
+		return a.Name() < b.Name()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].IsDir() != files[j].IsDir() {
+			return files[i].IsDir()
+		}
+
+		if opts.Descending {
+			return less(files[j], files[i])
+		}
+
+		return less(files[i], files[j])
+	})
+
+	return cleaned, files, nil
+}
+
+// Reads the contents of a directory, returning the cached results if the directory
+// was read recently. The slice returned must not be modified by the caller.
+func (fs *Filesystem) readDirectory(cleaned string) ([]os.FileInfo, error) {
+	key := "listing:" + cleaned
+	if x, exists := fs.Server.Cache.Get(key); exists {
+		return x.([]os.FileInfo), nil
+	}
+
+	files, err := ioutil.ReadDir(cleaned)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl := fs.Configuration.Cache.DirectoryListingTtl; ttl > 0 {
+		fs.Server.Cache.Set(key, files, time.Second*time.Duration(ttl))
+	}
+
+	return files, nil
+}
+
+// Detects the mimetype of each of the files provided, returning the stat information
+// in the same order as the files were passed in.
+func (fs *Filesystem) statFiles(dir string, files []os.FileInfo) []*Stat {
+	// You must initialize the output of this directory as a non-nil value otherwise
+	// when it is marshaled into a JSON object you'll just get 'null' back, which will
+	// break the panel badly.
+	out := make([]*Stat, len(files))
+
+	wg := sizedwaitgroup.New(16)
+	for i, file := range files {
+		wg.Add()
+
+		go func(i int, f os.FileInfo) {
+			defer wg.Done()
+
+			var m = "inode/directory"
+			if !f.IsDir() {
+				m, _, _ = mimetype.DetectFile(filepath.Join(dir, f.Name()))
+			}
+
+			out[i] = &Stat{
+				Info:     f,
+				Mimetype: m,
+			}
+		}(i, file)
+	}
+
+	wg.Wait()
+
+	return out
+}
@@ -0,0 +1,236 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The limits applied to a single search of a server's files.
+const (
+	// The default and maximum number of files returned by a search.
+	DefaultSearchResults = 100
+	MaxSearchResults     = 1000
+
+	// Files larger than this are matched by name but their contents are not searched.
+	maxSearchFileSize = 10 * 1024 * 1024
+
+	// The most matching lines returned for a single file, and the longest line returned
+	// for each match.
+	maxSearchMatches    = 10
+	maxSearchLineLength = 512
+
+	// The number of bytes at the start of a file checked for a NUL byte to determine if
+	// the file is binary, which is the same heuristic used by git and grep.
+	binarySniffLength = 8000
+)
+
+// The criteria used to search the files of a server. Files must match both the name
+// pattern and the content expression if both are provided.
+type SearchOptions struct {
+	// The directory to search within, including every directory below it.
+	Directory string
+	// A glob pattern matched against the name of each file, such as "*.yml".
+	Pattern string
+	// A regular expression matched against each line of the file. Binary files and files
+	// that are too large are skipped when searching contents.
+	Content string
+	// If set to true both the pattern and the expression ignore case.
+	IgnoreCase bool
+	// The most files to return.
+	Limit int
+}
+
+// A file that matched a search, along with the lines that matched the content expression.
+type SearchResult struct {
+	Path       string        `json:"path"`
+	Size       int64         `json:"size"`
+	ModifiedAt time.Time     `json:"modified_at"`
+	Matches    []SearchMatch `json:"matches,omitempty"`
+}
+
+// A single line matching the content expression of a search.
+type SearchMatch struct {
+	Line int    `json:"line"`
+	Text string `json:"text"`
+}
+
+// Validates the search options, returning the compiled content expression if there is
+// one.
+func (o *SearchOptions) compile() (*regexp.Regexp, error) {
+	if o.Pattern == "" && o.Content == "" {
+		return nil, errors.New("a name pattern or content expression must be provided")
+	}
+
+	if o.Limit <= 0 {
+		o.Limit = DefaultSearchResults
+	} else if o.Limit > MaxSearchResults {
+		o.Limit = MaxSearchResults
+	}
+
+	if o.IgnoreCase {
+		o.Pattern = strings.ToLower(o.Pattern)
+	}
+
+	if _, err := path.Match(o.Pattern, ""); err != nil {
+		return nil, errors.Errorf("invalid name pattern \"%s\"", o.Pattern)
+	}
+
+	if o.Content == "" {
+		return nil, nil
+	}
+
+	expr := o.Content
+	if o.IgnoreCase {
+		expr = "(?i)" + expr
+	}
+
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid content expression")
+	}
+
+	return re, nil
+}
+
+// Searches the files of the server, calling the function for each file that matches as
+// soon as it is found. Symbolic links are never followed. The search stops once the
+// limit is reached, the context is cancelled, or the function returns an error.
+func (fs *Filesystem) Search(ctx context.Context, opts SearchOptions, fn func(*SearchResult) error) error {
+	re, err := opts.compile()
+	if err != nil {
+		return err
+	}
+
+	root, err := fs.SafePath(opts.Directory)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if st, err := os.Stat(root); err != nil {
+		return err
+	} else if !st.IsDir() {
+		return errors.New("search path must be a directory")
+	}
+
+	found := 0
+	err = filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can be removed while the search is running, and unreadable directories
+			// should not stop the rest of the search.
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+
+		name := info.Name()
+		if opts.IgnoreCase {
+			name = strings.ToLower(name)
+		}
+
+		if opts.Pattern != "" {
+			if ok, _ := path.Match(opts.Pattern, name); !ok {
+				return nil
+			}
+		}
+
+		res := &SearchResult{
+			Path:       filepath.ToSlash(strings.TrimPrefix(p, fs.Path())),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+		}
+
+		if re != nil {
+			if info.Size() > maxSearchFileSize {
+				return nil
+			}
+
+			matches, err := searchFile(fs.Path(), p, re)
+			if err != nil || len(matches) == 0 {
+				return nil
+			}
+
+			res.Matches = matches
+		}
+
+		if err := fn(res); err != nil {
+			return err
+		}
+
+		if found++; found >= opts.Limit {
+			return io.EOF
+		}
+
+		return nil
+	})
+
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// Returns the lines of the file within the root that match the expression. Binary files
+// never match. The file is opened without following symlinks and its size is checked
+// again once it is open, since the server can replace it after the directory was read.
+func searchFile(root string, p string, re *regexp.Regexp) ([]SearchMatch, error) {
+	f, info, err := openFileBeneath(root, p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if info.Size() > maxSearchFileSize {
+		return nil, nil
+	}
+
+	r := bufio.NewReaderSize(io.LimitReader(f, maxSearchFileSize), binarySniffLength)
+	if head, _ := r.Peek(binarySniffLength); bytes.IndexByte(head, 0) != -1 {
+		return nil, nil
+	}
+
+	var matches []SearchMatch
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxSearchFileSize)
+
+	for line := 1; scanner.Scan(); line++ {
+		b := scanner.Bytes()
+		if !re.Match(b) {
+			continue
+		}
+
+		text := string(b)
+		if len(text) > maxSearchLineLength {
+			text = text[:maxSearchLineLength]
+		}
+
+		matches = append(matches, SearchMatch{Line: line, Text: text})
+		if len(matches) >= maxSearchMatches {
+			break
+		}
+	}
+
+	return matches, scanner.Err()
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/patrickmn/go-cache"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The limits applied when looking for duplicate files.
+const (
+	// Files smaller than this are not checked for duplicates by default, since they are
+	// rarely worth removing and hashing them is where most of the time would be spent.
+	DefaultDuplicateMinSize = 1024 * 1024
+
+	// The most groups of duplicate files returned, starting with the groups that waste
+	// the most space.
+	maxDuplicateGroups = 100
+)
+
+// The criteria used to calculate the disk usage of a directory.
+type DiskUsageOptions struct {
+	Directory string
+	// If set to true the checksum of each file in the directory is returned, and the files
+	// below it are checked for duplicates.
+	Checksums bool
+	// The smallest file checked for duplicates.
+	MinDuplicateSize int64
+}
+
+// The disk space used by a directory and each of the files and directories directly
+// within it. Files with more than one hard link are only counted the first time they are
+// seen.
+type DiskUsage struct {
+	Directory   string `json:"directory"`
+	Size        int64  `json:"size"`
+	Files       int64  `json:"files"`
+	Directories int64  `json:"directories"`
+	// The size of the hard links to files that were already counted, which is not
+	// included in the size of the directory.
+	LinkedSize   int64            `json:"linked_size"`
+	Entries      []DiskUsageEntry `json:"entries"`
+	Duplicates   []DuplicateFiles `json:"duplicates,omitempty"`
+	CalculatedAt time.Time        `json:"calculated_at"`
+}
+
+// The disk space used by a single file or directory, and everything below it.
+type DiskUsageEntry struct {
+	Name       string    `json:"name"`
+	Directory  bool      `json:"directory"`
+	Size       int64     `json:"size"`
+	Files      int64     `json:"files"`
+	ModifiedAt time.Time `json:"modified_at"`
+	Checksum   string    `json:"checksum,omitempty"`
+}
+
+// A set of files that have the same contents.
+type DuplicateFiles struct {
+	Checksum string   `json:"checksum"`
+	Size     int64    `json:"size"`
+	Paths    []string `json:"paths"`
+	// The space that would be freed by keeping only one of the files.
+	Wasted int64 `json:"wasted"`
+}
+
+// The checksum of a file, along with the size and modification time of the file when it
+// was calculated so that it is only used while the file is unchanged.
+type cachedChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// Returns the disk usage of a directory, broken down by each of the files and directories
+// directly within it. The result is cached until files are modified through the daemon,
+// or until it expires, so that a breakdown can be browsed without walking the files of
+// the server for every request. Symbolic links are never followed.
+func (fs *Filesystem) DiskUsage(ctx context.Context, opts DiskUsageOptions) (*DiskUsage, error) {
+	root, err := fs.SafePath(opts.Directory)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if st, err := os.Stat(root); err != nil {
+		return nil, err
+	} else if !st.IsDir() {
+		return nil, errors.New("path must be a directory")
+	}
+
+	if opts.MinDuplicateSize <= 0 {
+		opts.MinDuplicateSize = DefaultDuplicateMinSize
+	}
+
+	key := "usage:" + root
+	if opts.Checksums {
+		key = fmt.Sprintf("%s:%d", key, opts.MinDuplicateSize)
+	}
+
+	if x, exists := fs.Server.Cache.Get(key); exists {
+		return x.(*DiskUsage), nil
+	}
+
+	fs.Server.usageLock.Lock()
+	defer fs.Server.usageLock.Unlock()
+
+	// Another request may have calculated the usage while this one was waiting.
+	if x, exists := fs.Server.Cache.Get(key); exists {
+		return x.(*DiskUsage), nil
+	}
+
+	u, err := fs.calculateDiskUsage(ctx, root, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if ttl := fs.Configuration.Cache.DirectoryUsageTtl; ttl > 0 {
+		fs.Server.Cache.Set(key, u, time.Second*time.Duration(ttl))
+	}
+
+	return u, nil
+}
+
+func (fs *Filesystem) calculateDiskUsage(ctx context.Context, root string, opts DiskUsageOptions) (*DiskUsage, error) {
+	u := &DiskUsage{
+		Directory: filepath.ToSlash(strings.TrimPrefix(root, fs.Path())),
+		Entries:   []DiskUsageEntry{},
+	}
+	if u.Directory == "" {
+		u.Directory = "/"
+	}
+
+	entries := make(map[string]*DiskUsageEntry)
+	linked := make(map[[2]uint64]bool)
+	bySize := make(map[int64][]string)
+
+	err := filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files can be removed while the usage is being calculated, and unreadable
+			// directories should not prevent the rest from being counted.
+			if info != nil && info.IsDir() {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		if p == root {
+			return nil
+		}
+
+		rel := strings.TrimPrefix(p, root+string(filepath.Separator))
+		top := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+
+		e, ok := entries[top]
+		if !ok {
+			e = &DiskUsageEntry{Name: top, Directory: info.IsDir(), ModifiedAt: info.ModTime()}
+			entries[top] = e
+		}
+
+		if info.IsDir() {
+			u.Directories++
+			return nil
+		}
+
+		u.Files++
+		e.Files++
+
+		if opts.Checksums && !e.Directory && info.Mode().IsRegular() {
+			if e.Checksum, err = fs.checksum(p, info); err != nil {
+				e.Checksum = ""
+			}
+		}
+
+		if id, ok := hardLinkIdentity(info); ok {
+			if linked[id] {
+				u.LinkedSize += info.Size()
+				return nil
+			}
+
+			linked[id] = true
+		}
+
+		u.Size += info.Size()
+		e.Size += info.Size()
+
+		if opts.Checksums && info.Mode().IsRegular() && info.Size() >= opts.MinDuplicateSize {
+			bySize[info.Size()] = append(bySize[info.Size()], p)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		u.Entries = append(u.Entries, *e)
+	}
+
+	sort.Slice(u.Entries, func(i, j int) bool {
+		if u.Entries[i].Size == u.Entries[j].Size {
+			return u.Entries[i].Name < u.Entries[j].Name
+		}
+
+		return u.Entries[i].Size > u.Entries[j].Size
+	})
+
+	if opts.Checksums {
+		if u.Duplicates, err = fs.findDuplicates(ctx, bySize); err != nil {
+			return nil, err
+		}
+	}
+
+	u.CalculatedAt = time.Now().UTC()
+
+	return u, nil
+}
+
+// Returns the groups of files that have the same contents. Only files that share their
+// size with another file are hashed.
+func (fs *Filesystem) findDuplicates(ctx context.Context, bySize map[int64][]string) ([]DuplicateFiles, error) {
+	out := []DuplicateFiles{}
+
+	for size, paths := range bySize {
+		if len(paths) < 2 {
+			continue
+		}
+
+		byChecksum := make(map[string][]string)
+		for _, p := range paths {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			info, err := os.Lstat(p)
+			if err != nil {
+				continue
+			}
+
+			sum, err := fs.checksum(p, info)
+			if err != nil {
+				continue
+			}
+
+			byChecksum[sum] = append(byChecksum[sum], filepath.ToSlash(strings.TrimPrefix(p, fs.Path())))
+		}
+
+		for sum, matches := range byChecksum {
+			if len(matches) < 2 {
+				continue
+			}
+
+			sort.Strings(matches)
+			out = append(out, DuplicateFiles{
+				Checksum: sum,
+				Size:     size,
+				Paths:    matches,
+				Wasted:   size * int64(len(matches)-1),
+			})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Wasted == out[j].Wasted {
+			return out[i].Checksum < out[j].Checksum
+		}
+
+		return out[i].Wasted > out[j].Wasted
+	})
+
+	if len(out) > maxDuplicateGroups {
+		out = out[:maxDuplicateGroups]
+	}
+
+	return out, nil
+}
+
+// Returns the SHA-256 checksum of a file. Checksums are cached for as long as the size and
+// modification time of the file are unchanged, so files are not read again each time the
+// disk usage is calculated.
+func (fs *Filesystem) checksum(p string, info os.FileInfo) (string, error) {
+	key := "checksum:" + p
+	if x, exists := fs.Server.Cache.Get(key); exists {
+		if c := x.(cachedChecksum); c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+			return c.sum, nil
+		}
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	sum := hex.EncodeToString(h.Sum(nil))
+	fs.Server.Cache.Set(key, cachedChecksum{size: info.Size(), modTime: info.ModTime(), sum: sum}, cache.DefaultExpiration)
+
+	return sum, nil
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"os"
 	"time"
 )
 
@@ -9,4 +10,9 @@ import (
 // for right now.
 func (s *Stat) CTime() time.Time {
 	return s.Info.ModTime()
-}
\ No newline at end of file
+}
+
+// Hard links are not detected on windows, so every file is counted.
+func hardLinkIdentity(_ os.FileInfo) ([2]uint64, bool) {
+	return [2]uint64{}, false
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/firewall"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+)
+
+// Programs the flood protection rules for each of the server's allocations into the
+// host firewall. This is a no-op unless the firewall is enabled for the node.
+//
+// Servers using host networking also have their allocations opened in the firewall,
+// which Docker would otherwise handle when publishing the ports of a container.
+func (s *Server) SyncFirewall() error {
+	cfg := s.Filesystem.Configuration.Firewall
+	if !cfg.Enabled {
+		return nil
+	}
+
+	host := s.Network.Mode == "host"
+
+	var rules []firewall.Rule
+	for ip, ports := range s.Allocations.Bindings() {
+		for _, port := range ports {
+			r := s.floodProtectionRule(cfg, config.TrimAddressBrackets(ip), port)
+			r.Accept = host
+
+			if r.Accept || r.MaxConnections > 0 || r.UdpNewPerSecond > 0 {
+				rules = append(rules, r)
+			}
+		}
+	}
+
+	// Port ranges are limited as a whole using a single rule for each limit, rather than a
+	// rule for every port in the range.
+	for _, pr := range s.portRanges() {
+		r := s.floodProtectionRule(cfg, config.TrimAddressBrackets(pr.Ip), pr.Start)
+		r.EndPort = pr.End
+		r.Protocol = pr.Protocol
+		r.Accept = host
+
+		if r.Accept || r.MaxConnections > 0 || r.UdpNewPerSecond > 0 {
+			rules = append(rules, r)
+		}
+	}
+
+	zap.S().Debugw("applying firewall rules for server allocations", zap.String("server", s.Uuid), zap.Int("rules", len(rules)), zap.Bool("host", host))
+
+	return firewall.Apply(s.Uuid, rules, host)
+}
+
+// Removes the flood protection rules for the server from the host firewall.
+func (s *Server) RemoveFirewall() error {
+	if !s.Filesystem.Configuration.Firewall.Enabled {
+		return nil
+	}
+
+	return firewall.Remove(s.Uuid)
+}
+
+// Returns the limits for a single allocation, falling back to the node defaults for any
+// limits that are not defined for the allocation.
+func (s *Server) floodProtectionRule(cfg config.FirewallConfiguration, ip string, port int) firewall.Rule {
+	r := firewall.Rule{
+		Ip:              ip,
+		Port:            port,
+		MaxConnections:  cfg.MaxConnections,
+		UdpNewPerSecond: cfg.UdpNewPerSecond,
+		UdpBurst:        cfg.UdpBurst,
+	}
+
+	if fp, ok := s.Allocations.FloodProtection[net.JoinHostPort(ip, strconv.Itoa(port))]; ok {
+		if fp.MaxConnections > 0 {
+			r.MaxConnections = fp.MaxConnections
+		}
+
+		if fp.UdpNewPerSecond > 0 {
+			r.UdpNewPerSecond = fp.UdpNewPerSecond
+			r.UdpBurst = fp.UdpBurst
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pterodactyl/wings/config"
+	"sort"
+	"sync"
+)
+
+// Held while checking the group quota and node admission for a server that is starting
+// until it has been marked as starting, so that two servers starting at once cannot both
+// be allowed by the same headroom.
+var groupQuotaLock sync.Mutex
+
+// The resources claimed by the running servers in a group, compared with its quota.
+type GroupQuotaUsage struct {
+	Owner   string            `json:"owner"`
+	Quota   config.GroupQuota `json:"quota"`
+	Memory  int64             `json:"memory"`
+	Cpu     int64             `json:"cpu"`
+	Disk    int64             `json:"disk"`
+	Servers []string          `json:"servers"`
+}
+
+// Determines if the server claims resources from the quota of its group. Servers count
+// from the moment they begin starting until they are completely stopped.
+func (s *Server) claimsGroupQuota() bool {
+	return s.State != ProcessOfflineState
+}
+
+// Returns the current usage of every group that has a quota defined on the node.
+func GroupQuotas() []GroupQuotaUsage {
+	quotas := config.Get().System.GroupQuotas
+
+	var out []GroupQuotaUsage
+	for owner, q := range quotas {
+		out = append(out, groupQuotaUsage(owner, q, ""))
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Owner < out[j].Owner
+	})
+
+	return out
+}
+
+// Totals the resources of the running servers that belong to the owner, skipping the
+// server with the excluded UUID.
+func groupQuotaUsage(owner string, q config.GroupQuota, exclude string) GroupQuotaUsage {
+	u := GroupQuotaUsage{Owner: owner, Quota: q, Servers: []string{}}
+
+	for _, s := range GetServers().Filter(func(s *Server) bool {
+		return s.Owner == owner && s.Uuid != exclude && s.claimsGroupQuota()
+	}) {
+		u.Memory += s.effectiveMemoryLimit()
+		u.Cpu += s.Build.CpuLimit
+		u.Disk += s.Build.DiskSpace
+		u.Servers = append(u.Servers, s.Uuid)
+	}
+
+	sort.Strings(u.Servers)
+
+	return u
+}
+
+// Checks that starting the server would not take the combined resources of the running
+// servers in its group beyond the quota defined for the group. Servers without an owner,
+// or whose owner has no quota, can always be started. A server with no limit on a
+// resource that is limited for its group cannot be started, since it could use the
+// entire quota by itself.
+func (s *Server) CheckGroupQuota() error {
+	return s.checkGroupQuota(nil, true)
+}
+
+// Checks that the server can be started, in the same way as when it boots, so that the
+// reason it would not start can be returned to the Panel up front. No warnings are logged
+// for overcommit on a node that only warns about it, since they are logged when the
+// server boots.
+func (s *Server) CheckStart() error {
+	return s.checkGroupQuota(nil, false)
+}
+
+// Checks the group quota and node admission for the server, calling claim while the
+// quota is still held if the server is allowed to start. If warn is false overcommit on
+// a node that only warns about it is not logged.
+func (s *Server) checkGroupQuota(claim func(), warn bool) error {
+	groupQuotaLock.Lock()
+	defer groupQuotaLock.Unlock()
+
+	if err := s.exceedsGroupQuota(); err != nil {
+		return err
+	}
+
+	if err := s.checkAdmission(false, warn); err != nil {
+		return err
+	}
+
+	if claim != nil {
+		claim()
+	}
+
+	return nil
+}
+
+func (s *Server) exceedsGroupQuota() error {
+	if s.Owner == "" {
+		return nil
+	}
+
+	q, ok := config.Get().System.GroupQuotas[s.Owner]
+	if !ok {
+		return nil
+	}
+
+	u := groupQuotaUsage(s.Owner, q, s.Uuid)
+
+	checks := []struct {
+		name  string
+		unit  string
+		quota int64
+		used  int64
+		want  int64
+	}{
+		{"memory", "MB", q.Memory, u.Memory, s.effectiveMemoryLimit()},
+		{"cpu", "%", q.Cpu, u.Cpu, s.Build.CpuLimit},
+		{"disk", "MB", q.Disk, u.Disk, s.Build.DiskSpace},
+	}
+
+	for _, c := range checks {
+		if c.quota <= 0 {
+			continue
+		}
+
+		if c.want <= 0 {
+			return &groupQuotaError{message: fmt.Sprintf("server has no %s limit but its group \"%s\" has a %s quota", c.name, s.Owner, c.name)}
+		}
+
+		if c.used+c.want > c.quota {
+			return &groupQuotaError{
+				message: fmt.Sprintf(
+					"starting the server would exceed the %s quota of its group \"%s\" (%d%s in use of %d%s, server requires %d%s)",
+					c.name, s.Owner, c.used, c.unit, c.quota, c.unit, c.want, c.unit,
+				),
+			}
+		}
+	}
+
+	return nil
+}
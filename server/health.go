@@ -0,0 +1,268 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defines the health states of a running server.
+const (
+	HealthStarting  = "starting"
+	HealthHealthy   = "healthy"
+	HealthUnhealthy = "unhealthy"
+)
+
+// Tracks the health checks for a running server process.
+type healthMonitor struct {
+	mu sync.Mutex
+
+	status        string
+	failures      int
+	lastHeartbeat time.Time
+	cancel        context.CancelFunc
+}
+
+// Returns the health of the server process, or an empty string if the server is not
+// running or does not have a health check defined.
+func (s *Server) Health() string {
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+
+	return s.health.status
+}
+
+// Starts running the health check defined for the server in the background, replacing
+// any checks that are already running.
+func (s *Server) startHealthChecks() {
+	s.stopHealthChecks()
+
+	if s.processConfiguration == nil || s.processConfiguration.HealthCheck.Type == "" {
+		return
+	}
+
+	hc := withHealthCheckDefaults(s.processConfiguration.HealthCheck)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.health.mu.Lock()
+	s.health.cancel = cancel
+	s.health.failures = 0
+	s.health.lastHeartbeat = time.Now()
+	s.health.mu.Unlock()
+
+	s.setHealth(HealthStarting)
+
+	go func() {
+		defer supervisor.Recover("health check")
+
+		ticker := time.NewTicker(time.Duration(hc.Interval) * time.Second)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				s.onHealthCheckResult(ctx, hc, s.runHealthCheck(hc))
+			}
+		}
+	}()
+}
+
+// Stops any health checks running for the server and clears its health state.
+func (s *Server) stopHealthChecks() {
+	s.health.mu.Lock()
+	if s.health.cancel != nil {
+		s.health.cancel()
+		s.health.cancel = nil
+	}
+	s.health.mu.Unlock()
+
+	s.setHealth("")
+}
+
+// Records a heartbeat if the line of console output matches the heartbeat pattern.
+func (s *Server) recordHeartbeat(line string) {
+	if s.processConfiguration == nil {
+		return
+	}
+
+	hc := s.processConfiguration.HealthCheck
+	if hc.Type != api.HealthCheckHeartbeat || hc.Pattern == "" || !strings.Contains(line, hc.Pattern) {
+		return
+	}
+
+	s.health.mu.Lock()
+	s.health.lastHeartbeat = time.Now()
+	s.health.mu.Unlock()
+}
+
+// Updates the health of the server based on the result of a single check, restarting the
+// server if it has failed too many checks in a row.
+func (s *Server) onHealthCheckResult(ctx context.Context, hc api.HealthCheck, err error) {
+	s.health.mu.Lock()
+	if err == nil {
+		s.health.failures = 0
+	} else {
+		s.health.failures++
+	}
+	failures := s.health.failures
+	s.health.mu.Unlock()
+
+	if err == nil {
+		s.setHealth(HealthHealthy)
+		return
+	}
+
+	zap.S().Debugw("server failed health check", zap.String("server", s.Uuid), zap.Int("failures", failures), zap.Error(err))
+
+	if failures == hc.Retries {
+		zap.S().Warnw("server marked as unhealthy after failing health checks", zap.String("server", s.Uuid), zap.Int("failures", failures), zap.Error(err))
+
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server marked as unhealthy after %d failed health checks: %s", failures, err.Error()))
+	}
+
+	if failures >= hc.Retries {
+		s.setHealth(HealthUnhealthy)
+	}
+
+	if hc.RestartAfter > 0 && failures >= hc.RestartAfter && ctx.Err() == nil {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Restarting server after %d failed health checks...", failures))
+
+		// The restart stops the health checks for the server, so it cannot run in the
+		// same routine as them.
+		go func() {
+			defer supervisor.Recover("health check")
+
+			if err := s.gracefulRestart(time.Second*60, fmt.Sprintf("the server failed %d health checks", failures)); err != nil {
+				zap.S().Errorw("failed to restart unhealthy server", zap.String("server", s.Uuid), zap.Error(err))
+			}
+		}()
+
+		s.stopHealthChecks()
+	}
+}
+
+// Sets the health of the server, notifying any listeners if it has changed.
+func (s *Server) setHealth(status string) {
+	s.health.mu.Lock()
+	changed := s.health.status != status
+	s.health.status = status
+	s.health.mu.Unlock()
+
+	if changed {
+		s.Events().Publish(HealthEvent, status)
+	}
+}
+
+// Performs a single health check, returning an error if it failed.
+func (s *Server) runHealthCheck(hc api.HealthCheck) error {
+	timeout := time.Duration(hc.Timeout) * time.Second
+
+	switch hc.Type {
+	case api.HealthCheckTcp:
+		ip := s.Allocations.DefaultMapping.Ip
+		if ip == "" || ip == "0.0.0.0" {
+			ip = "127.0.0.1"
+		}
+
+		port := hc.Port
+		if port == 0 {
+			port = s.Allocations.DefaultMapping.Port
+		}
+
+		conn, err := net.DialTimeout("tcp", net.JoinHostPort(ip, strconv.Itoa(port)), timeout)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		return conn.Close()
+	case api.HealthCheckCommand:
+		e, ok := s.Environment.(interface {
+			Exec(cmd []string, timeout time.Duration) (int, error)
+		})
+		if !ok {
+			return errors.New("server environment does not support command health checks")
+		}
+
+		code, err := e.Exec(hc.Command, timeout)
+		if err != nil {
+			return err
+		} else if code != 0 {
+			return errors.Errorf("health check command exited with code %d", code)
+		}
+
+		return nil
+	case api.HealthCheckHeartbeat:
+		s.health.mu.Lock()
+		last := s.health.lastHeartbeat
+		s.health.mu.Unlock()
+
+		if since := time.Since(last); since > time.Duration(hc.Interval)*time.Second {
+			return errors.Errorf("no heartbeat seen in console output for %s", since.Round(time.Second))
+		}
+
+		return nil
+	}
+
+	return errors.Errorf("unknown health check type \"%s\"", hc.Type)
+}
+
+// Returns the health check with defaults filled in for any values that were not set.
+func withHealthCheckDefaults(hc api.HealthCheck) api.HealthCheck {
+	if hc.Interval <= 0 {
+		hc.Interval = 30
+	}
+
+	if hc.Timeout <= 0 {
+		hc.Timeout = 5
+	}
+
+	if hc.Retries <= 0 {
+		hc.Retries = 3
+	}
+
+	return hc
+}
+
+// Executes a command in the running container, returning the exit code once it has
+// finished. An error is returned if the command does not finish within the timeout.
+func (d *DockerEnvironment) Exec(cmd []string, timeout time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	id, err := d.Client.ContainerExecCreate(ctx, d.Server.Uuid, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	if err := d.Client.ContainerExecStart(ctx, id.ID, types.ExecStartCheck{}); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	for {
+		res, err := d.Client.ContainerExecInspect(ctx, id.ID)
+		if err != nil {
+			return 0, errors.WithStack(err)
+		}
+
+		if !res.Running {
+			return res.ExitCode, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return 0, errors.New("health check command timed out")
+		case <-time.After(time.Millisecond * 250):
+		}
+	}
+}
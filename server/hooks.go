@@ -0,0 +1,20 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/hooks"
+	"strconv"
+)
+
+// Runs the lifecycle hooks registered for the event, passing along details about the
+// server and any data specific to the event.
+func (s *Server) fireHook(event string, data map[string]string) {
+	if data == nil {
+		data = make(map[string]string)
+	}
+
+	data["server_ip"] = s.Allocations.DefaultMapping.Ip
+	data["server_port"] = strconv.Itoa(s.Allocations.DefaultMapping.Port)
+	data["server_image"] = s.Container.Image
+
+	hooks.Fire(hooks.Event{Event: event, Server: s.Uuid, State: s.State, Data: data})
+}
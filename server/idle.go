@@ -0,0 +1,38 @@
+package server
+
+import (
+	"fmt"
+	"go.uber.org/zap"
+	"time"
+)
+
+// Tracks how long the server has had no players connected, stopping it once it has been
+// empty for longer than its idle timeout. The time at which the server became empty is
+// stored in emptySince between calls. Returns true if the server is being stopped.
+func (s *Server) checkIdle(pc *PlayerCount, emptySince *time.Time) bool {
+	if s.IdleTimeout <= 0 || pc.Online > 0 {
+		*emptySince = time.Time{}
+		return false
+	}
+
+	if emptySince.IsZero() {
+		*emptySince = time.Now()
+		return false
+	}
+
+	if time.Since(*emptySince) < time.Duration(s.IdleTimeout)*time.Minute {
+		return false
+	}
+
+	zap.S().Infow("stopping server after it has been idle", zap.String("server", s.Uuid), zap.Int("timeout", s.IdleTimeout))
+
+	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Server has had no players for %d minutes, stopping it...", s.IdleTimeout))
+
+	s.SetStopReason(StopReasonIdle, fmt.Sprintf("the server had no players for %d minutes", s.IdleTimeout))
+	if err := s.Environment.Stop(); err != nil {
+		zap.S().Errorw("failed to stop idle server", zap.String("server", s.Uuid), zap.Error(err))
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"strings"
+)
+
+// Defines the policies that control when an image is pulled from its registry.
+const (
+	PullAlways       = "always"
+	PullIfNotPresent = "if-not-present"
+	PullNever        = "never"
+)
+
+// The registry used for images that do not include a registry host in their name.
+const defaultRegistry = "docker.io"
+
+// Returns the pull policy to use for an image. The policy defined for the image takes
+// priority, falling back to the node default. If neither is defined images are always
+// pulled when update_images is enabled for the node.
+func resolvePullPolicy(policy string) (string, error) {
+	if policy == "" {
+		policy = config.Get().Docker.PullPolicy
+	}
+
+	switch policy {
+	case PullAlways, PullIfNotPresent, PullNever:
+		return policy, nil
+	case "":
+		if config.Get().Docker.UpdateImages {
+			return PullAlways, nil
+		}
+
+		return PullIfNotPresent, nil
+	}
+
+	return "", errors.Errorf("invalid image pull policy \"%s\"", policy)
+}
+
+// Returns the image reference pinned to the given digest. If the image already includes
+// a digest, or no digest is provided, the image is returned as is.
+func pinnedImage(image string, digest string) string {
+	if digest == "" || strings.Contains(image, "@") {
+		return image
+	}
+
+	return image + "@" + digest
+}
+
+// Returns the registry host for an image reference. This follows the same rules as
+// Docker, the first component of the name is only treated as a registry host if it
+// contains a "." or ":", or is "localhost".
+func registryHost(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 {
+		return defaultRegistry
+	}
+
+	host := image[:i]
+	if host != "localhost" && !strings.ContainsAny(host, ".:") {
+		return defaultRegistry
+	}
+
+	if host == "index.docker.io" || host == "registry-1.docker.io" {
+		return defaultRegistry
+	}
+
+	return host
+}
+
+// Returns the encoded credentials to send along with a pull request for the image, or an
+// empty string if there are no credentials configured for the registry.
+func registryAuth(image string) (string, error) {
+	host := registryHost(image)
+
+	r, ok := config.Get().Docker.Registries[host]
+	if !ok {
+		return "", nil
+	}
+
+	b, err := json.Marshal(types.AuthConfig{
+		Username:      r.Username,
+		Password:      r.Password,
+		ServerAddress: host,
+	})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// Ensures an image exists locally according to the pull policy provided. Images pulled
+// from a registry with credentials defined in the configuration are authenticated.
+func pullImage(c *client.Client, image string, policy string) error {
+	policy, err := resolvePullPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	if policy != PullAlways {
+		if _, _, err := c.ImageInspectWithRaw(context.Background(), image); err == nil {
+			return nil
+		} else if !client.IsErrNotFound(err) {
+			return errors.WithStack(err)
+		}
+
+		if policy == PullNever {
+			return errors.Errorf("image \"%s\" does not exist locally and the pull policy is \"never\"", image)
+		}
+	}
+
+	// Docker Hub images are pulled through the registry mirrors shared by other nodes in
+	// the cluster when there are any, falling back to pulling the image directly.
+	if err := pullFromMirrors(c, image); err == nil {
+		return nil
+	}
+
+	if err := pullReference(c, image); err != nil {
+		// If the registry cannot be reached but there is already a copy of the image on
+		// the node continue using that rather than preventing the server from starting.
+		if _, _, ierr := c.ImageInspectWithRaw(context.Background(), image); ierr == nil {
+			zap.S().Warnw("failed to pull image, using the existing local copy", zap.String("image", image), zap.Error(err))
+			return nil
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Pulls an image from its registry, authenticating with any credentials that are
+// configured for the registry.
+func pullReference(c *client.Client, image string) error {
+	auth, err := registryAuth(image)
+	if err != nil {
+		return err
+	}
+
+	zap.S().Debugw("pulling docker image... this could take a bit of time", zap.String("image", image))
+
+	out, err := c.ImagePull(context.Background(), image, types.ImagePullOptions{RegistryAuth: auth})
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer out.Close()
+
+	// Block execution until the image is done being pulled. Any errors that occur during
+	// the pull are returned in the output stream rather than by the call above.
+	scanner := bufio.NewScanner(out)
+	for scanner.Scan() {
+		var m struct {
+			Error string `json:"error"`
+		}
+
+		if json.Unmarshal(scanner.Bytes(), &m) == nil && m.Error != "" {
+			return errors.Errorf("failed to pull image \"%s\": %s", image, m.Error)
+		}
+	}
+
+	return errors.WithStack(scanner.Err())
+}
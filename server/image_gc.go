@@ -0,0 +1,365 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Reports the results of the image garbage collector.
+type ImageGcStatus struct {
+	LastRun time.Time `json:"last_run"`
+	// The reason the last run was triggered, either "schedule" or "disk_pressure".
+	Trigger string `json:"trigger"`
+	// The number of images removed and the space reclaimed in bytes by the last run.
+	ImagesRemoved int    `json:"images_removed"`
+	Reclaimed     uint64 `json:"reclaimed"`
+	// The total space reclaimed in bytes since the daemon was started.
+	TotalReclaimed uint64 `json:"total_reclaimed"`
+	LastError      string `json:"last_error,omitempty"`
+}
+
+var imageGc = struct {
+	sync.RWMutex
+	status ImageGcStatus
+}{}
+
+// The file the time each image was last pulled or used by the daemon is kept in, so that
+// the minimum age of an image is measured from when it was pulled rather than when it was
+// built, which can be years earlier.
+const imagePullsFile = "data/image_pulls.json"
+
+// When an image was last pulled or used by the daemon, and whether it has been used to
+// install a server.
+type imagePull struct {
+	PulledAt  time.Time `json:"pulled_at"`
+	Installer bool      `json:"installer"`
+}
+
+var imagePulls = struct {
+	sync.Mutex
+	loaded bool
+	pulls  map[string]*imagePull
+}{pulls: make(map[string]*imagePull)}
+
+// Returns the results of the image garbage collector.
+func GetImageGcStatus() ImageGcStatus {
+	imageGc.RLock()
+	defer imageGc.RUnlock()
+
+	return imageGc.status
+}
+
+// Starts the background routine that removes images which are no longer used by any
+// server on the node. Disk usage is checked every minute so that collection can happen
+// ahead of schedule if the disk fills up. If collecting does not free enough space the
+// time until collection is triggered by the disk again is doubled each time, up to the
+// interval between scheduled runs, so that the runtime is not constantly listing images.
+func StartImageGarbageCollector(cfg *config.ImageGcConfiguration) {
+	go supervisor.Supervise("image gc", func() error {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		interval := time.Duration(cfg.Interval) * time.Minute
+		backoff := time.Minute
+
+		last := time.Now()
+		for range ticker.C {
+			trigger := ""
+			if time.Since(last) >= interval {
+				trigger = "schedule"
+			} else if cfg.DiskThreshold > 0 && time.Since(last) >= backoff && imageDiskUsage() >= cfg.DiskThreshold {
+				trigger = "disk_pressure"
+			}
+
+			if trigger == "" {
+				continue
+			}
+
+			last = time.Now()
+			if err := collectImages(cfg, trigger); err != nil {
+				zap.S().Warnw("failed to collect unused docker images", zap.Error(err))
+			}
+
+			if cfg.DiskThreshold > 0 && imageDiskUsage() >= cfg.DiskThreshold {
+				if backoff *= 2; backoff > interval {
+					backoff = interval
+				}
+			} else {
+				backoff = time.Minute
+			}
+		}
+
+		return nil
+	})
+}
+
+// Records that the image was pulled or used by the daemon. Images used to install a
+// server stay marked as installer images, and are never collected.
+func recordImagePull(c *client.Client, image string, installer bool) {
+	img, _, err := c.ImageInspectWithRaw(context.Background(), image)
+	if err != nil {
+		return
+	}
+
+	imagePulls.Lock()
+	defer imagePulls.Unlock()
+
+	loadImagePulls()
+
+	p, ok := imagePulls.pulls[img.ID]
+	if !ok {
+		p = &imagePull{}
+		imagePulls.pulls[img.ID] = p
+	}
+
+	p.PulledAt = time.Now().UTC()
+	p.Installer = p.Installer || installer
+
+	saveImagePulls()
+}
+
+// Loads the times images were pulled from the disk the first time they are needed. The
+// image pulls lock must be held.
+func loadImagePulls() {
+	if imagePulls.loaded {
+		return
+	}
+	imagePulls.loaded = true
+
+	b, err := ioutil.ReadFile(imagePullsFile)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			zap.S().Warnw("failed to read the times images were pulled", zap.Error(err))
+		}
+
+		return
+	}
+
+	if err := json.Unmarshal(b, &imagePulls.pulls); err != nil {
+		zap.S().Warnw("failed to parse the times images were pulled", zap.Error(err))
+	}
+
+	if imagePulls.pulls == nil {
+		imagePulls.pulls = make(map[string]*imagePull)
+	}
+}
+
+// Writes the times images were pulled to the disk. The image pulls lock must be held.
+func saveImagePulls() {
+	b, err := json.Marshal(imagePulls.pulls)
+	if err == nil {
+		tmp := imagePullsFile + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0600); err == nil {
+			err = os.Rename(tmp, imagePullsFile)
+		}
+	}
+
+	if err != nil {
+		zap.S().Warnw("failed to save the times images were pulled", zap.Error(err))
+	}
+}
+
+// Returns the percentage of the disk used by the container runtime that is currently in
+// use. If this cannot be determined 0 is returned.
+func imageDiskUsage() int {
+	cli, err := NewRuntimeClient()
+	if err != nil {
+		return 0
+	}
+	defer cli.Close()
+
+	info, err := cli.Info(context.Background())
+	if err != nil || info.DockerRootDir == "" {
+		return 0
+	}
+
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(info.DockerRootDir, &st); err != nil || st.Blocks == 0 {
+		return 0
+	}
+
+	return int(100 - (st.Bavail * 100 / st.Blocks))
+}
+
+// Removes dangling images, images that are not referenced by any server on the node and
+// the build cache. Images used by any container, or matching one of the images that
+// should always be kept, are never removed.
+func collectImages(cfg *config.ImageGcConfiguration, trigger string) error {
+	cli, err := NewRuntimeClient()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer cli.Close()
+
+	zap.S().Infow("collecting unused docker images", zap.String("trigger", trigger))
+
+	removed, reclaimed, err := removeUnusedImages(cli, cfg)
+
+	// The build cache is only created by servers using a Dockerfile, so failing to prune
+	// it should not prevent reporting the images that were removed.
+	if r, perr := cli.BuildCachePrune(context.Background()); perr == nil {
+		reclaimed += r.SpaceReclaimed
+	} else {
+		zap.S().Debugw("failed to prune docker build cache", zap.Error(perr))
+	}
+
+	imageGc.Lock()
+	imageGc.status.LastRun = time.Now().UTC()
+	imageGc.status.Trigger = trigger
+	imageGc.status.ImagesRemoved = removed
+	imageGc.status.Reclaimed = reclaimed
+	imageGc.status.TotalReclaimed += reclaimed
+	imageGc.status.LastError = ""
+	if err != nil {
+		imageGc.status.LastError = err.Error()
+	}
+	imageGc.Unlock()
+
+	zap.S().Infow("finished collecting unused docker images", zap.Int("removed", removed), zap.Uint64("reclaimed", reclaimed))
+
+	return err
+}
+
+func removeUnusedImages(cli *client.Client, cfg *config.ImageGcConfiguration) (int, uint64, error) {
+	ctx := context.Background()
+
+	images, err := cli.ImageList(ctx, types.ImageListOptions{All: false})
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+
+	containers, err := cli.ContainerList(ctx, types.ContainerListOptions{All: true})
+	if err != nil {
+		return 0, 0, errors.WithStack(err)
+	}
+
+	inUse := make(map[string]bool)
+	for _, c := range containers {
+		inUse[c.ImageID] = true
+	}
+
+	referenced := referencedImages()
+	minAge := time.Duration(cfg.MinAge) * time.Hour
+
+	imagePulls.Lock()
+	defer imagePulls.Unlock()
+
+	loadImagePulls()
+	defer saveImagePulls()
+
+	// Images that no longer exist are forgotten.
+	present := make(map[string]bool, len(images))
+	for _, img := range images {
+		present[img.ID] = true
+	}
+
+	for id := range imagePulls.pulls {
+		if !present[id] {
+			delete(imagePulls.pulls, id)
+		}
+	}
+
+	var removed int
+	var reclaimed uint64
+	for _, img := range images {
+		// An image the daemon has not seen pulled is treated as pulled now, since there is
+		// no way to know when it was, so it is only removed once the minimum age passes.
+		p, ok := imagePulls.pulls[img.ID]
+		if !ok {
+			imagePulls.pulls[img.ID] = &imagePull{PulledAt: time.Now().UTC()}
+			continue
+		}
+
+		if inUse[img.ID] || p.Installer || time.Since(p.PulledAt) < minAge {
+			continue
+		}
+
+		if imageReferenced(img, referenced) || imageKept(img, cfg.Keep) {
+			continue
+		}
+
+		if _, err := cli.ImageRemove(ctx, img.ID, types.ImageRemoveOptions{Force: false, PruneChildren: true}); err != nil {
+			zap.S().Debugw("failed to remove unused docker image", zap.String("image", img.ID), zap.Error(err))
+			continue
+		}
+
+		delete(imagePulls.pulls, img.ID)
+
+		removed++
+		reclaimed += uint64(img.Size)
+	}
+
+	return removed, reclaimed, nil
+}
+
+// Returns the normalized references of the images used by every server on the node.
+func referencedImages() map[string]bool {
+	m := make(map[string]bool)
+
+	for _, s := range GetServers().All() {
+		if d, ok := s.Environment.(*DockerEnvironment); ok {
+			m[normalizeImage(d.image())] = true
+		} else if s.Container.Image != "" {
+			m[normalizeImage(s.Container.Image)] = true
+		}
+	}
+
+	return m
+}
+
+// Determines if any of the tags or digests of the image are referenced by a server. Images
+// without any tags are dangling and never referenced.
+func imageReferenced(img types.ImageSummary, referenced map[string]bool) bool {
+	for _, ref := range append(img.RepoTags, img.RepoDigests...) {
+		if referenced[normalizeImage(ref)] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Determines if the image matches any of the patterns for images that are always kept.
+func imageKept(img types.ImageSummary, keep []string) bool {
+	for _, tag := range img.RepoTags {
+		if matchesAny(normalizeImage(tag), keep) || matchesAny(tag, keep) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Normalizes an image reference so that references to the same image can be compared.
+// Docker Hub prefixes are removed and images without a tag or digest use "latest".
+func normalizeImage(image string) string {
+	// A reference pinned to a digest is compared using only the digest.
+	if i := strings.Index(image, "@"); i != -1 {
+		name := image[:i]
+		if j := strings.LastIndex(name, ":"); j > strings.LastIndex(name, "/") {
+			name = name[:j]
+		}
+
+		image = name + image[i:]
+	} else if strings.LastIndex(image, ":") <= strings.LastIndex(image, "/") {
+		image += ":latest"
+	}
+
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "library/"} {
+		image = strings.TrimPrefix(image, prefix)
+	}
+
+	return image
+}
@@ -10,19 +10,34 @@ import (
 	"github.com/docker/docker/client"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/metrics"
+	"github.com/pterodactyl/wings/tempdir"
 	"go.uber.org/zap"
 	"io"
-	"io/ioutil"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
+	"time"
 )
 
 // Executes the installation stack for a server process. Bubbles any errors up to the calling
 // function which should handle contacting the panel to notify it of the server state.
 func (s *Server) Install() error {
+	start := time.Now()
 	err := s.internalInstall()
 
+	metrics.ServerInstallDuration.Observe(time.Since(start).Seconds(), strconv.FormatBool(err == nil))
+
+	// The installation replaces the monitored files, so record a new baseline for them
+	// the next time they are checked.
+	if err == nil {
+		if rerr := s.RemoveIntegrityBaseline(); rerr != nil {
+			zap.S().Warnw("failed to remove integrity baseline after installation", zap.String("server", s.Uuid), zap.Error(rerr))
+		}
+	}
+
 	zap.S().Debugw("notifying panel of server install state", zap.String("server", s.Uuid))
 	if serr := s.SyncInstallState(err == nil); serr != nil {
 		zap.S().Warnw(
@@ -47,15 +62,28 @@ func (s *Server) internalInstall() error {
 		return errors.New(rerr.String())
 	}
 
-	p, err := NewInstallationProcess(s, &script)
-	if err != nil {
-		return errors.WithStack(err)
+	if err := verifyInstallationScript(s, &script); err != nil {
+		s.Events().Publish(DaemonMessageEvent, "Installation script failed verification and will not be run.")
+
+		return err
 	}
 
 	zap.S().Infow("beginning installation process for server", zap.String("server", s.Uuid))
 
-	if err := p.Run(); err != nil {
-		return err
+	if len(script.Steps) > 0 {
+		p := &InstallPipeline{Server: s, Script: &script}
+		if err := p.Run(); err != nil {
+			return err
+		}
+	} else {
+		p, err := NewInstallationProcess(s, &script)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if err := p.Run(); err != nil {
+			return err
+		}
 	}
 
 	zap.S().Infow("completed installation process for server", zap.String("server", s.Uuid))
@@ -69,6 +97,10 @@ type InstallationProcess struct {
 
 	client *client.Client
 	mutex  *sync.Mutex
+
+	// If true the output of the installation is appended to the existing installation
+	// log for the server rather than replacing it.
+	appendLog bool
 }
 
 // Generates a new installation process struct that will be used to create containers,
@@ -80,7 +112,7 @@ func NewInstallationProcess(s *Server, script *api.InstallationScript) (*Install
 		mutex:  &sync.Mutex{},
 	}
 
-	if c, err := client.NewClientWithOpts(client.FromEnv); err != nil {
+	if c, err := NewRuntimeClient(); err != nil {
 		return nil, errors.WithStack(err)
 	} else {
 		proc.client = c
@@ -95,10 +127,15 @@ func NewInstallationProcess(s *Server, script *api.InstallationScript) (*Install
 // Once the container finishes installing the results will be stored in an installation
 // log in the server's configuration directory.
 func (ip *InstallationProcess) Run() error {
+	if err := ip.validatePolicy(); err != nil {
+		return err
+	}
+
 	installPath, err := ip.BeforeExecute()
 	if err != nil {
 		return err
 	}
+	defer os.RemoveAll(installPath)
 
 	cid, err := ip.Execute(installPath)
 	if err != nil {
@@ -117,13 +154,19 @@ func (ip *InstallationProcess) Run() error {
 // Writes the installation script to a temporary file on the host machine so that it
 // can be properly mounted into the installation container and then executed.
 func (ip *InstallationProcess) writeScriptToDisk() (string, error) {
-	d, err := ioutil.TempDir("", "pterodactyl")
+	i, err := ip.interpreter()
 	if err != nil {
-		return "", errors.WithStack(err)
+		return "", err
+	}
+
+	d, err := tempdir.Dir("install-")
+	if err != nil {
+		return "", err
 	}
 
-	f, err := os.OpenFile(filepath.Join(d, "install.sh"), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	f, err := os.OpenFile(filepath.Join(d, "install"+i.Extension), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
+		os.RemoveAll(d)
 		return "", errors.WithStack(err)
 	}
 	defer f.Close()
@@ -146,24 +189,21 @@ func (ip *InstallationProcess) writeScriptToDisk() (string, error) {
 
 // Pulls the docker image to be used for the installation container.
 func (ip *InstallationProcess) pullInstallationImage() error {
-	r, err := ip.client.ImagePull(context.Background(), ip.Script.ContainerImage, types.ImagePullOptions{})
-	if err != nil {
-		return errors.WithStack(err)
-	}
-
-	// Block continuation until the image has been pulled successfully.
-	scanner := bufio.NewScanner(r)
-	for scanner.Scan() {
-		zap.S().Debugw(scanner.Text())
+	if err := pullImage(ip.client, ip.image(), ip.Script.PullPolicy); err != nil {
+		return err
 	}
 
-	if err := scanner.Err(); err != nil {
-		return errors.WithStack(err)
-	}
+	recordImagePull(ip.client, ip.image(), true)
 
 	return nil
 }
 
+// Returns the image used for the installation container, pinned to the digest provided
+// by the Panel if there is one.
+func (ip *InstallationProcess) image() string {
+	return pinnedImage(ip.Script.ContainerImage, ip.Script.ContainerImageDigest)
+}
+
 // Runs before the container is executed. This pulls down the required docker container image
 // as well as writes the installation script to the disk. This process is executed in an async
 // manner, if either one fails the error is returned.
@@ -212,6 +252,10 @@ func (ip *InstallationProcess) BeforeExecute() (string, error) {
 	// Maybe a better way to handle this, but if there is at least one error
 	// just bail out of the process now.
 	if len(e) > 0 {
+		if fileName != "" {
+			os.RemoveAll(fileName)
+		}
+
 		return "", errors.WithStack(e[0])
 	}
 
@@ -235,7 +279,12 @@ func (ip *InstallationProcess) AfterExecute(containerId string) error {
 		return errors.WithStack(err)
 	}
 
-	f, err := os.OpenFile(filepath.Join("data/install_logs/", ip.Server.Uuid+".log"), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if ip.appendLog {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+
+	f, err := os.OpenFile(filepath.Join("data/install_logs/", ip.Server.Uuid+".log"), flags, 0600)
 	if err != nil {
 		return errors.WithStack(err)
 	}
@@ -265,10 +314,15 @@ func (ip *InstallationProcess) AfterExecute(containerId string) error {
 func (ip *InstallationProcess) Execute(installPath string) (string, error) {
 	ctx := context.Background()
 
+	i, err := ip.interpreter()
+	if err != nil {
+		return "", err
+	}
+
 	zap.S().Debugw(
 		"creating server installer container",
 		zap.String("server", ip.Server.Uuid),
-		zap.String("script_path", installPath+"/install.sh"),
+		zap.String("script_path", installPath+"/install"+i.Extension),
 	)
 
 	conf := &container.Config{
@@ -278,8 +332,8 @@ func (ip *InstallationProcess) Execute(installPath string) (string, error) {
 		AttachStdin:  true,
 		OpenStdin:    true,
 		Tty:          true,
-		Cmd:          []string{ip.Script.Entrypoint, "./mnt/install/install.sh"},
-		Image:        ip.Script.ContainerImage,
+		Cmd:          append(append([]string{}, i.Entrypoint...), "./mnt/install/install"+i.Extension),
+		Image:        ip.image(),
 		Env:          ip.Server.GetEnvironmentVariables(),
 		Labels: map[string]string{
 			"Service":       "Pterodactyl",
@@ -305,19 +359,15 @@ func (ip *InstallationProcess) Execute(installPath string) (string, error) {
 		Tmpfs: map[string]string{
 			"/tmp": "rw,exec,nosuid,size=50M",
 		},
-		DNS: []string{"1.1.1.1", "8.8.8.8"},
-		LogConfig: container.LogConfig{
-			Type: "local",
-			Config: map[string]string{
-				"max-size": "5m",
-				"max-file": "1",
-				"compress": "false",
-			},
-		},
+		DNS:         config.Get().Docker.Dns,
+		LogConfig:   Runtime().InstallerLogConfig(),
 		Privileged:  true,
-		NetworkMode: "pterodactyl_nw",
+		NetworkMode: container.NetworkMode(config.Get().Docker.Network.Name),
 	}
 
+	release := holdNetwork()
+	defer release()
+
 	zap.S().Infow("creating installer container for server process", zap.String("server", ip.Server.Uuid))
 	r, err := ip.client.ContainerCreate(ctx, conf, hostConf, nil, ip.Server.Uuid+"_installer")
 	if err != nil {
@@ -375,7 +425,7 @@ func (ip *InstallationProcess) StreamOutput(id string) error {
 
 	s := bufio.NewScanner(reader)
 	for s.Scan() {
-		ip.Server.Events().Publish(InstallOutputEvent, s.Text())
+		ip.Server.Events().Publish(InstallOutputEvent, ip.Server.Redact(s.Text()))
 	}
 
 	if err := s.Err(); err != nil {
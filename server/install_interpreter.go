@@ -0,0 +1,72 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"path"
+)
+
+// The interpreters that are available to installation scripts by default.
+var defaultInterpreters = map[string]config.InstallerInterpreter{
+	"bash":   {Entrypoint: []string{"bash"}, Extension: ".sh"},
+	"ash":    {Entrypoint: []string{"ash"}, Extension: ".sh"},
+	"sh":     {Entrypoint: []string{"sh"}, Extension: ".sh"},
+	"python": {Entrypoint: []string{"python3", "-u"}, Extension: ".py"},
+	"pwsh":   {Entrypoint: []string{"pwsh", "-NonInteractive", "-NoProfile", "-File"}, Extension: ".ps1"},
+}
+
+// Returns the interpreter to use when executing the installation script. Scripts that
+// do not declare an interpreter are run using their entrypoint, which is how scripts
+// were always executed before interpreters could be declared. The name of the program
+// the entrypoint runs is then checked against the allowed interpreters instead, so that
+// the entrypoint cannot be used to run something the node does not allow.
+func (ip *InstallationProcess) interpreter() (*config.InstallerInterpreter, error) {
+	cfg := ip.Server.Filesystem.Configuration.Installer
+
+	name := ip.Script.Interpreter
+	if name == "" {
+		entrypoint := ip.Script.Entrypoint
+		if len(cfg.AllowedInterpreters) > 0 && !matchesAny(path.Base(entrypoint), cfg.AllowedInterpreters) {
+			return nil, errors.Errorf("installation script entrypoint \"%s\" is not an allowed interpreter on this node", entrypoint)
+		}
+
+		return &config.InstallerInterpreter{Entrypoint: []string{entrypoint}, Extension: ".sh"}, nil
+	}
+
+	if len(cfg.AllowedInterpreters) > 0 && !matchesAny(name, cfg.AllowedInterpreters) {
+		return nil, errors.Errorf("installation script interpreter \"%s\" is not allowed on this node", name)
+	}
+
+	if i, ok := cfg.Interpreters[name]; ok && len(i.Entrypoint) > 0 {
+		return &i, nil
+	}
+
+	if i, ok := defaultInterpreters[name]; ok {
+		return &i, nil
+	}
+
+	return nil, errors.Errorf("installation script interpreter \"%s\" is not supported", name)
+}
+
+// Validates the installation script against the installer policy of the node.
+func (ip *InstallationProcess) validatePolicy() error {
+	allowed := ip.Server.Filesystem.Configuration.Installer.AllowedImages
+	if len(allowed) > 0 && !matchesAny(ip.Script.ContainerImage, allowed) {
+		return errors.Errorf("installation image \"%s\" is not allowed on this node", ip.Script.ContainerImage)
+	}
+
+	_, err := ip.interpreter()
+
+	return err
+}
+
+// Determines if the value matches any of the patterns provided.
+func matchesAny(value string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, value); ok {
+			return true
+		}
+	}
+
+	return false
+}
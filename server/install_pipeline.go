@@ -0,0 +1,429 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/tempdir"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// The directory storing the checkpoints for step based installations that have not
+	// yet completed successfully.
+	installCheckpointsDirectory = "data/install_checkpoints"
+
+	// The directory that downloads with a known checksum are cached in so that they do
+	// not need to be downloaded again by future installations.
+	installCacheDirectory = "data/install_cache"
+)
+
+// Records a step of an installation that was completed successfully.
+type installCheckpoint struct {
+	Name        string    `json:"name"`
+	Hash        string    `json:"hash"`
+	CompletedAt time.Time `json:"completed_at"`
+}
+
+// Runs a step based installation for a server. Each step that completes successfully is
+// recorded as a checkpoint, and if the installation fails it is resumed from the failed
+// step the next time it is run, so long as none of the earlier steps have changed.
+type InstallPipeline struct {
+	Server *Server
+	Script *api.InstallationScript
+
+	checkpoints []installCheckpoint
+}
+
+// Returns the path to the checkpoint file for the server's installation.
+func (s *Server) installCheckpointPath() string {
+	return filepath.Join(installCheckpointsDirectory, s.Uuid+".json")
+}
+
+// Removes any checkpoints for the server so that the next installation runs every step
+// from the beginning.
+func (s *Server) ResetInstallCheckpoints() error {
+	if err := os.Remove(s.installCheckpointPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Runs each of the steps for the installation in order, skipping any steps that were
+// already completed by a previous attempt.
+func (p *InstallPipeline) Run() error {
+	if err := p.loadCheckpoints(); err != nil {
+		zap.S().Warnw("failed to load installation checkpoints, running all steps", zap.String("server", p.Server.Uuid), zap.Error(err))
+	}
+
+	resuming := true
+	for i, step := range p.Script.Steps {
+		hash := p.stepHash(step)
+
+		if resuming && i < len(p.checkpoints) && p.checkpoints[i].Hash == hash {
+			p.publish(fmt.Sprintf("Skipping installation step \"%s\" (%d/%d), it was completed by a previous attempt.", step.Name, i+1, len(p.Script.Steps)))
+			continue
+		}
+
+		// Once a step needs to be run every step after it must be run as well since
+		// they may depend on the output of this step.
+		resuming = false
+		p.checkpoints = p.checkpoints[:i]
+
+		p.publish(fmt.Sprintf("Running installation step \"%s\" (%d/%d)...", step.Name, i+1, len(p.Script.Steps)))
+		if err := p.runWithRetries(i, step); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("installation step \"%s\" failed", step.Name))
+		}
+
+		p.checkpoints = append(p.checkpoints, installCheckpoint{Name: step.Name, Hash: hash, CompletedAt: time.Now().UTC()})
+		if err := p.saveCheckpoints(); err != nil {
+			zap.S().Warnw("failed to save installation checkpoint", zap.String("server", p.Server.Uuid), zap.String("step", step.Name), zap.Error(err))
+		}
+	}
+
+	// Everything completed, so the next installation should begin from scratch.
+	return p.Server.ResetInstallCheckpoints()
+}
+
+// Runs a single step, retrying it the number of times defined by the step if it fails.
+func (p *InstallPipeline) runWithRetries(i int, step api.InstallationStep) error {
+	var err error
+
+	for attempt := 0; attempt <= step.Retries; attempt++ {
+		if attempt > 0 {
+			p.publish(fmt.Sprintf("Installation step \"%s\" failed, retrying (%d/%d)...", step.Name, attempt, step.Retries))
+			time.Sleep(time.Duration(attempt*5) * time.Second)
+		}
+
+		if err = p.runStep(i, step); err == nil {
+			return nil
+		}
+
+		zap.S().Warnw("installation step failed", zap.String("server", p.Server.Uuid), zap.String("step", step.Name), zap.Int("attempt", attempt+1), zap.Error(err))
+	}
+
+	return err
+}
+
+func (p *InstallPipeline) runStep(i int, step api.InstallationStep) error {
+	switch step.Type {
+	case "download":
+		return p.download(step)
+	case "extract":
+		return p.Server.Filesystem.ExtractArchive(step.Source, step.Destination)
+	case "script":
+		return p.script(i, step)
+	case "verify":
+		return p.verify(step)
+	}
+
+	return errors.Errorf("unknown installation step type \"%s\"", step.Type)
+}
+
+// Downloads a file into the server data directory. If the step provides a checksum the
+// download is verified and cached on the node so that future installations using the
+// same file do not need to download it again. Files with a checksum are requested from
+// any peers sharing their cache before they are downloaded from the original location.
+func (p *InstallPipeline) download(step api.InstallationStep) error {
+	dest := step.Destination
+	if dest == "" {
+		dest = path.Base(step.Url)
+	}
+
+	// The checksum is used as the name of the file in the cache, so it must be a hash and
+	// nothing else.
+	sum := strings.ToLower(step.Checksum)
+	if sum != "" && !checksumRegex.MatchString(sum) {
+		return errors.Errorf("checksum for %s must be a hex encoded SHA-256 hash", step.Url)
+	}
+
+	cached := filepath.Join(installCacheDirectory, sum)
+
+	if sum != "" {
+		if actual, err := fileChecksum(cached); err == nil && actual == sum {
+			p.publish(fmt.Sprintf("Using cached copy of %s.", step.Url))
+
+			// Used files are kept the longest when the cache is trimmed.
+			now := time.Now()
+			os.Chtimes(cached, now, now)
+
+			return p.writeFromFile(cached, dest)
+		}
+	}
+
+	if err := os.MkdirAll(installCacheDirectory, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	var tmp string
+	if sum != "" {
+		if t, peer, err := fetchArtifactFromPeers(sum); err == nil {
+			p.publish(fmt.Sprintf("Using copy of %s cached by %s.", step.Url, peer))
+			tmp = t
+		}
+	}
+
+	if tmp == "" {
+		t, err := downloadToTemp(step.Url, "", sum)
+		if err != nil {
+			return err
+		}
+
+		tmp = t
+	}
+	defer os.Remove(tmp)
+
+	if err := p.writeFromFile(tmp, dest); err != nil {
+		return err
+	}
+
+	limit := p.Server.Filesystem.Configuration.Installer.CacheSize * 1024 * 1024
+	if sum != "" && limit > 0 {
+		if err := tempdir.Move(tmp, cached); err != nil {
+			zap.S().Warnw("failed to cache installation download", zap.String("url", step.Url), zap.Error(err))
+		}
+
+		trimInstallCache(limit)
+	}
+
+	return nil
+}
+
+// Removes the downloads used least recently from the installation cache until it is no
+// larger than the limit in bytes.
+func trimInstallCache(limit int64) {
+	files, err := ioutil.ReadDir(installCacheDirectory)
+	if err != nil {
+		return
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, f := range files {
+		if size <= limit {
+			break
+		}
+
+		if err := os.Remove(filepath.Join(installCacheDirectory, f.Name())); err != nil {
+			zap.S().Warnw("failed to remove cached installation download", zap.String("file", f.Name()), zap.Error(err))
+			continue
+		}
+
+		size -= f.Size()
+	}
+}
+
+// The client used to download the files for installations and to transfer templates. The
+// timeouts ensure that a host that stops responding fails the transfer rather than leaving
+// it running forever.
+var downloadClient = &http.Client{
+	Timeout: time.Hour,
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           (&net.Dialer{Timeout: time.Second * 30}).DialContext,
+		TLSHandshakeTimeout:   time.Second * 10,
+		ResponseHeaderTimeout: time.Second * 30,
+	},
+}
+
+// Downloads a file into a temporary file, returning the path to the file. If a checksum
+// is provided the download is verified against it, and if a token is provided it is sent
+// as a bearer token.
+func downloadToTemp(url string, token string, sum string) (string, error) {
+	tmp, err := tempdir.File("download-")
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+
+	err = func() error {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		res, err := downloadClient.Do(req)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return errors.Errorf("received unexpected response status %s", res.Status)
+		}
+
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(tmp, h), res.Body); err != nil {
+			return errors.WithStack(err)
+		}
+
+		if sum != "" {
+			if actual := hex.EncodeToString(h.Sum(nil)); actual != sum {
+				return errors.Errorf("checksum of downloaded file %s does not match the expected value", actual)
+			}
+		}
+
+		return errors.WithStack(tmp.Close())
+	}()
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+
+	return tmp.Name(), nil
+}
+
+// Copies a file from the node into the server data directory.
+func (p *InstallPipeline) writeFromFile(src string, dest string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	return p.Server.Filesystem.Writefile(dest, f)
+}
+
+// Runs a script inside of an installation container.
+func (p *InstallPipeline) script(i int, step api.InstallationStep) error {
+	script := &api.InstallationScript{
+		ContainerImage: step.ContainerImage,
+		Entrypoint:     p.Script.Entrypoint,
+		Interpreter:    step.Interpreter,
+		Script:         step.Script,
+		PullPolicy:     p.Script.PullPolicy,
+	}
+
+	if script.ContainerImage == "" {
+		script.ContainerImage = p.Script.ContainerImage
+		script.ContainerImageDigest = p.Script.ContainerImageDigest
+	}
+
+	if script.Interpreter == "" {
+		script.Interpreter = p.Script.Interpreter
+	}
+
+	ip, err := NewInstallationProcess(p.Server, script)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Keep the output of every step in the installation log rather than only the last.
+	ip.appendLog = i > 0
+
+	return ip.Run()
+}
+
+// Confirms that the expected files exist in the server data directory and that they
+// match their checksums, if one was provided.
+func (p *InstallPipeline) verify(step api.InstallationStep) error {
+	for f, sum := range step.Files {
+		cleaned, err := p.Server.Filesystem.SafePath(f)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if _, err := os.Stat(cleaned); err != nil {
+			return errors.Wrap(err, fmt.Sprintf("expected file %s is missing", f))
+		}
+
+		if sum == "" {
+			continue
+		}
+
+		if actual, err := fileChecksum(cleaned); err != nil {
+			return err
+		} else if actual != strings.ToLower(sum) {
+			return errors.Errorf("checksum of %s does not match the expected value", f)
+		}
+	}
+
+	return nil
+}
+
+// Returns a hash of the step definition so that changes to a step cause it to be run
+// again rather than being skipped.
+func (p *InstallPipeline) stepHash(step api.InstallationStep) string {
+	b, _ := json.Marshal(struct {
+		Step        api.InstallationStep `json:"step"`
+		Image       string               `json:"image"`
+		Entrypoint  string               `json:"entrypoint"`
+		Interpreter string               `json:"interpreter"`
+	}{step, p.Script.ContainerImage, p.Script.Entrypoint, p.Script.Interpreter})
+
+	h := sha256.Sum256(b)
+
+	return hex.EncodeToString(h[:])
+}
+
+func (p *InstallPipeline) loadCheckpoints() error {
+	b, err := ioutil.ReadFile(p.Server.installCheckpointPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(json.Unmarshal(b, &p.checkpoints))
+}
+
+func (p *InstallPipeline) saveCheckpoints() error {
+	if err := os.MkdirAll(installCheckpointsDirectory, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.Marshal(p.checkpoints)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(p.Server.installCheckpointPath(), b, 0600))
+}
+
+func (p *InstallPipeline) publish(message string) {
+	p.Server.Events().Publish(DaemonMessageEvent, message)
+}
+
+// Returns the hex encoded SHA-256 checksum of a file.
+func fileChecksum(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
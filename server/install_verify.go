@@ -0,0 +1,59 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/ed25519"
+	"strings"
+)
+
+// Verifies that an installation script received from the Panel is trusted before it is
+// executed. If verification is not required by the node this always succeeds. Otherwise
+// the script must either match one of the allowed hashes, or be signed by one of the
+// trusted keys.
+func verifyInstallationScript(s *Server, script *api.InstallationScript) error {
+	cfg := s.Filesystem.Configuration.Installer
+	if !cfg.RequireVerification {
+		return nil
+	}
+
+	payload := script.SigningPayload()
+
+	sum := sha256.Sum256(payload)
+	hash := hex.EncodeToString(sum[:])
+
+	for _, h := range cfg.AllowedHashes {
+		if strings.EqualFold(h, hash) {
+			zap.S().Debugw("installation script matched allowed hash", zap.String("server", s.Uuid), zap.String("hash", hash))
+			return nil
+		}
+	}
+
+	if script.Signature == "" {
+		return errors.Errorf("installation script (hash %s) is not signed and is not in the list of allowed hashes", hash)
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(script.Signature)
+	if err != nil {
+		return errors.Wrap(err, "installation script signature is not valid base64")
+	}
+
+	for _, k := range cfg.TrustedKeys {
+		key, err := base64.StdEncoding.DecodeString(k)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			zap.S().Warnw("ignoring invalid trusted installer key in configuration", zap.String("key", k))
+			continue
+		}
+
+		if ed25519.Verify(ed25519.PublicKey(key), payload, sig) {
+			zap.S().Debugw("installation script signature verified", zap.String("server", s.Uuid), zap.String("key", k))
+			return nil
+		}
+	}
+
+	return errors.Errorf("installation script (hash %s) is not signed by a trusted key", hash)
+}
@@ -0,0 +1,304 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/hooks"
+	"github.com/pterodactyl/wings/parser"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The directory containing the integrity baseline and last report for each server.
+const integrityDirectory = "data/integrity"
+
+var integrityLock sync.Mutex
+
+// A difference between a monitored file and the baseline recorded for it.
+type IntegrityViolation struct {
+	File string `json:"file"`
+	// Either "modified", "missing" or "added".
+	Reason   string `json:"reason"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+// The result of checking the monitored files of a server against the baseline.
+type IntegrityReport struct {
+	CheckedAt  time.Time            `json:"checked_at"`
+	Files      int                  `json:"files"`
+	Violations []IntegrityViolation `json:"violations"`
+}
+
+// The known good hashes of the monitored files for a server, along with the result of the
+// last check made against them.
+type IntegrityBaseline struct {
+	RecordedAt time.Time         `json:"recorded_at"`
+	Files      map[string]string `json:"files"`
+	LastReport *IntegrityReport  `json:"last_report,omitempty"`
+}
+
+// Returns the path to the integrity baseline for the server.
+func (s *Server) integrityPath() string {
+	return path.Join(integrityDirectory, s.Uuid+".json")
+}
+
+// Determines if the egg for the server declares any files to monitor.
+func (s *Server) hasIntegrityMonitoring() bool {
+	return s.processConfiguration != nil && len(s.processConfiguration.Integrity.Files) > 0
+}
+
+// Returns the integrity baseline for the server, or nil if one has not been recorded.
+func (s *Server) GetIntegrityBaseline() (*IntegrityBaseline, error) {
+	b, err := ioutil.ReadFile(s.integrityPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	baseline := &IntegrityBaseline{}
+	if err := json.Unmarshal(b, baseline); err != nil {
+		return nil, errors.Wrap(err, "failed to parse integrity baseline")
+	}
+
+	return baseline, nil
+}
+
+func (s *Server) saveIntegrityBaseline(baseline *IntegrityBaseline) error {
+	if err := os.MkdirAll(integrityDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.MarshalIndent(baseline, "", "    ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(s.integrityPath(), b, 0600))
+}
+
+// Hashes all of the files monitored for the server, keyed by their path relative to the
+// server data directory.
+func (s *Server) hashMonitoredFiles() (map[string]string, error) {
+	root := s.Filesystem.Path()
+	out := make(map[string]string)
+
+	for _, pattern := range s.processConfiguration.Integrity.Files {
+		cleaned, err := s.Filesystem.SafePath(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		matches, err := filepath.Glob(cleaned)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid integrity file pattern \"%s\"", pattern)
+		}
+
+		for _, m := range matches {
+			// Never follow a match out of the server data directory.
+			p, err := s.Filesystem.SafePath(strings.TrimPrefix(m, root))
+			if err != nil {
+				continue
+			}
+
+			if st, err := os.Stat(p); err != nil || !st.Mode().IsRegular() {
+				continue
+			}
+
+			h, err := parser.HashFile(p)
+			if err != nil {
+				return nil, err
+			}
+
+			out[filepath.ToSlash(strings.TrimPrefix(p, root))] = h
+		}
+	}
+
+	return out, nil
+}
+
+// Records the current state of the monitored files as the known good baseline for the
+// server. This should be called after the files have been intentionally changed, such as
+// when the server has been updated.
+func (s *Server) ResetIntegrityBaseline() (*IntegrityBaseline, error) {
+	if !s.hasIntegrityMonitoring() {
+		return nil, errors.New("the egg for this server does not declare any files to monitor")
+	}
+
+	integrityLock.Lock()
+	defer integrityLock.Unlock()
+
+	files, err := s.hashMonitoredFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	baseline := &IntegrityBaseline{RecordedAt: time.Now().UTC(), Files: files}
+
+	return baseline, s.saveIntegrityBaseline(baseline)
+}
+
+// Removes the integrity baseline for the server, so that a new baseline is recorded the
+// next time the files are checked.
+func (s *Server) RemoveIntegrityBaseline() error {
+	integrityLock.Lock()
+	defer integrityLock.Unlock()
+
+	if err := os.Remove(s.integrityPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Checks the monitored files for the server against the baseline. If there is no
+// baseline yet the current files are recorded as the baseline. Listeners are alerted
+// when the set of violations changes, rather than on every check.
+func (s *Server) VerifyIntegrity() (*IntegrityReport, error) {
+	if !s.hasIntegrityMonitoring() {
+		return nil, nil
+	}
+
+	integrityLock.Lock()
+	defer integrityLock.Unlock()
+
+	files, err := s.hashMonitoredFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	baseline, err := s.GetIntegrityBaseline()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &IntegrityReport{CheckedAt: time.Now().UTC(), Files: len(files), Violations: []IntegrityViolation{}}
+
+	if baseline == nil {
+		zap.S().Infow("recording integrity baseline for server", zap.String("server", s.Uuid), zap.Int("files", len(files)))
+
+		return report, s.saveIntegrityBaseline(&IntegrityBaseline{RecordedAt: report.CheckedAt, Files: files, LastReport: report})
+	}
+
+	for f, expected := range baseline.Files {
+		if actual, ok := files[f]; !ok {
+			report.Violations = append(report.Violations, IntegrityViolation{File: f, Reason: "missing", Expected: expected})
+		} else if actual != expected {
+			report.Violations = append(report.Violations, IntegrityViolation{File: f, Reason: "modified", Expected: expected, Actual: actual})
+		}
+	}
+
+	for f, actual := range files {
+		if _, ok := baseline.Files[f]; !ok {
+			report.Violations = append(report.Violations, IntegrityViolation{File: f, Reason: "added", Actual: actual})
+		}
+	}
+
+	sort.Slice(report.Violations, func(i, j int) bool {
+		return report.Violations[i].File < report.Violations[j].File
+	})
+
+	changed := baseline.LastReport == nil || !violationsEqual(baseline.LastReport.Violations, report.Violations)
+
+	baseline.LastReport = report
+	if err := s.saveIntegrityBaseline(baseline); err != nil {
+		return report, err
+	}
+
+	if changed && len(report.Violations) > 0 {
+		s.alertIntegrityViolations(report)
+	}
+
+	return report, nil
+}
+
+// Notifies the console, websocket listeners and lifecycle hooks about the violations.
+func (s *Server) alertIntegrityViolations(report *IntegrityReport) {
+	names := make([]string, len(report.Violations))
+	for i, v := range report.Violations {
+		names[i] = v.File + " (" + v.Reason + ")"
+	}
+
+	zap.S().Warnw("monitored server files do not match the integrity baseline", zap.String("server", s.Uuid), zap.Strings("files", names))
+
+	s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Integrity check failed, unexpected changes to: %s", strings.Join(names, ", ")))
+
+	if b, err := json.Marshal(report); err == nil {
+		s.Events().Publish(IntegrityEvent, string(b))
+	}
+
+	s.fireHook(hooks.Integrity, map[string]string{"files": strings.Join(names, ",")})
+}
+
+func violationsEqual(a []IntegrityViolation, b []IntegrityViolation) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Checks the monitored files before the server starts, returning an error if the server
+// should not be started because of a violation.
+func (s *Server) checkIntegrityBeforeStart() error {
+	report, err := s.VerifyIntegrity()
+	if err != nil {
+		zap.S().Warnw("failed to check integrity of monitored server files", zap.String("server", s.Uuid), zap.Error(err))
+		return nil
+	}
+
+	if report != nil && len(report.Violations) > 0 && s.processConfiguration.Integrity.BlockStart {
+		s.PublishConsoleOutputFromDaemon("Server start aborted: monitored files do not match the integrity baseline.")
+
+		return errors.New("monitored server files do not match the integrity baseline")
+	}
+
+	return nil
+}
+
+// Starts the background routine that periodically checks the monitored files of servers
+// whose egg defines a check interval.
+func StartIntegrityMonitor() {
+	go supervisor.Supervise("integrity monitor", func() error {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, s := range GetServers().All() {
+				if !s.hasIntegrityMonitoring() || s.processConfiguration.Integrity.Interval <= 0 {
+					continue
+				}
+
+				baseline, err := s.GetIntegrityBaseline()
+				if err != nil || (baseline != nil && baseline.LastReport != nil &&
+					time.Since(baseline.LastReport.CheckedAt) < time.Duration(s.processConfiguration.Integrity.Interval)*time.Second) {
+					continue
+				}
+
+				if _, err := s.VerifyIntegrity(); err != nil {
+					zap.S().Warnw("failed to check integrity of monitored server files", zap.String("server", s.Uuid), zap.Error(err))
+				}
+			}
+		}
+
+		return nil
+	})
+}
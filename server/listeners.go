@@ -2,6 +2,7 @@ package server
 
 import (
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/supervisor"
 	"go.uber.org/zap"
 	"strings"
 )
@@ -11,19 +12,31 @@ func (s *Server) AddEventListeners() {
 	consoleChannel := make(chan Event)
 	s.Events().Subscribe(ConsoleOutputEvent, consoleChannel)
 
-	go func() {
+	// Supervise the listener so that a panic while handling a line of output does not
+	// crash the daemon, and so that the server continues to receive console events.
+	go supervisor.Supervise("console listener", func() error {
 		for {
 			select {
 			case data := <-consoleChannel:
 				s.onConsoleOutput(data.Data)
 			}
 		}
-	}()
+	})
 }
 
-// Custom listener for console output events that will check if the given line
-// of output matches one that should mark the server as started or not.
+// Custom listener for console output events that writes every line sent to the console,
+// including messages from the daemon, to the console log for the server.
 func (s *Server) onConsoleOutput(data string) {
+	s.writeConsoleLog(data)
+}
+
+// Checks if the given line of output from the server process matches one that should
+// mark the server as started or not. This is called with the output before any console
+// transforms are applied to it.
+func (s *Server) inspectConsoleOutput(data string) {
+	s.recordHeartbeat(data)
+	s.checkReadinessOutput(data)
+
 	// If the specific line of output is one that would mark the server as started,
 	// set the server to that state. Only do this if the server is not currently stopped
 	// or stopping.
@@ -38,7 +51,7 @@ func (s *Server) onConsoleOutput(data string) {
 	// If the command sent to the server is one that should stop the server we will need to
 	// set the server to be in a stopping state, otherwise crash detection will kick in and
 	// cause the server to unexpectedly restart on the user.
-	if s.State == ProcessStartingState || s.State == ProcessRunningState {
+	if s.State == ProcessStartingState || IsRunningState(s.State) {
 		if s.processConfiguration.Stop.Type == api.ProcessStopCommand && data == s.processConfiguration.Stop.Value {
 			s.SetState(ProcessStoppingState)
 		}
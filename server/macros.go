@@ -0,0 +1,147 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands starting with this prefix invoke a macro rather than being sent to the server
+// process, for example "!restart-warn 5".
+const MacroPrefix = "!"
+
+// Matches placeholders within the commands of a macro, such as "{{env.SERVER_PORT}}".
+var macroPlaceholderRegex = regexp.MustCompile(`{{\s*([\w.-]+)\s*}}`)
+
+// Tracks the macros that are currently running so that a macro is not started again for
+// a server while it is still running.
+var runningMacros sync.Map
+
+// A named sequence of console commands for a server, invoked using the macro prefix from
+// the console or the API.
+type Macro struct {
+	Description string      `json:"description,omitempty" yaml:"description,omitempty"`
+	Steps       []MacroStep `json:"steps" yaml:"steps"`
+}
+
+// A single command within a macro. Commands may contain placeholders that are replaced
+// when the macro runs:
+//
+//	{{env.NAME}}      an environment variable for the server
+//	{{server.uuid}}   the server uuid, as well as "ip", "port", "memory" and "disk"
+//	{{args}}          all of the arguments passed to the macro
+//	{{args.N}}        a single argument passed to the macro, starting at 1
+type MacroStep struct {
+	// The number of seconds to wait before sending the command.
+	Delay   int    `json:"delay" yaml:"delay"`
+	Command string `json:"command" yaml:"command"`
+}
+
+// Sends a command to the server process. If the command invokes a macro defined for the
+// server the macro is started instead, and this returns once it has begun running.
+func (s *Server) SendCommand(command string) error {
+	if strings.HasPrefix(command, MacroPrefix) {
+		fields := strings.Fields(strings.TrimPrefix(command, MacroPrefix))
+		if len(fields) > 0 {
+			if _, ok := s.Macros[fields[0]]; ok {
+				return s.RunMacro(fields[0], fields[1:])
+			}
+		}
+	}
+
+	return s.Environment.SendCommand(command)
+}
+
+// Starts running the named macro for the server in the background.
+func (s *Server) RunMacro(name string, args []string) error {
+	m, ok := s.Macros[name]
+	if !ok {
+		return errors.Errorf("macro \"%s\" is not defined", name)
+	}
+
+	if !IsRunningState(s.State) {
+		return errors.New("cannot run a macro for a server that is not running")
+	}
+
+	key := s.Uuid + ":" + name
+	if _, running := runningMacros.LoadOrStore(key, true); running {
+		return errors.Errorf("macro \"%s\" is already running", name)
+	}
+
+	zap.S().Debugw("running console macro for server", zap.String("server", s.Uuid), zap.String("macro", name), zap.Strings("args", args))
+
+	go func() {
+		defer runningMacros.Delete(key)
+		defer supervisor.Recover("macros")
+
+		for _, step := range m.Steps {
+			if step.Delay > 0 {
+				time.Sleep(time.Duration(step.Delay) * time.Second)
+			}
+
+			// Stop running the macro if the server stopped while waiting, there is no
+			// process to send the remaining commands to.
+			if !IsRunningState(s.State) {
+				s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Macro \"%s\" was cancelled because the server stopped.", name))
+				return
+			}
+
+			if err := s.Environment.SendCommand(s.expandMacroCommand(step.Command, args)); err != nil {
+				zap.S().Warnw("failed to send macro command to server", zap.String("server", s.Uuid), zap.String("macro", name), zap.Error(err))
+				return
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Replaces the placeholders within a macro command.
+func (s *Server) expandMacroCommand(command string, args []string) string {
+	return macroPlaceholderRegex.ReplaceAllStringFunc(command, func(match string) string {
+		key := macroPlaceholderRegex.FindStringSubmatch(match)[1]
+		parts := strings.SplitN(key, ".", 2)
+
+		if len(parts) == 1 {
+			if key == "args" {
+				return strings.Join(args, " ")
+			}
+
+			return match
+		}
+
+		switch parts[0] {
+		case "env":
+			if v, ok := s.EnvVars[parts[1]]; ok {
+				return v
+			}
+		case "args":
+			if i, err := strconv.Atoi(parts[1]); err == nil && i > 0 && i <= len(args) {
+				return args[i-1]
+			}
+
+			return ""
+		case "server":
+			switch parts[1] {
+			case "uuid":
+				return s.Uuid
+			case "ip":
+				return s.Allocations.DefaultMapping.Ip
+			case "port":
+				return strconv.Itoa(s.Allocations.DefaultMapping.Port)
+			case "memory":
+				return strconv.FormatInt(s.Build.MemoryLimit, 10)
+			case "disk":
+				return strconv.FormatInt(s.Build.DiskSpace, 10)
+			}
+		}
+
+		return match
+	})
+}
@@ -0,0 +1,391 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A difference between the Docker network used by servers and its configuration.
+type NetworkChange struct {
+	Field   string `json:"field"`
+	Current string `json:"current"`
+	Desired string `json:"desired"`
+}
+
+// The plan for replacing the Docker network used by servers after its configuration was
+// changed. If the new network could be created alongside the previous one servers move
+// to it as they are restarted, and the previous network is removed once none of the
+// pending containers are running. Otherwise the network is recreated at that point.
+type NetworkMigration struct {
+	Network string `json:"network"`
+	// The network created alongside the previous one, if it could be.
+	Target  string          `json:"target,omitempty"`
+	Changes []NetworkChange `json:"changes"`
+	// The containers that are still running on the current network, which are usually
+	// the containers of servers.
+	Pending     []string   `json:"pending"`
+	DetectedAt  time.Time  `json:"detected_at"`
+	CompletedAt *time.Time `json:"completed_at"`
+	Error       string     `json:"error,omitempty"`
+}
+
+var networkMigration = struct {
+	sync.Mutex
+	migration *NetworkMigration
+	// The number of containers between being created and started.
+	holds int
+}{}
+
+// Returns the options used to create the Docker network for servers.
+func networkCreateOptions(c *config.DockerNetworkConfiguration) types.NetworkCreate {
+	ipam := []network.IPAMConfig{
+		{
+			Subnet:  c.Interfaces.V4.Subnet,
+			Gateway: c.Interfaces.V4.Gateway,
+		},
+	}
+
+	if c.EnableIPv6 {
+		ipam = append(ipam, network.IPAMConfig{
+			Subnet:  c.Interfaces.V6.Subnet,
+			Gateway: c.Interfaces.V6.Gateway,
+		})
+	}
+
+	options := map[string]string{
+		"encryption": "false",
+		"com.docker.network.bridge.default_bridge":       "false",
+		"com.docker.network.bridge.enable_icc":           strconv.FormatBool(c.EnableICC),
+		"com.docker.network.bridge.enable_ip_masquerade": "true",
+		"com.docker.network.bridge.host_binding_ipv4":    "0.0.0.0",
+		"com.docker.network.bridge.name":                 "pterodactyl0",
+		"com.docker.network.driver.mtu":                  strconv.Itoa(c.Mtu),
+	}
+
+	for k, v := range c.Options {
+		options[k] = v
+	}
+
+	return types.NetworkCreate{
+		Driver:     c.Driver,
+		EnableIPv6: c.EnableIPv6,
+		Internal:   c.IsInternal,
+		IPAM: &network.IPAM{
+			Config: ipam,
+		},
+		Options: options,
+	}
+}
+
+// Creates the Docker network for servers, and updates the interface used by servers to
+// reach the host to match it.
+func CreateDockerNetwork(cli *client.Client, c *config.DockerConfiguration) error {
+	if _, err := cli.NetworkCreate(context.Background(), c.Network.Name, networkCreateOptions(&c.Network)); err != nil {
+		return err
+	}
+
+	useDockerNetwork(c)
+
+	return nil
+}
+
+// Updates the interface used by servers to reach the host to match the network they are
+// created on.
+func useDockerNetwork(c *config.DockerConfiguration) {
+	switch c.Network.Driver {
+	case "host":
+		c.Network.Interface = "127.0.0.1"
+		c.Network.ISPN = false
+		break
+	case "overlay":
+	case "weavemesh":
+		c.Network.Interface = ""
+		c.Network.ISPN = true
+		break
+	default:
+		c.Network.Interface = c.Network.Interfaces.V4.Gateway
+		c.Network.Interface6 = c.Network.Interfaces.V6.Gateway
+		c.Network.ISPN = false
+		break
+	}
+}
+
+// Returns the differences between the existing network and the options it would be
+// created with now. Options set by Docker itself that are not part of the configuration
+// are ignored.
+func diffNetwork(resource types.NetworkResource, want types.NetworkCreate) []NetworkChange {
+	var changes []NetworkChange
+
+	add := func(field string, current string, desired string) {
+		if current != desired {
+			changes = append(changes, NetworkChange{Field: field, Current: current, Desired: desired})
+		}
+	}
+
+	add("driver", resource.Driver, want.Driver)
+	add("internal", strconv.FormatBool(resource.Internal), strconv.FormatBool(want.Internal))
+	add("enable_ipv6", strconv.FormatBool(resource.EnableIPv6), strconv.FormatBool(want.EnableIPv6))
+	add("subnets", formatIPAM(resource.IPAM.Config), formatIPAM(want.IPAM.Config))
+
+	keys := make([]string, 0, len(want.Options))
+	for k := range want.Options {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		add(k, resource.Options[k], want.Options[k])
+	}
+
+	return changes
+}
+
+func formatIPAM(cfg []network.IPAMConfig) string {
+	var out []string
+	for _, c := range cfg {
+		out = append(out, fmt.Sprintf("%s via %s", c.Subnet, c.Gateway))
+	}
+
+	sort.Strings(out)
+
+	return strings.Join(out, ", ")
+}
+
+// The driver option naming the bridge interface created for a network.
+const bridgeNameOption = "com.docker.network.bridge.name"
+
+// Compares the existing Docker network for servers against the configuration, planning
+// for the network to be replaced if they no longer match. Where possible a new network is
+// created alongside the existing one right away, and each server moves over to it the
+// next time it is started. Otherwise the network is recreated in place once nothing is
+// using it. True is returned if the servers will use a new network from now on.
+func ReconcileDockerNetwork(cli *client.Client, c *config.DockerConfiguration, resource types.NetworkResource) (bool, error) {
+	// Networks using other drivers are managed outside of the daemon.
+	if resource.Driver != "bridge" && c.Network.Driver != "bridge" {
+		return false, nil
+	}
+
+	changes := diffNetwork(resource, networkCreateOptions(&c.Network))
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	for _, change := range changes {
+		zap.S().Warnw("docker network does not match configuration", zap.String("network", c.Network.Name), zap.String("field", change.Field), zap.String("current", change.Current), zap.String("desired", change.Desired))
+	}
+
+	if !c.Network.Reconcile {
+		zap.S().Warnw("docker network reconciliation is disabled, the network must be removed manually for the changes to apply", zap.String("network", c.Network.Name))
+		return false, nil
+	}
+
+	m := &NetworkMigration{
+		Network:    c.Network.Name,
+		Changes:    changes,
+		Pending:    []string{},
+		DetectedAt: time.Now().UTC(),
+	}
+
+	// The new network needs its own name and bridge interface to exist alongside the
+	// current one. Docker refuses to create it if its subnets overlap with the current
+	// network, such as when only the MTU or options changed.
+	next := c.Network
+	next.Name, next.Options = alternateNetwork(c.Network.Name, resource.Options[bridgeNameOption], c.Network.Options)
+
+	if _, err := cli.NetworkCreate(context.Background(), next.Name, networkCreateOptions(&next)); err != nil {
+		zap.S().Warnw("could not create docker network alongside the current one, it will be recreated once no servers are running on it", zap.String("network", c.Network.Name), zap.Error(err))
+	} else {
+		zap.S().Infow("created new docker network, servers will move to it the next time they are started", zap.String("network", next.Name), zap.String("previous", c.Network.Name))
+
+		m.Target = next.Name
+		c.Network.Name = next.Name
+		c.Network.Options = next.Options
+		useDockerNetwork(c)
+
+		if err := config.Get().WriteToDisk(); err != nil {
+			zap.S().Warnw("failed to save configuration to disk after creating docker network", zap.Error(err))
+		}
+	}
+
+	networkMigration.Lock()
+	networkMigration.migration = m
+	networkMigration.Unlock()
+
+	recreated, err := migrateNetwork(cli)
+
+	return recreated || m.Target != "", err
+}
+
+// Returns the name and driver options for a network created alongside the existing one,
+// which alternate between two names and bridge interfaces so that repeated migrations
+// do not keep adding to them.
+func alternateNetwork(name string, bridge string, options map[string]string) (string, map[string]string) {
+	if strings.HasSuffix(name, "_alt") {
+		name = strings.TrimSuffix(name, "_alt")
+	} else {
+		name += "_alt"
+	}
+
+	switch {
+	case bridge == "":
+		bridge = "pterodactyl1"
+	case strings.HasSuffix(bridge, "1"):
+		bridge = strings.TrimSuffix(bridge, "1") + "0"
+	case strings.HasSuffix(bridge, "0"):
+		bridge = strings.TrimSuffix(bridge, "0") + "1"
+	case len(bridge) < 15:
+		bridge += "1"
+	default:
+		bridge = bridge[:14] + "1"
+	}
+
+	out := map[string]string{bridgeNameOption: bridge}
+	for k, v := range options {
+		if k != bridgeNameOption {
+			out[k] = v
+		}
+	}
+
+	return name, out
+}
+
+// Returns the plan for replacing the Docker network for servers, or nil if the network
+// matches the configuration.
+func GetNetworkMigration() *NetworkMigration {
+	networkMigration.Lock()
+	defer networkMigration.Unlock()
+
+	if networkMigration.migration == nil {
+		return nil
+	}
+
+	m := *networkMigration.migration
+
+	return &m
+}
+
+// Prevents the previous Docker network from being removed until the returned function is
+// called. This is held while a container is being created and started, since a container
+// is not listed as connected to a network until it is running, and removing the network
+// in between would cause the start to fail.
+func holdNetwork() func() {
+	networkMigration.Lock()
+	networkMigration.holds++
+	networkMigration.Unlock()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			networkMigration.Lock()
+			networkMigration.holds--
+			networkMigration.Unlock()
+
+			go retryNetworkMigration()
+		})
+	}
+}
+
+// Removes the previous Docker network for servers once nothing is running on it or being
+// started, recreating it first if the new network could not be created alongside it.
+// Otherwise the containers still running on the network are recorded so that operators
+// know what the migration is waiting for.
+func migrateNetwork(cli *client.Client) (bool, error) {
+	networkMigration.Lock()
+	defer networkMigration.Unlock()
+
+	m := networkMigration.migration
+	if m == nil || m.CompletedAt != nil {
+		return false, nil
+	}
+
+	resource, err := cli.NetworkInspect(context.Background(), m.Network, types.NetworkInspectOptions{})
+	if err != nil && !client.IsErrNotFound(err) {
+		return false, errors.WithStack(err)
+	}
+
+	if err == nil {
+		m.Pending = []string{}
+		for _, e := range resource.Containers {
+			m.Pending = append(m.Pending, strings.TrimPrefix(e.Name, "/"))
+		}
+		sort.Strings(m.Pending)
+
+		if len(m.Pending) > 0 || networkMigration.holds > 0 {
+			zap.S().Infow("waiting for containers to stop before removing docker network", zap.String("network", m.Network), zap.Strings("containers", m.Pending))
+			return false, nil
+		}
+
+		if err := cli.NetworkRemove(context.Background(), m.Network); err != nil && !client.IsErrNotFound(err) {
+			m.Error = err.Error()
+			return false, errors.WithStack(err)
+		}
+	}
+
+	now := time.Now().UTC()
+
+	// Servers are already using the network created alongside this one.
+	if m.Target != "" {
+		zap.S().Infow("removed previous docker network", zap.String("network", m.Network))
+
+		m.CompletedAt = &now
+		m.Error = ""
+
+		return false, nil
+	}
+
+	zap.S().Infow("recreating docker network to apply configuration changes", zap.String("network", m.Network))
+
+	c := config.Get()
+	if err := CreateDockerNetwork(cli, &c.Docker); err != nil {
+		m.Error = err.Error()
+		return false, errors.WithStack(err)
+	}
+
+	m.CompletedAt = &now
+	m.Error = ""
+
+	if err := c.WriteToDisk(); err != nil {
+		zap.S().Warnw("failed to save configuration to disk after recreating docker network", zap.Error(err))
+	}
+
+	return true, nil
+}
+
+// Removes or recreates the previous Docker network for servers if it is waiting for
+// nothing to be running on it anymore.
+func MigrateDockerNetwork() error {
+	if m := GetNetworkMigration(); m == nil || m.CompletedAt != nil {
+		return nil
+	}
+
+	cli, err := NewRuntimeClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	_, err = migrateNetwork(cli)
+
+	return err
+}
+
+// Attempts to finish replacing the Docker network for servers, logging any failure. This
+// is called whenever a server stops, is about to start, or has finished starting, since
+// those are the moments the network may no longer be in use.
+func retryNetworkMigration() {
+	if err := MigrateDockerNetwork(); err != nil {
+		zap.S().Errorw("failed to recreate docker network", zap.Error(err))
+	}
+}
@@ -0,0 +1,47 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/config"
+	"strings"
+)
+
+// Determines if an environment rule defined for the node applies to the server.
+func environmentRuleMatches(r config.EnvironmentRule, s *Server) bool {
+	if len(r.Servers) > 0 && !containsString(r.Servers, s.Uuid) {
+		return false
+	}
+
+	if len(r.Owners) > 0 && !containsString(r.Owners, s.Owner) {
+		return false
+	}
+
+	return r.Image == "" || strings.HasPrefix(s.Container.Image, r.Image)
+}
+
+// Layers the environment variables defined for the node around the variables of the
+// server. The variables of the server replace those of the node, except for the variables
+// of rules that override them. The names of the returned variables are upper case.
+func (s *Server) withNodeEnvironment(env map[string]string) map[string]string {
+	rules := config.Get().System.Environment
+
+	out := make(map[string]string, len(env))
+	layer := func(override bool) {
+		for _, r := range rules {
+			if r.Override != override || !environmentRuleMatches(r, s) {
+				continue
+			}
+
+			for k, v := range r.Variables {
+				out[strings.ToUpper(k)] = v
+			}
+		}
+	}
+
+	layer(false)
+	for k, v := range env {
+		out[strings.ToUpper(k)] = v
+	}
+	layer(true)
+
+	return out
+}
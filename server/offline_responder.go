@@ -0,0 +1,322 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// The amount of time a client connected to the offline responder has to finish sending
+// its request.
+const offlineResponderTimeout = time.Second * 10
+
+// The largest packet the offline responder will read from a client. Status and login
+// requests are tiny, so anything larger is not a real client.
+const offlineResponderMaxPacket = 1 << 15
+
+// The listener answering status requests for a single offline server.
+type offlineResponder struct {
+	listener net.Listener
+	waking   int32
+}
+
+var offlineResponders = struct {
+	sync.Mutex
+	responders map[string]*offlineResponder
+}{responders: make(map[string]*offlineResponder)}
+
+// Starts answering status requests on the default allocation of the server, if both the
+// node and the egg for the server enable it. This is called whenever the server goes
+// offline, and does nothing if the responder is already running.
+func (s *Server) startOfflineResponder() {
+	if !config.Get().System.OfflineResponder.Enabled || s.Suspended || s.processConfiguration == nil {
+		return
+	}
+
+	r := s.processConfiguration.OfflineResponder
+	if r.Protocol == "" {
+		return
+	}
+
+	if r.Protocol != api.OfflineResponderMinecraft && r.Protocol != api.OfflineResponderTcp {
+		zap.S().Warnw("offline responder protocol for server is not supported", zap.String("server", s.Uuid), zap.String("protocol", r.Protocol))
+		return
+	}
+
+	offlineResponders.Lock()
+	defer offlineResponders.Unlock()
+
+	if _, running := offlineResponders.responders[s.Uuid]; running {
+		return
+	}
+
+	ip := s.Allocations.DefaultMapping.Ip
+	if ip == "0.0.0.0" {
+		ip = ""
+	}
+
+	l, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(s.Allocations.DefaultMapping.Port)))
+	if err != nil {
+		zap.S().Warnw("failed to start offline responder for server", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	responder := &offlineResponder{listener: l}
+
+	offlineResponders.responders[s.Uuid] = responder
+
+	zap.S().Debugw("started offline responder for server", zap.String("server", s.Uuid), zap.String("address", l.Addr().String()))
+
+	go func() {
+		defer supervisor.Recover("offline responder")
+
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func(conn net.Conn) {
+				defer supervisor.Recover("offline responder")
+				defer conn.Close()
+
+				if r.Protocol == api.OfflineResponderTcp {
+					if r.WakeOnJoin {
+						s.wakeFromResponder(responder)
+					}
+
+					return
+				}
+
+				conn.SetDeadline(time.Now().Add(offlineResponderTimeout))
+				if err := s.answerMinecraft(responder, conn, r); err != nil {
+					zap.S().Debugw("offline responder failed to answer client", zap.String("server", s.Uuid), zap.Error(err))
+				}
+			}(conn)
+		}
+	}()
+}
+
+// Stops the offline responder for the server, releasing the allocation so that the server
+// process is able to bind it.
+func stopOfflineResponder(uuid string) {
+	offlineResponders.Lock()
+	responder, ok := offlineResponders.responders[uuid]
+	delete(offlineResponders.responders, uuid)
+	offlineResponders.Unlock()
+
+	if ok {
+		responder.listener.Close()
+	}
+}
+
+// Starts the server in response to a player attempting to join it. Only the first attempt
+// starts the server, any others while it is booting are ignored.
+func (s *Server) wakeFromResponder(responder *offlineResponder) {
+	if s.State != ProcessOfflineState || !atomic.CompareAndSwapInt32(&responder.waking, 0, 1) {
+		return
+	}
+
+	zap.S().Infow("starting server after a player attempted to join it", zap.String("server", s.Uuid))
+
+	go func() {
+		defer atomic.StoreInt32(&responder.waking, 0)
+
+		if err := s.Environment.Start(); err != nil {
+			zap.S().Errorw("failed to start server after a player attempted to join it", zap.String("server", s.Uuid), zap.Error(err))
+		}
+	}()
+}
+
+// Answers a single connection using the Minecraft server list ping protocol. Status
+// requests receive the configured message, and login attempts are disconnected with the
+// kick message after optionally starting the server.
+func (s *Server) answerMinecraft(responder *offlineResponder, conn net.Conn, r api.OfflineResponder) error {
+	br := bufio.NewReader(conn)
+
+	// The handshake contains the protocol version of the client, the address it connected
+	// to, and the state it wishes to move to: 1 for a status request or 2 to log in.
+	id, p, err := readMinecraftPacket(br, offlineResponderMaxPacket)
+	if err != nil {
+		return err
+	} else if id != 0x00 {
+		return errors.Errorf("unexpected handshake packet id %d", id)
+	}
+
+	pr := bytes.NewReader(p)
+	protocol, err := readVarInt(pr)
+	if err != nil {
+		return err
+	}
+
+	hostLen, err := readVarInt(pr)
+	if err != nil {
+		return err
+	}
+
+	if _, err := pr.Seek(int64(hostLen)+2, io.SeekCurrent); err != nil {
+		return errors.WithStack(err)
+	}
+
+	next, err := readVarInt(pr)
+	if err != nil {
+		return err
+	}
+
+	if next == 2 {
+		if r.WakeOnJoin {
+			s.wakeFromResponder(responder)
+		}
+
+		msg := r.KickMessage
+		if msg == "" {
+			msg = "This server is offline."
+			if r.WakeOnJoin {
+				msg = "This server is starting, please reconnect in a moment."
+			}
+		}
+
+		b, _ := json.Marshal(map[string]string{"text": msg})
+
+		return writeMinecraftPacket(conn, 0x00, minecraftString(string(b)))
+	}
+
+	for {
+		id, p, err := readMinecraftPacket(br, offlineResponderMaxPacket)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+
+			return err
+		}
+
+		switch id {
+		case 0x00:
+			b, err := json.Marshal(s.minecraftStatus(r, protocol))
+			if err != nil {
+				return errors.WithStack(err)
+			}
+
+			if err := writeMinecraftPacket(conn, 0x00, minecraftString(string(b))); err != nil {
+				return err
+			}
+		case 0x01:
+			// Ping requests are answered with the same payload so that the client is able
+			// to calculate the latency to the server.
+			return writeMinecraftPacket(conn, 0x01, p)
+		default:
+			return errors.Errorf("unexpected status packet id %d", id)
+		}
+	}
+}
+
+// Returns the status response sent to Minecraft clients. The protocol version of the
+// client is echoed back so that the server is not shown as running an incompatible
+// version.
+func (s *Server) minecraftStatus(r api.OfflineResponder, protocol int) map[string]interface{} {
+	motd := r.Motd
+	if s.State == ProcessStartingState && r.StartingMotd != "" {
+		motd = r.StartingMotd
+	}
+
+	if motd == "" {
+		motd = "Server is offline"
+		if s.State == ProcessStartingState {
+			motd = "Server is starting..."
+		} else if r.WakeOnJoin {
+			motd = "Server is offline, join to start it"
+		}
+	}
+
+	version := r.Version
+	if version == "" {
+		version = "Offline"
+	}
+
+	return map[string]interface{}{
+		"version":     map[string]interface{}{"name": version, "protocol": protocol},
+		"players":     map[string]int{"max": 0, "online": 0},
+		"description": map[string]string{"text": motd},
+	}
+}
+
+// Reads a length prefixed packet of at most max bytes, returning the packet id and the
+// remaining data.
+func readMinecraftPacket(r *bufio.Reader, max int) (int, []byte, error) {
+	length, err := readVarInt(r)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	if length <= 0 || length > max {
+		return 0, nil, errors.Errorf("invalid packet length %d", length)
+	}
+
+	b := make([]byte, length)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return 0, nil, errors.WithStack(err)
+	}
+
+	br := bytes.NewReader(b)
+	id, err := readVarInt(br)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return id, b[len(b)-br.Len():], nil
+}
+
+// Writes a packet with the given id and data, prefixed by its length.
+func writeMinecraftPacket(w io.Writer, id int, data []byte) error {
+	body := append(appendVarInt(nil, id), data...)
+
+	_, err := w.Write(append(appendVarInt(nil, len(body)), body...))
+
+	return errors.WithStack(err)
+}
+
+// Encodes a string as a length prefixed UTF-8 string.
+func minecraftString(v string) []byte {
+	return append(appendVarInt(nil, len(v)), v...)
+}
+
+// Reads a variable length integer, which encodes seven bits in each byte with the high bit
+// set on every byte but the last.
+func readVarInt(r io.ByteReader) (int, error) {
+	var v uint32
+	for i := uint(0); i < 5; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+
+		v |= uint32(b&0x7f) << (7 * i)
+		if b&0x80 == 0 {
+			return int(int32(v)), nil
+		}
+	}
+
+	return 0, errors.New("variable length integer is too long")
+}
+
+func appendVarInt(b []byte, v int) []byte {
+	u := uint32(v)
+	for u >= 0x80 {
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+
+	return append(b, byte(u))
+}
@@ -0,0 +1,246 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/hooks"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The actions that can be taken when a server process is killed for running out of
+// memory.
+const (
+	// Restarts the server with a higher memory limit, subject to the crash detection
+	// limits of the server.
+	OomActionRestart = "restart"
+	// Only reports the kill, the server is then handled like any other crash.
+	OomActionNotify = "notify"
+	// Marks the server as failed, and does not restart it.
+	OomActionFail = "fail"
+)
+
+// An OOM event received within this long of a server process exiting is considered to
+// be the cause of the exit.
+const oomExitWindow = time.Second * 30
+
+// Defines what happens when the server process is killed for running out of memory.
+type OomPolicy struct {
+	Action string `default:"notify" json:"action" yaml:"action"`
+
+	// The percentage of the memory limit added each time the server is restarted after
+	// being killed, and the most that can be added in total. The additional memory gives
+	// the process headroom outside of the heap, and is removed when the server is next
+	// stopped. The SERVER_MEMORY variable is not changed. No memory is added unless this
+	// is set and the action is "restart", since the memory is taken from the other servers
+	// on the node.
+	MemoryBump    int `default:"0" json:"memory_bump" yaml:"memory_bump"`
+	MaxMemoryBump int `default:"50" json:"max_memory_bump" yaml:"max_memory_bump"`
+}
+
+// Details about the last time a server process was killed for running out of memory,
+// sent with the OOM event and returned along with the server.
+type OomKill struct {
+	Time time.Time `json:"time"`
+	// The memory limit of the container in megabytes at the time, including any memory
+	// previously added by the policy, and the last memory usage that was recorded.
+	MemoryLimit int64  `json:"memory_limit"`
+	MemoryUsage uint64 `json:"memory_usage_bytes"`
+	// The action taken once the process exited, empty until then or if the process
+	// was not stopped.
+	Action string `json:"action"`
+	// The memory in megabytes added to the limit for the next start of the server.
+	MemoryBump int64 `json:"memory_bump"`
+}
+
+// Starts the background routine that listens for containers being killed for running
+// out of memory. Events for containers that do not belong to a server are ignored.
+func StartOomMonitor() {
+	go supervisor.Supervise("oom monitor", func() error {
+		cli, err := NewRuntimeClient()
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+
+		opts := types.EventsOptions{
+			Filters: filters.NewArgs(filters.Arg("type", "container"), filters.Arg("event", "oom")),
+		}
+
+		messages, errs := cli.Events(context.Background(), opts)
+		for {
+			select {
+			case m := <-messages:
+				name := strings.TrimPrefix(m.Actor.Attributes["name"], "/")
+				if name == "" {
+					name = m.Actor.ID
+				}
+
+				if s := GetServers().Find(func(s *Server) bool { return s.Uuid == name }); s != nil {
+					s.recordOomKill()
+				}
+			case err := <-errs:
+				if err == nil {
+					err = errors.New("event stream closed")
+				}
+
+				return errors.Wrap(err, "failed to read container events")
+			}
+		}
+	})
+}
+
+// Returns a copy of the details about the last time the server process was killed for
+// running out of memory, or nil if it has not been.
+func (s *Server) lastOom() *OomKill {
+	s.oomLock.Lock()
+	defer s.oomLock.Unlock()
+
+	if s.LastOom == nil {
+		return nil
+	}
+
+	k := *s.LastOom
+
+	return &k
+}
+
+// Changes the details about the last OOM kill of the server. The details are replaced
+// rather than changed in place, since they can be read without the lock while the server
+// is being encoded. Nothing is changed if the server has not been killed.
+func (s *Server) updateLastOom(fn func(k *OomKill)) {
+	s.oomLock.Lock()
+	defer s.oomLock.Unlock()
+
+	if s.LastOom == nil {
+		return
+	}
+
+	k := *s.LastOom
+	fn(&k)
+	s.LastOom = &k
+}
+
+// Determines if the server process was killed for running out of memory recently enough
+// for it to be the reason the process exited.
+func (s *Server) oomKilledRecently() bool {
+	k := s.lastOom()
+
+	return k != nil && time.Since(k.Time) <= oomExitWindow
+}
+
+// Returns the memory in megabytes added to the limit of the server by its OOM policy.
+func (s *Server) oomMemoryBump() int64 {
+	if k := s.lastOom(); k != nil {
+		return k.MemoryBump
+	}
+
+	return 0
+}
+
+// Returns the memory limit of the server in megabytes including any memory added by its
+// OOM policy, which is what the container is actually allowed to use.
+func (s *Server) effectiveMemoryLimit() int64 {
+	if s.Build.MemoryLimit <= 0 {
+		return s.Build.MemoryLimit
+	}
+
+	return s.Build.MemoryLimit + s.oomMemoryBump()
+}
+
+// Records that the server process was killed for running out of memory and notifies any
+// listeners. The action taken is decided once the process has exited.
+func (s *Server) recordOomKill() {
+	s.oomLock.Lock()
+	var bump int64
+	if s.LastOom != nil {
+		bump = s.LastOom.MemoryBump
+	}
+
+	k := OomKill{
+		Time:        time.Now().UTC(),
+		MemoryLimit: s.Build.MemoryLimit + bump,
+		MemoryUsage: s.Resources.Memory,
+		MemoryBump:  bump,
+	}
+	s.LastOom = &k
+	s.oomLock.Unlock()
+
+	zap.S().Warnw("server process was killed for running out of memory", zap.String("server", s.Uuid), zap.Int64("memory_limit", k.MemoryLimit))
+
+	s.PublishConsoleOutputFromDaemon("---------- Server process ran out of memory! ----------")
+	if k.MemoryLimit > 0 {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("A process was killed for using more than the %d MB of memory available to the server.", k.MemoryLimit))
+	}
+
+	s.publishOomKill()
+
+	s.fireHook(hooks.OomKill, map[string]string{
+		"memory_limit": strconv.FormatInt(k.MemoryLimit, 10),
+		"memory_usage": strconv.FormatUint(k.MemoryUsage, 10),
+	})
+}
+
+func (s *Server) publishOomKill() {
+	if b, err := json.Marshal(s.lastOom()); err == nil {
+		s.Events().Publish(OomEvent, string(b))
+	}
+}
+
+// Applies the OOM policy of the server after the process exited because it ran out of
+// memory. Returns true if the server should not be restarted.
+func (s *Server) applyOomPolicy() bool {
+	// The event may not have been received if the monitor is not running, or if the
+	// container was only reported as killed once it exited.
+	if !s.oomKilledRecently() {
+		s.recordOomKill()
+	}
+
+	p := s.OomPolicy
+	limit := s.Build.MemoryLimit
+
+	var action string
+	var raised int64
+	s.updateLastOom(func(k *OomKill) {
+		switch p.Action {
+		case OomActionRestart:
+			k.Action = OomActionRestart
+
+			if limit > 0 && p.MemoryBump > 0 {
+				max := limit * int64(p.MaxMemoryBump) / 100
+				if bump := k.MemoryBump + limit*int64(p.MemoryBump)/100; bump <= max {
+					k.MemoryBump = bump
+				} else {
+					k.MemoryBump = max
+				}
+
+				raised = limit + k.MemoryBump
+			}
+		case OomActionFail:
+			k.Action = OomActionFail
+		default:
+			k.Action = OomActionNotify
+		}
+
+		action = k.Action
+	})
+
+	if action == OomActionFail {
+		s.OomFailed = true
+
+		s.PublishConsoleOutputFromDaemon("Server has been marked as failed after running out of memory and will not be restarted.")
+	} else if raised > 0 {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Memory limit raised to %d MB until the server is next stopped.", raised))
+	}
+
+	s.publishOomKill()
+
+	return action == OomActionFail
+}
@@ -0,0 +1,251 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// The directory containing the local override files for servers. This is intentionally
+// not stored within the server data directory since that directory is writable by the
+// server owner, and overrides are able to adjust resource limits.
+const overridesDirectory = "data/overrides"
+
+// Defines local adjustments to a server's configuration that are applied on top of the
+// configuration received from the Panel. This allows a node administrator to make
+// emergency changes to a server without needing the Panel to be reachable. Only the
+// fields that are set in the override file are changed.
+type Overrides struct {
+	// Replaces the Docker image used for the server.
+	Image string `yaml:"image"`
+
+	// Replaces the startup command for the server entirely.
+	Invocation string `yaml:"invocation"`
+
+	// Appended to the end of the startup command for the server. This is applied after
+	// the invocation override, if one is set.
+	InvocationAppend string `yaml:"invocation_append"`
+
+	// Environment variables that are set or replaced for the server process.
+	Environment map[string]string `yaml:"environment"`
+
+	Build struct {
+		MemoryLimit *int64    `yaml:"memory"`
+		Swap        *int64    `yaml:"swap"`
+		IoWeight    *uint16   `yaml:"io"`
+		IoLimits    *IoLimits `yaml:"io_limits"`
+		CpuLimit    *int64    `yaml:"cpu"`
+		CpuWeight   *uint64   `yaml:"cpu_weight"`
+		DiskSpace   *int64    `yaml:"disk"`
+		PidsLimit   *int64    `yaml:"pids_limit"`
+	} `yaml:"build"`
+}
+
+// Returns the path to the override file for the server.
+func (s *Server) overridesPath() string {
+	return path.Join(overridesDirectory, s.Uuid+".yml")
+}
+
+// Reads the local override file for the server. If there is no override file for the
+// server nil is returned.
+func (s *Server) GetOverrides() (*Overrides, error) {
+	b, err := ioutil.ReadFile(s.overridesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	o := new(Overrides)
+	if err := yaml.Unmarshal(b, o); err != nil {
+		return nil, errors.Wrap(err, "failed to parse server override file")
+	}
+
+	return o, nil
+}
+
+// Writes the local override file for the server, replacing any existing overrides.
+func (s *Server) SaveOverrides(o *Overrides) error {
+	b, err := yaml.Marshal(o)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(overridesDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(s.overridesPath(), b, 0600))
+}
+
+// The values from the Panel that were replaced when the local overrides were applied to
+// a server, so that the overrides can be removed again.
+type panelValues struct {
+	overrides  *Overrides
+	image      string
+	invocation string
+	// The previous value of each environment variable that was overridden, nil if the
+	// variable was not set.
+	environment map[string]*string
+	build       BuildSettings
+}
+
+// Restores the values from the Panel that were replaced by the overrides.
+func (v *panelValues) restore(s *Server) {
+	o := v.overrides
+
+	if o.Image != "" {
+		s.Container.Image = v.image
+	}
+
+	if o.Invocation != "" || o.InvocationAppend != "" {
+		s.Invocation = v.invocation
+	}
+
+	for k, prev := range v.environment {
+		if prev == nil {
+			delete(s.EnvVars, k)
+		} else if s.EnvVars != nil {
+			s.EnvVars[k] = *prev
+		}
+	}
+
+	if o.Build.MemoryLimit != nil {
+		s.Build.MemoryLimit = v.build.MemoryLimit
+	}
+
+	if o.Build.Swap != nil {
+		s.Build.Swap = v.build.Swap
+	}
+
+	if o.Build.IoWeight != nil {
+		s.Build.IoWeight = v.build.IoWeight
+	}
+
+	if o.Build.IoLimits != nil {
+		s.Build.IoLimits = v.build.IoLimits
+	}
+
+	if o.Build.CpuLimit != nil {
+		s.Build.CpuLimit = v.build.CpuLimit
+	}
+
+	if o.Build.CpuWeight != nil {
+		s.Build.CpuWeight = v.build.CpuWeight
+	}
+
+	if o.Build.DiskSpace != nil {
+		s.Build.DiskSpace = v.build.DiskSpace
+	}
+
+	if o.Build.PidsLimit != nil {
+		s.Build.PidsLimit = v.build.PidsLimit
+	}
+}
+
+// Removes the local overrides from the server, returning it to the configuration from the
+// Panel. This is done before changes from the Panel are merged in, so that the values the
+// Panel does not send are not replaced by the overrides.
+func (s *Server) removeOverrides() {
+	if s.panelValues != nil {
+		s.panelValues.restore(s)
+		s.panelValues = nil
+	}
+}
+
+// Applies any local overrides for the server on top of the configuration from the Panel.
+// Errors reading the override file are logged but do not prevent the server from being
+// used. The overrides are never written to the configuration file for the server.
+func (s *Server) applyOverrides() {
+	o, err := s.GetOverrides()
+	if err != nil {
+		zap.S().Errorw("failed to load server configuration overrides", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	s.removeOverrides()
+	if o == nil {
+		return
+	}
+
+	zap.S().Infow("applying local configuration overrides to server", zap.String("server", s.Uuid), zap.String("path", s.overridesPath()))
+
+	v := &panelValues{
+		overrides:   o,
+		image:       s.Container.Image,
+		invocation:  s.Invocation,
+		environment: make(map[string]*string, len(o.Environment)),
+		build:       s.Build,
+	}
+
+	for k := range o.Environment {
+		if prev, ok := s.EnvVars[k]; ok {
+			v.environment[k] = &prev
+		} else {
+			v.environment[k] = nil
+		}
+	}
+	s.panelValues = v
+
+	if o.Image != "" {
+		s.Container.Image = o.Image
+	}
+
+	if o.Invocation != "" {
+		s.Invocation = o.Invocation
+	}
+
+	// Partial updates from the Panel will not always include the invocation, so avoid
+	// appending the same value to it more than once.
+	if o.InvocationAppend != "" && !strings.HasSuffix(s.Invocation, " "+o.InvocationAppend) {
+		s.Invocation = s.Invocation + " " + o.InvocationAppend
+	}
+
+	if len(o.Environment) > 0 {
+		if s.EnvVars == nil {
+			s.EnvVars = make(map[string]string)
+		}
+
+		for k, v := range o.Environment {
+			s.EnvVars[k] = v
+		}
+	}
+
+	if o.Build.MemoryLimit != nil {
+		s.Build.MemoryLimit = *o.Build.MemoryLimit
+	}
+
+	if o.Build.Swap != nil {
+		s.Build.Swap = *o.Build.Swap
+	}
+
+	if o.Build.IoWeight != nil {
+		s.Build.IoWeight = *o.Build.IoWeight
+	}
+
+	if o.Build.IoLimits != nil {
+		s.Build.IoLimits = *o.Build.IoLimits
+	}
+
+	if o.Build.CpuLimit != nil {
+		s.Build.CpuLimit = *o.Build.CpuLimit
+	}
+
+	if o.Build.CpuWeight != nil {
+		s.Build.CpuWeight = *o.Build.CpuWeight
+	}
+
+	if o.Build.DiskSpace != nil {
+		s.Build.DiskSpace = *o.Build.DiskSpace
+	}
+
+	if o.Build.PidsLimit != nil {
+		s.Build.PidsLimit = *o.Build.PidsLimit
+	}
+}
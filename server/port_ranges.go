@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/firewall"
+	"go.uber.org/zap"
+	"strconv"
+)
+
+// A contiguous range of ports allocated to a server as a single allocation, such as the
+// range of UDP ports used by a voice server for its virtual servers.
+type PortRange struct {
+	Ip    string `json:"ip"`
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// Either "tcp" or "udp", or empty if the range uses both protocols.
+	Protocol string `json:"protocol"`
+}
+
+// Returns the protocols used by the range.
+func (r PortRange) protocols() []string {
+	if r.Protocol == "" {
+		return []string{"tcp", "udp"}
+	}
+
+	return []string{r.Protocol}
+}
+
+// Returns the number of ports in the range.
+func (r PortRange) Size() int {
+	return r.End - r.Start + 1
+}
+
+// Returns the range in the "start-end/protocol" format used by Docker.
+func (r PortRange) String() string {
+	s := fmt.Sprintf("%d-%d", r.Start, r.End)
+	if r.Protocol != "" {
+		s += "/" + r.Protocol
+	}
+
+	return s
+}
+
+// Checks that the range contains valid ports and is no larger than the node allows.
+func (r PortRange) validate(max int) error {
+	switch {
+	case r.Start < 1 || r.End > 65535 || r.Start > r.End:
+		return errors.Errorf("port range %s is not valid", r)
+	case r.Protocol != "" && r.Protocol != "tcp" && r.Protocol != "udp":
+		return errors.Errorf("port range %s has an unknown protocol", r)
+	case max > 0 && r.Size() > max:
+		return errors.Errorf("port range %s contains more than %d ports", r, max)
+	}
+
+	return nil
+}
+
+// Returns the port ranges of the server that are valid on this node. Invalid ranges are
+// logged and ignored rather than preventing the server from starting.
+func (s *Server) portRanges() []PortRange {
+	max := config.Get().Docker.PortRanges.MaxSize
+
+	var out []PortRange
+	for _, r := range s.Allocations.Ranges {
+		if err := r.validate(max); err != nil {
+			zap.S().Warnw("ignoring invalid port range for server", zap.String("server", s.Uuid), zap.Error(err))
+			continue
+		}
+
+		out = append(out, r)
+	}
+
+	return out
+}
+
+// Determines if the port range is forwarded to the container by the daemon rather than
+// being published by Docker.
+func (s *Server) forwardsPortRange(r PortRange) bool {
+	if config.Get().Docker.PortRanges.Publish != "nat" {
+		return false
+	}
+
+	if s.Network.Mode == "host" || s.Network.Mode == "macvlan" {
+		return false
+	}
+
+	return !isIpv6Address(r.Ip)
+}
+
+// Forwards the port ranges that are not published by Docker to the container, replacing
+// any forwards that existed for a previous container.
+func (d *DockerEnvironment) applyPortRangeForwards() error {
+	var ranges []PortRange
+	for _, r := range d.Server.portRanges() {
+		if d.Server.forwardsPortRange(r) {
+			ranges = append(ranges, r)
+		}
+	}
+
+	if len(ranges) == 0 {
+		return firewall.RemoveForwards(d.Server.Uuid)
+	}
+
+	ip, err := d.containerIp()
+	if err != nil {
+		return err
+	}
+
+	forwards := make([]firewall.Forward, len(ranges))
+	for i, r := range ranges {
+		forwards[i] = firewall.Forward{
+			Ip:       config.TrimAddressBrackets(r.Ip),
+			Protocol: r.Protocol,
+			Start:    r.Start,
+			End:      r.End,
+			Target:   ip,
+		}
+	}
+
+	return firewall.ApplyForwards(d.Server.Uuid, forwards)
+}
+
+// Returns the environment variables describing the first port range of the server, so
+// that the startup command of a voice server can pass the range to the server process.
+func (a *Allocations) rangeEnvironment() []string {
+	if len(a.Ranges) == 0 {
+		return nil
+	}
+
+	r := a.Ranges[0]
+
+	return []string{
+		fmt.Sprintf("SERVER_PORT_RANGE=%d-%d", r.Start, r.End),
+		fmt.Sprintf("SERVER_PORT_RANGE_START=%d", r.Start),
+		fmt.Sprintf("SERVER_PORT_RANGE_END=%d", r.End),
+	}
+}
+
+// Looks up a single value of a port range for the "ranges.<index>.<field>" allocation
+// placeholders.
+func (a *Allocations) lookupRangePlaceholder(index string, field string) (string, bool) {
+	i, err := strconv.Atoi(index)
+	if err != nil || i < 0 || i >= len(a.Ranges) {
+		return "", false
+	}
+
+	r := a.Ranges[i]
+
+	switch field {
+	case "ip":
+		return config.TrimAddressBrackets(r.Ip), true
+	case "start":
+		return strconv.Itoa(r.Start), true
+	case "end":
+		return strconv.Itoa(r.End), true
+	case "size":
+		return strconv.Itoa(r.Size()), true
+	case "protocol":
+		return r.Protocol, true
+	case "range":
+		return fmt.Sprintf("%d-%d", r.Start, r.End), true
+	}
+
+	return "", false
+}
@@ -0,0 +1,175 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"net"
+	"sort"
+	"strconv"
+)
+
+// A port allocated to a server on the node.
+type PortClaim struct {
+	Server string `json:"server"`
+	Ip     string `json:"ip"`
+	Port   int    `json:"port"`
+	// Set to true if the server uses host networking, in which case the port is bound
+	// by the server process directly rather than being published by Docker.
+	Host bool `json:"host"`
+}
+
+// Two servers that have been allocated the same port.
+type PortConflict struct {
+	Port    int         `json:"port"`
+	Servers []PortClaim `json:"servers"`
+}
+
+// Returns the ports allocated to the server.
+func (s *Server) portClaims() []PortClaim {
+	var claims []PortClaim
+
+	for ip, ports := range s.Allocations.Bindings() {
+		for _, port := range ports {
+			claims = append(claims, PortClaim{
+				Server: s.Uuid,
+				Ip:     config.TrimAddressBrackets(ip),
+				Port:   port,
+				Host:   s.Network.Mode == "host",
+			})
+		}
+	}
+
+	for _, r := range s.portRanges() {
+		for port := r.Start; port <= r.End; port++ {
+			claims = append(claims, PortClaim{
+				Server: s.Uuid,
+				Ip:     config.TrimAddressBrackets(r.Ip),
+				Port:   port,
+				Host:   s.Network.Mode == "host",
+			})
+		}
+	}
+
+	return claims
+}
+
+// Returns every port allocated to a server on the node, sorted by port.
+func GetPortClaims() []PortClaim {
+	var claims []PortClaim
+	for _, s := range GetServers().All() {
+		claims = append(claims, s.portClaims()...)
+	}
+
+	sort.Slice(claims, func(i, j int) bool {
+		if claims[i].Port == claims[j].Port {
+			return claims[i].Server < claims[j].Server
+		}
+
+		return claims[i].Port < claims[j].Port
+	})
+
+	return claims
+}
+
+// Returns all of the ports that are allocated to more than one server on the node. When
+// using bridge networking Docker prevents two containers from binding the same port, but
+// servers using host networking bind the ports themselves so it is up to the daemon to
+// keep track of them.
+func GetPortConflicts() []PortConflict {
+	claims := GetPortClaims()
+
+	var conflicts []PortConflict
+	for i := 0; i < len(claims); {
+		j := i + 1
+		for j < len(claims) && claims[j].Port == claims[i].Port {
+			j++
+		}
+
+		var overlapping []PortClaim
+		for a := i; a < j; a++ {
+			for b := i; b < j; b++ {
+				if a != b && claims[a].Server != claims[b].Server && addressesOverlap(claims[a].Ip, claims[b].Ip) {
+					overlapping = append(overlapping, claims[a])
+					break
+				}
+			}
+		}
+
+		if len(overlapping) > 0 {
+			conflicts = append(conflicts, PortConflict{Port: claims[i].Port, Servers: overlapping})
+		}
+
+		i = j
+	}
+
+	return conflicts
+}
+
+// Checks that none of the ports allocated to the server are in use by another running
+// server before it is started. Servers using host networking also have their ports
+// checked against the host, since nothing else prevents the process from starting while
+// another program is bound to them.
+func (s *Server) checkPortConflicts() error {
+	host := s.Network.Mode == "host"
+
+	for _, other := range GetServers().All() {
+		if other.Uuid == s.Uuid || other.State == ProcessOfflineState {
+			continue
+		}
+
+		// Conflicts between two servers using bridge networking are caught by Docker.
+		if !host && other.Network.Mode != "host" {
+			continue
+		}
+
+		for _, a := range s.portClaims() {
+			for _, b := range other.portClaims() {
+				if a.Port == b.Port && addressesOverlap(a.Ip, b.Ip) {
+					return errors.Errorf("port %d is already in use by server %s", a.Port, other.Uuid)
+				}
+			}
+		}
+	}
+
+	if !host {
+		return nil
+	}
+
+	for _, c := range s.portClaims() {
+		if err := portAvailable(c.Ip, c.Port); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Determines if two addresses overlap, which is the case if they are the same or if
+// either one is unspecified and therefore bound to every address.
+func addressesOverlap(a string, b string) bool {
+	ipa, ipb := net.ParseIP(a), net.ParseIP(b)
+	if ipa == nil || ipb == nil || ipa.IsUnspecified() || ipb.IsUnspecified() {
+		return true
+	}
+
+	return ipa.Equal(ipb)
+}
+
+// Checks that both the TCP and UDP port are free on the host by briefly binding them.
+func portAvailable(ip string, port int) error {
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "port %s is already in use on the host", addr)
+	}
+	l.Close()
+
+	pc, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return errors.Wrapf(err, "port %s is already in use on the host", addr)
+	}
+	pc.Close()
+
+	return nil
+}
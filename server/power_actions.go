@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// The power actions built into the daemon, which cannot be replaced by an egg.
+var BuiltinPowerActions = []string{"start", "stop", "restart", "kill"}
+
+// Tracks the power actions that are currently running so that an action is not started
+// again for a server while it is still running.
+var runningPowerActions sync.Map
+
+// Determines if the power action is one of the actions built into the daemon.
+func IsBuiltinPowerAction(name string) bool {
+	return containsString(BuiltinPowerActions, name)
+}
+
+// Returns the permission a websocket token must grant to run the power action.
+func PowerActionPermission(a api.PowerAction) string {
+	if a.Permission != "" {
+		return a.Permission
+	}
+
+	return "power." + a.Name
+}
+
+// Returns the additional power actions defined by the egg of the server. Actions using
+// the name of a built in action are ignored.
+func (s *Server) PowerActions() []api.PowerAction {
+	out := []api.PowerAction{}
+	if s.processConfiguration == nil {
+		return out
+	}
+
+	for _, a := range s.processConfiguration.PowerActions {
+		if a.Name == "" || IsBuiltinPowerAction(a.Name) {
+			continue
+		}
+
+		out = append(out, a)
+	}
+
+	return out
+}
+
+// Returns the additional power action with the given name.
+func (s *Server) PowerAction(name string) (api.PowerAction, bool) {
+	for _, a := range s.PowerActions() {
+		if a.Name == name {
+			return a, true
+		}
+	}
+
+	return api.PowerAction{}, false
+}
+
+// Starts running an additional power action for the server in the background, streaming
+// the progress to the console. The server must be running.
+func (s *Server) RunPowerAction(name string) error {
+	a, ok := s.PowerAction(name)
+	if !ok {
+		return errors.Errorf("power action \"%s\" is not defined", name)
+	}
+
+	for _, step := range a.Steps {
+		if step.Type != api.ProcessStopCommand && step.Type != api.ProcessStopSignal {
+			return errors.Errorf("unknown power action step type \"%s\"", step.Type)
+		}
+	}
+
+	if !IsRunningState(s.State) {
+		return errors.New("cannot run a power action for a server that is not running")
+	}
+
+	key := s.Uuid + ":" + name
+	if _, running := runningPowerActions.LoadOrStore(key, true); running {
+		return errors.Errorf("power action \"%s\" is already running", name)
+	}
+
+	zap.S().Debugw("running power action for server", zap.String("server", s.Uuid), zap.String("action", name))
+
+	go func() {
+		defer runningPowerActions.Delete(key)
+		defer supervisor.Recover("power actions")
+
+		for i, step := range a.Steps {
+			// Stop running the action if the server stopped while waiting, there is no
+			// process to send the remaining steps to.
+			if !IsRunningState(s.State) {
+				s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Power action \"%s\" was cancelled because the server stopped.", name))
+				return
+			}
+
+			s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Running %s step %d of %d: %s %s", name, i+1, len(a.Steps), step.Type, step.Value))
+
+			var err error
+			if step.Type == api.ProcessStopCommand {
+				err = s.Environment.SendCommand(step.Value)
+			} else {
+				err = s.Environment.Signal(step.Value)
+			}
+
+			if err != nil {
+				zap.S().Warnw("failed to run power action step", zap.String("server", s.Uuid), zap.String("action", name), zap.Int("step", i+1), zap.Error(err))
+				return
+			}
+
+			if step.Wait > 0 {
+				time.Sleep(time.Duration(step.Wait) * time.Second)
+			}
+		}
+	}()
+
+	return nil
+}
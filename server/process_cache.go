@@ -0,0 +1,147 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// The directory containing the last process configuration received from the Panel for
+// each server.
+const processCacheDirectory = "data/process_cache"
+
+// A copy of the configuration for a server as it was last received from the Panel.
+type cachedProcessConfiguration struct {
+	// A hash of the configuration, used to detect when the Panel has changed it.
+	Hash     string                           `json:"hash"`
+	CachedAt time.Time                        `json:"cached_at"`
+	Response *api.ServerConfigurationResponse `json:"response"`
+}
+
+// Returns the path to the cached process configuration for the server.
+func (s *Server) processCachePath() string {
+	return path.Join(processCacheDirectory, s.Uuid+".json")
+}
+
+// Gets the process configuration data for the server. The configuration is cached every
+// time it is fetched, and if the Panel cannot be reached the cached copy is returned
+// instead so that servers can still be started and stopped while the Panel is down.
+func (s *Server) GetProcessConfiguration() (*api.ServerConfigurationResponse, *api.RequestError, error) {
+	res, rerr, err := api.NewRequester().GetServerConfiguration(s.Uuid)
+	if err == nil && rerr == nil {
+		s.reconcileProcessConfiguration(res)
+
+		return res, nil, nil
+	}
+
+	// Only fall back to the cache when the Panel is unavailable, any other error such as
+	// the server no longer existing must be handled by the caller.
+	if err != nil || (rerr != nil && strings.HasPrefix(rerr.Status, "5")) {
+		if c, cerr := s.readProcessCache(); cerr == nil && c != nil {
+			zap.S().Warnw("panel is unavailable, using cached process configuration for server", zap.String("server", s.Uuid), zap.Time("cached_at", c.CachedAt), zap.Error(err))
+
+			s.usingProcessCache = true
+
+			return c.Response, nil, nil
+		}
+	}
+
+	return res, rerr, err
+}
+
+// Writes the configuration received from the Panel to the cache. If the server had been
+// using the cached copy while the Panel was unavailable, and the Panel has since changed
+// the configuration, the new configuration is used immediately.
+func (s *Server) reconcileProcessConfiguration(res *api.ServerConfigurationResponse) {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return
+	}
+
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+
+	previous, _ := s.readProcessCache()
+	if previous != nil && previous.Hash != hash && s.usingProcessCache {
+		zap.S().Infow("process configuration for server changed while the panel was unavailable", zap.String("server", s.Uuid))
+
+		if s.processConfiguration != nil {
+			s.processConfiguration = res.ProcessConfiguration
+		}
+	}
+
+	s.usingProcessCache = false
+
+	if previous != nil && previous.Hash == hash {
+		return
+	}
+
+	if err := s.writeProcessCache(cachedProcessConfiguration{Hash: hash, CachedAt: time.Now().UTC(), Response: res}); err != nil {
+		zap.S().Warnw("failed to cache process configuration for server", zap.String("server", s.Uuid), zap.Error(err))
+	}
+}
+
+// Reads the cached process configuration for the server. If there is no cached copy nil
+// is returned.
+func (s *Server) readProcessCache() (*cachedProcessConfiguration, error) {
+	b, err := ioutil.ReadFile(s.processCachePath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	c := &cachedProcessConfiguration{}
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return c, nil
+}
+
+func (s *Server) writeProcessCache(c cachedProcessConfiguration) error {
+	if err := os.MkdirAll(processCacheDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.Marshal(c)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(s.processCachePath(), b, 0600))
+}
+
+// Loads the cached process configuration when the server is first loaded, so that a
+// server which is already running can be stopped before it has been synced with the
+// Panel.
+func (s *Server) loadCachedProcessConfiguration() {
+	c, err := s.readProcessCache()
+	if err != nil {
+		zap.S().Warnw("failed to read cached process configuration for server", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	if c != nil && c.Response != nil {
+		s.processConfiguration = c.Response.ProcessConfiguration
+	}
+}
+
+// Removes the cached process configuration for the server.
+func (s *Server) RemoveProcessCache() error {
+	if err := os.Remove(s.processCachePath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,321 @@
+package server
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/parser"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The directory containing the configuration profiles defined for servers.
+const profilesDirectory = "data/profiles"
+
+// Defines when switching to or from a profile is applied to a running server.
+const (
+	ProfileRestartOnSwitch = "restart"
+	ProfileApplyNextStart  = "next_start"
+)
+
+// The amount of time a server is given to stop when it is restarted to switch profiles
+// before the process is killed.
+const profileStopTimeout = time.Minute * 2
+
+var profilesLock sync.Mutex
+
+// A named set of changes applied on top of the normal configuration of a server, such
+// as the settings used while running a recurring event.
+type Profile struct {
+	// Environment variables set or replaced for the server process.
+	Environment map[string]string `json:"environment"`
+
+	// Configuration file replacements that are applied after those defined by the egg.
+	Files []parser.ConfigurationFile `json:"files"`
+
+	// Controls if the server is restarted when switching to or from this profile. Either
+	// "restart" or "next_start", which is the default.
+	RestartPolicy string `json:"restart_policy"`
+}
+
+// Activates a profile during a recurring window of time. Times are in the local time
+// of the node.
+type ProfileSchedule struct {
+	Profile string `json:"profile"`
+
+	// The days the window starts on, such as "sat" or "sun". If empty the window starts
+	// every day.
+	Days []string `json:"days"`
+
+	// The start and end of the window in "15:04" format. If the end is before the start
+	// the window finishes on the following day.
+	Start string `json:"start"`
+	End   string `json:"end"`
+}
+
+// All of the profiles defined for a server along with the active profile. An empty
+// active profile means the normal configuration is used.
+type ProfileSet struct {
+	Active    string             `json:"active"`
+	Profiles  map[string]Profile `json:"profiles"`
+	Schedules []ProfileSchedule  `json:"schedules"`
+
+	// The profile selected by the schedule the last time it was checked. This is used to
+	// only switch profiles when a window starts or ends, so a profile activated manually
+	// is left alone until the next scheduled change.
+	Scheduled string `json:"scheduled"`
+}
+
+// Returns the path to the profile file for the server.
+func (s *Server) profilesPath() string {
+	return path.Join(profilesDirectory, s.Uuid+".json")
+}
+
+// Returns the profiles defined for the server.
+func (s *Server) GetProfiles() (*ProfileSet, error) {
+	set := &ProfileSet{Profiles: make(map[string]Profile)}
+
+	b, err := ioutil.ReadFile(s.profilesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return set, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	if err := json.Unmarshal(b, set); err != nil {
+		return nil, errors.Wrap(err, "failed to parse server profiles")
+	}
+
+	return set, nil
+}
+
+// Validates and stores the profiles for the server.
+func (s *Server) saveProfiles(set *ProfileSet) error {
+	if set.Active != "" {
+		if _, ok := set.Profiles[set.Active]; !ok {
+			return errors.Errorf("profile \"%s\" is not defined", set.Active)
+		}
+	}
+
+	for _, sc := range set.Schedules {
+		if _, ok := set.Profiles[sc.Profile]; !ok {
+			return errors.Errorf("scheduled profile \"%s\" is not defined", sc.Profile)
+		}
+
+		if _, _, err := sc.window(); err != nil {
+			return err
+		}
+	}
+
+	if err := os.MkdirAll(profilesDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.MarshalIndent(set, "", "    ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(s.profilesPath(), b, 0600))
+}
+
+// Replaces the profiles and schedules defined for the server, keeping the currently
+// active profile if it still exists.
+func (s *Server) UpdateProfiles(profiles map[string]Profile, schedules []ProfileSchedule) error {
+	profilesLock.Lock()
+	defer profilesLock.Unlock()
+
+	set, err := s.GetProfiles()
+	if err != nil {
+		return err
+	}
+
+	set.Profiles = profiles
+	set.Schedules = schedules
+
+	if _, ok := profiles[set.Active]; !ok {
+		set.Active = ""
+	}
+
+	return s.saveProfiles(set)
+}
+
+// Switches the server to the given profile, or back to the normal configuration if the
+// name is empty. If either profile requires it the server is restarted, otherwise the
+// change is applied the next time the server starts.
+func (s *Server) ActivateProfile(name string) error {
+	profilesLock.Lock()
+	set, err := s.GetProfiles()
+	if err != nil {
+		profilesLock.Unlock()
+		return err
+	}
+
+	previous := set.Profiles[set.Active]
+	if set.Active == name {
+		profilesLock.Unlock()
+		return nil
+	}
+
+	set.Active = name
+	err = s.saveProfiles(set)
+	profilesLock.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	zap.S().Infow("switched server configuration profile", zap.String("server", s.Uuid), zap.String("profile", name))
+
+	if s.State == ProcessOfflineState {
+		return nil
+	}
+
+	if set.Profiles[name].RestartPolicy != ProfileRestartOnSwitch && previous.RestartPolicy != ProfileRestartOnSwitch {
+		s.PublishConsoleOutputFromDaemon("Server configuration profile changed, this will be applied the next time the server is started.")
+		return nil
+	}
+
+	s.PublishConsoleOutputFromDaemon("Server configuration profile changed, restarting the server to apply it...")
+
+	go func() {
+		if err := s.gracefulRestart(profileStopTimeout, "the configuration profile of the server changed"); err != nil {
+			zap.S().Errorw("failed to restart server after switching profile", zap.String("server", s.Uuid), zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Returns the active profile for the server, or nil if the normal configuration is used.
+func (s *Server) activeProfile() *Profile {
+	set, err := s.GetProfiles()
+	if err != nil {
+		zap.S().Errorw("failed to load server profiles", zap.String("server", s.Uuid), zap.Error(err))
+		return nil
+	}
+
+	if p, ok := set.Profiles[set.Active]; ok && set.Active != "" {
+		return &p
+	}
+
+	return nil
+}
+
+// Returns the start and end of the window as minutes since midnight.
+func (sc *ProfileSchedule) window() (int, int, error) {
+	start, err := time.Parse("15:04", sc.Start)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid schedule start time \"%s\"", sc.Start)
+	}
+
+	end, err := time.Parse("15:04", sc.End)
+	if err != nil {
+		return 0, 0, errors.Errorf("invalid schedule end time \"%s\"", sc.End)
+	}
+
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// Determines if the schedule window is open at the given time.
+func (sc *ProfileSchedule) Contains(t time.Time) bool {
+	start, end, err := sc.window()
+	if err != nil {
+		return false
+	}
+
+	now := t.Hour()*60 + t.Minute()
+	day := t
+
+	// For windows that cross midnight the early hours belong to the window that started
+	// on the previous day.
+	if end <= start && now < end {
+		day = t.AddDate(0, 0, -1)
+		now += 24 * 60
+	}
+
+	if end <= start {
+		end += 24 * 60
+	}
+
+	if now < start || now >= end {
+		return false
+	}
+
+	if len(sc.Days) == 0 {
+		return true
+	}
+
+	name := strings.ToLower(day.Weekday().String()[:3])
+	for _, d := range sc.Days {
+		if strings.ToLower(d) == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the profile that the schedules select at the given time. The first schedule
+// with an open window is used.
+func (set *ProfileSet) scheduledProfile(t time.Time) string {
+	for _, sc := range set.Schedules {
+		if sc.Contains(t) {
+			return sc.Profile
+		}
+	}
+
+	return ""
+}
+
+// Starts the background routine that switches server profiles according to their
+// schedules.
+func StartProfileScheduler() {
+	go supervisor.Supervise("profile scheduler", func() error {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, s := range GetServers().All() {
+				s.runProfileSchedule(time.Now())
+			}
+		}
+
+		return nil
+	})
+}
+
+func (s *Server) runProfileSchedule(t time.Time) {
+	profilesLock.Lock()
+	set, err := s.GetProfiles()
+	if err != nil || len(set.Schedules) == 0 {
+		profilesLock.Unlock()
+		return
+	}
+
+	desired := set.scheduledProfile(t)
+	if desired == set.Scheduled {
+		profilesLock.Unlock()
+		return
+	}
+
+	set.Scheduled = desired
+	err = s.saveProfiles(set)
+	profilesLock.Unlock()
+
+	if err != nil {
+		zap.S().Errorw("failed to save server profile schedule state", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	if err := s.ActivateProfile(desired); err != nil {
+		zap.S().Errorw("failed to switch to scheduled server profile", zap.String("server", s.Uuid), zap.String("profile", desired), zap.Error(err))
+	}
+}
@@ -0,0 +1,354 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/metrics"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// The time allowed for a server to answer a single query.
+const queryTimeout = time.Second * 5
+
+// The largest Minecraft status response that is accepted. Responses include the server
+// icon, so they are much larger than the packets sent by clients.
+const queryMaxMinecraftPacket = 1 << 18
+
+// The players connected to a server as reported by its query protocol.
+type PlayerCount struct {
+	Online int    `json:"online"`
+	Max    int    `json:"max"`
+	Map    string `json:"map,omitempty"`
+	// The time at which the server last answered a query.
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Tracks the routine querying a running server for its players.
+type queryPoller struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+}
+
+// Determines if the egg for the server defines a protocol that can be used to query it.
+func (s *Server) hasQuery() bool {
+	return s.processConfiguration != nil && s.processConfiguration.Query.Protocol != ""
+}
+
+// Begins querying a server that has just finished starting for the players connected to
+// it, stopping any querying that is still running from a previous start. Querying stops
+// once the server is no longer running.
+func (s *Server) startQueryPolling() {
+	s.stopQueryPolling()
+
+	if !s.hasQuery() {
+		return
+	}
+
+	q := s.processConfiguration.Query
+
+	interval := time.Duration(q.Interval) * time.Second
+	if interval <= 0 {
+		interval = time.Second * 15
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s.queryPoller.mu.Lock()
+	s.queryPoller.cancel = cancel
+	s.queryPoller.mu.Unlock()
+
+	go func() {
+		defer supervisor.Recover("query polling")
+
+		var previous *PlayerCount
+		var emptySince time.Time
+		for ctx.Err() == nil && IsRunningState(s.State) {
+			current, err := s.queryPlayers(q)
+			if err != nil {
+				zap.S().Debugw("failed to query server for players", zap.String("server", s.Uuid), zap.String("protocol", q.Protocol), zap.Error(err))
+			} else {
+				s.Resources.Players = current
+				metrics.ServerPlayers.Set(float64(current.Online), s.Uuid)
+				metrics.ServerMaxPlayers.Set(float64(current.Max), s.Uuid)
+
+				s.publishPlayerTransitions(previous, current)
+				previous = current
+
+				if s.checkIdle(current, &emptySince) {
+					break
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+			case <-time.After(interval):
+			}
+		}
+
+		metrics.ServerPlayers.Set(0, s.Uuid)
+	}()
+}
+
+// Stops querying the server for its players.
+func (s *Server) stopQueryPolling() {
+	s.queryPoller.mu.Lock()
+	defer s.queryPoller.mu.Unlock()
+
+	if s.queryPoller.cancel != nil {
+		s.queryPoller.cancel()
+		s.queryPoller.cancel = nil
+	}
+}
+
+// Emits an event when the server becomes empty or full. Nothing is emitted for the first
+// query after the server starts, since every server starts out empty.
+func (s *Server) publishPlayerTransitions(previous *PlayerCount, current *PlayerCount) {
+	if previous == nil {
+		return
+	}
+
+	b, _ := json.Marshal(current)
+
+	if current.Online == 0 && previous.Online > 0 {
+		s.Events().Publish(PlayersEmptyEvent, string(b))
+	}
+
+	if current.Max > 0 && current.Online >= current.Max && previous.Online < previous.Max {
+		s.Events().Publish(PlayersFullEvent, string(b))
+	}
+}
+
+// Queries the server using the protocol defined by the egg.
+func (s *Server) queryPlayers(q api.Query) (*PlayerCount, error) {
+	ip := s.Allocations.DefaultMapping.Ip
+	if ip == "" || ip == "0.0.0.0" {
+		ip = "127.0.0.1"
+	}
+
+	port := q.Port
+	if port == 0 {
+		port = s.Allocations.DefaultMapping.Port
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(port))
+
+	var pc *PlayerCount
+	var err error
+	switch q.Protocol {
+	case api.QueryMinecraft:
+		pc, err = queryMinecraft(addr)
+	case api.QuerySource:
+		pc, err = querySource(addr)
+	case api.QueryFiveM:
+		pc, err = queryFiveM(addr)
+	default:
+		return nil, errors.Errorf("query protocol \"%s\" is not supported", q.Protocol)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	pc.UpdatedAt = time.Now().UTC()
+
+	return pc, nil
+}
+
+// Queries a Minecraft server using the server list ping used by the game client.
+func queryMinecraft(addr string) (*PlayerCount, error) {
+	host, p, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(p)
+
+	conn, err := net.DialTimeout("tcp", addr, queryTimeout)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	// Handshake using an unknown protocol version followed by the next state of 1, which
+	// requests the status of the server.
+	handshake := appendVarInt(nil, -1)
+	handshake = append(handshake, minecraftString(host)...)
+	handshake = append(handshake, byte(port>>8), byte(port))
+	handshake = appendVarInt(handshake, 1)
+
+	if err := writeMinecraftPacket(conn, 0x00, handshake); err != nil {
+		return nil, err
+	}
+
+	if err := writeMinecraftPacket(conn, 0x00, nil); err != nil {
+		return nil, err
+	}
+
+	id, data, err := readMinecraftPacket(bufio.NewReader(conn), queryMaxMinecraftPacket)
+	if err != nil {
+		return nil, err
+	} else if id != 0x00 {
+		return nil, errors.Errorf("unexpected status response packet 0x%02x", id)
+	}
+
+	br := bytes.NewReader(data)
+	length, err := readVarInt(br)
+	if err != nil {
+		return nil, err
+	} else if length != br.Len() {
+		return nil, errors.New("malformed status response")
+	}
+
+	var status struct {
+		Players struct {
+			Online int `json:"online"`
+			Max    int `json:"max"`
+		} `json:"players"`
+	}
+
+	if err := json.Unmarshal(data[len(data)-length:], &status); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &PlayerCount{Online: status.Players.Online, Max: status.Players.Max}, nil
+}
+
+// Queries a Source engine server using the A2S_INFO query.
+func querySource(addr string) (*PlayerCount, error) {
+	conn, err := net.DialTimeout("udp", addr, queryTimeout)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(queryTimeout))
+
+	request := append([]byte{0xff, 0xff, 0xff, 0xff, 'T'}, "Source Engine Query\x00"...)
+	base := len(request)
+
+	b := make([]byte, 1400)
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := conn.Write(request); err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		n, err := conn.Read(b)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		if n < 5 || !bytes.Equal(b[:4], []byte{0xff, 0xff, 0xff, 0xff}) {
+			return nil, errors.New("malformed A2S_INFO response")
+		}
+
+		switch b[4] {
+		case 'A':
+			// Newer servers respond with a challenge that must be appended to the
+			// request before they will answer it.
+			if n < 9 {
+				return nil, errors.New("malformed A2S_INFO challenge")
+			}
+
+			request = append(request[:base], b[5:9]...)
+			continue
+		case 'I':
+			return parseSourceInfo(b[5:n])
+		default:
+			return nil, errors.Errorf("unexpected A2S_INFO response type 0x%02x", b[4])
+		}
+	}
+
+	return nil, errors.New("server did not answer the A2S_INFO challenge")
+}
+
+// Parses the body of an A2S_INFO response, which is made up of the protocol version,
+// the name, map, folder and game as null terminated strings, the app id, and then the
+// number of players and maximum players.
+func parseSourceInfo(b []byte) (*PlayerCount, error) {
+	r := bytes.NewReader(b)
+	if _, err := r.ReadByte(); err != nil {
+		return nil, errors.New("malformed A2S_INFO response")
+	}
+
+	var fields []string
+	for i := 0; i < 4; i++ {
+		var buf []byte
+		for {
+			c, err := r.ReadByte()
+			if err != nil {
+				return nil, errors.New("malformed A2S_INFO response")
+			}
+
+			if c == 0 {
+				break
+			}
+
+			buf = append(buf, c)
+		}
+
+		fields = append(fields, string(buf))
+	}
+
+	var id uint16
+	if err := binary.Read(r, binary.LittleEndian, &id); err != nil {
+		return nil, errors.New("malformed A2S_INFO response")
+	}
+
+	players, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("malformed A2S_INFO response")
+	}
+
+	max, err := r.ReadByte()
+	if err != nil {
+		return nil, errors.New("malformed A2S_INFO response")
+	}
+
+	return &PlayerCount{Online: int(players), Max: int(max), Map: fields[1]}, nil
+}
+
+// Queries a FiveM server using the dynamic information it serves over HTTP.
+func queryFiveM(addr string) (*PlayerCount, error) {
+	client := &http.Client{Timeout: queryTimeout}
+
+	res, err := client.Get("http://" + addr + "/dynamic.json")
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("unexpected status code %d", res.StatusCode)
+	}
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// The maximum number of clients is returned as a string, since it is read directly
+	// from the convars of the server.
+	var info struct {
+		Clients    int         `json:"clients"`
+		MaxClients json.Number `json:"sv_maxclients"`
+		Map        string      `json:"mapname"`
+	}
+
+	if err := json.Unmarshal(b, &info); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	max, _ := info.MaxClients.Int64()
+
+	return &PlayerCount{Online: info.Clients, Max: int(max), Map: info.Map}, nil
+}
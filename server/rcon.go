@@ -0,0 +1,137 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/rcon"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The amount of time to wait when connecting to, or waiting for a response from, the RCON
+// server of a server process.
+const rconTimeout = time.Second * 10
+
+// Determines if the egg for the server defines how to connect to its RCON server.
+func (s *Server) HasRcon() bool {
+	return s.processConfiguration != nil && s.processConfiguration.Rcon.PasswordVariable != ""
+}
+
+// Returns the address of the RCON server for the server process along with its password,
+// using the values of the environment variables defined by the egg.
+func (s *Server) rconCredentials() (string, string, error) {
+	r := s.processConfiguration.Rcon
+
+	env := make(map[string]string)
+	for _, v := range s.GetEnvironmentVariables() {
+		if parts := strings.SplitN(v, "=", 2); len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+
+	password := env[strings.ToUpper(r.PasswordVariable)]
+	if password == "" {
+		return "", "", errors.Errorf("rcon password variable \"%s\" is not set", r.PasswordVariable)
+	}
+
+	port := r.Port
+	if v, ok := env[strings.ToUpper(r.PortVariable)]; ok && r.PortVariable != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return "", "", errors.Errorf("rcon port variable \"%s\" is not a valid port", r.PortVariable)
+		}
+
+		port = p
+	}
+
+	if port <= 0 {
+		return "", "", errors.New("rcon port is not defined for the server")
+	}
+
+	// The port can be changed by users of the server, so never connect to an address on
+	// the node itself unless the server is using the host network, and then only to one of
+	// the ports allocated to it. Otherwise users could use RCON to reach other services
+	// listening on the node.
+	if s.Network.Mode == "host" {
+		if !s.hasAllocatedPort(port) {
+			return "", "", errors.Errorf("rcon port %d is not allocated to the server", port)
+		}
+
+		return net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), password, nil
+	}
+
+	d, ok := s.Environment.(*DockerEnvironment)
+	if !ok {
+		return "", "", errors.New("rcon is not supported by the environment of the server")
+	}
+
+	// The RCON port is usually not one of the allocations of the server, so connect to
+	// the container directly rather than through the published ports.
+	ip, err := d.containerIp()
+	if err != nil {
+		return "", "", errors.Wrap(err, "could not determine the address of the server container")
+	}
+
+	return net.JoinHostPort(ip, strconv.Itoa(port)), password, nil
+}
+
+// Determines if the port is one of the ports allocated to the server on any address.
+func (s *Server) hasAllocatedPort(port int) bool {
+	for _, ports := range s.Allocations.Mappings {
+		for _, p := range ports {
+			if p == port {
+				return true
+			}
+		}
+	}
+
+	for _, pair := range s.Allocations.Pairs {
+		if pair.Ipv4.Port == port || pair.Ipv6.Port == port {
+			return true
+		}
+	}
+
+	for _, r := range s.Allocations.Ranges {
+		if port >= r.Start && port <= r.End {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Executes the commands using the RCON server of the server process, returning the
+// response to each of them.
+func (s *Server) ExecuteRcon(commands []string) ([]string, error) {
+	if !s.HasRcon() {
+		return nil, errors.New("the egg for this server does not define an rcon connection")
+	}
+
+	if !IsRunningState(s.State) {
+		return nil, errors.New("cannot send rcon commands to a server that is not running")
+	}
+
+	addr, password, err := s.rconCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := rcon.Dial(addr, password, rconTimeout)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to rcon server")
+	}
+	defer c.Close()
+
+	out := make([]string, 0, len(commands))
+	for _, cmd := range commands {
+		res, err := c.Execute(cmd)
+		if err != nil {
+			return out, errors.Wrapf(err, "failed to execute rcon command \"%s\"", cmd)
+		}
+
+		out = append(out, res)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"net"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// The interval at which the readiness port of a running server is checked.
+const readinessPollInterval = time.Second * 2
+
+// Determines if the egg for the server defines how to detect that it is ready.
+func (s *Server) hasReadinessCheck() bool {
+	if s.processConfiguration == nil {
+		return false
+	}
+
+	r := s.processConfiguration.Startup.Readiness
+
+	return r.Pattern != "" || r.Port > 0
+}
+
+// Begins checking if a server that has just entered the running state is ready to accept
+// players. Servers without a readiness check remain in the running state.
+func (s *Server) startReadinessChecks() {
+	if !s.hasReadinessCheck() {
+		return
+	}
+
+	r := s.processConfiguration.Startup.Readiness
+	if r.Pattern != "" {
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			zap.S().Warnw("server readiness pattern is not a valid regular expression", zap.String("server", s.Uuid), zap.Error(err))
+		}
+
+		s.health.mu.Lock()
+		s.readinessPattern = re
+		s.health.mu.Unlock()
+	}
+
+	if r.Port == 0 {
+		return
+	}
+
+	ip := s.Allocations.DefaultMapping.Ip
+	if ip == "" || ip == "0.0.0.0" {
+		ip = "127.0.0.1"
+	}
+
+	addr := net.JoinHostPort(ip, strconv.Itoa(r.Port))
+
+	go func() {
+		defer supervisor.Recover("readiness check")
+
+		for s.State == ProcessRunningState {
+			if conn, err := net.DialTimeout("tcp", addr, readinessPollInterval); err == nil {
+				conn.Close()
+
+				s.markReady("port " + strconv.Itoa(r.Port) + " is accepting connections")
+				return
+			}
+
+			time.Sleep(readinessPollInterval)
+		}
+	}()
+}
+
+// Marks the server as ready if the line of console output matches the readiness pattern.
+func (s *Server) checkReadinessOutput(line string) {
+	if s.State != ProcessRunningState {
+		return
+	}
+
+	s.health.mu.Lock()
+	re := s.readinessPattern
+	s.health.mu.Unlock()
+
+	if re != nil && re.MatchString(line) {
+		s.markReady("console output matched readiness pattern")
+	}
+}
+
+// Moves a running server into the ready state.
+func (s *Server) markReady(reason string) {
+	if s.State != ProcessRunningState {
+		return
+	}
+
+	zap.S().Debugw("detected server as ready", zap.String("server", s.Uuid), zap.String("reason", reason))
+
+	s.SetState(ProcessReadyState)
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// The text that matches of a redaction rule are replaced with when the rule does not
+// define its own replacement.
+const defaultRedaction = "[REDACTED]"
+
+// Secrets shorter than this are not redacted automatically, since they would match too
+// much of the normal output of the server.
+const minimumRedactedSecret = 4
+
+type redactionRule struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+// The redaction rules for a server, compiled from the node configuration and the egg of
+// the server. The rules are compiled again whenever either of them changes.
+type redactions struct {
+	mu    sync.Mutex
+	rules []redactionRule
+	// The configuration the rules were compiled from.
+	cfg    *config.Configuration
+	pc     *api.ProcessConfiguration
+	secret string
+}
+
+// Applies the redaction rules for the server to a line of console output.
+func (s *Server) Redact(line string) string {
+	for _, r := range s.redactionRules() {
+		line = r.pattern.ReplaceAllString(line, r.replacement)
+	}
+
+	return line
+}
+
+// Returns the compiled redaction rules for the server, compiling them first if the node
+// configuration, egg or RCON password have changed since they were last compiled.
+func (s *Server) redactionRules() []redactionRule {
+	cfg := config.Get()
+	pc := s.processConfiguration
+	secret := s.rconPassword()
+
+	s.redactions.mu.Lock()
+	defer s.redactions.mu.Unlock()
+
+	if s.redactions.cfg == cfg && s.redactions.pc == pc && s.redactions.secret == secret {
+		return s.redactions.rules
+	}
+
+	var rules []redactionRule
+	add := func(pattern string, replacement string) {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			zap.S().Warnw("console redaction pattern is not a valid regular expression", zap.String("server", s.Uuid), zap.String("pattern", pattern), zap.Error(err))
+			return
+		}
+
+		if replacement == "" {
+			replacement = defaultRedaction
+		}
+
+		rules = append(rules, redactionRule{pattern: re, replacement: replacement})
+	}
+
+	// The RCON password is always hidden, since a number of games echo it to the console
+	// when they start.
+	if len(secret) >= minimumRedactedSecret {
+		add(regexp.QuoteMeta(secret), "")
+	}
+
+	for _, r := range cfg.System.ConsoleRedactions {
+		add(r.Pattern, r.Replacement)
+	}
+
+	if pc != nil {
+		for _, r := range pc.Redactions {
+			add(r.Pattern, r.Replacement)
+		}
+	}
+
+	s.redactions.rules = rules
+	s.redactions.cfg = cfg
+	s.redactions.pc = pc
+	s.redactions.secret = secret
+
+	return rules
+}
+
+// Returns the value of the RCON password variable for the server, or an empty string if
+// the server does not use RCON.
+func (s *Server) rconPassword() string {
+	if !s.HasRcon() {
+		return ""
+	}
+
+	v := s.processConfiguration.Rcon.PasswordVariable
+	for k, value := range s.EnvVars {
+		if strings.EqualFold(k, v) {
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,198 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+)
+
+// The resource limits that can be changed while a server is running.
+const (
+	MemoryResourceLimit    = "memory"
+	CpuResourceLimit       = "cpu"
+	CpuWeightResourceLimit = "cpu_weight"
+	IoWeightResourceLimit  = "io_weight"
+	PidsResourceLimit      = "pids"
+)
+
+// Changes to the resource limits of a server. Limits that are not set are left as they
+// are.
+type ResourceLimits struct {
+	MemoryLimit *int64  `json:"memory_limit"`
+	Swap        *int64  `json:"swap"`
+	CpuLimit    *int64  `json:"cpu_limit"`
+	CpuWeight   *uint64 `json:"cpu_weight"`
+	IoWeight    *uint16 `json:"io_weight"`
+	PidsLimit   *int64  `json:"pids_limit"`
+}
+
+// The result of changing the resource limits of a server.
+type ResourceLimitsResult struct {
+	// The limits that were changed on the running server, or every limit that was changed
+	// if the server is not running, since they are applied when it is started.
+	Applied []string `json:"applied"`
+	// The limits that were changed but can only be applied by restarting the server.
+	PendingRestart []string `json:"pending_restart"`
+}
+
+// Validates the changes, returning an error describing the first invalid limit.
+func (l *ResourceLimits) Validate() error {
+	switch {
+	case l.MemoryLimit != nil && *l.MemoryLimit < 0:
+		return errors.New("memory limit cannot be negative")
+	case l.Swap != nil && *l.Swap < -1:
+		return errors.New("swap must be -1 for unlimited swap, or 0 and above")
+	case l.CpuLimit != nil && *l.CpuLimit < 0:
+		return errors.New("cpu limit cannot be negative")
+	case l.CpuWeight != nil && (*l.CpuWeight < 1 || *l.CpuWeight > 10000):
+		return errors.New("cpu weight must be between 1 and 10000")
+	case l.IoWeight != nil && *l.IoWeight != 0 && (*l.IoWeight < 10 || *l.IoWeight > 1000):
+		return errors.New("io weight must be between 10 and 1000")
+	case l.PidsLimit != nil && *l.PidsLimit < 0:
+		return errors.New("pids limit cannot be negative")
+	}
+
+	return nil
+}
+
+// Applies the changes to the build settings, returning the limits that were changed.
+func (l *ResourceLimits) apply(b *BuildSettings) []string {
+	var changed []string
+
+	if (l.MemoryLimit != nil && *l.MemoryLimit != b.MemoryLimit) || (l.Swap != nil && *l.Swap != b.Swap) {
+		changed = append(changed, MemoryResourceLimit)
+	}
+
+	if l.MemoryLimit != nil {
+		b.MemoryLimit = *l.MemoryLimit
+	}
+
+	if l.Swap != nil {
+		b.Swap = *l.Swap
+	}
+
+	if l.CpuLimit != nil && *l.CpuLimit != b.CpuLimit {
+		b.CpuLimit = *l.CpuLimit
+		changed = append(changed, CpuResourceLimit)
+	}
+
+	if l.CpuWeight != nil && *l.CpuWeight != b.CpuWeight {
+		b.CpuWeight = *l.CpuWeight
+		changed = append(changed, CpuWeightResourceLimit)
+	}
+
+	if l.IoWeight != nil && *l.IoWeight != b.IoWeight {
+		b.IoWeight = *l.IoWeight
+		changed = append(changed, IoWeightResourceLimit)
+	}
+
+	if l.PidsLimit != nil && *l.PidsLimit != b.PidsLimit {
+		b.PidsLimit = *l.PidsLimit
+		changed = append(changed, PidsResourceLimit)
+	}
+
+	return changed
+}
+
+// Changes the resource limits of the server, applying them to the server process if it
+// is running. Limits that cannot be changed while the server is running are flagged as
+// pending until the server is next started.
+//
+// The limits are saved to the local overrides of the server, so that they are kept when
+// the Panel next sends its configuration. They remain in place until they are removed
+// from the override file, so the Panel should be updated to match them as well.
+func (s *Server) UpdateResourceLimits(l ResourceLimits) (*ResourceLimitsResult, error) {
+	if err := l.Validate(); err != nil {
+		return nil, err
+	}
+
+	previous := s.Build
+	changed := l.apply(&s.Build)
+
+	res := &ResourceLimitsResult{Applied: []string{}, PendingRestart: []string{}}
+	if len(changed) == 0 {
+		return res, nil
+	}
+
+	// A running server taking more resources must still fit within the quota of its group
+	// and the overcommit limits of the node.
+	if s.claimsGroupQuota() {
+		if err := s.CheckGroupQuota(); err != nil {
+			s.Build = previous
+
+			return nil, err
+		}
+	}
+
+	pending, err := s.Environment.UpdateResources(changed)
+	if err != nil {
+		s.Build = previous
+
+		return nil, err
+	}
+
+	for _, c := range changed {
+		if containsString(pending, c) {
+			res.PendingRestart = append(res.PendingRestart, c)
+
+			if !containsString(s.PendingRestart, c) {
+				s.PendingRestart = append(s.PendingRestart, c)
+			}
+		} else {
+			res.Applied = append(res.Applied, c)
+		}
+	}
+
+	o, err := s.GetOverrides()
+	if err != nil {
+		return nil, err
+	} else if o == nil {
+		o = new(Overrides)
+	}
+
+	l.override(o)
+	if err := s.SaveOverrides(o); err != nil {
+		return nil, err
+	}
+
+	if _, err := s.WriteConfigurationToDisk(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return res, nil
+}
+
+// Sets the limits that are being changed in the overrides for a server.
+func (l *ResourceLimits) override(o *Overrides) {
+	if l.MemoryLimit != nil {
+		o.Build.MemoryLimit = l.MemoryLimit
+	}
+
+	if l.Swap != nil {
+		o.Build.Swap = l.Swap
+	}
+
+	if l.CpuLimit != nil {
+		o.Build.CpuLimit = l.CpuLimit
+	}
+
+	if l.CpuWeight != nil {
+		o.Build.CpuWeight = l.CpuWeight
+	}
+
+	if l.IoWeight != nil {
+		o.Build.IoWeight = l.IoWeight
+	}
+
+	if l.PidsLimit != nil {
+		o.Build.PidsLimit = l.PidsLimit
+	}
+}
+
+func containsString(s []string, v string) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+
+	return false
+}
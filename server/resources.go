@@ -2,6 +2,8 @@ package server
 
 import (
 	"github.com/docker/docker/api/types"
+	"github.com/pterodactyl/wings/cgroups"
+	"github.com/pterodactyl/wings/metrics"
 	"math"
 )
 
@@ -18,6 +20,24 @@ type ResourceUsage struct {
 	// The absolute CPU usage is the amount of CPU used in relation to the entire system and
 	// does not take into account any limits on the server process itself.
 	CpuAbsolute float64 `json:"cpu_absolute"`
+	// The CPU throttling applied to the server by its CPU limit. A server that is
+	// frequently throttled needs a higher limit, rather than the host being overloaded.
+	CpuThrottling struct {
+		// The percentage of scheduler periods during the last reading in which the
+		// server was throttled.
+		Percent float64 `json:"percent"`
+		// The total number of periods throttled and time spent throttled, in seconds,
+		// since the server started.
+		ThrottledPeriods uint64  `json:"throttled_periods"`
+		ThrottledTime    float64 `json:"throttled_time"`
+	} `json:"cpu_throttling"`
+	// The percentage of CPU time stolen from the host by the hypervisor. This is the
+	// same for every server on the node, and a high value indicates that the host is
+	// competing with other virtual machines for CPU time.
+	CpuSteal float64 `json:"cpu_steal"`
+	// The health of the server process, based on the health check defined for it. This
+	// is empty if the server is not running or does not have a health check.
+	Health string `json:"health"`
 	// The current disk space being used by the server. This is cached to prevent slow lookup
 	// issues on frequent refreshes.
 	Disk int64 `json:"disk_bytes"`
@@ -25,7 +45,29 @@ type ResourceUsage struct {
 	Network struct {
 		RxBytes uint64 `json:"rx_bytes"`
 		TxBytes uint64 `json:"tx_bytes"`
+		// The current throughput in bytes per second.
+		RxRate float64 `json:"rx_rate"`
+		TxRate float64 `json:"tx_rate"`
 	} `json:"network"`
+	// The pressure stall information for the server, only available on hosts using
+	// cgroup v2 with PSI enabled in the kernel.
+	Pressure *cgroups.PressureStats `json:"pressure,omitempty"`
+	// The players connected to the server, only available if the egg defines a query
+	// protocol and the server has answered a query.
+	Players *PlayerCount `json:"players,omitempty"`
+}
+
+// Returns the memory used by the container, excluding the inactive page cache which can
+// be reclaimed by the kernel at any time. This matches the value reported by the Docker
+// CLI. The statistic is named differently on cgroup v1 and v2 hosts.
+func memoryUsage(s *types.MemoryStats) uint64 {
+	for _, k := range []string{"total_inactive_file", "inactive_file"} {
+		if v, ok := s.Stats[k]; ok && v < s.Usage {
+			return s.Usage - v
+		}
+	}
+
+	return s.Usage
 }
 
 // Calculates the absolute CPU usage used by the server process on the system, not constrained
@@ -51,4 +93,39 @@ func (ru *ResourceUsage) CalculateAbsoluteCpu(pStats *types.CPUStats, stats *typ
 	}
 
 	return math.Round(percent*1000) / 1000
-}
\ No newline at end of file
+}
+// Updates the CPU throttling statistics using the current and previous CPU readings.
+func (ru *ResourceUsage) updateThrottling(pStats *types.CPUStats, stats *types.CPUStats) {
+	cur, prev := stats.ThrottlingData, pStats.ThrottlingData
+
+	ru.CpuThrottling.Percent = 0
+	if cur.Periods > prev.Periods && cur.ThrottledPeriods >= prev.ThrottledPeriods {
+		p := float64(cur.ThrottledPeriods-prev.ThrottledPeriods) / float64(cur.Periods-prev.Periods) * 100
+		ru.CpuThrottling.Percent = math.Round(p*1000) / 1000
+	}
+
+	ru.CpuThrottling.ThrottledPeriods = cur.ThrottledPeriods
+	ru.CpuThrottling.ThrottledTime = math.Round(float64(cur.ThrottledTime)/1e6) / 1000
+}
+
+// Returns the rate per second of a counter between two readings. If the counter has been
+// reset since the previous reading 0 is returned.
+func rate(current uint64, previous uint64, elapsed float64) float64 {
+	if current < previous || elapsed <= 0 {
+		return 0
+	}
+
+	return math.Round(float64(current-previous)/elapsed*100) / 100
+}
+
+// Publishes the current resource usage values to the metrics subsystem.
+func (ru *ResourceUsage) publishMetrics(uuid string) {
+	metrics.ServerCpuAbsolute.Set(ru.CpuAbsolute, uuid)
+	metrics.ServerCpuThrottledPercent.Set(ru.CpuThrottling.Percent, uuid)
+	metrics.ServerCpuThrottledSeconds.Set(ru.CpuThrottling.ThrottledTime, uuid)
+	metrics.ServerMemory.Set(float64(ru.Memory), uuid)
+	metrics.ServerMemoryLimit.Set(float64(ru.MemoryLimit), uuid)
+	metrics.ServerNetworkRx.Set(float64(ru.Network.RxBytes), uuid)
+	metrics.ServerNetworkTx.Set(float64(ru.Network.TxBytes), uuid)
+	metrics.ServerDisk.Set(float64(ru.Disk), uuid)
+}
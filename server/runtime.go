@@ -0,0 +1,184 @@
+package server
+
+import (
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"strconv"
+	"strings"
+)
+
+// The container runtimes that can be used to run server processes. Both are accessed
+// using the Docker API, Podman through its Docker compatible socket.
+const (
+	DockerRuntime = "docker"
+	PodmanRuntime = "podman"
+)
+
+// The socket used by Docker for its API when running as root.
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// The socket used by Podman for its Docker compatible API when running as root.
+const defaultPodmanSocket = "/run/podman/podman.sock"
+
+// Describes the behaviour that differs between the container runtimes that servers can be
+// run with. Each runtime is accessed using the Docker API.
+type ContainerRuntime interface {
+	// Returns the name of the runtime, as it is set in the configuration.
+	Name() string
+
+	// Returns the socket the runtime serves its API on when running as root.
+	DefaultSocket() string
+
+	// Returns the logging configuration for installation containers.
+	InstallerLogConfig() container.LogConfig
+
+	// Determines if the logs of server containers must be read through the API rather
+	// than from the log file written by the runtime.
+	ReadsLogsFromApi() bool
+}
+
+type dockerRuntime struct{}
+
+func (dockerRuntime) Name() string {
+	return DockerRuntime
+}
+
+func (dockerRuntime) DefaultSocket() string {
+	return defaultDockerSocket
+}
+
+func (dockerRuntime) InstallerLogConfig() container.LogConfig {
+	return container.LogConfig{
+		Type: "local",
+		Config: map[string]string{
+			"max-size": "5m",
+			"max-file": "1",
+			"compress": "false",
+		},
+	}
+}
+
+func (dockerRuntime) ReadsLogsFromApi() bool {
+	return false
+}
+
+type podmanRuntime struct{}
+
+func (podmanRuntime) Name() string {
+	return PodmanRuntime
+}
+
+func (podmanRuntime) DefaultSocket() string {
+	return defaultPodmanSocket
+}
+
+// Podman does not support the "local" logging driver so the json-file driver is used
+// instead.
+func (podmanRuntime) InstallerLogConfig() container.LogConfig {
+	return container.LogConfig{
+		Type:   "json-file",
+		Config: map[string]string{"max-size": "5m", "max-file": "1"},
+	}
+}
+
+// Podman does not write logs in the same format as Docker, so they are read through the
+// API rather than from the log file directly.
+func (podmanRuntime) ReadsLogsFromApi() bool {
+	return true
+}
+
+// The container runtimes that can be used, keyed by their name.
+var runtimes = map[string]ContainerRuntime{
+	DockerRuntime: dockerRuntime{},
+	PodmanRuntime: podmanRuntime{},
+}
+
+// Returns the container runtime with the given name, or an error if it is not supported.
+func LookupRuntime(name string) (ContainerRuntime, error) {
+	if rt, ok := runtimes[name]; ok {
+		return rt, nil
+	}
+
+	return nil, errors.Errorf("unknown container runtime \"%s\"", name)
+}
+
+// Returns the container runtime configured for the node. Docker is used if the configured
+// runtime is not supported, the error is returned when environments are created.
+func Runtime() ContainerRuntime {
+	if rt, err := LookupRuntime(RuntimeName()); err == nil {
+		return rt
+	}
+
+	return dockerRuntime{}
+}
+
+// Returns the name of the container runtime configured for the node.
+func RuntimeName() string {
+	if c := config.Get(); c != nil && c.Docker.Runtime != "" {
+		return c.Docker.Runtime
+	}
+
+	return DockerRuntime
+}
+
+// Returns a new client for the configured container runtime. Podman exposes a Docker
+// compatible API so the same client is used for both, only the socket differs. The
+// DOCKER_HOST environment variable takes priority over the configured socket.
+func NewRuntimeClient() (*client.Client, error) {
+	var opts []func(*client.Client) error
+
+	if host := runtimeHost(); host != "" {
+		opts = append(opts, client.WithHost(host))
+	}
+
+	cli, err := client.NewClientWithOpts(append(opts, client.FromEnv)...)
+
+	return cli, errors.WithStack(err)
+}
+
+// Returns the host address of the runtime API using the configured socket.
+func runtimeHost() string {
+	c := config.Get()
+	if c == nil {
+		return ""
+	}
+
+	rt := Runtime()
+
+	socket := c.Docker.Socket
+	if c.System.Rootless.Enabled && (socket == "" || socket == defaultDockerSocket) {
+		socket = config.RootlessSocket(rt.Name())
+	} else if socket == "" || socket == defaultDockerSocket {
+		socket = rt.DefaultSocket()
+	}
+
+	if socket == "" || strings.Contains(socket, "://") {
+		return socket
+	}
+
+	return "unix://" + socket
+}
+
+// Returns the user that server processes run as inside of their containers. When running
+// rootless the container user is mapped back to the daemon user by the runtime, so the
+// process runs as root inside of the container rather than as the daemon user.
+func containerUser() string {
+	c := config.Get()
+	if c.System.Rootless.Enabled {
+		return c.System.Rootless.ContainerUser
+	}
+
+	return strconv.Itoa(c.System.User.Uid)
+}
+
+// Creates the environment for a server using the runtime configured for the node. Every
+// runtime is accessed using the Docker API, so they all use the Docker environment.
+func NewEnvironment(s *Server) error {
+	if _, err := LookupRuntime(RuntimeName()); err != nil {
+		return err
+	}
+
+	return NewDockerEnvironment(s)
+}
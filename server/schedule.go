@@ -0,0 +1,430 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"os"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// The directory containing the scheduled tasks defined for servers.
+const schedulesDirectory = "data/schedules"
+
+// The actions that can be performed by a scheduled task.
+const (
+	ScheduleActionStart   = "start"
+	ScheduleActionStop    = "stop"
+	ScheduleActionRestart = "restart"
+	ScheduleActionKill    = "kill"
+	ScheduleActionCommand = "command"
+	ScheduleActionBackup  = "backup"
+)
+
+// The amount of time a server is given to stop during a scheduled restart before the
+// process is killed.
+const scheduleStopTimeout = time.Minute * 2
+
+var scheduleIdRegex = regexp.MustCompile(`^[\w-]{1,64}$`)
+
+var schedulesLock sync.Mutex
+
+// Tracks the scheduled tasks that are currently running so that a slow task, such as a
+// backup of a large server, is not started again before the previous run has finished.
+var runningSchedules sync.Map
+
+// Returned when a scheduled task is run while the previous run of it has not finished.
+var ErrScheduleRunning = errors.New("the previous run of this schedule has not finished")
+
+// A task that is run for a server by the node according to a cron expression, without
+// needing the Panel to be available.
+type Schedule struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+
+	// A five field cron expression, evaluated in the local time of the node.
+	Cron string `json:"cron"`
+
+	// The action to perform, one of "start", "stop", "restart", "kill", "command" or
+	// "backup".
+	Action string `json:"action"`
+
+	// The console command to send for "command" actions.
+	Payload string `json:"payload,omitempty"`
+
+	// The number of local backups to keep for "backup" actions, zero keeps all of them.
+	Retain int `json:"retain,omitempty"`
+
+	Enabled bool `json:"enabled"`
+
+	// If set the task is skipped while the server is offline.
+	OnlyWhenOnline bool `json:"only_when_online"`
+
+	// The result of the last time the task was run. This is maintained by the node and
+	// ignored when schedules are sent by the Panel.
+	LastRun *ScheduleRun `json:"last_run,omitempty"`
+
+	// The next time the task will run, calculated when the schedules are returned.
+	NextRun *time.Time `json:"next_run,omitempty"`
+}
+
+// The result of running a scheduled task.
+type ScheduleRun struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Successful bool      `json:"successful"`
+	Skipped    bool      `json:"skipped"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Returns the path to the schedules file for the server.
+func (s *Server) schedulesPath() string {
+	return path.Join(schedulesDirectory, s.Uuid+".json")
+}
+
+// Returns the scheduled tasks defined for the server, along with the next time each of
+// the enabled tasks will run.
+func (s *Server) GetSchedules() ([]Schedule, error) {
+	schedules, err := s.readSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, sc := range schedules {
+		if !sc.Enabled {
+			continue
+		}
+
+		if c, err := ParseCron(sc.Cron); err == nil {
+			if next := c.Next(now); !next.IsZero() {
+				schedules[i].NextRun = &next
+			}
+		}
+	}
+
+	return schedules, nil
+}
+
+// Returns a single scheduled task for the server, or nil if it does not exist.
+func (s *Server) GetSchedule(id string) (*Schedule, error) {
+	schedules, err := s.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sc := range schedules {
+		if sc.Id == id {
+			return &sc, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *Server) readSchedules() ([]Schedule, error) {
+	b, err := ioutil.ReadFile(s.schedulesPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []Schedule{}, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	var schedules []Schedule
+	if err := json.Unmarshal(b, &schedules); err != nil {
+		return nil, errors.Wrap(err, "failed to parse server schedules")
+	}
+
+	return schedules, nil
+}
+
+func (s *Server) saveSchedules(schedules []Schedule) error {
+	if err := os.MkdirAll(schedulesDirectory, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	for i := range schedules {
+		schedules[i].NextRun = nil
+	}
+
+	b, err := json.MarshalIndent(schedules, "", "    ")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(ioutil.WriteFile(s.schedulesPath(), b, 0600))
+}
+
+// Checks that the scheduled task can be run by the node.
+func (sc *Schedule) Validate() error {
+	if !scheduleIdRegex.MatchString(sc.Id) {
+		return errors.New("schedule id must only contain letters, numbers, dashes and underscores")
+	}
+
+	if _, err := ParseCron(sc.Cron); err != nil {
+		return err
+	}
+
+	switch sc.Action {
+	case ScheduleActionStart, ScheduleActionStop, ScheduleActionRestart, ScheduleActionKill, ScheduleActionBackup:
+	case ScheduleActionCommand:
+		if sc.Payload == "" {
+			return errors.Errorf("schedule \"%s\" must define a command to send", sc.Id)
+		}
+	default:
+		return errors.Errorf("schedule \"%s\" has an unknown action \"%s\"", sc.Id, sc.Action)
+	}
+
+	if sc.Retain < 0 {
+		return errors.Errorf("schedule \"%s\" cannot retain a negative number of backups", sc.Id)
+	}
+
+	return nil
+}
+
+// Replaces all of the scheduled tasks for the server. The result of the last run is kept
+// for any tasks that already existed.
+func (s *Server) SyncSchedules(schedules []Schedule) error {
+	seen := make(map[string]bool)
+	for _, sc := range schedules {
+		if err := sc.Validate(); err != nil {
+			return err
+		}
+
+		if seen[sc.Id] {
+			return errors.Errorf("schedule \"%s\" is defined more than once", sc.Id)
+		}
+		seen[sc.Id] = true
+	}
+
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	existing, err := s.readSchedules()
+	if err != nil {
+		return err
+	}
+
+	runs := make(map[string]*ScheduleRun)
+	for _, sc := range existing {
+		runs[sc.Id] = sc.LastRun
+	}
+
+	for i := range schedules {
+		schedules[i].LastRun = runs[schedules[i].Id]
+	}
+
+	return s.saveSchedules(schedules)
+}
+
+// Creates a scheduled task for the server, or replaces the existing task with the same
+// id.
+func (s *Server) SaveSchedule(sc Schedule) error {
+	if err := sc.Validate(); err != nil {
+		return err
+	}
+
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	schedules, err := s.readSchedules()
+	if err != nil {
+		return err
+	}
+
+	sc.LastRun = nil
+	for i, v := range schedules {
+		if v.Id == sc.Id {
+			sc.LastRun = v.LastRun
+			schedules[i] = sc
+
+			return s.saveSchedules(schedules)
+		}
+	}
+
+	return s.saveSchedules(append(schedules, sc))
+}
+
+// Removes a scheduled task from the server, returning false if it did not exist.
+func (s *Server) DeleteSchedule(id string) (bool, error) {
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	schedules, err := s.readSchedules()
+	if err != nil {
+		return false, err
+	}
+
+	for i, sc := range schedules {
+		if sc.Id == id {
+			return true, s.saveSchedules(append(schedules[:i], schedules[i+1:]...))
+		}
+	}
+
+	return false, nil
+}
+
+// Removes all of the scheduled tasks for the server.
+func (s *Server) RemoveSchedules() error {
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	if err := os.Remove(s.schedulesPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Stores the result of running a scheduled task, if the task still exists.
+func (s *Server) recordScheduleRun(id string, run *ScheduleRun) error {
+	schedulesLock.Lock()
+	defer schedulesLock.Unlock()
+
+	schedules, err := s.readSchedules()
+	if err != nil {
+		return err
+	}
+
+	for i, sc := range schedules {
+		if sc.Id == id {
+			schedules[i].LastRun = run
+
+			return s.saveSchedules(schedules)
+		}
+	}
+
+	return nil
+}
+
+// Starts the background routine that runs the scheduled tasks for all of the servers on
+// the node. Tasks are checked at the start of every minute.
+func StartScheduler() {
+	go supervisor.Supervise("scheduler", func() error {
+		for {
+			now := time.Now()
+			next := now.Truncate(time.Minute).Add(time.Minute)
+
+			time.Sleep(next.Sub(now))
+
+			for _, s := range GetServers().All() {
+				s.runSchedules(next)
+			}
+		}
+	})
+}
+
+// Starts any of the enabled scheduled tasks for the server that are due at the given time.
+func (s *Server) runSchedules(t time.Time) {
+	schedulesLock.Lock()
+	schedules, err := s.readSchedules()
+	schedulesLock.Unlock()
+
+	if err != nil {
+		zap.S().Errorw("failed to load server schedules", zap.String("server", s.Uuid), zap.Error(err))
+		return
+	}
+
+	for _, sc := range schedules {
+		if !sc.Enabled {
+			continue
+		}
+
+		c, err := ParseCron(sc.Cron)
+		if err != nil || !c.Matches(t) {
+			continue
+		}
+
+		go func(sc Schedule) {
+			defer supervisor.Recover("scheduler")
+
+			if _, err := s.RunSchedule(sc); err == ErrScheduleRunning {
+				zap.S().Warnw("skipping scheduled task, previous run has not finished", zap.String("server", s.Uuid), zap.String("schedule", sc.Id))
+			}
+		}(sc)
+	}
+}
+
+// Runs a scheduled task for the server and records the result. ErrScheduleRunning is
+// returned without running the task if the previous run of it has not finished yet,
+// whether it was started by the scheduler or requested through the API.
+func (s *Server) RunSchedule(sc Schedule) (*ScheduleRun, error) {
+	key := s.Uuid + ":" + sc.Id
+	if _, running := runningSchedules.LoadOrStore(key, true); running {
+		return nil, ErrScheduleRunning
+	}
+	defer runningSchedules.Delete(key)
+
+	run := &ScheduleRun{StartedAt: time.Now().UTC()}
+
+	if sc.OnlyWhenOnline && s.State == ProcessOfflineState {
+		run.Skipped = true
+	} else {
+		zap.S().Infow("running scheduled task for server", zap.String("server", s.Uuid), zap.String("schedule", sc.Id), zap.String("action", sc.Action))
+
+		if err := s.executeSchedule(sc); err != nil {
+			zap.S().Warnw("scheduled task for server failed", zap.String("server", s.Uuid), zap.String("schedule", sc.Id), zap.Error(err))
+			run.Error = err.Error()
+		} else {
+			run.Successful = true
+		}
+	}
+
+	run.FinishedAt = time.Now().UTC()
+
+	if err := s.recordScheduleRun(sc.Id, run); err != nil {
+		zap.S().Errorw("failed to record result of scheduled task", zap.String("server", s.Uuid), zap.String("schedule", sc.Id), zap.Error(err))
+	}
+
+	return run, nil
+}
+
+func (s *Server) executeSchedule(sc Schedule) error {
+	name := sc.Name
+	if name == "" {
+		name = sc.Id
+	}
+
+	if sc.Action != ScheduleActionCommand {
+		s.PublishConsoleOutputFromDaemon(fmt.Sprintf("Running scheduled task \"%s\"...", name))
+	}
+
+	switch sc.Action {
+	case ScheduleActionStart:
+		return s.Environment.Start()
+	case ScheduleActionStop:
+		s.SetStopReason(StopReasonSchedule, fmt.Sprintf("stopped by the \"%s\" schedule", name))
+		return s.Environment.Stop()
+	case ScheduleActionKill:
+		s.SetStopReason(StopReasonSchedule, fmt.Sprintf("killed by the \"%s\" schedule", name))
+		return s.Environment.Terminate(os.Kill)
+	case ScheduleActionRestart:
+		if s.State == ProcessOfflineState {
+			return s.Environment.Start()
+		}
+
+		return s.gracefulRestart(scheduleStopTimeout, fmt.Sprintf("restarted by the \"%s\" schedule", name))
+	case ScheduleActionCommand:
+		if !IsRunningState(s.State) {
+			return errors.New("server is not running")
+		}
+
+		s.RecordCommand("schedule:"+sc.Id, sc.Payload)
+
+		return s.Environment.SendCommand(sc.Payload)
+	case ScheduleActionBackup:
+		_, err := s.CreateLocalBackup("schedule:"+sc.Id, sc.Retain)
+
+		return err
+	}
+
+	return errors.Errorf("unknown schedule action \"%s\"", sc.Action)
+}
@@ -0,0 +1,222 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A parsed cron expression in the standard five field format of minute, hour, day of the
+// month, month and day of the week.
+type CronExpression struct {
+	minute uint64
+	hour   uint64
+	dom    uint64
+	month  uint64
+	dow    uint64
+	anyDom bool
+	anyDow bool
+}
+
+// The bounds of each field in a cron expression.
+var cronFields = []struct {
+	name  string
+	min   int
+	max   int
+	names []string
+}{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12, names: []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{name: "day of week", min: 0, max: 7, names: []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// Shorthand expressions that are commonly used in place of the full five fields.
+var cronAliases = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// Parses a cron expression. Each field supports "*", single values, ranges such as "1-5",
+// steps such as "*/15" or "0-30/5", and comma separated lists of any of those. Months and
+// days of the week may also be given using their three letter names.
+func ParseCron(expr string) (*CronExpression, error) {
+	expr = strings.TrimSpace(expr)
+	if alias, ok := cronAliases[strings.ToLower(expr)]; ok {
+		expr = alias
+	}
+
+	parts := strings.Fields(expr)
+	if len(parts) != len(cronFields) {
+		return nil, errors.Errorf("cron expression \"%s\" must have %d fields", expr, len(cronFields))
+	}
+
+	var bits [5]uint64
+	for i, p := range parts {
+		b, err := parseCronField(p, i)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid cron expression \"%s\"", expr)
+		}
+
+		bits[i] = b
+	}
+
+	// Sunday may be written as either 0 or 7.
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &CronExpression{
+		minute: bits[0],
+		hour:   bits[1],
+		dom:    bits[2],
+		month:  bits[3],
+		dow:    bits[4],
+		anyDom: parts[2] == "*",
+		anyDow: parts[4] == "*",
+	}, nil
+}
+
+// Parses a single field of a cron expression into a bitmask of the values it matches.
+func parseCronField(field string, index int) (uint64, error) {
+	f := cronFields[index]
+
+	var bits uint64
+	for _, item := range strings.Split(field, ",") {
+		rng, step := item, 1
+		if i := strings.Index(item, "/"); i >= 0 {
+			s, err := strconv.Atoi(item[i+1:])
+			if err != nil || s <= 0 {
+				return 0, errors.Errorf("invalid step \"%s\" in %s field", item[i+1:], f.name)
+			}
+
+			rng, step = item[:i], s
+		}
+
+		start, end := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+
+			var err error
+			if start, err = cronValue(bounds[0], index); err != nil {
+				return 0, err
+			}
+
+			end = start
+			if len(bounds) == 2 {
+				if end, err = cronValue(bounds[1], index); err != nil {
+					return 0, err
+				}
+			} else if step > 1 {
+				// A single value with a step, such as "5/10", runs from that value until
+				// the end of the field.
+				end = f.max
+			}
+
+			if end < start {
+				return 0, errors.Errorf("invalid range \"%s\" in %s field", rng, f.name)
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+
+	return bits, nil
+}
+
+// Parses a single value within a cron field, checking that it is within the bounds of
+// the field.
+func cronValue(v string, index int) (int, error) {
+	f := cronFields[index]
+
+	for i, n := range f.names {
+		if strings.ToLower(v) == n {
+			return i + f.min, nil
+		}
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil || i < f.min || i > f.max {
+		return 0, errors.Errorf("invalid value \"%s\" in %s field", v, f.name)
+	}
+
+	return i, nil
+}
+
+// Determines if the expression matches the given time, to the minute.
+func (c *CronExpression) Matches(t time.Time) bool {
+	if c.minute&(1<<uint(t.Minute())) == 0 || c.hour&(1<<uint(t.Hour())) == 0 || c.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+
+	// As with cron itself, if both the day of the month and day of the week are restricted
+	// the expression matches when either of them does.
+	switch {
+	case c.anyDom && c.anyDow:
+		return true
+	case c.anyDom:
+		return dow
+	case c.anyDow:
+		return dom
+	}
+
+	return dom || dow
+}
+
+// Returns the next time after the one given that the expression matches. If there is no
+// matching time within the next five years, such as for the 31st of February, a zero
+// time is returned.
+func (c *CronExpression) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		// Skip whole days and hours that can never match rather than checking every
+		// minute within them.
+		if !c.Matches(time.Date(t.Year(), t.Month(), t.Day(), firstBit(c.hour), firstBit(c.minute), 0, 0, t.Location())) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+
+		if c.Matches(t) {
+			return t
+		}
+
+		t = t.Add(time.Minute)
+	}
+
+	return time.Time{}
+}
+
+// Returns the lowest value set in the bitmask.
+func firstBit(bits uint64) int {
+	for i := 0; i < 64; i++ {
+		if bits&(1<<uint(i)) != 0 {
+			return i
+		}
+	}
+
+	return 0
+}
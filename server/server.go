@@ -6,13 +6,17 @@ import (
 	"github.com/patrickmn/go-cache"
 	"github.com/pkg/errors"
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/cgroups"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/hooks"
+	"github.com/pterodactyl/wings/metrics"
 	"github.com/remeh/sizedwaitgroup"
 	"go.uber.org/zap"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 	"sync"
 	"time"
@@ -38,6 +42,28 @@ type Server struct {
 	// The power state of the server.
 	State string `default:"offline" json:"state"`
 
+	// Set when the server has crashed too many times and is no longer being restarted
+	// automatically. This is cleared the next time the server is started.
+	CrashLooping bool `json:"crash_looping" yaml:"-"`
+
+	// Set when the server ran out of memory and its OOM policy marked it as failed. This
+	// is cleared the next time the server is started.
+	OomFailed bool `json:"oom_failed" yaml:"-"`
+
+	// Details about the last time the server process was killed for running out of
+	// memory, if it has been since the daemon booted. This is only changed while holding
+	// the OOM lock, see lastOom and updateLastOom.
+	LastOom *OomKill `json:"last_oom" yaml:"-"`
+	oomLock sync.Mutex
+
+	// Details about why the server process last stopped. This is kept when the daemon is
+	// restarted so that the reason for a server being offline is never lost.
+	LastStopReason *StopReason `json:"last_stop_reason" yaml:"last_stop_reason"`
+
+	// The resource limits that were changed while the server was running but could not be
+	// applied without restarting it. This is cleared the next time the server is started.
+	PendingRestart []string `json:"pending_restart" yaml:"pending_restart"`
+
 	// The command that should be used when booting up the server instance.
 	Invocation string `json:"invocation"`
 
@@ -45,16 +71,61 @@ type Server struct {
 	// server process.
 	EnvVars map[string]string `json:"environment" yaml:"environment"`
 
-	CrashDetection CrashDetection `json:"crash_detection" yaml:"crash_detection"`
-	Build          BuildSettings  `json:"build"`
-	Allocations    Allocations    `json:"allocations"`
-	Environment    Environment    `json:"-" yaml:"-"`
-	Filesystem     Filesystem     `json:"-" yaml:"-"`
-	Resources      ResourceUsage  `json:"resources" yaml:"-"`
+	// Named sequences of console commands that can be invoked from the console.
+	Macros map[string]Macro `json:"macros" yaml:"macros"`
+
+	// The storage pool that the server data is stored within. If empty the data is stored
+	// in the data directory of the node.
+	StoragePool string `json:"storage_pool" yaml:"storage_pool"`
+
+	// The number of minutes a running server can have no players connected before it
+	// is stopped automatically. Zero disables idle shutdowns, which also require the egg
+	// to define a query protocol to count the players.
+	IdleTimeout int `json:"idle_timeout" yaml:"idle_timeout"`
+
+	// Determines if deleted files are moved to the trash of the server so that they can
+	// be restored. If not set the node default is used.
+	Trash *bool `json:"trash,omitempty" yaml:"trash,omitempty"`
+
+	// The customer or label the server belongs to. Servers with the same owner share any
+	// group quota defined for it on the node.
+	Owner string `json:"owner" yaml:"owner"`
+
+	CrashDetection CrashDetection  `json:"crash_detection" yaml:"crash_detection"`
+	OomPolicy      OomPolicy       `json:"oom_policy" yaml:"oom_policy"`
+	Build          BuildSettings   `json:"build"`
+	Allocations    Allocations     `json:"allocations"`
+	Network        NetworkSettings `json:"network" yaml:"network"`
+	Mounts         []Mount         `json:"mounts" yaml:"mounts"`
+	Environment    Environment     `json:"-" yaml:"-"`
+	Filesystem     Filesystem      `json:"-" yaml:"-"`
+	Resources      ResourceUsage   `json:"resources" yaml:"-"`
 
 	Container struct {
 		// Defines the Docker image that will be used for this server
 		Image string `json:"image,omitempty"`
+		// The digest the image is pinned to, such as "sha256:...". When set the server
+		// always runs this exact image, regardless of what the tag points to.
+		Digest string `json:"digest,omitempty" yaml:"digest,omitempty"`
+		// Controls when the image is pulled, either "always", "if-not-present" or "never".
+		// If empty the node default is used.
+		PullPolicy string `json:"pull_policy,omitempty" yaml:"pull_policy,omitempty"`
+		// A Dockerfile provided by the egg that is built into a node local image for the
+		// server, rather than pulling the image defined above. Any build arguments are
+		// passed along to the build.
+		Dockerfile string            `json:"dockerfile,omitempty" yaml:"dockerfile,omitempty"`
+		BuildArgs  map[string]string `json:"build_args,omitempty" yaml:"build_args,omitempty"`
+		// The DNS servers for the container. If empty the node defaults are used.
+		Dns []string `json:"dns,omitempty" yaml:"dns,omitempty"`
+		// Additional hosts file entries for the container in the format "hostname:ip",
+		// added alongside any defined for the node.
+		ExtraHosts []string `json:"extra_hosts,omitempty" yaml:"extra_hosts,omitempty"`
+		// Kernel parameters to set for the container. These must be allowed by the node
+		// configuration and take priority over the node defaults.
+		Sysctls map[string]string `json:"sysctls,omitempty" yaml:"sysctls,omitempty"`
+		// Overrides for the confinement of the container, such as the seccomp profile and
+		// capabilities. These are checked against what the node allows.
+		Security ContainerSecurity `json:"security,omitempty" yaml:"security,omitempty"`
 		// If set to true, OOM killer will be disabled on the server's Docker container.
 		// If not present (nil) we will default to disabling it.
 		OomDisabled bool `default:"true" json:"oom_disabled" yaml:"oom_disabled"`
@@ -72,6 +143,62 @@ type Server struct {
 	// started, and then cached here.
 	processConfiguration *api.ProcessConfiguration
 
+	// Set while the server is using the cached process configuration because the Panel
+	// could not be reached.
+	usingProcessCache bool
+
+	// Tracks the health checks for the server process while it is running.
+	health healthMonitor
+
+	// Tracks the querying of the server process for its players while it is running.
+	queryPoller queryPoller
+
+	// The compiled readiness pattern for the running server process.
+	readinessPattern *regexp.Regexp
+
+	// Records where the time is spent while the server is booting.
+	boot bootTracker
+
+	// The commands recently sent to the server console.
+	console consoleHistory
+
+	// The file the console output of the server is written to.
+	consoleLog consoleLog
+
+	// The transforms applied to the console output of the server.
+	consoleTransforms consoleTransformer
+
+	// The file the resource usage history of the server is written to.
+	statsHistory statsHistory
+
+	// The compiled redaction rules applied to the console output of the server.
+	redactions redactions
+
+	// The compiled rules restricting what can be done to the files of the server.
+	fileRestrictions fileRestrictions
+
+	// Held while a file edited inline is checked for changes and written, so that two edits
+	// of the same version of a file cannot both be saved. Only inline edits take the lock,
+	// files written through the files API or SFTP in the meantime are not detected.
+	editLock sync.Mutex
+
+	// The reason recorded the next time the server process stops.
+	stopReason pendingStopReason
+
+	// Held while the disk usage of a directory is being calculated, so that concurrent
+	// requests wait for the cached result rather than walking the files again.
+	usageLock sync.Mutex
+
+	// Tracks the disk space used by the server as its files change.
+	diskAccounting diskAccounting
+
+	// The size of the items in the trash of the server.
+	trash trashBin
+
+	// The values from the Panel replaced by the local overrides, which are written to the
+	// disk in place of the overrides.
+	panelValues *panelValues
+
 	// Internal mutex used to block actions that need to occur sequentially, such as
 	// writing the configuration to the disk.
 	mutex *sync.Mutex
@@ -91,13 +218,83 @@ type BuildSettings struct {
 	// containers on the system and should be a value between 10 and 1000.
 	IoWeight uint16 `json:"io_weight" yaml:"io"`
 
+	// Hard limits on the disk throughput of the server, applied to the disk that the
+	// server data is stored on.
+	IoLimits IoLimits `json:"io_limits" yaml:"io_limits"`
+
 	// The percentage of CPU that this instance is allowed to consume relative to
 	// the host. A value of 200% represents complete utilization of two cores. This
 	// should be a value between 1 and THREAD_COUNT * 100.
 	CpuLimit int64 `json:"cpu_limit" yaml:"cpu"`
 
+	// The relative weight of the server when competing for CPU time with other processes
+	// on the host, using the cgroup v2 scale of 1 to 10000. If not set the default weight
+	// of 100 is used, which is equal to that of other services on the host.
+	CpuWeight uint64 `json:"cpu_weight" yaml:"cpu_weight"`
+
 	// The amount of disk space in megabytes that a server is allowed to use.
 	DiskSpace int64 `json:"disk_space" yaml:"disk"`
+
+	// The maximum number of processes and threads that can run in the container. If not
+	// set the number is not limited.
+	PidsLimit int64 `json:"pids_limit" yaml:"pids_limit"`
+
+	// The GPUs that should be made available to the server.
+	Gpu GpuSettings `json:"gpu" yaml:"gpu"`
+
+	// The maximum network bandwidth available to the server.
+	Bandwidth BandwidthLimits `json:"bandwidth" yaml:"bandwidth"`
+}
+
+// Defines the network bandwidth limits for a server in megabits per second. A value of
+// 0 means no limit is applied in that direction.
+type BandwidthLimits struct {
+	// Limits traffic received by the server.
+	Ingress uint64 `json:"ingress" yaml:"ingress"`
+	// Limits traffic sent by the server.
+	Egress uint64 `json:"egress" yaml:"egress"`
+}
+
+// Determines if any bandwidth limits are defined.
+func (b *BandwidthLimits) Enabled() bool {
+	return b.Ingress > 0 || b.Egress > 0
+}
+
+// Defines the maximum disk throughput for a server. A value of 0 means no limit is
+// applied.
+type IoLimits struct {
+	// The maximum bytes per second that can be read from and written to the disk.
+	ReadBps  uint64 `json:"read_bps" yaml:"read_bps"`
+	WriteBps uint64 `json:"write_bps" yaml:"write_bps"`
+
+	// The maximum number of read and write operations per second.
+	ReadIops  uint64 `json:"read_iops" yaml:"read_iops"`
+	WriteIops uint64 `json:"write_iops" yaml:"write_iops"`
+}
+
+// Determines if any IO limits are defined.
+func (l *IoLimits) Enabled() bool {
+	return l.ReadBps > 0 || l.WriteBps > 0 || l.ReadIops > 0 || l.WriteIops > 0
+}
+
+// Defines the GPU devices that are passed through to a server container.
+type GpuSettings struct {
+	// The specific devices to pass through, using either their index or UUID. The value
+	// "all" will pass through every device on the host.
+	Devices []string `json:"devices" yaml:"devices"`
+
+	// The number of devices to pass through. This is only used if no specific devices
+	// are defined, in which case the first devices detected on the host are used.
+	Count int `json:"count" yaml:"count"`
+
+	// The driver capabilities to enable for the devices, such as "compute", "utility",
+	// or "graphics". If empty the node defaults are used.
+	Capabilities []string `json:"capabilities" yaml:"capabilities"`
+}
+
+// Determines if the server has requested access to any GPUs.
+func (g *GpuSettings) Enabled() bool {
+	return len(g.Devices) > 0 || g.Count > 0
 }
 
 // Converts the CPU limit for a server build into a number that can be better understood
@@ -111,6 +308,22 @@ func (b *BuildSettings) ConvertedCpuLimit() int64 {
 	return b.CpuLimit * 1000
 }
 
+// Returns the CPU shares for the server. Shares are what the container runtime accepts
+// on both cgroup v1 and v2, on v2 hosts they are converted to the matching CPU weight.
+func (b *BuildSettings) ConvertedCpuShares() int64 {
+	weight := b.CpuWeight
+	if weight == 0 {
+		weight = 100
+	}
+
+	if cgroups.Unified() {
+		return int64(cgroups.SharesForWeight(weight))
+	}
+
+	// The default cgroup v1 shares are 1024, which are equivalent to a weight of 100.
+	return int64(weight * 1024 / 100)
+}
+
 // Returns the amount of swap available as a total in bytes. This is returned as the amount
 // of memory available to the server initially, PLUS the amount of additional swap to include
 // which is the format used by Docker.
@@ -122,6 +335,21 @@ func (b *BuildSettings) ConvertedSwap() int64 {
 	return (b.Swap * 1000000) + (b.MemoryLimit * 1000000)
 }
 
+// Defines the networking configuration for a server.
+type NetworkSettings struct {
+	// The networking mode for the server, one of "bridge", "host", or "macvlan". If not
+	// set the server is attached to the node's primary network.
+	Mode string `json:"mode" yaml:"mode"`
+
+	// The parent interface and static addresses to use when the mode is "macvlan".
+	Parent string `json:"parent" yaml:"parent"`
+	Ip     string `json:"ip" yaml:"ip"`
+	Ip6    string `json:"ip6" yaml:"ip6"`
+
+	// Additional named networks that the server is attached to.
+	Networks []string `json:"networks" yaml:"networks"`
+}
+
 // Defines the allocations available for a given server. When using the Docker environment
 // driver these correspond to mappings for the container that allow external connections.
 type Allocations struct {
@@ -136,6 +364,25 @@ type Allocations struct {
 	// Mappings contains all of the ports that should be assigned to a given server
 	// attached to the IP they correspond to.
 	Mappings map[string][]int `json:"mappings"`
+
+	// Allocations that pair an IPv4 and an IPv6 binding into a single logical allocation
+	// for servers that should be reachable using both address families.
+	Pairs []AllocationPair `json:"pairs" yaml:"pairs"`
+
+	// Contiguous ranges of ports allocated as a single allocation, which are published
+	// without needing a mapping for every port in the range.
+	Ranges []PortRange `json:"ranges" yaml:"ranges"`
+
+	// Flood protection limits for individual allocations, keyed by "ip:port". Any
+	// allocation without limits defined uses the node defaults.
+	FloodProtection map[string]FloodProtection `json:"flood_protection" yaml:"flood_protection"`
+}
+
+// Defines the limits applied to the connections made to an allocation.
+type FloodProtection struct {
+	MaxConnections  int `json:"max_connections" yaml:"max_connections"`
+	UdpNewPerSecond int `json:"udp_new_per_second" yaml:"udp_new_per_second"`
+	UdpBurst        int `json:"udp_burst" yaml:"udp_burst"`
 }
 
 // Iterates over a given directory and loads all of the servers listed before returning
@@ -218,12 +465,12 @@ func FromConfiguration(data []byte, cfg *config.SystemConfiguration) (*Server, e
 		return nil, err
 	}
 
+	s.applyOverrides()
+	s.loadCachedProcessConfiguration()
+
 	s.AddEventListeners()
 
-	// Right now we only support a Docker based environment, so I'm going to hard code
-	// this logic in. When we're ready to support other environment we'll need to make
-	// some modifications here obviously.
-	if err := NewDockerEnvironment(s); err != nil {
+	if err := NewEnvironment(s); err != nil {
 		return nil, err
 	}
 
@@ -258,9 +505,23 @@ func (s *Server) GetEnvironmentVariables() []string {
 		fmt.Sprintf("SERVER_IP=%s", s.Allocations.DefaultMapping.Ip),
 		fmt.Sprintf("SERVER_PORT=%d", s.Allocations.DefaultMapping.Port),
 	}
+	out = append(out, s.Allocations.pairEnvironment()...)
+	out = append(out, s.Allocations.rangeEnvironment()...)
+
+	env := s.EnvVars
+	if p := s.activeProfile(); p != nil && len(p.Environment) > 0 {
+		env = make(map[string]string, len(s.EnvVars)+len(p.Environment))
+		for k, v := range s.EnvVars {
+			env[k] = v
+		}
+
+		for k, v := range p.Environment {
+			env[k] = v
+		}
+	}
 
 eloop:
-	for k, v := range s.EnvVars {
+	for k, v := range s.withNodeEnvironment(env) {
 		for _, e := range out {
 			if strings.HasPrefix(e, strings.ToUpper(k)) {
 				continue eloop
@@ -303,9 +564,20 @@ func (s *Server) Sync() error {
 	return nil
 }
 
-// Reads the log file for a server up to a specified number of bytes.
+// Reads the log file for a server up to a specified number of bytes. The redaction rules
+// for the server are applied to every line, since the log file contains the output of the
+// server exactly as it was written.
 func (s *Server) ReadLogfile(len int64) ([]string, error) {
-	return s.Environment.Readlog(len)
+	lines, err := s.Environment.Readlog(len)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, l := range lines {
+		lines[i] = s.Redact(l)
+	}
+
+	return lines, nil
 }
 
 // Determine if the server is bootable in it's current state or not. This will not
@@ -326,19 +598,33 @@ const (
 	ProcessOfflineState  = "offline"
 	ProcessStartingState = "starting"
 	ProcessRunningState  = "running"
+	ProcessReadyState    = "ready"
 	ProcessStoppingState = "stopping"
 )
 
+// Determines if the state is one where the server process has finished starting, which
+// includes servers that are running but not yet ready to accept players.
+func IsRunningState(state string) bool {
+	return state == ProcessRunningState || state == ProcessReadyState
+}
+
 // Sets the state of the server internally. This function handles crash detection as
 // well as reporting to event listeners for the server.
 func (s *Server) SetState(state string) error {
-	if state != ProcessOfflineState && state != ProcessStartingState && state != ProcessRunningState && state != ProcessStoppingState {
+	if state != ProcessOfflineState && state != ProcessStartingState && !IsRunningState(state) && state != ProcessStoppingState {
 		return errors.New(fmt.Sprintf("invalid server state received: %s", state))
 	}
 
 	prevState := s.State
 	s.State = state
 
+	metrics.ServerState.Inc(s.Uuid, state)
+
+	stopped := (prevState == ProcessStartingState || IsRunningState(prevState) || prevState == ProcessStoppingState) && state == ProcessOfflineState
+	if stopped {
+		s.LastStopReason = s.resolveStopReason()
+	}
+
 	// Persist this change to the disk immediately so that should the Daemon be stopped or
 	// crash we can immediately restore the server state.
 	//
@@ -357,6 +643,62 @@ func (s *Server) SetState(state string) error {
 
 	// Emit the event to any listeners that are currently registered.
 	s.Events().Publish(StatusEvent, s.State)
+	if stopped {
+		s.publishStopReason()
+
+		go retryNetworkMigration()
+	}
+
+	// Health checks only run once the server has finished starting, and are stopped as
+	// soon as the server begins stopping.
+	if IsRunningState(s.State) && !IsRunningState(prevState) {
+		s.startHealthChecks()
+		s.startQueryPolling()
+	} else if !IsRunningState(s.State) {
+		s.stopHealthChecks()
+		s.stopQueryPolling()
+	}
+
+	switch s.State {
+	case ProcessStartingState:
+		s.CrashDetection.wasReady = false
+		s.CrashDetection.startedAt = time.Now()
+		s.CrashLooping = false
+		s.OomFailed = false
+		s.PendingRestart = nil
+		s.clearStopReason()
+	case ProcessRunningState:
+		if prevState == ProcessStartingState {
+			s.fireHook(hooks.PostStart, nil)
+		}
+
+		s.bootPhase("startup")
+		if !s.hasReadinessCheck() {
+			s.finishBoot(true)
+		}
+
+		s.startReadinessChecks()
+	case ProcessReadyState:
+		s.CrashDetection.wasReady = true
+		s.bootPhase("ready")
+		s.finishBoot(true)
+	case ProcessOfflineState, ProcessStoppingState:
+		s.finishBoot(false)
+
+		// Memory added after running out of memory only lasts until the server is stopped.
+		if s.State == ProcessStoppingState {
+			s.updateLastOom(func(k *OomKill) {
+				k.MemoryBump = 0
+			})
+		}
+	}
+
+	if s.State == ProcessOfflineState {
+		// Ports forwarded after an allocation change are only needed while the container
+		// that they forward to is running.
+		stopAllocationProxy(s.Uuid)
+		s.startOfflineResponder()
+	}
 
 	// If server was in an online state, and is now in an offline state we should handle
 	// that as a crash event. In that scenario, check the last crash time, and the crash
@@ -366,7 +708,7 @@ func (s *Server) SetState(state string) error {
 	// automatically attempt to start the process back up for the user. This is done in a
 	// seperate thread as to not block any actions currently taking place in the flow
 	// that called this function.
-	if (prevState == ProcessStartingState || prevState == ProcessRunningState) && s.State == ProcessOfflineState {
+	if (prevState == ProcessStartingState || IsRunningState(prevState)) && s.State == ProcessOfflineState {
 		zap.S().Infow("detected server as entering a potentially crashed state; running handler", zap.String("server", s.Uuid))
 
 		go func(server *Server) {
@@ -383,7 +725,13 @@ func (s *Server) SetState(state string) error {
 	return nil
 }
 
-// Gets the process configuration data for the server.
-func (s *Server) GetProcessConfiguration() (*api.ServerConfigurationResponse, *api.RequestError, error) {
-	return api.NewRequester().GetServerConfiguration(s.Uuid)
+// Forces the locally built image for the server to be rebuilt, if the environment for
+// the server supports building images.
+func (s *Server) RebuildImage() error {
+	e, ok := s.Environment.(interface{ RebuildImage() error })
+	if !ok {
+		return errors.New("server environment does not support building images")
+	}
+
+	return e.RebuildImage()
 }
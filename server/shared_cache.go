@@ -0,0 +1,215 @@
+package server
+
+import (
+	"context"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/client"
+	"github.com/docker/go-connections/nat"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The name of the container running the pull-through registry mirror for the node.
+const registryMirrorContainer = "wings_registry_mirror"
+
+// The directory the registry mirror stores cached image layers in.
+const registryMirrorDirectory = "data/registry_mirror"
+
+var checksumRegex = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// Returns the path to an installation artifact in the local cache so that it can be
+// served to a peer. An error is returned if the artifact is not cached on this node.
+func CachedArtifactPath(sum string) (string, error) {
+	sum = strings.ToLower(sum)
+	if !checksumRegex.MatchString(sum) {
+		return "", errors.New("invalid artifact checksum")
+	}
+
+	p := filepath.Join(installCacheDirectory, sum)
+	if _, err := os.Stat(p); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return p, nil
+}
+
+// Requests an installation artifact from each of the peers sharing their cache until one
+// of them returns it. The artifact is verified against the checksum before it is used.
+// Returns the path to the downloaded file and the peer it came from.
+func fetchArtifactFromPeers(sum string) (string, string, error) {
+	cfg := config.Get().System.SharedCache
+	if !cfg.Enabled {
+		return "", "", errors.New("shared cache is not enabled")
+	}
+
+	for _, peer := range cfg.Peers {
+		url := strings.TrimSuffix(peer, "/") + "/api/cache/artifacts/" + sum
+
+		tmp, err := downloadToTemp(url, cfg.Token, sum)
+		if err != nil {
+			zap.S().Debugw("installation artifact not available from peer", zap.String("peer", peer), zap.String("checksum", sum), zap.Error(err))
+			continue
+		}
+
+		return tmp, peer, nil
+	}
+
+	return "", "", errors.New("artifact is not cached by any peer")
+}
+
+// Returns the reference for a Docker Hub image on a registry mirror. Images that are not
+// hosted on Docker Hub, or that are pinned to a digest, cannot be pulled from a mirror and
+// an empty string is returned.
+func mirrorReference(mirror string, image string) string {
+	if registryHost(image) != defaultRegistry || strings.Contains(image, "@") {
+		return ""
+	}
+
+	name := image
+	for _, prefix := range []string{"docker.io/", "index.docker.io/", "registry-1.docker.io/"} {
+		name = strings.TrimPrefix(name, prefix)
+	}
+
+	// Official images are stored under the "library" namespace on the registry.
+	if !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	return strings.TrimSuffix(mirror, "/") + "/" + name
+}
+
+// Attempts to pull a Docker Hub image from one of the registry mirrors shared between
+// nodes. The image is tagged with its original name once pulled so that it is used
+// exactly as if it was pulled from Docker Hub.
+func pullFromMirrors(c *client.Client, image string) error {
+	cfg := config.Get().System.SharedCache
+	if !cfg.Enabled || len(cfg.RegistryMirrors) == 0 {
+		return errors.New("no registry mirrors are configured")
+	}
+
+	for _, mirror := range cfg.RegistryMirrors {
+		ref := mirrorReference(mirror, image)
+		if ref == "" {
+			return errors.New("image cannot be pulled from a registry mirror")
+		}
+
+		if err := pullReference(c, ref); err != nil {
+			zap.S().Debugw("failed to pull image from registry mirror", zap.String("mirror", mirror), zap.String("image", image), zap.Error(err))
+			continue
+		}
+
+		if err := c.ImageTag(context.Background(), ref, image); err != nil {
+			return errors.WithStack(err)
+		}
+
+		// Only the additional tag is removed here, the image itself remains since it is
+		// still tagged using the original name.
+		if _, err := c.ImageRemove(context.Background(), ref, types.ImageRemoveOptions{}); err != nil {
+			zap.S().Debugw("failed to remove registry mirror tag from image", zap.String("image", ref), zap.Error(err))
+		}
+
+		zap.S().Infow("pulled image from registry mirror", zap.String("mirror", mirror), zap.String("image", image))
+
+		return nil
+	}
+
+	return errors.New("image could not be pulled from any registry mirror")
+}
+
+// Ensures the pull-through registry mirror container is running on this node so that
+// other nodes in the cluster can pull Docker Hub images through it.
+func EnsureRegistryMirror(cfg *config.SharedCacheConfiguration) error {
+	cli, err := NewRuntimeClient()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer cli.Close()
+
+	host := registryMirrorAddress(cfg)
+	port := nat.Port("5000/tcp")
+
+	ctx := context.Background()
+	if c, err := cli.ContainerInspect(ctx, registryMirrorContainer); err == nil {
+		// A container created by an older version is published on every address, so it
+		// is replaced rather than started again.
+		if b := c.HostConfig.PortBindings[port]; len(b) == 1 && b[0].HostIP == host {
+			if c.State.Running {
+				return nil
+			}
+
+			return errors.WithStack(cli.ContainerStart(ctx, registryMirrorContainer, types.ContainerStartOptions{}))
+		}
+
+		if err := cli.ContainerRemove(ctx, registryMirrorContainer, types.ContainerRemoveOptions{Force: true}); err != nil {
+			return errors.WithStack(err)
+		}
+	} else if !client.IsErrNotFound(err) {
+		return errors.WithStack(err)
+	}
+
+	if err := pullImage(cli, cfg.RegistryMirrorImage, PullIfNotPresent); err != nil {
+		return err
+	}
+
+	dir, err := filepath.Abs(registryMirrorDirectory)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	conf := &container.Config{
+		Image:        cfg.RegistryMirrorImage,
+		Env:          []string{"REGISTRY_PROXY_REMOTEURL=https://registry-1.docker.io"},
+		ExposedPorts: nat.PortSet{port: struct{}{}},
+	}
+
+	hostConf := &container.HostConfig{
+		PortBindings: nat.PortMap{
+			port: []nat.PortBinding{{HostIP: host, HostPort: strconv.Itoa(cfg.RegistryMirrorPort)}},
+		},
+		Mounts: []mount.Mount{
+			{Type: mount.TypeBind, Source: dir, Target: "/var/lib/registry"},
+		},
+		RestartPolicy: container.RestartPolicy{Name: "unless-stopped"},
+	}
+
+	if _, err := cli.ContainerCreate(ctx, conf, hostConf, nil, registryMirrorContainer); err != nil {
+		return errors.WithStack(err)
+	}
+
+	zap.S().Infow("created registry mirror container", zap.String("host", host), zap.Int("port", cfg.RegistryMirrorPort))
+
+	return errors.WithStack(cli.ContainerStart(ctx, registryMirrorContainer, types.ContainerStartOptions{}))
+}
+
+// Returns the address the registry mirror is published on, which is the configured address
+// or the gateway of the docker0 bridge, falling back to the loopback address.
+func registryMirrorAddress(cfg *config.SharedCacheConfiguration) string {
+	if cfg.RegistryMirrorAddress != "" {
+		return config.TrimAddressBrackets(cfg.RegistryMirrorAddress)
+	}
+
+	if i, err := net.InterfaceByName("docker0"); err == nil {
+		if addrs, err := i.Addrs(); err == nil {
+			for _, a := range addrs {
+				if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+					return n.IP.String()
+				}
+			}
+		}
+	}
+
+	return "127.0.0.1"
+}
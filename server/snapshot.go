@@ -0,0 +1,398 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The directory within each storage pool that snapshots are created in. Snapshots are
+// kept on the same disk as the server data so that files can be cloned rather than
+// copied on filesystems that support it.
+const snapshotsDirectory = ".snapshots"
+
+// The methods used to create a snapshot. Cloned snapshots share their data with the
+// original files until either of them is modified, so they are created almost instantly
+// and use no additional disk space.
+const (
+	SnapshotMethodReflink = "reflink"
+	SnapshotMethodCopy    = "copy"
+)
+
+// A read-only copy of the data directory of a server at a point in time, which can be
+// read by external tools such as map renderers using a token that expires along with the
+// snapshot. The live files of the server are never touched by these tools.
+type Snapshot struct {
+	Id     string `json:"id"`
+	Server string `json:"server"`
+	Method string `json:"method"`
+	// Set if the server process was frozen while the snapshot was created, in which case
+	// every file is from the same moment in time.
+	Frozen bool  `json:"frozen"`
+	Files  int   `json:"files"`
+	Size   int64 `json:"size"`
+
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	path  string
+	token string
+	timer *time.Timer
+	// The size of the files that were copied rather than cloned, which use disk space of
+	// their own and are counted towards the disk limit of the server.
+	copied int64
+}
+
+// A single file or directory within a snapshot.
+type SnapshotEntry struct {
+	Name       string    `json:"name"`
+	Directory  bool      `json:"directory"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+var snapshots = struct {
+	sync.Mutex
+	snapshots map[string]*Snapshot
+}{snapshots: make(map[string]*Snapshot)}
+
+// Creates a snapshot of the server data that is available for the given duration, or
+// the default lifetime if it is zero. If freeze is set and the server is running its
+// process is suspended while the files are copied, so that none of them change part way
+// through. The token needed to read the snapshot is returned alongside it, and cannot be
+// retrieved again later.
+func (s *Server) CreateSnapshot(ttl time.Duration, freeze bool) (*Snapshot, string, error) {
+	cfg := config.Get().System.Snapshots
+
+	if ttl == 0 {
+		ttl = time.Duration(cfg.DefaultTtl) * time.Minute
+	}
+
+	if ttl < 0 || ttl > time.Duration(cfg.MaxTtl)*time.Minute {
+		return nil, "", errors.Errorf("snapshot lifetime must be between 1 and %d minutes", cfg.MaxTtl)
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", errors.WithStack(err)
+	}
+
+	sn := &Snapshot{
+		Id:        uuid.New().String(),
+		Server:    s.Uuid,
+		Method:    SnapshotMethodReflink,
+		CreatedAt: time.Now().UTC(),
+		token:     hex.EncodeToString(b),
+	}
+	sn.path = filepath.Join(s.Filesystem.Root(), snapshotsDirectory, sn.Id)
+
+	// Reserve the slot for the snapshot before copying anything, so that concurrent
+	// requests cannot exceed the limit for the server.
+	snapshots.Lock()
+	if cfg.MaxPerServer > 0 && len(s.snapshots()) >= cfg.MaxPerServer {
+		snapshots.Unlock()
+		return nil, "", errors.Errorf("server already has the maximum of %d snapshots", cfg.MaxPerServer)
+	}
+	snapshots.snapshots[sn.Id] = sn
+	snapshots.Unlock()
+
+	if err := s.copySnapshot(sn, freeze); err != nil {
+		sn.remove()
+		return nil, "", err
+	}
+
+	sn.ExpiresAt = time.Now().UTC().Add(ttl)
+	sn.timer = time.AfterFunc(ttl, func() {
+		if err := sn.remove(); err != nil {
+			zap.S().Warnw("failed to remove expired snapshot", zap.String("server", sn.Server), zap.String("snapshot", sn.Id), zap.Error(err))
+		}
+	})
+
+	zap.S().Infow("created snapshot of server data", zap.String("server", s.Uuid), zap.String("snapshot", sn.Id), zap.String("method", sn.Method), zap.Int64("size", sn.Size))
+
+	return sn, sn.token, nil
+}
+
+// Copies the server data into the snapshot directory.
+func (s *Server) copySnapshot(sn *Snapshot, freeze bool) error {
+	if freeze && s.State != ProcessOfflineState {
+		if err := s.Environment.Freeze(); err != nil {
+			return errors.Wrap(err, "failed to freeze server process")
+		}
+
+		sn.Frozen = true
+
+		defer func() {
+			if err := s.Environment.Thaw(); err != nil {
+				zap.S().Errorw("failed to thaw server process after creating snapshot", zap.String("server", s.Uuid), zap.Error(err))
+			}
+		}()
+	}
+
+	src := s.Filesystem.Path()
+	clone := true
+
+	var available int64 = -1
+	if limit := s.Build.DiskSpace; limit > 0 {
+		available = limit*1000*1000 - s.Filesystem.cachedDiskUsage()
+	}
+
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files removed by the server process while the snapshot is being created
+			// are left out of it.
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		target := filepath.Join(sn.path, rel)
+
+		switch {
+		case info.IsDir():
+			return errors.WithStack(os.MkdirAll(target, 0700))
+		case info.Mode().IsRegular():
+			cloned, size, err := snapshotFile(src, p, target, clone, &available)
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+
+			// Once a file could not be cloned the filesystem does not support it, so
+			// the remaining files are copied without trying.
+			if !cloned && clone {
+				clone = false
+				sn.Method = SnapshotMethodCopy
+			}
+
+			snapshots.Lock()
+			sn.Files++
+			sn.Size += size
+			if !cloned {
+				sn.copied += size
+			}
+			snapshots.Unlock()
+		}
+
+		// Symlinks are never followed out of the server directory, so they are left out
+		// of the snapshot entirely.
+		return nil
+	})
+}
+
+// Copies a single file within the root into the snapshot as a read-only file, cloning it
+// if requested. The file is opened without following symlinks, since the server can swap
+// it for one after the directory was read, and the size of the opened file is returned
+// along with whether it was cloned. Copied files count against the available disk space.
+func snapshotFile(root string, src string, dst string, clone bool, available *int64) (bool, int64, error) {
+	in, info, err := openFileBeneath(root, src)
+	if err != nil {
+		return false, 0, err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0400)
+	if err != nil {
+		return false, 0, errors.WithStack(err)
+	}
+	defer out.Close()
+
+	cloned := clone && cloneFile(in, out) == nil
+	if !cloned {
+		if *available >= 0 {
+			if info.Size() > *available {
+				return false, 0, &diskSpaceError{message: "copying the snapshot would exceed the disk space available to the server"}
+			}
+
+			*available -= info.Size()
+		}
+
+		if _, err := io.Copy(out, in); err != nil {
+			return false, 0, errors.WithStack(err)
+		}
+	}
+
+	return cloned, info.Size(), errors.WithStack(os.Chtimes(dst, info.ModTime(), info.ModTime()))
+}
+
+// Returns the disk space used by the snapshots of the server. Cloned files share their
+// data with the files of the server, so only copied files are included.
+func (fs *Filesystem) snapshotsSize() int64 {
+	snapshots.Lock()
+	defer snapshots.Unlock()
+
+	var size int64
+	for _, sn := range snapshots.snapshots {
+		if sn.Server == fs.Server.Uuid {
+			size += sn.copied
+		}
+	}
+
+	return size
+}
+
+// Returns the snapshots of the server. The snapshots lock must be held.
+func (s *Server) snapshots() []*Snapshot {
+	var out []*Snapshot
+	for _, sn := range snapshots.snapshots {
+		if sn.Server == s.Uuid {
+			out = append(out, sn)
+		}
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].CreatedAt.Before(out[j].CreatedAt)
+	})
+
+	return out
+}
+
+// Returns the snapshots of the server that are available to be read, oldest first.
+func (s *Server) Snapshots() []Snapshot {
+	snapshots.Lock()
+	defer snapshots.Unlock()
+
+	out := make([]Snapshot, 0)
+	for _, sn := range s.snapshots() {
+		// Snapshots that are still being created do not have an expiry yet.
+		if !sn.ExpiresAt.IsZero() {
+			out = append(out, *sn)
+		}
+	}
+
+	return out
+}
+
+// Removes a snapshot of the server before it expires.
+func (s *Server) DeleteSnapshot(id string) error {
+	snapshots.Lock()
+	sn, ok := snapshots.snapshots[id]
+	snapshots.Unlock()
+
+	if !ok || sn.Server != s.Uuid || sn.ExpiresAt.IsZero() {
+		return os.ErrNotExist
+	}
+
+	return sn.remove()
+}
+
+// Removes every snapshot of the server. This should be called when the server is deleted.
+func (s *Server) RemoveSnapshots() error {
+	snapshots.Lock()
+	list := s.snapshots()
+	snapshots.Unlock()
+
+	for _, sn := range list {
+		if err := sn.remove(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the snapshot with the given id if the token grants access to it and it has not
+// expired.
+func GetSnapshot(id string, token string) (*Snapshot, error) {
+	snapshots.Lock()
+	sn, ok := snapshots.snapshots[id]
+	snapshots.Unlock()
+
+	if !ok || sn.ExpiresAt.IsZero() || time.Now().After(sn.ExpiresAt) {
+		return nil, os.ErrNotExist
+	}
+
+	if subtle.ConstantTimeCompare([]byte(token), []byte(sn.token)) != 1 {
+		return nil, os.ErrPermission
+	}
+
+	return sn, nil
+}
+
+// Removes the snapshot files from the disk and stops tracking it.
+func (sn *Snapshot) remove() error {
+	snapshots.Lock()
+	delete(snapshots.snapshots, sn.Id)
+	snapshots.Unlock()
+
+	if sn.timer != nil {
+		sn.timer.Stop()
+	}
+
+	return errors.WithStack(os.RemoveAll(sn.path))
+}
+
+// Returns the path on the disk of a file within the snapshot. Paths are cleaned so that
+// they can never point outside of the snapshot.
+func (sn *Snapshot) Path(p string) string {
+	return filepath.Join(sn.path, filepath.Clean("/"+strings.TrimPrefix(p, "/")))
+}
+
+// Returns the contents of a directory within the snapshot.
+func (sn *Snapshot) List(dir string) ([]SnapshotEntry, error) {
+	f, err := os.Open(sn.Path(dir))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	files, err := f.Readdir(-1)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	out := make([]SnapshotEntry, 0, len(files))
+	for _, info := range files {
+		out = append(out, SnapshotEntry{
+			Name:       info.Name(),
+			Directory:  info.IsDir(),
+			Size:       info.Size(),
+			ModifiedAt: info.ModTime(),
+		})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+// Writes a gzip compressed tarball of the entire snapshot to the writer.
+func (sn *Snapshot) Archive(w io.Writer) error {
+	return compressDirectory(sn.path, w)
+}
+
+// Removes any snapshots left on the disk from before the daemon was started. Snapshots
+// are only tracked in memory, so their tokens no longer exist and they cannot be read.
+func RemoveStaleSnapshots(cfg *config.SystemConfiguration) {
+	pools := []string{cfg.Data}
+	for _, p := range cfg.StoragePools {
+		pools = append(pools, p)
+	}
+
+	for _, p := range pools {
+		if err := os.RemoveAll(filepath.Join(p, snapshotsDirectory)); err != nil {
+			zap.S().Warnw("failed to remove stale snapshots", zap.String("path", p), zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"os"
+)
+
+// Files can only be cloned on Linux, so snapshots are always copied elsewhere.
+func cloneFile(src *os.File, dst *os.File) error {
+	return errors.New("cloning files is not supported on this platform")
+}
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"go.uber.org/zap"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The history file for a server is made up of a header followed by one fixed size record
+// for each point within the window. Points are written to the slot for their time, so the
+// file never grows and the oldest points are overwritten as the window moves forward.
+const (
+	statsHistoryMagic      = "WSH1"
+	statsHistoryHeaderSize = 16
+	statsHistoryRecordSize = 64
+)
+
+// A single point in the resource usage history of a server, combining every sample taken
+// within its interval. Usage values are averages over the interval, while the maximums
+// and disk usage are the highest and last values seen.
+type StatsPoint struct {
+	Time        time.Time `json:"time"`
+	Samples     int       `json:"samples"`
+	CpuAbsolute float64   `json:"cpu_absolute"`
+	CpuMax      float64   `json:"cpu_absolute_max"`
+	Memory      uint64    `json:"memory_bytes"`
+	MemoryMax   uint64    `json:"memory_bytes_max"`
+	MemoryLimit uint64    `json:"memory_limit_bytes"`
+	Disk        int64     `json:"disk_bytes"`
+	RxRate      float64   `json:"rx_rate"`
+	TxRate      float64   `json:"tx_rate"`
+	// The players connected at the end of the interval, if the server can be queried.
+	Players *int `json:"players,omitempty"`
+}
+
+// Defines the history to return for a server. The points are combined into intervals of
+// the given step, which is rounded up to a multiple of the resolution of the history.
+type StatsHistoryQuery struct {
+	Since time.Time
+	Until time.Time
+	Step  time.Duration
+}
+
+// The file the resource usage history of a server is written to, along with the point
+// currently being collected.
+type statsHistory struct {
+	mu         sync.Mutex
+	f          *os.File
+	resolution int64
+	slots      int64
+	current    *StatsPoint
+	// Set if the history could not be opened, so that the failure is only logged once.
+	failed bool
+	// Set once the history has been removed along with the server.
+	removed bool
+}
+
+// Returns the path to the history file for the server.
+func (s *Server) statsHistoryPath() string {
+	return filepath.Join(config.Get().System.StatsHistory.Directory, s.Uuid+".stats")
+}
+
+// Adds the current resource usage of the server to its history, writing the previous
+// point to the disk once its interval has passed.
+func (s *Server) recordStats() {
+	cfg := config.Get().System.StatsHistory
+	if !cfg.Enabled {
+		return
+	}
+
+	h := &s.statsHistory
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.removed {
+		return
+	}
+
+	if err := h.open(s.statsHistoryPath(), cfg); err != nil {
+		if !h.failed {
+			zap.S().Warnw("failed to open server stats history", zap.String("server", s.Uuid), zap.Error(err))
+		}
+
+		h.failed = true
+		return
+	}
+	h.failed = false
+
+	now := time.Now().Unix()
+	start := time.Unix(now-now%h.resolution, 0).UTC()
+
+	if h.current != nil && !h.current.Time.Equal(start) {
+		if err := h.write(h.current); err != nil {
+			zap.S().Debugw("failed to write to server stats history", zap.String("server", s.Uuid), zap.Error(err))
+		}
+
+		h.current = nil
+	}
+
+	if h.current == nil {
+		h.current = &StatsPoint{Time: start}
+	}
+
+	h.current.add(&s.Resources)
+}
+
+// Adds a sample to the point, keeping a running average of the usage values.
+func (p *StatsPoint) add(ru *ResourceUsage) {
+	p.Samples++
+	n := float64(p.Samples)
+
+	p.CpuAbsolute += (ru.CpuAbsolute - p.CpuAbsolute) / n
+	p.Memory = uint64(float64(p.Memory) + (float64(ru.Memory)-float64(p.Memory))/n)
+	p.RxRate += (ru.Network.RxRate - p.RxRate) / n
+	p.TxRate += (ru.Network.TxRate - p.TxRate) / n
+
+	p.CpuMax = math.Max(p.CpuMax, ru.CpuAbsolute)
+	if ru.Memory > p.MemoryMax {
+		p.MemoryMax = ru.Memory
+	}
+
+	p.MemoryLimit = ru.MemoryLimit
+	p.Disk = ru.Disk
+
+	if ru.Players != nil {
+		players := ru.Players.Online
+		p.Players = &players
+	}
+}
+
+// Combines another point into this one, weighting the averages by the number of samples
+// in each point.
+func (p *StatsPoint) merge(o StatsPoint) {
+	total := float64(p.Samples + o.Samples)
+	if total == 0 {
+		return
+	}
+
+	a, b := float64(p.Samples)/total, float64(o.Samples)/total
+
+	p.CpuAbsolute = p.CpuAbsolute*a + o.CpuAbsolute*b
+	p.Memory = uint64(float64(p.Memory)*a + float64(o.Memory)*b)
+	p.RxRate = p.RxRate*a + o.RxRate*b
+	p.TxRate = p.TxRate*a + o.TxRate*b
+	p.Samples += o.Samples
+
+	p.CpuMax = math.Max(p.CpuMax, o.CpuMax)
+	if o.MemoryMax > p.MemoryMax {
+		p.MemoryMax = o.MemoryMax
+	}
+
+	p.MemoryLimit = o.MemoryLimit
+	p.Disk = o.Disk
+	if o.Players != nil {
+		p.Players = o.Players
+	}
+}
+
+// Opens the history file if it is not already open. If the file was written using a
+// different window or resolution it is cleared, since its slots no longer line up.
+func (h *statsHistory) open(p string, cfg config.StatsHistoryConfiguration) error {
+	if h.f != nil {
+		return nil
+	}
+
+	resolution := int64(cfg.Resolution)
+	if resolution <= 0 {
+		resolution = 10
+	}
+
+	slots := int64(cfg.Window) * 3600 / resolution
+	if slots <= 0 {
+		return errors.New("stats history window must be at least one hour")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	header := make([]byte, statsHistoryHeaderSize)
+	copy(header, statsHistoryMagic)
+	binary.LittleEndian.PutUint32(header[4:], uint32(resolution))
+	binary.LittleEndian.PutUint32(header[8:], uint32(slots))
+
+	existing := make([]byte, statsHistoryHeaderSize)
+	if _, err := f.ReadAt(existing, 0); err != nil || string(existing) != string(header) {
+		// The file is created sparse, so slots that have never been written take up no
+		// space on the disk.
+		err := f.Truncate(0)
+		if err == nil {
+			_, err = f.WriteAt(header, 0)
+		}
+
+		if err == nil {
+			err = f.Truncate(statsHistoryHeaderSize + slots*statsHistoryRecordSize)
+		}
+
+		if err != nil {
+			f.Close()
+			return errors.WithStack(err)
+		}
+	}
+
+	h.f = f
+	h.resolution = resolution
+	h.slots = slots
+
+	return nil
+}
+
+// Writes a point to its slot in the history file.
+func (h *statsHistory) write(p *StatsPoint) error {
+	b := make([]byte, statsHistoryRecordSize)
+
+	players := int32(-1)
+	if p.Players != nil {
+		players = int32(*p.Players)
+	}
+
+	binary.LittleEndian.PutUint64(b[0:], uint64(p.Time.Unix()))
+	binary.LittleEndian.PutUint32(b[8:], uint32(p.Samples))
+	binary.LittleEndian.PutUint32(b[12:], math.Float32bits(float32(p.CpuAbsolute)))
+	binary.LittleEndian.PutUint32(b[16:], math.Float32bits(float32(p.CpuMax)))
+	binary.LittleEndian.PutUint32(b[20:], math.Float32bits(float32(p.RxRate)))
+	binary.LittleEndian.PutUint32(b[24:], math.Float32bits(float32(p.TxRate)))
+	binary.LittleEndian.PutUint32(b[28:], uint32(players))
+	binary.LittleEndian.PutUint64(b[32:], p.Memory)
+	binary.LittleEndian.PutUint64(b[40:], p.MemoryMax)
+	binary.LittleEndian.PutUint64(b[48:], p.MemoryLimit)
+	binary.LittleEndian.PutUint64(b[56:], uint64(p.Disk))
+
+	slot := p.Time.Unix() / h.resolution % h.slots
+
+	_, err := h.f.WriteAt(b, statsHistoryHeaderSize+slot*statsHistoryRecordSize)
+
+	return errors.WithStack(err)
+}
+
+// Decodes a point from a record in the history file. Returns false for slots that have
+// never been written.
+func decodeStatsPoint(b []byte) (StatsPoint, bool) {
+	t := int64(binary.LittleEndian.Uint64(b[0:]))
+	if t == 0 {
+		return StatsPoint{}, false
+	}
+
+	p := StatsPoint{
+		Time:        time.Unix(t, 0).UTC(),
+		Samples:     int(binary.LittleEndian.Uint32(b[8:])),
+		CpuAbsolute: float64(math.Float32frombits(binary.LittleEndian.Uint32(b[12:]))),
+		CpuMax:      float64(math.Float32frombits(binary.LittleEndian.Uint32(b[16:]))),
+		RxRate:      float64(math.Float32frombits(binary.LittleEndian.Uint32(b[20:]))),
+		TxRate:      float64(math.Float32frombits(binary.LittleEndian.Uint32(b[24:]))),
+		Memory:      binary.LittleEndian.Uint64(b[32:]),
+		MemoryMax:   binary.LittleEndian.Uint64(b[40:]),
+		MemoryLimit: binary.LittleEndian.Uint64(b[48:]),
+		Disk:        int64(binary.LittleEndian.Uint64(b[56:])),
+	}
+
+	if players := int32(binary.LittleEndian.Uint32(b[28:])); players >= 0 {
+		n := int(players)
+		p.Players = &n
+	}
+
+	return p, true
+}
+
+// Returns the resource usage history of the server within the window, from oldest to
+// newest, combined into points of the requested step.
+func (s *Server) StatsHistory(q StatsHistoryQuery) ([]StatsPoint, error) {
+	cfg := config.Get().System.StatsHistory
+	h := &s.statsHistory
+
+	h.mu.Lock()
+	if !cfg.Enabled || h.removed {
+		h.mu.Unlock()
+		return []StatsPoint{}, nil
+	}
+
+	if _, err := os.Stat(s.statsHistoryPath()); err != nil {
+		h.mu.Unlock()
+
+		if os.IsNotExist(err) {
+			return []StatsPoint{}, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	if err := h.open(s.statsHistoryPath(), cfg); err != nil {
+		h.mu.Unlock()
+		return nil, err
+	}
+
+	b := make([]byte, h.slots*statsHistoryRecordSize)
+	if _, err := h.f.ReadAt(b, statsHistoryHeaderSize); err != nil && err != io.EOF {
+		h.mu.Unlock()
+		return nil, errors.WithStack(err)
+	}
+
+	var current *StatsPoint
+	if h.current != nil {
+		c := *h.current
+		current = &c
+	}
+
+	resolution := time.Duration(h.resolution) * time.Second
+	window := time.Duration(h.slots) * resolution
+	h.mu.Unlock()
+
+	since := time.Now().Add(-window)
+	if q.Since.After(since) {
+		since = q.Since
+	}
+
+	var points []StatsPoint
+	for i := 0; i+statsHistoryRecordSize <= len(b); i += statsHistoryRecordSize {
+		if p, ok := decodeStatsPoint(b[i : i+statsHistoryRecordSize]); ok {
+			points = append(points, p)
+		}
+	}
+
+	if current != nil {
+		points = append(points, *current)
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	step := resolution
+	if q.Step > step {
+		step = (q.Step + resolution - 1) / resolution * resolution
+	}
+
+	out := make([]StatsPoint, 0)
+	for _, p := range points {
+		if p.Time.Before(since) || (!q.Until.IsZero() && p.Time.After(q.Until)) {
+			continue
+		}
+
+		t := p.Time.Truncate(step)
+		if len(out) > 0 && out[len(out)-1].Time.Equal(t) {
+			out[len(out)-1].merge(p)
+			continue
+		}
+
+		p.Time = t
+		out = append(out, p)
+	}
+
+	return out, nil
+}
+
+// Closes and removes the stats history for the server. Nothing more is written to the
+// history once it has been removed.
+func (s *Server) RemoveStatsHistory() error {
+	h := &s.statsHistory
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.removed = true
+	h.current = nil
+
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+
+	if err := os.Remove(s.statsHistoryPath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"encoding/json"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// The reasons a server process can stop.
+const (
+	// The process was stopped or killed through the API or the console.
+	StopReasonStop = "stop"
+	StopReasonKill = "kill"
+	// The process exited on its own, either cleanly or with an error.
+	StopReasonExited  = "exited"
+	StopReasonCrashed = "crashed"
+	// The process crashed too many times and is no longer restarted automatically.
+	StopReasonCrashLoop = "crash_loop"
+	// The process was killed for running out of memory.
+	StopReasonOom = "oom"
+	// The process was killed for using more disk space than the server is allowed.
+	StopReasonDiskQuota = "disk_quota"
+	// The process was killed because the server was suspended.
+	StopReasonSuspended = "suspended"
+	// The process was stopped by the daemon, either by a schedule, after the server had
+	// no players, after failing health checks, or to apply a change to the server.
+	StopReasonSchedule    = "schedule"
+	StopReasonIdle        = "idle"
+	StopReasonHealthCheck = "health_check"
+	StopReasonRestart     = "restart"
+	// The process was stopped to drain the node for maintenance.
+	StopReasonDrain = "drain"
+	// The process stopped because the node, or the daemon, was shut down while it was
+	// running.
+	StopReasonNodeShutdown = "node_shutdown"
+)
+
+// Details about why the server process last stopped, sent with the stop reason event and
+// returned along with the server.
+type StopReason struct {
+	Reason  string `json:"reason" yaml:"reason"`
+	Message string `json:"message,omitempty" yaml:"message,omitempty"`
+	// The exit code of the process and whether it was killed for running out of memory,
+	// when they could be determined.
+	ExitCode  uint32    `json:"exit_code" yaml:"exit_code"`
+	OomKilled bool      `json:"oom_killed" yaml:"oom_killed"`
+	Time      time.Time `json:"time" yaml:"time"`
+}
+
+// The reason that will be recorded the next time the server process stops, set by the
+// daemon before it stops or kills the process.
+type pendingStopReason struct {
+	sync.Mutex
+	reason *StopReason
+}
+
+// Sets the reason recorded the next time the server process stops. This should be called
+// right before stopping or killing the process, any reason set earlier is replaced.
+func (s *Server) SetStopReason(reason string, message string) {
+	s.stopReason.Lock()
+	defer s.stopReason.Unlock()
+
+	s.stopReason.reason = &StopReason{Reason: reason, Message: message}
+}
+
+// Records the reason the server process stopped and notifies any listeners. This is used
+// when the reason is only known after the process has stopped.
+func (s *Server) RecordStopReason(reason string, message string) {
+	r := &StopReason{Reason: reason, Message: message, Time: time.Now().UTC()}
+	if s.LastStopReason != nil && reason == StopReasonCrashLoop {
+		r.ExitCode = s.LastStopReason.ExitCode
+		r.OomKilled = s.LastStopReason.OomKilled
+	}
+
+	s.LastStopReason = r
+	s.publishStopReason()
+
+	go func(server *Server) {
+		if _, err := server.WriteConfigurationToDisk(); err != nil {
+			zap.S().Warnw("failed to write server stop reason to disk", zap.String("server", server.Uuid), zap.Error(err))
+		}
+	}(s)
+}
+
+// Clears any reason set for a stop that never happened, so that it is not recorded the
+// next time the process stops.
+func (s *Server) clearStopReason() {
+	s.stopReason.Lock()
+	defer s.stopReason.Unlock()
+
+	s.stopReason.reason = nil
+}
+
+// Determines why the server process stopped, using the reason set before it was stopped
+// if there is one, otherwise the exit state of the process.
+func (s *Server) resolveStopReason() *StopReason {
+	s.stopReason.Lock()
+	r := s.stopReason.reason
+	s.stopReason.reason = nil
+	s.stopReason.Unlock()
+
+	exitCode, oomKilled, err := s.Environment.ExitState()
+	if err != nil {
+		zap.S().Debugw("failed to get exit state of server process", zap.String("server", s.Uuid), zap.Error(err))
+	}
+
+	// The runtime only reports the container as killed if the main process was killed,
+	// a child process being killed can also cause the server to exit.
+	if s.oomKilledRecently() {
+		oomKilled = true
+	}
+
+	if r == nil {
+		switch {
+		case oomKilled:
+			r = &StopReason{Reason: StopReasonOom}
+		case exitCode == 0:
+			r = &StopReason{Reason: StopReasonExited}
+		default:
+			r = &StopReason{Reason: StopReasonCrashed}
+		}
+	}
+
+	r.ExitCode = exitCode
+	r.OomKilled = oomKilled
+	r.Time = time.Now().UTC()
+
+	return r
+}
+
+// Sends the last stop reason of the server to any listeners, along with the state of the
+// server so that it can be matched with the status event it follows.
+func (s *Server) publishStopReason() {
+	if s.LastStopReason == nil {
+		return
+	}
+
+	b, _ := json.Marshal(struct {
+		State string `json:"state"`
+		*StopReason
+	}{State: s.State, StopReason: s.LastStopReason})
+
+	s.Events().Publish(StopReasonEvent, string(b))
+}
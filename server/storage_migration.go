@@ -0,0 +1,404 @@
+package server
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// The maximum number of passes made copying the data of a server to its new storage pool
+// while the server is still running. Each pass only copies the files changed since the
+// previous one, so the final pass made while the server is stopped should be short.
+const storageMigrationPasses = 3
+
+// The phases of a storage migration.
+const (
+	StorageMigrationCopying   = "copying"
+	StorageMigrationFinishing = "finishing"
+	StorageMigrationComplete  = "complete"
+	StorageMigrationFailed    = "failed"
+)
+
+// The progress of moving the data of a server to another storage pool on the node.
+type StorageMigration struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Phase string `json:"phase"`
+	// The number of copy passes that have been made, and the total bytes copied by them.
+	Passes int   `json:"passes"`
+	Copied int64 `json:"copied_bytes"`
+
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+var storageMigrations = struct {
+	sync.Mutex
+	migrations map[string]*StorageMigration
+}{migrations: make(map[string]*StorageMigration)}
+
+// Returns the current or last storage migration for the server, or nil if the server
+// has not been migrated since the daemon started.
+func (s *Server) StorageMigration() *StorageMigration {
+	storageMigrations.Lock()
+	defer storageMigrations.Unlock()
+
+	m, ok := storageMigrations.migrations[s.Uuid]
+	if !ok {
+		return nil
+	}
+
+	c := *m
+
+	return &c
+}
+
+// Determines if the server is in the final phase of a storage migration, during which it
+// must not be started since its data is being switched over to the new pool.
+func (s *Server) storageMigrationLocked() bool {
+	m := s.StorageMigration()
+
+	return m != nil && m.Phase == StorageMigrationFinishing
+}
+
+// Updates the migration for the server while holding the lock.
+func (s *Server) updateStorageMigration(fn func(m *StorageMigration)) {
+	storageMigrations.Lock()
+	defer storageMigrations.Unlock()
+
+	if m, ok := storageMigrations.migrations[s.Uuid]; ok {
+		fn(m)
+	}
+}
+
+// Begins moving the data of the server to another storage pool on the node. The data is
+// copied in the background while the server keeps running, and then the server is briefly
+// stopped while the remaining changes are copied and it is switched over to the new pool.
+func (s *Server) MigrateStorage(pool string) error {
+	cfg := config.Get().System
+	if _, ok := cfg.StoragePools[pool]; pool != "" && !ok {
+		return errors.Errorf("storage pool \"%s\" is not defined", pool)
+	}
+
+	if cfg.StoragePoolPath(pool) == s.Filesystem.Root() {
+		return errors.New("server data is already stored in this storage pool")
+	}
+
+	storageMigrations.Lock()
+	if m, ok := storageMigrations.migrations[s.Uuid]; ok && (m.Phase == StorageMigrationCopying || m.Phase == StorageMigrationFinishing) {
+		storageMigrations.Unlock()
+		return errors.New("server data is already being migrated")
+	}
+
+	storageMigrations.migrations[s.Uuid] = &StorageMigration{
+		From:      s.StoragePool,
+		To:        pool,
+		Phase:     StorageMigrationCopying,
+		StartedAt: time.Now().UTC(),
+	}
+	storageMigrations.Unlock()
+
+	go func() {
+		defer supervisor.Recover("storage migration")
+
+		err := s.migrateStorage(pool)
+
+		s.updateStorageMigration(func(m *StorageMigration) {
+			t := time.Now().UTC()
+			m.FinishedAt = &t
+			m.Phase = StorageMigrationComplete
+
+			if err != nil {
+				m.Phase = StorageMigrationFailed
+				m.Error = err.Error()
+			}
+		})
+
+		if err != nil {
+			zap.S().Errorw("failed to migrate server data to storage pool", zap.String("server", s.Uuid), zap.String("pool", pool), zap.Error(err))
+		}
+	}()
+
+	return nil
+}
+
+// Copies the server data to the new storage pool and switches the server over to it.
+func (s *Server) migrateStorage(pool string) error {
+	previous := s.StoragePool
+	src := s.Filesystem.Path()
+	dst := filepath.Join(config.Get().System.StoragePoolPath(pool), s.Uuid)
+
+	zap.S().Infow("migrating server data to storage pool", zap.String("server", s.Uuid), zap.String("pool", pool), zap.String("destination", dst))
+
+	for i := 0; i < storageMigrationPasses; i++ {
+		copied, err := syncDirectory(src, dst, false)
+		if err != nil {
+			return err
+		}
+
+		s.updateStorageMigration(func(m *StorageMigration) {
+			m.Passes++
+			m.Copied += copied
+		})
+
+		if copied == 0 {
+			break
+		}
+	}
+
+	s.updateStorageMigration(func(m *StorageMigration) {
+		m.Phase = StorageMigrationFinishing
+	})
+
+	running := s.State != ProcessOfflineState
+	if running {
+		s.PublishConsoleOutputFromDaemon("Server data is being moved to new storage, restarting the server to finish the move...")
+
+		if err := s.stopAndWait(remapStopTimeout, StopReasonRestart, "the server data is being moved to new storage"); err != nil {
+			return err
+		}
+	}
+
+	copied, err := syncDirectory(src, dst, true)
+	if err == nil {
+		s.updateStorageMigration(func(m *StorageMigration) {
+			m.Passes++
+			m.Copied += copied
+		})
+
+		s.StoragePool = pool
+		if _, err = s.WriteConfigurationToDisk(); err == nil {
+			err = s.Filesystem.Chown("/")
+		}
+	}
+
+	if err != nil {
+		s.StoragePool = previous
+	} else {
+		s.Filesystem.InvalidateCache()
+
+		go func() {
+			if err := os.RemoveAll(src); err != nil {
+				zap.S().Warnw("failed to remove server data from previous storage pool", zap.String("server", s.Uuid), zap.String("path", src), zap.Error(err))
+			}
+		}()
+	}
+
+	// The final phase needs to be complete before the server is able to start again.
+	s.updateStorageMigration(func(m *StorageMigration) {
+		m.Phase = StorageMigrationComplete
+	})
+
+	if running {
+		if serr := s.Environment.Start(); serr != nil {
+			zap.S().Errorw("failed to start server after migrating its data", zap.String("server", s.Uuid), zap.Error(serr))
+		}
+	}
+
+	return err
+}
+
+// Copies every file in the source directory that does not exist in the destination, or
+// differs in size or modification time, returning the number of bytes copied. If prune
+// is set any files in the destination that no longer exist in the source are removed.
+//
+// The server can change its files between passes, so anything in the destination that is
+// not the same type of file as the source is removed before it is replaced, and nothing in
+// either directory is ever followed through a symlink.
+func syncDirectory(src string, dst string, prune bool) (int64, error) {
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	var copied int64
+
+	err := filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			// Files removed by the server process while the copy is in progress are
+			// picked up by the next pass.
+			if os.IsNotExist(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		if p == src {
+			return nil
+		}
+
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		target := filepath.Join(dst, rel)
+
+		existing, err := lstatBeneath(dst, target)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+
+		if existing != nil && !sameFileType(existing, info) {
+			if err := removeBeneath(dst, target); err != nil {
+				return err
+			}
+
+			existing = nil
+		}
+
+		switch {
+		case info.IsDir():
+			d, err := mkdirAllBeneath(dst, target)
+			if err != nil {
+				return err
+			}
+			defer d.Close()
+
+			return errors.WithStack(d.Chmod(info.Mode().Perm()))
+		case info.Mode()&os.ModeSymlink != 0:
+			return copySymlink(src, p, dst, target)
+		case info.Mode().IsRegular():
+			if existing != nil && existing.Size == info.Size() && time.Unix(existing.Mtim.Unix()).Equal(info.ModTime()) {
+				return nil
+			}
+
+			if existing != nil {
+				if err := removeBeneath(dst, target); err != nil {
+					return err
+				}
+			}
+
+			n, err := copyFile(src, p, dst, target, info.Mode().Perm(), info.ModTime(), -1, -1)
+			copied += n
+
+			if os.IsNotExist(errors.Cause(err)) {
+				return nil
+			}
+
+			return err
+		}
+
+		return nil
+	})
+
+	if err != nil || !prune {
+		return copied, errors.WithStack(err)
+	}
+
+	err = filepath.Walk(dst, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dst, p)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+
+		if _, err := os.Lstat(filepath.Join(src, rel)); !os.IsNotExist(err) {
+			return nil
+		}
+
+		if err := removeBeneath(dst, p); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+
+		return nil
+	})
+
+	return copied, errors.WithStack(err)
+}
+
+// Determines if the file in the destination is the same type of file as the source.
+func sameFileType(st *unix.Stat_t, info os.FileInfo) bool {
+	switch st.Mode & unix.S_IFMT {
+	case unix.S_IFDIR:
+		return info.IsDir()
+	case unix.S_IFLNK:
+		return info.Mode()&os.ModeSymlink != 0
+	case unix.S_IFREG:
+		return info.Mode().IsRegular()
+	}
+
+	return false
+}
+
+// Copies a single regular file to a path that must not exist yet, setting its permissions
+// and modification time, and its owner if the uid is not negative. Neither file is opened
+// through a symlink, and the copy is written and changed using its open descriptor so it
+// cannot be swapped for another file part way through.
+func copyFile(srcRoot string, src string, dstRoot string, dst string, mode os.FileMode, modTime time.Time, uid int, gid int) (int64, error) {
+	in, _, err := openFileBeneath(srcRoot, src)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	dir, name, err := openParentBeneath(dstRoot, dst, false)
+	if err != nil {
+		return 0, err
+	}
+	defer unix.Close(dir)
+
+	fd, err := unix.Openat(dir, name, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, uint32(mode.Perm()))
+	if err != nil {
+		return 0, &os.PathError{Op: "open", Path: dst, Err: err}
+	}
+
+	out := os.NewFile(uintptr(fd), dst)
+	n, err := io.Copy(out, in)
+	if err == nil {
+		err = out.Chmod(mode.Perm())
+	}
+
+	if err == nil && uid >= 0 {
+		err = out.Chown(uid, gid)
+	}
+
+	if cerr := out.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return n, errors.WithStack(err)
+	}
+
+	ts := unix.NsecToTimespec(modTime.UnixNano())
+	if err := unix.UtimesNanoAt(dir, name, []unix.Timespec{ts, ts}, unix.AT_SYMLINK_NOFOLLOW); err != nil {
+		return n, &os.PathError{Op: "utimes", Path: dst, Err: err}
+	}
+
+	return n, nil
+}
+
+// Recreates a symlink at the destination, replacing anything already there that does not
+// point to the same place.
+func copySymlink(srcRoot string, src string, dstRoot string, dst string) error {
+	link, err := readlinkBeneath(srcRoot, src)
+	if err != nil {
+		return err
+	}
+
+	if existing, err := readlinkBeneath(dstRoot, dst); err == nil && existing == link {
+		return nil
+	}
+
+	if err := removeBeneath(dstRoot, dst); err != nil {
+		return err
+	}
+
+	return symlinkBeneath(dstRoot, link, dst)
+}
@@ -0,0 +1,418 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/tempdir"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The directory that server templates are stored in on the node.
+const templatesDirectory = "data/templates"
+
+var templateNameRegex = regexp.MustCompile(`^[\w-]{1,64}$`)
+
+// Returned when creating a template with a name that cannot be used.
+var ErrInvalidTemplateName = errors.New("template name must only contain letters, numbers, dashes and underscores")
+
+// A template is a snapshot of a server's data directory along with the details of the
+// egg and variables used by that server. Templates can be used to quickly instantiate new
+// servers with the same data without running a full installation process.
+type Template struct {
+	Name       string            `json:"name"`
+	Source     string            `json:"source"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Image      string            `json:"image"`
+	Invocation string            `json:"invocation"`
+	EnvVars    map[string]string `json:"environment"`
+	// The egg configuration of the server the template was created from.
+	Egg  *api.ProcessConfiguration `json:"egg"`
+	Size int64                     `json:"size"`
+}
+
+// The presigned URLs used to store a template in, or fetch a template from, an object
+// storage bucket. The URLs are generated by the Panel so that the node never needs the
+// credentials for the bucket.
+type TemplateObjectStorage struct {
+	ArchiveUrl  string `json:"archive_url"`
+	ManifestUrl string `json:"manifest_url"`
+}
+
+// Determines if the name can be used for a template.
+func ValidTemplateName(name string) bool {
+	return templateNameRegex.MatchString(name)
+}
+
+// Returns the path to the data archive for the template.
+func (t *Template) ArchivePath() string {
+	return filepath.Join(templatesDirectory, t.Name+".tar.gz")
+}
+
+// Returns the path to the manifest file for the template.
+func (t *Template) manifestPath() string {
+	return filepath.Join(templatesDirectory, t.Name+".json")
+}
+
+// Creates a new template from the server's current data directory and configuration. If
+// a template already exists with the given name it will be replaced.
+func (s *Server) CreateTemplate(name string) (*Template, error) {
+	if !ValidTemplateName(name) {
+		return nil, ErrInvalidTemplateName
+	}
+
+	if err := os.MkdirAll(templatesDirectory, 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	t := &Template{
+		Name:       name,
+		Source:     s.Uuid,
+		CreatedAt:  time.Now().UTC(),
+		Image:      s.Container.Image,
+		Invocation: s.Invocation,
+		EnvVars:    s.EnvVars,
+		Egg:        s.processConfiguration,
+	}
+
+	// Write the archive to a temporary file first so that a failure part way through does
+	// not destroy an existing template with the same name.
+	f, err := tempdir.File("template-")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.Filesystem.CompressDirectory("/", f); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+	f.Close()
+
+	if st, err := os.Stat(f.Name()); err == nil {
+		t.Size = st.Size()
+	}
+
+	if err := tempdir.Move(f.Name(), t.ArchivePath()); err != nil {
+		os.Remove(f.Name())
+
+		return nil, err
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := ioutil.WriteFile(t.manifestPath(), b, 0600); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return t, nil
+}
+
+// Returns all of the templates stored on this node.
+func GetTemplates() ([]*Template, error) {
+	files, err := ioutil.ReadDir(templatesDirectory)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Template{}, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	out := make([]*Template, 0)
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		t, err := GetTemplate(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+
+		out = append(out, t)
+	}
+
+	sort.SliceStable(out, func(i, j int) bool {
+		return out[i].Name < out[j].Name
+	})
+
+	return out, nil
+}
+
+// Returns a single template from the node by name.
+func GetTemplate(name string) (*Template, error) {
+	if !ValidTemplateName(name) {
+		return nil, os.ErrNotExist
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(templatesDirectory, name+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := new(Template)
+	if err := json.Unmarshal(b, t); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return t, nil
+}
+
+// Removes a template and its data archive from the node.
+func (t *Template) Delete() error {
+	if err := os.Remove(t.ArchivePath()); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Remove(t.manifestPath()))
+}
+
+// Uploads the archive and manifest of the template to object storage.
+func (t *Template) Upload(o TemplateObjectStorage) error {
+	f, err := os.Open(t.ArchivePath())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer f.Close()
+
+	if err := uploadTemplateFile(o.ArchiveUrl, f, t.Size, "application/gzip"); err != nil {
+		return errors.Wrap(err, "failed to upload template archive")
+	}
+
+	b, err := json.Marshal(t)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := uploadTemplateFile(o.ManifestUrl, bytes.NewReader(b), int64(len(b)), "application/json"); err != nil {
+		return errors.Wrap(err, "failed to upload template manifest")
+	}
+
+	return nil
+}
+
+func uploadTemplateFile(url string, r io.Reader, size int64, contentType string) error {
+	req, err := http.NewRequest(http.MethodPut, url, r)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	req.ContentLength = size
+	req.Header.Set("Content-Type", contentType)
+
+	res, err := downloadClient.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return errors.Errorf("received unexpected response status %s", res.Status)
+	}
+
+	return nil
+}
+
+// Extracts the contents of a template archive into the server's data directory, and
+// applies the image, startup command and variables of the template to the server. Files
+// in the archive replace any existing files at the same path, but files that are not in
+// the archive are left in place. The archive must be a gzip compressed tarball on the disk.
+//
+// Only the image, startup command and variables that differ from those of the server are
+// written to its local overrides, so that they are kept when the configuration is next
+// synced from the Panel, and everything else continues to follow the Panel. The
+// egg a server uses can only be changed by the Panel, so if it differs from the egg the
+// template was created from the server owner is warned instead.
+func (s *Server) ApplyTemplate(t *Template, archive string) error {
+	if s.State != ProcessOfflineState {
+		return errors.New("cannot apply a template to a server that is running")
+	}
+
+	if err := s.Filesystem.EnsureDataDirectory(); err != nil {
+		return err
+	}
+
+	s.PublishConsoleOutputFromDaemon("Deploying server data from template, this could take a few minutes...")
+	if err := s.extractArchiveTo(archive, s.Filesystem.Path()); err != nil {
+		return err
+	}
+
+	if err := s.applyTemplateSettings(t); err != nil {
+		return err
+	}
+
+	if t.Egg != nil && s.processConfiguration != nil && !reflect.DeepEqual(t.Egg, s.processConfiguration) {
+		zap.S().Warnw("template was created from a server using a different egg", zap.String("server", s.Uuid), zap.String("template", t.Name))
+
+		s.PublishConsoleOutputFromDaemon("Warning: this template was created from a server using a different egg configuration.")
+	}
+
+	s.PublishConsoleOutputFromDaemon("Finished deploying server data from template.")
+
+	// A server created from a template does not need to run an installation process,
+	// so mark it as installed on the Panel right away.
+	return s.SyncInstallState(true)
+}
+
+// Writes the image, startup command and variables of the template that differ from those
+// of the server to its local overrides and applies them. Nothing is written if the
+// template does not change anything.
+func (s *Server) applyTemplateSettings(t *Template) error {
+	o, err := s.GetOverrides()
+	if err != nil {
+		return err
+	} else if o == nil {
+		o = new(Overrides)
+	}
+
+	changed := false
+	if t.Image != "" && t.Image != s.Container.Image {
+		o.Image = t.Image
+		changed = true
+	}
+
+	if t.Invocation != "" && t.Invocation != s.Invocation {
+		o.Invocation = t.Invocation
+		changed = true
+	}
+
+	for k, v := range t.EnvVars {
+		if current, ok := s.EnvVars[k]; ok && current == v {
+			continue
+		}
+
+		if o.Environment == nil {
+			o.Environment = make(map[string]string)
+		}
+
+		o.Environment[k] = v
+		changed = true
+	}
+
+	if !changed {
+		return nil
+	}
+
+	if err := s.SaveOverrides(o); err != nil {
+		return err
+	}
+
+	s.applyOverrides()
+
+	_, err = s.WriteConfigurationToDisk()
+
+	return errors.WithStack(err)
+}
+
+// Applies a template stored on this node to the server.
+func (s *Server) ApplyLocalTemplate(name string) error {
+	t, err := GetTemplate(name)
+	if err != nil {
+		return err
+	}
+
+	return s.ApplyTemplate(t, t.ArchivePath())
+}
+
+// Fetches a template from a peer node and applies it to the server. The token provided
+// must be the authentication token for the remote node.
+func (s *Server) ApplyRemoteTemplate(remote string, token string, name string) error {
+	if !ValidTemplateName(name) {
+		return ErrInvalidTemplateName
+	}
+
+	base := fmt.Sprintf("%s/api/templates/%s", strings.TrimSuffix(remote, "/"), name)
+
+	return s.applyFetchedTemplate(base, base+"/download", token)
+}
+
+// Fetches a template from object storage and applies it to the server.
+func (s *Server) ApplyObjectStorageTemplate(o TemplateObjectStorage) error {
+	return s.applyFetchedTemplate(o.ManifestUrl, o.ArchiveUrl, "")
+}
+
+// Fetches the manifest and archive of a template and applies it to the server. The
+// archive is downloaded to a temporary file first, and the download is stopped if it
+// grows larger than the disk space of the server.
+func (s *Server) applyFetchedTemplate(manifest string, archive string, token string) error {
+	t := new(Template)
+	if err := fetchTemplateFile(manifest, token, func(r io.Reader) error {
+		return errors.WithStack(json.NewDecoder(io.LimitReader(r, 1024*1024)).Decode(t))
+	}); err != nil {
+		return errors.Wrap(err, "failed to fetch template manifest")
+	}
+
+	// The archive is only recognised as a tarball with the correct extension.
+	f, err := tempdir.File("template-*.tar.gz")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	var available int64 = -1
+	if limit := s.Build.DiskSpace; limit > 0 {
+		available = limit * 1000 * 1000
+	}
+
+	err = fetchTemplateFile(archive, token, func(r io.Reader) error {
+		w := &quotaWriter{
+			w:         f,
+			available: &available,
+			err:       &diskSpaceError{message: "the template archive is larger than the disk space available to the server"},
+		}
+
+		if _, err := io.Copy(w, r); err != nil {
+			f.Close()
+
+			return err
+		}
+
+		return errors.WithStack(f.Close())
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch template archive")
+	}
+
+	return s.ApplyTemplate(t, f.Name())
+}
+
+// Requests a template file and passes the body of the response to the function.
+func fetchTemplateFile(url string, token string, fn func(r io.Reader) error) error {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	res, err := downloadClient.Do(req)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return errors.Errorf("received unexpected response status %s", res.Status)
+	}
+
+	return fn(res.Body)
+}
@@ -0,0 +1,378 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"golang.org/x/sys/unix"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The directory within each storage pool that deleted files are moved to. Like snapshots
+// the trash is kept on the same disk as the server data, so that files are moved into it
+// without being copied.
+const trashDirectory = ".trash"
+
+// Returned when restoring an item from the trash to a path that already exists.
+var ErrTrashDestinationExists = errors.New("a file already exists at the destination")
+
+// A file or directory that was deleted from a server and can be restored.
+type TrashItem struct {
+	Id string `json:"id"`
+	// The path the item was deleted from.
+	Path      string    `json:"path"`
+	Directory bool      `json:"directory"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// The total size of the items in the trash of a server, which is loaded from the disk the
+// first time it is needed.
+type trashBin struct {
+	sync.Mutex
+	size   int64
+	loaded bool
+}
+
+// Determines if files deleted from the server are moved to the trash.
+func (s *Server) TrashEnabled() bool {
+	if s.Trash != nil {
+		return *s.Trash
+	}
+
+	return config.Get().System.Trash.Enabled
+}
+
+// Returns the directory the trash of the server is stored in.
+func (fs *Filesystem) trashPath() string {
+	return filepath.Join(fs.Root(), trashDirectory, fs.Server.Uuid)
+}
+
+// Removes a file or directory from the server, moving it to the trash if the trash is
+// enabled for the server. This is used for deletions through both the API and SFTP. If
+// the file cannot be moved to the trash it is left in place and a trash error returned,
+// since removing it anyway would defeat the point of the trash.
+func (fs *Filesystem) remove(cleaned string) error {
+	if !fs.Server.TrashEnabled() {
+		return os.RemoveAll(cleaned)
+	}
+
+	if err := fs.moveToTrash(cleaned); err != nil {
+		if IsTrashError(err) {
+			return err
+		}
+
+		zap.S().Warnw("failed to move file to trash", zap.String("server", fs.Server.Uuid), zap.String("path", cleaned), zap.Error(err))
+
+		return &trashError{message: fmt.Sprintf("\"%s\" could not be moved to the trash and was not deleted", strings.TrimPrefix(cleaned, fs.Path()))}
+	}
+
+	return nil
+}
+
+// Moves a file or directory into the trash of the server, writing the details needed to
+// restore it alongside. Once it has been moved the oldest items in the trash are removed
+// if there is not enough room for it. A trash error is returned if it would never fit.
+func (fs *Filesystem) moveToTrash(cleaned string) error {
+	st, err := os.Lstat(cleaned)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
+		return errors.WithStack(err)
+	}
+
+	_, size, err := treeSize(cleaned)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	item := &TrashItem{
+		Id:        uuid.New().String(),
+		Path:      filepath.ToSlash(strings.TrimPrefix(cleaned, fs.Path())),
+		Directory: st.IsDir(),
+		Size:      size,
+		DeletedAt: time.Now().UTC(),
+	}
+	item.ExpiresAt = item.DeletedAt.Add(time.Duration(fs.Configuration.Trash.Retention) * time.Hour)
+
+	limit := fs.Configuration.Trash.MaxSize * 1000 * 1000
+	if limit > 0 && item.Size > limit {
+		return &trashError{message: fmt.Sprintf("\"%s\" is larger than the %d MB trash and was not deleted", item.Path, fs.Configuration.Trash.MaxSize)}
+	}
+
+	dir := fs.trashPath()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.WithStack(err)
+	}
+
+	b, err := json.Marshal(item)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// The file is moved using its parent directory opened without following symlinks, so
+	// that the server cannot swap a directory along the path for a symlink and have a file
+	// from elsewhere on the host moved into its trash.
+	parent, name, err := openParentBeneath(fs.Path(), cleaned, false)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(parent)
+
+	if err := unix.Renameat(parent, name, unix.AT_FDCWD, filepath.Join(dir, item.Id)); err != nil {
+		return &os.LinkError{Op: "rename", Old: cleaned, New: filepath.Join(dir, item.Id), Err: err}
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(dir, item.Id+".json"), b, 0600); err != nil {
+		os.RemoveAll(filepath.Join(dir, item.Id))
+
+		return errors.WithStack(err)
+	}
+
+	fs.adjustTrashSize(item.Size)
+
+	if limit > 0 {
+		fs.evictTrash(limit)
+	}
+
+	return nil
+}
+
+// Returns the items in the trash of the server, the most recently deleted first.
+func (fs *Filesystem) TrashItems() ([]TrashItem, error) {
+	files, err := ioutil.ReadDir(fs.trashPath())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, errors.WithStack(err)
+	}
+
+	out := []TrashItem{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		b, err := ioutil.ReadFile(filepath.Join(fs.trashPath(), f.Name()))
+		if err != nil {
+			continue
+		}
+
+		var item TrashItem
+		if err := json.Unmarshal(b, &item); err != nil {
+			continue
+		}
+
+		out = append(out, item)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].DeletedAt.After(out[j].DeletedAt)
+	})
+
+	return out, nil
+}
+
+// Returns a single item in the trash of the server, or an error satisfying os.IsNotExist
+// if there is no item with the id.
+func (fs *Filesystem) TrashItem(id string) (*TrashItem, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(fs.trashPath(), id+".json"))
+	if err != nil {
+		return nil, err
+	}
+
+	item := &TrashItem{}
+	if err := json.Unmarshal(b, item); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return item, nil
+}
+
+// Restores an item from the trash to the path it was deleted from, or to the location
+// provided. An error is returned if a file already exists at the destination.
+func (fs *Filesystem) RestoreTrashItem(id string, location string) (*TrashItem, error) {
+	item, err := fs.TrashItem(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if location == "" {
+		location = item.Path
+	}
+
+	dest, err := fs.SafePath(location)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if dest == fs.Path() {
+		return nil, errors.New("cannot restore over the root server directory")
+	}
+
+	if _, err := os.Lstat(dest); err == nil {
+		return nil, ErrTrashDestinationExists
+	}
+
+	if err := fs.checkFileRulesCopy(filepath.Join(fs.trashPath(), item.Id), dest); err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := os.Rename(filepath.Join(fs.trashPath(), item.Id), dest); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	os.Remove(filepath.Join(fs.trashPath(), item.Id+".json"))
+
+	fs.adjustTrashSize(-item.Size)
+	fs.InvalidateCache()
+
+	item.Path = filepath.ToSlash(strings.TrimPrefix(dest, fs.Path()))
+
+	return item, nil
+}
+
+// Permanently removes an item from the trash of the server.
+func (fs *Filesystem) DeleteTrashItem(id string) error {
+	item, err := fs.TrashItem(id)
+	if err != nil {
+		return err
+	}
+
+	fs.removeTrashItem(item)
+
+	return nil
+}
+
+// Permanently removes every item in the trash of the server.
+func (fs *Filesystem) EmptyTrash() error {
+	fs.Server.trash.Lock()
+	defer fs.Server.trash.Unlock()
+
+	if err := os.RemoveAll(fs.trashPath()); err != nil {
+		return errors.WithStack(err)
+	}
+
+	fs.Server.trash.size = 0
+	fs.Server.trash.loaded = true
+
+	return nil
+}
+
+// Permanently removes an item and its details from the trash. Failures are only logged,
+// since the item will be removed again when it expires.
+func (fs *Filesystem) removeTrashItem(item *TrashItem) {
+	if err := os.RemoveAll(filepath.Join(fs.trashPath(), item.Id)); err != nil {
+		zap.S().Warnw("failed to remove item from trash", zap.String("server", fs.Server.Uuid), zap.String("item", item.Id), zap.Error(err))
+		return
+	}
+
+	os.Remove(filepath.Join(fs.trashPath(), item.Id+".json"))
+
+	fs.adjustTrashSize(-item.Size)
+}
+
+// Removes the oldest items from the trash until it uses no more than the given number of
+// bytes.
+func (fs *Filesystem) evictTrash(max int64) {
+	if fs.trashSize() <= max {
+		return
+	}
+
+	items, err := fs.TrashItems()
+	if err != nil {
+		return
+	}
+
+	for i := len(items) - 1; i >= 0 && fs.trashSize() > max; i-- {
+		fs.removeTrashItem(&items[i])
+	}
+}
+
+// Removes the items in the trash of the server that have expired.
+func (fs *Filesystem) pruneTrash() {
+	items, err := fs.TrashItems()
+	if err != nil {
+		zap.S().Warnw("failed to read trash of server", zap.String("server", fs.Server.Uuid), zap.Error(err))
+		return
+	}
+
+	for i := range items {
+		if time.Now().After(items[i].ExpiresAt) {
+			fs.removeTrashItem(&items[i])
+		}
+	}
+}
+
+// Returns the number of bytes used by the items in the trash of the server.
+func (fs *Filesystem) trashSize() int64 {
+	fs.Server.trash.Lock()
+	loaded := fs.Server.trash.loaded
+	fs.Server.trash.Unlock()
+
+	if !loaded {
+		items, _ := fs.TrashItems()
+
+		var size int64
+		for _, item := range items {
+			size += item.Size
+		}
+
+		fs.Server.trash.Lock()
+		if !fs.Server.trash.loaded {
+			fs.Server.trash.size = size
+			fs.Server.trash.loaded = true
+		}
+		fs.Server.trash.Unlock()
+	}
+
+	fs.Server.trash.Lock()
+	defer fs.Server.trash.Unlock()
+
+	return fs.Server.trash.size
+}
+
+// Adds the number of bytes to the tracked size of the trash, which may be negative when an
+// item is removed from it.
+func (fs *Filesystem) adjustTrashSize(delta int64) {
+	fs.trashSize()
+
+	fs.Server.trash.Lock()
+	fs.Server.trash.size += delta
+	fs.Server.trash.Unlock()
+}
+
+// Starts the background routine that removes expired items from the trash of each server.
+func StartTrashCleanup() {
+	go supervisor.Supervise("trash cleanup", func() error {
+		ticker := time.NewTicker(time.Minute * 10)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, s := range GetServers().All() {
+				s.Filesystem.pruneTrash()
+			}
+		}
+
+		return nil
+	})
+}
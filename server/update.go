@@ -7,6 +7,7 @@ import (
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 	"os"
+	"reflect"
 )
 
 // Merges data passed through in JSON form into the existing server object.
@@ -29,6 +30,13 @@ func (s *Server) UpdateDataStructure(data []byte, background bool) error {
 		return errors.New("attempting to merge a data stack with an invalid UUID")
 	}
 
+	previous := s.Allocations.Bindings()
+	previousRanges := append([]PortRange(nil), s.Allocations.Ranges...)
+
+	// The changes are merged into the configuration from the Panel, the local overrides
+	// are applied again once it has been saved.
+	s.removeOverrides()
+
 	// Merge the new data object that we have received with the existing server data object
 	// and then save it to the disk so it is persistent.
 	if err := mergo.Merge(s, src, mergo.WithOverride); err != nil {
@@ -55,6 +63,29 @@ func (s *Server) UpdateDataStructure(data []byte, background bool) error {
 		s.Suspended = v
 	}
 
+	// A zero idle timeout disables idle shutdowns, which mergo would otherwise ignore.
+	if v, err := jsonparser.GetInt(data, "idle_timeout"); err != nil {
+		if err != jsonparser.KeyPathNotFoundError {
+			return errors.WithStack(err)
+		}
+	} else {
+		s.IdleTimeout = int(v)
+	}
+
+	// Removing the owner of a server needs to clear it, which mergo would otherwise ignore.
+	if v, err := jsonparser.GetString(data, "owner"); err != nil {
+		if err != jsonparser.KeyPathNotFoundError {
+			return errors.WithStack(err)
+		}
+	} else {
+		s.Owner = v
+	}
+
+	// Setting the trash to null returns the server to the node default.
+	if _, _, _, err := jsonparser.Get(data, "trash"); err == nil {
+		s.Trash = src.Trash
+	}
+
 	// Environment and Mappings should be treated as a full update at all times, never a
 	// true patch, otherwise we can't know what we're passing along.
 	if src.EnvVars != nil && len(src.EnvVars) > 0 {
@@ -65,12 +96,43 @@ func (s *Server) UpdateDataStructure(data []byte, background bool) error {
 		s.Allocations.Mappings = src.Allocations.Mappings
 	}
 
+	// Allocation pairs are replaced as a whole so that removing a pair in the Panel removes
+	// it from the node.
+	if _, _, _, err := jsonparser.Get(data, "allocations", "pairs"); err == nil {
+		s.Allocations.Pairs = src.Allocations.Pairs
+	}
+
+	// Port ranges are also replaced as a whole.
+	if _, _, _, err := jsonparser.Get(data, "allocations", "ranges"); err == nil {
+		s.Allocations.Ranges = src.Allocations.Ranges
+	}
+
+	// Mounts are also a full update, and an empty list removes all of the additional
+	// mounts from the server.
+	if _, _, _, err := jsonparser.Get(data, "mounts"); err == nil {
+		s.Mounts = src.Mounts
+	}
+
+	// Macros are replaced as a whole so that removing a macro in the Panel removes it
+	// from the node.
+	if _, _, _, err := jsonparser.Get(data, "macros"); err == nil {
+		s.Macros = src.Macros
+	}
+
+	// Apply the local overrides before anything else uses the new configuration. They are
+	// never written to the disk, only the values from the Panel are stored.
+	s.applyOverrides()
+
 	if _, err := s.WriteConfigurationToDisk(); err != nil {
 		return errors.WithStack(err)
 	}
 
 	if background {
 		s.runBackgroundActions()
+
+		if !mappingsEqual(previous, s.Allocations.Bindings()) || !reflect.DeepEqual(previousRanges, s.Allocations.Ranges) {
+			go s.remapAllocations(previous)
+		}
 	}
 
 	return nil
@@ -101,6 +163,8 @@ func (s *Server) runBackgroundActions() {
 		if server.Suspended && server.State != ProcessOfflineState {
 			zap.S().Infow("server suspended with running process state, terminating now", zap.String("server", server.Uuid))
 
+			server.SetStopReason(StopReasonSuspended, "the server was suspended")
+
 			if err := server.Environment.Terminate(os.Kill); err != nil {
 				zap.S().Warnw(
 					"failed to terminate server environment after seeing suspension",
@@ -0,0 +1,482 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/parser"
+	"github.com/pterodactyl/wings/supervisor"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The directory within each storage pool that partial uploads are written to. Keeping
+// them on the same filesystem as the server data means a finished upload can be moved
+// into place without copying it.
+const uploadsDirectory = ".uploads"
+
+// A file being uploaded to a server in chunks. The upload can be resumed from its offset
+// after a failed chunk, or after the daemon is restarted.
+type Upload struct {
+	Id     string `json:"id"`
+	Server string `json:"server"`
+	// The file the upload is written to once complete, relative to the server data
+	// directory.
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// The number of bytes received so far. The next chunk must start at this offset.
+	Offset int64 `json:"offset"`
+	// The SHA-256 checksum the complete file must match. If not provided the checksum of
+	// the file is only reported once the upload is complete.
+	Checksum  string    `json:"checksum,omitempty"`
+	Complete  bool      `json:"complete"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// The state of the checksum of the bytes received so far, so that the checksum does
+	// not need to be calculated again from the start when an upload is resumed.
+	HashState []byte `json:"hash_state,omitempty"`
+}
+
+// Returned when a chunk does not start at the offset of the upload, which happens when a
+// client retries a chunk that was already received or skips ahead.
+type uploadOffsetError struct {
+	offset int64
+}
+
+func (e *uploadOffsetError) Error() string {
+	return "chunk does not start at the current offset of the upload"
+}
+
+// Returns the offset the next chunk of the upload must start at if the error is caused by
+// a chunk starting at the wrong offset.
+func UploadOffsetFromError(err error) (int64, bool) {
+	if e, ok := errors.Cause(err).(*uploadOffsetError); ok {
+		return e.offset, true
+	}
+
+	return 0, false
+}
+
+// Returned when the checksum of a complete upload does not match the expected checksum.
+type uploadChecksumError struct {
+	expected string
+	actual   string
+}
+
+func (e *uploadChecksumError) Error() string {
+	return "checksum of the uploaded file " + e.actual + " does not match the expected checksum " + e.expected
+}
+
+func IsUploadChecksumError(err error) bool {
+	_, ok := errors.Cause(err).(*uploadChecksumError)
+
+	return ok
+}
+
+// Returned when a chunk is sent for an upload that is still receiving another chunk.
+type uploadBusyError struct {
+}
+
+func (e *uploadBusyError) Error() string {
+	return "another chunk is already being written to the upload"
+}
+
+func IsUploadBusyError(err error) bool {
+	_, ok := errors.Cause(err).(*uploadBusyError)
+
+	return ok
+}
+
+var uploads = struct {
+	sync.Mutex
+	// The uploads that are currently receiving a chunk. Only one chunk can be written to
+	// an upload at a time.
+	busy map[string]bool
+}{busy: make(map[string]bool)}
+
+// Returns the directory that partial uploads for the server are written to.
+func (s *Server) uploadsPath() string {
+	return filepath.Join(s.Filesystem.Root(), uploadsDirectory, s.Uuid)
+}
+
+func (s *Server) uploadDataPath(id string) string {
+	return filepath.Join(s.uploadsPath(), id+".part")
+}
+
+func (s *Server) uploadMetaPath(id string) string {
+	return filepath.Join(s.uploadsPath(), id+".json")
+}
+
+// Starts a new upload to the given path. The space needed for the file, along with any
+// other uploads still in progress for the server, must be available before the upload
+// is accepted so that a large upload does not fail once most of it has been sent.
+func (s *Server) CreateUpload(p string, size int64, checksum string) (*Upload, error) {
+	if size < 0 {
+		return nil, errors.New("upload size cannot be negative")
+	}
+
+	checksum = strings.ToLower(checksum)
+	if checksum != "" {
+		if b, err := hex.DecodeString(checksum); err != nil || len(b) != sha256.Size {
+			return nil, errors.New("checksum must be a hex encoded SHA-256 checksum")
+		}
+	}
+
+	cleaned, err := s.Filesystem.SafePath(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if st, err := os.Stat(cleaned); err == nil && st.IsDir() {
+		return nil, errors.New("cannot use a directory as a file for writing")
+	}
+
+	if err := s.Filesystem.checkFileRules(cleaned, FileActionWrite); err != nil {
+		return nil, err
+	}
+
+	s.removeExpiredUploads()
+
+	pending, err := s.Uploads()
+	if err != nil {
+		return nil, err
+	}
+
+	needed := size
+	for _, u := range pending {
+		needed += u.Size
+	}
+
+	if !s.Filesystem.hasSpaceFor(needed) {
+		return nil, &diskSpaceError{message: "the upload would exceed the disk space available to the server"}
+	}
+
+	if err := os.MkdirAll(s.uploadsPath(), 0700); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	now := time.Now().UTC()
+	u := &Upload{
+		Id:        uuid.New().String(),
+		Server:    s.Uuid,
+		Path:      strings.TrimPrefix(strings.TrimPrefix(cleaned, s.Filesystem.Path()), "/"),
+		Size:      size,
+		Checksum:  checksum,
+		CreatedAt: now,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(uploadExpiry()),
+	}
+
+	h := sha256.New()
+	if u.HashState, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := ioutil.WriteFile(s.uploadDataPath(u.Id), nil, 0600); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := s.saveUpload(u); err != nil {
+		return nil, err
+	}
+
+	// An empty file is complete as soon as it is created.
+	if size == 0 {
+		return s.finishUpload(u, h)
+	}
+
+	return u, nil
+}
+
+// Returns the uploads in progress for the server.
+func (s *Server) Uploads() ([]*Upload, error) {
+	files, err := ioutil.ReadDir(s.uploadsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []*Upload{}, nil
+		}
+
+		return nil, errors.WithStack(err)
+	}
+
+	out := []*Upload{}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		u, err := s.GetUpload(strings.TrimSuffix(f.Name(), ".json"))
+		if err != nil {
+			continue
+		}
+
+		out = append(out, u)
+	}
+
+	return out, nil
+}
+
+// Returns a single upload for the server, or an error satisfying os.IsNotExist if there
+// is no upload with the id.
+func (s *Server) GetUpload(id string) (*Upload, error) {
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, os.ErrNotExist
+	}
+
+	b, err := ioutil.ReadFile(s.uploadMetaPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Upload{}
+	if err := json.Unmarshal(b, u); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return u, nil
+}
+
+func (s *Server) saveUpload(u *Upload) error {
+	b, err := json.Marshal(u)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Write to a temporary file first so that a crash while saving never leaves behind
+	// metadata that cannot be read.
+	tmp := s.uploadMetaPath(u.Id) + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Rename(tmp, s.uploadMetaPath(u.Id)))
+}
+
+// Writes a chunk of the upload starting at the given offset. The bytes received are kept
+// even if the chunk is cut short, so that the client can resume from the new offset.
+// Once every byte has been received the checksum is verified and the file is moved into
+// place.
+func (s *Server) WriteUploadChunk(id string, offset int64, r io.Reader) (*Upload, error) {
+	uploads.Lock()
+	if uploads.busy[id] {
+		uploads.Unlock()
+		return nil, &uploadBusyError{}
+	}
+	uploads.busy[id] = true
+	uploads.Unlock()
+
+	defer func() {
+		uploads.Lock()
+		delete(uploads.busy, id)
+		uploads.Unlock()
+	}()
+
+	u, err := s.GetUpload(id)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset != u.Offset {
+		return nil, &uploadOffsetError{offset: u.Offset}
+	}
+
+	h := sha256.New()
+	if err := h.(encoding.BinaryUnmarshaler).UnmarshalBinary(u.HashState); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	f, err := os.OpenFile(s.uploadDataPath(id), os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer f.Close()
+
+	// Anything after the offset was written by a chunk that failed before it could be
+	// recorded, so it is discarded.
+	if err := f.Truncate(u.Offset); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if _, err := f.Seek(u.Offset, io.SeekStart); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	rerr := s.copyUploadChunk(u, f, h, io.LimitReader(r, u.Size-u.Offset))
+
+	if err := f.Sync(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if u.HashState, err = h.(encoding.BinaryMarshaler).MarshalBinary(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	u.UpdatedAt = time.Now().UTC()
+	u.ExpiresAt = u.UpdatedAt.Add(uploadExpiry())
+
+	if err := s.saveUpload(u); err != nil {
+		return nil, err
+	}
+
+	if rerr != nil {
+		return u, rerr
+	}
+
+	if u.Offset < u.Size {
+		return u, nil
+	}
+
+	f.Close()
+
+	return s.finishUpload(u, h)
+}
+
+// Copies the chunk to the file, only adding bytes to the checksum and the offset of the
+// upload once they have been written.
+func (s *Server) copyUploadChunk(u *Upload, f *os.File, h hash.Hash, r io.Reader) error {
+	buf := make([]byte, 1024*128)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			if _, werr := f.Write(buf[:n]); werr != nil {
+				f.Truncate(u.Offset)
+
+				return errors.WithStack(werr)
+			}
+
+			h.Write(buf[:n])
+			u.Offset += int64(n)
+		}
+
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.WithStack(err)
+		}
+	}
+}
+
+// Verifies the checksum of a complete upload and moves it to its destination, replacing
+// any existing file.
+func (s *Server) finishUpload(u *Upload, h hash.Hash) (*Upload, error) {
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	if u.Checksum != "" && sum != u.Checksum {
+		s.RemoveUpload(u.Id)
+
+		return nil, &uploadChecksumError{expected: u.Checksum, actual: sum}
+	}
+
+	dst, err := s.Filesystem.SafePath(u.Path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if err := s.Filesystem.Chown(filepath.Dir(dst)); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// Hold the same lock used by the configuration parsers so that the file is never
+	// replaced while the parser is updating it.
+	unlock := parser.LockFile(dst)
+	err = os.Rename(s.uploadDataPath(u.Id), dst)
+	unlock()
+
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	os.Chmod(dst, 0644)
+	if err := s.Filesystem.Chown(dst); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	os.Remove(s.uploadMetaPath(u.Id))
+
+	s.Filesystem.InvalidateCache()
+
+	u.Complete = true
+	u.Checksum = sum
+	u.HashState = nil
+
+	return u, nil
+}
+
+// Cancels an upload, removing the data received so far.
+func (s *Server) RemoveUpload(id string) error {
+	if _, err := uuid.Parse(id); err != nil {
+		return os.ErrNotExist
+	}
+
+	if err := os.Remove(s.uploadMetaPath(id)); err != nil {
+		return err
+	}
+
+	if err := os.Remove(s.uploadDataPath(id)); err != nil && !os.IsNotExist(err) {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Removes the uploads for the server that have not received a chunk within the expiry
+// time.
+func (s *Server) removeExpiredUploads() {
+	pending, err := s.Uploads()
+	if err != nil {
+		return
+	}
+
+	for _, u := range pending {
+		if time.Now().After(u.ExpiresAt) {
+			s.RemoveUpload(u.Id)
+		}
+	}
+}
+
+// Starts the background routine that removes the expired uploads of each server, so that
+// abandoned uploads do not take up disk space until another upload is started.
+func StartUploadCleanup() {
+	go supervisor.Supervise("upload cleanup", func() error {
+		ticker := time.NewTicker(time.Minute * 10)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			for _, s := range GetServers().All() {
+				s.removeExpiredUploads()
+			}
+		}
+
+		return nil
+	})
+}
+
+// Removes every upload in progress for the server.
+func (s *Server) RemoveUploads() error {
+	if err := os.RemoveAll(s.uploadsPath()); err != nil {
+		return errors.WithStack(err)
+	}
+
+	return nil
+}
+
+// Returns the time an upload is kept for after it last received a chunk.
+func uploadExpiry() time.Duration {
+	return time.Duration(config.Get().Api.UploadExpiry) * time.Hour
+}
@@ -0,0 +1,349 @@
+package sftp
+
+import (
+	"github.com/pkg/sftp"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Handles the SFTP requests for a single session, performing each action against the
+// filesystem of the server the user logged in to.
+type Handler struct {
+	server      *server.Server
+	permissions []string
+	readOnly    bool
+	logger      *zap.SugaredLogger
+	mu          sync.Mutex
+}
+
+// Returns a new handler for a session with the given permissions on the server.
+func NewHandler(s *server.Server, permissions []string, readOnly bool) *Handler {
+	return &Handler{
+		server:      s,
+		permissions: permissions,
+		readOnly:    readOnly,
+		logger:      zap.S().Named("sftp").With(zap.String("server", s.Uuid)),
+	}
+}
+
+// Returns the handlers that the SFTP request server sends each type of request to.
+func (h *Handler) Handlers() sftp.Handlers {
+	return sftp.Handlers{
+		FileGet:  h,
+		FilePut:  h,
+		FileCmd:  h,
+		FileList: h,
+	}
+}
+
+// Returns the full path on the disk for a path requested by the client, ensuring that it
+// is within the data directory of the server.
+func (h *Handler) buildPath(p string) (string, error) {
+	return validatePath(h.server, p)
+}
+
+// Opens a file on the server for reading.
+func (h *Handler) Fileread(request *sftp.Request) (io.ReaderAt, error) {
+	// This permission is named poorly, but it is what the Panel uses to determine if the
+	// user can open and view a file. Saving changes to a file uses "save-files".
+	if !h.can("edit-files") {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	p, err := h.buildPath(request.Filepath)
+	if err != nil {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		h.logger.Errorw("could not open file for reading", zap.String("source", p), zap.Error(err))
+		return nil, sftp.ErrSshFxFailure
+	}
+
+	return file, nil
+}
+
+// Opens a file on the server for writing, creating it and the directories leading up to
+// it if it does not exist yet.
+func (h *Handler) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	if h.readOnly {
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+
+	p, err := h.buildPath(request.Filepath)
+	if err != nil {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	if !h.allowed(p, server.FileActionWrite) {
+		return nil, sftp.ErrSshFxPermissionDenied
+	}
+
+	// If the server does not have enough space left refuse the write, since the file
+	// would only take it further over its limit.
+	if !config.Get().System.Sftp.DisableDiskChecking && !h.server.Filesystem.HasSpaceAvailable() {
+		h.logger.Infow("denying file write due to space limit")
+		return nil, sftp.ErrSshFxFailure
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	st, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		if !h.can("create-files") {
+			return nil, sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			h.logger.Errorw("error making path for file", zap.String("source", p), zap.Error(err))
+			return nil, sftp.ErrSshFxFailure
+		}
+	} else if err != nil {
+		h.logger.Errorw("error performing file stat", zap.String("source", p), zap.Error(err))
+		return nil, sftp.ErrSshFxFailure
+	} else if !h.can("save-files") {
+		return nil, sftp.ErrSshFxPermissionDenied
+	} else if st.IsDir() {
+		return nil, sftp.ErrSshFxOpUnsupported
+	}
+
+	file, err := os.Create(p)
+	if err != nil {
+		h.logger.Errorw("error opening file for writing", zap.Uint32("flags", request.Flags), zap.String("source", p), zap.Error(err))
+		return nil, sftp.ErrSshFxFailure
+	}
+
+	// Not failing here is intentional, the file was still written but is owned by the
+	// wrong user which will likely cause issues later on. The open file is changed rather
+	// than the path, so a symlink swapped in at the path is never followed.
+	u := config.Get().System.User
+	if err := file.Chown(u.Uid, u.Gid); err != nil {
+		h.logger.Warnw("error chowning file", zap.String("file", p), zap.Error(err))
+	}
+
+	return &writtenFile{File: file, server: h.server}, nil
+}
+
+// A file being written by the client. Once the client closes the file the cached sizes
+// and listings for the server are removed, since they no longer match the disk.
+type writtenFile struct {
+	*os.File
+	server *server.Server
+}
+
+func (f *writtenFile) Close() error {
+	err := f.File.Close()
+	f.server.Filesystem.InvalidateCache()
+
+	return err
+}
+
+// Handles the requests that act on a file without reading or writing its contents.
+func (h *Handler) Filecmd(request *sftp.Request) error {
+	if h.readOnly {
+		return sftp.ErrSshFxOpUnsupported
+	}
+
+	p, err := h.buildPath(request.Filepath)
+	if err != nil {
+		return sftp.ErrSshFxNoSuchFile
+	}
+
+	var target string
+	if request.Target != "" {
+		target, err = h.buildPath(request.Target)
+		if err != nil {
+			return sftp.ErrSshFxOpUnsupported
+		}
+	}
+
+	switch request.Method {
+	case "Setstat":
+		if !h.allowed(p, server.FileActionWrite) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		var mode os.FileMode = 0644
+		if request.Attributes().FileMode().Perm() != 0000 {
+			mode = request.Attributes().FileMode().Perm()
+		}
+
+		if request.Attributes().FileMode().IsDir() {
+			mode = 0755
+		}
+
+		if err := os.Chmod(p, h.server.Filesystem.FileRuleMode(p, mode)); err != nil {
+			h.logger.Errorw("failed to perform setstat", zap.String("source", p), zap.Error(err))
+			return sftp.ErrSshFxFailure
+		}
+
+		return nil
+	case "Rename":
+		if !h.can("move-files") {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		// Moves go through the filesystem of the server so that the file rules are checked
+		// for everything within a directory being moved, not only the directory itself.
+		if err := h.server.Filesystem.Rename(request.Filepath, request.Target); err != nil {
+			if server.IsFileRuleError(err) {
+				return sftp.ErrSshFxPermissionDenied
+			}
+
+			h.logger.Errorw("failed to rename file", zap.String("source", p), zap.String("target", target), zap.Error(err))
+			return sftp.ErrSshFxFailure
+		}
+	case "Rmdir", "Remove":
+		if !h.can("delete-files") {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if _, err := os.Lstat(p); os.IsNotExist(err) {
+			return sftp.ErrSshFxNoSuchFile
+		}
+
+		// Deletes go through the filesystem of the server so that the file is moved to the
+		// trash, the same as a file deleted through the API.
+		if err := h.server.Filesystem.Delete(request.Filepath); err != nil {
+			if server.IsFileRuleError(err) {
+				return sftp.ErrSshFxPermissionDenied
+			}
+
+			h.logger.Errorw("failed to remove file", zap.String("source", p), zap.Error(err))
+			return sftp.ErrSshFxFailure
+		}
+
+		return sftp.ErrSshFxOk
+	case "Mkdir":
+		if !h.can("create-files") || !h.allowed(p, server.FileActionWrite) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := os.MkdirAll(p, 0755); err != nil {
+			h.logger.Errorw("failed to create directory", zap.String("source", p), zap.Error(err))
+			return sftp.ErrSshFxFailure
+		}
+	case "Symlink":
+		if !h.can("create-files") || !h.allowed(target, server.FileActionWrite) {
+			return sftp.ErrSshFxPermissionDenied
+		}
+
+		if err := os.Symlink(p, target); err != nil {
+			h.logger.Errorw("failed to create symlink", zap.String("source", p), zap.String("target", target), zap.Error(err))
+			return sftp.ErrSshFxFailure
+		}
+	default:
+		return sftp.ErrSshFxOpUnsupported
+	}
+
+	h.server.Filesystem.InvalidateCache()
+
+	if target != "" {
+		h.chown(target)
+	} else {
+		h.chown(p)
+	}
+
+	return sftp.ErrSshFxOk
+}
+
+// Handles listing the contents of a directory and returning information about a file.
+func (h *Handler) Filelist(request *sftp.Request) (sftp.ListerAt, error) {
+	p, err := h.buildPath(request.Filepath)
+	if err != nil {
+		return nil, sftp.ErrSshFxNoSuchFile
+	}
+
+	switch request.Method {
+	case "List":
+		if !h.can("list-files") {
+			return nil, sftp.ErrSshFxPermissionDenied
+		}
+
+		files, err := ioutil.ReadDir(p)
+		if err != nil {
+			h.logger.Errorw("error listing directory", zap.String("source", p), zap.Error(err))
+			return nil, sftp.ErrSshFxFailure
+		}
+
+		return listerAt(files), nil
+	case "Stat":
+		if !h.can("list-files") {
+			return nil, sftp.ErrSshFxPermissionDenied
+		}
+
+		st, err := os.Stat(p)
+		if os.IsNotExist(err) {
+			return nil, sftp.ErrSshFxNoSuchFile
+		} else if err != nil {
+			h.logger.Errorw("error running stat on file", zap.String("source", p), zap.Error(err))
+			return nil, sftp.ErrSshFxFailure
+		}
+
+		return listerAt([]os.FileInfo{st}), nil
+	}
+
+	// Reading links is not supported since they could point outside of the data directory
+	// of the server.
+	return nil, sftp.ErrSshFxOpUnsupported
+}
+
+// Sets the owner of a file to the user the server runs as. Failures are only logged since
+// the action itself has already been performed. Symlinks are never followed, a symlink
+// has its own owner changed rather than the file it points to.
+func (h *Handler) chown(p string) {
+	u := config.Get().System.User
+	if err := os.Lchown(p, u.Uid, u.Gid); err != nil {
+		h.logger.Warnw("error chowning file", zap.String("file", p), zap.Error(err))
+	}
+}
+
+// Determines if the file rules of the server allow the action on the path. Reading and
+// listing files is never restricted by the rules.
+func (h *Handler) allowed(p string, action string) bool {
+	return h.server.Filesystem.FileActionAllowed(p, action)
+}
+
+// Determines if the user has the permission on the server, as returned by the Panel when
+// they logged in. Server owners and administrators are granted every permission with "*".
+func (h *Handler) can(permission string) bool {
+	for _, p := range h.permissions {
+		if p == "*" || p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A list of files returned to the client, implementing the sftp.ListerAt interface.
+type listerAt []os.FileInfo
+
+// Copies the entries from the offset into the slice, returning io.EOF once the end of the
+// list has been reached.
+func (l listerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+
+	if n := copy(f, l[offset:]); n < len(f) {
+		return n, io.EOF
+	} else {
+		return n, nil
+	}
+}
@@ -1,75 +1,293 @@
 package sftp
 
 import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
 	"github.com/pkg/errors"
+	"github.com/pkg/sftp"
 	"github.com/pterodactyl/sftp-server"
 	"github.com/pterodactyl/wings/api"
+	"github.com/pterodactyl/wings/audit"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/metrics"
 	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/supervisor"
 	"go.uber.org/zap"
+	"golang.org/x/crypto/ssh"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
-func Initialize(config *config.Configuration) error {
-	c := &sftp_server.Server{
-		User: sftp_server.SftpUser{
-			Uid: config.System.User.Uid,
-			Gid: config.System.User.Gid,
-		},
-		Settings: sftp_server.Settings{
-			BasePath:         config.System.Data,
-			ReadOnly:         config.System.Sftp.ReadOnly,
-			BindAddress:      config.System.Sftp.Address,
-			BindPort:         config.System.Sftp.Port,
-			ServerDataFolder: path.Join(config.System.Data, "/servers"),
-			DisableDiskCheck: config.System.Sftp.DisableDiskChecking,
-		},
-		CredentialValidator: validateCredentials,
-		PathValidator: validatePath,
-		DiskSpaceValidator: validateDiskSpace,
-	}
+// The SFTP server for the daemon, which authenticates users against the Panel and gives
+// them access to the files of the server they logged in to.
+type Server struct {
+	config *config.Configuration
+
+	// The socket passed by systemd to accept connections on, if the daemon was socket
+	// activated. Otherwise the configured address is bound.
+	listener net.Listener
+}
+
+// Starts the SFTP server in the background. If a listener is provided connections are
+// accepted on it rather than on the configured address.
+func Initialize(config *config.Configuration, listener net.Listener) error {
+	c := &Server{config: config, listener: listener}
 
-	if err := sftp_server.New(c); err != nil {
+	// Load the host key up front so that a problem with it is returned to the caller
+	// rather than retried by the supervisor.
+	if _, err := c.hostKey(); err != nil {
 		return err
 	}
 
-	c.ConfigureLogger(func() *zap.SugaredLogger {
-		return zap.S().Named("sftp")
-	})
-
 	// Initialize the SFTP server in a background thread since this is
-	// a long running operation.
-	go func(instance *sftp_server.Server) {
-		if err := c.Initalize(); err != nil {
+	// a long running operation. If the listener fails it will be restarted by
+	// the supervisor.
+	go supervisor.Supervise("sftp", func() error {
+		if err := c.listen(); err != nil {
 			zap.S().Named("sftp").Errorw("failed to initialize SFTP subsystem", zap.Error(errors.WithStack(err)))
+
+			return err
 		}
-	}(c)
+
+		return nil
+	})
 
 	return nil
 }
 
-func validatePath(fs sftp_server.FileSystem, p string) (string, error) {
-	s := server.GetServers().Find(func(server *server.Server) bool {
-		return server.Uuid == fs.UUID
-	})
+// Returns the private key used by the SFTP server, generating it the first time the
+// server is started.
+func (c *Server) hostKey() (ssh.Signer, error) {
+	p := path.Join(c.config.System.Data, ".sftp/id_rsa")
 
-	if s == nil {
-		return "", errors.New("no server found with that UUID")
+	if _, err := os.Stat(p); os.IsNotExist(err) {
+		if err := generatePrivateKey(p); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, errors.WithStack(err)
 	}
 
-	return s.Filesystem.SafePath(p)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	k, err := ssh.ParsePrivateKey(b)
+
+	return k, errors.WithStack(err)
 }
 
-func validateDiskSpace(fs sftp_server.FileSystem) bool {
-	s := server.GetServers().Find(func(server *server.Server) bool {
-		return server.Uuid == fs.UUID
-	})
+// Listens for inbound SFTP connections until the listener fails.
+func (c *Server) listen() error {
+	conf := &ssh.ServerConfig{
+		NoClientAuth: false,
+		MaxAuthTries: 6,
+		PasswordCallback: func(conn ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			resp, err := validateCredentials(sftp_server.AuthenticationRequest{User: conn.User(), Pass: string(pass)})
+			if err != nil {
+				if _, ok := err.(sftp_server.InvalidCredentialsError); !ok {
+					zap.S().Named("sftp").Errorw("encountered error validating user credentials", zap.Error(err))
+				}
 
-	if s == nil {
-		return false
+				return nil, err
+			}
+
+			return &ssh.Permissions{
+				Extensions: map[string]string{
+					"uuid":        resp.Server,
+					"user":        conn.User(),
+					"permissions": strings.Join(resp.Permissions, ","),
+				},
+			}, nil
+		},
+	}
+
+	k, err := c.hostKey()
+	if err != nil {
+		return err
+	}
+	conf.AddHostKey(k)
+
+	// The socket passed by systemd is left open when the listener is restarted, since it
+	// cannot be opened again.
+	listener := c.listener
+	if listener == nil {
+		listener, err = net.Listen("tcp", fmt.Sprintf("%s:%d", bindAddress(c.config.System.Sftp.Address), c.config.System.Sftp.Port))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		defer listener.Close()
+
+		zap.S().Named("sftp").Infow("sftp subsystem listening for connections", zap.String("host", c.config.System.Sftp.Address), zap.Int("port", c.config.System.Sftp.Port))
+	} else {
+		zap.S().Named("sftp").Infow("using sftp socket passed by systemd", zap.String("address", listener.Addr().String()))
 	}
 
-	return s.Filesystem.HasSpaceAvailable()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(time.Millisecond * 50)
+				continue
+			}
+
+			return errors.WithStack(err)
+		}
+
+		go c.accept(conn, conf)
+	}
+}
+
+// Handles an inbound connection, serving the SFTP subsystem on each session channel that
+// the client opens once it has authenticated. Each channel is served concurrently, since a
+// client can open several of them over the same connection.
+func (c *Server) accept(conn net.Conn, conf *ssh.ServerConfig) {
+	defer conn.Close()
+	defer supervisor.Recover("sftp session")
+
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, conf)
+	if err != nil {
+		return
+	}
+	defer sconn.Close()
+
+	go ssh.DiscardRequests(reqs)
+
+	for nc := range chans {
+		// Only session channels are used by SFTP, anything else is rejected.
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+
+		channel, requests, err := nc.Accept()
+		if err != nil {
+			continue
+		}
+
+		uuid := sconn.Permissions.Extensions["uuid"]
+		s := server.GetServers().Find(func(s *server.Server) bool {
+			return s.Uuid == uuid
+		})
+
+		if s == nil {
+			channel.Close()
+			go ssh.DiscardRequests(requests)
+			continue
+		}
+
+		go serve(channel, requests, NewHandler(s, strings.Split(sconn.Permissions.Extensions["permissions"], ","), c.config.System.Sftp.ReadOnly))
+	}
+}
+
+// Serves SFTP requests on the channel until the client closes the session. Nothing is
+// served until the client requests the "sftp" subsystem, and any other request, such as
+// for a shell or pty, is refused.
+func serve(channel ssh.Channel, requests <-chan *ssh.Request, h *Handler) {
+	defer channel.Close()
+	defer supervisor.Recover("sftp session")
+
+	subsystem := false
+	for req := range requests {
+		ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+		req.Reply(ok, nil)
+
+		if ok {
+			subsystem = true
+			break
+		}
+	}
+
+	if !subsystem {
+		return
+	}
+
+	go func() {
+		for req := range requests {
+			req.Reply(false, nil)
+		}
+	}()
+
+	metrics.SftpSessions.Add(1)
+	defer metrics.SftpSessions.Add(-1)
+
+	rs := sftp.NewRequestServer(channel, h.Handlers())
+	if err := rs.Serve(); err == io.EOF {
+		rs.Close()
+	}
+}
+
+// Generates the private key used by the SFTP server and writes it to the path.
+func generatePrivateKey(p string) error {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+		return errors.WithStack(err)
+	}
+
+	o, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer o.Close()
+
+	return errors.WithStack(pem.Encode(o, &pem.Block{
+		Type:  "RSA PRIVATE KEY",
+		Bytes: x509.MarshalPKCS1PrivateKey(key),
+	}))
+}
+
+// Returns the bind address in a format that the SFTP server can listen on. The server
+// joins the address and port together without adding brackets around IPv6 addresses,
+// so they need to be added here.
+func bindAddress(host string) string {
+	host = config.TrimAddressBrackets(host)
+	if strings.Contains(host, ":") {
+		return "[" + host + "]"
+	}
+
+	return host
+}
+
+// Blocks until the SFTP server is accepting connections, or until the timeout is reached
+// in which case an error is returned.
+func WaitUntilListening(c *config.Configuration, timeout time.Duration) error {
+	host := config.LocalDialAddress(c.System.Sftp.Address)
+	addr := net.JoinHostPort(host, strconv.Itoa(c.System.Sftp.Port))
+	deadline := time.Now().Add(timeout)
+
+	for {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err == nil {
+			conn.Close()
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return errors.Wrap(err, "sftp server did not begin listening in time")
+		}
+
+		time.Sleep(time.Millisecond * 250)
+	}
+}
+
+// Returns the full path on the disk for a path requested by an SFTP client, ensuring that
+// it is within the data directory of the server.
+func validatePath(s *server.Server, p string) (string, error) {
+	return s.Filesystem.SafePath(p)
 }
 
 // Validates a set of credentials for a SFTP login aganist Pterodactyl Panel and returns
@@ -77,6 +295,11 @@ func validateDiskSpace(fs sftp_server.FileSystem) bool {
 func validateCredentials(c sftp_server.AuthenticationRequest) (*sftp_server.AuthenticationResponse, error) {
 	resp, err := api.NewRequester().ValidateSftpCredentials(c)
 	if err != nil {
+		if _, ok := err.(sftp_server.InvalidCredentialsError); ok {
+			metrics.SftpLogins.Inc("failed")
+			audit.Log(audit.SftpLoginFailed, "sftp:"+c.User, "", nil)
+		}
+
 		return resp, err
 	}
 
@@ -88,5 +311,8 @@ func validateCredentials(c sftp_server.AuthenticationRequest) (*sftp_server.Auth
 		return resp, errors.New("no server found with that UUID")
 	}
 
+	metrics.SftpLogins.Inc("success")
+	audit.Log(audit.SftpLogin, "sftp:"+c.User, s.Uuid, nil)
+
 	return resp, err
 }
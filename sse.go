@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/supervisor"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The interval at which a comment is written to an event stream that has nothing else to
+// send, so that proxies do not close the connection for being idle.
+const sseKeepAliveInterval = time.Second * 15
+
+// Streams the same events that are sent to a server websocket using Server-Sent Events,
+// for clients behind proxies that do not support websockets. Each message is written as
+// an event named after the websocket event, with the same JSON message as its data.
+//
+// Browsers cannot set headers on an EventSource, so the token may be provided in the
+// "token" query parameter rather than as a bearer token. Streams are read-only, clients
+// perform actions using the server API. Once the token expires the stream is closed, and
+// the client should reconnect with a new token.
+func (rt *Router) routeServerEventStream(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	s := rt.GetServer(ps.ByName("server"))
+
+	// Browsers connect to the stream directly from the Panel, so it needs to be allowed
+	// to read the response.
+	rt.AttachAccessControlHeaders(w, r, ps)
+
+	raw := r.URL.Query().Get("token")
+	if auth := strings.SplitN(r.Header.Get("Authorization"), " ", 2); len(auth) == 2 && auth[0] == "Bearer" {
+		raw = auth[1]
+	}
+
+	token, err := ParseJWT([]byte(raw))
+	if err == nil {
+		err = checkToken(token, s.Uuid)
+	}
+
+	if err != nil {
+		http.Error(w, "could not authenticate client: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	if ok, wait := allowServerConnection(s.Uuid); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "too many connection attempts, try again later", http.StatusTooManyRequests)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	mode := WebsocketModeDefault
+	if r.URL.Query().Get("mode") == WebsocketModeQuiet {
+		mode = WebsocketModeQuiet
+	}
+
+	format := r.URL.Query().Get("console")
+	if format == "" {
+		format = WebsocketConsoleRaw
+	}
+
+	switch format {
+	case WebsocketConsoleRaw, WebsocketConsoleStripped, WebsocketConsoleStructured:
+	default:
+		http.Error(w, "unknown console format \""+format+"\"", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the stream, which would hold events back until the
+	// buffer fills.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	events := subscribedEvents(mode)
+	queue := newSendQueue()
+	defer queue.close()
+
+	eventChannel := make(chan server.Event)
+	for _, event := range events {
+		s.Events().Subscribe(event, eventChannel)
+	}
+
+	defer func() {
+		for _, event := range events {
+			s.Events().Unsubscribe(event, eventChannel)
+		}
+
+		close(eventChannel)
+	}()
+
+	go func() {
+		defer supervisor.Recover("event stream")
+
+		for d := range eventChannel {
+			// Installation output is only sent to clients that are allowed to see it.
+			if d.Topic == server.InstallOutputEvent && !token.HasPermission(PermissionReceiveInstall) {
+				continue
+			}
+
+			data := d.Data
+			if d.Topic == server.ConsoleOutputEvent || d.Topic == server.InstallOutputEvent {
+				data = formatConsoleLine(format, data)
+			}
+
+			queue.push(&WebsocketMessage{Event: d.Topic, Args: []string{data}})
+		}
+	}()
+
+	var id int
+	write := func(m *WebsocketMessage) {
+		id++
+		b, _ := json.Marshal(m)
+
+		fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, m.Event, b)
+		flusher.Flush()
+	}
+
+	write(&WebsocketMessage{Event: AuthenticationSuccessEvent, Args: []string{}})
+	write(&WebsocketMessage{Event: server.StatusEvent, Args: []string{s.State}})
+
+	// Clients that were previously connected can ask for the recent console output, as
+	// the "send logs" event does for websockets.
+	if mode != WebsocketModeQuiet && r.URL.Query().Get("logs") == "1" {
+		if running, _ := s.Environment.IsRunning(); running {
+			if logs, err := s.ReadLogfile(1024 * 16); err == nil {
+				for _, line := range logs {
+					write(&WebsocketMessage{Event: server.ConsoleOutputEvent, Args: []string{formatConsoleLine(format, line)}})
+				}
+			}
+		}
+	}
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	expiry := time.NewTicker(time.Second * 30)
+	defer expiry.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case m := <-queue.messages:
+			if n := queue.takeDropped(); n > 0 {
+				write(&WebsocketMessage{
+					Event: server.DaemonMessageEvent,
+					Args:  []string{fmt.Sprintf("%d events were not sent because the connection could not keep up.", n)},
+				})
+			}
+
+			write(m)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-expiry.C:
+			remaining := token.ExpirationTime.Unix() - time.Now().Unix()
+			if remaining <= 0 {
+				write(&WebsocketMessage{Event: TokenExpiredEvent})
+				return
+			} else if remaining <= 180 {
+				write(&WebsocketMessage{Event: TokenExpiringEvent})
+			}
+		}
+	}
+}
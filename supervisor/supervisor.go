@@ -0,0 +1,143 @@
+package supervisor
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/crash"
+	"github.com/pterodactyl/wings/metrics"
+	"go.uber.org/zap"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// If a supervised subsystem runs for at least this long without failing the backoff
+// between restarts is reset.
+const stableAfter = time.Minute
+
+var (
+	panicsMetric   = metrics.NewCounterVec("wings_subsystem_panics_total", "The number of panics recovered from in a daemon subsystem.", "subsystem")
+	restartsMetric = metrics.NewCounterVec("wings_subsystem_restarts_total", "The number of times a daemon subsystem has been restarted.", "subsystem")
+)
+
+// Details about a subsystem failure that are sent to the configured webhook.
+type Incident struct {
+	Subsystem string    `json:"subsystem"`
+	Error     string    `json:"error"`
+	Stack     string    `json:"stack,omitempty"`
+	Time      time.Time `json:"time"`
+	Restarts  int       `json:"restarts"`
+}
+
+// Runs a long running subsystem function, restarting it with an exponential backoff if
+// it panics or returns an error. If the function returns nil the subsystem is considered
+// to have finished and is not restarted. This function blocks, so it should generally be
+// called in its own goroutine.
+func Supervise(name string, fn func() error) {
+	backoff := time.Second
+	restarts := 0
+
+	for {
+		start := time.Now()
+
+		err := run(name, fn)
+		if err == nil {
+			return
+		}
+
+		if time.Since(start) > stableAfter {
+			backoff = time.Second
+		}
+
+		restarts++
+		// Panics are reported at the time they are recovered from along with the stack
+		// trace, so only report plain errors here.
+		if _, ok := err.(*panicError); !ok {
+			report(Incident{Subsystem: name, Error: err.Error(), Time: time.Now().UTC(), Restarts: restarts})
+		}
+
+		zap.S().Warnw("subsystem failed, restarting after backoff", zap.String("subsystem", name), zap.Duration("backoff", backoff), zap.Error(err))
+
+		time.Sleep(backoff)
+		restartsMetric.Inc(name)
+
+		backoff *= 2
+		if max := maxBackoff(); backoff > max {
+			backoff = max
+		}
+	}
+}
+
+// Recovers from a panic in the calling goroutine and reports it. This should be deferred
+// at the start of any goroutine that should not be able to take down the entire daemon,
+// but does not need to be restarted.
+func Recover(name string) {
+	if r := recover(); r != nil {
+		handlePanic(name, r)
+	}
+}
+
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("recovered from panic: %v", e.value)
+}
+
+// Executes the function, converting any panic into an error.
+func run(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			handlePanic(name, r)
+			err = &panicError{value: r}
+		}
+	}()
+
+	return fn()
+}
+
+func handlePanic(name string, r interface{}) {
+	stack := string(debug.Stack())
+
+	panicsMetric.Inc(name)
+	zap.S().Errorw("recovered from panic in daemon subsystem", zap.String("subsystem", name), zap.Any("panic", r), zap.String("stack", stack))
+
+	report(Incident{Subsystem: name, Error: fmt.Sprintf("%v", r), Stack: stack, Time: time.Now().UTC()})
+	crash.Capture(name, r, stack, false)
+}
+
+// Returns the maximum amount of time to wait between subsystem restarts.
+func maxBackoff() time.Duration {
+	if c := config.Get(); c != nil && c.System.Supervisor.MaxBackoff > 0 {
+		return time.Duration(c.System.Supervisor.MaxBackoff) * time.Second
+	}
+
+	return time.Minute
+}
+
+// Sends the incident to the configured webhook, if there is one. This happens in the
+// background so that a slow webhook does not delay restarting the subsystem.
+func report(i Incident) {
+	c := config.Get()
+	if c == nil || c.System.Supervisor.WebhookUrl == "" {
+		return
+	}
+
+	go func(url string) {
+		b, err := json.Marshal(i)
+		if err != nil {
+			return
+		}
+
+		client := &http.Client{Timeout: time.Second * 10}
+		res, err := client.Post(url, "application/json", bytes.NewReader(b))
+		if err != nil {
+			zap.S().Warnw("failed to send subsystem incident to webhook", zap.String("subsystem", i.Subsystem), zap.Error(err))
+			return
+		}
+		res.Body.Close()
+	}(c.System.Supervisor.WebhookUrl)
+}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"github.com/docker/docker/pkg/parsers/kernel"
+	"github.com/pterodactyl/wings/gpu"
+	"github.com/pterodactyl/wings/hoststat"
+	"github.com/pterodactyl/wings/server"
+	"go.uber.org/zap"
 	"runtime"
 )
 
@@ -11,6 +15,16 @@ type SystemInformation struct {
 	Architecture  string `json:"architecture"`
 	OS            string `json:"os"`
 	CpuCount      int    `json:"cpu_count"`
+
+	Gpus []gpu.Device `json:"gpus"`
+
+	ImageGc server.ImageGcStatus `json:"image_gc"`
+
+	// The percentage of CPU time stolen from the host by the hypervisor.
+	CpuSteal float64 `json:"cpu_steal"`
+
+	// The resources still available for placing servers on the node.
+	Headroom server.NodeHeadroom `json:"headroom"`
 }
 
 func GetSystemInformation() (*SystemInformation, error) {
@@ -19,12 +33,26 @@ func GetSystemInformation() (*SystemInformation, error) {
 		return nil, err
 	}
 
+	gpus, err := gpu.Detect()
+	if err != nil {
+		zap.S().Warnw("failed to detect gpus available on the system", zap.Error(err))
+	}
+
+	// Always return an array for the GPUs so that clients don't need to handle a null value.
+	if gpus == nil {
+		gpus = []gpu.Device{}
+	}
+
 	s := &SystemInformation{
 		Version:       Version,
 		KernelVersion: k.String(),
 		Architecture:  runtime.GOARCH,
 		OS:            runtime.GOOS,
 		CpuCount:      runtime.NumCPU(),
+		Gpus:          gpus,
+		ImageGc:       server.GetImageGcStatus(),
+		CpuSteal:      hoststat.Steal(),
+		Headroom:      server.GetNodeHeadroom(),
 	}
 
 	return s, nil
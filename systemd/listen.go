@@ -0,0 +1,62 @@
+package systemd
+
+import (
+	"github.com/pkg/errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// The first file descriptor passed by systemd when using socket activation, the ones
+// before it are stdin, stdout and stderr.
+const listenFdsStart = 3
+
+// Returns the listeners passed to the daemon by systemd using socket activation, keyed by
+// the name given to them with FileDescriptorName= in the socket unit. Sockets without a
+// name use the default name of the socket unit. If the daemon was not socket activated
+// no listeners are returned.
+//
+// The environment variables used for socket activation are removed once they have been
+// read so that they are not passed along to any child processes.
+func Listeners() (map[string]net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	out := make(map[string]net.Listener, count)
+	for i := 0; i < count; i++ {
+		fd := listenFdsStart + i
+		syscall.CloseOnExec(fd)
+
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// The listener holds its own copy of the file descriptor.
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to use socket \"%s\" passed by systemd", name)
+		}
+
+		out[name] = l
+	}
+
+	return out, nil
+}
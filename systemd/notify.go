@@ -0,0 +1,99 @@
+package systemd
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Defines the states that can be sent to systemd.
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// Sends a state notification to systemd using the socket defined in the NOTIFY_SOCKET
+// environment variable. If the daemon is not being run by systemd with Type=notify this
+// is a no-op and false is returned.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+
+	addr := &net.UnixAddr{Name: socket, Net: "unixgram"}
+	conn, err := net.DialUnix(addr.Net, nil, addr)
+	if err != nil {
+		return false, errors.WithStack(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, errors.WithStack(err)
+	}
+
+	return true, nil
+}
+
+// Sends a free-form status message to systemd, which is shown by "systemctl status".
+func Status(message string) (bool, error) {
+	return Notify("STATUS=" + message)
+}
+
+// Asks systemd to extend the time it waits for the daemon to become ready by the given
+// duration. This should be sent periodically while a slow startup is making progress so
+// that systemd does not consider the daemon to have failed to start.
+func ExtendTimeout(d time.Duration) (bool, error) {
+	return Notify(fmt.Sprintf("EXTEND_TIMEOUT_USEC=%d", int64(d/time.Microsecond)))
+}
+
+// Returns the interval at which systemd expects the watchdog to be notified. If the
+// watchdog is not enabled for this process a zero duration is returned.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+
+	// If the watchdog is meant for a different process, ignore it.
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Starts a background routine that notifies the systemd watchdog at half of the interval
+// that systemd expects. The health check function is called before each notification, and
+// if it returns an error the watchdog is not notified so that systemd will restart the
+// daemon if it remains unhealthy.
+func StartWatchdog(check func() error) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+
+	zap.S().Infow("enabling systemd watchdog notifications", zap.Duration("interval", interval))
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if err := check(); err != nil {
+				zap.S().Warnw("skipping systemd watchdog notification, daemon is unhealthy", zap.Error(err))
+				continue
+			}
+
+			if _, err := Notify(Watchdog); err != nil {
+				zap.S().Warnw("failed to notify systemd watchdog", zap.Error(err))
+			}
+		}
+	}()
+}
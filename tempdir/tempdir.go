@@ -0,0 +1,304 @@
+// Package tempdir manages the directory that all of the temporary files created by the
+// daemon are stored in, so that they are kept off of the root partition, limited in size
+// and cleaned up if the daemon exits before it is able to remove them itself.
+//
+// The files are kept in a "wings" directory within the configured directory, so that
+// only files created by the daemon are ever removed from it.
+package tempdir
+
+import (
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/metrics"
+	"github.com/pterodactyl/wings/supervisor"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Returned when a temporary file cannot be created because the temporary directory has
+// reached its maximum size.
+var ErrQuotaExceeded = errors.New("temporary directory has reached its maximum size")
+
+// The size in bytes of the temporary files as of the last time the directory was walked,
+// plus everything written to temporary files since then.
+var usage struct {
+	sync.Mutex
+	size int64
+}
+
+// Returns the absolute path to the temporary directory, creating it if it does not exist.
+// The path is absolute since temporary directories are also mounted into containers.
+func Root() (string, error) {
+	p, err := filepath.Abs(filepath.Join(config.Get().System.Temp.Directory, "wings"))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	if err := os.MkdirAll(p, 0755); err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	return p, nil
+}
+
+// Removes everything left in the temporary directory by a previous run of the daemon,
+// and then begins removing abandoned temporary files in the background.
+func Configure() error {
+	root, err := Root()
+	if err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(root, e.Name())); err != nil {
+			zap.S().Warnw("failed to remove stale temporary file", zap.String("path", e.Name()), zap.Error(err))
+		}
+	}
+
+	if len(entries) > 0 {
+		zap.S().Infow("removed stale temporary files", zap.Int("count", len(entries)))
+	}
+
+	go supervisor.Supervise("temp directory cleanup", func() error {
+		for {
+			cleanup()
+			time.Sleep(time.Minute * 10)
+		}
+	})
+
+	return nil
+}
+
+// Removes any temporary files that have not been modified within the maximum age, and
+// updates the metrics for the directory.
+func cleanup() {
+	root, err := Root()
+	if err != nil {
+		return
+	}
+
+	if max := config.Get().System.Temp.MaxAge; max > 0 {
+		cutoff := time.Now().Add(-time.Duration(max) * time.Hour)
+
+		entries, _ := ioutil.ReadDir(root)
+		for _, e := range entries {
+			if latestModification(filepath.Join(root, e.Name())).Before(cutoff) {
+				zap.S().Debugw("removing abandoned temporary file", zap.String("path", e.Name()))
+
+				os.RemoveAll(filepath.Join(root, e.Name()))
+			}
+		}
+	}
+
+	Usage()
+}
+
+// Returns the most recent modification time of the file, or of anything within it if it
+// is a directory, so that a directory still being written to is not removed.
+func latestModification(p string) time.Time {
+	var latest time.Time
+
+	filepath.Walk(p, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+
+		return nil
+	})
+
+	return latest
+}
+
+// Returns the total size of the temporary files in bytes, and the number of temporary
+// files and directories, updating the metrics for the directory.
+func Usage() (int64, int) {
+	root, err := Root()
+	if err != nil {
+		return 0, 0
+	}
+
+	var size int64
+	filepath.Walk(root, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += info.Size()
+		}
+
+		return nil
+	})
+
+	entries, _ := ioutil.ReadDir(root)
+
+	usage.Lock()
+	usage.size = size
+	usage.Unlock()
+
+	metrics.TempBytes.Set(float64(size))
+	metrics.TempEntries.Set(float64(len(entries)))
+
+	return size, len(entries)
+}
+
+// Checks that the temporary directory has room for another temporary file.
+func checkQuota() error {
+	max := config.Get().System.Temp.MaxSize
+	if max <= 0 {
+		return nil
+	}
+
+	if size, _ := Usage(); size >= max*1024*1024 {
+		metrics.TempQuotaRejections.Inc()
+
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+// Reserves room in the temporary directory for n more bytes, returning an error if they
+// would take the directory over its maximum size.
+func reserve(n int64) error {
+	max := config.Get().System.Temp.MaxSize
+	if max <= 0 {
+		return nil
+	}
+
+	usage.Lock()
+	defer usage.Unlock()
+
+	if usage.size+n > max*1024*1024 {
+		metrics.TempQuotaRejections.Inc()
+
+		return ErrQuotaExceeded
+	}
+	usage.size += n
+
+	return nil
+}
+
+// Creates a new temporary directory with a name beginning with the prefix. The caller is
+// responsible for removing the directory once it is done with it. Only the creation of
+// the directory is checked against the maximum size, since its contents are written by
+// whatever it is given to, such as an installation container.
+func Dir(prefix string) (string, error) {
+	if err := checkQuota(); err != nil {
+		return "", err
+	}
+
+	root, err := Root()
+	if err != nil {
+		return "", err
+	}
+
+	d, err := ioutil.TempDir(root, prefix)
+
+	return d, errors.WithStack(err)
+}
+
+// A temporary file. Every write to the file is counted against the maximum size of the
+// temporary directory, and fails with ErrQuotaExceeded once it would be exceeded.
+type LimitedFile struct {
+	*os.File
+}
+
+func (f *LimitedFile) Write(b []byte) (int, error) {
+	if err := reserve(int64(len(b))); err != nil {
+		return 0, err
+	}
+
+	return f.File.Write(b)
+}
+
+func (f *LimitedFile) WriteString(s string) (int, error) {
+	return f.Write([]byte(s))
+}
+
+// Copies the reader into the file through Write, rather than the ReadFrom of the file
+// itself, so that the data is counted against the maximum size.
+func (f *LimitedFile) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(struct{ io.Writer }{f}, r)
+}
+
+// Creates a new temporary file with a name beginning with the prefix, opened for reading
+// and writing. If the prefix contains a "*" the random part of the name replaces it. The
+// caller is responsible for removing the file once it is done with it.
+func File(prefix string) (*LimitedFile, error) {
+	if err := checkQuota(); err != nil {
+		return nil, err
+	}
+
+	root, err := Root()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := ioutil.TempFile(root, prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &LimitedFile{File: f}, nil
+}
+
+// Moves a completed temporary file to its destination. If the destination is on another
+// disk the file is copied there instead, and the temporary file is removed.
+func Move(src string, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+
+	if le, ok := err.(*os.LinkError); !ok || le.Err != syscall.EXDEV {
+		return errors.WithStack(err)
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer in.Close()
+
+	st, err := in.Stat()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	// Copy to a temporary name next to the destination so that a partially copied file
+	// is never left at the destination itself.
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, st.Mode().Perm())
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+
+		return errors.WithStack(err)
+	}
+
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+
+		return errors.WithStack(err)
+	}
+
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+
+		return errors.WithStack(err)
+	}
+
+	return errors.WithStack(os.Remove(src))
+}
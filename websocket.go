@@ -8,9 +8,12 @@ import (
 	"github.com/gorilla/websocket"
 	"github.com/julienschmidt/httprouter"
 	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
 	"github.com/pterodactyl/wings/config"
 	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/supervisor"
 	"go.uber.org/zap"
+	"math"
 	"net/http"
 	"os"
 	"strings"
@@ -27,6 +30,16 @@ const (
 	SendServerLogsEvent        = "send logs"
 	SendCommandEvent           = "send command"
 	ErrorEvent                 = "daemon error"
+	AcknowledgementEvent       = "ack"
+	ConsoleFormatEvent         = "console format"
+)
+
+// Defines the modes that a websocket connection can be opened in. Quiet mode sends state
+// changes, structured events and acknowledgements down the socket but no console output or
+// resource usage, and is intended for automation that does not care about the console.
+const (
+	WebsocketModeDefault = "default"
+	WebsocketModeQuiet   = "quiet"
 )
 
 type WebsocketMessage struct {
@@ -52,6 +65,20 @@ type WebsocketHandler struct {
 	Mutex      sync.Mutex
 	Connection *websocket.Conn
 	JWT        *WebsocketTokenPayload
+
+	// The mode that this connection was opened in.
+	Mode string
+
+	// The format console output is sent to the connection in. This can be changed by
+	// the client at any time, so it is only accessed while holding the mutex.
+	consoleFormat string
+
+	// The events waiting to be written to the connection.
+	queue sendQueue
+
+	// Set if the client negotiated the MessagePack subprotocol, in which case stats
+	// events are written to it as binary frames.
+	binaryStats bool
 }
 
 type WebsocketTokenPayload struct {
@@ -69,6 +96,34 @@ const (
 	PermissionReceiveInstall = "receive-install"
 )
 
+// The permission the token for a connection must grant for each inbound event. Events
+// that are not listed only require the connect permission, which allows a token to be
+// issued that can only view the console.
+var inboundPermissions = map[string]string{
+	SetStateEvent:    PermissionSendPower,
+	SendCommandEvent: PermissionSendCommand,
+}
+
+// Returns the permission the token for the connection must grant for an inbound event.
+// Power actions defined by the egg of the server require their own permission rather than
+// the permission for the built in power actions.
+func (wsh *WebsocketHandler) requiredPermission(m WebsocketMessage) (string, bool) {
+	if m.Event == SetStateEvent {
+		if a, ok := wsh.Server.PowerAction(strings.Join(m.Args, "")); ok {
+			return server.PowerActionPermission(a), true
+		}
+	}
+
+	p, ok := inboundPermissions[m.Event]
+
+	return p, ok
+}
+
+// Returns the identifier used for the token's user in the audit log.
+func (wtp *WebsocketTokenPayload) Actor() string {
+	return "user:" + wtp.UserID.String()
+}
+
 // Checks if the given token payload has a permission string.
 func (wtp *WebsocketTokenPayload) HasPermission(permission string) bool {
 	for _, k := range wtp.Permissions {
@@ -113,19 +168,24 @@ func ParseJWT(token []byte) (*WebsocketTokenPayload, error) {
 
 // Checks if the JWT is still valid.
 func (wsh *WebsocketHandler) TokenValid() error {
-	if wsh.JWT == nil {
+	return checkToken(wsh.JWT, wsh.Server.Uuid)
+}
+
+// Checks that a token has not expired and grants access to connect to the given server.
+func checkToken(token *WebsocketTokenPayload, serverUuid string) error {
+	if token == nil {
 		return errors.New("no jwt present")
 	}
 
-	if err := jwt.ExpirationTimeValidator(time.Now())(&wsh.JWT.Payload); err != nil {
+	if err := jwt.ExpirationTimeValidator(time.Now())(&token.Payload); err != nil {
 		return err
 	}
 
-	if !wsh.JWT.HasPermission(PermissionConnect) {
+	if !token.HasPermission(PermissionConnect) {
 		return errors.New("jwt does not have connect permission")
 	}
 
-	if wsh.Server.Uuid != wsh.JWT.ServerUUID {
+	if serverUuid != token.ServerUUID {
 		return errors.New("jwt server uuid mismatch")
 	}
 
@@ -162,16 +222,36 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 		Mutex:      sync.Mutex{},
 		Connection: c,
 		JWT:        nil,
+		Mode:       WebsocketModeDefault,
+		queue:      newSendQueue(),
 	}
 
-	events := []string{
-		server.StatsEvent,
-		server.StatusEvent,
-		server.ConsoleOutputEvent,
-		server.InstallOutputEvent,
-		server.DaemonMessageEvent,
+	handler.negotiateEncoding(config.Get().Api.Websocket)
+
+	if err := handler.setConsoleFormat(r.URL.Query().Get("console")); err != nil {
+		handler.setConsoleFormat(WebsocketConsoleRaw)
+	}
+
+	if r.URL.Query().Get("mode") == WebsocketModeQuiet {
+		handler.Mode = WebsocketModeQuiet
 	}
 
+	s.DebugTrace(server.DebugCategoryWebsocket, "connection opened", map[string]interface{}{
+		"remote_address": r.RemoteAddr,
+		"mode":           handler.Mode,
+		"console":        r.URL.Query().Get("console"),
+	})
+
+	opened := time.Now()
+	defer func() {
+		s.DebugTrace(server.DebugCategoryWebsocket, "connection closed", map[string]interface{}{
+			"remote_address": r.RemoteAddr,
+			"duration_ms":    int64(time.Since(opened) / time.Millisecond),
+		})
+	}()
+
+	events := subscribedEvents(handler.Mode)
+
 	eventChannel := make(chan server.Event)
 	for _, event := range events {
 		s.Events().Subscribe(event, eventChannel)
@@ -183,22 +263,38 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 		}
 
 		close(eventChannel)
+		handler.queue.close()
 	}()
 
-	// Listen for different events emitted by the server and respond to them appropriately.
+	// Listen for different events emitted by the server and queue them to be sent to the
+	// connection. Events are never written from here directly, so that a client that is
+	// slow to read cannot hold up the delivery of events to every other client.
 	go func() {
+		defer supervisor.Recover("websocket")
+
 		for d := range eventChannel {
-			handler.SendJson(&WebsocketMessage{
+			data := d.Data
+			if d.Topic == server.ConsoleOutputEvent || d.Topic == server.InstallOutputEvent {
+				data = handler.formatConsole(data)
+			}
+
+			s.DebugCount("websocket.sent."+d.Topic, len(data))
+
+			handler.queue.push(&WebsocketMessage{
 				Event: d.Topic,
-				Args:  []string{d.Data},
+				Args:  []string{data},
 			})
 		}
 	}()
+
+	go handler.drainQueue()
 	// Sit here and check the time to expiration on the JWT every 30 seconds until
 	// the token has expired. If we are within 3 minutes of the token expiring, send
 	// a notice over the socket that it is expiring soon. If it has expired, send that
 	// notice as well.
 	go func() {
+		defer supervisor.Recover("websocket")
+
 		for {
 			select {
 			case <-done:
@@ -242,12 +338,44 @@ func (rt *Router) routeWebsocket(w http.ResponseWriter, r *http.Request, ps http
 			continue
 		}
 
+		// Only the event is recorded, the arguments can contain commands and tokens.
+		s.DebugTrace(server.DebugCategoryWebsocket, "message received", map[string]interface{}{
+			"remote_address": r.RemoteAddr,
+			"event":          j.Event,
+			"bytes":          len(p),
+		})
+
 		if err := handler.HandleInbound(j); err != nil {
 			handler.SendErrorJson(err)
 		}
 	}
 }
 
+// Returns the server events that are sent to a connection opened in the given mode. When
+// running in quiet mode every event is sent except for the high volume console output and
+// resource usage events.
+func subscribedEvents(mode string) []string {
+	events := []string{
+		server.StatusEvent,
+		server.HealthEvent,
+		server.CrashLoopEvent,
+		server.OomEvent,
+		server.StopReasonEvent,
+		server.BootTimelineEvent,
+		server.IntegrityEvent,
+		server.PlayersEmptyEvent,
+		server.PlayersFullEvent,
+		server.FileOperationEvent,
+		server.DaemonMessageEvent,
+	}
+
+	if mode == WebsocketModeQuiet {
+		return events
+	}
+
+	return append(events, server.StatsEvent, server.ConsoleOutputEvent, server.InstallOutputEvent)
+}
+
 // Perform a blocking send operation on the websocket since we want to avoid any
 // concurrent writes to the connection, which would cause a runtime panic and cause
 // the program to crash out.
@@ -277,7 +405,22 @@ func (wsh *WebsocketHandler) unsafeSendJson(v interface{}) error {
 	wsh.Mutex.Lock()
 	defer wsh.Mutex.Unlock()
 
-	return wsh.Connection.WriteJSON(v)
+	return wsh.writeJson(v)
+}
+
+// Writes to the connection, closing it if the client does not accept the message within
+// the write timeout. Closing the connection causes the read loop to exit, which cleans up
+// everything else for the connection. The mutex must be held when this is called.
+func (wsh *WebsocketHandler) writeJson(v interface{}) error {
+	wsh.Connection.SetWriteDeadline(time.Now().Add(websocketWriteTimeout))
+
+	if err := wsh.writeMessage(v); err != nil {
+		wsh.Connection.Close()
+
+		return err
+	}
+
+	return nil
 }
 
 // Sends an error back to the connected websocket instance by checking the permissions
@@ -289,7 +432,7 @@ func (wsh *WebsocketHandler) SendErrorJson(err error) error {
 
 	message := "an unexpected error was encountered while handling this request"
 	if wsh.JWT != nil {
-		if server.IsSuspendedError(err) || wsh.JWT.HasPermission(PermissionReceiveErrors) {
+		if server.IsSuspendedError(err) || server.IsGroupQuotaError(err) || server.IsAdmissionError(err) || wsh.JWT.HasPermission(PermissionReceiveErrors) {
 			message = err.Error()
 		}
 	}
@@ -299,7 +442,7 @@ func (wsh *WebsocketHandler) SendErrorJson(err error) error {
 	wsm := WebsocketMessage{Event: ErrorEvent}
 	wsm.Args = []string{m}
 
-	if !server.IsSuspendedError(err) {
+	if !server.IsSuspendedError(err) && !server.IsGroupQuotaError(err) && !server.IsAdmissionError(err) {
 		zap.S().Errorw(
 			"an error was encountered in the websocket process",
 			zap.String("server", wsh.Server.Uuid),
@@ -308,7 +451,7 @@ func (wsh *WebsocketHandler) SendErrorJson(err error) error {
 		)
 	}
 
-	return wsh.Connection.WriteJSON(wsm)
+	return wsh.writeJson(wsm)
 }
 
 // Converts an error message into a more readable representation and returns a UUID
@@ -340,6 +483,16 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 		}
 	}
 
+	// Every connection to the server is authenticated separately, so the permissions of
+	// this connection are checked for every event rather than trusting the client to only
+	// send the events it is allowed to.
+	if p, ok := wsh.requiredPermission(m); ok && !wsh.JWT.HasPermission(p) {
+		return wsh.unsafeSendJson(WebsocketMessage{
+			Event: ErrorEvent,
+			Args:  []string{"you do not have permission to perform this action: " + p},
+		})
+	}
+
 	switch m.Event {
 	case AuthenticationEvent:
 		{
@@ -348,6 +501,24 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 				return err
 			}
 
+			// A client re-authenticates with a new token before the current one expires,
+			// in which case the permissions granted may have changed since the last token.
+			previous := wsh.JWT
+			before := wsh.Capabilities()
+
+			// The first time the client authenticates the connection is counted against
+			// the limit for the server. Re-authenticating is not counted again.
+			if previous == nil && token.HasPermission(PermissionConnect) {
+				if ok, wait := allowServerConnection(wsh.Server.Uuid); !ok {
+					wsh.unsafeSendJson(WebsocketMessage{
+						Event: ErrorEvent,
+						Args:  []string{fmt.Sprintf("too many connection attempts, try again in %d seconds", int(math.Ceil(wait.Seconds())))},
+					})
+
+					return wsh.Connection.Close()
+				}
+			}
+
 			if token.HasPermission(PermissionConnect) {
 				wsh.JWT = token
 			}
@@ -361,34 +532,62 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 				Args:  []string{},
 			})
 
-			return nil
+			after := wsh.Capabilities()
+			if previous != nil && !before.equal(after) {
+				return wsh.sendCapabilities(PermissionsChangedEvent, after)
+			}
+
+			return wsh.sendCapabilities(CapabilitiesEvent, after)
 		}
 	case SetStateEvent:
 		{
-			if !wsh.JWT.HasPermission(PermissionSendPower) {
-				return nil
-			}
+			action := strings.Join(m.Args, "")
+			audit.Log(audit.PowerAction, wsh.JWT.Actor(), wsh.Server.Uuid, map[string]string{"action": action})
 
-			switch strings.Join(m.Args, "") {
+			var err error
+			dispatched := true
+			switch action {
 			case "start":
-				return wsh.Server.Environment.Start()
+				err = wsh.Server.Environment.Start()
 			case "stop":
-				return wsh.Server.Environment.Stop()
+				wsh.Server.SetStopReason(server.StopReasonStop, "stopped from the console")
+				err = wsh.Server.Environment.Stop()
 			case "restart":
-				return nil
+				dispatched = false
 			case "kill":
-				return wsh.Server.Environment.Terminate(os.Kill)
+				wsh.Server.SetStopReason(server.StopReasonKill, "killed from the console")
+				err = wsh.Server.Environment.Terminate(os.Kill)
+			default:
+				if _, ok := wsh.Server.PowerAction(action); ok {
+					err = wsh.Server.RunPowerAction(action)
+				} else {
+					dispatched = false
+				}
 			}
 
-			return nil
+			if err != nil {
+				return err
+			}
+
+			// Only acknowledge actions that were actually performed, so that clients are not
+			// told that an unknown action was handled.
+			if !dispatched {
+				return nil
+			}
+
+			return wsh.acknowledge(m.Event, action)
 		}
 	case SendServerLogsEvent:
 		{
+			if wsh.Mode == WebsocketModeQuiet {
+				return nil
+			}
+
 			if running, _ := wsh.Server.Environment.IsRunning(); !running {
 				return nil
 			}
 
-			logs, err := wsh.Server.Environment.Readlog(1024 * 16)
+			logs, err := wsh.Server.ReadLogfile(1024 * 16)
 			if err != nil {
 				return err
 			}
@@ -396,25 +595,65 @@ func (wsh *WebsocketHandler) HandleInbound(m WebsocketMessage) error {
 			for _, line := range logs {
 				wsh.SendJson(&WebsocketMessage{
 					Event: server.ConsoleOutputEvent,
-					Args:  []string{line},
+					Args:  []string{wsh.formatConsole(line)},
 				})
 			}
 
 			return nil
 		}
-	case SendCommandEvent:
+	case ConsoleFormatEvent:
 		{
-			if !wsh.JWT.HasPermission(PermissionSendCommand) {
-				return nil
+			if err := wsh.setConsoleFormat(strings.Join(m.Args, "")); err != nil {
+				return err
 			}
 
+			wsh.Mutex.Lock()
+			format := wsh.consoleFormat
+			wsh.Mutex.Unlock()
+
+			return wsh.SendJson(&WebsocketMessage{
+				Event: ConsoleFormatEvent,
+				Args:  []string{format},
+			})
+		}
+	case SendCommandEvent:
+		{
 			if wsh.Server.State == server.ProcessOfflineState {
 				return nil
 			}
 
-			return wsh.Server.Environment.SendCommand(strings.Join(m.Args, ""))
+			if !wsh.Server.AllowCommand(wsh.JWT.Actor()) {
+				return wsh.SendJson(&WebsocketMessage{
+					Event: server.DaemonMessageEvent,
+					Args:  []string{"You are sending commands too quickly, please wait a moment before sending another."},
+				})
+			}
+
+			command := strings.Join(m.Args, "")
+			wsh.Server.RecordCommand(wsh.JWT.Actor(), command)
+			audit.Log(audit.ConsoleCommand, wsh.JWT.Actor(), wsh.Server.Uuid, map[string]string{"command": command})
+
+			if err := wsh.Server.SendCommand(command); err != nil {
+				return err
+			}
+
+			return wsh.acknowledge(m.Event, command)
 		}
 	}
 
 	return nil
 }
+
+// Sends an acknowledgement for an inbound event back to connections running in quiet
+// mode. Since those connections do not receive console output this is the only way for
+// them to know that a command or power action was actually handled.
+func (wsh *WebsocketHandler) acknowledge(event string, value string) error {
+	if wsh.Mode != WebsocketModeQuiet {
+		return nil
+	}
+
+	return wsh.SendJson(&WebsocketMessage{
+		Event: AcknowledgementEvent,
+		Args:  []string{event, value},
+	})
+}
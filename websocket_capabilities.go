@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pterodactyl/wings/server"
+)
+
+const (
+	CapabilitiesEvent       = "capabilities"
+	PermissionsChangedEvent = "permissions changed"
+)
+
+// Grants access to the files of the server. This is not used by the websocket itself,
+// but is reported to the client so that file controls can be shown or hidden.
+const PermissionFileAccess = "file-access"
+
+// Defines the actions the token for a websocket connection allows the client to take,
+// so that a user interface can enable or disable its controls without waiting for an
+// action to be denied.
+type WebsocketCapabilities struct {
+	SendCommand bool     `json:"send_command"`
+	SendPower   bool     `json:"send_power"`
+	ViewConsole bool     `json:"view_console"`
+	ViewStats   bool     `json:"view_stats"`
+	ViewInstall bool     `json:"view_install"`
+	ViewErrors  bool     `json:"view_errors"`
+	FileAccess  bool     `json:"file_access"`
+	Permissions []string `json:"permissions"`
+	// The power actions defined by the egg of the server that the connection can run.
+	PowerActions []string `json:"power_actions"`
+}
+
+// Returns the capabilities granted to the connection by its current token. Suspended
+// servers cannot receive commands or power actions regardless of the token.
+func (wsh *WebsocketHandler) Capabilities() WebsocketCapabilities {
+	if wsh.JWT == nil {
+		return WebsocketCapabilities{Permissions: []string{}, PowerActions: []string{}}
+	}
+
+	suspended := wsh.Server.Suspended
+	quiet := wsh.Mode == WebsocketModeQuiet
+
+	actions := []string{}
+	for _, a := range wsh.Server.PowerActions() {
+		if wsh.JWT.HasPermission(server.PowerActionPermission(a)) && !suspended {
+			actions = append(actions, a.Name)
+		}
+	}
+
+	return WebsocketCapabilities{
+		SendCommand:  wsh.JWT.HasPermission(PermissionSendCommand) && !suspended,
+		SendPower:    wsh.JWT.HasPermission(PermissionSendPower) && !suspended,
+		ViewConsole:  !quiet,
+		ViewStats:    !quiet,
+		ViewInstall:  !quiet && wsh.JWT.HasPermission(PermissionReceiveInstall),
+		ViewErrors:   wsh.JWT.HasPermission(PermissionReceiveErrors),
+		FileAccess:   wsh.JWT.HasPermission(PermissionFileAccess),
+		Permissions:  wsh.JWT.Permissions,
+		PowerActions: actions,
+	}
+}
+
+// Sends the capabilities of the connection to the client using the given event.
+func (wsh *WebsocketHandler) sendCapabilities(event string, c WebsocketCapabilities) error {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	return wsh.unsafeSendJson(WebsocketMessage{
+		Event: event,
+		Args:  []string{string(b)},
+	})
+}
+
+// Determines if two sets of capabilities grant the same actions.
+func (c WebsocketCapabilities) equal(o WebsocketCapabilities) bool {
+	if c.SendCommand != o.SendCommand || c.SendPower != o.SendPower || c.ViewConsole != o.ViewConsole ||
+		c.ViewStats != o.ViewStats || c.ViewInstall != o.ViewInstall || c.ViewErrors != o.ViewErrors ||
+		c.FileAccess != o.FileAccess || len(c.Permissions) != len(o.Permissions) || len(c.PowerActions) != len(o.PowerActions) {
+		return false
+	}
+
+	for i := range c.Permissions {
+		if c.Permissions[i] != o.Permissions[i] {
+			return false
+		}
+	}
+
+	for i := range c.PowerActions {
+		if c.PowerActions[i] != o.PowerActions[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/ansi"
+)
+
+// The formats console output can be sent to a websocket connection in. Raw output keeps
+// the ANSI color codes written by the server, stripped output removes them, and structured
+// output converts them into a JSON array of styled text segments.
+const (
+	WebsocketConsoleRaw        = "raw"
+	WebsocketConsoleStripped   = "stripped"
+	WebsocketConsoleStructured = "structured"
+)
+
+// Sets the format console output is sent to the connection in. An empty format uses the
+// raw output.
+func (wsh *WebsocketHandler) setConsoleFormat(format string) error {
+	if format == "" {
+		format = WebsocketConsoleRaw
+	}
+
+	switch format {
+	case WebsocketConsoleRaw, WebsocketConsoleStripped, WebsocketConsoleStructured:
+	default:
+		return errors.Errorf("unknown console format \"%s\"", format)
+	}
+
+	wsh.Mutex.Lock()
+	wsh.consoleFormat = format
+	wsh.Mutex.Unlock()
+
+	return nil
+}
+
+// Converts a line of console output into the format requested by the connection.
+func (wsh *WebsocketHandler) formatConsole(line string) string {
+	wsh.Mutex.Lock()
+	format := wsh.consoleFormat
+	wsh.Mutex.Unlock()
+
+	return formatConsoleLine(format, line)
+}
+
+// Converts a line of console output into the given format.
+func formatConsoleLine(format string, line string) string {
+	switch format {
+	case WebsocketConsoleStripped:
+		return ansi.Strip(line)
+	case WebsocketConsoleStructured:
+		b, _ := json.Marshal(ansi.Parse(line))
+
+		return string(b)
+	}
+
+	return line
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/server"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The subprotocols a client can request when connecting to the websocket. Clients that do
+// not request one are sent JSON text frames, which is the same as requesting the JSON
+// subprotocol.
+const (
+	WebsocketProtocolJson    = "wings.json"
+	WebsocketProtocolMsgpack = "wings.msgpack"
+)
+
+// Returns the subprotocols offered to clients during the websocket handshake, in order of
+// preference.
+func websocketSubprotocols(cfg config.WebsocketConfiguration) []string {
+	if !cfg.BinaryStats {
+		return []string{WebsocketProtocolJson}
+	}
+
+	return []string{WebsocketProtocolMsgpack, WebsocketProtocolJson}
+}
+
+// Applies the encoding negotiated during the handshake to the connection.
+func (wsh *WebsocketHandler) negotiateEncoding(cfg config.WebsocketConfiguration) {
+	wsh.binaryStats = wsh.Connection.Subprotocol() == WebsocketProtocolMsgpack
+
+	// The compression level is only used if compression was negotiated with the client,
+	// and an invalid level leaves the default in place.
+	wsh.Connection.SetCompressionLevel(cfg.CompressionLevel)
+}
+
+// Writes a message to the connection using the encoding negotiated for it. Stats events
+// are sent as binary frames to clients using the MessagePack subprotocol, since they are
+// sent every second for as long as the server is running. Everything else is sent as JSON.
+func (wsh *WebsocketHandler) writeMessage(v interface{}) error {
+	m, ok := v.(*WebsocketMessage)
+	if !ok || !wsh.binaryStats || m.Event != server.StatsEvent {
+		return wsh.Connection.WriteJSON(v)
+	}
+
+	b, err := encodeMsgpackMessage(m)
+	if err != nil {
+		return err
+	}
+
+	return wsh.Connection.WriteMessage(websocket.BinaryMessage, b)
+}
+
+// Encodes a websocket message as MessagePack. Arguments that contain JSON, such as the
+// stats of a server, are decoded and encoded as structured values so that the client does
+// not need to parse them a second time.
+func encodeMsgpackMessage(m *WebsocketMessage) ([]byte, error) {
+	args := make([]interface{}, len(m.Args))
+	for i, a := range m.Args {
+		args[i] = a
+
+		trimmed := strings.TrimSpace(a)
+		if !strings.HasPrefix(trimmed, "{") && !strings.HasPrefix(trimmed, "[") {
+			continue
+		}
+
+		d := json.NewDecoder(strings.NewReader(trimmed))
+		d.UseNumber()
+
+		var v interface{}
+		if err := d.Decode(&v); err == nil {
+			args[i] = v
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := writeMsgpack(&buf, map[string]interface{}{"event": m.Event, "args": args}); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Writes a value decoded from JSON to the buffer using the MessagePack format. Only the
+// types produced by decoding JSON with numbers preserved are supported.
+func writeMsgpack(buf *bytes.Buffer, v interface{}) error {
+	switch t := v.(type) {
+	case nil:
+		buf.WriteByte(0xc0)
+	case bool:
+		if t {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case json.Number:
+		if i, err := t.Int64(); err == nil {
+			writeMsgpackInt(buf, i)
+		} else if f, err := t.Float64(); err == nil {
+			buf.WriteByte(0xcb)
+			binary.Write(buf, binary.BigEndian, math.Float64bits(f))
+		} else {
+			return errors.WithStack(err)
+		}
+	case string:
+		writeMsgpackHeader(buf, len(t), 0xa0, 32, 0xd9, 0xda, 0xdb)
+		buf.WriteString(t)
+	case []interface{}:
+		writeMsgpackHeader(buf, len(t), 0x90, 16, 0, 0xdc, 0xdd)
+		for _, e := range t {
+			if err := writeMsgpack(buf, e); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		// Keys are written in a consistent order so that identical stats always produce
+		// identical frames.
+		keys := make([]string, 0, len(t))
+		for k := range t {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		writeMsgpackHeader(buf, len(keys), 0x80, 16, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			writeMsgpack(buf, k)
+			if err := writeMsgpack(buf, t[k]); err != nil {
+				return err
+			}
+		}
+	default:
+		return errors.Errorf("cannot encode %T as MessagePack", v)
+	}
+
+	return nil
+}
+
+// Writes the smallest MessagePack integer representation of the value.
+func writeMsgpackInt(buf *bytes.Buffer, i int64) {
+	switch {
+	case i >= 0 && i < 128:
+		buf.WriteByte(byte(i))
+	case i < 0 && i >= -32:
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(i))
+	case i >= 0 && i <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(i))
+	case i >= 0 && i <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(i))
+	case i >= 0:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, uint64(i))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, i)
+	}
+}
+
+// Writes the header for a string, array or map of the given length. The fixed format is
+// used for lengths below the limit, and the 8 bit format is skipped when it is zero since
+// only strings have one.
+func writeMsgpackHeader(buf *bytes.Buffer, n int, fixed byte, limit int, b8 byte, b16 byte, b32 byte) {
+	switch {
+	case n < limit:
+		buf.WriteByte(fixed | byte(n))
+	case b8 != 0 && n <= math.MaxUint8:
+		buf.WriteByte(b8)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
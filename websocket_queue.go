@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"github.com/pterodactyl/wings/server"
+	"github.com/pterodactyl/wings/supervisor"
+	"sync"
+	"time"
+)
+
+// The number of events that can be waiting to be written to a single connection. Once a
+// connection falls this far behind any further events are dropped until it catches up.
+const websocketSendQueueSize = 512
+
+// The time a client has to accept a message written to it before the connection is
+// closed, so that a client that has stopped reading does not hold resources forever.
+const websocketWriteTimeout = time.Second * 10
+
+// The events waiting to be written to a single connection.
+type sendQueue struct {
+	messages chan *WebsocketMessage
+	done     chan struct{}
+	once     sync.Once
+
+	mu      sync.Mutex
+	dropped int
+}
+
+func newSendQueue() sendQueue {
+	return sendQueue{
+		messages: make(chan *WebsocketMessage, websocketSendQueueSize),
+		done:     make(chan struct{}),
+	}
+}
+
+// Adds a message to the queue without blocking. If the queue is full the message is
+// dropped and counted, so that the client can be told that it missed some output.
+func (q *sendQueue) push(m *WebsocketMessage) {
+	select {
+	case <-q.done:
+	case q.messages <- m:
+	default:
+		q.mu.Lock()
+		q.dropped++
+		q.mu.Unlock()
+	}
+}
+
+// Returns the number of messages dropped since the last call, resetting the count.
+func (q *sendQueue) takeDropped() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := q.dropped
+	q.dropped = 0
+
+	return n
+}
+
+// Stops the queue from accepting or writing any more messages.
+func (q *sendQueue) close() {
+	q.once.Do(func() {
+		close(q.done)
+	})
+}
+
+// Writes queued messages to the connection until the queue is closed. If any messages
+// were dropped because the client fell behind the client is told how many it missed
+// before the next message is written.
+func (wsh *WebsocketHandler) drainQueue() {
+	defer supervisor.Recover("websocket")
+
+	for {
+		select {
+		case <-wsh.queue.done:
+			return
+		case m := <-wsh.queue.messages:
+			if n := wsh.queue.takeDropped(); n > 0 {
+				wsh.SendJson(&WebsocketMessage{
+					Event: server.DaemonMessageEvent,
+					Args:  []string{fmt.Sprintf("%d events were not sent because the connection could not keep up.", n)},
+				})
+			}
+
+			if err := wsh.SendJson(m); err != nil {
+				wsh.queue.close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"github.com/julienschmidt/httprouter"
+	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/metrics"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A token bucket limiting the rate at which connections can be opened.
+type connectionBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Takes a token from the bucket, refilling it at the given rate per second up to the
+// burst size. If the bucket is empty the time until the next token is available is
+// returned instead.
+func (b *connectionBucket) take(now time.Time, rate float64, burst float64) (bool, time.Duration) {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else {
+		b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	}
+
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	}
+
+	b.tokens--
+
+	return true, 0
+}
+
+// The buckets for every IP address and server that has recently opened a connection.
+var connectionThrottle = struct {
+	sync.Mutex
+	ips     map[string]*connectionBucket
+	servers map[string]*connectionBucket
+	swept   time.Time
+	rand    *rand.Rand
+}{
+	ips:     make(map[string]*connectionBucket),
+	servers: make(map[string]*connectionBucket),
+	rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+}
+
+// Middleware limiting how quickly websockets and event streams can be opened from a
+// single IP address. When the Panel comes back after an outage every client reconnects at
+// once, and setting up those connections uses CPU that the servers on the node need.
+// Refused clients are told when to retry, with a random delay added so that they do not
+// all retry at the same moment. The limit for the server is only applied once the client
+// has authenticated, see allowServerConnection.
+func (rt *Router) ThrottleConnections(h httprouter.Handle) httprouter.Handle {
+	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+		cfg := config.Get().Api.Websocket
+
+		ok, wait := allowConnection(cfg, "ip", clientIp(r, cfg.TrustedProxies))
+		if ok {
+			h(w, r, ps)
+			return
+		}
+
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		http.Error(w, "too many connection attempts, try again later", http.StatusTooManyRequests)
+	}
+}
+
+// Takes a token for a connection to the server from its bucket. This is only done once the
+// client has authenticated, so that clients without access to the server cannot use up
+// the connections allowed for it. If the bucket is empty the time the client should wait
+// before retrying is returned.
+func allowServerConnection(server string) (bool, time.Duration) {
+	return allowConnection(config.Get().Api.Websocket, "server", server)
+}
+
+// Returns the IP address of the client that made the request. If the request was made by
+// a trusted proxy the last address in the X-Forwarded-For header that is not a trusted
+// proxy is used, since every earlier address could have been set by the client itself.
+func clientIp(r *http.Request, trusted []string) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	if len(trusted) == 0 || !ipAllowed(net.ParseIP(ip), trusted) {
+		return ip
+	}
+
+	var forwarded []string
+	for _, h := range r.Header["X-Forwarded-For"] {
+		forwarded = append(forwarded, strings.Split(h, ",")...)
+	}
+
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if addr == nil {
+			break
+		}
+
+		ip = addr.String()
+		if !ipAllowed(addr, trusted) {
+			break
+		}
+	}
+
+	return ip
+}
+
+// Takes a token for the connection from the bucket for the key in the scope, which is
+// either "ip" or "server". If the bucket is empty the time the client should wait before
+// retrying is returned, including the jitter.
+func allowConnection(cfg config.WebsocketConfiguration, scope string, key string) (bool, time.Duration) {
+	connectionThrottle.Lock()
+	defer connectionThrottle.Unlock()
+
+	now := time.Now()
+
+	// Buckets that have not been used for a while will have refilled completely, which is
+	// the same as a new bucket, so they are removed to stop the maps growing with every
+	// address that has ever connected.
+	if now.Sub(connectionThrottle.swept) > time.Minute {
+		for _, m := range []map[string]*connectionBucket{connectionThrottle.ips, connectionThrottle.servers} {
+			for k, b := range m {
+				if now.Sub(b.last) > time.Minute*10 {
+					delete(m, k)
+				}
+			}
+		}
+
+		connectionThrottle.swept = now
+	}
+
+	buckets, rate, burst := connectionThrottle.ips, cfg.IpConnectionsPerMinute, cfg.IpConnectionBurst
+	if scope == "server" {
+		buckets, rate, burst = connectionThrottle.servers, cfg.ServerConnectionsPerMinute, cfg.ServerConnectionBurst
+	}
+
+	if rate <= 0 {
+		return true, 0
+	}
+
+	b, ok := buckets[key]
+	if !ok {
+		b = &connectionBucket{}
+		buckets[key] = b
+	}
+
+	allowed, wait := b.take(now, float64(rate)/60, math.Max(float64(burst), 1))
+	if allowed {
+		return true, 0
+	}
+
+	metrics.WebsocketThrottled.Inc(scope)
+
+	if cfg.RetryJitter > 0 {
+		wait += time.Duration(connectionThrottle.rand.Int63n(int64(cfg.RetryJitter) * int64(time.Second)))
+	}
+
+	return false, wait
+}
@@ -1,18 +1,30 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
 	"fmt"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"github.com/pterodactyl/wings/audit"
 	"github.com/pterodactyl/wings/config"
+	"github.com/pterodactyl/wings/crash"
+	"github.com/pterodactyl/wings/daemonlog"
+	"github.com/pterodactyl/wings/hoststat"
 	"github.com/pterodactyl/wings/server"
 	"github.com/pterodactyl/wings/sftp"
+	"github.com/pterodactyl/wings/systemd"
+	"github.com/pterodactyl/wings/tempdir"
 	"github.com/remeh/sizedwaitgroup"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"net"
 	"net/http"
 	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 var configPath = "config.yml"
@@ -26,6 +38,20 @@ func main() {
 
 	flag.Parse()
 
+	crash.SetVersion(Version)
+	defer crash.Recover()
+
+	// Migrating from the legacy daemon may create the configuration file, so this is
+	// handled before the configuration is read.
+	if flag.Arg(0) == "migrate" {
+		if err := runMigrateCommand(configPath, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	c, err := config.ReadConfiguration(configPath)
 	if err != nil {
 		panic(err)
@@ -36,8 +62,37 @@ func main() {
 		c.Debug = true
 	}
 
+	// Commands that interact with an already running daemon rather than booting a
+	// new instance of it.
+	if flag.Arg(0) == "drain" {
+		if err := runDrainCommand(c, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if flag.Arg(0) == "lint" {
+		if err := runLintCommand(c, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if flag.Arg(0) == "logs" {
+		if err := runLogsCommand(c, flag.Args()[1:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	}
+
 	printLogo()
-	if err := configureLogging(c.Debug); err != nil {
+	if err := configureLogging(c.Debug, c.System.Log); err != nil {
 		panic(err)
 	}
 
@@ -51,6 +106,7 @@ func main() {
 		}
 	}
 
+	c.ConfigureRootless()
 	config.Set(c)
 	config.SetDebugViaFlag(debug)
 
@@ -69,6 +125,12 @@ func main() {
 		zap.S().Infow("finished ensuring file permissions")
 	}
 
+	if err := tempdir.Configure(); err != nil {
+		zap.S().Errorw("failed to configure temporary directory", zap.Error(err))
+	}
+
+	server.RemoveStaleSnapshots(&c.System)
+
 	if err := server.LoadDirectory("data/servers", &c.System); err != nil {
 		zap.S().Fatalw("failed to load server configurations", zap.Error(errors.WithStack(err)))
 		return
@@ -93,11 +155,18 @@ func main() {
 	// and reboot processes without causing a slow-down due to sequential booting.
 	wg := sizedwaitgroup.New(4)
 
+	// Booting a large number of servers can take longer than systemd is willing to wait
+	// for the daemon to start, so keep extending the timeout while progress is being made.
+	var booted int32
+	done := make(chan struct{})
+	go reportBootProgress(len(server.GetServers().All()), &booted, done)
+
 	for _, serv := range server.GetServers().All() {
 		wg.Add()
 
 		go func(s *server.Server) {
 			defer wg.Done()
+			defer atomic.AddInt32(&booted, 1)
 
 			// Create a server environment if none exists currently. This allows us to recover from Docker
 			// being reinstalled on the host system for example.
@@ -117,8 +186,16 @@ func main() {
 			//
 			// This will also validate that a server process is running if the last tracked state we have
 			// is that it was running, but we see that the container process is not currently running.
-			if r || (!r && (s.State == server.ProcessRunningState || s.State == server.ProcessStartingState)) {
+			if r || (!r && (server.IsRunningState(s.State) || s.State == server.ProcessStartingState)) {
 				zap.S().Infow("detected server is running, re-attaching to process", zap.String("server", s.Uuid))
+
+				// The process was expected to be running but its container is stopped, so it
+				// stopped while the daemon was not running, most likely because the node was
+				// shut down.
+				if !r {
+					s.RecordStopReason(server.StopReasonNodeShutdown, "the server stopped while the daemon was not running")
+				}
+
 				if err := s.Environment.Start(); err != nil {
 					zap.S().Warnw(
 						"failed to properly start server detected as already running",
@@ -138,10 +215,61 @@ func main() {
 
 	// Wait until all of the servers are ready to go before we fire up the HTTP server.
 	wg.Wait()
+	close(done)
+
+	// The sockets passed by systemd when using socket activation, which can only be read
+	// once since the environment describing them is removed.
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		zap.S().Fatalw("failed to use sockets passed by systemd", zap.Error(err))
+	}
+
+	sftpListener, ok := listeners["sftp"]
+	delete(listeners, "sftp")
 
 	// If the SFTP subsystem should be started, do so now.
 	if c.System.Sftp.UseInternalSystem {
-		sftp.Initialize(c)
+		// Exit rather than continuing without it, since systemd would otherwise be told the
+		// daemon is ready while users are unable to connect over SFTP. A socket passed by
+		// systemd is already accepting connections, so there is nothing to wait for.
+		if err := sftp.Initialize(c, sftpListener); err != nil {
+			zap.S().Fatalw("failed to initialize SFTP subsystem", zap.Error(errors.WithStack(err)))
+		} else if !ok {
+			if err := sftp.WaitUntilListening(c, time.Second*30); err != nil {
+				zap.S().Fatalw("SFTP subsystem is not accepting connections", zap.Error(err))
+			}
+		}
+	} else if ok {
+		zap.S().Warnw("ignoring sftp socket passed by systemd, the internal SFTP server is disabled")
+		sftpListener.Close()
+	}
+
+	server.StartProfileScheduler()
+	server.StartScheduler()
+	server.StartIntegrityMonitor()
+	server.StartOomMonitor()
+	server.StartTrashCleanup()
+	server.StartUploadCleanup()
+	hoststat.StartStealMonitor()
+	audit.StartCompactor()
+
+	if c.System.SharedCache.Enabled && c.System.SharedCache.RunRegistryMirror {
+		if err := server.EnsureRegistryMirror(&c.System.SharedCache); err != nil {
+			zap.S().Errorw("failed to start registry mirror for shared cache", zap.Error(err))
+		}
+	}
+
+	if c.Docker.ImageGc.Enabled {
+		server.StartImageGarbageCollector(&c.Docker.ImageGc)
+	}
+
+	server.StartContainerReconciliation(&c.Docker.Reconciliation)
+	server.StartEggSync(&c.System.EggSync)
+
+	if c.System.Flows.Enabled {
+		if err := startFlowCollector(&c.System.Flows); err != nil {
+			zap.S().Errorw("failed to start network flow collector", zap.Error(err))
+		}
 	}
 
 	r := &Router{
@@ -152,27 +280,135 @@ func main() {
 			CheckOrigin: func(r *http.Request) bool {
 				return r.Header.Get("Origin") == c.PanelLocation
 			},
+			EnableCompression: c.Api.Websocket.Compression,
+			Subprotocols:      websocketSubprotocols(c.Api.Websocket),
 		},
 	}
 
-	router := r.ConfigureRouter()
+	router := r.InstrumentHandler(r.ConfigureRouter())
 	zap.S().Infow("configuring webserver", zap.Bool("ssl", c.Api.Ssl.Enabled), zap.String("host", c.Api.Host), zap.Int("port", c.Api.Port))
 
-	addr := fmt.Sprintf("%s:%d", c.Api.Host, c.Api.Port)
+	listener, err := apiListener(c, listeners)
+	if err != nil {
+		zap.S().Fatalw("failed to bind webserver to address", zap.Error(err))
+	}
+
+	// At this point the API is bound, the SFTP server is listening, and the Docker
+	// environment was configured successfully, so let systemd know that we're ready.
+	if ok, err := systemd.Notify(systemd.Ready); err != nil {
+		zap.S().Warnw("failed to notify systemd of daemon readiness", zap.Error(err))
+	} else if ok {
+		zap.S().Infow("notified systemd that the daemon is ready")
+	}
+
+	systemd.StartWatchdog(watchdogCheck(listener, c.Api.Ssl.Enabled))
+
 	if c.Api.Ssl.Enabled {
-		if err := http.ListenAndServeTLS(addr, c.Api.Ssl.CertificateFile, c.Api.Ssl.KeyFile, router); err != nil {
+		if err := http.ServeTLS(listener, router, c.Api.Ssl.CertificateFile, c.Api.Ssl.KeyFile); err != nil {
 			zap.S().Fatalw("failed to configure HTTPS server", zap.Error(err))
 		}
 	} else {
-		if err := http.ListenAndServe(addr, router); err != nil {
+		if err := http.Serve(listener, router); err != nil {
 			zap.S().Fatalw("failed to configure HTTP server", zap.Error(err))
 		}
 	}
 }
 
+// Returns the listener for the API. If the daemon was started by systemd using socket
+// activation the socket named "api" is used, or the only other socket if there is just
+// one, otherwise the configured address is bound.
+//
+// With socket activation systemd holds the socket open while the daemon boots, so the
+// Panel's requests wait for the daemon rather than failing and marking the node as down.
+func apiListener(c *config.Configuration, listeners map[string]net.Listener) (net.Listener, error) {
+	l, ok := listeners["api"]
+	if !ok && len(listeners) == 1 {
+		for _, v := range listeners {
+			l, ok = v, true
+		}
+	}
+
+	if ok {
+		zap.S().Infow("using api socket passed by systemd", zap.String("address", l.Addr().String()))
+		return l, nil
+	}
+
+	addr := net.JoinHostPort(config.TrimAddressBrackets(c.Api.Host), strconv.Itoa(c.Api.Port))
+	l, err := net.Listen("tcp", addr)
+
+	return l, errors.Wrapf(err, "failed to listen on %s", addr)
+}
+
+// Reports the progress of booting the servers on the node to systemd until the done
+// channel is closed, extending the startup timeout each time.
+func reportBootProgress(total int, booted *int32, done chan struct{}) {
+	ticker := time.NewTicker(time.Second * 10)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			systemd.Status("servers booted, starting api")
+			return
+		case <-ticker.C:
+			systemd.ExtendTimeout(time.Second * 30)
+			systemd.Status(fmt.Sprintf("booting servers (%d of %d)", atomic.LoadInt32(booted), total))
+		}
+	}
+}
+
+// Returns the check run before each systemd watchdog notification. The check makes a
+// request to the API through the listener the daemon is serving on, so that the watchdog
+// stops being notified if the webserver hangs, and checks that Docker can be reached.
+func watchdogCheck(l net.Listener, ssl bool) func() error {
+	network, address := l.Addr().Network(), l.Addr().String()
+	if network == "tcp" {
+		if host, port, err := net.SplitHostPort(address); err == nil {
+			address = net.JoinHostPort(config.LocalDialAddress(host), port)
+		}
+	}
+
+	scheme := "http"
+	if ssl {
+		scheme = "https"
+	}
+
+	// The certificate is for the public hostname of the node rather than the local address
+	// the request is made to, so it is not verified.
+	client := &http.Client{
+		Timeout: healthCheckTimeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, network, address)
+			},
+			TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+			DisableKeepAlives: true,
+		},
+	}
+
+	return func() error {
+		res, err := client.Get(scheme + "://localhost/")
+		if err != nil {
+			return errors.Wrap(err, "api did not respond")
+		}
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK {
+			return errors.Errorf("api responded with %s", res.Status)
+		}
+
+		if h := checkDockerHealth(); h.Status == HealthUnhealthy {
+			return errors.New(h.Message)
+		}
+
+		return nil
+	}
+}
+
 // Configures the global logger for Zap so that we can call it from any location
-// in the code without having to pass around a logger instance.
-func configureLogging(debug bool) error {
+// in the code without having to pass around a logger instance. Entries are written
+// to stdout, and to the rotating daemon log if a log directory is configured.
+func configureLogging(debug bool, lc config.LogConfiguration) error {
 	cfg := zap.NewProductionConfig()
 	if debug {
 		cfg = zap.NewDevelopmentConfig()
@@ -183,11 +419,29 @@ func configureLogging(debug bool) error {
 		"stdout",
 	}
 
-	logger, err := cfg.Build()
+	logger, err := cfg.Build(crash.LoggerHook())
 	if err != nil {
 		return err
 	}
 
+	if lc.Directory != "" {
+		f, err := daemonlog.Open(lc)
+		if err != nil {
+			return err
+		}
+
+		fc := zapcore.NewCore(zapcore.NewJSONEncoder(daemonlog.EncoderConfig()), f, cfg.Level)
+		logger = logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+			return zapcore.NewTee(c, fc)
+		}))
+	}
+
+	// Entries for servers with a running debug capture are recorded regardless of the
+	// level configured above.
+	logger = logger.WithOptions(zap.WrapCore(func(c zapcore.Core) zapcore.Core {
+		return zapcore.NewTee(c, server.DebugLogCore())
+	}))
+
 	zap.ReplaceGlobals(logger)
 
 	return nil